@@ -0,0 +1,90 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/maarulav/k8s-setup/internal/status"
+	"github.com/maarulav/k8s-setup/pkg/config"
+	"github.com/maarulav/k8s-setup/pkg/kubernetes"
+)
+
+// runAdopt registers an existing cluster in the status store so day-2
+// operations can run against it without re-running the bootstrap steps
+func runAdopt(args []string) error {
+	flags := flag.NewFlagSet("adopt", flag.ExitOnError)
+	kubeconfig := flags.String("kubeconfig", "", "admin kubeconfig to install on the control plane instead of /etc/kubernetes/admin.conf")
+	flags.Parse(args)
+
+	if flags.NArg() != 2 {
+		return fmt.Errorf("usage: adopt [--kubeconfig file] <config.json> <ip>")
+	}
+	_, client, err := connect(flags.Arg(0), flags.Arg(1))
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	ip := client.IP
+
+	var data []byte
+	if *kubeconfig != "" {
+		data, err = ioutil.ReadFile(*kubeconfig)
+		if err != nil {
+			return fmt.Errorf("failed to read kubeconfig: %v", err)
+		}
+		if _, err := client.ExecuteCommand("mkdir -p $HOME/.kube"); err != nil {
+			return fmt.Errorf("failed to create kubeconfig directory: %v", err)
+		}
+		if err := client.WriteFile("$HOME/.kube/config", data, 0600); err != nil {
+			return err
+		}
+	} else {
+		data, err = kubernetes.FetchKubeconfig(client)
+		if err != nil {
+			return fmt.Errorf("%v, pass --kubeconfig", err)
+		}
+		if _, err := client.ExecuteCommand("mkdir -p $HOME/.kube && cp -n /etc/kubernetes/admin.conf $HOME/.kube/config"); err != nil {
+			return fmt.Errorf("failed to install kubeconfig: %v", err)
+		}
+	}
+
+	nodes, err := client.ExecuteCommand("kubectl get nodes")
+	if err != nil {
+		return fmt.Errorf("cluster is not reachable with kubectl: %v\nOutput: %s", err, nodes)
+	}
+	fmt.Print(nodes)
+
+	detected := &config.Config{}
+	if err := kubernetes.Inspect(client, detected); err != nil {
+		return err
+	}
+	fmt.Printf("Detected Kubernetes %s, CNI %s, pod CIDR %s, service CIDR %s\n",
+		detected.Kubernetes.Version, detected.Kubernetes.CNI,
+		detected.Kubernetes.PodCIDR, detected.Kubernetes.ServiceCIDR)
+
+	if err := os.MkdirAll(status.Dir, 0755); err != nil {
+		return fmt.Errorf("failed to create status directory: %v", err)
+	}
+
+	path := status.KubeconfigPath(ip)
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		return fmt.Errorf("failed to save kubeconfig: %v", err)
+	}
+
+	s := status.New(ip)
+	s.Adopted = true
+	s.Kubeconfig = path
+	s.Status = "Adopted"
+	s.CurrentStep = "Adopted"
+	s.CompletedSteps = []string{"kubernetes"}
+	s.EndTime = time.Now()
+	if err := s.Save(); err != nil {
+		return err
+	}
+
+	fmt.Printf("Cluster %s adopted, kubeconfig saved to %s\n", ip, path)
+	return nil
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/maarulav/k8s-setup/pkg/addons"
+	"github.com/maarulav/k8s-setup/pkg/backup"
+	"github.com/maarulav/k8s-setup/pkg/config"
+	"github.com/maarulav/k8s-setup/pkg/helm"
+	"github.com/maarulav/k8s-setup/pkg/kubernetes"
+	"github.com/maarulav/k8s-setup/pkg/monitoring"
+	"github.com/maarulav/k8s-setup/pkg/security"
+	"github.com/maarulav/k8s-setup/pkg/ssh"
+)
+
+// runAllowlist dispatches the allowlist subcommands
+func runAllowlist(args []string) error {
+	if len(args) > 0 {
+		switch args[0] {
+		case "checksum":
+			return allowlistChecksum(args[1:])
+		case "record":
+			return allowlistRecord(args[1:])
+		}
+	}
+	return fmt.Errorf("unknown allowlist command, expected: allowlist checksum|record")
+}
+
+// allowlistChecksum prints the checksum of a reviewed allowlist, the value
+// ssh.allowlist.sha256 pins
+func allowlistChecksum(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: allowlist checksum <allowlist.json>")
+	}
+
+	data, err := ioutil.ReadFile(args[0])
+	if err != nil {
+		return fmt.Errorf("failed to read allowlist: %v", err)
+	}
+	fmt.Println(ssh.Checksum(data))
+	return nil
+}
+
+// allowlistRecord records the allowlist of a provisioning run of the given
+// hosts in a dry run: the steps run against a recorder instead of the hosts,
+// so nothing is touched. Steps that need answers from a live host or cluster
+// stop early; what they would run after is left to a rehearsal with
+// ssh.allowlist.record.
+func allowlistRecord(args []string) error {
+	flags := flag.NewFlagSet("allowlist record", flag.ExitOnError)
+	output := flags.String("output", "", "allowlist to add to (default ssh.allowlist.file, or allowlist.json)")
+	flags.Parse(args)
+
+	if flags.NArg() < 2 {
+		return fmt.Errorf("usage: allowlist record [--output file] <config.json> <ip|hostname|cidr> ...")
+	}
+	cfg, err := config.LoadConfig(flags.Arg(0))
+	if err != nil {
+		return fmt.Errorf("failed to load configuration: %v", err)
+	}
+	// Probing the hosts for SSH would touch them
+	ips, err := expandTargets(cfg, flags.Args()[1:])
+	if err != nil {
+		return err
+	}
+
+	file := *output
+	if file == "" {
+		file = cfg.SSHConfig.Allowlist.File
+	}
+	if file == "" {
+		file = "allowlist.json"
+	}
+	a, err := ssh.ReadAllowlist(file)
+	if err != nil {
+		return err
+	}
+
+	for _, ip := range ips {
+		r := ssh.NewRecorder(ip, vmConfig(cfg, ip).Shell, a)
+		for _, step := range dryRunSteps(cfg, r, ips) {
+			if err := step.run(); err != nil {
+				fmt.Printf("Warning: %s: step %s needs a live host after this point, record the rest with ssh.allowlist.record: %v\n", ip, step.name, err)
+			}
+		}
+	}
+
+	if err := a.Save(file); err != nil {
+		return fmt.Errorf("failed to write allowlist: %v", err)
+	}
+	fmt.Printf("Recorded %d commands, %d writes and %d reads to %s, review it and pin its checksum\n", len(a.Commands), len(a.Writes), len(a.Reads), file)
+	return nil
+}
+
+// dryRunStep is a step of a provisioning run replayed against a recorder
+type dryRunStep struct {
+	name string
+	run  func() error
+}
+
+// dryRunSteps returns the steps a provisioning run of the host behind r
+// goes through, as main runs them
+func dryRunSteps(cfg *config.Config, r *ssh.Recorder, ips []string) []dryRunStep {
+	ip := r.Host()
+	steps := []dryRunStep{
+		{"system requirements", r.CheckSystemRequirements},
+		{"facts", func() error {
+			if _, err := kubernetes.DetectArch(r); err != nil {
+				return err
+			}
+			_, err := kubernetes.CollectFacts(r)
+			return err
+		}},
+		{"kubernetes", func() error { return kubernetes.Setup(r, cfg, nil) }},
+	}
+	if roles, err := cfg.HostRoles(ip); err == nil && len(roles) > 0 {
+		steps = append(steps, dryRunStep{"roles", func() error {
+			if err := kubernetes.SetupRoles(r, r, cfg, roles); err != nil {
+				return err
+			}
+			return kubernetes.TaintRoles(r, r, cfg, roles)
+		}})
+	}
+	if len(cfg.Manifests) > 0 {
+		steps = append(steps, dryRunStep{"manifests", func() error { return kubernetes.ApplyManifests(r, cfg) }})
+	}
+	if len(cfg.Namespaces) > 0 {
+		steps = append(steps, dryRunStep{"namespaces", func() error { return kubernetes.SetupNamespaces(r, cfg) }})
+	}
+	if len(cfg.Registries) > 0 {
+		steps = append(steps, dryRunStep{"registries", func() error {
+			return fmt.Errorf("the registry credentials are distributed over the SSH connections of the nodes")
+		}})
+	}
+	steps = append(steps, dryRunStep{"monitoring", func() error { return monitoring.Setup(r, cfg, ips) }})
+	if len(addons.Enabled(cfg)) > 0 {
+		steps = append(steps, dryRunStep{"addons", func() error { return addons.Setup(r, cfg) }})
+	}
+	if len(cfg.Charts) > 0 {
+		steps = append(steps, dryRunStep{"charts", func() error { return helm.InstallCharts(r, cfg.Charts, cfg.HostVars(ip)) }})
+	}
+	if cfg.Backup.Volumes.Enabled {
+		steps = append(steps, dryRunStep{"velero", func() error { return backup.SetupVolumes(r, cfg) }})
+	}
+	if cfg.Security.Enabled() {
+		steps = append(steps, dryRunStep{"security", func() error {
+			_, err := security.Scan(r, cfg)
+			return err
+		}})
+	}
+	return append(steps, dryRunStep{"backup", func() error { return backup.Create(r, cfg) }})
+}
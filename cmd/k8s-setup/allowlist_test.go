@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/maarulav/k8s-setup/pkg/config"
+	"github.com/maarulav/k8s-setup/pkg/ssh"
+)
+
+func TestAllowlistRecord(t *testing.T) {
+	dir := t.TempDir()
+	cfg := &config.Config{}
+	cfg.Kubernetes.Version = "1.30.4-1.1"
+	cfg.Kubernetes.PodCIDR = "10.244.0.0/16"
+	cfg.Kubernetes.ServiceCIDR = "10.96.0.0/12"
+	// Nothing listens there, the dry run must not connect
+	cfg.Hosts = []config.Host{{IP: "10.0.0.1", Port: 1}}
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "config.json")
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(dir, "allowlist.json")
+
+	start := time.Now()
+	if err := allowlistRecord([]string{"--output", file, path, "10.0.0.1"}); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("dry run took %s", elapsed)
+	}
+
+	a, err := ssh.ReadAllowlist(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	commands := strings.Join(a.Commands, "\n")
+	for _, want := range []string{"command -v bash", "uname -a", "kubeadm init --config=/root/kubeadm-config.yaml"} {
+		if !strings.Contains(commands, want) {
+			t.Errorf("allowlist lacks %q:\n%s", want, commands)
+		}
+	}
+	if strings.Contains(commands, "10.0.0.1") {
+		t.Errorf("the host IP was not generalized:\n%s", commands)
+	}
+	if len(a.Writes) == 0 {
+		t.Error("no file writes recorded")
+	}
+
+	// Recording again adds nothing
+	before, _ := ioutil.ReadFile(file)
+	if err := allowlistRecord([]string{"--output", file, path, "10.0.0.1"}); err != nil {
+		t.Fatal(err)
+	}
+	if after, _ := ioutil.ReadFile(file); string(after) != string(before) {
+		t.Error("a second dry run changed the allowlist")
+	}
+}
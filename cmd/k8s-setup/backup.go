@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/maarulav/k8s-setup/pkg/backup"
+)
+
+// runBackup dispatches the backup subcommands
+func runBackup(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("unknown backup command, expected: backup list|show")
+	}
+	switch args[0] {
+	case "list":
+		return backupList(args[1:])
+	case "show":
+		return backupShow(args[1:])
+	default:
+		return fmt.Errorf("unknown backup command, expected: backup list|show")
+	}
+}
+
+// backupList prints the backups stored on the control plane and in Velero
+func backupList(args []string) error {
+	flags := flag.NewFlagSet("backup list", flag.ExitOnError)
+	asJSON := flags.Bool("json", false, "print as JSON")
+	flags.Parse(args)
+
+	if flags.NArg() != 2 {
+		return fmt.Errorf("usage: backup list [--json] <config.json> <ip>")
+	}
+
+	cfg, client, err := connect(flags.Arg(0), flags.Arg(1))
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	backups, err := backup.List(client, cfg)
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		return printJSON(backups)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tKIND\tTIME\tSIZE\tKUBERNETES\tLOCATION")
+	for _, b := range backups {
+		size := "-"
+		if b.Size > 0 {
+			size = formatSize(b.Size)
+		}
+		version := b.KubernetesVersion
+		if b.Kind == backup.KindVolumes {
+			version = b.Phase
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", b.ID, b.Kind, b.Time.Local().Format(time.RFC3339), size, version, b.Location)
+	}
+	return w.Flush()
+}
+
+// backupShow prints the contents summary of one backup
+func backupShow(args []string) error {
+	flags := flag.NewFlagSet("backup show", flag.ExitOnError)
+	asJSON := flags.Bool("json", false, "print as JSON")
+	flags.Parse(args)
+
+	if flags.NArg() != 3 {
+		return fmt.Errorf("usage: backup show [--json] <config.json> <ip> <id>")
+	}
+
+	cfg, client, err := connect(flags.Arg(0), flags.Arg(1))
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	details, err := backup.Show(client, cfg, flags.Arg(2))
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		return printJSON(details)
+	}
+
+	fmt.Printf("ID:         %s\n", details.ID)
+	fmt.Printf("Kind:       %s\n", details.Kind)
+	fmt.Printf("Location:   %s\n", details.Location)
+	fmt.Printf("Time:       %s\n", details.Time.Local().Format(time.RFC3339))
+	if details.Size > 0 {
+		fmt.Printf("Size:       %s\n", formatSize(details.Size))
+	}
+	if details.KubernetesVersion != "" {
+		fmt.Printf("Kubernetes: %s\n", details.KubernetesVersion)
+	}
+	if details.EtcdSnapshot != "" {
+		fmt.Printf("Etcd:       %s\n", details.EtcdSnapshot)
+	}
+	if details.Kind == backup.KindVolumes {
+		fmt.Printf("Phase:      %s\n", details.Phase)
+		fmt.Printf("Namespaces: %v\n", details.Namespaces)
+		fmt.Printf("Items:      %d\n", details.Items)
+		return nil
+	}
+
+	namespaces := make([]string, 0, len(details.Objects))
+	for ns := range details.Objects {
+		namespaces = append(namespaces, ns)
+	}
+	sort.Strings(namespaces)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "\nNAMESPACE\tKIND\tOBJECTS")
+	for _, ns := range namespaces {
+		kinds := make([]string, 0, len(details.Objects[ns]))
+		for kind := range details.Objects[ns] {
+			kinds = append(kinds, kind)
+		}
+		sort.Strings(kinds)
+		for _, kind := range kinds {
+			fmt.Fprintf(w, "%s\t%s\t%d\n", ns, kind, details.Objects[ns][kind])
+		}
+	}
+	return w.Flush()
+}
+
+// formatSize renders a size in binary units
+func formatSize(bytes int64) string {
+	switch {
+	case bytes >= 1<<30:
+		return fmt.Sprintf("%.1fGi", float64(bytes)/(1<<30))
+	case bytes >= 1<<20:
+		return fmt.Sprintf("%.1fMi", float64(bytes)/(1<<20))
+	case bytes >= 1<<10:
+		return fmt.Sprintf("%.1fKi", float64(bytes)/(1<<10))
+	}
+	return fmt.Sprintf("%dB", bytes)
+}
+
+func printJSON(v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(data))
+	return nil
+}
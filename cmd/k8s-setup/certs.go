@@ -0,0 +1,135 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strings"
+
+	"github.com/maarulav/k8s-setup/internal/status"
+	"github.com/maarulav/k8s-setup/pkg/kubeconfig"
+	"github.com/maarulav/k8s-setup/pkg/kubernetes"
+	"github.com/maarulav/k8s-setup/pkg/ssh"
+)
+
+// runCerts dispatches the certs subcommands
+func runCerts(args []string) error {
+	if len(args) == 0 || args[0] != "add-san" {
+		return fmt.Errorf("unknown certs command, expected: certs add-san")
+	}
+	return certsAddSAN(args[1:])
+}
+
+// certsAddSAN adds names to the API server certificate of a running cluster,
+// those of kubernetes.certSANs and the given ones, on every control plane.
+// With --endpoint the cluster and its saved kubeconfigs are moved to the new
+// address.
+func certsAddSAN(args []string) error {
+	flags := flag.NewFlagSet("certs add-san", flag.ExitOnError)
+	endpoint := flags.String("endpoint", "", "new address of the API server, host or host:port, the cluster and the saved kubeconfigs are moved to")
+	target := flags.String("kubeconfig", kubeconfig.DefaultPath(), "kubeconfig the cluster was merged into")
+	forceUnlock := flags.Bool("force-unlock", false, "break the lock another run holds on the host")
+	flags.Parse(args)
+
+	if flags.NArg() < 2 {
+		return fmt.Errorf("usage: certs add-san [--endpoint host[:port]] [--kubeconfig file] [--force-unlock] <config.json> <control-plane-ip> [san...]")
+	}
+
+	cfg, client, err := connect(flags.Arg(0), flags.Arg(1))
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	sans := append(append([]string(nil), cfg.Kubernetes.CertSANs...), flags.Args()[2:]...)
+	var address string
+	if *endpoint != "" {
+		host, port, err := net.SplitHostPort(*endpoint)
+		if err != nil {
+			host, port = *endpoint, "6443"
+		}
+		sans = append(sans, host)
+		address = net.JoinHostPort(host, port)
+	}
+	if len(sans) == 0 {
+		return fmt.Errorf("no names to add, pass them as arguments or set kubernetes.certSANs")
+	}
+
+	// Every control plane serves its own certificate
+	addresses, err := kubernetes.OtherControlPlanes(client)
+	if err != nil {
+		return err
+	}
+	var others []kubernetes.ControlPlane
+	for _, ip := range addresses {
+		node, err := ssh.Connect(vmConfig(cfg, ip))
+		if err != nil {
+			return fmt.Errorf("SSH connection to control plane %s failed: %v", ip, err)
+		}
+		defer node.Close()
+		others = append(others, kubernetes.ControlPlane{Address: ip, Client: node})
+	}
+
+	unlock, err := lockHosts(cfg, append([]string{client.IP}, addresses...), "add-san", *forceUnlock)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	added, err := kubernetes.AddCertSANs(client, others, cfg, sans, address)
+	if err != nil {
+		return err
+	}
+	if len(added) == 0 {
+		fmt.Println("The API server certificate already covers every name")
+	} else {
+		fmt.Printf("API server certificate reissued with %s\n", strings.Join(added, ", "))
+	}
+
+	if address != "" {
+		return updateKubeconfigs(client.IP, "https://"+address, *target)
+	}
+	return nil
+}
+
+// updateKubeconfigs points the saved kubeconfig of the cluster at ip and its
+// context in the merged kubeconfig target at server
+func updateKubeconfigs(ip, server, target string) error {
+	s, err := status.Load(ip)
+	if err != nil {
+		fmt.Printf("Warning: no status for %s, no kubeconfig updated\n", ip)
+		return nil
+	}
+
+	if s.Kubeconfig != "" {
+		data, err := ioutil.ReadFile(s.Kubeconfig)
+		if err != nil {
+			return err
+		}
+		saved, err := kubeconfig.Parse(data)
+		if err != nil {
+			return err
+		}
+		if saved.SetServer(saved.CurrentContext, server) {
+			if err := saved.Save(s.Kubeconfig); err != nil {
+				return err
+			}
+			fmt.Printf("Updated %s to %s\n", s.Kubeconfig, server)
+		}
+	}
+
+	if s.Context != "" {
+		merged, err := kubeconfig.Load(target)
+		if err != nil {
+			return err
+		}
+		if merged.SetServer(s.Context, server) {
+			if err := merged.Save(target); err != nil {
+				return err
+			}
+			fmt.Printf("Updated context %s in %s to %s\n", s.Context, target, server)
+		}
+	}
+	return nil
+}
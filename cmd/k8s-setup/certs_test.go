@@ -0,0 +1,73 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/maarulav/k8s-setup/internal/status"
+	"github.com/maarulav/k8s-setup/pkg/kubeconfig"
+)
+
+// clusterKubeconfig returns a kubeconfig whose context name uses the cluster
+// of the same name at server
+func clusterKubeconfig(name, server string) *kubeconfig.Config {
+	return &kubeconfig.Config{
+		APIVersion:     "v1",
+		Kind:           "Config",
+		CurrentContext: name,
+		Clusters:       []kubeconfig.NamedCluster{{Name: name, Cluster: map[string]interface{}{"server": server}}},
+		Contexts:       []kubeconfig.NamedContext{{Name: name, Context: kubeconfig.Context{Cluster: name, User: name + "-admin"}}},
+		Users:          []kubeconfig.NamedUser{{Name: name + "-admin", User: map[string]interface{}{"token": "secret"}}},
+	}
+}
+
+func TestUpdateKubeconfigs(t *testing.T) {
+	t.Chdir(t.TempDir())
+	if err := os.MkdirAll(status.Dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	s := status.New("10.0.0.1")
+	s.Kubeconfig = s.KubeconfigPath()
+	s.Context = "prod"
+	if err := s.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if err := clusterKubeconfig("kubernetes-admin@kubernetes", "https://10.0.0.1:6443").Save(s.Kubeconfig); err != nil {
+		t.Fatal(err)
+	}
+	target := filepath.Join(t.TempDir(), "config")
+	merged := clusterKubeconfig("prod", "https://10.0.0.1:6443")
+	merged.Clusters = append(merged.Clusters, kubeconfig.NamedCluster{Name: "staging", Cluster: map[string]interface{}{"server": "https://10.0.1.1:6443"}})
+	merged.Contexts = append(merged.Contexts, kubeconfig.NamedContext{Name: "staging", Context: kubeconfig.Context{Cluster: "staging", User: "staging-admin"}})
+	if err := merged.Save(target); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := updateKubeconfigs("10.0.0.1", "https://k8s.example.com:6443", target); err != nil {
+		t.Fatal(err)
+	}
+
+	saved, err := kubeconfig.Load(s.Kubeconfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if server := saved.Server(saved.CurrentContext); server != "https://k8s.example.com:6443" {
+		t.Errorf("saved kubeconfig points at %s", server)
+	}
+	merged, err = kubeconfig.Load(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if server := merged.Server("prod"); server != "https://k8s.example.com:6443" {
+		t.Errorf("merged context points at %s", server)
+	}
+	if server := merged.Server("staging"); server != "https://10.0.1.1:6443" {
+		t.Errorf("other context moved to %s", server)
+	}
+
+	// A host without status has nothing to update
+	if err := updateKubeconfigs("10.0.0.9", "https://k8s.example.com:6443", target); err != nil {
+		t.Errorf("host without status: %v", err)
+	}
+}
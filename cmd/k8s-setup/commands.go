@@ -0,0 +1,121 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/maarulav/k8s-setup/pkg/config"
+	"github.com/maarulav/k8s-setup/pkg/ssh"
+)
+
+const usage = `Usage:
+  k8s-setup [--force] [--force-unlock] [--no-cache] [--allow-eol] [--ignore-window] <config.json> <ip|hostname|cidr> ...
+  k8s-setup adopt [--kubeconfig file] <config.json> <ip>
+  k8s-setup allowlist checksum <allowlist.json>
+  k8s-setup allowlist record [--output file] <config.json> <ip|hostname|cidr> ...
+  k8s-setup backup list [--json] <config.json> <ip>
+  k8s-setup backup show [--json] <config.json> <ip> <id>
+  k8s-setup certs add-san [--endpoint host[:port]] [--kubeconfig file] [--force-unlock] <config.json> <control-plane-ip> [san...]
+  k8s-setup config migrate [--output file|-] <config.json>
+  k8s-setup diagnose [--since time] [--output file] <config.json> <ip>
+  k8s-setup dr simulate [--namespace name] <config.json> <ip>
+  k8s-setup etcd maintain [--check] [--min-fragmentation 0.1] [--disarm] [--skip-backup] [--force-unlock] <config.json> <control-plane-ip>
+  k8s-setup export [--output file] <config.json> <ip>
+  k8s-setup fleet [--parallel 8] [--timeout 15s] [--json]
+  k8s-setup join [--control-plane] [--run node-ip] [--windows] [--force-unlock] <config.json> <ip>
+  k8s-setup lab up|down [--force-unlock] <config.json>
+  k8s-setup local up [--name name] [--provider kind|minikube] [--image image] <config.json>
+  k8s-setup local down [--name name] [--provider kind|minikube]
+  k8s-setup kubeconfig merge [--kubeconfig file] [cluster...]
+  k8s-setup kubeconfig use [--kubeconfig file] [--print] <cluster>
+  k8s-setup logs [--component name,...] [--since time] [--output file] <config.json> <ip>
+  k8s-setup node drain [--timeout 5m] [--force] [--disable-eviction] [--reason text] <config.json> <control-plane-ip> <node-ip>
+  k8s-setup node uncordon <config.json> <control-plane-ip> <node-ip>
+  k8s-setup operator [--kubeconfig file] [--namespace ns] [--interval 30s] [--retry 10m] <config.json>
+  k8s-setup patch [--all] [--reboot auto|always|never] [--skip-control-plane] [--skip-backup] [--force-unlock] [--ignore-window] <config.json> <control-plane-ip> [node-ip...]
+  k8s-setup ping <config.json> [ip...]
+  k8s-setup plan <config.json> [ip...]
+  k8s-setup render capi [--name name] [--namespace ns] [--output file] <config.json> <control-plane-ip> [worker-ip...]
+  k8s-setup render operator --image image [--namespace ns] [--configmap name] [--output file]
+  k8s-setup render packer [--source source.type.name] [--output dir] <config.json>
+  k8s-setup render script <config.json>
+  k8s-setup rename [--kubeconfig file] [--skip-monitoring] [--force-unlock] <config.json> <control-plane-ip>
+  k8s-setup self-update [--check] [--force] [--endpoint url]
+  k8s-setup ssh rotate-keys [--key file | --output file] [--disable-password] [--remove-old] [--hosts ip,...] <config.json>
+  k8s-setup status compare [--threshold 20] [--min-delta 5s] [--json] <run-a> <run-b>
+  k8s-setup status facts [--json] [ip...]
+  k8s-setup status runs [--json]
+  k8s-setup upgrade [--canary] [--skip-control-plane] [--skip-backup] [--force-unlock] [--allow-eol] [--ignore-window] <config.json> <control-plane-ip> [worker-ip...]
+  k8s-setup upload [--dest dir] [--mode 0644] [--hosts ip,...] <config.json> <file>...
+  k8s-setup version [--json]
+  k8s-setup versions [--json] <config.json> [ip...]
+  k8s-setup watch [--interval 1h] [--once] [--cert-warning 30] [--parallel 4] <config.json>`
+
+// commands maps subcommand names to their handlers
+var commands = map[string]func(args []string) error{
+	"adopt":       runAdopt,
+	"allowlist":   runAllowlist,
+	"backup":      runBackup,
+	"certs":       runCerts,
+	"config":      runConfig,
+	"diagnose":    runDiagnose,
+	"dr":          runDR,
+	"etcd":        runEtcd,
+	"export":      runExport,
+	"fleet":       runFleet,
+	"join":        runJoin,
+	"kubeconfig":  runKubeconfig,
+	"lab":         runLab,
+	"local":       runLocal,
+	"logs":        runLogs,
+	"node":        runNode,
+	"operator":    runOperator,
+	"patch":       runPatch,
+	"ping":        runPing,
+	"plan":        runPlan,
+	"render":      runRender,
+	"rename":      runRename,
+	"self-update": runSelfUpdate,
+	"ssh":         runSSH,
+	"status":      runStatus,
+	"upgrade":     runUpgrade,
+	"upload":      runUpload,
+	"version":     runVersion,
+	"versions":    runVersions,
+	"watch":       runWatch,
+}
+
+// checkRunFlags refuses the flags of the provisioning run given before
+// subcommand, which has flags of its own and would ignore them
+func checkRunFlags(flags *flag.FlagSet, subcommand string) error {
+	var set []string
+	flags.Visit(func(f *flag.Flag) {
+		set = append(set, "--"+f.Name)
+	})
+	if len(set) > 0 {
+		return fmt.Errorf("%s: %s only applies to provisioning runs, give the flags of %s after it, e.g. k8s-setup %s %s ...",
+			subcommand, strings.Join(set, " "), subcommand, subcommand, set[0])
+	}
+	return nil
+}
+
+// connect loads the configuration and opens an SSH connection to target, an
+// IP, hostname or OpenSSH alias; the client's IP is the one it resolved to
+func connect(configPath, target string) (*config.Config, *ssh.Client, error) {
+	cfg, err := config.LoadConfig(configPath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load configuration: %v", err)
+	}
+	ip, err := resolveTarget(cfg, target)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	client, err := ssh.Connect(vmConfig(cfg, ip))
+	if err != nil {
+		return nil, nil, fmt.Errorf("SSH connection failed: %v", err)
+	}
+
+	return cfg, client, nil
+}
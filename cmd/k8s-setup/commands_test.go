@@ -0,0 +1,29 @@
+package main
+
+import (
+	"flag"
+	"strings"
+	"testing"
+)
+
+func TestCheckRunFlags(t *testing.T) {
+	flags := flag.NewFlagSet("k8s-setup", flag.ContinueOnError)
+	flags.Bool("force-unlock", false, "")
+	flags.Bool("no-cache", false, "")
+
+	if err := flags.Parse([]string{"upgrade", "config.json", "10.0.0.1"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkRunFlags(flags, "upgrade"); err != nil {
+		t.Errorf("subcommand without run flags refused: %v", err)
+	}
+
+	// The lock would be kept despite --force-unlock
+	if err := flags.Parse([]string{"--force-unlock", "upgrade", "config.json", "10.0.0.1"}); err != nil {
+		t.Fatal(err)
+	}
+	err := checkRunFlags(flags, "upgrade")
+	if err == nil || !strings.Contains(err.Error(), "k8s-setup upgrade --force-unlock") {
+		t.Errorf("error %v, want the flag after the subcommand", err)
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/maarulav/k8s-setup/pkg/config"
+)
+
+// runConfig dispatches the config subcommands
+func runConfig(args []string) error {
+	if len(args) == 0 || args[0] != "migrate" {
+		return fmt.Errorf("unknown config command, expected: config migrate")
+	}
+	return configMigrate(args[1:])
+}
+
+// configMigrate upgrades a configuration file to the newest schema version.
+// The file is rewritten in place, keeping the original as <file>.bak,
+// unless --output names another file or - for stdout.
+func configMigrate(args []string) error {
+	flags := flag.NewFlagSet("config migrate", flag.ExitOnError)
+	output := flags.String("output", "", "write the upgraded configuration to this file, - for stdout, instead of replacing the original")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		return fmt.Errorf("usage: config migrate [--output file] <config.json>")
+	}
+	path := flags.Arg(0)
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %v", err)
+	}
+	raw, notices, version, err := config.Migrate(data)
+	if err != nil {
+		return err
+	}
+	if version == config.SchemaVersion && *output == "" {
+		fmt.Printf("%s is already at schema version %d\n", path, version)
+		return nil
+	}
+
+	migrated, err := json.MarshalIndent(raw, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal configuration: %v", err)
+	}
+	migrated = append(migrated, '\n')
+
+	// The upgraded file has to load, or it is not written
+	if _, _, err := config.Parse(migrated); err != nil {
+		return fmt.Errorf("the upgraded configuration does not load: %v", err)
+	}
+
+	for _, notice := range notices {
+		fmt.Fprintf(os.Stderr, "Deprecated: %s\n", notice)
+	}
+	switch *output {
+	case "-":
+		_, err := os.Stdout.Write(migrated)
+		return err
+	case "":
+		if err := ioutil.WriteFile(path+".bak", data, 0600); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(path, migrated, 0600); err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "Upgraded %s from schema version %d to %d, the original is %s.bak\n", path, version, config.SchemaVersion, path)
+	default:
+		if err := ioutil.WriteFile(*output, migrated, 0600); err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "Wrote %s at schema version %d\n", *output, config.SchemaVersion)
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"text/tabwriter"
+	"time"
+
+	"github.com/maarulav/k8s-setup/internal/report"
+	"github.com/maarulav/k8s-setup/internal/status"
+	"github.com/maarulav/k8s-setup/pkg/config"
+	"github.com/maarulav/k8s-setup/pkg/monitoring"
+)
+
+// saveCredentials writes the credentials summary of the cluster of s and
+// returns its path. The file is only readable by the operator, or encrypted
+// with age when recipients are configured.
+func saveCredentials(s *status.SetupStatus, cfg *config.Config) (string, error) {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "Credentials of %s (%s), generated by k8s-setup on %s\n\n", cfg.ClusterName(s.VMIP), s.VMIP, time.Now().Format("2006-01-02 15:04 MST"))
+	w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	for _, entry := range credentialEntries(s, cfg) {
+		fmt.Fprintf(w, "%s:\t%s\n", entry.Name, entry.Value)
+	}
+	w.Flush()
+
+	if err := os.MkdirAll(s.WorkDir(), 0700); err != nil {
+		return "", err
+	}
+	path := filepath.Join(s.WorkDir(), "credentials.txt")
+	recipients := cfg.Credentials.AgeRecipients
+	if len(recipients) == 0 {
+		return path, writePrivate(path, b.Bytes())
+	}
+
+	// The plain text never touches the disk
+	args := []string{"--encrypt", "--armor"}
+	for _, recipient := range recipients {
+		args = append(args, "--recipient", recipient)
+	}
+	var encrypted, stderr bytes.Buffer
+	cmd := exec.Command("age", args...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = &b, &encrypted, &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("failed to encrypt the credentials with age: %v\nOutput: %s", err, stderr.String())
+	}
+	if err := writePrivate(path+".age", encrypted.Bytes()); err != nil {
+		return "", err
+	}
+	// A summary of an earlier run may be lying around unencrypted
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+	return path + ".age", nil
+}
+
+// writePrivate replaces the file at path with data, readable only by the
+// operator. The data goes to a new file that is renamed into place, so a
+// file left by an earlier run with a wider mode is not reused.
+func writePrivate(path string, data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+"-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	// TempFile creates the file 0600, but the umask is not to be trusted
+	if err := os.Chmod(tmp.Name(), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// credentialEntries returns the credentials of the cluster of s, with their
+// values where k8s-setup knows them and their location otherwise. There is no
+// dashboard token: k8s-setup does not install the Kubernetes Dashboard, its
+// dashboards are Grafana's.
+func credentialEntries(s *status.SetupStatus, cfg *config.Config) []report.Entry {
+	ip := s.VMIP
+	entries := []report.Entry{
+		{Name: "API server", Value: fmt.Sprintf("https://%s:6443", apiServerAddress(cfg, ip))},
+	}
+
+	kubeconfig := ip + ":/etc/kubernetes/admin.conf"
+	if s.Kubeconfig != "" {
+		kubeconfig = s.Kubeconfig + ", " + kubeconfig
+	}
+	entries = append(entries, report.Entry{Name: "Admin kubeconfig", Value: kubeconfig})
+	if s.Context != "" {
+		entries = append(entries, report.Entry{Name: "Kubeconfig context", Value: s.Context})
+	}
+
+	if url, ok := monitoring.GrafanaURL(cfg, ip); ok {
+		if url != "" {
+			entries = append(entries, report.Entry{Name: "Grafana", Value: url})
+		}
+		entries = append(entries,
+			report.Entry{Name: "Grafana admin user", Value: "admin"},
+			report.Entry{Name: "Grafana admin password", Value: cfg.Monitoring.Grafana.AdminPassword + " (Secret monitoring/grafana-admin)"},
+		)
+	}
+
+	if r := cfg.Addons.Registry; r.Enabled && r.Type == "harbor" && r.AdminPassword != "" {
+		entries = append(entries, report.Entry{Name: "Harbor admin password", Value: r.AdminPassword})
+	}
+	if key := vmConfig(cfg, ip).KeyFile; key != "" {
+		entries = append(entries, report.Entry{Name: "SSH key", Value: key})
+	}
+	if s.SealedSecretsCert != "" {
+		entries = append(entries, report.Entry{Name: "Sealed-secrets certificate", Value: s.SealedSecretsCert})
+	}
+	if cfg.Backup.Volumes.Enabled {
+		entries = append(entries, report.Entry{Name: "Backup bucket credentials", Value: "Secret velero/velero, key cloud"})
+	}
+	return entries
+}
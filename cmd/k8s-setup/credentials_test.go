@@ -0,0 +1,128 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/maarulav/k8s-setup/internal/status"
+	"github.com/maarulav/k8s-setup/pkg/config"
+)
+
+func TestSaveCredentials(t *testing.T) {
+	t.Chdir(t.TempDir())
+	s := &status.SetupStatus{VMIP: "10.0.0.1", Kubeconfig: "status/10.0.0.1.kubeconfig"}
+	cfg := &config.Config{}
+	cfg.Credentials.Enabled = true
+
+	// A summary of an earlier run, readable by everyone
+	path := filepath.Join(s.WorkDir(), "credentials.txt")
+	if err := os.MkdirAll(s.WorkDir(), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	saved, err := saveCredentials(s, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved != path {
+		t.Errorf("saved to %s, want %s", saved, path)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode := info.Mode().Perm(); mode != 0600 {
+		t.Errorf("mode %o, want 600", mode)
+	}
+	data, _ := ioutil.ReadFile(path)
+	for _, want := range []string{"Credentials of 10.0.0.1", "https://10.0.0.1:6443", "status/10.0.0.1.kubeconfig, 10.0.0.1:/etc/kubernetes/admin.conf"} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("summary is missing %q:\n%s", want, data)
+		}
+	}
+	if files, _ := filepath.Glob(filepath.Join(s.WorkDir(), ".*")); len(files) != 0 {
+		t.Errorf("temporary files left behind: %v", files)
+	}
+}
+
+// fakeAge puts an age on PATH that records its arguments and standard input
+// in dir and prints an armored file, or fails when fail is set
+func fakeAge(t *testing.T, fail bool) string {
+	dir := t.TempDir()
+	script := "#!/bin/sh\necho \"$@\" > " + dir + "/args\ncat > " + dir + "/stdin\n"
+	if fail {
+		script += "echo 'age: error: malformed recipient' >&2\nexit 1\n"
+	} else {
+		script += "echo '-----BEGIN AGE ENCRYPTED FILE-----'\n"
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "age"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	return dir
+}
+
+func TestSaveCredentialsAge(t *testing.T) {
+	t.Chdir(t.TempDir())
+	dir := fakeAge(t, false)
+	s := &status.SetupStatus{VMIP: "10.0.0.1"}
+	cfg := &config.Config{}
+	cfg.Credentials.Enabled = true
+	cfg.Credentials.AgeRecipients = []string{"age1first", "ssh-ed25519 AAAA second"}
+
+	// The plain text summary of an earlier run
+	plain := filepath.Join(s.WorkDir(), "credentials.txt")
+	if err := os.MkdirAll(s.WorkDir(), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(plain, []byte("secret"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	saved, err := saveCredentials(s, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved != plain+".age" {
+		t.Errorf("saved to %s, want %s.age", saved, plain)
+	}
+	if data, err := ioutil.ReadFile(saved); err != nil || !strings.HasPrefix(string(data), "-----BEGIN AGE ENCRYPTED FILE-----") {
+		t.Errorf("encrypted file %q, %v", data, err)
+	}
+	if info, err := os.Stat(saved); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("encrypted file %v, %v, want mode 600", info, err)
+	}
+	if _, err := os.Stat(plain); !os.IsNotExist(err) {
+		t.Errorf("the plain text summary was kept: %v", err)
+	}
+
+	args, _ := ioutil.ReadFile(filepath.Join(dir, "args"))
+	if want := "--encrypt --armor --recipient age1first --recipient ssh-ed25519 AAAA second\n"; string(args) != want {
+		t.Errorf("age run with %q, want %q", args, want)
+	}
+	if stdin, _ := ioutil.ReadFile(filepath.Join(dir, "stdin")); !strings.Contains(string(stdin), "https://10.0.0.1:6443") {
+		t.Errorf("age did not get the summary:\n%s", stdin)
+	}
+}
+
+func TestSaveCredentialsAgeFails(t *testing.T) {
+	t.Chdir(t.TempDir())
+	fakeAge(t, true)
+	s := &status.SetupStatus{VMIP: "10.0.0.1"}
+	cfg := &config.Config{}
+	cfg.Credentials.AgeRecipients = []string{"age1broken"}
+
+	_, err := saveCredentials(s, cfg)
+	if err == nil || !strings.Contains(err.Error(), "malformed recipient") {
+		t.Fatalf("got %v, want the age error", err)
+	}
+	if files, _ := filepath.Glob(filepath.Join(s.WorkDir(), "*")); len(files) != 0 {
+		t.Errorf("files written despite the failure: %v", files)
+	}
+}
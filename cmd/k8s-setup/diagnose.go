@@ -0,0 +1,61 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/maarulav/k8s-setup/internal/status"
+	"github.com/maarulav/k8s-setup/pkg/kubernetes"
+	"github.com/maarulav/k8s-setup/pkg/support"
+)
+
+// runDiagnose downloads a troubleshooting bundle for a host
+func runDiagnose(args []string) error {
+	flags := flag.NewFlagSet("diagnose", flag.ExitOnError)
+	since := flags.String("since", "24 hours ago", "only collect log entries newer than this")
+	output := flags.String("output", "", "local archive path (default status/bundles/diagnose-<ip>-<time>.tar.gz)")
+	flags.Parse(args)
+
+	if flags.NArg() != 2 {
+		return fmt.Errorf("usage: diagnose [--since time] [--output file] <config.json> <ip>")
+	}
+	_, client, err := connect(flags.Arg(0), flags.Arg(1))
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	ip := client.IP
+
+	bundle, err := support.NewBundle(client, "k8s-setup-diagnose")
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Collecting diagnostics from %s\n", ip)
+	bundle.CollectDiagnostics(kubernetes.KubeadmLog)
+	if err := bundle.CollectLogs(support.ComponentNames(), *since); err != nil {
+		return err
+	}
+
+	// Include what this tool knows about the host
+	if data, err := ioutil.ReadFile(fmt.Sprintf("%s/%s.json", status.Dir, ip)); err == nil {
+		if err := bundle.Write("k8s-setup/status.json", data); err != nil {
+			return err
+		}
+	}
+
+	path := *output
+	if path == "" {
+		var err error
+		if path, err = bundlePath("diagnose", ip); err != nil {
+			return err
+		}
+	}
+	if err := bundle.Download(path); err != nil {
+		return err
+	}
+
+	fmt.Printf("Diagnostics bundle of %s saved to %s\n", ip, path)
+	return nil
+}
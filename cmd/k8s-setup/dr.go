@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/maarulav/k8s-setup/pkg/backup"
+	"github.com/maarulav/k8s-setup/pkg/kube"
+	"github.com/maarulav/k8s-setup/pkg/kubernetes"
+	"github.com/maarulav/k8s-setup/pkg/ssh"
+)
+
+// runDR dispatches the disaster recovery subcommands
+func runDR(args []string) error {
+	if len(args) == 0 || args[0] != "simulate" {
+		return fmt.Errorf("unknown dr command, expected: dr simulate")
+	}
+	return drSimulate(args[1:])
+}
+
+// drSimulate deletes a test namespace, restores it from the latest backup and
+// checks that its workloads come back
+func drSimulate(args []string) error {
+	flags := flag.NewFlagSet("dr simulate", flag.ExitOnError)
+	namespace := flags.String("namespace", "dr-test", "namespace to tear down and restore")
+	existing := flags.Bool("use-existing-backup", false, "restore from the existing backup instead of taking a fresh one")
+	flags.Parse(args)
+
+	if flags.NArg() != 2 {
+		return fmt.Errorf("usage: dr simulate [--namespace name] <config.json> <ip>")
+	}
+
+	cfg, client, err := connect(flags.Arg(0), flags.Arg(1))
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	// Seed the namespace with a canary workload if it does not exist yet
+	if _, err := client.ExecuteCommand(fmt.Sprintf("kubectl get namespace %s", *namespace)); err != nil {
+		fmt.Printf("Namespace %s not found, creating canary workload\n", *namespace)
+		if err := seedCanary(client, *namespace); err != nil {
+			return err
+		}
+	}
+
+	before, err := workloads(client, *namespace)
+	if err != nil {
+		return err
+	}
+
+	if !*existing {
+		fmt.Println("Creating backup")
+		if err := backup.Create(client, cfg); err != nil {
+			return err
+		}
+	}
+
+	fmt.Printf("Deleting namespace %s\n", *namespace)
+	if _, err := client.ExecuteCommand(fmt.Sprintf("kubectl delete namespace %s --wait --timeout=300s", *namespace)); err != nil {
+		return fmt.Errorf("failed to delete namespace %s: %v", *namespace, err)
+	}
+
+	fmt.Printf("Restoring namespace %s from backup\n", *namespace)
+	restored, err := backup.RestoreNamespace(client, *namespace)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Restored %d objects\n", restored)
+
+	for _, workload := range before {
+		cmd := fmt.Sprintf("kubectl rollout status %s -n %s --timeout=300s", workload, *namespace)
+		if output, err := client.ExecuteCommand(cmd); err != nil {
+			return fmt.Errorf("workload %s did not recover: %v\nOutput: %s", workload, err, output)
+		}
+		fmt.Printf("Workload %s recovered\n", workload)
+	}
+
+	after, err := workloads(client, *namespace)
+	if err != nil {
+		return err
+	}
+	if len(after) != len(before) {
+		return fmt.Errorf("expected %d workloads after restore, found %d", len(before), len(after))
+	}
+
+	fmt.Printf("DR simulation passed for namespace %s\n", *namespace)
+	return nil
+}
+
+// workloads lists the deployments, statefulsets and daemonsets in namespace
+func workloads(client *ssh.Client, namespace string) ([]string, error) {
+	output, err := client.ExecuteCommand(fmt.Sprintf("kubectl get deployments,statefulsets,daemonsets -n %s -o name", namespace))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list workloads in %s: %v", namespace, err)
+	}
+	return strings.Fields(output), nil
+}
+
+// seedCanary creates the namespace with a small deployment and config map
+func seedCanary(client *ssh.Client, namespace string) error {
+	labels := map[string]interface{}{"app": "dr-canary"}
+	err := kubernetes.Apply(client, "dr-canary", []map[string]interface{}{
+		{
+			"apiVersion": "v1",
+			"kind":       "Namespace",
+			"metadata":   map[string]interface{}{"name": namespace},
+		},
+		{
+			"apiVersion": "v1",
+			"kind":       "ConfigMap",
+			"metadata":   map[string]interface{}{"name": "dr-canary", "namespace": namespace},
+			"data":       map[string]interface{}{"index.html": "dr-canary"},
+		},
+		{
+			"apiVersion": "apps/v1",
+			"kind":       "Deployment",
+			"metadata":   map[string]interface{}{"name": "dr-canary", "namespace": namespace, "labels": labels},
+			"spec": map[string]interface{}{
+				"replicas": 2,
+				"selector": map[string]interface{}{"matchLabels": labels},
+				"template": map[string]interface{}{
+					"metadata": map[string]interface{}{"labels": labels},
+					"spec": map[string]interface{}{
+						"containers": []map[string]interface{}{
+							{
+								"name":  "nginx",
+								"image": "nginx:stable-alpine",
+								"volumeMounts": []map[string]interface{}{
+									{"name": "content", "mountPath": "/usr/share/nginx/html"},
+								},
+							},
+						},
+						"volumes": []map[string]interface{}{
+							{"name": "content", "configMap": map[string]interface{}{"name": "dr-canary"}},
+						},
+					},
+				},
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	kc, err := kube.NewClient(client)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), kube.DefaultTimeout)
+	defer cancel()
+	if err := kube.WaitForDeploymentAvailable(ctx, kc, namespace, "dr-canary", kube.Print); err != nil {
+		return fmt.Errorf("canary workload did not start: %v", err)
+	}
+
+	return nil
+}
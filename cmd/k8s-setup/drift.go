@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/maarulav/k8s-setup/internal/status"
+	"github.com/maarulav/k8s-setup/pkg/config"
+)
+
+// checkDrift reports the hosts last provisioned with a configuration other
+// than cfg. With the refuse policy it fails unless force is set.
+func checkDrift(cfg *config.Config, ips []string, force bool) error {
+	switch cfg.ConfigDrift {
+	case "", config.ConfigDriftWarn, config.ConfigDriftRefuse:
+	default:
+		return fmt.Errorf("invalid configDrift %q", cfg.ConfigDrift)
+	}
+
+	hash := cfg.Hash()
+	var drifted []string
+	for _, ip := range ips {
+		previous, err := status.Load(ip)
+		if err != nil || previous.ConfigHash == "" || previous.ConfigHash == hash {
+			continue
+		}
+		fmt.Printf("Warning: %s was provisioned on %s with configuration %s, this one is %s\n",
+			ip, previous.StartTime.Format("2006-01-02 15:04"), shortHash(previous.ConfigHash), shortHash(hash))
+		drifted = append(drifted, ip)
+	}
+
+	if len(drifted) > 0 && cfg.ConfigDrift == config.ConfigDriftRefuse && !force {
+		return fmt.Errorf("the configuration changed since %s was provisioned, pass --force to apply it", strings.Join(drifted, ", "))
+	}
+	return nil
+}
+
+// shortHash abbreviates a configuration hash for display
+func shortHash(hash string) string {
+	if len(hash) > 12 {
+		return hash[:12]
+	}
+	return hash
+}
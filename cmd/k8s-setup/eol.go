@@ -0,0 +1,33 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/maarulav/k8s-setup/pkg/config"
+	"github.com/maarulav/k8s-setup/pkg/kubernetes"
+)
+
+// checkVersion refuses a Kubernetes version past its end of life unless
+// allowEOL is set, and warns about one close to it. The dates of
+// kubernetes.eolFeed are used when it can be read.
+func checkVersion(cfg *config.Config, allowEOL bool) error {
+	var dates map[int]time.Time
+	if feed := cfg.Kubernetes.EOLFeed; feed != "" {
+		var err error
+		if dates, err = kubernetes.FetchEOL(feed); err != nil {
+			fmt.Printf("Warning: %v, using the built-in end-of-life dates\n", err)
+		}
+	}
+
+	now := time.Now()
+	support := kubernetes.CheckSupport(cfg.Kubernetes.Version, now, dates)
+	warning := support.Warning(now)
+	if support.Expired && !allowEOL {
+		return fmt.Errorf("%s, pass --allow-eol to use it anyway", warning)
+	}
+	if warning != "" {
+		fmt.Printf("Warning: %s\n", warning)
+	}
+	return nil
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/maarulav/k8s-setup/pkg/etcd"
+	"github.com/maarulav/k8s-setup/pkg/ssh"
+)
+
+// quotaWarning is the share of the backend quota above which a member's
+// database is reported
+const quotaWarning = 0.8
+
+// runEtcd dispatches the etcd subcommands
+func runEtcd(args []string) error {
+	if len(args) == 0 || args[0] != "maintain" {
+		return fmt.Errorf("unknown etcd command, expected: etcd maintain")
+	}
+	return etcdMaintain(args[1:])
+}
+
+// etcdMaintain reports the health, database size and alarms of the etcd
+// members and defragments them one at a time, followers first and the leader
+// last. It refuses to defragment while a member is unhealthy and stops as
+// soon as one becomes unhealthy.
+func etcdMaintain(args []string) error {
+	flags := flag.NewFlagSet("etcd maintain", flag.ExitOnError)
+	check := flags.Bool("check", false, "only report the state of the members")
+	minFragmentation := flags.Float64("min-fragmentation", 0.1, "only defragment members with at least this share of their database unused")
+	disarm := flags.Bool("disarm", false, "disarm the NOSPACE alarm once every member was defragmented and is under the quota, other alarms are refused")
+	skipBackup := flags.Bool("skip-backup", false, "do not back up the cluster before defragmenting")
+	forceUnlock := flags.Bool("force-unlock", false, "break the lock another run holds on the host")
+	flags.Parse(args)
+
+	if flags.NArg() != 2 {
+		return fmt.Errorf("usage: etcd maintain [--check] [--min-fragmentation 0.1] [--disarm] [--skip-backup] [--force-unlock] <config.json> <control-plane-ip>")
+	}
+
+	cfg, client, err := connect(flags.Arg(0), flags.Arg(1))
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	members, err := etcd.Members(client)
+	if err != nil {
+		return err
+	}
+	quota, err := etcd.Quota(client)
+	if err != nil {
+		return err
+	}
+	alarms, err := etcd.Alarms(client)
+	if err != nil {
+		return err
+	}
+	printMembers(members, quota)
+
+	unhealthy := unhealthyMembers(members)
+	for _, m := range members {
+		if float64(m.DBSize) > quotaWarning*float64(quota) {
+			fmt.Printf("Warning: the database of %s uses %.0f%% of its %s quota\n", m.Name, 100*float64(m.DBSize)/float64(quota), formatSize(quota))
+		}
+	}
+	for _, a := range alarms {
+		member := fmt.Sprintf("%x", a.MemberID)
+		for _, m := range members {
+			if m.ID == a.MemberID {
+				member = m.Name
+			}
+		}
+		fmt.Printf("Warning: alarm %s raised by member %s\n", a.Alarm, member)
+	}
+	if *check {
+		if len(unhealthy) > 0 {
+			return fmt.Errorf("unhealthy etcd members: %s", strings.Join(unhealthy, ", "))
+		}
+		return nil
+	}
+	if len(unhealthy) > 0 {
+		return fmt.Errorf("not defragmenting while members are unhealthy: %s", strings.Join(unhealthy, ", "))
+	}
+
+	var pending []etcd.Member
+	for _, m := range members {
+		if m.Fragmentation() >= *minFragmentation {
+			pending = append(pending, m)
+		}
+	}
+	// The leader goes last, so the cluster elects a new one at most once
+	sort.SliceStable(pending, func(i, j int) bool { return !pending[i].Leader && pending[j].Leader })
+	if len(pending) == 0 {
+		fmt.Printf("No member has %.0f%% of its database unused, nothing to defragment\n", 100*(*minFragmentation))
+	}
+
+	if len(pending) > 0 || (*disarm && len(alarms) > 0) {
+		unlock, err := lockHosts(cfg, []string{client.IP}, "etcd-maintain", *forceUnlock)
+		if err != nil {
+			return err
+		}
+		defer unlock()
+	}
+	if len(pending) > 0 {
+		if err := recordOperation(client, cfg, "etcd-maintain", *skipBackup); err != nil {
+			return err
+		}
+	}
+
+	for _, m := range pending {
+		fmt.Printf("Defragmenting %s (%s), %s unused\n", m.Name, m.Endpoint, formatSize(m.DBSize-m.DBSizeInUse))
+		if err := etcd.Defragment(client, m.Endpoint); err != nil {
+			return err
+		}
+		if err := checkMembers(client); err != nil {
+			return fmt.Errorf("stopping after %s: %v", m.Name, err)
+		}
+	}
+
+	if *disarm && len(alarms) > 0 {
+		fmt.Println("Disarming etcd alarms")
+		if err := etcd.DisarmAlarms(client); err != nil {
+			return err
+		}
+	}
+
+	if len(pending) > 0 {
+		members, err := etcd.Members(client)
+		if err != nil {
+			return err
+		}
+		printMembers(members, quota)
+	}
+	return nil
+}
+
+// checkMembers fails when a member is unhealthy
+func checkMembers(client ssh.Executor) error {
+	members, err := etcd.Members(client)
+	if err != nil {
+		return err
+	}
+	if unhealthy := unhealthyMembers(members); len(unhealthy) > 0 {
+		return fmt.Errorf("unhealthy etcd members: %s", strings.Join(unhealthy, ", "))
+	}
+	return nil
+}
+
+// unhealthyMembers returns the names and errors of the unhealthy members
+func unhealthyMembers(members []etcd.Member) []string {
+	var unhealthy []string
+	for _, m := range members {
+		if !m.Healthy {
+			unhealthy = append(unhealthy, fmt.Sprintf("%s (%s)", m.Name, m.Error))
+		}
+	}
+	return unhealthy
+}
+
+func printMembers(members []etcd.Member, quota int64) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "MEMBER\tENDPOINT\tHEALTHY\tLEADER\tVERSION\tDB SIZE\tIN USE\tQUOTA")
+	for _, m := range members {
+		fmt.Fprintf(w, "%s\t%s\t%t\t%t\t%s\t%s\t%s\t%.0f%%\n", m.Name, m.Endpoint, m.Healthy, m.Leader, m.Version,
+			formatSize(m.DBSize), formatSize(m.DBSizeInUse), 100*float64(m.DBSize)/float64(quota))
+	}
+	w.Flush()
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/maarulav/k8s-setup/internal/testutil"
+	"github.com/maarulav/k8s-setup/pkg/config"
+)
+
+// writeConfig writes the configuration reaching the control plane served by
+// cp as localhost into the working directory and returns its path
+func writeConfig(t *testing.T, cp *testutil.SSHServer, cfg *config.Config) string {
+	t.Helper()
+	cfg.SSHConfig.Shell = config.ShellLogin
+	cfg.SSHConfig.ConfigFile = "none"
+	cfg.SSHConfig.Timeout = config.Duration(5 * time.Second)
+	cfg.SSHConfig.Username = testutil.SSHUser
+	cfg.SSHConfig.Password = testutil.SSHPassword
+	cfg.Hosts = append(cfg.Hosts, config.Host{IP: "localhost", Port: cp.VMConfig().Port})
+
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// etcdServer returns a control plane of three healthy etcd members, the
+// leader cp-2 listed first, each with half of its database unused
+func etcdServer(t *testing.T, alarms string) *testutil.SSHServer {
+	return testutil.NewSSHServer(t).
+		Respond("member list", `{"members":[
+			{"ID":2,"name":"cp-2","clientURLs":["https://10.0.0.2:2379"]},
+			{"ID":1,"name":"cp-1","clientURLs":["https://10.0.0.1:2379"]},
+			{"ID":3,"name":"cp-3","clientURLs":["https://10.0.0.3:2379"]}
+		]}`).
+		Respond("endpoint health", `[
+			{"endpoint":"https://10.0.0.1:2379","health":true},
+			{"endpoint":"https://10.0.0.2:2379","health":true},
+			{"endpoint":"https://10.0.0.3:2379","health":true}
+		]`).
+		Respond("endpoint status", `[
+			{"Endpoint":"https://10.0.0.1:2379","Status":{"dbSize":1000,"dbSizeInUse":500,"leader":2}},
+			{"Endpoint":"https://10.0.0.2:2379","Status":{"dbSize":1000,"dbSizeInUse":500,"leader":2}},
+			{"Endpoint":"https://10.0.0.3:2379","Status":{"dbSize":1000,"dbSizeInUse":500,"leader":2}}
+		]`).
+		Respond("alarm list", alarms).
+		Respond("spec.containers[0].command", `["etcd","--quota-backend-bytes=2000"]`)
+}
+
+func TestEtcdMaintainLeaderLast(t *testing.T) {
+	t.Chdir(t.TempDir())
+	cp := etcdServer(t, "memberID:2 alarm:NOSPACE\n")
+	path := writeConfig(t, cp, &config.Config{})
+
+	if err := etcdMaintain([]string{"--skip-backup", "--disarm", path, "localhost"}); err != nil {
+		t.Fatal(err)
+	}
+
+	var order []string
+	for _, command := range cp.Commands() {
+		if strings.HasSuffix(command, " defrag") {
+			order = append(order, strings.Fields(command[strings.Index(command, "--endpoints="):])[0])
+		}
+		if strings.HasSuffix(command, "alarm disarm") {
+			order = append(order, "disarm")
+		}
+	}
+	want := []string{
+		"--endpoints=https://10.0.0.1:2379",
+		"--endpoints=https://10.0.0.3:2379",
+		"--endpoints=https://10.0.0.2:2379",
+		"disarm",
+	}
+	if strings.Join(order, " ") != strings.Join(want, " ") {
+		t.Errorf("maintenance order %v, want the followers, the leader and then the disarm", order)
+	}
+}
+
+func TestEtcdMaintainCorrupt(t *testing.T) {
+	t.Chdir(t.TempDir())
+	cp := etcdServer(t, "memberID:3 alarm:CORRUPT\n")
+	path := writeConfig(t, cp, &config.Config{})
+
+	err := etcdMaintain([]string{"--skip-backup", "--disarm", "--min-fragmentation", "0.9", path, "localhost"})
+	if err == nil || !strings.Contains(err.Error(), "alarm CORRUPT") {
+		t.Errorf("error %v, want the CORRUPT alarm refused", err)
+	}
+	if len(commandsWith(cp, "alarm disarm")) != 0 {
+		t.Error("the CORRUPT alarm was disarmed")
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/maarulav/k8s-setup/pkg/addons"
+	"github.com/maarulav/k8s-setup/pkg/config"
+	"github.com/maarulav/k8s-setup/pkg/kubernetes"
+	"github.com/maarulav/k8s-setup/pkg/monitoring"
+)
+
+// runExport inspects a provisioned cluster and prints a configuration file
+// describing its current state
+func runExport(args []string) error {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	output := flags.String("output", "", "write the configuration to this file instead of stdout")
+	flags.Parse(args)
+
+	if flags.NArg() != 2 {
+		return fmt.Errorf("usage: export [--output file] <config.json> <ip>")
+	}
+
+	cfg, client, err := connect(flags.Arg(0), flags.Arg(1))
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	// Only the connection settings are carried over, without the password
+	exported := &config.Config{SchemaVersion: config.SchemaVersion}
+	exported.SSHConfig = cfg.SSHConfig
+	exported.SSHConfig.Password = ""
+
+	if err := kubernetes.Inspect(client, exported); err != nil {
+		return err
+	}
+	if err := monitoring.Inspect(client, exported); err != nil {
+		return err
+	}
+	if err := addons.Inspect(client, exported); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(exported, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal configuration: %v", err)
+	}
+	data = append(data, '\n')
+
+	if *output == "" {
+		_, err := os.Stdout.Write(data)
+		return err
+	}
+	return ioutil.WriteFile(*output, data, 0600)
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"sort"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/maarulav/k8s-setup/internal/status"
+	"github.com/maarulav/k8s-setup/pkg/kube"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// fleetCluster is a cluster of the status store as listed by k8s-setup fleet
+type fleetCluster struct {
+	Name         string     `json:"name"`
+	IP           string     `json:"ip"`
+	Status       string     `json:"status"`
+	Version      string     `json:"version,omitempty"`
+	Nodes        int        `json:"nodes"`
+	ReadyNodes   int        `json:"readyNodes"`
+	LastBackup   *time.Time `json:"lastBackup,omitempty"`
+	Verification string     `json:"verification,omitempty"`
+	VerifiedAt   *time.Time `json:"verifiedAt,omitempty"`
+	CertExpiry   *time.Time `json:"certExpiry,omitempty"`
+	Error        string     `json:"error,omitempty"`
+}
+
+// runFleet lists the clusters of the status store, querying their stored
+// kubeconfigs concurrently
+func runFleet(args []string) error {
+	flags := flag.NewFlagSet("fleet", flag.ExitOnError)
+	parallel := flags.Int("parallel", 8, "clusters queried at once")
+	timeout := flags.Duration("timeout", 15*time.Second, "how long to wait for each cluster")
+	asJSON := flags.Bool("json", false, "print as JSON")
+	flags.Parse(args)
+
+	if flags.NArg() != 0 {
+		return fmt.Errorf("usage: fleet [--parallel 8] [--timeout 15s] [--json]")
+	}
+	clusters, err := fleetClusters()
+	if err != nil {
+		return err
+	}
+	fleet := make([]fleetCluster, len(clusters))
+	eachCluster(clusters, *parallel, func(i int, s *status.SetupStatus) {
+		fleet[i] = queryCluster(s, *timeout)
+	})
+	sort.Slice(fleet, func(i, j int) bool { return fleet[i].Name < fleet[j].Name })
+
+	if *asJSON {
+		return printJSON(fleet)
+	}
+	if len(fleet) == 0 {
+		fmt.Printf("No clusters with a kubeconfig in %s\n", status.Dir)
+		return nil
+	}
+
+	now := time.Now()
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "CLUSTER\tIP\tSTATUS\tVERSION\tNODES\tLAST BACKUP\tVERIFY\tCERT DAYS\tERROR")
+	for _, c := range fleet {
+		nodes, certDays := "-", "-"
+		if c.Version != "" {
+			nodes = fmt.Sprintf("%d/%d", c.ReadyNodes, c.Nodes)
+		}
+		if c.CertExpiry != nil {
+			certDays = fmt.Sprint(int(c.CertExpiry.Sub(now).Hours() / 24))
+		}
+		verify := dash(c.Verification)
+		if c.VerifiedAt != nil {
+			verify += " " + c.VerifiedAt.Format("2006-01-02")
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", c.Name, c.IP, c.Status, dash(c.Version), nodes,
+			formatTime(c.LastBackup), verify, certDays, dash(c.Error))
+	}
+	return w.Flush()
+}
+
+// fleetClusters returns the entries of the status store with a kubeconfig,
+// the control planes of the clusters
+func fleetClusters() ([]*status.SetupStatus, error) {
+	statuses, err := status.List()
+	if err != nil {
+		return nil, err
+	}
+	var clusters []*status.SetupStatus
+	for _, s := range statuses {
+		// Worker and failed entries have no kubeconfig
+		if s.Kubeconfig != "" {
+			clusters = append(clusters, s)
+		}
+	}
+	return clusters, nil
+}
+
+// eachCluster calls fn for every cluster, at most parallel at once, and
+// returns when all calls have
+func eachCluster(clusters []*status.SetupStatus, parallel int, fn func(i int, s *status.SetupStatus)) {
+	if parallel < 1 {
+		parallel = 1
+	}
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, parallel)
+	for i, s := range clusters {
+		wg.Add(1)
+		go func(i int, s *status.SetupStatus) {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+			fn(i, s)
+		}(i, s)
+	}
+	wg.Wait()
+}
+
+// queryCluster reads what the status store records about the cluster of s
+// and asks its API server for the rest
+func queryCluster(s *status.SetupStatus, timeout time.Duration) fleetCluster {
+	c := fleetCluster{Name: s.VMIP, IP: s.VMIP, Status: s.Status}
+	if s.ClusterName != "" {
+		c.Name = s.ClusterName
+	}
+
+	// Backups are taken at the end of a run and before destructive operations
+	c.LastBackup = s.LastBackup
+	for i, op := range s.Operations {
+		if op.Backup != "" && (c.LastBackup == nil || op.StartTime.After(*c.LastBackup)) {
+			c.LastBackup = &s.Operations[i].StartTime
+		}
+	}
+
+	switch {
+	case s.Verification != nil:
+		c.Verification = "failed"
+		if s.Verification.Passed {
+			c.Verification = "passed"
+		}
+		c.VerifiedAt = &s.Verification.Time
+	case s.HasCompleted("verification"):
+		// Recorded before the outcome was kept
+		c.Verification = "passed"
+		c.VerifiedAt = &s.EndTime
+	}
+
+	kc, restConfig, err := kube.NewClientForKubeconfig(s.Kubeconfig, timeout)
+	if err != nil {
+		c.Error = err.Error()
+		return c
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	version, err := kc.Discovery().ServerVersion()
+	if err != nil {
+		c.Error = fmt.Sprintf("API server unreachable: %v", err)
+		return c
+	}
+	c.Version = version.GitVersion
+
+	nodes, err := kc.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		c.Error = fmt.Sprintf("failed to list nodes: %v", err)
+		return c
+	}
+	c.Nodes = len(nodes.Items)
+	for _, node := range nodes.Items {
+		for _, condition := range node.Status.Conditions {
+			if condition.Type == corev1.NodeReady && condition.Status == corev1.ConditionTrue {
+				c.ReadyNodes++
+			}
+		}
+	}
+
+	if expiry, err := servingCertExpiry(restConfig.Host, timeout); err != nil {
+		c.Error = err.Error()
+	} else {
+		// k8s-setup watch records the first of all the certificates. A run
+		// since its check, e.g. an upgrade, may have renewed them.
+		if s.CertExpiry != nil && s.CertChecked != nil && !s.CertChecked.Before(s.LastRun()) && s.CertExpiry.Before(expiry) {
+			expiry = *s.CertExpiry
+		}
+		c.CertExpiry = &expiry
+	}
+	return c
+}
+
+// servingCertExpiry returns when the certificate the API server at host
+// serves expires. kubeadm issues it together with the other leaf
+// certificates of the control plane, which expire with it.
+func servingCertExpiry(host string, timeout time.Duration) (time.Time, error) {
+	u, err := url.Parse(host)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid API server address %q: %v", host, err)
+	}
+	address := u.Host
+	if u.Port() == "" {
+		address = net.JoinHostPort(u.Hostname(), "443")
+	}
+
+	// Only the expiry is read, the certificate is not trusted for anything
+	conn, err := tls.DialWithDialer(&net.Dialer{Timeout: timeout}, "tcp", address, &tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to read the API server certificate: %v", err)
+	}
+	defer conn.Close()
+	certs := conn.ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		return time.Time{}, fmt.Errorf("the API server presented no certificate")
+	}
+	return certs[0].NotAfter, nil
+}
+
+// formatTime renders an optional time to the minute, - when it is not set
+func formatTime(t *time.Time) string {
+	if t == nil {
+		return "-"
+	}
+	return t.Format("2006-01-02 15:04")
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/maarulav/k8s-setup/internal/status"
+)
+
+// apiServer serves the version and the nodes of a cluster with two nodes,
+// one of them Ready
+func apiServer(t *testing.T) *httptest.Server {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/version":
+			fmt.Fprint(w, `{"major":"1","minor":"30","gitVersion":"v1.30.4"}`)
+		case "/api/v1/nodes":
+			fmt.Fprint(w, `{"kind":"NodeList","apiVersion":"v1","items":[
+				{"metadata":{"name":"cp-1"},"status":{"conditions":[{"type":"Ready","status":"True"}]}},
+				{"metadata":{"name":"worker-1"},"status":{"conditions":[{"type":"Ready","status":"False"}]}}]}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// writeKubeconfig writes a kubeconfig for the API server at url and returns
+// its path
+func writeKubeconfig(t *testing.T, url string) string {
+	path := filepath.Join(t.TempDir(), "kubeconfig")
+	data := fmt.Sprintf(`apiVersion: v1
+kind: Config
+clusters:
+- name: test
+  cluster:
+    server: %s
+    insecure-skip-tls-verify: true
+users:
+- name: admin
+  user:
+    token: test
+contexts:
+- name: test
+  context:
+    cluster: test
+    user: admin
+current-context: test
+`, url)
+	if err := ioutil.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// fleetStatus returns a status of the cluster prod that ended at end, with a
+// backup, a passed verification, an upgrade and a patch
+func fleetStatus(kubeconfig string, end time.Time) *status.SetupStatus {
+	backup := end.Add(-time.Hour)
+	return &status.SetupStatus{
+		VMIP:         "10.0.0.1",
+		ClusterName:  "prod",
+		Status:       "Completed",
+		Kubeconfig:   kubeconfig,
+		EndTime:      end,
+		LastBackup:   &backup,
+		Verification: &status.Verification{Time: end, Passed: true},
+		Operations: []status.Operation{
+			{Name: "upgrade", StartTime: end.Add(time.Hour), Backup: "/var/backups/k8s-setup/upgrade"},
+			{Name: "patch", StartTime: end.Add(2 * time.Hour)},
+		},
+	}
+}
+
+func TestQueryCluster(t *testing.T) {
+	server := apiServer(t)
+	end := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	s := fleetStatus(writeKubeconfig(t, server.URL), end)
+
+	c := queryCluster(s, 5*time.Second)
+	if c.Error != "" {
+		t.Fatal(c.Error)
+	}
+	if c.Name != "prod" || c.IP != "10.0.0.1" || c.Status != "Completed" || c.Version != "v1.30.4" || c.Nodes != 2 || c.ReadyNodes != 1 {
+		t.Errorf("cluster %+v", c)
+	}
+	// The backup before the upgrade is the latest, the patch took none
+	if c.LastBackup == nil || !c.LastBackup.Equal(end.Add(time.Hour)) {
+		t.Errorf("last backup %v, want the one before the upgrade", c.LastBackup)
+	}
+	if c.Verification != "passed" || c.VerifiedAt == nil || !c.VerifiedAt.Equal(end) {
+		t.Errorf("verification %q at %v", c.Verification, c.VerifiedAt)
+	}
+	if c.CertExpiry == nil || !c.CertExpiry.Equal(server.Certificate().NotAfter) {
+		t.Errorf("certificate expiry %v, want %s", c.CertExpiry, server.Certificate().NotAfter)
+	}
+}
+
+func TestQueryClusterWatchedExpiry(t *testing.T) {
+	server := apiServer(t)
+	end := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	s := fleetStatus(writeKubeconfig(t, server.URL), end)
+	s.Operations = nil
+	expiry := server.Certificate().NotAfter.Add(-24 * time.Hour)
+	checked := end.Add(time.Hour)
+	s.CertExpiry, s.CertChecked = &expiry, &checked
+
+	// The etcd certificates found by watch expire first
+	if c := queryCluster(s, 5*time.Second); c.CertExpiry == nil || !c.CertExpiry.Equal(expiry) {
+		t.Errorf("certificate expiry %v, want the watched %s", c.CertExpiry, expiry)
+	}
+
+	// An upgrade since the check renewed them
+	s.Operations = []status.Operation{{Name: "upgrade", StartTime: checked.Add(time.Hour)}}
+	if c := queryCluster(s, 5*time.Second); c.CertExpiry == nil || !c.CertExpiry.Equal(server.Certificate().NotAfter) {
+		t.Errorf("certificate expiry %v, want the served %s", c.CertExpiry, server.Certificate().NotAfter)
+	}
+}
+
+func TestQueryClusterUnreachable(t *testing.T) {
+	server := apiServer(t)
+	kubeconfig := writeKubeconfig(t, server.URL)
+	server.Close()
+	end := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	s := fleetStatus(kubeconfig, end)
+	s.Verification = &status.Verification{Time: end, Error: "nodes not Ready: worker-1"}
+
+	c := queryCluster(s, 2*time.Second)
+	if !strings.HasPrefix(c.Error, "API server unreachable") {
+		t.Errorf("error %q, want the API server unreachable", c.Error)
+	}
+	// What the status store knows is kept
+	if c.Name != "prod" || c.LastBackup == nil || c.Verification != "failed" || c.VerifiedAt == nil {
+		t.Errorf("cluster %+v lost the stored fields", c)
+	}
+	if c.Version != "" || c.Nodes != 0 || c.CertExpiry != nil {
+		t.Errorf("cluster %+v has fields of an unreachable API server", c)
+	}
+
+	s.Kubeconfig = filepath.Join(t.TempDir(), "missing")
+	if c := queryCluster(s, 2*time.Second); !strings.Contains(c.Error, "failed to load kubeconfig") {
+		t.Errorf("error %q, want the kubeconfig missing", c.Error)
+	}
+}
+
+func TestServingCertExpiry(t *testing.T) {
+	server := apiServer(t)
+	expiry, err := servingCertExpiry(server.URL, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !expiry.Equal(server.Certificate().NotAfter) {
+		t.Errorf("expiry %s, want %s", expiry, server.Certificate().NotAfter)
+	}
+
+	if _, err := servingCertExpiry("://", time.Second); err == nil {
+		t.Error("expected an invalid address to be refused")
+	}
+	plain := httptest.NewServer(http.NotFoundHandler())
+	defer plain.Close()
+	if _, err := servingCertExpiry(plain.URL, 2*time.Second); err == nil {
+		t.Error("expected a server without TLS to fail")
+	}
+}
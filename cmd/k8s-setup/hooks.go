@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/maarulav/k8s-setup/internal/status"
+	"github.com/maarulav/k8s-setup/pkg/config"
+)
+
+// defaultHookTimeout bounds hooks without a timeout of their own
+const defaultHookTimeout = 5 * time.Minute
+
+// hookWaitDelay is how long the output of a hook that ran out of time is
+// still read. Background children of the shell keep its output open after
+// the shell was killed.
+const hookWaitDelay = 2 * time.Second
+
+// runHooks runs the hooks configured at when around step for the host of s,
+// one after the other. It returns
+// the error of the first required hook that fails; the other failures are
+// logged.
+func (r *run) runHooks(s *status.SetupStatus, when, step string) error {
+	for _, hook := range r.cfg.Hooks {
+		if !hook.Matches(when, step) {
+			continue
+		}
+
+		output, err := r.runHook(hook, s, when, step)
+		if len(output) > 0 {
+			log.Printf("Hook %s %s on %s:\n%s", when, step, s.VMIP, output)
+		}
+		if err == nil {
+			continue
+		}
+		if hook.Required && when == config.HookBefore {
+			return err
+		}
+		log.Printf("Warning: hook %s %s on %s failed: %v", when, step, s.VMIP, err)
+	}
+	return nil
+}
+
+// runHook runs hook for the host of s and returns its combined output. The
+// command of a templated hook is rendered with the host vars. The shell is
+// killed once the timeout of the hook passes.
+func (r *run) runHook(hook config.Hook, s *status.SetupStatus, when, step string) ([]byte, error) {
+	timeout := defaultHookTimeout
+	if hook.Timeout != "" {
+		t, err := time.ParseDuration(hook.Timeout)
+		if err != nil || t <= 0 {
+			return nil, fmt.Errorf("%s: invalid timeout %q", hook.Command, hook.Timeout)
+		}
+		timeout = t
+	}
+	command := hook.Command
+	if hook.Template {
+		rendered, err := config.Render("hook", hook.Command, r.cfg.HostVars(s.VMIP))
+		if err != nil {
+			return nil, err
+		}
+		command = rendered
+	}
+
+	ctx, cancel := context.WithTimeout(r.ctx, timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	cmd.WaitDelay = hookWaitDelay
+	cmd.Env = append(os.Environ(),
+		"K8S_SETUP_HOST="+s.VMIP,
+		"K8S_SETUP_STEP="+step,
+		"K8S_SETUP_WHEN="+when,
+		"K8S_SETUP_STATUS="+s.Status,
+		"K8S_SETUP_COMPLETED_STEPS="+strings.Join(s.CompletedSteps, ","),
+		"K8S_SETUP_STATUS_FILE="+filepath.Join(status.Dir, s.VMIP+".json"),
+		"K8S_SETUP_KUBECONFIG="+s.Kubeconfig,
+	)
+	output, err := cmd.CombinedOutput()
+	if ctx.Err() == context.DeadlineExceeded {
+		return output, fmt.Errorf("%s: timed out after %s", hook.Command, timeout)
+	}
+	if err != nil {
+		return output, fmt.Errorf("%s: %v", hook.Command, err)
+	}
+	return output, nil
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/maarulav/k8s-setup/internal/status"
+	"github.com/maarulav/k8s-setup/pkg/config"
+)
+
+// hookRun returns a run of the given hooks and the status of its host
+func hookRun(hooks ...config.Hook) (*run, *status.SetupStatus) {
+	cfg := &config.Config{Hooks: hooks}
+	s := status.New("10.0.0.1")
+	s.Status = "InProgress"
+	s.CompletedSteps = []string{"kubernetes", "roles"}
+	return &run{cfg: cfg, ctx: context.Background()}, s
+}
+
+func TestRunHooks(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "env")
+	r, s := hookRun(
+		config.Hook{Step: "monitoring", When: config.HookBefore, Command: `echo "$K8S_SETUP_HOST $K8S_SETUP_STEP $K8S_SETUP_WHEN $K8S_SETUP_COMPLETED_STEPS" > ` + out},
+		// Hooks of other steps and after hooks are left out
+		config.Hook{Step: "charts", When: config.HookBefore, Command: "exit 1", Required: true},
+		config.Hook{Step: "*", Command: "exit 1", Required: true},
+	)
+
+	if err := r.runHooks(s, config.HookBefore, "monitoring"); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.TrimSpace(string(data)); got != "10.0.0.1 monitoring before kubernetes,roles" {
+		t.Errorf("hook environment %q", got)
+	}
+
+	// Failing after hooks are only warnings, even when required
+	if err := r.runHooks(s, config.HookAfter, "monitoring"); err != nil {
+		t.Errorf("after hook failed the host: %v", err)
+	}
+}
+
+func TestRunHooksRequired(t *testing.T) {
+	r, s := hookRun(
+		config.Hook{Step: "kubernetes", When: config.HookBefore, Command: "echo no capacity; exit 3", Required: true},
+	)
+	err := r.runHooks(s, config.HookBefore, "kubernetes")
+	if err == nil || !strings.Contains(err.Error(), "exit status 3") {
+		t.Errorf("error %v, want the exit status of the required hook", err)
+	}
+
+	r.cfg.Hooks[0].Required = false
+	if err := r.runHooks(s, config.HookBefore, "kubernetes"); err != nil {
+		t.Errorf("optional hook failed the host: %v", err)
+	}
+}
+
+func TestRunHooksTimeout(t *testing.T) {
+	r, s := hookRun(
+		config.Hook{Step: "kubernetes", When: config.HookBefore, Command: "sleep 30", Required: true, Timeout: "200ms"},
+	)
+
+	start := time.Now()
+	err := r.runHooks(s, config.HookBefore, "kubernetes")
+	if err == nil || !strings.Contains(err.Error(), "timed out after 200ms") {
+		t.Errorf("error %v, want the hook timed out", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("hook ran for %s despite its timeout", elapsed)
+	}
+
+	// A background child keeps the output open after the shell was killed
+	r.cfg.Hooks[0].Command = "sleep 30 & echo started; wait"
+	start = time.Now()
+	err = r.runHooks(s, config.HookBefore, "kubernetes")
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("error %v, want the hook timed out", err)
+	}
+	if elapsed := time.Since(start); elapsed > 200*time.Millisecond+hookWaitDelay+3*time.Second {
+		t.Errorf("hook with a background child ran for %s despite its timeout", elapsed)
+	}
+
+	r.cfg.Hooks[0].Timeout = "soon"
+	if err := r.runHooks(s, config.HookBefore, "kubernetes"); err == nil || !strings.Contains(err.Error(), `invalid timeout "soon"`) {
+		t.Errorf("error %v, want the invalid timeout", err)
+	}
+}
+
+func TestRunHooksTemplate(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "out")
+	r, s := hookRun(
+		config.Hook{Step: "roles", Command: "echo {{ .zone }} {{ .ip }} > " + out, Template: true},
+	)
+	r.cfg.Vars = map[string]string{"zone": "eu-1a"}
+
+	if err := r.runHooks(s, config.HookAfter, "roles"); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := ioutil.ReadFile(out); err != nil || strings.TrimSpace(string(data)) != "eu-1a 10.0.0.1" {
+		t.Errorf("templated hook wrote %q, %v", data, err)
+	}
+
+	// Without template the braces reach the shell as written
+	r.cfg.Hooks[0].Template = false
+	if err := r.runHooks(s, config.HookAfter, "roles"); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := ioutil.ReadFile(out); err != nil || strings.TrimSpace(string(data)) != "{{ .zone }} {{ .ip }}" {
+		t.Errorf("plain hook wrote %q, %v", data, err)
+	}
+
+	// An undefined variable fails the hook instead of rendering empty
+	r.cfg.Hooks[0] = config.Hook{Step: "roles", When: config.HookBefore, Command: "echo {{ .rack }}", Template: true, Required: true}
+	if err := r.runHooks(s, config.HookBefore, "roles"); err == nil || !strings.Contains(err.Error(), "rack") {
+		t.Errorf("error %v, want the undefined variable", err)
+	}
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/maarulav/k8s-setup/internal/status"
+	"github.com/maarulav/k8s-setup/pkg/config"
+	"github.com/maarulav/k8s-setup/pkg/kubernetes"
+	"github.com/maarulav/k8s-setup/pkg/ssh"
+)
+
+// runJoin prints the join command of a cluster, or runs it on a new node.
+// Join tokens and certificate keys are reused from the status file while
+// they are valid and regenerated once they expire.
+func runJoin(args []string) error {
+	flags := flag.NewFlagSet("join", flag.ExitOnError)
+	controlPlane := flags.Bool("control-plane", false, "join as an additional control plane node")
+	node := flags.String("run", "", "run the join command on this host instead of printing it")
+	windows := flags.Bool("windows", false, "prepare and join the --run host as a Windows Server worker (experimental)")
+	forceUnlock := flags.Bool("force-unlock", false, "break the locks other runs hold on the hosts")
+	flags.Parse(args)
+
+	if flags.NArg() != 2 {
+		return fmt.Errorf("usage: join [--control-plane] [--run node-ip] [--windows] [--force-unlock] <config.json> <ip>")
+	}
+	if *windows && (*node == "" || *controlPlane) {
+		return fmt.Errorf("--windows joins the --run host as a worker")
+	}
+	cfg, client, err := connect(flags.Arg(0), flags.Arg(1))
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	ip := client.IP
+	if *node != "" {
+		if *node, err = resolveTarget(cfg, *node); err != nil {
+			return err
+		}
+	}
+
+	s, err := status.Load(ip)
+	if err != nil {
+		s = status.New(ip)
+	}
+
+	command, err := joinCommand(client, s, *controlPlane)
+	if err != nil {
+		return err
+	}
+
+	if *node == "" {
+		fmt.Println(command)
+		return nil
+	}
+
+	unlock, err := lockHosts(cfg, []string{ip, *node}, "join", *forceUnlock)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if *windows {
+		return joinWindows(cfg, client, *node, command)
+	}
+
+	nodeClient, err := ssh.Connect(vmConfig(cfg, *node))
+	if err != nil {
+		return fmt.Errorf("SSH connection to %s failed: %v", *node, err)
+	}
+	defer nodeClient.Close()
+
+	roles, err := cfg.HostRoles(*node)
+	if err != nil {
+		return err
+	}
+
+	if output, err := nodeClient.ExecuteCommand(command); err != nil {
+		return fmt.Errorf("join failed on %s: %v\nOutput: %s", *node, err, output)
+	}
+	fmt.Printf("Node %s joined cluster %s\n", *node, ip)
+	if err := labelCluster(cfg, client, nodeClient); err != nil {
+		return fmt.Errorf("failed to label %s with the cluster name: %v", *node, err)
+	}
+	recordJoin(*node, nodeClient)
+
+	if len(roles) > 0 {
+		fmt.Printf("Setting up roles %s on %s\n", strings.Join(roles, ", "), *node)
+		if err := kubernetes.SetupRoles(client, nodeClient, cfg, roles); err != nil {
+			return fmt.Errorf("role setup failed on %s: %v", *node, err)
+		}
+		if err := kubernetes.TaintRoles(client, nodeClient, cfg, roles); err != nil {
+			return fmt.Errorf("role setup failed on %s: %v", *node, err)
+		}
+	}
+	return nil
+}
+
+// joinWindows prepares the cluster network and a Windows Server worker
+// reached over SSH-on-Windows, then joins the worker
+func joinWindows(cfg *config.Config, controlPlane *ssh.Client, node, command string) error {
+	fmt.Println("Preparing the cluster network for Windows workers")
+	if err := kubernetes.SetupWindowsNetworking(controlPlane, cfg); err != nil {
+		return err
+	}
+
+	// Windows has no sudo, the SSH user has to be an administrator. Its
+	// OpenSSH server runs the PowerShell commands as sent, there is no sh.
+	vm := vmConfig(cfg, node)
+	vm.Sudo = false
+	vm.Shell = config.ShellLogin
+	nodeClient, err := ssh.Connect(vm)
+	if err != nil {
+		return fmt.Errorf("SSH connection to %s failed: %v", node, err)
+	}
+	defer nodeClient.Close()
+
+	fmt.Printf("Installing containerd and kubelet on %s\n", node)
+	if err := kubernetes.PrepareWindowsNode(nodeClient, cfg); err != nil {
+		return err
+	}
+
+	if output, err := nodeClient.ExecuteCommand(kubernetes.WindowsJoinCommand(command)); err != nil {
+		return fmt.Errorf("join failed on %s: %v\nOutput: %s", node, err, output)
+	}
+	fmt.Printf("Windows node %s joined cluster %s\n", node, controlPlane.IP)
+	if err := labelCluster(cfg, controlPlane, nodeClient); err != nil {
+		return fmt.Errorf("failed to label %s with the cluster name: %v", node, err)
+	}
+	return nil
+}
+
+// recordJoin records the node at ip as joined in the status store, with the
+// facts of the host behind client. The status store only informs here,
+// failures to update it are warnings.
+func recordJoin(ip string, client ssh.Executor) {
+	s, err := status.Load(ip)
+	if err != nil {
+		s = status.New(ip)
+	}
+	s.CurrentStep, s.Status, s.Error = "join", "Completed", ""
+	s.EndTime = time.Now()
+	if !s.HasCompleted("join") {
+		s.CompletedSteps = append(s.CompletedSteps, "join")
+	}
+	collectFacts(log.Printf, s, client)
+	if err := s.Save(); err != nil {
+		log.Printf("Warning: failed to save the status of %s: %v", ip, err)
+	}
+}
+
+// labelCluster labels the node behind node with the name configured for the
+// cluster of controlPlane, as Setup does for the first control plane. Clusters
+// without a configured name keep the node unlabeled.
+func labelCluster(cfg *config.Config, controlPlane, node ssh.Executor) error {
+	name := cfg.ClusterName(controlPlane.Host())
+	if name == controlPlane.Host() {
+		return nil
+	}
+	return kubernetes.LabelCluster(controlPlane, node, name)
+}
+
+// joinCommand returns the stored join command when it is still valid, or
+// creates a new one and stores it encrypted
+func joinCommand(client *ssh.Client, s *status.SetupStatus, controlPlane bool) (string, error) {
+	var command string
+	if s.JoinCommand.Valid() {
+		if stored, err := s.JoinCommand.Open(); err == nil {
+			command = stored
+		} else {
+			log.Printf("Warning: %v, creating a new join token", err)
+		}
+	}
+
+	changed := false
+	if command == "" {
+		created, expires, err := kubernetes.CreateJoinCommand(client)
+		if err != nil {
+			return "", err
+		}
+		command = created
+		s.JoinCommand = seal(created, expires)
+		changed = true
+	}
+
+	if controlPlane {
+		var key string
+		if s.CertificateKey.Valid() {
+			key, _ = s.CertificateKey.Open()
+		}
+		if key == "" {
+			created, expires, err := kubernetes.UploadCertificates(client)
+			if err != nil {
+				return "", err
+			}
+			key = created
+			s.CertificateKey = seal(created, expires)
+			changed = true
+		}
+		command += " --control-plane --certificate-key " + key
+	}
+
+	if changed {
+		if err := os.MkdirAll(status.Dir, 0755); err != nil {
+			return "", err
+		}
+		if err := s.Save(); err != nil {
+			log.Printf("Warning: failed to save status: %v", err)
+		}
+	}
+
+	return command, nil
+}
+
+// seal encrypts a secret for the status file, or leaves it out when no
+// status key is configured
+func seal(value string, expires time.Time) *status.Secret {
+	secret, err := status.Seal(value, expires)
+	if err != nil {
+		log.Printf("Warning: %v", err)
+		return nil
+	}
+	return secret
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/maarulav/k8s-setup/internal/status"
+	"github.com/maarulav/k8s-setup/internal/testutil"
+	"github.com/maarulav/k8s-setup/pkg/config"
+	"github.com/maarulav/k8s-setup/pkg/kubernetes"
+	"github.com/maarulav/k8s-setup/pkg/ssh"
+)
+
+func TestJoinWindows(t *testing.T) {
+	cp := testutil.NewSSHServer(t)
+	node := testutil.NewSSHServer(t).Respond("Install-WindowsFeature", "No\r\n")
+
+	cfg := &config.Config{}
+	// The Linux control plane is probed for bash, the Windows node must not be
+	cfg.SSHConfig.Shell = config.ShellAuto
+	cfg.SSHConfig.ConfigFile = "none"
+	cfg.SSHConfig.Timeout = config.Duration(5 * time.Second)
+	cfg.SSHConfig.Username = testutil.SSHUser
+	cfg.SSHConfig.Password = testutil.SSHPassword
+	cfg.Kubernetes.CNI = kubernetes.CNIFlannel
+	cfg.Hosts = []config.Host{
+		{IP: "localhost", Port: cp.VMConfig().Port},
+		{IP: "127.0.0.1", Port: node.VMConfig().Port},
+	}
+
+	controlPlane, err := ssh.Connect(vmConfig(cfg, "localhost"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer controlPlane.Close()
+
+	join := "kubeadm join 10.0.0.10:6443 --token abcdef.0123456789abcdef --discovery-token-ca-cert-hash sha256:0123"
+	if err := joinWindows(cfg, controlPlane, "127.0.0.1", join); err != nil {
+		t.Fatal(err)
+	}
+
+	commands := node.Commands()
+	if len(commands) != 6 {
+		t.Fatalf("node received %d commands, want the feature check, containerd, the kubelet and the join:\n%s", len(commands), strings.Join(commands, "\n"))
+	}
+	for _, command := range commands {
+		if !strings.HasPrefix(command, "powershell -NoProfile -NonInteractive ") {
+			t.Errorf("command %q does not reach PowerShell as sent", command)
+		}
+	}
+	if want := kubernetes.WindowsJoinCommand(join); commands[5] != want {
+		t.Errorf("join command\n%s\nwant\n%s", commands[5], want)
+	}
+}
+
+func TestLabelCluster(t *testing.T) {
+	cfg := &config.Config{Hosts: []config.Host{{IP: "10.0.0.1", ClusterName: "edge-1"}}}
+	controlPlane := testutil.NewExecutor("10.0.0.1")
+	node := testutil.NewExecutor("10.0.0.2").Respond("hostname", "worker-1\n")
+	if err := labelCluster(cfg, controlPlane, node); err != nil {
+		t.Fatal(err)
+	}
+	want := "kubectl label node worker-1 " + kubernetes.ClusterLabel + "=edge-1 --overwrite"
+	if commands := controlPlane.Commands(); len(commands) != 1 || commands[0] != want {
+		t.Errorf("got %v, want %q", commands, want)
+	}
+
+	// A cluster known by its IP keeps the node unlabeled
+	unnamed := testutil.NewExecutor("10.0.0.3")
+	if err := labelCluster(cfg, unnamed, node); err != nil || len(unnamed.Commands()) != 0 {
+		t.Errorf("unnamed cluster: %v, %v", unnamed.Commands(), err)
+	}
+}
+
+func TestRecordJoin(t *testing.T) {
+	t.Chdir(t.TempDir())
+	if err := os.MkdirAll(status.Dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	node := testutil.NewExecutor("10.0.0.2").
+		Respond("os-release", "os=Debian GNU/Linux 12 (bookworm)\ncpus=2\nmemory=2000000\ndisk=1000\ndiskFree=500\ncontainerd=containerd containerd.io 1.7.22 7f7f\nkubelet=Kubernetes v1.31.2\n")
+	recordJoin("10.0.0.2", node)
+
+	s, err := status.Load("10.0.0.2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Status != "Completed" || !s.HasCompleted("join") || s.Kubeconfig != "" {
+		t.Errorf("status %+v, want a completed join without a kubeconfig", s)
+	}
+	if s.Facts == nil || s.Facts.Kubelet != "v1.31.2" || s.Facts.ContainerRuntime != "containerd 1.7.22" {
+		t.Errorf("facts %+v", s.Facts)
+	}
+
+	// Joining again keeps a single join step
+	recordJoin("10.0.0.2", node)
+	if s, err = status.Load("10.0.0.2"); err != nil || len(s.CompletedSteps) != 1 {
+		t.Errorf("completed steps %v, %v", s.CompletedSteps, err)
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+
+	"github.com/maarulav/k8s-setup/internal/status"
+	"github.com/maarulav/k8s-setup/pkg/kubeconfig"
+)
+
+// runKubeconfig dispatches the kubeconfig subcommands
+func runKubeconfig(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: kubeconfig merge|use ...")
+	}
+
+	switch args[0] {
+	case "merge":
+		return kubeconfigMerge(args[1:])
+	case "use":
+		return kubeconfigUse(args[1:])
+	default:
+		return fmt.Errorf("unknown kubeconfig command %q", args[0])
+	}
+}
+
+// kubeconfigMerge merges the saved cluster kubeconfigs into the user's kubeconfig
+func kubeconfigMerge(args []string) error {
+	flags := flag.NewFlagSet("kubeconfig merge", flag.ExitOnError)
+	target := flags.String("kubeconfig", kubeconfig.DefaultPath(), "kubeconfig file to merge into")
+	flags.Parse(args)
+
+	statuses, err := selectClusters(flags.Args())
+	if err != nil {
+		return err
+	}
+
+	dst, err := kubeconfig.Load(*target)
+	if err != nil {
+		return err
+	}
+
+	merged := 0
+	for _, s := range statuses {
+		if s.Kubeconfig == "" {
+			continue
+		}
+
+		data, err := ioutil.ReadFile(s.Kubeconfig)
+		if err != nil {
+			return fmt.Errorf("cluster %s: %v", s.VMIP, err)
+		}
+		src, err := kubeconfig.Parse(data)
+		if err != nil {
+			return fmt.Errorf("cluster %s: %v", s.VMIP, err)
+		}
+
+		name := s.VMIP
+		if s.ClusterName != "" {
+			name = s.ClusterName
+		}
+		context, err := dst.Merge(src, name)
+		if err != nil {
+			return fmt.Errorf("cluster %s: %v", s.VMIP, err)
+		}
+
+		s.Context = context
+		if err := s.Save(); err != nil {
+			return err
+		}
+		fmt.Printf("Merged cluster %s as context %s\n", s.VMIP, context)
+		merged++
+	}
+
+	if merged == 0 {
+		return fmt.Errorf("no saved kubeconfigs found in %s", status.Dir)
+	}
+
+	return dst.Save(*target)
+}
+
+// kubeconfigUse switches to a cluster's context or prints the export command
+// that points KUBECONFIG at its saved kubeconfig
+func kubeconfigUse(args []string) error {
+	flags := flag.NewFlagSet("kubeconfig use", flag.ExitOnError)
+	target := flags.String("kubeconfig", kubeconfig.DefaultPath(), "kubeconfig file to switch the context in")
+	print := flags.Bool("print", false, "print an export command instead of changing the current context")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		return fmt.Errorf("usage: kubeconfig use [--print] <cluster>")
+	}
+
+	s, err := status.Load(flags.Arg(0))
+	if err != nil {
+		return fmt.Errorf("unknown cluster %s: %v", flags.Arg(0), err)
+	}
+
+	if *print {
+		if s.Kubeconfig == "" {
+			return fmt.Errorf("no kubeconfig saved for cluster %s", s.VMIP)
+		}
+		path, err := filepath.Abs(s.Kubeconfig)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("export KUBECONFIG=%s\n", path)
+		return nil
+	}
+
+	context := s.Context
+	if context == "" {
+		return fmt.Errorf("cluster %s has not been merged, run kubeconfig merge first", s.VMIP)
+	}
+
+	cfg, err := kubeconfig.Load(*target)
+	if err != nil {
+		return err
+	}
+	if err := cfg.Use(context); err != nil {
+		return err
+	}
+	if err := cfg.Save(*target); err != nil {
+		return err
+	}
+
+	fmt.Printf("Switched to context %s\n", context)
+	return nil
+}
+
+// selectClusters loads the statuses of the named clusters, or of every known
+// cluster when none are given
+func selectClusters(names []string) ([]*status.SetupStatus, error) {
+	if len(names) == 0 {
+		return status.List()
+	}
+
+	var statuses []*status.SetupStatus
+	for _, name := range names {
+		s, err := status.Load(name)
+		if err != nil {
+			return nil, fmt.Errorf("unknown cluster %s: %v", name, err)
+		}
+		statuses = append(statuses, s)
+	}
+	return statuses, nil
+}
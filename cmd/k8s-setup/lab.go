@@ -0,0 +1,382 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/maarulav/k8s-setup/internal/logger"
+	"github.com/maarulav/k8s-setup/internal/status"
+	"github.com/maarulav/k8s-setup/pkg/config"
+	"github.com/maarulav/k8s-setup/pkg/kubernetes"
+	"github.com/maarulav/k8s-setup/pkg/ssh"
+)
+
+// labVM is a booted lab node and the address it is reachable on
+type labVM struct {
+	config.LabNode
+	IP string
+}
+
+// runLab dispatches the lab subcommands
+func runLab(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("unknown lab command, expected: lab up|down")
+	}
+	switch args[0] {
+	case "up":
+		return labUp(args[1:])
+	case "down":
+		return labDown(args[1:])
+	default:
+		return fmt.Errorf("unknown lab command, expected: lab up|down")
+	}
+}
+
+// labUp boots the lab VMs, provisions the control plane with the normal
+// pipeline and joins the workers to it
+func labUp(args []string) error {
+	flags := flag.NewFlagSet("lab up", flag.ExitOnError)
+	forceUnlock := flags.Bool("force-unlock", false, "break the lock another run holds on the lab")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		return fmt.Errorf("usage: lab up [--force-unlock] <config.json>")
+	}
+
+	cfg, err := config.LoadConfig(flags.Arg(0))
+	if err != nil {
+		return fmt.Errorf("failed to load configuration: %v", err)
+	}
+	unlock, err := lockHosts(cfg, []string{labName(cfg.Lab)}, "lab up", *forceUnlock)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	nodes, err := cfg.Lab.LabNodes()
+	if err != nil {
+		return err
+	}
+
+	dir := labDir(cfg.Lab)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	keyFile, publicKey, err := labKey(dir)
+	if err != nil {
+		return err
+	}
+
+	var vms []labVM
+	username := "ubuntu"
+	switch labProvider(cfg.Lab) {
+	case "multipass":
+		vms, err = bootMultipass(cfg.Lab, nodes, dir, publicKey)
+	case "vagrant":
+		username = "vagrant"
+		vms, err = bootVagrant(cfg.Lab, nodes, dir, publicKey)
+	default:
+		err = fmt.Errorf("unknown lab provider %q, expected multipass or vagrant", cfg.Lab.Provider)
+	}
+	if err != nil {
+		return err
+	}
+
+	// The lab VMs share one key and user, with sudo for root
+	cfg.SSHConfig.Username = username
+	cfg.SSHConfig.Password = ""
+	cfg.SSHConfig.KeyFile = keyFile
+	cfg.SSHConfig.CertFile = ""
+	cfg.SSHConfig.Sudo = true
+	cfg.Hosts = nil
+	var controlPlane string
+	var workers []string
+	for _, vm := range vms {
+		fmt.Printf("Lab node %s (%s) is up at %s\n", vm.Name, vm.Role, vm.IP)
+		cfg.Hosts = append(cfg.Hosts, config.Host{IP: vm.IP})
+		if vm.Role == config.LabControlPlane {
+			controlPlane = vm.IP
+		} else {
+			workers = append(workers, vm.IP)
+		}
+	}
+
+	// Vagrant puts its NAT interface first, so the nodes have to register
+	// with their private network address
+	pinAddress := labProvider(cfg.Lab) == "vagrant"
+	if pinAddress {
+		cfg.Kubernetes.AdvertiseAddress = controlPlane
+	}
+
+	// Keep a configuration for the other commands, e.g. ping or upgrade
+	labConfig := filepath.Join(dir, "config.json")
+	data, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(labConfig, data, 0600); err != nil {
+		return err
+	}
+	fmt.Printf("Lab configuration written to %s\n", labConfig)
+
+	if err := waitForSSH(cfg, append([]string{controlPlane}, workers...)); err != nil {
+		return err
+	}
+
+	results, err := provision(logger.New(), cfg, []string{controlPlane}, options{})
+	if err != nil {
+		return err
+	}
+	if results[controlPlane] != "Completed" {
+		return fmt.Errorf("control plane %s: %s", controlPlane, results[controlPlane])
+	}
+
+	for _, worker := range workers {
+		if err := joinLabWorker(cfg, controlPlane, worker, pinAddress); err != nil {
+			return err
+		}
+	}
+
+	fmt.Printf("Lab cluster is ready, kubeconfig: %s\n", status.KubeconfigPath(controlPlane))
+	return nil
+}
+
+// joinLabWorker installs the Kubernetes packages on a worker and joins it.
+// With pinAddress the kubelet registers with the worker's lab address.
+func joinLabWorker(cfg *config.Config, controlPlane, worker string, pinAddress bool) error {
+	cpClient, err := ssh.Connect(vmConfig(cfg, controlPlane))
+	if err != nil {
+		return fmt.Errorf("SSH connection to %s failed: %v", controlPlane, err)
+	}
+	defer cpClient.Close()
+
+	client, err := ssh.Connect(vmConfig(cfg, worker))
+	if err != nil {
+		return fmt.Errorf("SSH connection to %s failed: %v", worker, err)
+	}
+	defer client.Close()
+
+	fmt.Printf("Preparing worker %s\n", worker)
+	if !cfg.Kubernetes.Prebaked {
+		if err := kubernetes.PrepareNode(client, cfg); err != nil {
+			return fmt.Errorf("worker %s: %v", worker, err)
+		}
+	}
+	if pinAddress {
+		kubeletArgs := []byte(fmt.Sprintf("KUBELET_EXTRA_ARGS=--node-ip=%s\n", worker))
+		if err := client.WriteFile("/etc/default/kubelet", kubeletArgs, 0644); err != nil {
+			return fmt.Errorf("worker %s: %v", worker, err)
+		}
+	}
+
+	s, err := status.Load(controlPlane)
+	if err != nil {
+		s = status.New(controlPlane)
+	}
+	command, err := joinCommand(cpClient, s, false)
+	if err != nil {
+		return err
+	}
+	if output, err := client.ExecuteCommand(command); err != nil {
+		return fmt.Errorf("join failed on %s: %v\nOutput: %s", worker, err, output)
+	}
+	fmt.Printf("Worker %s joined cluster %s\n", worker, controlPlane)
+	return nil
+}
+
+// waitForSSH waits until every lab VM accepts the lab key
+func waitForSSH(cfg *config.Config, ips []string) error {
+	for _, ip := range ips {
+		var err error
+		for i := 0; i < 60; i++ {
+			var client *ssh.Client
+			if client, err = ssh.Connect(vmConfig(cfg, ip)); err == nil {
+				client.Close()
+				break
+			}
+			time.Sleep(5 * time.Second)
+		}
+		if err != nil {
+			return fmt.Errorf("lab node %s is not reachable over SSH: %v", ip, err)
+		}
+	}
+	return nil
+}
+
+// labDown deletes the lab VMs and their working directory
+func labDown(args []string) error {
+	flags := flag.NewFlagSet("lab down", flag.ExitOnError)
+	forceUnlock := flags.Bool("force-unlock", false, "break the lock another run holds on the lab")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		return fmt.Errorf("usage: lab down [--force-unlock] <config.json>")
+	}
+
+	cfg, err := config.LoadConfig(flags.Arg(0))
+	if err != nil {
+		return fmt.Errorf("failed to load configuration: %v", err)
+	}
+	unlock, err := lockHosts(cfg, []string{labName(cfg.Lab)}, "teardown", *forceUnlock)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	nodes, err := cfg.Lab.LabNodes()
+	if err != nil {
+		return err
+	}
+
+	dir := labDir(cfg.Lab)
+	switch labProvider(cfg.Lab) {
+	case "multipass":
+		args := []string{"delete", "--purge"}
+		for _, n := range nodes {
+			args = append(args, labVMName(cfg.Lab, n))
+		}
+		err = runLocalCommand(exec.Command("multipass", args...))
+	case "vagrant":
+		cmd := exec.Command("vagrant", "destroy", "-f")
+		cmd.Dir = dir
+		err = runLocalCommand(cmd)
+	default:
+		err = fmt.Errorf("unknown lab provider %q, expected multipass or vagrant", cfg.Lab.Provider)
+	}
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(dir)
+}
+
+func labProvider(lab config.Lab) string {
+	if lab.Provider == "" {
+		return "multipass"
+	}
+	return lab.Provider
+}
+
+func labName(lab config.Lab) string {
+	if lab.Name == "" {
+		return "k8s-lab"
+	}
+	return lab.Name
+}
+
+// labDir holds the key, the generated configuration and the Vagrantfile
+func labDir(lab config.Lab) string {
+	return filepath.Join(status.Dir, labName(lab))
+}
+
+func labVMName(lab config.Lab, node config.LabNode) string {
+	return labName(lab) + "-" + node.Name
+}
+
+// labKey returns the private key file of the lab and its public key in
+// authorized_keys format, generating an ed25519 key pair on first use
+func labKey(dir string) (string, string, error) {
+	keyFile := filepath.Join(dir, "id_ed25519")
+	if data, err := ioutil.ReadFile(keyFile + ".pub"); err == nil {
+		return keyFile, strings.TrimSpace(string(data)), nil
+	}
+
+	authorized, err := ssh.GenerateKey(keyFile, "k8s-setup lab")
+	if err != nil {
+		return "", "", fmt.Errorf("failed to generate lab key: %v", err)
+	}
+	return keyFile, authorized, nil
+}
+
+// bootMultipass launches the missing lab VMs with the key injected through
+// cloud-init and reads their addresses
+func bootMultipass(lab config.Lab, nodes []config.LabNode, dir, publicKey string) ([]labVM, error) {
+	image := lab.Image
+	if image == "" {
+		image = "22.04"
+	}
+	cloudInit := filepath.Join(dir, "cloud-init.yaml")
+	if err := ioutil.WriteFile(cloudInit, []byte("#cloud-config\nssh_authorized_keys:\n  - "+publicKey+"\n"), 0600); err != nil {
+		return nil, err
+	}
+
+	var vms []labVM
+	for _, n := range nodes {
+		name := labVMName(lab, n)
+		if err := exec.Command("multipass", "info", name).Run(); err != nil {
+			memory, _ := config.ParseMemory(n.Memory)
+			disk, _ := config.ParseMemory(n.Disk)
+			err := runLocalCommand(exec.Command("multipass", "launch", "--name", name,
+				"--cpus", fmt.Sprint(n.CPUs),
+				"--memory", fmt.Sprintf("%dM", memory>>20),
+				"--disk", fmt.Sprintf("%dM", disk>>20),
+				"--cloud-init", cloudInit, image))
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		output, err := exec.Command("multipass", "info", name, "--format", "json").Output()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read address of %s: %v", name, err)
+		}
+		var info struct {
+			Info map[string]struct {
+				IPv4 []string `json:"ipv4"`
+			} `json:"info"`
+		}
+		if err := json.Unmarshal(output, &info); err != nil {
+			return nil, fmt.Errorf("failed to parse multipass info of %s: %v", name, err)
+		}
+		if len(info.Info[name].IPv4) == 0 {
+			return nil, fmt.Errorf("lab VM %s has no IPv4 address", name)
+		}
+		vms = append(vms, labVM{LabNode: n, IP: info.Info[name].IPv4[0]})
+	}
+	return vms, nil
+}
+
+// bootVagrant writes a Vagrantfile with a static private address per node and
+// brings the VMs up
+func bootVagrant(lab config.Lab, nodes []config.LabNode, dir, publicKey string) ([]labVM, error) {
+	box := lab.Image
+	if box == "" {
+		box = "ubuntu/jammy64"
+	}
+	network := lab.Network
+	if network == "" {
+		network = "192.168.56"
+	}
+
+	var vms []labVM
+	var b strings.Builder
+	fmt.Fprintf(&b, "Vagrant.configure(\"2\") do |config|\n")
+	fmt.Fprintf(&b, "  config.vm.box = %q\n", box)
+	fmt.Fprintf(&b, "  config.vm.provision \"shell\", inline: \"echo '%s' >> /home/vagrant/.ssh/authorized_keys\"\n", publicKey)
+	for i, n := range nodes {
+		ip := fmt.Sprintf("%s.%d", network, 10+i)
+		memory, _ := config.ParseMemory(n.Memory)
+		fmt.Fprintf(&b, "  config.vm.define %q do |node|\n", n.Name)
+		fmt.Fprintf(&b, "    node.vm.hostname = %q\n", labVMName(lab, n))
+		fmt.Fprintf(&b, "    node.vm.network \"private_network\", ip: %q\n", ip)
+		fmt.Fprintf(&b, "    node.vm.provider \"virtualbox\" do |vb|\n")
+		fmt.Fprintf(&b, "      vb.cpus = %d\n", n.CPUs)
+		fmt.Fprintf(&b, "      vb.memory = %d\n", memory>>20)
+		fmt.Fprintf(&b, "    end\n")
+		fmt.Fprintf(&b, "  end\n")
+		vms = append(vms, labVM{LabNode: n, IP: ip})
+	}
+	fmt.Fprintf(&b, "end\n")
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "Vagrantfile"), []byte(b.String()), 0644); err != nil {
+		return nil, err
+	}
+	cmd := exec.Command("vagrant", "up")
+	cmd.Dir = dir
+	if err := runLocalCommand(cmd); err != nil {
+		return nil, err
+	}
+	return vms, nil
+}
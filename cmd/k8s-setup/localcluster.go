@@ -0,0 +1,162 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/maarulav/k8s-setup/internal/events"
+	"github.com/maarulav/k8s-setup/internal/logger"
+	"github.com/maarulav/k8s-setup/internal/status"
+	"github.com/maarulav/k8s-setup/internal/tracing"
+	"github.com/maarulav/k8s-setup/pkg/config"
+	"github.com/maarulav/k8s-setup/pkg/local"
+)
+
+// runLocal dispatches the local development cluster subcommands
+func runLocal(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("unknown local command, expected: local up|down")
+	}
+	switch args[0] {
+	case "up":
+		return localUp(args[1:])
+	case "down":
+		return localDown(args[1:])
+	default:
+		return fmt.Errorf("unknown local command, expected: local up|down")
+	}
+}
+
+// localUp creates a kind or minikube cluster on this machine and runs the
+// cluster steps of the configuration against it, without any SSH target
+func localUp(args []string) error {
+	flags := flag.NewFlagSet("local up", flag.ExitOnError)
+	name := flags.String("name", "k8s-setup", "name of the local cluster")
+	provider := flags.String("provider", "kind", "local cluster provider: kind or minikube")
+	image := flags.String("image", "", "kind node image, e.g. kindest/node:v1.30.0")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		return fmt.Errorf("usage: local up [--name name] [--provider kind|minikube] [--image image] <config.json>")
+	}
+
+	cfg, err := config.LoadConfig(flags.Arg(0))
+	if err != nil {
+		return fmt.Errorf("failed to load configuration: %v", err)
+	}
+	if err := os.MkdirAll(status.Dir, 0755); err != nil {
+		return fmt.Errorf("failed to create status directory: %v", err)
+	}
+
+	id := "local-" + *name
+	kubeconfig := status.KubeconfigPath(id)
+	if err := createLocalCluster(*provider, *name, *image, kubeconfig); err != nil {
+		return err
+	}
+
+	flush := tracing.Setup(cfg.Tracing)
+	defer flush()
+	r := newRun(cfg)
+	defer r.end()
+
+	log := logger.New()
+	s := status.New(id)
+	s.Kubeconfig = kubeconfig
+	log.SetStatus(s)
+	log.Printf("Starting setup for local cluster %s", *name)
+
+	r.events.Emit(events.RunStarted, "", map[string]interface{}{"hosts": []string{id}})
+	r.startHost(id)
+	defer func() { r.events.Emit(events.RunFinished, "", map[string]interface{}{"results": r.results}) }()
+
+	cluster, err := local.New(id, kubeconfig, s.WorkDir())
+	if err != nil {
+		s.Status = "Failed"
+		s.Error = fmt.Sprintf("Local cluster access failed: %v", err)
+		r.finish(s)
+		return err
+	}
+	r.trace(cluster)
+
+	if !setupCluster(r, log, s, cfg, nil, cluster, []string{id}) {
+		return fmt.Errorf("%s", s.Error)
+	}
+
+	s.Status = "Completed"
+	s.EndTime = time.Now()
+	r.finish(s)
+	log.Printf("Local cluster %s is ready, kubeconfig: %s", *name, kubeconfig)
+	return nil
+}
+
+// localDown deletes a local cluster together with its status and kubeconfig
+func localDown(args []string) error {
+	flags := flag.NewFlagSet("local down", flag.ExitOnError)
+	name := flags.String("name", "k8s-setup", "name of the local cluster")
+	provider := flags.String("provider", "kind", "local cluster provider: kind or minikube")
+	flags.Parse(args)
+
+	var cmd *exec.Cmd
+	switch *provider {
+	case "kind":
+		cmd = exec.Command("kind", "delete", "cluster", "--name", *name)
+	case "minikube":
+		cmd = exec.Command("minikube", "delete", "-p", *name)
+	default:
+		return fmt.Errorf("unknown provider %q, expected kind or minikube", *provider)
+	}
+	if err := runLocalCommand(cmd); err != nil {
+		return err
+	}
+
+	return status.Remove("local-" + *name)
+}
+
+// createLocalCluster starts the named cluster unless it already runs and
+// writes its kubeconfig to kubeconfig
+func createLocalCluster(provider, name, image, kubeconfig string) error {
+	switch provider {
+	case "kind":
+		output, err := exec.Command("kind", "get", "clusters").Output()
+		if err != nil {
+			return fmt.Errorf("failed to list kind clusters, is kind installed? %v", err)
+		}
+		for _, existing := range strings.Fields(string(output)) {
+			if existing == name {
+				fmt.Printf("Using existing kind cluster %s\n", name)
+				return runLocalCommand(exec.Command("kind", "export", "kubeconfig", "--name", name, "--kubeconfig", kubeconfig))
+			}
+		}
+		args := []string{"create", "cluster", "--name", name, "--kubeconfig", kubeconfig, "--wait", "5m"}
+		if image != "" {
+			args = append(args, "--image", image)
+		}
+		return runLocalCommand(exec.Command("kind", args...))
+	case "minikube":
+		// minikube writes the context of the profile into $KUBECONFIG
+		absolute, err := filepath.Abs(kubeconfig)
+		if err != nil {
+			return err
+		}
+		cmd := exec.Command("minikube", "start", "-p", name, "--wait", "all")
+		cmd.Env = append(os.Environ(), "KUBECONFIG="+absolute)
+		return runLocalCommand(cmd)
+	default:
+		return fmt.Errorf("unknown provider %q, expected kind or minikube", provider)
+	}
+}
+
+// runLocalCommand runs cmd with its output on the terminal
+func runLocalCommand(cmd *exec.Cmd) error {
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s failed: %v", strings.Join(cmd.Args, " "), err)
+	}
+	return nil
+}
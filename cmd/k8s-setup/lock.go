@@ -0,0 +1,31 @@
+package main
+
+import (
+	"github.com/maarulav/k8s-setup/internal/status"
+	"github.com/maarulav/k8s-setup/pkg/config"
+)
+
+// lockHosts locks every host for operation, breaking the existing locks with
+// force, and returns the function releasing them
+func lockHosts(cfg *config.Config, hosts []string, operation string, force bool) (func(), error) {
+	ttl, err := cfg.LockTTLDuration()
+	if err != nil {
+		return nil, err
+	}
+
+	var locks []*status.Lock
+	release := func() {
+		for _, l := range locks {
+			l.Release()
+		}
+	}
+	for _, host := range hosts {
+		l, err := status.AcquireLock(host, operation, ttl, force)
+		if err != nil {
+			release()
+			return nil, err
+		}
+		locks = append(locks, l)
+	}
+	return release, nil
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/maarulav/k8s-setup/pkg/support"
+)
+
+// runLogs downloads compressed component logs from a host
+func runLogs(args []string) error {
+	flags := flag.NewFlagSet("logs", flag.ExitOnError)
+	components := flags.String("component", "", "comma separated components to collect ("+strings.Join(support.ComponentNames(), ", ")+"), default all")
+	since := flags.String("since", "", "only collect entries newer than this timestamp, e.g. \"2024-01-02 15:04\"")
+	output := flags.String("output", "", "local archive path (default status/bundles/logs-<ip>-<time>.tar.gz)")
+	flags.Parse(args)
+
+	if flags.NArg() != 2 {
+		return fmt.Errorf("usage: logs [--component name,...] [--since time] [--output file] <config.json> <ip>")
+	}
+	names := support.ComponentNames()
+	if *components != "" {
+		names = strings.Split(*components, ",")
+	}
+
+	_, client, err := connect(flags.Arg(0), flags.Arg(1))
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	ip := client.IP
+
+	bundle, err := support.NewBundle(client, "k8s-setup-logs")
+	if err != nil {
+		return err
+	}
+	if err := bundle.CollectLogs(names, *since); err != nil {
+		return err
+	}
+
+	path := *output
+	if path == "" {
+		var err error
+		if path, err = bundlePath("logs", ip); err != nil {
+			return err
+		}
+	}
+	if err := bundle.Download(path); err != nil {
+		return err
+	}
+
+	fmt.Printf("Logs of %s saved to %s\n", ip, path)
+	return nil
+}
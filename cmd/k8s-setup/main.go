@@ -0,0 +1,522 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/maarulav/k8s-setup/internal/events"
+	"github.com/maarulav/k8s-setup/internal/logger"
+	"github.com/maarulav/k8s-setup/internal/progress"
+	"github.com/maarulav/k8s-setup/internal/report"
+	"github.com/maarulav/k8s-setup/internal/status"
+	"github.com/maarulav/k8s-setup/internal/tracing"
+	"github.com/maarulav/k8s-setup/pkg/addons"
+	"github.com/maarulav/k8s-setup/pkg/backup"
+	"github.com/maarulav/k8s-setup/pkg/config"
+	"github.com/maarulav/k8s-setup/pkg/helm"
+	"github.com/maarulav/k8s-setup/pkg/kubernetes"
+	"github.com/maarulav/k8s-setup/pkg/local"
+	"github.com/maarulav/k8s-setup/pkg/monitoring"
+	"github.com/maarulav/k8s-setup/pkg/plan"
+	"github.com/maarulav/k8s-setup/pkg/security"
+	"github.com/maarulav/k8s-setup/pkg/ssh"
+)
+
+func main() {
+	// Initialize logger
+	log := logger.New()
+
+	// Parse command line arguments
+	flags := flag.NewFlagSet("k8s-setup", flag.ExitOnError)
+	forceUnlock := flags.Bool("force-unlock", false, "break the locks other runs hold on the target hosts")
+	force := flags.Bool("force", false, "apply to hosts provisioned with a different configuration under configDrift refuse")
+	noCache := flags.Bool("no-cache", false, "run every step even where the host already ran it with the same inputs")
+	allowEOL := flags.Bool("allow-eol", false, "install a Kubernetes version past its end of life, e.g. for airgapped legacy clusters")
+	ignoreWindow := flags.Bool("ignore-window", false, "start outside the maintenance window and run past it, e.g. for an emergency change")
+	flags.Parse(os.Args[1:])
+	if flags.NArg() < 1 {
+		log.Fatal(usage)
+	}
+	args := flags.Args()
+
+	// Dispatch subcommands
+	if command, ok := commands[args[0]]; ok {
+		if err := checkRunFlags(flags, args[0]); err != nil {
+			log.Fatal(err)
+		}
+		if err := command(args[1:]); err != nil {
+			log.Fatalf("%s: %v", args[0], err)
+		}
+		return
+	}
+
+	// Load configuration
+	cfg, err := config.LoadConfig(args[0])
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+
+	// Get IP addresses from the hostnames, IPs and ranges on the command line
+	ips, err := resolveTargets(cfg, args[1:])
+	if err != nil {
+		log.Fatalf("Failed to resolve targets: %v", err)
+	}
+
+	// Keep unsupported Kubernetes versions from being installed by accident
+	if err := checkVersion(cfg, *allowEOL); err != nil {
+		log.Fatalf("Refusing to start: %v", err)
+	}
+
+	// Make changes between the file and the clusters visible
+	if err := checkDrift(cfg, ips, *force); err != nil {
+		log.Fatalf("Refusing to start: %v", err)
+	}
+
+	// Keep other operators off the hosts while they are provisioned
+	unlock, err := lockHosts(cfg, ips, "apply", *forceUnlock)
+	if err != nil {
+		log.Fatalf("Refusing to start: %v", err)
+	}
+	// log.Fatalf skips deferred calls, release the locks before it
+	_, err = provision(log, cfg, ips, options{noCache: *noCache, ignoreWindow: *ignoreWindow})
+	unlock()
+	if err != nil {
+		log.Fatalf("Refusing to start: %v", err)
+	}
+}
+
+// options tune a provisioning run
+type options struct {
+	// noCache runs the cached steps even where they are unchanged
+	noCache bool
+	// ignoreWindow runs regardless of the maintenance window
+	ignoreWindow bool
+}
+
+// provision sets up a cluster on each of the VMs and returns the final
+// status of every one of them. It fails when the run cannot start at all.
+func provision(log *logger.Logger, cfg *config.Config, ips []string, opts options) (map[string]string, error) {
+	// Export traces when an OTLP endpoint is configured
+	flush := tracing.Setup(cfg.Tracing)
+	defer flush()
+
+	// Refuse to start outside the maintenance window
+	r := newRun(cfg)
+	defer r.end()
+	deadline, err := windowDeadline(cfg, opts.ignoreWindow)
+	if err != nil {
+		return nil, err
+	}
+	if r.deadline = deadline; !deadline.IsZero() {
+		log.Printf("Maintenance window closes at %s", deadline.Format(time.RFC1123))
+	}
+
+	// Create status directory
+	if err := os.MkdirAll(status.Dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create status directory: %v", err)
+	}
+
+	// Stream the log of each host to CI jobs
+	if cfg.Progress.Listen != "" {
+		hub := progress.New(ips)
+		if stop, err := progress.Serve(cfg.Progress.Listen, hub); err != nil {
+			log.Printf("Warning: failed to start the progress endpoint: %v", err)
+		} else {
+			defer stop()
+			log.Tee(hub)
+			r.progress = hub
+			log.Printf("Streaming host logs on http://%s/hosts/<ip>/log", cfg.Progress.Listen)
+		}
+	}
+
+	// Process each VM
+	configHash := cfg.Hash()
+	r.events.Emit(events.RunStarted, "", map[string]interface{}{"hosts": ips})
+	for _, ip := range ips {
+		previous, _ := status.Load(ip)
+		status := status.New(ip)
+		status.ConfigHash = configHash
+		if previous != nil && previous.Adopted {
+			status.Adopted = true
+			status.Kubeconfig = previous.Kubeconfig
+		}
+		if previous != nil {
+			status.Operations = previous.Operations
+			// Kept until collected again, for hosts that fail before
+			status.Facts = previous.Facts
+			if !opts.noCache {
+				status.StepCache = previous.StepCache
+			}
+		}
+		if status.StepCache == nil {
+			status.StepCache = map[string]string{}
+		}
+		log.SetStatus(status)
+		log.Printf("Starting setup for VM %s", ip)
+		r.startHost(ip)
+
+		// Connect to VM
+		client, err := ssh.Connect(vmConfig(cfg, ip))
+		if err != nil {
+			status.Status = "Failed"
+			status.Error = fmt.Sprintf("SSH connection failed: %v", err)
+			r.finish(status)
+			continue
+		}
+		defer client.Close()
+		r.trace(client)
+
+		// Check system requirements
+		if err := client.CheckSystemRequirements(); err != nil {
+			status.Status = "Failed"
+			status.Error = fmt.Sprintf("System requirements check failed: %v", err)
+			r.finish(status)
+			continue
+		}
+
+		// Pick the packages and images for the architecture of the host
+		arch, err := kubernetes.DetectArch(client)
+		if err == nil {
+			err = addons.CheckArch(cfg, arch)
+		}
+		if err != nil {
+			status.Status = "Failed"
+			status.Error = fmt.Sprintf("System requirements check failed: %v", err)
+			r.finish(status)
+			continue
+		}
+		status.Arch = arch
+		log.Printf("VM %s runs on %s", ip, arch)
+		collectFacts(log.Printf, status, client)
+
+		// Warn about hosts too small for the planned components
+		if footprints, err := plan.Estimate(cfg); err == nil {
+			if capacity, err := plan.Detect(client); err == nil {
+				for _, warning := range plan.Check(plan.Total(footprints), capacity) {
+					log.Printf("Warning: VM %s: %s", ip, warning)
+				}
+			}
+		}
+
+		// Setup Kubernetes, unless the cluster was adopted
+		if status.Adopted {
+			log.Printf("VM %s is an adopted cluster, skipping Kubernetes bootstrap", ip)
+		} else {
+			if !r.begin(status, "kubernetes", "Setting up Kubernetes") {
+				continue
+			}
+			if err := kubernetes.Setup(client, cfg, status.StepCache); err != nil {
+				status.Status = "Failed"
+				status.Error = fmt.Sprintf("Kubernetes setup failed: %v", err)
+				r.finish(status)
+				continue
+			}
+			r.complete(status, "kubernetes")
+
+			// Keep a local copy of the admin kubeconfig
+			if data, err := kubernetes.FetchKubeconfig(client); err != nil {
+				log.Printf("Warning: %v", err)
+			} else if err := ioutil.WriteFile(status.KubeconfigPath(), data, 0600); err != nil {
+				log.Printf("Warning: failed to save kubeconfig: %v", err)
+			} else {
+				status.Kubeconfig = status.KubeconfigPath()
+			}
+		}
+
+		// Run the cluster operations over SSH or from this machine
+		var cluster ssh.Executor = client
+		if cfg.ClusterAccess == config.ClusterAccessLocal {
+			cluster, err = local.New(ip, status.Kubeconfig, status.WorkDir())
+			if err != nil {
+				status.Status = "Failed"
+				status.Error = fmt.Sprintf("Local cluster access failed: %v", err)
+				r.finish(status)
+				continue
+			}
+			r.trace(cluster)
+		}
+
+		// Run the extra steps of the roles of the host
+		roles, err := cfg.HostRoles(ip)
+		if err != nil {
+			status.Status = "Failed"
+			status.Error = fmt.Sprintf("Role setup failed: %v", err)
+			r.finish(status)
+			continue
+		}
+		if len(roles) > 0 {
+			if !r.begin(status, "roles", "Setting up node roles") {
+				continue
+			}
+			if err := kubernetes.SetupRoles(cluster, client, cfg, roles); err != nil {
+				status.Status = "Failed"
+				status.Error = fmt.Sprintf("Role setup failed: %v", err)
+				r.finish(status)
+				continue
+			}
+			r.complete(status, "roles")
+		}
+
+		if !setupCluster(r, log, status, cfg, client, cluster, ips) {
+			continue
+		}
+
+		// Taint the node once the addons and the monitoring stack run on it
+		if len(roles) > 0 {
+			if !r.begin(status, "taints", "Tainting the node for its roles") {
+				continue
+			}
+			if err := kubernetes.TaintRoles(cluster, client, cfg, roles); err != nil {
+				status.Status = "Failed"
+				status.Error = fmt.Sprintf("Role setup failed: %v", err)
+				r.finish(status)
+				continue
+			}
+			r.complete(status, "taints")
+		}
+
+		// Create backup
+		if !r.begin(status, "backup", "Creating backup") {
+			continue
+		}
+		if err := backup.Create(cluster, cfg); err != nil {
+			log.Printf("Warning: Backup creation failed: %v", err)
+		} else {
+			now := time.Now()
+			status.LastBackup = &now
+			r.complete(status, "backup")
+		}
+
+		status.Status = "Completed"
+		status.EndTime = time.Now()
+
+		// Keep the credentials in one place, before the runbook points at it
+		if cfg.Credentials.Enabled {
+			if path, err := saveCredentials(status, cfg); err != nil {
+				log.Printf("Warning: failed to write the credentials summary: %v", err)
+			} else {
+				status.Credentials = path
+				log.Printf("Credentials for VM %s saved to %s", ip, path)
+			}
+		}
+
+		// Document the cluster for handover
+		if cfg.Runbook.Enabled {
+			if path, err := saveRunbook(r, status, cfg, cluster); err != nil {
+				log.Printf("Warning: failed to write the runbook: %v", err)
+			} else {
+				status.Runbook = path
+				log.Printf("Runbook for VM %s saved to %s", ip, path)
+			}
+		}
+		r.finish(status)
+		log.Printf("Setup completed successfully for VM %s", ip)
+		if status.GrafanaURL != "" {
+			log.Printf("Grafana for VM %s is at %s", ip, status.GrafanaURL)
+		}
+	}
+
+	// Keep the status directory and logs from growing without bound
+	prune(log, cfg)
+
+	// Mail the summary of the run
+	r.summary.End = time.Now()
+	if id, err := report.SaveRun("apply", &r.summary); err != nil {
+		log.Printf("Warning: failed to record the run: %v", err)
+	} else {
+		log.Printf("Run recorded as %s", id)
+	}
+	if err := report.Send(cfg.Email, &r.summary); err != nil {
+		log.Printf("Warning: failed to send the summary email: %v", err)
+	}
+	cluster := ""
+	if len(ips) > 0 {
+		cluster = cfg.ClusterName(ips[0])
+	}
+	if err := report.Push(cfg.Pushgateway, cluster, "apply", &r.summary); err != nil {
+		log.Printf("Warning: %v", err)
+	}
+
+	r.events.Emit(events.RunFinished, "", map[string]interface{}{"results": r.results})
+	return r.results, nil
+}
+
+// setupCluster runs the steps that only need cluster access, from the
+// bootstrap manifests to the verification. client is nil for clusters without
+// SSH access to their nodes. It returns false when the host is done, having
+// failed or run out of its maintenance window.
+func setupCluster(r *run, log *logger.Logger, status *status.SetupStatus, cfg *config.Config, client *ssh.Client, cluster ssh.Executor, ips []string) bool {
+	// Apply bootstrap manifests
+	if len(cfg.Manifests) > 0 {
+		if !r.begin(status, "manifests", "Applying bootstrap manifests") {
+			return false
+		}
+		if err := kubernetes.ApplyManifests(cluster, cfg); err != nil {
+			status.Status = "Failed"
+			status.Error = fmt.Sprintf("Manifest injection failed: %v", err)
+			r.finish(status)
+			return false
+		}
+		r.complete(status, "manifests")
+	}
+
+	// Create application namespaces
+	if len(cfg.Namespaces) > 0 {
+		if !r.begin(status, "namespaces", "Creating namespaces") {
+			return false
+		}
+		if err := kubernetes.SetupNamespaces(cluster, cfg); err != nil {
+			status.Status = "Failed"
+			status.Error = fmt.Sprintf("Namespace setup failed: %v", err)
+			r.finish(status)
+			return false
+		}
+		r.complete(status, "namespaces")
+	}
+
+	// Distribute registry credentials, which needs SSH access to the nodes
+	if len(cfg.Registries) > 0 && client == nil {
+		log.Printf("Warning: no SSH access to %s, skipping registry credentials", status.VMIP)
+	} else if len(cfg.Registries) > 0 {
+		if !r.begin(status, "registries", "Distributing registry credentials") {
+			return false
+		}
+		if err := kubernetes.SetupRegistryCredentials(client, cfg); err != nil {
+			status.Status = "Failed"
+			status.Error = fmt.Sprintf("Registry credentials setup failed: %v", err)
+			r.finish(status)
+			return false
+		}
+		r.complete(status, "registries")
+	}
+
+	// Setup monitoring
+	if !r.begin(status, "monitoring", "Setting up monitoring") {
+		return false
+	}
+	if err := monitoring.Setup(cluster, cfg, ips); err != nil {
+		status.Status = "Failed"
+		status.Error = fmt.Sprintf("Monitoring setup failed: %v", err)
+		r.finish(status)
+		return false
+	}
+	if url, _ := monitoring.GrafanaURL(cfg, status.VMIP); url != "" {
+		status.GrafanaURL = url
+	}
+	r.complete(status, "monitoring")
+
+	// Install addons
+	if len(addons.Enabled(cfg)) > 0 {
+		if !r.begin(status, "addons", "Installing addons") {
+			return false
+		}
+		if err := addons.Setup(cluster, cfg); err != nil {
+			status.Status = "Failed"
+			status.Error = fmt.Sprintf("Addon setup failed: %v", err)
+			r.finish(status)
+			return false
+		}
+		if addons.SealedSecrets(cfg) {
+			if path, err := saveSealedSecretsCert(status, cluster); err != nil {
+				log.Printf("Warning: failed to save sealed-secrets certificate: %v", err)
+			} else {
+				status.SealedSecretsCert = path
+				log.Printf("Sealed-secrets certificate for VM %s saved to %s", status.VMIP, path)
+			}
+		}
+		r.complete(status, "addons")
+	}
+
+	// Install user charts
+	if len(cfg.Charts) > 0 {
+		if !r.begin(status, "charts", "Installing charts") {
+			return false
+		}
+		if err := helm.InstallCharts(cluster, cfg.Charts, cfg.HostVars(status.VMIP)); err != nil {
+			status.Status = "Failed"
+			status.Error = fmt.Sprintf("Chart installation failed: %v", err)
+			r.finish(status)
+			return false
+		}
+		r.complete(status, "charts")
+	}
+
+	// Install Velero for the volume backups
+	if cfg.Backup.Volumes.Enabled {
+		if !r.begin(status, "velero", "Installing Velero") {
+			return false
+		}
+		if err := backup.SetupVolumes(cluster, cfg); err != nil {
+			status.Status = "Failed"
+			status.Error = fmt.Sprintf("Velero installation failed: %v", err)
+			r.finish(status)
+			return false
+		}
+		r.complete(status, "velero")
+	}
+
+	// Scan the cluster for misconfigurations and vulnerabilities
+	if cfg.Security.Enabled() {
+		if !r.begin(status, "security", "Scanning cluster security") {
+			return false
+		}
+		report, err := security.Scan(cluster, cfg)
+		if report != nil {
+			if path, saveErr := saveSecurityReport(status, report); saveErr != nil {
+				log.Printf("Warning: failed to save security report: %v", saveErr)
+			} else {
+				status.SecurityReport = path
+				log.Printf("Security report for VM %s saved to %s", status.VMIP, path)
+			}
+		}
+		if err != nil {
+			status.Status = "Failed"
+			status.Error = fmt.Sprintf("Security scan failed: %v", err)
+			r.finish(status)
+			return false
+		}
+		r.complete(status, "security")
+	}
+
+	// Verify setup
+	if !r.begin(status, "verification", "Verifying setup") {
+		return false
+	}
+	if err := kubernetes.Verify(cluster, cfg); err != nil {
+		status.Status = "Failed"
+		status.Error = fmt.Sprintf("Verification failed: %v", err)
+		status.Verification = verification(status.Error)
+		r.finish(status)
+		return false
+	}
+	if err := monitoring.Verify(cluster, cfg); err != nil {
+		status.Status = "Failed"
+		status.Error = fmt.Sprintf("Monitoring verification failed: %v", err)
+		status.Verification = verification(status.Error)
+		r.finish(status)
+		return false
+	}
+	status.Verification = verification("")
+	if client != nil {
+		// The runtime and the kubelet are installed by now
+		collectFacts(log.Printf, status, client)
+	}
+	r.complete(status, "verification")
+
+	return true
+}
+
+// collectFacts records the facts of the host behind client in s. Facts are
+// informational, a failure to collect them is only passed to warn.
+func collectFacts(warn func(format string, v ...interface{}), s *status.SetupStatus, client ssh.Executor) {
+	facts, err := kubernetes.CollectFacts(client)
+	if err != nil {
+		warn("Warning: VM %s: %v", s.VMIP, err)
+		return
+	}
+	f := status.Facts(*facts)
+	s.Facts = &f
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/maarulav/k8s-setup/internal/status"
+	"github.com/maarulav/k8s-setup/pkg/kube"
+	"github.com/maarulav/k8s-setup/pkg/kubernetes"
+	"github.com/maarulav/k8s-setup/pkg/ssh"
+)
+
+// runNode dispatches the node subcommands
+func runNode(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("unknown node command, expected: node drain|uncordon")
+	}
+	switch args[0] {
+	case "drain":
+		return nodeDrain(args[1:])
+	case "uncordon":
+		return nodeUncordon(args[1:])
+	default:
+		return fmt.Errorf("unknown node command, expected: node drain|uncordon")
+	}
+}
+
+// nodeDrain takes a node out of service for maintenance and records it in
+// the status file of the control plane. It refuses to start while a pod
+// disruption budget allows no disruption of a pod on the node, since the
+// drain would only wait for it until the timeout.
+func nodeDrain(args []string) error {
+	flags := flag.NewFlagSet("node drain", flag.ExitOnError)
+	timeout := flags.Duration("timeout", 5*time.Minute, "give up draining after this long")
+	force := flags.Bool("force", false, "drain despite blocking pod disruption budgets and delete pods no controller manages")
+	disableEviction := flags.Bool("disable-eviction", false, "delete the pods instead of evicting them, bypassing pod disruption budgets")
+	reason := flags.String("reason", "", "why the node is drained, kept in the status file")
+	flags.Parse(args)
+
+	if flags.NArg() != 3 {
+		return fmt.Errorf("usage: node drain [--timeout 5m] [--force] [--disable-eviction] [--reason text] <config.json> <control-plane-ip> <node-ip>")
+	}
+	cfg, client, err := connect(flags.Arg(0), flags.Arg(1))
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	ip, err := resolveTarget(cfg, flags.Arg(2))
+	if err != nil {
+		return err
+	}
+
+	unlock, err := lockHosts(cfg, []string{ip}, "drain", false)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	kc, err := kube.NewClient(client)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), kube.DefaultTimeout)
+	defer cancel()
+	node, err := kube.NodeByAddress(ctx, kc, ip)
+	if err != nil {
+		return err
+	}
+	blocking, err := kube.BlockingPDBs(ctx, kc, node)
+	if err != nil {
+		return fmt.Errorf("failed to check pod disruption budgets: %v", err)
+	}
+	if len(blocking) > 0 {
+		switch {
+		case *disableEviction:
+			fmt.Printf("Warning: bypassing pod disruption budgets %s\n", strings.Join(blocking, ", "))
+		case *force:
+			fmt.Printf("Warning: pod disruption budgets %s allow no disruption, the drain may time out\n", strings.Join(blocking, ", "))
+		default:
+			return fmt.Errorf("pod disruption budgets %s allow no disruption of pods on %s, pass --force to try anyway or --disable-eviction to bypass them",
+				strings.Join(blocking, ", "), node)
+		}
+	}
+
+	fmt.Printf("Draining %s (%s)\n", node, ip)
+	err = drainNode(client, ip, node, *reason, kubernetes.DrainOptions{
+		Timeout:         *timeout,
+		Force:           *force,
+		DisableEviction: *disableEviction,
+	})
+	if err != nil {
+		fmt.Printf("Node %s stays cordoned, bring it back with: k8s-setup node uncordon %s %s %s\n", node, flags.Arg(0), client.IP, ip)
+		return err
+	}
+	fmt.Printf("Node %s is drained and cordoned, bring it back with: k8s-setup node uncordon %s %s %s\n", node, flags.Arg(0), client.IP, ip)
+	return nil
+}
+
+// drainNode drains node and records it under maintenance in the status file
+// of the control plane. kubectl cordons the node before evicting its pods,
+// so a failed or timed out drain is recorded as well, with its error.
+func drainNode(client ssh.Executor, ip, node, reason string, opts kubernetes.DrainOptions) error {
+	drainErr := kubernetes.Drain(client, node, opts)
+
+	s, err := status.Load(client.Host())
+	if err != nil {
+		s = status.New(client.Host())
+	}
+	m := status.Maintenance{
+		IP:     ip,
+		Node:   node,
+		Reason: reason,
+		Since:  time.Now(),
+	}
+	if drainErr != nil {
+		m.Error = strings.SplitN(drainErr.Error(), "\n", 2)[0]
+	}
+	s.Maintenance = append(removeMaintenance(s.Maintenance, ip), m)
+	if err := saveStatus(s); err != nil {
+		if drainErr != nil {
+			fmt.Printf("Warning: failed to record the maintenance of %s: %v\n", node, err)
+			return drainErr
+		}
+		return err
+	}
+	return drainErr
+}
+
+// nodeUncordon returns a node to service and removes it from the
+// maintenance list of the control plane
+func nodeUncordon(args []string) error {
+	flags := flag.NewFlagSet("node uncordon", flag.ExitOnError)
+	flags.Parse(args)
+
+	if flags.NArg() != 3 {
+		return fmt.Errorf("usage: node uncordon <config.json> <control-plane-ip> <node-ip>")
+	}
+	cfg, client, err := connect(flags.Arg(0), flags.Arg(1))
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	ip, err := resolveTarget(cfg, flags.Arg(2))
+	if err != nil {
+		return err
+	}
+
+	unlock, err := lockHosts(cfg, []string{ip}, "uncordon", false)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	node, err := nodeName(client, ip)
+	if err != nil {
+		return err
+	}
+	if err := kubernetes.Uncordon(client, node); err != nil {
+		return err
+	}
+
+	s, err := status.Load(client.IP)
+	if err != nil {
+		fmt.Printf("Node %s (%s) is schedulable again\n", node, ip)
+		return nil
+	}
+	for _, m := range s.Maintenance {
+		if m.IP == ip {
+			fmt.Printf("Node %s (%s) is schedulable again after %s of maintenance\n", node, ip, time.Since(m.Since).Round(time.Minute))
+			s.Maintenance = removeMaintenance(s.Maintenance, ip)
+			return saveStatus(s)
+		}
+	}
+	fmt.Printf("Node %s (%s) is schedulable again\n", node, ip)
+	return nil
+}
+
+// nodeName returns the name of the node with address ip
+func nodeName(client ssh.Executor, ip string) (string, error) {
+	kc, err := kube.NewClient(client)
+	if err != nil {
+		return "", err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), kube.DefaultTimeout)
+	defer cancel()
+	return kube.NodeByAddress(ctx, kc, ip)
+}
+
+// removeMaintenance returns the maintenance entries without the one of ip
+func removeMaintenance(entries []status.Maintenance, ip string) []status.Maintenance {
+	var kept []status.Maintenance
+	for _, m := range entries {
+		if m.IP != ip {
+			kept = append(kept, m)
+		}
+	}
+	return kept
+}
+
+// saveStatus writes s to the status directory
+func saveStatus(s *status.SetupStatus) error {
+	if err := os.MkdirAll(status.Dir, 0755); err != nil {
+		return err
+	}
+	return s.Save()
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/maarulav/k8s-setup/internal/status"
+	"github.com/maarulav/k8s-setup/internal/testutil"
+	"github.com/maarulav/k8s-setup/pkg/config"
+	"github.com/maarulav/k8s-setup/pkg/kubernetes"
+)
+
+func TestDrainNode(t *testing.T) {
+	t.Chdir(t.TempDir())
+	client := testutil.NewExecutor("10.0.0.1")
+
+	if err := drainNode(client, "10.0.0.2", "worker-1", "disk swap", kubernetes.DrainOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	s, err := status.Load("10.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Maintenance) != 1 || s.Maintenance[0].Node != "worker-1" || s.Maintenance[0].Reason != "disk swap" || s.Maintenance[0].Error != "" {
+		t.Errorf("maintenance %+v", s.Maintenance)
+	}
+}
+
+func TestDrainNodeFailure(t *testing.T) {
+	t.Chdir(t.TempDir())
+	client := testutil.NewExecutor("10.0.0.1").Fail("kubectl drain", "error: timed out waiting for the condition")
+
+	err := drainNode(client, "10.0.0.2", "worker-1", "", kubernetes.DrainOptions{Timeout: time.Minute})
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Fatalf("error %v, want the failed drain", err)
+	}
+
+	// The node stays cordoned, so it is recorded with the error
+	s, err := status.Load("10.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Maintenance) != 1 || s.Maintenance[0].IP != "10.0.0.2" || !strings.Contains(s.Maintenance[0].Error, "failed to drain node worker-1") {
+		t.Errorf("maintenance %+v, want the failed drain recorded", s.Maintenance)
+	}
+}
+
+func TestNodeUncordonLocked(t *testing.T) {
+	t.Chdir(t.TempDir())
+	if err := os.MkdirAll(status.Dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	cp := testutil.NewSSHServer(t)
+	path := writeConfig(t, cp, &config.Config{})
+
+	lock, err := status.AcquireLock("10.0.0.2", "drain", time.Hour, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lock.Release()
+
+	err = nodeUncordon([]string{path, "localhost", "10.0.0.2"})
+	if err == nil || !strings.Contains(err.Error(), "10.0.0.2 is locked") {
+		t.Errorf("error %v, want the node locked by the drain", err)
+	}
+	if len(commandsWith(cp, "kubectl uncordon")) != 0 {
+		t.Error("the locked node was uncordoned")
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/maarulav/k8s-setup/internal/status"
+	"github.com/maarulav/k8s-setup/pkg/backup"
+	"github.com/maarulav/k8s-setup/pkg/config"
+	"github.com/maarulav/k8s-setup/pkg/ssh"
+)
+
+// recordOperation backs up the cluster before a destructive operation, unless
+// skipBackup is set, and records the operation with the backup location in
+// the status file of the control plane
+func recordOperation(client ssh.Executor, cfg *config.Config, operation string, skipBackup bool) error {
+	op := status.Operation{Name: operation, StartTime: time.Now()}
+	if !skipBackup {
+		fmt.Printf("Backing up cluster %s before %s\n", client.Host(), operation)
+		location, err := backup.BeforeOperation(client, cfg, operation)
+		if err != nil {
+			return fmt.Errorf("backup before %s failed, pass --skip-backup to continue without one: %v", operation, err)
+		}
+		fmt.Printf("Backup stored at %s:%s\n", client.Host(), location)
+		op.Backup = location
+	}
+
+	s, err := status.Load(client.Host())
+	if err != nil {
+		s = status.New(client.Host())
+	}
+	s.Operations = append(s.Operations, op)
+
+	if err := os.MkdirAll(status.Dir, 0755); err != nil {
+		return err
+	}
+	return s.Save()
+}
@@ -0,0 +1,336 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"time"
+
+	"github.com/maarulav/k8s-setup/internal/status"
+	"github.com/maarulav/k8s-setup/pkg/config"
+	"github.com/maarulav/k8s-setup/pkg/kubernetes"
+	"github.com/maarulav/k8s-setup/pkg/render"
+	"github.com/maarulav/k8s-setup/pkg/ssh"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	clientset "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// Phases of a node of a ClusterSetup
+const (
+	phaseJoining = "Joining"
+	phaseJoined  = "Joined"
+	phaseFailed  = "Failed"
+)
+
+var clusterSetupResource = schema.GroupVersionResource{
+	Group:    render.ClusterSetupGroup,
+	Version:  render.ClusterSetupVersion,
+	Resource: render.ClusterSetupResource,
+}
+
+// clusterSetup is a ClusterSetup object: the nodes to prepare and join to
+// the cluster of a control plane
+type clusterSetup struct {
+	Spec struct {
+		ControlPlane string `json:"controlPlane"`
+
+		// CredentialsSecret names the Secret with the SSH credentials of the
+		// control plane and of the nodes without their own
+		CredentialsSecret string      `json:"credentialsSecret,omitempty"`
+		Nodes             []setupNode `json:"nodes,omitempty"`
+	} `json:"spec"`
+	Status struct {
+		Nodes []nodeStatus `json:"nodes,omitempty"`
+	} `json:"status,omitempty"`
+}
+
+// setupNode is a node a ClusterSetup joins
+type setupNode struct {
+	IP                string `json:"ip"`
+	CredentialsSecret string `json:"credentialsSecret,omitempty"`
+	ControlPlane      bool   `json:"controlPlane,omitempty"`
+}
+
+// nodeStatus is the outcome of the last join of a node
+type nodeStatus struct {
+	IP                 string      `json:"ip"`
+	Phase              string      `json:"phase"`
+	Message            string      `json:"message,omitempty"`
+	LastTransitionTime metav1.Time `json:"lastTransitionTime"`
+}
+
+// operator reconciles the ClusterSetups of a management cluster
+type operator struct {
+	cfg       *config.Config
+	dynamic   dynamic.Interface
+	client    clientset.Interface
+	namespace string
+	retry     time.Duration
+}
+
+// runOperator runs the provisioning engine as a controller: every node
+// listed in a ClusterSetup is prepared and joined over SSH, and the outcome
+// recorded in the ClusterSetup's status
+func runOperator(args []string) error {
+	flags := flag.NewFlagSet("operator", flag.ExitOnError)
+	kubeconfig := flags.String("kubeconfig", "", "kubeconfig of the management cluster (default: the in-cluster service account)")
+	namespace := flags.String("namespace", "", "only reconcile the ClusterSetups of this namespace")
+	interval := flags.Duration("interval", 30*time.Second, "how often the ClusterSetups are reconciled")
+	retry := flags.Duration("retry", 10*time.Minute, "how long a node that failed to join waits for the next attempt")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		return fmt.Errorf("usage: operator [--kubeconfig file] [--namespace ns] [--interval 30s] [--retry 10m] <config.json>")
+	}
+	cfg, err := config.LoadConfig(flags.Arg(0))
+	if err != nil {
+		return fmt.Errorf("failed to load configuration: %v", err)
+	}
+
+	var restConfig *rest.Config
+	if *kubeconfig == "" {
+		restConfig, err = rest.InClusterConfig()
+	} else {
+		restConfig, err = clientcmd.BuildConfigFromFlags("", *kubeconfig)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to load the management cluster's configuration: %v", err)
+	}
+	o := &operator{cfg: cfg, namespace: *namespace, retry: *retry}
+	if o.dynamic, err = dynamic.NewForConfig(restConfig); err != nil {
+		return err
+	}
+	if o.client, err = clientset.NewForConfig(restConfig); err != nil {
+		return err
+	}
+
+	log.Printf("Reconciling ClusterSetups every %s", *interval)
+	for {
+		if err := o.reconcileAll(context.Background()); err != nil {
+			log.Printf("Warning: %v", err)
+		}
+		time.Sleep(*interval)
+	}
+}
+
+// reconcileAll joins the pending nodes of every ClusterSetup
+func (o *operator) reconcileAll(ctx context.Context) error {
+	list, err := o.dynamic.Resource(clusterSetupResource).Namespace(o.namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to list ClusterSetups: %v", err)
+	}
+	for i := range list.Items {
+		obj := &list.Items[i]
+		if err := o.reconcile(ctx, obj); err != nil {
+			log.Printf("Warning: ClusterSetup %s/%s: %v", obj.GetNamespace(), obj.GetName(), err)
+		}
+	}
+	return nil
+}
+
+// reconcile joins the nodes of obj that have not joined yet, and those that
+// failed more than the retry interval ago. The status is written before and
+// after each join, so a join is visible while it runs.
+func (o *operator) reconcile(ctx context.Context, obj *unstructured.Unstructured) error {
+	var cs clusterSetup
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, &cs); err != nil {
+		return fmt.Errorf("invalid ClusterSetup: %v", err)
+	}
+
+	for _, node := range cs.Spec.Nodes {
+		st := cs.nodeStatus(node.IP)
+		if st.Phase == phaseJoined || (st.Phase == phaseFailed && time.Since(st.LastTransitionTime.Time) < o.retry) {
+			continue
+		}
+
+		st.Phase, st.Message, st.LastTransitionTime = phaseJoining, "", metav1.Now()
+		var err error
+		if obj, err = o.updateStatus(ctx, obj, &cs); err != nil {
+			return err
+		}
+
+		log.Printf("Joining %s to %s", node.IP, cs.Spec.ControlPlane)
+		if err := o.join(ctx, obj.GetNamespace(), &cs, node); err != nil {
+			log.Printf("Warning: %s: %v", node.IP, err)
+			st.Phase, st.Message = phaseFailed, err.Error()
+		} else {
+			log.Printf("Node %s joined cluster %s", node.IP, cs.Spec.ControlPlane)
+			st.Phase = phaseJoined
+		}
+		st.LastTransitionTime = metav1.Now()
+		if obj, err = o.updateStatus(ctx, obj, &cs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// nodeStatus returns the status entry of ip, adding it when missing
+func (cs *clusterSetup) nodeStatus(ip string) *nodeStatus {
+	for i := range cs.Status.Nodes {
+		if cs.Status.Nodes[i].IP == ip {
+			return &cs.Status.Nodes[i]
+		}
+	}
+	cs.Status.Nodes = append(cs.Status.Nodes, nodeStatus{IP: ip})
+	return &cs.Status.Nodes[len(cs.Status.Nodes)-1]
+}
+
+// updateStatus writes the status of cs to obj and returns the updated object
+func (o *operator) updateStatus(ctx context.Context, obj *unstructured.Unstructured, cs *clusterSetup) (*unstructured.Unstructured, error) {
+	st, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&cs.Status)
+	if err != nil {
+		return nil, err
+	}
+	obj = obj.DeepCopy()
+	obj.Object["status"] = st
+	updated, err := o.dynamic.Resource(clusterSetupResource).Namespace(obj.GetNamespace()).UpdateStatus(ctx, obj, metav1.UpdateOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to update status: %v", err)
+	}
+	return updated, nil
+}
+
+// join prepares node and joins it to the cluster of cs. A node that already
+// runs a kubelet is taken as joined, e.g. after the operator lost its status.
+func (o *operator) join(ctx context.Context, namespace string, cs *clusterSetup, node setupNode) error {
+	cfg, cleanup, err := o.credentials(ctx, namespace, cs, node)
+	defer cleanup()
+	if err != nil {
+		return err
+	}
+	controlPlane := cs.Spec.ControlPlane
+
+	unlock, err := lockHosts(cfg, []string{controlPlane, node.IP}, "join", false)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	cpClient, err := ssh.Connect(vmConfig(cfg, controlPlane))
+	if err != nil {
+		return fmt.Errorf("SSH connection to %s failed: %v", controlPlane, err)
+	}
+	defer cpClient.Close()
+
+	client, err := ssh.Connect(vmConfig(cfg, node.IP))
+	if err != nil {
+		return fmt.Errorf("SSH connection to %s failed: %v", node.IP, err)
+	}
+	defer client.Close()
+
+	if _, err := client.ExecuteCommand("test -f /etc/kubernetes/kubelet.conf"); err == nil {
+		return nil
+	}
+
+	if !cfg.Kubernetes.Prebaked {
+		if err := kubernetes.PrepareNode(client, cfg); err != nil {
+			return err
+		}
+	}
+
+	s, err := status.Load(controlPlane)
+	if err != nil {
+		s = status.New(controlPlane)
+	}
+	command, err := joinCommand(cpClient, s, node.ControlPlane)
+	if err != nil {
+		return err
+	}
+	if output, err := client.ExecuteCommand(command); err != nil {
+		return fmt.Errorf("join failed: %v\nOutput: %s", err, output)
+	}
+	if err := labelCluster(cfg, cpClient, client); err != nil {
+		return fmt.Errorf("failed to label the node with the cluster name: %v", err)
+	}
+
+	roles, err := cfg.HostRoles(node.IP)
+	if err != nil {
+		return err
+	}
+	if len(roles) > 0 {
+		if err := kubernetes.SetupRoles(cpClient, client, cfg, roles); err != nil {
+			return fmt.Errorf("role setup failed: %v", err)
+		}
+		if err := kubernetes.TaintRoles(cpClient, client, cfg, roles); err != nil {
+			return fmt.Errorf("role setup failed: %v", err)
+		}
+	}
+	return nil
+}
+
+// credentials returns a copy of the configuration with the SSH credentials
+// of the control plane and node of cs from their Secrets, and the function
+// removing the private keys it wrote
+func (o *operator) credentials(ctx context.Context, namespace string, cs *clusterSetup, node setupNode) (*config.Config, func(), error) {
+	cfg := *o.cfg
+	cfg.Hosts = append([]config.Host(nil), o.cfg.Hosts...)
+
+	var keys []string
+	cleanup := func() {
+		for _, key := range keys {
+			os.Remove(key)
+		}
+	}
+
+	nodeSecret := node.CredentialsSecret
+	if nodeSecret == "" {
+		nodeSecret = cs.Spec.CredentialsSecret
+	}
+	for _, host := range []struct{ ip, secret string }{
+		{cs.Spec.ControlPlane, cs.Spec.CredentialsSecret},
+		{node.IP, nodeSecret},
+	} {
+		if host.secret == "" {
+			continue
+		}
+		secret, err := o.client.CoreV1().Secrets(namespace).Get(ctx, host.secret, metav1.GetOptions{})
+		if err != nil {
+			return nil, cleanup, fmt.Errorf("failed to read the credentials of %s: %v", host.ip, err)
+		}
+
+		h := hostEntry(&cfg, host.ip)
+		if username := secret.Data["username"]; len(username) > 0 {
+			h.Username = string(username)
+		}
+		if password := secret.Data["password"]; len(password) > 0 {
+			h.Password = string(password)
+		}
+		if key := secret.Data["ssh-privatekey"]; len(key) > 0 {
+			f, err := ioutil.TempFile("", "k8s-setup-key-")
+			if err != nil {
+				return nil, cleanup, err
+			}
+			keys = append(keys, f.Name())
+			_, err = f.Write(key)
+			if closeErr := f.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				return nil, cleanup, err
+			}
+			h.KeyFile = f.Name()
+		}
+	}
+	return &cfg, cleanup, nil
+}
+
+// hostEntry returns the inventory entry of ip, adding it when missing
+func hostEntry(cfg *config.Config, ip string) *config.Host {
+	for i := range cfg.Hosts {
+		if cfg.Hosts[i].IP == ip {
+			return &cfg.Hosts[i]
+		}
+	}
+	cfg.Hosts = append(cfg.Hosts, config.Host{IP: ip})
+	return &cfg.Hosts[len(cfg.Hosts)-1]
+}
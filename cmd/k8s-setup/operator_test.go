@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/maarulav/k8s-setup/internal/testutil"
+	"github.com/maarulav/k8s-setup/pkg/config"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+const joinOutput = "kubeadm join 10.0.0.10:6443 --token abcdef.0123456789abcdef --discovery-token-ca-cert-hash sha256:0123\n"
+
+// testOperator returns an operator for the ClusterSetup "prod" of the
+// namespace "infra", whose control plane is served by cp and whose node
+// 127.0.0.1 by node. The control plane is addressed as localhost so both
+// get their own lock.
+func testOperator(t *testing.T, cp, node *testutil.SSHServer, nodes ...map[string]interface{}) *operator {
+	t.Chdir(t.TempDir())
+
+	cfg := &config.Config{}
+	cfg.SSHConfig.Timeout = config.Duration(5 * time.Second)
+	cfg.SSHConfig.Shell = config.ShellLogin
+	cfg.SSHConfig.ConfigFile = "none"
+	cfg.Kubernetes.Prebaked = true
+	cfg.Hosts = []config.Host{
+		{IP: "localhost", Port: cp.VMConfig().Port},
+		{IP: "127.0.0.1", Port: node.VMConfig().Port},
+	}
+
+	items := []interface{}{}
+	for _, n := range nodes {
+		items = append(items, n)
+	}
+	setup := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": clusterSetupResource.GroupVersion().String(),
+		"kind":       "ClusterSetup",
+		"metadata":   map[string]interface{}{"name": "prod", "namespace": "infra"},
+		"spec": map[string]interface{}{
+			"controlPlane":      "localhost",
+			"credentialsSecret": "ssh",
+			"nodes":             items,
+		},
+	}}
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "ssh", Namespace: "infra"},
+		Data:       map[string][]byte{"username": []byte(testutil.SSHUser), "password": []byte(testutil.SSHPassword)},
+	}
+
+	return &operator{
+		cfg: cfg,
+		dynamic: dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+			map[schema.GroupVersionResource]string{clusterSetupResource: "ClusterSetupList"}, setup),
+		client:    fake.NewClientset(secret),
+		namespace: "infra",
+		retry:     time.Hour,
+	}
+}
+
+// statuses returns the node phases of the ClusterSetup "prod" by IP
+func statuses(t *testing.T, o *operator) map[string]nodeStatus {
+	t.Helper()
+	obj, err := o.dynamic.Resource(clusterSetupResource).Namespace("infra").Get(context.Background(), "prod", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cs clusterSetup
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, &cs); err != nil {
+		t.Fatal(err)
+	}
+	phases := map[string]nodeStatus{}
+	for _, st := range cs.Status.Nodes {
+		phases[st.IP] = st
+	}
+	return phases
+}
+
+// joins counts the kubeadm join commands server received
+func joins(server *testutil.SSHServer) int {
+	n := 0
+	for _, command := range server.Commands() {
+		if strings.Contains(command, "kubeadm join ") {
+			n++
+		}
+	}
+	return n
+}
+
+func TestOperatorReconcile(t *testing.T) {
+	cp := testutil.NewSSHServer(t).Respond("kubeadm token create", joinOutput)
+	node := testutil.NewSSHServer(t).Fail("kubelet.conf", "")
+	o := testOperator(t, cp, node,
+		map[string]interface{}{"ip": "127.0.0.1"},
+		// The Secret is missing, so the node fails before any connection
+		map[string]interface{}{"ip": "10.0.0.99", "credentialsSecret": "missing"},
+	)
+
+	if err := o.reconcileAll(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	phases := statuses(t, o)
+	if st := phases["127.0.0.1"]; st.Phase != phaseJoined || st.LastTransitionTime.IsZero() {
+		t.Errorf("node 127.0.0.1: %+v, want Joined", st)
+	}
+	if st := phases["10.0.0.99"]; st.Phase != phaseFailed || !strings.Contains(st.Message, "credentials of 10.0.0.99") {
+		t.Errorf("node 10.0.0.99: %+v, want Failed on its credentials", st)
+	}
+	if n := joins(node); n != 1 {
+		t.Errorf("node received %d joins, want 1", n)
+	}
+	if commands := node.Commands(); !strings.HasPrefix(commands[len(commands)-1], "kubeadm join 10.0.0.10:6443") {
+		t.Errorf("node commands %v, want the join command of the control plane last", commands)
+	}
+
+	// Joined nodes are left alone and failed ones wait for the retry interval
+	if err := o.reconcileAll(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if n := joins(node); n != 1 {
+		t.Errorf("a joined node was joined again, %d joins", n)
+	}
+	if failed := statuses(t, o)["10.0.0.99"]; failed.LastTransitionTime != phases["10.0.0.99"].LastTransitionTime {
+		t.Errorf("a failed node was retried before the retry interval")
+	}
+}
+
+func TestOperatorRetry(t *testing.T) {
+	cp := testutil.NewSSHServer(t).Respond("kubeadm token create", joinOutput)
+	node := testutil.NewSSHServer(t).Fail("kubelet.conf", "").Fail("kubeadm join", "[preflight] port 10250 is in use")
+	o := testOperator(t, cp, node, map[string]interface{}{"ip": "127.0.0.1"})
+	o.retry = 0
+
+	for i := 0; i < 2; i++ {
+		if err := o.reconcileAll(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if st := statuses(t, o)["127.0.0.1"]; st.Phase != phaseFailed || !strings.Contains(st.Message, "port 10250 is in use") {
+		t.Errorf("node: %+v, want Failed with the join output", st)
+	}
+	if n := joins(node); n != 2 {
+		t.Errorf("node received %d joins, want a retry after the failure", n)
+	}
+}
+
+func TestOperatorJoinAlreadyJoined(t *testing.T) {
+	cp := testutil.NewSSHServer(t)
+	node := testutil.NewSSHServer(t)
+	o := testOperator(t, cp, node)
+
+	var cs clusterSetup
+	cs.Spec.ControlPlane = "localhost"
+	cs.Spec.CredentialsSecret = "ssh"
+	if err := o.join(context.Background(), "infra", &cs, setupNode{IP: "127.0.0.1"}); err != nil {
+		t.Fatal(err)
+	}
+	// A node running a kubelet counts as joined
+	if n := joins(node); n != 0 {
+		t.Errorf("node received %d joins, want none", n)
+	}
+	for _, command := range cp.Commands() {
+		if strings.Contains(command, "kubeadm token create") {
+			t.Errorf("a join token was created for a joined node")
+		}
+	}
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/maarulav/k8s-setup/internal/report"
+	"github.com/maarulav/k8s-setup/pkg/config"
+	"github.com/maarulav/k8s-setup/pkg/kube"
+	"github.com/maarulav/k8s-setup/pkg/kubernetes"
+	"github.com/maarulav/k8s-setup/pkg/ssh"
+)
+
+// rebootTimeout is how long a host gets to come back after a reboot
+const rebootTimeout = 10 * time.Minute
+
+// patchTarget is a host to patch, the role it is patched with and the
+// outcome
+type patchTarget struct {
+	ip     string
+	role   string
+	node   string
+	result string
+}
+
+// patcher patches the hosts of a cluster one at a time
+type patcher struct {
+	cfg    *config.Config
+	cp     *ssh.Client
+	all    bool
+	reboot string
+}
+
+// runPatch installs OS updates on the control plane and the given nodes, one
+// host at a time: each is drained, patched, rebooted when needed and
+// uncordoned, and must be healthy again before the next one is drained. The
+// control plane goes first, then the nodes grouped by their roles. Like
+// provisioning, it keeps to the maintenance window.
+func runPatch(args []string) (err error) {
+	flags := flag.NewFlagSet("patch", flag.ExitOnError)
+	all := flags.Bool("all", false, "install every pending update instead of only the security updates")
+	reboot := flags.String("reboot", kubernetes.RebootAuto, "reboot the patched hosts: auto (when an update asks for it), always or never")
+	skipControlPlane := flags.Bool("skip-control-plane", false, "only patch the nodes")
+	skipBackup := flags.Bool("skip-backup", false, "do not back up the cluster before patching")
+	forceUnlock := flags.Bool("force-unlock", false, "break the locks other runs hold on the hosts")
+	ignoreWindow := flags.Bool("ignore-window", false, "start outside the maintenance window and run past it, e.g. for an emergency change")
+	flags.Parse(args)
+
+	if flags.NArg() < 2 {
+		return fmt.Errorf("usage: patch [--all] [--reboot auto|always|never] [--skip-control-plane] [--skip-backup] [--force-unlock] [--ignore-window] <config.json> <control-plane-ip> [node-ip...]")
+	}
+	switch *reboot {
+	case kubernetes.RebootAuto, kubernetes.RebootAlways, kubernetes.RebootNever:
+	default:
+		return fmt.Errorf("unknown reboot policy %q, expected auto, always or never", *reboot)
+	}
+
+	cfg, client, err := connect(flags.Arg(0), flags.Arg(1))
+	if err != nil {
+		return err
+	}
+	p := &patcher{cfg: cfg, cp: client, all: *all, reboot: *reboot}
+	defer func() { p.cp.Close() }()
+
+	nodes, err := resolveTargets(cfg, flags.Args()[2:])
+	if err != nil {
+		return err
+	}
+	targets, err := patchOrder(cfg, client.IP, nodes, *skipControlPlane)
+	if err != nil {
+		return err
+	}
+	deadline, err := windowDeadline(cfg, *ignoreWindow)
+	if err != nil {
+		return err
+	}
+
+	hosts := []string{client.IP}
+	for _, t := range targets {
+		if t.ip != client.IP {
+			hosts = append(hosts, t.ip)
+		}
+	}
+	unlock, err := lockHosts(cfg, hosts, "patch", *forceUnlock)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	summary := report.Summary{Start: time.Now()}
+	defer func() {
+		summary.End = time.Now()
+		if err != nil {
+			summary.Error = err.Error()
+		}
+		if err := report.Push(cfg.Pushgateway, cfg.ClusterName(client.IP), "patch", &summary); err != nil {
+			fmt.Printf("Warning: %v\n", err)
+		}
+	}()
+
+	if err := checkWindow(cfg, deadline, "patching"); err != nil {
+		return err
+	}
+	if err := recordOperation(client, cfg, "patch", *skipBackup); err != nil {
+		return err
+	}
+
+	defer printPatchResults(targets)
+	for i := range targets {
+		t := &targets[i]
+		if err := checkWindow(cfg, deadline, fmt.Sprintf("patching %s, %d remaining hosts were not patched", t.ip, len(targets)-i)); err != nil {
+			return err
+		}
+		if i == 0 || targets[i-1].role != t.role {
+			fmt.Printf("Patching %s hosts\n", t.role)
+		}
+		start := time.Now()
+		node, rebooted, err := p.patch(t.ip)
+		summary.Hosts = append(summary.Hosts, upgradeHost(t.ip, "patch", start, err))
+		t.node = node
+		if err != nil {
+			t.result = err.Error()
+			return fmt.Errorf("patching %s failed, %d remaining hosts were not patched: %v", t.ip, len(targets)-i-1, err)
+		}
+		t.result = "patched"
+		if rebooted {
+			t.result = "patched, rebooted"
+		}
+	}
+	return nil
+}
+
+// patchOrder returns the hosts to patch: the control plane, then the nodes
+// grouped by their roles
+func patchOrder(cfg *config.Config, controlPlane string, nodes []string, skipControlPlane bool) ([]patchTarget, error) {
+	var targets []patchTarget
+	if !skipControlPlane {
+		targets = append(targets, patchTarget{ip: controlPlane, role: "control-plane", result: "not patched"})
+	}
+	var workers []patchTarget
+	for _, ip := range nodes {
+		if ip == controlPlane {
+			continue
+		}
+		roles, err := cfg.HostRoles(ip)
+		if err != nil {
+			return nil, err
+		}
+		role := "worker"
+		if len(roles) > 0 {
+			role = strings.Join(roles, ",")
+		}
+		workers = append(workers, patchTarget{ip: ip, role: role, result: "not patched"})
+	}
+	sort.SliceStable(workers, func(i, j int) bool { return workers[i].role < workers[j].role })
+	return append(targets, workers...), nil
+}
+
+// patch drains the host at ip, installs its updates, reboots it according
+// to the reboot policy and uncordons it once it is Ready. It refuses to
+// start while another node is not Ready. It returns the node name of the
+// host and whether it was rebooted.
+func (p *patcher) patch(ip string) (string, bool, error) {
+	kc, err := kube.NewClient(p.cp)
+	if err != nil {
+		return "", false, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), kube.DefaultTimeout)
+	defer cancel()
+
+	notReady, err := kube.NotReadyNodes(ctx, kc)
+	if err != nil {
+		return "", false, err
+	}
+	if len(notReady) > 0 {
+		return "", false, fmt.Errorf("nodes %s are not Ready, not taking another one down", strings.Join(notReady, ", "))
+	}
+	node, err := kube.NodeByAddress(ctx, kc, ip)
+	if err != nil {
+		return "", false, err
+	}
+
+	host := p.cp
+	if ip != p.cp.IP {
+		if host, err = ssh.Connect(vmConfig(p.cfg, ip)); err != nil {
+			return node, false, fmt.Errorf("SSH connection failed: %v", err)
+		}
+		defer func() { host.Close() }()
+	}
+
+	fmt.Printf("Draining %s (%s)\n", node, ip)
+	if err := kubernetes.Drain(p.cp, node, kubernetes.DrainOptions{}); err != nil {
+		return node, false, err
+	}
+
+	fmt.Printf("Installing updates on %s\n", ip)
+	needsReboot, err := kubernetes.PatchHost(host, p.all)
+	if err != nil {
+		return node, false, fmt.Errorf("node %s stays cordoned: %v", node, err)
+	}
+
+	rebooted := false
+	switch {
+	case p.reboot == kubernetes.RebootAlways || (p.reboot == kubernetes.RebootAuto && needsReboot):
+		fmt.Printf("Rebooting %s\n", ip)
+		restarted, err := p.rebootHost(ip, host)
+		if err != nil {
+			return node, false, fmt.Errorf("node %s stays cordoned: %v", node, err)
+		}
+		if host == p.cp {
+			p.cp = restarted
+			if kc, err = kube.NewClient(p.cp); err != nil {
+				return node, true, err
+			}
+		}
+		host = restarted
+		rebooted = true
+	case needsReboot:
+		fmt.Printf("Warning: %s needs a reboot to complete its updates\n", ip)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), kube.DefaultTimeout)
+	defer cancel()
+	if err := kube.WaitForNodeReady(ctx, kc, node, kube.Print); err != nil {
+		return node, rebooted, fmt.Errorf("node %s stays cordoned: %v", node, err)
+	}
+	if err := kubernetes.Uncordon(p.cp, node); err != nil {
+		return node, rebooted, err
+	}
+	if err := kube.WaitForNodePods(ctx, kc, node, kube.Print); err != nil {
+		return node, rebooted, fmt.Errorf("workloads on node %s did not recover: %v", node, err)
+	}
+	return node, rebooted, nil
+}
+
+// rebootHost reboots the host at ip, closing host, and returns a new
+// connection once the host runs a new boot
+func (p *patcher) rebootHost(ip string, host *ssh.Client) (*ssh.Client, error) {
+	bootID, err := kubernetes.BootID(host)
+	if err != nil {
+		return nil, err
+	}
+	if err := kubernetes.Reboot(host); err != nil {
+		return nil, err
+	}
+	host.Close()
+
+	deadline := time.Now().Add(rebootTimeout)
+	for time.Now().Before(deadline) {
+		time.Sleep(10 * time.Second)
+		client, err := ssh.Connect(vmConfig(p.cfg, ip))
+		if err != nil {
+			continue
+		}
+		if id, err := kubernetes.BootID(client); err == nil && id != bootID {
+			return client, nil
+		}
+		client.Close()
+	}
+	return nil, fmt.Errorf("%s did not come back within %s", ip, rebootTimeout)
+}
+
+func printPatchResults(targets []patchTarget) {
+	if len(targets) == 0 {
+		return
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "HOST\tROLE\tNODE\tRESULT")
+	for _, t := range targets {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", t.ip, t.role, t.node, t.result)
+	}
+	w.Flush()
+}
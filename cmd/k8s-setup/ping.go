@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/maarulav/k8s-setup/pkg/config"
+	"github.com/maarulav/k8s-setup/pkg/ssh"
+)
+
+// pingResult is the outcome of probing a single host
+type pingResult struct {
+	ip         string
+	connect    time.Duration
+	roundTrip  time.Duration
+	authMethod string
+	shell      string
+	os         string
+	sudo       string
+	err        error
+}
+
+// runPing checks SSH access to every host without changing anything
+func runPing(args []string) error {
+	if len(args) < 1 {
+		return fmt.Errorf("usage: ping <config.json> [ip...]")
+	}
+
+	cfg, err := config.LoadConfig(args[0])
+	if err != nil {
+		return fmt.Errorf("failed to load configuration: %v", err)
+	}
+
+	ips, err := inventory(cfg, args[1:])
+	if err != nil {
+		return err
+	}
+	if len(ips) == 0 {
+		return fmt.Errorf("no hosts in the inventory and none given on the command line")
+	}
+
+	results := make([]pingResult, len(ips))
+	var wg sync.WaitGroup
+	for i, ip := range ips {
+		wg.Add(1)
+		go func(i int, ip string) {
+			defer wg.Done()
+			results[i] = ping(vmConfig(cfg, ip))
+		}(i, ip)
+	}
+	wg.Wait()
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "HOST\tCONNECT\tRTT\tAUTH\tSHELL\tOS\tSUDO\tERROR")
+	failed := 0
+	for _, r := range results {
+		if r.err != nil {
+			failed++
+			fmt.Fprintf(w, "%s\t-\t-\t-\t-\t-\t-\t%v\n", r.ip, r.err)
+			continue
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t\n", r.ip,
+			r.connect.Round(time.Millisecond), r.roundTrip.Round(time.Millisecond),
+			r.authMethod, r.shell, r.os, r.sudo)
+	}
+	w.Flush()
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d hosts unreachable", failed, len(results))
+	}
+	return nil
+}
+
+// ping connects to a host and collects read-only facts about it
+func ping(vm config.VMConfig) pingResult {
+	result := pingResult{ip: vm.IP}
+
+	start := time.Now()
+	client, err := ssh.Connect(vm)
+	if err != nil {
+		result.err = err
+		return result
+	}
+	defer client.Close()
+	result.connect = time.Since(start)
+	result.authMethod = client.AuthMethod
+	result.shell = client.Shell()
+
+	start = time.Now()
+	if _, err := client.ExecuteCommand("true"); err != nil {
+		result.err = fmt.Errorf("command execution failed: %v", err)
+		return result
+	}
+	result.roundTrip = time.Since(start)
+
+	if output, err := client.ExecuteCommand(". /etc/os-release && echo $PRETTY_NAME"); err == nil {
+		result.os = strings.TrimSpace(output)
+	} else {
+		result.os = "unknown"
+	}
+
+	result.sudo = sudoCapability(client, vm)
+	return result
+}
+
+// sudoCapability describes whether commands can run as root
+func sudoCapability(client *ssh.Client, vm config.VMConfig) string {
+	uid, err := client.ExecuteCommand("id -u")
+	if err != nil {
+		return "sudo failed"
+	}
+	if strings.TrimSpace(uid) == "0" {
+		if vm.Sudo {
+			return "sudo"
+		}
+		return "root"
+	}
+	if _, err := client.ExecuteCommand("sudo -n true"); err == nil {
+		return "passwordless sudo (not enabled)"
+	}
+	return "none"
+}
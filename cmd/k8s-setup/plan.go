@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"text/tabwriter"
+
+	"github.com/maarulav/k8s-setup/pkg/addons"
+	"github.com/maarulav/k8s-setup/pkg/config"
+	"github.com/maarulav/k8s-setup/pkg/plan"
+	"github.com/maarulav/k8s-setup/pkg/ssh"
+)
+
+// hostPlan is the detected size of one host and the warnings for it
+type hostPlan struct {
+	ip       string
+	capacity plan.Capacity
+	warnings []string
+	err      error
+}
+
+// runPlan prints what a provisioning run would install and its estimated
+// footprint, and compares it with the size of every host without changing
+// anything on them
+func runPlan(args []string) error {
+	if len(args) < 1 {
+		return fmt.Errorf("usage: plan <config.json> [ip...]")
+	}
+
+	cfg, err := config.LoadConfig(args[0])
+	if err != nil {
+		return fmt.Errorf("failed to load configuration: %v", err)
+	}
+
+	footprints, err := plan.Estimate(cfg)
+	if err != nil {
+		return err
+	}
+	total := plan.Total(footprints)
+
+	fmt.Println("Steps:")
+	for _, step := range plannedSteps(cfg) {
+		fmt.Printf("  - %s\n", step)
+	}
+	fmt.Println()
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "COMPONENT\tCPU\tMEMORY")
+	for _, f := range append(footprints, total) {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", f.Component, config.FormatCPU(f.CPU), config.FormatMemory(f.Memory))
+	}
+	w.Flush()
+	if len(cfg.Charts) > 0 {
+		fmt.Printf("The %d charts under charts are not included in the estimate\n", len(cfg.Charts))
+	}
+
+	ips, err := inventory(cfg, args[1:])
+	if err != nil {
+		return err
+	}
+	if len(ips) == 0 {
+		return nil
+	}
+
+	results := make([]hostPlan, len(ips))
+	var wg sync.WaitGroup
+	for i, ip := range ips {
+		wg.Add(1)
+		go func(i int, ip string) {
+			defer wg.Done()
+			results[i] = planHost(vmConfig(cfg, ip), total)
+		}(i, ip)
+	}
+	wg.Wait()
+
+	fmt.Println()
+	w = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "HOST\tCPU\tMEMORY\tWARNINGS")
+	undersized := 0
+	for _, r := range results {
+		if r.err != nil {
+			fmt.Fprintf(w, "%s\t-\t-\t%v\n", r.ip, r.err)
+			continue
+		}
+		if len(r.warnings) > 0 {
+			undersized++
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", r.ip, config.FormatCPU(r.capacity.CPU), config.FormatMemory(r.capacity.Memory), joinWarnings(r.warnings))
+	}
+	w.Flush()
+
+	if undersized > 0 {
+		return fmt.Errorf("%d of %d hosts are too small for the planned components", undersized, len(results))
+	}
+	return nil
+}
+
+// planHost detects the size of a host and checks it against the footprint
+func planHost(vm config.VMConfig, total plan.Footprint) hostPlan {
+	result := hostPlan{ip: vm.IP}
+
+	client, err := ssh.Connect(vm)
+	if err != nil {
+		result.err = err
+		return result
+	}
+	defer client.Close()
+
+	result.capacity, result.err = plan.Detect(client)
+	if result.err == nil {
+		result.warnings = plan.Check(total, result.capacity)
+	}
+	return result
+}
+
+// plannedSteps lists the steps a provisioning run goes through, as main runs them
+func plannedSteps(cfg *config.Config) []string {
+	steps := []string{"kubernetes"}
+	if len(cfg.Manifests) > 0 {
+		steps = append(steps, "manifests")
+	}
+	if len(cfg.Namespaces) > 0 {
+		steps = append(steps, "namespaces")
+	}
+	if len(cfg.Registries) > 0 {
+		steps = append(steps, "registries")
+	}
+	steps = append(steps, "monitoring")
+	for _, name := range addons.Enabled(cfg) {
+		steps = append(steps, "addon "+name)
+	}
+	for _, chart := range cfg.Charts {
+		steps = append(steps, "chart "+chart.Name)
+	}
+	if cfg.Backup.Volumes.Enabled {
+		steps = append(steps, "velero")
+	}
+	if cfg.Security.Enabled() {
+		steps = append(steps, "security")
+	}
+	return append(steps, "verification", "backup")
+}
+
+func joinWarnings(warnings []string) string {
+	if len(warnings) == 0 {
+		return "ok"
+	}
+	text := warnings[0]
+	for _, w := range warnings[1:] {
+		text += "; " + w
+	}
+	return text
+}
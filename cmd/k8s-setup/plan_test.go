@@ -0,0 +1,68 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/maarulav/k8s-setup/internal/status"
+	"github.com/maarulav/k8s-setup/pkg/config"
+)
+
+func TestPlannedSteps(t *testing.T) {
+	cfg := &config.Config{}
+	if got := strings.Join(plannedSteps(cfg), ","); got != "kubernetes,monitoring,verification,backup" {
+		t.Errorf("minimal steps %s", got)
+	}
+
+	cfg.Charts = []config.Chart{{Name: "app"}}
+	cfg.Backup.Volumes.Enabled = true
+	cfg.Security.Trivy = true
+	// Velero goes in after the charts and before the security scan, as main
+	// runs them
+	want := "kubernetes,monitoring,chart app,velero,security,verification,backup"
+	if got := strings.Join(plannedSteps(cfg), ","); got != want {
+		t.Errorf("steps %s, want %s", got, want)
+	}
+}
+
+func TestCheckDrift(t *testing.T) {
+	t.Chdir(t.TempDir())
+	if err := os.MkdirAll(status.Dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		policy string
+		ips    []string
+		force  bool
+		err    string
+	}{
+		{policy: "", ips: []string{"10.0.0.1", "10.0.0.2"}},
+		{policy: config.ConfigDriftWarn, ips: []string{"10.0.0.2"}},
+		{policy: config.ConfigDriftRefuse, ips: []string{"10.0.0.1", "10.0.0.2"}, err: "since 10.0.0.2 was provisioned"},
+		{policy: config.ConfigDriftRefuse, ips: []string{"10.0.0.2"}, force: true},
+		// Unchanged, unrecorded and new hosts have not drifted
+		{policy: config.ConfigDriftRefuse, ips: []string{"10.0.0.1", "10.0.0.3", "10.0.0.4"}},
+		{policy: "ignore", ips: []string{"10.0.0.1"}, err: `invalid configDrift "ignore"`},
+	} {
+		cfg := &config.Config{ConfigDrift: tc.policy}
+		for ip, hash := range map[string]string{
+			"10.0.0.1": cfg.Hash(),
+			"10.0.0.2": "0123456789abcdef",
+			// Provisioned before hashes were recorded
+			"10.0.0.3": "",
+		} {
+			s := status.New(ip)
+			s.ConfigHash = hash
+			if err := s.Save(); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		err := checkDrift(cfg, tc.ips, tc.force)
+		if tc.err == "" && err != nil || tc.err != "" && (err == nil || !strings.Contains(err.Error(), tc.err)) {
+			t.Errorf("checkDrift(%q, %v, force %t) = %v, want %q", tc.policy, tc.ips, tc.force, err, tc.err)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/maarulav/k8s-setup/internal/status"
+	"github.com/maarulav/k8s-setup/pkg/kubeconfig"
+	"github.com/maarulav/k8s-setup/pkg/kubernetes"
+	"github.com/maarulav/k8s-setup/pkg/monitoring"
+)
+
+// runRename gives a running cluster the name the configuration now sets for
+// it, cluster.name or the clusterName of its control plane: the kubeadm
+// configuration, the node labels, the Prometheus external labels, the status
+// entry and the merged kubeconfig context all take the new name.
+func runRename(args []string) error {
+	flags := flag.NewFlagSet("rename", flag.ExitOnError)
+	target := flags.String("kubeconfig", kubeconfig.DefaultPath(), "kubeconfig the cluster was merged into")
+	skipMonitoring := flags.Bool("skip-monitoring", false, "do not relabel the Prometheus stack")
+	forceUnlock := flags.Bool("force-unlock", false, "break the lock another run holds on the host")
+	flags.Parse(args)
+
+	if flags.NArg() != 2 {
+		return fmt.Errorf("usage: rename [--kubeconfig file] [--skip-monitoring] [--force-unlock] <config.json> <control-plane-ip>")
+	}
+
+	cfg, client, err := connect(flags.Arg(0), flags.Arg(1))
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	name := cfg.ClusterName(client.IP)
+	if name == client.IP {
+		return fmt.Errorf("no name configured for %s, set cluster.name or the clusterName of the host", client.IP)
+	}
+
+	unlock, err := lockHosts(cfg, []string{client.IP}, "rename", *forceUnlock)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	changed, err := kubernetes.RenameCluster(client, name)
+	if err != nil {
+		return err
+	}
+	if changed {
+		fmt.Printf("Set the kubeadm clusterName to %s\n", name)
+	}
+	fmt.Printf("Labeled the nodes with %s=%s\n", kubernetes.ClusterLabel, name)
+
+	if !*skipMonitoring {
+		installed, err := monitoring.Relabel(client, cfg, client.IP)
+		if err != nil {
+			return err
+		}
+		if installed {
+			fmt.Printf("Set the cluster label of Prometheus to %s\n", name)
+			if cfg.Monitoring.Federation.Enabled && cfg.Monitoring.Federation.Hub != client.IP {
+				fmt.Printf("Note: run the setup of the hub %s again to rename its Grafana data source\n", cfg.Monitoring.Federation.Hub)
+			}
+		}
+	}
+
+	return renameStatus(client.IP, name, *target)
+}
+
+// renameStatus records name in the status of the cluster at ip and renames
+// its context in the merged kubeconfig target
+func renameStatus(ip, name, target string) error {
+	s, err := status.Load(ip)
+	if err != nil {
+		fmt.Printf("Warning: no status for %s, no kubeconfig context renamed\n", ip)
+		return nil
+	}
+	s.ClusterName = name
+
+	if s.Context != "" && s.Context != name {
+		merged, err := kubeconfig.Load(target)
+		if err != nil {
+			return err
+		}
+		if merged.HasContext(s.Context) {
+			context, err := merged.Rename(s.Context, name)
+			if err != nil {
+				return err
+			}
+			if err := merged.Save(target); err != nil {
+				return err
+			}
+			fmt.Printf("Renamed context %s in %s to %s\n", s.Context, target, context)
+			s.Context = context
+		}
+	}
+	return saveStatus(s)
+}
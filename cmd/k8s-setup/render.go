@@ -0,0 +1,161 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/maarulav/k8s-setup/pkg/config"
+	"github.com/maarulav/k8s-setup/pkg/render"
+)
+
+// runRender dispatches the render subcommands
+func runRender(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("unknown render command, expected: render capi|operator|packer|script")
+	}
+	switch args[0] {
+	case "capi":
+		return renderCAPI(args[1:])
+	case "operator":
+		return renderOperator(args[1:])
+	case "packer":
+		return renderPacker(args[1:])
+	case "script":
+		return renderScript(args[1:])
+	default:
+		return fmt.Errorf("unknown render command, expected: render capi|operator|packer|script")
+	}
+}
+
+// renderPacker writes a Packer build and the node preparation script it runs
+func renderPacker(args []string) error {
+	flags := flag.NewFlagSet("render packer", flag.ExitOnError)
+	source := flags.String("source", "source.qemu.ubuntu", "Packer source the image is built from, defined in another file of the output directory")
+	output := flags.String("output", ".", "directory receiving k8s-node.pkr.hcl and k8s-node.sh")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		return fmt.Errorf("usage: render packer [--source source.type.name] [--output dir] <config.json>")
+	}
+
+	cfg, err := config.LoadConfig(flags.Arg(0))
+	if err != nil {
+		return fmt.Errorf("failed to load configuration: %v", err)
+	}
+	script, err := render.NodeScript(cfg)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(*output, 0755); err != nil {
+		return err
+	}
+	scriptPath := filepath.Join(*output, "k8s-node.sh")
+	if err := ioutil.WriteFile(scriptPath, []byte(script), 0755); err != nil {
+		return err
+	}
+	templatePath := filepath.Join(*output, "k8s-node.pkr.hcl")
+	if err := ioutil.WriteFile(templatePath, []byte(render.Packer(*source, "k8s-node.sh")), 0644); err != nil {
+		return err
+	}
+
+	fmt.Printf("Packer build written to %s and %s\n", templatePath, scriptPath)
+	return nil
+}
+
+// renderScript prints the node preparation script
+func renderScript(args []string) error {
+	flags := flag.NewFlagSet("render script", flag.ExitOnError)
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		return fmt.Errorf("usage: render script <config.json>")
+	}
+
+	cfg, err := config.LoadConfig(flags.Arg(0))
+	if err != nil {
+		return fmt.Errorf("failed to load configuration: %v", err)
+	}
+	script, err := render.NodeScript(cfg)
+	if err != nil {
+		return err
+	}
+	fmt.Print(script)
+	return nil
+}
+
+// renderCAPI writes Cluster API manifests for the bring-your-own-host
+// provider describing a cluster provisioned by the tool
+func renderCAPI(args []string) error {
+	flags := flag.NewFlagSet("render capi", flag.ExitOnError)
+	name := flags.String("name", "", "name of the Cluster (default k8s-setup-<control-plane-ip>)")
+	namespace := flags.String("namespace", "default", "namespace of the management cluster the objects go to")
+	output := flags.String("output", "", "write the manifests to this file instead of stdout")
+	flags.Parse(args)
+
+	if flags.NArg() < 2 {
+		return fmt.Errorf("usage: render capi [--name name] [--namespace ns] [--output file] <config.json> <control-plane-ip> [worker-ip...]")
+	}
+
+	cfg, err := config.LoadConfig(flags.Arg(0))
+	if err != nil {
+		return fmt.Errorf("failed to load configuration: %v", err)
+	}
+
+	topology := render.Topology{
+		Name:         *name,
+		Namespace:    *namespace,
+		ControlPlane: flags.Arg(1),
+		Workers:      flags.Args()[2:],
+	}
+	if topology.Name == "" {
+		topology.Name = "k8s-setup-" + strings.NewReplacer(".", "-", ":", "-").Replace(topology.ControlPlane)
+	}
+
+	manifests, err := render.CAPI(cfg, topology)
+	if err != nil {
+		return err
+	}
+	if *output == "" {
+		fmt.Print(manifests)
+		return nil
+	}
+	return ioutil.WriteFile(*output, []byte(manifests), 0644)
+}
+
+// renderOperator writes the manifests deploying the operator to a
+// management cluster
+func renderOperator(args []string) error {
+	flags := flag.NewFlagSet("render operator", flag.ExitOnError)
+	image := flags.String("image", "", "container image of k8s-setup the operator runs")
+	namespace := flags.String("namespace", "k8s-setup", "namespace the operator runs in")
+	configMap := flags.String("configmap", "k8s-setup-config", "ConfigMap holding the config.json of the operator")
+	storageClass := flags.String("storage-class", "", "storage class of the operator's status volume (default: the cluster's default class)")
+	storageSize := flags.String("storage-size", "1Gi", "size of the operator's status volume")
+	output := flags.String("output", "", "write the manifests to this file instead of stdout")
+	flags.Parse(args)
+
+	if flags.NArg() != 0 || *image == "" {
+		return fmt.Errorf("usage: render operator --image image [--namespace ns] [--configmap name] [--storage-class class] [--storage-size 1Gi] [--output file]")
+	}
+
+	manifests, err := render.Operator(render.OperatorDeployment{
+		Namespace:    *namespace,
+		Image:        *image,
+		ConfigMap:    *configMap,
+		StorageClass: *storageClass,
+		StorageSize:  *storageSize,
+	})
+	if err != nil {
+		return err
+	}
+	if *output == "" {
+		fmt.Print(manifests)
+		return nil
+	}
+	return ioutil.WriteFile(*output, []byte(manifests), 0644)
+}
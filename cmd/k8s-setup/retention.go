@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/maarulav/k8s-setup/internal/logger"
+	"github.com/maarulav/k8s-setup/internal/report"
+	"github.com/maarulav/k8s-setup/internal/status"
+	"github.com/maarulav/k8s-setup/pkg/config"
+)
+
+// bundleDir holds the default archives of the logs and diagnose commands
+var bundleDir = filepath.Join(status.Dir, "bundles")
+
+// bundlePatterns match the default archives of the logs and diagnose commands
+var bundlePatterns = []string{filepath.Join(bundleDir, "logs-*.tar.gz"), filepath.Join(bundleDir, "diagnose-*.tar.gz")}
+
+// bundlePath returns the default archive of a kind of bundle of the VM at ip
+func bundlePath(kind, ip string) (string, error) {
+	if err := os.MkdirAll(bundleDir, 0755); err != nil {
+		return "", err
+	}
+	return filepath.Join(bundleDir, fmt.Sprintf("%s-%s-%s.tar.gz", kind, ip, time.Now().Format("20060102-150405"))), nil
+}
+
+// prune applies the retention limits to the status directory, the run
+// records, the truncated command output and the support bundles
+func prune(log *logger.Logger, cfg *config.Config) {
+	if !cfg.Retention.Enabled() {
+		return
+	}
+	maxAge, err := cfg.Retention.MaxAgeDuration()
+	if err != nil {
+		log.Printf("Warning: skipping pruning: %v", err)
+		return
+	}
+	now := time.Now()
+
+	removed, err := status.Prune(maxAge, cfg.Retention.MaxCount, now)
+	if err != nil {
+		log.Printf("Warning: failed to prune status entries: %v", err)
+	}
+
+	patterns := append([]string{filepath.Join(report.RunsDir, "*.json")}, bundlePatterns...)
+	if dir := cfg.SSHConfig.Limits.OutputDir; dir != "" {
+		patterns = append(patterns, filepath.Join(dir, "*.log"))
+	}
+	for _, pattern := range patterns {
+		files, err := status.PruneFiles(pattern, maxAge, cfg.Retention.MaxCount, now)
+		if err != nil {
+			log.Printf("Warning: failed to prune %s: %v", pattern, err)
+		}
+		removed = append(removed, files...)
+	}
+
+	for _, path := range removed {
+		log.Printf("Pruned %s", path)
+	}
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/maarulav/k8s-setup/internal/events"
+	"github.com/maarulav/k8s-setup/internal/progress"
+	"github.com/maarulav/k8s-setup/internal/report"
+	"github.com/maarulav/k8s-setup/internal/status"
+	"github.com/maarulav/k8s-setup/internal/tracing"
+	"github.com/maarulav/k8s-setup/pkg/config"
+	"github.com/maarulav/k8s-setup/pkg/ssh"
+)
+
+// run carries what the steps of a provisioning run share: the maintenance
+// window deadline, the event sinks and the trace spans
+type run struct {
+	cfg      *config.Config
+	deadline time.Time
+	events   *events.Emitter
+	results  map[string]string
+	summary  report.Summary
+	progress *progress.Hub
+
+	ctx       context.Context
+	span      trace.Span
+	hostCtx   context.Context
+	hostSpan  trace.Span
+	stepSpan  trace.Span
+	executors []traceable
+
+	// steps are the completed steps of the current host, step the current
+	// one, stepStart when it began and commands what it ran so far
+	steps     []report.Step
+	step      string
+	stepStart time.Time
+	mu        sync.Mutex
+	commands  []string
+}
+
+// traceable executors parent their command spans to the current step
+type traceable interface {
+	SetTraceContext(ctx context.Context)
+}
+
+// logged executors pass their commands to the runbook of the host
+type logged interface {
+	SetCommandLog(log func(command string))
+}
+
+// hostEvent is the data of step and host events
+type hostEvent struct {
+	Host           string   `json:"host"`
+	Status         string   `json:"status"`
+	Step           string   `json:"step,omitempty"`
+	CompletedSteps []string `json:"completedSteps"`
+	Error          string   `json:"error,omitempty"`
+}
+
+func newRun(cfg *config.Config) *run {
+	ctx, span := tracing.Tracer().Start(context.Background(), "provision")
+	return &run{
+		cfg:     cfg,
+		events:  events.New(cfg.EventSinks),
+		results: map[string]string{},
+		summary: report.Summary{Start: time.Now()},
+		ctx:     ctx,
+		span:    span,
+	}
+}
+
+// startHost opens the span of the host at ip
+func (r *run) startHost(ip string) {
+	r.hostCtx, r.hostSpan = tracing.Tracer().Start(r.ctx, "host "+ip, trace.WithAttributes(attribute.String("host", ip)))
+	r.stepSpan = nil
+	r.executors = nil
+	r.steps = nil
+	r.step = ""
+	r.commands = nil
+	if r.progress != nil {
+		r.progress.SetHost(ip)
+	}
+}
+
+// trace parents the command spans of executor to the current host or step
+// and records its commands for the current step
+func (r *run) trace(executor interface{}) {
+	if t, ok := executor.(traceable); ok {
+		t.SetTraceContext(r.hostCtx)
+		r.executors = append(r.executors, t)
+	}
+	if l, ok := executor.(logged); ok {
+		l.SetCommandLog(r.logCommand)
+	}
+}
+
+// logCommand records command for the current step, generalized like an
+// allowlist entry so the runbook holds no tokens. Repeated commands, such as
+// those of wait loops, are recorded once.
+func (r *run) logCommand(command string) {
+	command = ssh.Generalize(command)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, c := range r.commands {
+		if c == command {
+			return
+		}
+	}
+	r.commands = append(r.commands, command)
+}
+
+// end closes the span of the run
+func (r *run) end() {
+	r.span.End()
+}
+
+// begin marks step, shown as description, as the current one and runs its
+// before hooks. When the maintenance window leaves less than its reserve, the
+// status is saved as a checkpoint with the steps completed so far and false
+// is returned instead, as it is when a required hook fails.
+func (r *run) begin(s *status.SetupStatus, step, description string) bool {
+	if err := checkWindow(r.cfg, r.deadline, fmt.Sprintf("%q", description)); err != nil {
+		s.Status = "Aborted"
+		s.Error = err.Error()
+		s.EndTime = time.Now()
+		r.finish(s)
+		log.Printf("Stopping setup for VM %s: %s", s.VMIP, s.Error)
+		return false
+	}
+
+	s.CurrentStep = description
+	r.step = step
+	r.stepStart = time.Now()
+	r.mu.Lock()
+	r.commands = nil
+	r.mu.Unlock()
+	if err := r.runHooks(s, config.HookBefore, step); err != nil {
+		s.Status = "Failed"
+		s.Error = fmt.Sprintf("Hook before %s failed: %v", step, err)
+		r.finish(s)
+		return false
+	}
+
+	if r.stepSpan != nil {
+		r.stepSpan.End()
+	}
+	var ctx context.Context
+	ctx, r.stepSpan = tracing.Tracer().Start(r.hostCtx, description)
+	for _, executor := range r.executors {
+		executor.SetTraceContext(ctx)
+	}
+
+	r.events.Emit(events.StepStarted, s.VMIP, r.event(s))
+	return true
+}
+
+// complete records step as completed and runs its after hooks
+func (r *run) complete(s *status.SetupStatus, step string) {
+	s.CompletedSteps = append(s.CompletedSteps, step)
+	r.mu.Lock()
+	r.steps = append(r.steps, report.Step{Name: step, Duration: time.Since(r.stepStart), Commands: r.commands})
+	r.commands = nil
+	r.mu.Unlock()
+	r.runHooks(s, config.HookAfter, step)
+	r.events.Emit(events.StepCompleted, s.VMIP, hostEvent{
+		Host:           s.VMIP,
+		Status:         s.Status,
+		Step:           step,
+		CompletedSteps: s.CompletedSteps,
+	})
+}
+
+// finish saves the status of a host that failed, was aborted or completed
+func (r *run) finish(s *status.SetupStatus) {
+	s.Save()
+	r.results[s.VMIP] = s.Status
+	host := report.NewHost(s, time.Now())
+	host.Steps = r.steps
+	if s.Status == "Failed" {
+		host.FailedStep = r.step
+	}
+	r.summary.Hosts = append(r.summary.Hosts, host)
+
+	var err error
+	if s.Status != "Completed" {
+		err = errors.New(s.Error)
+	}
+	if r.stepSpan != nil {
+		tracing.End(r.stepSpan, err)
+		r.stepSpan = nil
+	}
+	if r.hostSpan != nil {
+		tracing.End(r.hostSpan, err)
+	}
+
+	eventType := events.HostFailed
+	switch s.Status {
+	case "Completed":
+		eventType = events.HostCompleted
+	case "Aborted":
+		eventType = events.HostAborted
+	}
+	r.events.Emit(eventType, s.VMIP, r.event(s))
+}
+
+func (r *run) event(s *status.SetupStatus) hostEvent {
+	return hostEvent{
+		Host:           s.VMIP,
+		Status:         s.Status,
+		Step:           s.CurrentStep,
+		CompletedSteps: s.CompletedSteps,
+		Error:          s.Error,
+	}
+}
+
+// verification records the outcome of the verification step, failed with
+// failure unless it is empty
+func verification(failure string) *status.Verification {
+	return &status.Verification{Time: time.Now(), Passed: failure == "", Error: failure}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/maarulav/k8s-setup/internal/status"
+	"github.com/maarulav/k8s-setup/pkg/config"
+)
+
+// commandLogger is an executor that only passes commands to its log
+type commandLogger struct {
+	log func(command string)
+}
+
+func (c *commandLogger) SetCommandLog(log func(command string)) {
+	c.log = log
+}
+
+func TestRunStepCommands(t *testing.T) {
+	r := newRun(&config.Config{})
+	s := status.New("10.0.0.1")
+	executor := &commandLogger{}
+	r.startHost(s.VMIP)
+	r.trace(executor)
+
+	r.begin(s, "kubernetes", "Installing Kubernetes")
+	executor.log("kubeadm join 10.0.0.1:6443 --token abcdef.0123456789abcdef")
+	executor.log("kubectl get nodes")
+	// Wait loops poll with the same command
+	executor.log("kubectl get nodes")
+	r.complete(s, "kubernetes")
+
+	r.begin(s, "roles", "Labelling nodes")
+	r.complete(s, "roles")
+
+	want := []string{"kubeadm join {ip}:6443 --token {token}", "kubectl get nodes"}
+	if got := r.steps[0].Commands; !reflect.DeepEqual(got, want) {
+		t.Errorf("commands of the first step %q, want %q", got, want)
+	}
+	if got := r.steps[1].Commands; len(got) != 0 {
+		t.Errorf("commands of the second step %q, want none", got)
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/maarulav/k8s-setup/internal/report"
+	"github.com/maarulav/k8s-setup/internal/status"
+	"github.com/maarulav/k8s-setup/pkg/backup"
+	"github.com/maarulav/k8s-setup/pkg/config"
+	"github.com/maarulav/k8s-setup/pkg/helm"
+	"github.com/maarulav/k8s-setup/pkg/kube"
+	"github.com/maarulav/k8s-setup/pkg/monitoring"
+	"github.com/maarulav/k8s-setup/pkg/ssh"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// saveRunbook writes the handover document of the cluster of s, reading the
+// installed versions through cluster, and returns its path
+func saveRunbook(r *run, s *status.SetupStatus, cfg *config.Config, cluster ssh.Executor) (string, error) {
+	rb, err := collectRunbook(s, cfg, cluster)
+	if err != nil {
+		return "", err
+	}
+	for _, step := range r.steps {
+		rb.Steps = append(rb.Steps, report.Step{Name: step.Name, Duration: step.Duration.Round(time.Second), Commands: step.Commands})
+	}
+
+	var text, name string
+	switch cfg.Runbook.Format {
+	case "", config.RunbookMarkdown:
+		text, err = rb.Markdown()
+		name = "runbook.md"
+	case config.RunbookHTML:
+		text, err = rb.HTML()
+		name = "runbook.html"
+	default:
+		return "", fmt.Errorf("unknown runbook format %q", cfg.Runbook.Format)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if err := os.MkdirAll(s.WorkDir(), 0700); err != nil {
+		return "", err
+	}
+	path := filepath.Join(s.WorkDir(), name)
+	return path, ioutil.WriteFile(path, []byte(text), 0600)
+}
+
+// apiServerAddress returns the address clients reach the API server of the
+// cluster at ip on
+func apiServerAddress(cfg *config.Config, ip string) string {
+	if cfg.Kubernetes.AdvertiseAddress != "" {
+		return cfg.Kubernetes.AdvertiseAddress
+	}
+	return ip
+}
+
+// collectRunbook gathers the versions, endpoints, credentials and backups of
+// the cluster of s
+func collectRunbook(s *status.SetupStatus, cfg *config.Config, cluster ssh.Executor) (*report.Runbook, error) {
+	ip := s.VMIP
+	rb := &report.Runbook{
+		Cluster:   cfg.ClusterName(ip),
+		Host:      ip,
+		Generated: time.Now(),
+	}
+
+	rb.Components = append(rb.Components, report.Entry{Name: "Kubernetes", Value: cfg.Kubernetes.Version})
+	releases, err := helm.List(cluster)
+	if err != nil {
+		return nil, err
+	}
+	for _, release := range releases {
+		rb.Components = append(rb.Components, report.Entry{
+			Name:  fmt.Sprintf("%s/%s (%s)", release.Namespace, release.Name, release.ChartName()),
+			Value: release.ChartVersion(),
+		})
+	}
+
+	kc, err := kube.NewClient(cluster)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), kube.DefaultTimeout)
+	defer cancel()
+	nodes, err := kc.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list nodes: %v", err)
+	}
+	for _, node := range nodes.Items {
+		var roles []string
+		for label := range node.Labels {
+			if role := strings.TrimPrefix(label, "node-role.kubernetes.io/"); role != label {
+				roles = append(roles, role)
+			}
+		}
+		sort.Strings(roles)
+		info := node.Status.NodeInfo
+		rb.Nodes = append(rb.Nodes, report.Node{
+			Name:             node.Name,
+			Roles:            strings.Join(roles, ","),
+			KubeletVersion:   info.KubeletVersion,
+			ContainerRuntime: info.ContainerRuntimeVersion,
+			OS:               info.OSImage,
+		})
+	}
+
+	rb.Endpoints = append(rb.Endpoints, report.Entry{Name: "API server", Value: fmt.Sprintf("https://%s:6443", apiServerAddress(cfg, ip))})
+	if grafana, ok := monitoring.GrafanaURL(cfg, ip); ok {
+		if grafana == "" {
+			grafana = "kubectl -n monitoring port-forward svc/prometheus-grafana 3000:80, then http://localhost:3000"
+		}
+		rb.Endpoints = append(rb.Endpoints, report.Entry{Name: "Grafana", Value: grafana})
+	}
+	prometheus := monitoring.PrometheusURL(cfg, ip)
+	if prometheus == "" {
+		prometheus = "kubectl -n monitoring port-forward svc/prometheus-operated 9090, then http://localhost:9090"
+	}
+	rb.Endpoints = append(rb.Endpoints, report.Entry{Name: "Prometheus", Value: prometheus})
+
+	if s.Credentials != "" {
+		rb.Credentials = append(rb.Credentials, report.Entry{Name: "Credentials summary", Value: s.Credentials})
+	}
+	kubeconfig := ip + ":/etc/kubernetes/admin.conf"
+	if s.Kubeconfig != "" {
+		kubeconfig = s.Kubeconfig + ", " + kubeconfig
+	}
+	rb.Credentials = append(rb.Credentials, report.Entry{Name: "Admin kubeconfig", Value: kubeconfig})
+	if _, ok := monitoring.GrafanaURL(cfg, ip); ok {
+		rb.Credentials = append(rb.Credentials, report.Entry{Name: "Grafana admin password", Value: "Secret monitoring/grafana-admin, key admin-password"})
+	}
+	if key := vmConfig(cfg, ip).KeyFile; key != "" {
+		rb.Credentials = append(rb.Credentials, report.Entry{Name: "SSH key", Value: key})
+	}
+	if s.SealedSecretsCert != "" {
+		rb.Credentials = append(rb.Credentials, report.Entry{Name: "Sealed-secrets certificate", Value: s.SealedSecretsCert})
+	}
+	if cfg.Backup.Volumes.Enabled {
+		rb.Credentials = append(rb.Credentials, report.Entry{Name: "Backup bucket credentials", Value: "Secret velero/velero, key cloud"})
+	}
+
+	for _, location := range backup.Locations(cfg, ip) {
+		rb.Backups = append(rb.Backups, report.Entry{Name: location.Kind, Value: location.Path})
+	}
+	for _, op := range s.Operations {
+		if op.Backup != "" {
+			rb.Backups = append(rb.Backups, report.Entry{
+				Name:  fmt.Sprintf("Before %s on %s", op.Name, op.StartTime.Format("2006-01-02 15:04")),
+				Value: op.Backup,
+			})
+		}
+	}
+	return rb, nil
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/maarulav/k8s-setup/internal/status"
+	"github.com/maarulav/k8s-setup/pkg/addons"
+	"github.com/maarulav/k8s-setup/pkg/ssh"
+)
+
+// saveSealedSecretsCert keeps the public certificate of the sealed-secrets
+// controller next to the status file of the VM, for kubeseal --cert
+func saveSealedSecretsCert(s *status.SetupStatus, client ssh.Executor) (string, error) {
+	cert, err := addons.SealedSecretsCert(client)
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(s.WorkDir(), 0700); err != nil {
+		return "", err
+	}
+
+	path := filepath.Join(s.WorkDir(), "sealed-secrets.pem")
+	return path, ioutil.WriteFile(path, cert, 0644)
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/maarulav/k8s-setup/internal/status"
+	"github.com/maarulav/k8s-setup/pkg/security"
+)
+
+// saveSecurityReport writes the scan findings next to the status file of the VM
+func saveSecurityReport(s *status.SetupStatus, report *security.Report) (string, error) {
+	if err := os.MkdirAll(s.WorkDir(), 0700); err != nil {
+		return "", err
+	}
+
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return "", err
+	}
+
+	path := filepath.Join(s.WorkDir(), "security-report.json")
+	return path, ioutil.WriteFile(path, data, 0600)
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/maarulav/k8s-setup/internal/selfupdate"
+)
+
+// runSelfUpdate replaces the running binary with the latest release
+func runSelfUpdate(args []string) error {
+	flags := flag.NewFlagSet("self-update", flag.ExitOnError)
+	endpoint := flags.String("endpoint", selfupdate.DefaultEndpoint, "release endpoint returning a GitHub style release JSON")
+	check := flags.Bool("check", false, "only report whether an update is available")
+	force := flags.Bool("force", false, "reinstall even if the latest release is not newer")
+	flags.Parse(args)
+
+	release, err := selfupdate.Latest(*endpoint)
+	if err != nil {
+		return err
+	}
+
+	if !release.Newer(version) && !*force {
+		fmt.Printf("k8s-setup %s is up to date\n", version)
+		return nil
+	}
+
+	fmt.Printf("Update available: %s -> %s\n", version, release.Tag)
+	if *check {
+		return nil
+	}
+
+	if updatePublicKey == "" {
+		fmt.Println("Warning: this build has no update signing key, only the checksum is verified")
+	}
+
+	data, err := release.Download(updatePublicKey)
+	if err != nil {
+		return err
+	}
+
+	path, err := selfupdate.Replace(data)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Updated %s to %s\n", path, release.Tag)
+	return nil
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/maarulav/k8s-setup/pkg/config"
+	"github.com/maarulav/k8s-setup/pkg/ssh"
+)
+
+// rotateResult is the outcome of rotating the key of one host
+type rotateResult struct {
+	ip        string
+	installed bool
+	verified  bool
+	password  string
+	err       error
+}
+
+// runSSH dispatches the ssh subcommands
+func runSSH(args []string) error {
+	if len(args) == 0 || args[0] != "rotate-keys" {
+		return fmt.Errorf("unknown ssh command, expected: ssh rotate-keys")
+	}
+	return rotateKeys(args[1:])
+}
+
+// rotateKeys installs a new key on every host, verifies that it logs in and
+// only then, when asked to, removes the old key and disables password logins
+func rotateKeys(args []string) error {
+	flags := flag.NewFlagSet("ssh rotate-keys", flag.ExitOnError)
+	keyFile := flags.String("key", "", "existing private key to install instead of generating one")
+	output := flags.String("output", "", "file the generated private key is written to (default ~/.ssh/k8s-setup-<date>)")
+	disablePassword := flags.Bool("disable-password", false, "disable password authentication in sshd once the new key logs in")
+	removeOld := flags.Bool("remove-old", false, "remove the configured key from authorized_keys once the new key logs in")
+	hosts := flags.String("hosts", "", "comma separated targets in addition to the inventory")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		return fmt.Errorf("usage: ssh rotate-keys [--key file | --output file] [--disable-password] [--remove-old] [--hosts ip,...] <config.json>")
+	}
+
+	cfg, err := config.LoadConfig(flags.Arg(0))
+	if err != nil {
+		return fmt.Errorf("failed to load configuration: %v", err)
+	}
+
+	var extra []string
+	if *hosts != "" {
+		extra = strings.Split(*hosts, ",")
+	}
+	ips, err := inventory(cfg, extra)
+	if err != nil {
+		return err
+	}
+	if len(ips) == 0 {
+		return fmt.Errorf("no hosts in the inventory and none given with --hosts")
+	}
+
+	newKey := *keyFile
+	var authorized string
+	if newKey != "" {
+		authorized, err = ssh.PublicKey(newKey)
+	} else {
+		newKey, err = newKeyPath(*output)
+		if err == nil {
+			authorized, err = ssh.GenerateKey(newKey, "k8s-setup "+time.Now().Format("2006-01-02"))
+			fmt.Printf("Generated %s\n", newKey)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	results := make([]rotateResult, len(ips))
+	var wg sync.WaitGroup
+	for i, ip := range ips {
+		wg.Add(1)
+		go func(i int, ip string) {
+			defer wg.Done()
+			results[i] = rotateKey(vmConfig(cfg, ip), newKey, authorized, *removeOld, *disablePassword)
+		}(i, ip)
+	}
+	wg.Wait()
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "HOST\tKEY INSTALLED\tKEY LOGIN\tPASSWORD AUTH\tERROR")
+	failed := 0
+	for _, r := range results {
+		errText := ""
+		if r.err != nil {
+			failed++
+			errText = r.err.Error()
+		}
+		fmt.Fprintf(w, "%s\t%t\t%t\t%s\t%s\n", r.ip, r.installed, r.verified, r.password, errText)
+	}
+	w.Flush()
+
+	if failed > 0 {
+		return fmt.Errorf("key rotation failed on %d of %d hosts", failed, len(results))
+	}
+	fmt.Printf("Set \"keyFile\": %q in the ssh section of %s\n", newKey, flags.Arg(0))
+	return nil
+}
+
+// newKeyPath returns where a generated key goes, refusing to overwrite a key
+func newKeyPath(output string) (string, error) {
+	if output == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		output = filepath.Join(home, ".ssh", "k8s-setup-"+time.Now().Format("20060102"))
+		if err := os.MkdirAll(filepath.Dir(output), 0700); err != nil {
+			return "", err
+		}
+	}
+	if _, err := os.Stat(output); err == nil {
+		return "", fmt.Errorf("%s already exists, pass it with --key or choose another --output", output)
+	}
+	return output, nil
+}
+
+// rotateKey installs authorized on the host of vm and logs in with newKey
+// alone. The old key and password logins are only removed after that login
+// succeeded, so a host never ends up locked out.
+func rotateKey(vm config.VMConfig, newKey, authorized string, removeOld, disablePassword bool) rotateResult {
+	result := rotateResult{ip: vm.IP, password: "unchanged"}
+
+	client, err := ssh.Connect(vm)
+	if err != nil {
+		result.err = err
+		return result
+	}
+	defer client.Close()
+
+	if result.err = client.InstallAuthorizedKey(vm.Username, authorized); result.err != nil {
+		return result
+	}
+	result.installed = true
+
+	if result.err = verifyKeyLogin(vm, newKey); result.err != nil {
+		return result
+	}
+	result.verified = true
+
+	if removeOld && vm.KeyFile != "" && vm.KeyFile != newKey {
+		old, err := ssh.PublicKey(vm.KeyFile)
+		if err == nil {
+			err = client.RemoveAuthorizedKey(vm.Username, old)
+		}
+		if err != nil {
+			result.err = fmt.Errorf("old key: %v", err)
+			return result
+		}
+	}
+
+	if disablePassword {
+		if result.err = client.DisablePasswordAuth(); result.err != nil {
+			return result
+		}
+		result.password = "disabled"
+		if err := verifyKeyLogin(vm, newKey); err != nil {
+			result.err = fmt.Errorf("key login failed after disabling passwords: %v", err)
+		}
+	}
+	return result
+}
+
+// verifyKeyLogin connects to the host of vm with newKey as the only
+// authentication method
+func verifyKeyLogin(vm config.VMConfig, newKey string) error {
+	vm.KeyFile, vm.CertFile = newKey, ""
+	vm.Password, vm.KeyboardInteractive = "", false
+
+	client, err := ssh.Connect(vm)
+	if err != nil {
+		return fmt.Errorf("login with the new key failed: %v", err)
+	}
+	defer client.Close()
+
+	if _, err := client.ExecuteCommand("true"); err != nil {
+		return fmt.Errorf("command with the new key failed: %v", err)
+	}
+	return nil
+}
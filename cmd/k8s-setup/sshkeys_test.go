@@ -0,0 +1,155 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/maarulav/k8s-setup/internal/testutil"
+	"github.com/maarulav/k8s-setup/pkg/config"
+	"github.com/maarulav/k8s-setup/pkg/ssh"
+)
+
+// generateKey writes a key pair named name to a temporary directory and
+// returns the private key file and the public key
+func generateKey(t *testing.T, name string) (string, string) {
+	file := filepath.Join(t.TempDir(), name)
+	authorized, err := ssh.GenerateKey(file, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return file, authorized
+}
+
+// keyVM returns the settings of server logging in with keyFile
+func keyVM(server *testutil.SSHServer, keyFile string) config.VMConfig {
+	vm := server.VMConfig()
+	vm.KeyFile, vm.Password = keyFile, ""
+	return vm
+}
+
+// commandsWith returns the commands of server containing match
+func commandsWith(server *testutil.SSHServer, match string) []string {
+	var commands []string
+	for _, command := range server.Commands() {
+		if strings.Contains(command, match) {
+			commands = append(commands, command)
+		}
+	}
+	return commands
+}
+
+func TestRotateKey(t *testing.T) {
+	oldKey, oldAuthorized := generateKey(t, "old")
+	newKey, newAuthorized := generateKey(t, "new")
+	// The server takes the new key as if the install had written it
+	server := testutil.NewSSHServer(t).AuthorizeKey(t, oldAuthorized).AuthorizeKey(t, newAuthorized)
+
+	r := rotateKey(keyVM(server, oldKey), newKey, newAuthorized, false, false)
+	if r.err != nil {
+		t.Fatal(r.err)
+	}
+	if !r.installed || !r.verified || r.password != "unchanged" {
+		t.Errorf("result %+v, want the key installed and verified", r)
+	}
+
+	installs := commandsWith(server, "authorized_keys")
+	if len(installs) != 1 {
+		t.Fatalf("authorized_keys changed by %v, want one install", installs)
+	}
+	// The key is appended unless its type and base64 are present, whatever
+	// the comment
+	body := strings.Join(strings.Fields(newAuthorized)[:2], " ")
+	if !strings.Contains(installs[0], "grep -qF '"+body+"'") || !strings.Contains(installs[0], "echo '"+newAuthorized+"' >>") {
+		t.Errorf("install command %q does not add the new key once", installs[0])
+	}
+	if !strings.Contains(installs[0], "getent passwd "+testutil.SSHUser) || !strings.Contains(installs[0], "chmod 600") {
+		t.Errorf("install command %q does not write the user's authorized_keys privately", installs[0])
+	}
+	if len(commandsWith(server, "sshd")) != 0 {
+		t.Error("sshd was reconfigured without --disable-password")
+	}
+}
+
+func TestRotateKeyRemoveOld(t *testing.T) {
+	oldKey, oldAuthorized := generateKey(t, "old")
+	newKey, newAuthorized := generateKey(t, "new")
+	server := testutil.NewSSHServer(t).AuthorizeKey(t, oldAuthorized).AuthorizeKey(t, newAuthorized)
+
+	r := rotateKey(keyVM(server, oldKey), newKey, newAuthorized, true, false)
+	if r.err != nil {
+		t.Fatal(r.err)
+	}
+
+	removals := commandsWith(server, "grep -vF")
+	if len(removals) != 1 {
+		t.Fatalf("removals %v, want one", removals)
+	}
+	oldBody := strings.Join(strings.Fields(oldAuthorized)[:2], " ")
+	if !strings.Contains(removals[0], "grep -vF '"+oldBody+"'") || strings.Contains(removals[0], strings.Fields(newAuthorized)[1]) {
+		t.Errorf("removal %q, want only the old key filtered out", removals[0])
+	}
+	// The old key goes only after the new one logged in
+	commands := server.Commands()
+	if !strings.Contains(commands[len(commands)-1], "grep -vF") {
+		t.Errorf("commands %v, want the removal last", commands)
+	}
+
+	// Rotating to the configured key itself keeps it
+	server = testutil.NewSSHServer(t).AuthorizeKey(t, oldAuthorized)
+	if r := rotateKey(keyVM(server, oldKey), oldKey, oldAuthorized, true, false); r.err != nil || len(commandsWith(server, "grep -vF")) != 0 {
+		t.Errorf("result %+v, the configured key was removed while installed again", r)
+	}
+}
+
+func TestRotateKeyDisablePassword(t *testing.T) {
+	newKey, newAuthorized := generateKey(t, "new")
+	server := testutil.NewSSHServer(t).AuthorizeKey(t, newAuthorized)
+
+	// Logged in with the password, which is disabled once the key works
+	r := rotateKey(server.VMConfig(), newKey, newAuthorized, true, true)
+	if r.err != nil {
+		t.Fatal(r.err)
+	}
+	if r.password != "disabled" {
+		t.Errorf("password auth %q, want disabled", r.password)
+	}
+	disables := commandsWith(server, "PasswordAuthentication no")
+	if len(disables) != 1 || !strings.Contains(disables[0], "sshd -t") {
+		t.Fatalf("sshd commands %v, want one validated change", disables)
+	}
+	// Without a configured key there is no old key to remove
+	if len(commandsWith(server, "grep -vF")) != 0 {
+		t.Error("a key was removed although none was configured")
+	}
+	// The key logs in again after sshd reloaded
+	commands := server.Commands()
+	if commands[len(commands)-1] != "true" {
+		t.Errorf("commands %v, want a login with the key last", commands)
+	}
+
+	server = testutil.NewSSHServer(t).AuthorizeKey(t, newAuthorized).Fail("sshd -t", "/etc/ssh/sshd_config line 3: Bad configuration option")
+	r = rotateKey(server.VMConfig(), newKey, newAuthorized, false, true)
+	if r.err == nil || !strings.Contains(r.err.Error(), "Bad configuration option") || r.password != "unchanged" {
+		t.Errorf("result %+v, want the sshd failure and passwords unchanged", r)
+	}
+}
+
+func TestRotateKeyLoginFails(t *testing.T) {
+	oldKey, oldAuthorized := generateKey(t, "old")
+	newKey, newAuthorized := generateKey(t, "new")
+	// The install does not take, say authorized_keys is managed elsewhere
+	server := testutil.NewSSHServer(t).AuthorizeKey(t, oldAuthorized)
+
+	r := rotateKey(keyVM(server, oldKey), newKey, newAuthorized, true, true)
+	if r.err == nil || !strings.Contains(r.err.Error(), "login with the new key failed") {
+		t.Fatalf("error %v, want the new key refused", r.err)
+	}
+	if !r.installed || r.verified || r.password != "unchanged" {
+		t.Errorf("result %+v, want installed but not verified", r)
+	}
+	// Nothing that could lock the host out happened
+	if len(commandsWith(server, "grep -vF")) != 0 || len(commandsWith(server, "sshd")) != 0 {
+		t.Errorf("commands %v, want neither the old key nor passwords removed", server.Commands())
+	}
+}
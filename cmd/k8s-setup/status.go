@@ -0,0 +1,223 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/maarulav/k8s-setup/internal/report"
+	"github.com/maarulav/k8s-setup/internal/status"
+)
+
+// runStatus dispatches the status subcommands
+func runStatus(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("unknown status command, expected: status runs|compare|facts")
+	}
+	switch args[0] {
+	case "runs":
+		return statusRuns(args[1:])
+	case "compare":
+		return statusCompare(args[1:])
+	case "facts":
+		return statusFacts(args[1:])
+	default:
+		return fmt.Errorf("unknown status command, expected: status runs|compare|facts")
+	}
+}
+
+// statusRuns prints the recorded runs, oldest first
+func statusRuns(args []string) error {
+	flags := flag.NewFlagSet("status runs", flag.ExitOnError)
+	asJSON := flags.Bool("json", false, "print as JSON")
+	flags.Parse(args)
+
+	runs, err := report.ListRuns()
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		return printJSON(runs)
+	}
+	if len(runs) == 0 {
+		fmt.Printf("No runs recorded in %s\n", report.RunsDir)
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tOPERATION\tHOSTS\tFAILED\tDURATION")
+	for _, run := range runs {
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%s\n", run.ID, run.Operation, len(run.Hosts), run.Failed(), run.End.Sub(run.Start).Round(time.Second))
+	}
+	return w.Flush()
+}
+
+// stepComparison is a step of status compare as printed with --json
+type stepComparison struct {
+	Step          string   `json:"step"`
+	Before        string   `json:"before,omitempty"`
+	After         string   `json:"after,omitempty"`
+	HostsBefore   int      `json:"hostsBefore"`
+	HostsAfter    int      `json:"hostsAfter"`
+	FailedBefore  int      `json:"failedBefore"`
+	FailedAfter   int      `json:"failedAfter"`
+	Slower        bool     `json:"slower,omitempty"`
+	NewlyFailing  bool     `json:"newlyFailing,omitempty"`
+	NewlyFailedOn []string `json:"newlyFailedOn,omitempty"`
+}
+
+// statusCompare diffs the step durations and failures of two runs across
+// their hosts and fails when the second one regressed
+func statusCompare(args []string) error {
+	flags := flag.NewFlagSet("status compare", flag.ExitOnError)
+	threshold := flags.Float64("threshold", 20, "percent a step has to get slower by to be reported")
+	minDelta := flags.Duration("min-delta", 5*time.Second, "smallest slowdown reported, so that short steps do not flap")
+	asJSON := flags.Bool("json", false, "print as JSON")
+	flags.Parse(args)
+
+	if flags.NArg() != 2 {
+		return fmt.Errorf("usage: status compare [--threshold 20] [--min-delta 5s] [--json] <run-a> <run-b>")
+	}
+	a, err := report.LoadRun(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	b, err := report.LoadRun(flags.Arg(1))
+	if err != nil {
+		return err
+	}
+
+	changes := report.Compare(a, b)
+	steps := make([]stepComparison, 0, len(changes))
+	regressions := 0
+	for _, c := range changes {
+		step := stepComparison{
+			Step:          c.Step,
+			HostsBefore:   c.HostsBefore,
+			HostsAfter:    c.HostsAfter,
+			FailedBefore:  c.FailedBefore,
+			FailedAfter:   c.FailedAfter,
+			Slower:        c.Slower(*threshold/100, *minDelta),
+			NewlyFailing:  c.NewlyFailing(),
+			NewlyFailedOn: c.NewlyFailedOn(),
+		}
+		if c.Before > 0 {
+			step.Before = c.Before.Round(time.Second).String()
+		}
+		if c.After > 0 {
+			step.After = c.After.Round(time.Second).String()
+		}
+		if step.Slower || step.NewlyFailing {
+			regressions++
+		}
+		steps = append(steps, step)
+	}
+
+	if *asJSON {
+		if err := printJSON(steps); err != nil {
+			return err
+		}
+	} else {
+		fmt.Printf("Comparing %s (%s, %d hosts) with %s (%s, %d hosts), durations are medians over the hosts\n\n",
+			a.ID, a.Operation, len(a.Hosts), b.ID, b.Operation, len(b.Hosts))
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "STEP\tBEFORE\tAFTER\tCHANGE\tFAILED\tREGRESSION")
+		for i, step := range steps {
+			var regression []string
+			if step.Slower {
+				regression = append(regression, "slower")
+			}
+			if step.NewlyFailing {
+				failing := "newly failing"
+				if len(step.NewlyFailedOn) > 0 {
+					failing += " on " + strings.Join(step.NewlyFailedOn, ",")
+				}
+				regression = append(regression, failing)
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d/%d -> %d/%d\t%s\n", step.Step, dash(step.Before), dash(step.After), durationChange(changes[i]),
+				step.FailedBefore, step.HostsBefore+step.FailedBefore, step.FailedAfter, step.HostsAfter+step.FailedAfter, dash(strings.Join(regression, ", ")))
+		}
+		w.Flush()
+	}
+
+	if regressions > 0 {
+		return fmt.Errorf("%d steps regressed between %s and %s", regressions, a.ID, b.ID)
+	}
+	return nil
+}
+
+// durationChange renders how much the step took longer or shorter
+func durationChange(c report.StepChange) string {
+	if c.Before == 0 || c.After == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%+.0f%%", 100*float64(c.After-c.Before)/float64(c.Before))
+}
+
+// dash returns value, or - when it is empty
+func dash(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
+
+// hostFacts are the facts of a host as printed by status facts --json
+type hostFacts struct {
+	IP string `json:"ip"`
+	*status.Facts
+}
+
+// statusFacts prints the facts recorded for the given hosts, or for every
+// host of the status store
+func statusFacts(args []string) error {
+	flags := flag.NewFlagSet("status facts", flag.ExitOnError)
+	asJSON := flags.Bool("json", false, "print as JSON")
+	flags.Parse(args)
+
+	var statuses []*status.SetupStatus
+	if flags.NArg() == 0 {
+		all, err := status.List()
+		if err != nil {
+			return err
+		}
+		for _, s := range all {
+			if s.Facts != nil {
+				statuses = append(statuses, s)
+			}
+		}
+	}
+	for _, ip := range flags.Args() {
+		s, err := status.Load(ip)
+		if err != nil {
+			return fmt.Errorf("no status for %s: %v", ip, err)
+		}
+		if s.Facts == nil {
+			return fmt.Errorf("no facts recorded for %s, they are collected by a run", ip)
+		}
+		statuses = append(statuses, s)
+	}
+
+	hosts := make([]hostFacts, len(statuses))
+	for i, s := range statuses {
+		hosts[i] = hostFacts{IP: s.VMIP, Facts: s.Facts}
+	}
+	if *asJSON {
+		return printJSON(hosts)
+	}
+	if len(hosts) == 0 {
+		fmt.Printf("No facts recorded in %s\n", status.Dir)
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "HOST\tOS\tKERNEL\tARCH\tCPUS\tMEMORY\tDISK FREE\tRUNTIME\tKUBELET\tCOLLECTED")
+	for _, h := range hosts {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%s\t%s/%s\t%s\t%s\t%s\n", h.IP, h.OS, h.Kernel, h.Arch, h.CPUs, formatSize(h.MemoryBytes),
+			formatSize(h.DiskFreeBytes), formatSize(h.DiskBytes), dash(h.ContainerRuntime), dash(h.Kubelet), formatTime(&h.Collected))
+	}
+	return w.Flush()
+}
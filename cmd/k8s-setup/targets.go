@@ -0,0 +1,244 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/maarulav/k8s-setup/pkg/config"
+	"github.com/maarulav/k8s-setup/pkg/ssh"
+)
+
+// maxRangeHosts caps how many addresses a single CIDR target may expand to
+const maxRangeHosts = 1024
+
+// probeTimeout bounds the SSH port check when no SSH timeout is configured
+const probeTimeout = 2 * time.Second
+
+// inventory returns the hosts from the configuration followed by the extra
+// targets, expanded to IPs and without duplicates
+func inventory(cfg *config.Config, extra []string) ([]string, error) {
+	extraIPs, err := expandTargets(cfg, extra)
+	if err != nil {
+		return nil, err
+	}
+	return dedupe(cfg, append(cfg.HostIPs(), extraIPs...)), nil
+}
+
+// resolveTargets expands the targets given on the command line to IPs
+func resolveTargets(cfg *config.Config, targets []string) ([]string, error) {
+	ips, err := expandTargets(cfg, targets)
+	if err != nil {
+		return nil, err
+	}
+	return dedupe(cfg, ips), nil
+}
+
+// resolveTarget resolves a single host given on the command line to its IP.
+// Hosts of the inventory are kept as listed, hostnames and OpenSSH aliases
+// resolve to their first address.
+func resolveTarget(cfg *config.Config, target string) (string, error) {
+	if _, ok := cfg.Host(target); ok || net.ParseIP(target) != nil {
+		return target, nil
+	}
+	if strings.Contains(target, "/") {
+		return "", fmt.Errorf("%s is a range, expected a single host", target)
+	}
+	ips, err := expandTargets(cfg, []string{target})
+	if err != nil {
+		return "", err
+	}
+	if len(ips) == 0 {
+		return "", fmt.Errorf("%s has no addresses", target)
+	}
+	return ips[0], nil
+}
+
+// dedupe drops repeated IPs and, with ssh.probe, the ones without SSH
+func dedupe(cfg *config.Config, all []string) []string {
+	seen := map[string]bool{}
+	var ips []string
+	for _, ip := range all {
+		if !seen[ip] {
+			seen[ip] = true
+			ips = append(ips, ip)
+		}
+	}
+
+	if cfg.SSHConfig.Probe {
+		ips = probeSSH(cfg, ips)
+	}
+	return ips
+}
+
+// expandTargets turns IPs, hostnames, OpenSSH aliases and CIDR ranges into
+// a list of IPs
+func expandTargets(cfg *config.Config, targets []string) ([]string, error) {
+	var clientConfig *ssh.ClientConfig
+	var ips []string
+	for _, target := range targets {
+		switch {
+		case strings.Contains(target, "/"):
+			expanded, err := expandCIDR(target)
+			if err != nil {
+				return nil, err
+			}
+			ips = append(ips, expanded...)
+		case net.ParseIP(target) != nil:
+			ips = append(ips, target)
+		default:
+			if clientConfig == nil {
+				var err error
+				if clientConfig, err = ssh.LoadClientConfig(cfg.SSHConfig.ConfigFile); err != nil {
+					return nil, fmt.Errorf("failed to read SSH client configuration: %v", err)
+				}
+			}
+			addrs, err := resolveHost(clientConfig, target)
+			if err != nil {
+				return nil, err
+			}
+			ips = append(ips, addrs...)
+		}
+	}
+	return ips, nil
+}
+
+// sshAliases holds the OpenSSH client settings of the targets given as
+// aliases, by IP, see vmConfig
+var sshAliases sync.Map
+
+// vmConfig returns the connection settings of the host at ip. Hosts reached
+// through an OpenSSH alias take its User, Port, IdentityFile and ProxyJump
+// where their inventory entry does not set them.
+func vmConfig(cfg *config.Config, ip string) config.VMConfig {
+	vm := cfg.VMConfig(ip)
+	value, ok := sshAliases.Load(ip)
+	if !ok {
+		return vm
+	}
+	settings := value.(ssh.HostConfig)
+	host, _ := cfg.Host(ip)
+	if host.Username == "" && settings.User != "" {
+		vm.Username = settings.User
+	}
+	if host.Port == 0 && settings.Port != 0 {
+		vm.Port = settings.Port
+	}
+	if host.KeyFile == "" && settings.IdentityFile != "" {
+		vm.KeyFile, vm.CertFile = settings.IdentityFile, host.CertFile
+	}
+	if host.ProxyJump == "" {
+		vm.ProxyJump = settings.ProxyJump
+	}
+	return vm
+}
+
+// resolveHost resolves a hostname to its IPs. When the name is a Host alias
+// of the OpenSSH client configuration, its HostName is resolved instead and
+// its settings are kept in sshAliases for the IPs; cfg is left as loaded.
+func resolveHost(clientConfig *ssh.ClientConfig, target string) ([]string, error) {
+	if !clientConfig.HasAlias(target) {
+		addrs, err := net.LookupHost(target)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve %s: %v", target, err)
+		}
+		return addrs, nil
+	}
+
+	settings := clientConfig.Lookup(target)
+	hostname := target
+	if settings.HostName != "" {
+		hostname = settings.HostName
+	}
+	addrs := []string{hostname}
+	if net.ParseIP(hostname) == nil {
+		resolved, err := net.LookupHost(hostname)
+		switch {
+		case err == nil:
+			addrs = resolved
+		case settings.ProxyJump == "":
+			return nil, fmt.Errorf("failed to resolve %s (%s): %v", target, hostname, err)
+		}
+		// Names only the jump host resolves are dialed by name through it
+	}
+
+	for _, ip := range addrs {
+		sshAliases.Store(ip, settings)
+	}
+	return addrs, nil
+}
+
+// expandCIDR lists the host addresses of an IPv4 or IPv6 range. The network
+// and broadcast addresses of IPv4 ranges larger than /31 are left out.
+func expandCIDR(cidr string) ([]string, error) {
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid target range %s: %v", cidr, err)
+	}
+
+	ones, bits := network.Mask.Size()
+	if bits-ones > 30 || 1<<uint(bits-ones) > maxRangeHosts {
+		return nil, fmt.Errorf("target range %s is larger than %d addresses", cidr, maxRangeHosts)
+	}
+
+	var ips []string
+	for ip := network.IP.Mask(network.Mask); network.Contains(ip); ip = nextIP(ip) {
+		ips = append(ips, ip.String())
+	}
+	if ip4 := network.IP.To4(); ip4 != nil && bits-ones > 1 {
+		ips = ips[1 : len(ips)-1]
+	}
+	return ips, nil
+}
+
+// nextIP returns the address following ip
+func nextIP(ip net.IP) net.IP {
+	next := make(net.IP, len(ip))
+	copy(next, ip)
+	for i := len(next) - 1; i >= 0; i-- {
+		next[i]++
+		if next[i] != 0 {
+			break
+		}
+	}
+	return next
+}
+
+// probeSSH keeps the IPs that accept TCP connections on their SSH port
+func probeSSH(cfg *config.Config, ips []string) []string {
+	open := make([]bool, len(ips))
+	var wg sync.WaitGroup
+	for i, ip := range ips {
+		wg.Add(1)
+		go func(i int, ip string) {
+			defer wg.Done()
+			vm := vmConfig(cfg, ip)
+			timeout := vm.Timeout
+			if timeout == 0 || timeout > probeTimeout {
+				timeout = probeTimeout
+			}
+			conn, err := net.DialTimeout("tcp", net.JoinHostPort(ip, strconv.Itoa(vm.Port)), timeout)
+			if err != nil {
+				return
+			}
+			conn.Close()
+			open[i] = true
+		}(i, ip)
+	}
+	wg.Wait()
+
+	var reachable []string
+	for i, ip := range ips {
+		if open[i] {
+			reachable = append(reachable, ip)
+		}
+	}
+	if skipped := len(ips) - len(reachable); skipped > 0 {
+		log.Printf("Skipping %d of %d targets that do not answer on the SSH port", skipped, len(ips))
+	}
+	return reachable
+}
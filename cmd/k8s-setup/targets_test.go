@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/maarulav/k8s-setup/internal/testutil"
+	"github.com/maarulav/k8s-setup/pkg/config"
+)
+
+func TestResolveTargetsAlias(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "config")
+	err := ioutil.WriteFile(file, []byte(`
+Host k8s-cp1
+    HostName 10.9.0.1
+    User ubuntu
+    Port 2222
+    IdentityFile /keys/cp1
+
+Host k8s-cp2
+    HostName 10.9.0.2
+    User ubuntu
+    Port 2222
+`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &config.Config{}
+	cfg.SSHConfig.Username = "root"
+	cfg.SSHConfig.ConfigFile = file
+	cfg.Hosts = []config.Host{{IP: "10.9.0.2", Username: "admin"}}
+
+	ips, err := resolveTargets(cfg, []string{"k8s-cp1", "k8s-cp2", "10.9.0.3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ips) != 3 || ips[0] != "10.9.0.1" || ips[1] != "10.9.0.2" || ips[2] != "10.9.0.3" {
+		t.Fatalf("resolved to %v", ips)
+	}
+	if len(cfg.Hosts) != 1 || cfg.Hosts[0].Port != 0 || cfg.Hosts[0].KeyFile != "" {
+		t.Errorf("the configuration was changed: %+v", cfg.Hosts)
+	}
+
+	if vm := vmConfig(cfg, "10.9.0.1"); vm.Username != "ubuntu" || vm.Port != 2222 || vm.KeyFile != "/keys/cp1" {
+		t.Errorf("alias settings not applied: %+v", vm)
+	}
+	// The inventory wins over the alias
+	if vm := vmConfig(cfg, "10.9.0.2"); vm.Username != "admin" || vm.Port != 2222 {
+		t.Errorf("inventory settings overridden: %+v", vm)
+	}
+	if vm := vmConfig(cfg, "10.9.0.3"); vm.Username != "root" || vm.Port != 22 {
+		t.Errorf("plain IP got alias settings: %+v", vm)
+	}
+}
+
+func TestConnectAlias(t *testing.T) {
+	server := testutil.NewSSHServer(t).Respond("hostname", "cp-1")
+	dir := t.TempDir()
+	sshConfig := filepath.Join(dir, "ssh_config")
+	err := ioutil.WriteFile(sshConfig, []byte(fmt.Sprintf(`
+Host lab-cp
+    HostName 127.0.0.1
+    Port %d
+`, server.VMConfig().Port)), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &config.Config{}
+	cfg.SSHConfig.Shell = config.ShellLogin
+	cfg.SSHConfig.ConfigFile = sshConfig
+	cfg.SSHConfig.Timeout = config.Duration(5 * time.Second)
+	cfg.SSHConfig.Username = testutil.SSHUser
+	cfg.SSHConfig.Password = testutil.SSHPassword
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "config.json")
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	_, client, err := connect(path, "lab-cp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if client.IP != "127.0.0.1" {
+		t.Errorf("connected to %s, want the HostName of the alias", client.IP)
+	}
+	if out, err := client.ExecuteCommand("hostname"); err != nil || out != "cp-1" {
+		t.Errorf("command through the alias returned %q, %v", out, err)
+	}
+
+	for target, want := range map[string]string{
+		"10.9.0.7": "10.9.0.7",
+		"lab-cp":   "127.0.0.1",
+	} {
+		if ip, err := resolveTarget(cfg, target); err != nil || ip != want {
+			t.Errorf("resolveTarget(%q) = %q, %v, want %q", target, ip, err, want)
+		}
+	}
+	// Names of the inventory are kept, their settings are listed by name
+	cfg.Hosts = []config.Host{{IP: "localhost", Port: 2200}}
+	if ip, err := resolveTarget(cfg, "localhost"); err != nil || ip != "localhost" {
+		t.Errorf("resolveTarget(localhost) = %q, %v, want the inventory name", ip, err)
+	}
+	if _, err := resolveTarget(cfg, "10.9.0.0/30"); err == nil {
+		t.Error("a range resolved to a single host")
+	}
+}
+
+func TestExpandCIDR(t *testing.T) {
+	for _, tc := range []struct {
+		cidr string
+		want []string
+		err  string
+	}{
+		// The network and broadcast addresses are left out
+		{cidr: "10.0.0.0/30", want: []string{"10.0.0.1", "10.0.0.2"}},
+		{cidr: "10.0.0.5/29", want: []string{"10.0.0.1", "10.0.0.2", "10.0.0.3", "10.0.0.4", "10.0.0.5", "10.0.0.6"}},
+		// Point-to-point links and single hosts have no such addresses
+		{cidr: "10.0.0.0/31", want: []string{"10.0.0.0", "10.0.0.1"}},
+		{cidr: "10.0.0.7/32", want: []string{"10.0.0.7"}},
+		{cidr: "10.0.0.254/31", want: []string{"10.0.0.254", "10.0.0.255"}},
+		{cidr: "fd00::/126", want: []string{"fd00::", "fd00::1", "fd00::2", "fd00::3"}},
+		{cidr: "10.0.0.0/21", err: "larger than 1024 addresses"},
+		{cidr: "fd00::/64", err: "larger than 1024 addresses"},
+		{cidr: "10.0.0.0/33", err: "invalid target range"},
+		{cidr: "db-1/24", err: "invalid target range"},
+	} {
+		got, err := expandCIDR(tc.cidr)
+		if tc.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("expandCIDR(%s) error %v, want %q", tc.cidr, err, tc.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("expandCIDR(%s): %v", tc.cidr, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("expandCIDR(%s) = %v, want %v", tc.cidr, got, tc.want)
+		}
+	}
+
+	// The addresses carry into the next octet, up to the cap
+	got, err := expandCIDR("10.0.0.0/22")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1022 || got[254] != "10.0.0.255" || got[255] != "10.0.1.0" || got[1021] != "10.0.3.254" {
+		t.Errorf("expandCIDR(10.0.0.0/22) = %d addresses from %s to %s", len(got), got[0], got[len(got)-1])
+	}
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/maarulav/k8s-setup/internal/report"
+	"github.com/maarulav/k8s-setup/pkg/config"
+	"github.com/maarulav/k8s-setup/pkg/kube"
+	"github.com/maarulav/k8s-setup/pkg/kubernetes"
+	"github.com/maarulav/k8s-setup/pkg/ssh"
+)
+
+// upgradeResult is the outcome of upgrading one worker
+type upgradeResult struct {
+	ip     string
+	node   string
+	result string
+}
+
+// runUpgrade upgrades the control plane and then the workers, one at a time,
+// to the configured Kubernetes version. With --canary the first worker is
+// smoke tested before the others are touched. Like provisioning, it keeps to
+// the maintenance window.
+func runUpgrade(args []string) (err error) {
+	flags := flag.NewFlagSet("upgrade", flag.ExitOnError)
+	canary := flags.Bool("canary", false, "upgrade one worker first and only continue if its smoke test passes")
+	skipControlPlane := flags.Bool("skip-control-plane", false, "only upgrade the workers")
+	skipBackup := flags.Bool("skip-backup", false, "do not back up the cluster before upgrading")
+	forceUnlock := flags.Bool("force-unlock", false, "break the locks other runs hold on the hosts")
+	allowEOL := flags.Bool("allow-eol", false, "upgrade to a Kubernetes version past its end of life")
+	ignoreWindow := flags.Bool("ignore-window", false, "start outside the maintenance window and run past it, e.g. for an emergency change")
+	flags.Parse(args)
+
+	if flags.NArg() < 2 {
+		return fmt.Errorf("usage: upgrade [--canary] [--skip-control-plane] [--skip-backup] [--force-unlock] [--allow-eol] [--ignore-window] <config.json> <control-plane-ip> [worker-ip...]")
+	}
+
+	cfg, client, err := connect(flags.Arg(0), flags.Arg(1))
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if err := checkVersion(cfg, *allowEOL); err != nil {
+		return err
+	}
+	deadline, err := windowDeadline(cfg, *ignoreWindow)
+	if err != nil {
+		return err
+	}
+
+	workers, err := resolveTargets(cfg, flags.Args()[2:])
+	if err != nil {
+		return err
+	}
+
+	unlock, err := lockHosts(cfg, append([]string{client.IP}, workers...), "upgrade", *forceUnlock)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	summary := report.Summary{Start: time.Now()}
+	defer func() {
+		summary.End = time.Now()
+		// A failure outside the hosts, such as the backup, fails the run too
+		if err != nil {
+			summary.Error = err.Error()
+		}
+		if _, err := report.SaveRun("upgrade", &summary); err != nil {
+			fmt.Printf("Warning: failed to record the run: %v\n", err)
+		}
+		if err := report.Push(cfg.Pushgateway, cfg.ClusterName(client.IP), "upgrade", &summary); err != nil {
+			fmt.Printf("Warning: %v\n", err)
+		}
+	}()
+
+	if err := checkWindow(cfg, deadline, "the upgrade"); err != nil {
+		return err
+	}
+	if err := recordOperation(client, cfg, "upgrade", *skipBackup); err != nil {
+		return err
+	}
+
+	if !*skipControlPlane {
+		if err := checkWindow(cfg, deadline, "the control plane upgrade"); err != nil {
+			return err
+		}
+		fmt.Printf("Upgrading control plane %s to %s\n", client.IP, cfg.Kubernetes.Version)
+		start := time.Now()
+		err := kubernetes.UpgradeControlPlane(client, cfg)
+		summary.Hosts = append(summary.Hosts, upgradeHost(client.IP, "control-plane", start, err))
+		if err != nil {
+			return fmt.Errorf("control plane upgrade failed: %v", err)
+		}
+	}
+
+	results := make([]upgradeResult, len(workers))
+	for i := range workers {
+		results[i] = upgradeResult{ip: workers[i], result: "not upgraded"}
+	}
+	defer printUpgradeResults(results)
+
+	for i, ip := range workers {
+		if err := checkWindow(cfg, deadline, fmt.Sprintf("upgrading %s, %d remaining workers were not upgraded", ip, len(workers)-i)); err != nil {
+			return err
+		}
+		start := time.Now()
+		node, err := upgradeWorker(client, cfg, ip, *canary && i == 0)
+		summary.Hosts = append(summary.Hosts, upgradeHost(ip, "worker", start, err))
+		results[i].node = node
+		if err != nil {
+			results[i].result = err.Error()
+			if *canary && i == 0 {
+				return fmt.Errorf("canary node %s failed, %d remaining workers were not upgraded: %v", ip, len(workers)-1, err)
+			}
+			return fmt.Errorf("upgrade of %s failed: %v", ip, err)
+		}
+		results[i].result = "upgraded"
+		if *canary && i == 0 {
+			results[i].result = "upgraded, smoke test passed"
+		}
+	}
+
+	return nil
+}
+
+// upgradeWorker upgrades the worker at ip and, for the canary, runs the
+// smoke test. It returns the node name of the worker.
+func upgradeWorker(controlPlane *ssh.Client, cfg *config.Config, ip string, smokeTest bool) (string, error) {
+	kc, err := kube.NewClient(controlPlane)
+	if err != nil {
+		return "", err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), kube.DefaultTimeout)
+	defer cancel()
+
+	node, err := kube.NodeByAddress(ctx, kc, ip)
+	if err != nil {
+		return "", err
+	}
+
+	worker, err := ssh.Connect(vmConfig(cfg, ip))
+	if err != nil {
+		return node, fmt.Errorf("SSH connection failed: %v", err)
+	}
+	defer worker.Close()
+
+	fmt.Printf("Upgrading worker %s (%s)\n", node, ip)
+	if err := kubernetes.UpgradeWorker(controlPlane, worker, node, cfg); err != nil {
+		return node, err
+	}
+
+	if smokeTest {
+		fmt.Printf("Running smoke test on canary node %s\n", node)
+		return node, kubernetes.SmokeTest(controlPlane, node, cfg)
+	}
+	if err := kube.WaitForNodeReady(ctx, kc, node, kube.Print); err != nil {
+		return node, err
+	}
+	return node, nil
+}
+
+// upgradeHost records the outcome of the upgrade step of the host at ip,
+// begun at start, for the run metrics
+func upgradeHost(ip, step string, start time.Time, err error) report.Host {
+	h := report.Host{IP: ip, Status: "Completed", Step: step, Duration: time.Since(start)}
+	if err != nil {
+		h.Status = "Failed"
+		h.Error = err.Error()
+		h.FailedStep = step
+		return h
+	}
+	h.CompletedSteps = []string{step}
+	h.Steps = []report.Step{{Name: step, Duration: h.Duration}}
+	return h
+}
+
+func printUpgradeResults(results []upgradeResult) {
+	if len(results) == 0 {
+		return
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "HOST\tNODE\tRESULT")
+	for _, r := range results {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", r.ip, r.node, r.result)
+	}
+	w.Flush()
+}
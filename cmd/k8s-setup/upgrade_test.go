@@ -0,0 +1,88 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/maarulav/k8s-setup/internal/testutil"
+	"github.com/maarulav/k8s-setup/pkg/config"
+)
+
+func TestUpgradeBackupFailurePushed(t *testing.T) {
+	t.Chdir(t.TempDir())
+	var paths, bodies []string
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		paths, bodies = append(paths, r.URL.Path), append(bodies, string(body))
+	}))
+	defer gateway.Close()
+
+	cp := testutil.NewSSHServer(t).Fail("snapshot save", "etcdserver: request timed out")
+	cfg := &config.Config{}
+	cfg.Cluster.Name = "prod"
+	cfg.Kubernetes.Version = "1.31.1-1.1"
+	cfg.Pushgateway.URL = gateway.URL
+	path := writeConfig(t, cp, cfg)
+
+	err := runUpgrade([]string{"--allow-eol", path, "localhost"})
+	if err == nil || !strings.Contains(err.Error(), "backup before upgrade failed") {
+		t.Fatalf("error %v, want the failed backup", err)
+	}
+	if len(commandsWith(cp, "kubeadm upgrade")) != 0 {
+		t.Error("the control plane was upgraded without a backup")
+	}
+
+	if len(paths) != 1 || paths[0] != "/metrics/job/k8s-setup/cluster/prod/operation/upgrade" {
+		t.Fatalf("pushed to %v", paths)
+	}
+	if !strings.Contains(bodies[0], "k8s_setup_run_success 0\n") {
+		t.Errorf("pushed a successful run:\n%s", bodies[0])
+	}
+}
+
+// closedWindow returns a maintenance window that opens in two hours
+func closedWindow() *config.MaintenanceWindow {
+	return &config.MaintenanceWindow{Start: time.Now().UTC().Add(2 * time.Hour).Format("15:04"), Duration: "1h", Timezone: "UTC"}
+}
+
+func TestUpgradeMaintenanceWindow(t *testing.T) {
+	t.Chdir(t.TempDir())
+	cp := testutil.NewSSHServer(t).Fail("snapshot save", "etcdserver: request timed out")
+	cfg := &config.Config{MaintenanceWindow: closedWindow()}
+	cfg.Kubernetes.Version = "1.31.1-1.1"
+	path := writeConfig(t, cp, cfg)
+
+	err := runUpgrade([]string{"--allow-eol", path, "localhost"})
+	if err == nil || !strings.Contains(err.Error(), "outside the maintenance window") {
+		t.Fatalf("error %v, want the closed window", err)
+	}
+	if len(commandsWith(cp, "snapshot save")) != 0 {
+		t.Error("the cluster was touched outside the maintenance window")
+	}
+
+	// The override runs it anyway, here up to the failing backup
+	err = runUpgrade([]string{"--allow-eol", "--ignore-window", path, "localhost"})
+	if err == nil || !strings.Contains(err.Error(), "backup before upgrade failed") {
+		t.Errorf("error %v, want the upgrade to start", err)
+	}
+}
+
+func TestPatchMaintenanceWindow(t *testing.T) {
+	t.Chdir(t.TempDir())
+	cp := testutil.NewSSHServer(t)
+	// Five minutes are left, less than the default reserve
+	window := &config.MaintenanceWindow{Start: time.Now().UTC().Add(-time.Hour).Format("15:04"), Duration: "1h5m", Timezone: "UTC"}
+	path := writeConfig(t, cp, &config.Config{MaintenanceWindow: window})
+
+	err := runPatch([]string{path, "localhost"})
+	if err == nil || !strings.Contains(err.Error(), "stopped before patching") {
+		t.Fatalf("error %v, want the run stopped by the reserve", err)
+	}
+	if len(commandsWith(cp, "snapshot save")) != 0 || len(commandsWith(cp, "apt-get")) != 0 {
+		t.Error("the cluster was touched without enough of the window left")
+	}
+}
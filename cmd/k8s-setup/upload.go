@@ -0,0 +1,159 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/maarulav/k8s-setup/pkg/config"
+	"github.com/maarulav/k8s-setup/pkg/ssh"
+)
+
+// artifact is a local file distributed to every host
+type artifact struct {
+	name     string
+	data     []byte
+	checksum string
+}
+
+// uploadResult is the outcome of distributing the artifacts to one host
+type uploadResult struct {
+	ip       string
+	files    int
+	bytes    int
+	duration time.Duration
+	err      error
+}
+
+// runUpload copies the same files to every host concurrently and verifies
+// their checksums on arrival
+func runUpload(args []string) error {
+	flags := flag.NewFlagSet("upload", flag.ExitOnError)
+	dest := flags.String("dest", "/root", "remote directory the files are written to")
+	mode := flags.String("mode", "0644", "file mode of the uploaded files")
+	hosts := flags.String("hosts", "", "comma separated targets in addition to the inventory")
+	flags.Parse(args)
+
+	if flags.NArg() < 2 {
+		return fmt.Errorf("usage: upload [--dest dir] [--mode 0644] [--hosts ip,...] <config.json> <file>...")
+	}
+
+	perm, err := strconv.ParseUint(*mode, 8, 32)
+	if err != nil {
+		return fmt.Errorf("invalid mode %s: %v", *mode, err)
+	}
+
+	cfg, err := config.LoadConfig(flags.Arg(0))
+	if err != nil {
+		return fmt.Errorf("failed to load configuration: %v", err)
+	}
+
+	var extra []string
+	if *hosts != "" {
+		extra = strings.Split(*hosts, ",")
+	}
+	ips, err := inventory(cfg, extra)
+	if err != nil {
+		return err
+	}
+	if len(ips) == 0 {
+		return fmt.Errorf("no hosts in the inventory and none given with --hosts")
+	}
+
+	var artifacts []artifact
+	for _, file := range flags.Args()[1:] {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %v", file, err)
+		}
+		sum := sha256.Sum256(data)
+		artifacts = append(artifacts, artifact{
+			name:     filepath.Base(file),
+			data:     data,
+			checksum: hex.EncodeToString(sum[:]),
+		})
+	}
+
+	results := make([]uploadResult, len(ips))
+	var wg sync.WaitGroup
+	for i, ip := range ips {
+		wg.Add(1)
+		go func(i int, ip string) {
+			defer wg.Done()
+			results[i] = distribute(vmConfig(cfg, ip), artifacts, *dest, os.FileMode(perm))
+		}(i, ip)
+	}
+	wg.Wait()
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "HOST\tFILES\tBYTES\tDURATION\tERROR")
+	failed := 0
+	for _, r := range results {
+		errText := ""
+		if r.err != nil {
+			failed++
+			errText = r.err.Error()
+		}
+		fmt.Fprintf(w, "%s\t%d/%d\t%d\t%s\t%s\n", r.ip, r.files, len(artifacts), r.bytes,
+			r.duration.Round(time.Millisecond), errText)
+	}
+	w.Flush()
+
+	if failed > 0 {
+		return fmt.Errorf("upload failed on %d of %d hosts", failed, len(results))
+	}
+	return nil
+}
+
+// distribute uploads the artifacts to one host, stopping at the first file
+// that fails to transfer or does not match its checksum
+func distribute(vm config.VMConfig, artifacts []artifact, dest string, mode os.FileMode) (result uploadResult) {
+	result.ip = vm.IP
+	start := time.Now()
+	defer func() { result.duration = time.Since(start) }()
+
+	client, err := ssh.Connect(vm)
+	if err != nil {
+		result.err = err
+		return result
+	}
+	defer client.Close()
+
+	if output, err := client.ExecuteCommand("mkdir -p " + ssh.Quote(dest)); err != nil {
+		result.err = fmt.Errorf("failed to create %s: %v\nOutput: %s", dest, err, output)
+		return result
+	}
+
+	for _, a := range artifacts {
+		target := path.Join(dest, a.name)
+		if err := client.WriteFile(target, a.data, mode); err != nil {
+			result.err = err
+			return result
+		}
+
+		output, err := client.ExecuteCommand("sha256sum " + ssh.Quote(target))
+		if err != nil {
+			result.err = fmt.Errorf("failed to checksum %s: %v", target, err)
+			return result
+		}
+		if fields := strings.Fields(output); len(fields) == 0 || fields[0] != a.checksum {
+			result.err = fmt.Errorf("checksum mismatch for %s", target)
+			return result
+		}
+
+		result.files++
+		result.bytes += len(a.data)
+	}
+
+	return result
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/maarulav/k8s-setup/internal/testutil"
+)
+
+// testArtifact returns an artifact named name holding data
+func testArtifact(name, data string) artifact {
+	sum := sha256.Sum256([]byte(data))
+	return artifact{name: name, data: []byte(data), checksum: hex.EncodeToString(sum[:])}
+}
+
+func TestDistribute(t *testing.T) {
+	a := testArtifact("agent.tar", "agent")
+	server := testutil.NewSSHServer(t).Respond("sha256sum", a.checksum+"  /opt/my tools/agent.tar\n")
+
+	r := distribute(server.VMConfig(), []artifact{a}, "/opt/my tools", 0644)
+	if r.err != nil {
+		t.Fatal(r.err)
+	}
+	if r.files != 1 || r.bytes != 5 {
+		t.Errorf("result %+v, want one file of 5 bytes", r)
+	}
+	if r.duration <= 0 {
+		t.Errorf("duration %s, want the time the upload took", r.duration)
+	}
+
+	want := []string{
+		"mkdir -p '/opt/my tools'",
+		"umask 077 && cat > '/opt/my tools/agent.tar' && chmod 644 '/opt/my tools/agent.tar'",
+		"sha256sum '/opt/my tools/agent.tar'",
+	}
+	if got := server.Commands(); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("commands\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	// A failed host reports its duration as well
+	server = testutil.NewSSHServer(t).Respond("sha256sum", strings.Repeat("0", 64)+"  agent.tar\n")
+	r = distribute(server.VMConfig(), []artifact{a}, "/opt", 0644)
+	if r.err == nil || !strings.Contains(r.err.Error(), "checksum mismatch") || r.duration <= 0 {
+		t.Errorf("result %+v, want a checksum mismatch with its duration", r)
+	}
+}
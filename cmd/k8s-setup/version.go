@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"runtime"
+)
+
+// Build information, set at build time with
+// -ldflags "-X main.version=v1.2.3 -X main.commit=abc123 -X main.buildDate=2024-01-02T15:04:05Z -X main.updatePublicKey=<base64 ed25519 key>"
+var (
+	version         = "dev"
+	commit          = "unknown"
+	buildDate       = "unknown"
+	updatePublicKey = ""
+)
+
+// buildInfo is the machine readable form of the version command
+type buildInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildDate string `json:"buildDate"`
+	GoVersion string `json:"goVersion"`
+	Platform  string `json:"platform"`
+}
+
+// runVersion prints the build information
+func runVersion(args []string) error {
+	flags := flag.NewFlagSet("version", flag.ExitOnError)
+	asJSON := flags.Bool("json", false, "print as JSON")
+	flags.Parse(args)
+
+	info := buildInfo{
+		Version:   version,
+		Commit:    commit,
+		BuildDate: buildDate,
+		GoVersion: runtime.Version(),
+		Platform:  runtime.GOOS + "/" + runtime.GOARCH,
+	}
+
+	if *asJSON {
+		data, err := json.MarshalIndent(info, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+		return nil
+	}
+
+	fmt.Printf("k8s-setup %s (commit %s, built %s, %s, %s)\n",
+		info.Version, info.Commit, info.BuildDate, info.GoVersion, info.Platform)
+	return nil
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"text/tabwriter"
+
+	"github.com/maarulav/k8s-setup/pkg/config"
+	"github.com/maarulav/k8s-setup/pkg/helm"
+	"github.com/maarulav/k8s-setup/pkg/ssh"
+)
+
+// componentVersion is one row of the versions report
+type componentVersion struct {
+	Host      string `json:"host"`
+	Component string `json:"component"`
+	Installed string `json:"installed"`
+	Expected  string `json:"expected,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// Matches reports whether the installed version is the configured one
+func (v componentVersion) Matches() bool {
+	return v.Expected == "" || v.Installed == v.Expected
+}
+
+// runVersions reports the installed component versions of every host
+// compared to the configuration
+func runVersions(args []string) error {
+	flags := flag.NewFlagSet("versions", flag.ExitOnError)
+	asJSON := flags.Bool("json", false, "print as JSON")
+	flags.Parse(args)
+
+	if flags.NArg() < 1 {
+		return fmt.Errorf("usage: versions [--json] <config.json> [ip...]")
+	}
+
+	cfg, err := config.LoadConfig(flags.Arg(0))
+	if err != nil {
+		return fmt.Errorf("failed to load configuration: %v", err)
+	}
+
+	ips, err := inventory(cfg, flags.Args()[1:])
+	if err != nil {
+		return err
+	}
+	results := make([][]componentVersion, len(ips))
+	var wg sync.WaitGroup
+	for i, ip := range ips {
+		wg.Add(1)
+		go func(i int, ip string) {
+			defer wg.Done()
+			results[i] = hostVersions(cfg, ip)
+		}(i, ip)
+	}
+	wg.Wait()
+
+	var rows []componentVersion
+	for _, r := range results {
+		rows = append(rows, r...)
+	}
+
+	if *asJSON {
+		data, err := json.MarshalIndent(rows, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "HOST\tCOMPONENT\tINSTALLED\tEXPECTED\tSTATUS")
+	for _, r := range rows {
+		state := "ok"
+		switch {
+		case r.Error != "":
+			state = r.Error
+		case !r.Matches():
+			state = "mismatch"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", r.Host, r.Component, r.Installed, r.Expected, state)
+	}
+	return w.Flush()
+}
+
+// hostVersions collects the versions installed on one host
+func hostVersions(cfg *config.Config, ip string) []componentVersion {
+	client, err := ssh.Connect(vmConfig(cfg, ip))
+	if err != nil {
+		return []componentVersion{{Host: ip, Component: "ssh", Error: err.Error()}}
+	}
+	defer client.Close()
+
+	probe := func(component, cmd, expected string) componentVersion {
+		v := componentVersion{Host: ip, Component: component, Expected: expected}
+		output, err := ssh.Complete(client.ExecuteCommand(cmd))
+		if err != nil {
+			v.Installed = "-"
+			v.Error = "not installed"
+			return v
+		}
+		v.Installed = strings.TrimSpace(output)
+		return v
+	}
+
+	rows := []componentVersion{
+		probe("kubelet", "dpkg-query -W -f='${Version}' kubelet", cfg.Kubernetes.Version),
+		probe("kubeadm", "dpkg-query -W -f='${Version}' kubeadm", cfg.Kubernetes.Version),
+		probe("kubectl", "dpkg-query -W -f='${Version}' kubectl", cfg.Kubernetes.Version),
+		probe("containerd", "containerd --version | awk '{print $3}'", ""),
+	}
+
+	expectedCharts := map[string]string{
+		"kube-prometheus-stack": cfg.Monitoring.Prometheus.ChartVersion,
+		"cert-manager":          cfg.Addons.CertManager.Version,
+		"external-dns":          cfg.Addons.ExternalDNS.Version,
+	}
+	for _, c := range cfg.Charts {
+		expectedCharts[c.Chart] = c.Version
+	}
+
+	releases, err := helm.List(client)
+	if err != nil {
+		rows = append(rows, componentVersion{Host: ip, Component: "charts", Error: err.Error()})
+		return rows
+	}
+	for _, r := range releases {
+		rows = append(rows, componentVersion{
+			Host:      ip,
+			Component: "chart/" + r.ChartName(),
+			Installed: r.ChartVersion(),
+			Expected:  expectedCharts[r.ChartName()],
+		})
+	}
+
+	return rows
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/maarulav/k8s-setup/internal/events"
+	"github.com/maarulav/k8s-setup/internal/report"
+	"github.com/maarulav/k8s-setup/internal/status"
+	"github.com/maarulav/k8s-setup/pkg/config"
+	"github.com/maarulav/k8s-setup/pkg/kube"
+	"github.com/maarulav/k8s-setup/pkg/kubernetes"
+	"github.com/maarulav/k8s-setup/pkg/monitoring"
+	"github.com/maarulav/k8s-setup/pkg/ssh"
+)
+
+// watcher checks the clusters of the status store and alerts about them
+type watcher struct {
+	cfg         *config.Config
+	events      *events.Emitter
+	certWarning time.Duration
+	parallel    int
+	// verify returns why a cluster fails verification, see verifyCluster
+	verify func(client *ssh.Client, cfg *config.Config) string
+
+	// healthy holds the outcome of the last check of each cluster, so only
+	// changes are alerted about. Only round uses it, after the checks.
+	healthy map[string]bool
+}
+
+// runWatch checks the fleet periodically: the nodes are Ready, the
+// monitoring stack works and no control plane certificate is about to
+// expire. Clusters turning unhealthy or recovering are emitted as events
+// and mailed, and every round is pushed to the Pushgateway. With --once a
+// single round runs, for cron, and fails when a cluster needs attention.
+func runWatch(args []string) error {
+	flags := flag.NewFlagSet("watch", flag.ExitOnError)
+	interval := flags.Duration("interval", time.Hour, "how often the fleet is checked")
+	once := flags.Bool("once", false, "check the fleet once and exit, non-zero when a cluster needs attention")
+	certWarning := flags.Int("cert-warning", 30, "alert this many days before a control plane certificate expires")
+	parallel := flags.Int("parallel", 4, "clusters checked at once")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		return fmt.Errorf("usage: watch [--interval 1h] [--once] [--cert-warning 30] [--parallel 4] <config.json>")
+	}
+	cfg, err := config.LoadConfig(flags.Arg(0))
+	if err != nil {
+		return fmt.Errorf("failed to load configuration: %v", err)
+	}
+
+	w := &watcher{
+		cfg:         cfg,
+		events:      events.New(cfg.EventSinks),
+		certWarning: time.Duration(*certWarning) * 24 * time.Hour,
+		parallel:    *parallel,
+		verify:      verifyCluster,
+		healthy:     map[string]bool{},
+	}
+	if *once {
+		return w.once()
+	}
+
+	log.Printf("Checking the fleet every %s", *interval)
+	for {
+		if _, err := w.round(); err != nil {
+			log.Printf("Warning: %v", err)
+		}
+		time.Sleep(*interval)
+	}
+}
+
+// once runs a single round and fails when a cluster needs attention
+func (w *watcher) once() error {
+	round, err := w.round()
+	if err != nil {
+		return err
+	}
+	if unhealthy := round.Unhealthy(); unhealthy > 0 {
+		return fmt.Errorf("%d of %d clusters need attention", unhealthy, len(round.Checks))
+	}
+	return nil
+}
+
+// clusters returns the clusters of the status store whose control plane is
+// a host of the configuration; the credentials of others are unknown
+func (w *watcher) clusters() ([]*status.SetupStatus, error) {
+	all, err := fleetClusters()
+	if err != nil {
+		return nil, err
+	}
+	var clusters []*status.SetupStatus
+	for _, s := range all {
+		if _, ok := w.cfg.Host(s.VMIP); ok {
+			clusters = append(clusters, s)
+		}
+	}
+	return clusters, nil
+}
+
+// round checks every cluster and reports the outcome
+func (w *watcher) round() (*report.CheckRound, error) {
+	clusters, err := w.clusters()
+	if err != nil {
+		return nil, err
+	}
+
+	round := &report.CheckRound{Start: time.Now()}
+	checks := make([]*report.Check, len(clusters))
+	eachCluster(clusters, w.parallel, func(i int, s *status.SetupStatus) {
+		checks[i] = w.check(s)
+	})
+	for _, c := range checks {
+		// Clusters locked by another operation are skipped
+		if c != nil {
+			round.Checks = append(round.Checks, *c)
+		}
+	}
+	round.End = time.Now()
+
+	changed := false
+	for _, c := range round.Checks {
+		if w.notify(c) {
+			changed = true
+		}
+		if len(c.Problems) == 0 {
+			log.Printf("%s (%s): healthy", c.Cluster, c.IP)
+		} else {
+			log.Printf("%s (%s): %s", c.Cluster, c.IP, strings.Join(c.Problems, "; "))
+		}
+	}
+
+	if changed && round.Unhealthy() > 0 {
+		if err := report.SendAlert(w.cfg.Email, round); err != nil {
+			log.Printf("Warning: failed to send the alert: %v", err)
+		}
+	}
+	if err := report.PushChecks(w.cfg.Pushgateway, round); err != nil {
+		log.Printf("Warning: failed to push metrics: %v", err)
+	}
+	return round, nil
+}
+
+// notify emits an event when the health of the cluster of c changed since
+// its last check, or it is unhealthy on its first, and reports whether it
+// turned unhealthy
+func (w *watcher) notify(c report.Check) bool {
+	healthy := len(c.Problems) == 0
+	was, seen := w.healthy[c.IP]
+	w.healthy[c.IP] = healthy
+
+	data := map[string]interface{}{"cluster": c.Cluster, "problems": c.Problems}
+	if !c.CertExpiry.IsZero() {
+		data["certExpiry"] = c.CertExpiry
+	}
+	switch {
+	case !healthy && (!seen || was):
+		w.events.Emit(events.ClusterUnhealthy, c.IP, data)
+		return true
+	case healthy && seen && !was:
+		w.events.Emit(events.ClusterRecovered, c.IP, data)
+	}
+	return false
+}
+
+// check checks the cluster of s over SSH to its control plane and records
+// the outcome in the status store. It returns nil when another operation
+// holds the lock of the control plane.
+func (w *watcher) check(s *status.SetupStatus) *report.Check {
+	c := &report.Check{Cluster: s.VMIP, IP: s.VMIP, Time: time.Now()}
+	if s.ClusterName != "" {
+		c.Cluster = s.ClusterName
+	}
+
+	ttl, err := w.cfg.LockTTLDuration()
+	if err != nil {
+		c.Problems = append(c.Problems, err.Error())
+		return c
+	}
+	lock, err := status.AcquireLock(s.VMIP, "watch", ttl, false)
+	if err != nil {
+		log.Printf("Skipping %s: %v", s.VMIP, err)
+		return nil
+	}
+	defer lock.Release()
+
+	client, err := ssh.Connect(vmConfig(w.cfg, s.VMIP))
+	if err != nil {
+		c.Error = fmt.Sprintf("failed to connect: %v", err)
+		c.Problems = append(c.Problems, c.Error)
+		return c
+	}
+	defer client.Close()
+
+	certs, err := kubernetes.CertificateExpiry(client)
+	if err != nil {
+		c.Problems = append(c.Problems, err.Error())
+	} else {
+		c.CertExpiry, c.Cert = certs[0].NotAfter, certs[0].Path
+		if remaining := time.Until(c.CertExpiry); remaining < 0 {
+			c.Problems = append(c.Problems, fmt.Sprintf("certificate %s expired on %s", c.Cert, c.CertExpiry.Format("2006-01-02")))
+		} else if remaining < w.certWarning {
+			c.Problems = append(c.Problems, fmt.Sprintf("certificate %s expires on %s, renew it with kubeadm certs renew all", c.Cert, c.CertExpiry.Format("2006-01-02")))
+		}
+	}
+
+	if c.Error = w.verify(client, w.cfg); c.Error != "" {
+		c.Problems = append(c.Problems, c.Error)
+	}
+	c.Verified = c.Error == ""
+
+	// The lock keeps runs from writing the status meanwhile
+	st, err := status.Load(s.VMIP)
+	if err != nil {
+		log.Printf("Warning: failed to load the status of %s: %v", s.VMIP, err)
+		return c
+	}
+	st.Verification = verification(c.Error)
+	// Upgrades and patches change the facts, they are refreshed with the
+	// verification as in a run
+	collectFacts(log.Printf, st, client)
+	st.CertExpiry, st.CertChecked = nil, &c.Time
+	if !c.CertExpiry.IsZero() {
+		st.CertExpiry = &c.CertExpiry
+	}
+	if err := st.Save(); err != nil {
+		log.Printf("Warning: failed to save the status of %s: %v", s.VMIP, err)
+	}
+	return c
+}
+
+// verifyCluster returns why the cluster behind client fails verification,
+// or "" when every node is Ready and the monitoring stack works
+func verifyCluster(client *ssh.Client, cfg *config.Config) string {
+	kc, err := kube.NewClient(client)
+	if err != nil {
+		return err.Error()
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), kube.DefaultTimeout)
+	defer cancel()
+	notReady, err := kube.NotReadyNodes(ctx, kc)
+	if err != nil {
+		return fmt.Sprintf("failed to list nodes: %v", err)
+	}
+	if len(notReady) > 0 {
+		return fmt.Sprintf("nodes not Ready: %s", strings.Join(notReady, ", "))
+	}
+	if err := monitoring.Verify(client, cfg); err != nil {
+		return fmt.Sprintf("Monitoring verification failed: %v", err)
+	}
+	return ""
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/maarulav/k8s-setup/internal/events"
+	"github.com/maarulav/k8s-setup/internal/status"
+	"github.com/maarulav/k8s-setup/internal/testutil"
+	"github.com/maarulav/k8s-setup/pkg/config"
+	"github.com/maarulav/k8s-setup/pkg/ssh"
+)
+
+// sink records the types of the events posted to it
+type sink struct {
+	mu    sync.Mutex
+	types []string
+}
+
+func (s *sink) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var event events.Event
+	json.NewDecoder(r.Body).Decode(&event)
+	s.mu.Lock()
+	s.types = append(s.types, event.Type)
+	s.mu.Unlock()
+}
+
+// take returns the events posted since the last call
+func (s *sink) take() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	types := s.types
+	s.types = nil
+	return types
+}
+
+// endDate renders t as openssl x509 -enddate does
+func endDate(path string, t time.Time) string {
+	return path + " notAfter=" + t.UTC().Format("Jan _2 15:04:05 2006 MST") + "\n"
+}
+
+// testWatcher returns a watcher of the cluster at 127.0.0.1, served by
+// server, whose verification fails with *problem unless it is empty, and
+// the sink of its events
+func testWatcher(t *testing.T, server *testutil.SSHServer, problem *string) (*watcher, *sink) {
+	t.Chdir(t.TempDir())
+	s := status.New("127.0.0.1")
+	s.Status = "Completed"
+	s.Kubeconfig = s.KubeconfigPath()
+	if err := os.MkdirAll(status.Dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	posted := &sink{}
+	hs := httptest.NewServer(posted)
+	t.Cleanup(hs.Close)
+
+	cfg := &config.Config{}
+	cfg.SSHConfig.Timeout = config.Duration(5 * time.Second)
+	cfg.SSHConfig.Shell = config.ShellLogin
+	cfg.SSHConfig.ConfigFile = "none"
+	cfg.SSHConfig.Username = testutil.SSHUser
+	cfg.SSHConfig.Password = testutil.SSHPassword
+	cfg.Hosts = []config.Host{{IP: "127.0.0.1", Port: server.VMConfig().Port}}
+	cfg.EventSinks = []string{hs.URL}
+
+	return &watcher{
+		cfg:         cfg,
+		events:      events.New(cfg.EventSinks),
+		certWarning: 30 * 24 * time.Hour,
+		parallel:    2,
+		verify:      func(*ssh.Client, *config.Config) string { return *problem },
+		healthy:     map[string]bool{},
+	}, posted
+}
+
+func TestWatcherTransitions(t *testing.T) {
+	expiry := time.Now().Add(365 * 24 * time.Hour).Truncate(time.Second)
+	server := testutil.NewSSHServer(t).
+		Respond("openssl x509", endDate("/etc/kubernetes/pki/apiserver.crt", expiry)).
+		Respond("os-release", "os=Ubuntu 24.04 LTS\ncpus=4\nmemory=8000000\ndisk=4000000\ndiskFree=2000000\nkubelet=Kubernetes v1.31.2\n")
+	problem := ""
+	w, posted := testWatcher(t, server, &problem)
+
+	for _, tt := range []struct {
+		problem string
+		events  []string
+	}{
+		// Healthy on the first check, nothing to say
+		{"", nil},
+		{"nodes not Ready: worker-1", []string{events.ClusterUnhealthy}},
+		// Still unhealthy, already alerted about
+		{"nodes not Ready: worker-1", nil},
+		{"", []string{events.ClusterRecovered}},
+		{"", nil},
+	} {
+		problem = tt.problem
+		round, err := w.round()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(round.Checks) != 1 {
+			t.Fatalf("round checked %d clusters, want 1", len(round.Checks))
+		}
+		if got := posted.take(); strings.Join(got, ",") != strings.Join(tt.events, ",") {
+			t.Errorf("check with problem %q emitted %v, want %v", tt.problem, got, tt.events)
+		}
+	}
+
+	s, err := status.Load("127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Verification == nil || !s.Verification.Passed {
+		t.Errorf("verification %+v, want passed", s.Verification)
+	}
+	if s.CertExpiry == nil || !s.CertExpiry.Equal(expiry) || s.CertChecked == nil {
+		t.Errorf("certificate expiry %v checked %v, want %s", s.CertExpiry, s.CertChecked, expiry)
+	}
+	if s.Facts == nil || s.Facts.Kubelet != "v1.31.2" || s.Facts.CPUs != 4 {
+		t.Errorf("facts %+v, want them refreshed by the check", s.Facts)
+	}
+}
+
+func TestWatcherUnhealthyFirst(t *testing.T) {
+	server := testutil.NewSSHServer(t).Respond("openssl x509", endDate("/etc/kubernetes/pki/apiserver.crt", time.Now().Add(365*24*time.Hour)))
+	problem := "Monitoring verification failed: no Prometheus"
+	w, posted := testWatcher(t, server, &problem)
+
+	if _, err := w.round(); err != nil {
+		t.Fatal(err)
+	}
+	if got := posted.take(); len(got) != 1 || got[0] != events.ClusterUnhealthy {
+		t.Errorf("emitted %v, want an unhealthy event for a cluster unhealthy on its first check", got)
+	}
+}
+
+func TestWatcherCertificateWarning(t *testing.T) {
+	expiry := time.Now().Add(10 * 24 * time.Hour)
+	server := testutil.NewSSHServer(t).Respond("openssl x509",
+		endDate("/etc/kubernetes/pki/apiserver.crt", expiry.Add(24*time.Hour))+endDate("/etc/kubernetes/pki/etcd/peer.crt", expiry))
+	problem := ""
+	w, _ := testWatcher(t, server, &problem)
+
+	round, err := w.round()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := round.Checks[0]
+	if c.Cert != "/etc/kubernetes/pki/etcd/peer.crt" || len(c.Problems) != 1 || !strings.Contains(c.Problems[0], "kubeadm certs renew all") {
+		t.Errorf("check %+v, want a warning about the etcd peer certificate", c)
+	}
+	if !c.Verified {
+		t.Error("an expiring certificate failed the verification")
+	}
+}
+
+func TestWatcherOnce(t *testing.T) {
+	server := testutil.NewSSHServer(t).Respond("openssl x509", endDate("/etc/kubernetes/pki/apiserver.crt", time.Now().Add(365*24*time.Hour)))
+	problem := ""
+	w, _ := testWatcher(t, server, &problem)
+
+	if err := w.once(); err != nil {
+		t.Errorf("healthy fleet failed: %v", err)
+	}
+	problem = "nodes not Ready: worker-1"
+	if err := w.once(); err == nil || err.Error() != "1 of 1 clusters need attention" {
+		t.Errorf("got %v, want 1 of 1 clusters need attention", err)
+	}
+
+	// Unreachable clusters need attention too
+	w.cfg.Hosts[0].Port = 1
+	problem = ""
+	if err := w.once(); err == nil {
+		t.Error("an unreachable cluster passed")
+	}
+}
+
+func TestWatcherClusters(t *testing.T) {
+	server := testutil.NewSSHServer(t)
+	problem := ""
+	w, _ := testWatcher(t, server, &problem)
+
+	// A cluster set up from another configuration
+	other := status.New("10.1.0.1")
+	other.Status = "Completed"
+	other.Kubeconfig = other.KubeconfigPath()
+	if err := other.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	clusters, err := w.clusters()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(clusters) != 1 || clusters[0].VMIP != "127.0.0.1" {
+		t.Errorf("watched %d clusters, want only the host of the configuration", len(clusters))
+	}
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/maarulav/k8s-setup/pkg/config"
+)
+
+// windowDeadline returns when the maintenance window the run starts in
+// closes, or the zero time without a window or when ignore is set. Outside
+// the window it fails with the time the window opens next.
+func windowDeadline(cfg *config.Config, ignore bool) (time.Time, error) {
+	if cfg.MaintenanceWindow == nil || ignore {
+		return time.Time{}, nil
+	}
+	return cfg.MaintenanceWindow.End(time.Now())
+}
+
+// checkWindow fails when the maintenance window closing at deadline leaves
+// less than its reserve for next
+func checkWindow(cfg *config.Config, deadline time.Time, next string) error {
+	if !deadline.IsZero() && time.Until(deadline) < cfg.MaintenanceWindow.ReserveDuration() {
+		return fmt.Errorf("maintenance window closes at %s, stopped before %s", deadline.Format(time.RFC1123), next)
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package events
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// Event types emitted during a provisioning run
+const (
+	RunStarted    = "io.k8s-setup.run.started"
+	RunFinished   = "io.k8s-setup.run.finished"
+	StepStarted   = "io.k8s-setup.step.started"
+	StepCompleted = "io.k8s-setup.step.completed"
+	HostCompleted = "io.k8s-setup.host.completed"
+	HostFailed    = "io.k8s-setup.host.failed"
+	HostAborted   = "io.k8s-setup.host.aborted"
+)
+
+// Event types emitted by k8s-setup watch
+const (
+	ClusterUnhealthy = "io.k8s-setup.cluster.unhealthy"
+	ClusterRecovered = "io.k8s-setup.cluster.recovered"
+)
+
+// Source is the CloudEvents source of every event
+const Source = "/k8s-setup"
+
+// Event is a CloudEvents 1.0 event in structured JSON mode
+type Event struct {
+	SpecVersion     string      `json:"specversion"`
+	ID              string      `json:"id"`
+	Source          string      `json:"source"`
+	Type            string      `json:"type"`
+	Subject         string      `json:"subject,omitempty"`
+	Time            time.Time   `json:"time"`
+	DataContentType string      `json:"datacontenttype"`
+	Data            interface{} `json:"data,omitempty"`
+}
+
+// Emitter posts events to HTTP sinks such as a Knative broker or an Argo
+// Events webhook source. Delivery is best effort: failures are logged and
+// never fail the run.
+type Emitter struct {
+	sinks  []string
+	client *http.Client
+}
+
+// New creates an Emitter for the given sink URLs. Without sinks Emit is a no-op.
+func New(sinks []string) *Emitter {
+	return &Emitter{
+		sinks:  sinks,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Emit sends an event of eventType about subject, usually a host IP, to every sink
+func (e *Emitter) Emit(eventType, subject string, data interface{}) {
+	if e == nil || len(e.sinks) == 0 {
+		return
+	}
+
+	body, err := json.Marshal(Event{
+		SpecVersion:     "1.0",
+		ID:              newID(),
+		Source:          Source,
+		Type:            eventType,
+		Subject:         subject,
+		Time:            time.Now().UTC(),
+		DataContentType: "application/json",
+		Data:            data,
+	})
+	if err != nil {
+		log.Printf("Warning: failed to encode %s event: %v", eventType, err)
+		return
+	}
+
+	for _, sink := range e.sinks {
+		if err := e.post(sink, body); err != nil {
+			log.Printf("Warning: failed to deliver %s event to %s: %v", eventType, sink, err)
+		}
+	}
+}
+
+func (e *Emitter) post(sink string, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, sink, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/cloudevents+json; charset=utf-8")
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("sink returned %s", resp.Status)
+	}
+	return nil
+}
+
+func newID() string {
+	id := make([]byte, 16)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
@@ -0,0 +1,102 @@
+package events
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// received is a request received by the fake sink
+type received struct {
+	method, contentType string
+	event               map[string]interface{}
+}
+
+// sink returns a CloudEvents sink answering with status and the requests it
+// received
+func sink(t *testing.T, status int) (*httptest.Server, func() []received) {
+	var mu sync.Mutex
+	var requests []received
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		var event map[string]interface{}
+		if err := json.Unmarshal(body, &event); err != nil {
+			t.Errorf("event is no JSON: %v\n%s", err, body)
+		}
+		mu.Lock()
+		requests = append(requests, received{r.Method, r.Header.Get("Content-Type"), event})
+		mu.Unlock()
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(server.Close)
+	return server, func() []received {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]received(nil), requests...)
+	}
+}
+
+func TestEmit(t *testing.T) {
+	server, requests := sink(t, http.StatusAccepted)
+	start := time.Now().UTC()
+
+	New([]string{server.URL}).Emit(HostCompleted, "10.0.0.1", map[string]string{"status": "Completed"})
+
+	got := requests()
+	if len(got) != 1 {
+		t.Fatalf("%d requests, want one", len(got))
+	}
+	r := got[0]
+	if r.method != http.MethodPost || r.contentType != "application/cloudevents+json; charset=utf-8" {
+		t.Errorf("delivered with %s as %q", r.method, r.contentType)
+	}
+	for attribute, want := range map[string]string{
+		"specversion":     "1.0",
+		"source":          Source,
+		"type":            HostCompleted,
+		"subject":         "10.0.0.1",
+		"datacontenttype": "application/json",
+	} {
+		if r.event[attribute] != want {
+			t.Errorf("%s = %v, want %q", attribute, r.event[attribute], want)
+		}
+	}
+	if id, _ := r.event["id"].(string); len(id) != 32 {
+		t.Errorf("id %q, want 16 random bytes in hex", id)
+	}
+	if ts, err := time.Parse(time.RFC3339Nano, r.event["time"].(string)); err != nil || ts.Before(start.Add(-time.Second)) {
+		t.Errorf("time %v, %v", r.event["time"], err)
+	}
+	if data, _ := r.event["data"].(map[string]interface{}); data["status"] != "Completed" {
+		t.Errorf("data %v", r.event["data"])
+	}
+}
+
+func TestEmitSinks(t *testing.T) {
+	failing, failed := sink(t, http.StatusInternalServerError)
+	server, requests := sink(t, http.StatusOK)
+
+	// A failing sink does not keep the event from the others
+	e := New([]string{failing.URL, "http://127.0.0.1:1", server.URL})
+	e.Emit(StepStarted, "10.0.0.1", nil)
+	e.Emit(StepCompleted, "10.0.0.1", nil)
+	if len(failed()) != 2 || len(requests()) != 2 {
+		t.Fatalf("%d and %d requests, want two to each sink", len(failed()), len(requests()))
+	}
+	first, second := requests()[0].event, requests()[1].event
+	if first["id"] == second["id"] {
+		t.Errorf("two events with id %v", first["id"])
+	}
+	if _, ok := first["data"]; ok {
+		t.Errorf("event without data has data %v", first["data"])
+	}
+
+	// Without sinks nothing is sent
+	var none *Emitter
+	none.Emit(RunStarted, "", nil)
+	New(nil).Emit(RunStarted, "", nil)
+}
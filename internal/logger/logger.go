@@ -1,6 +1,7 @@
 package logger
 
 import (
+	"io"
 	"log"
 	"os"
 
@@ -29,3 +30,9 @@ func (l *Logger) SetStatus(status *status.SetupStatus) {
 func (l *Logger) GetStatus() *status.SetupStatus {
 	return l.status
 }
+
+// Tee copies the output of the logger and of the standard logger to w
+func (l *Logger) Tee(w io.Writer) {
+	l.SetOutput(io.MultiWriter(os.Stdout, w))
+	log.SetOutput(io.MultiWriter(os.Stderr, w))
+}
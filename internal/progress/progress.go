@@ -0,0 +1,143 @@
+package progress
+
+import (
+	"context"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Hub keeps the log of every host of a run and streams it to HTTP clients
+// while it is written. Hosts are provisioned one after the other, so
+// everything written goes to the host set last.
+type Hub struct {
+	mu      sync.Mutex
+	logs    map[string][]byte
+	done    map[string]bool
+	current string
+	changed chan struct{}
+}
+
+// New creates a Hub for the hosts of a run
+func New(hosts []string) *Hub {
+	h := &Hub{
+		logs:    map[string][]byte{},
+		done:    map[string]bool{},
+		changed: make(chan struct{}),
+	}
+	for _, host := range hosts {
+		h.logs[host] = nil
+	}
+	return h
+}
+
+// SetHost directs the following writes to the log of host and ends the log
+// of the previous one
+func (h *Hub) SetHost(host string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.current != "" {
+		h.done[h.current] = true
+	}
+	h.current = host
+	h.notify()
+}
+
+// Close ends the logs of all hosts, including those never started
+func (h *Hub) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for host := range h.logs {
+		h.done[host] = true
+	}
+	h.notify()
+}
+
+// Write appends p to the log of the current host
+func (h *Hub) Write(p []byte) (int, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.logs[h.current]; ok && !h.done[h.current] {
+		h.logs[h.current] = append(h.logs[h.current], p...)
+		h.notify()
+	}
+	return len(p), nil
+}
+
+// notify wakes up the streams waiting for changes, h.mu must be held
+func (h *Hub) notify() {
+	close(h.changed)
+	h.changed = make(chan struct{})
+}
+
+// ServeHTTP streams the log of the host named in the path as chunked plain
+// text, from its beginning until the host is done or the client goes away
+func (h *Hub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	host := r.PathValue("host")
+	h.mu.Lock()
+	_, ok := h.logs[host]
+	h.mu.Unlock()
+	if !ok {
+		http.Error(w, "unknown host "+host, http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	flusher, _ := w.(http.Flusher)
+
+	offset := 0
+	for {
+		h.mu.Lock()
+		chunk := h.logs[host][offset:]
+		done := h.done[host]
+		changed := h.changed
+		h.mu.Unlock()
+
+		if len(chunk) > 0 {
+			if _, err := w.Write(chunk); err != nil {
+				return
+			}
+			offset += len(chunk)
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		if done {
+			return
+		}
+
+		select {
+		case <-changed:
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// Serve exposes hub on addr under /hosts/{host}/log. The returned function
+// ends all logs and stops the server once the open streams are written out.
+func Serve(addr string, hub *Hub) (func(), error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("GET /hosts/{host}/log", hub)
+	server := &http.Server{Handler: mux}
+	go func() {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			log.Printf("Warning: progress endpoint failed: %v", err)
+		}
+	}()
+
+	return func() {
+		hub.Close()
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		server.Shutdown(ctx)
+	}, nil
+}
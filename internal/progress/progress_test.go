@@ -0,0 +1,160 @@
+package progress
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// serve serves hub under /hosts/{host}/log as Serve does
+func serve(t *testing.T, hub *Hub) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.Handle("GET /hosts/{host}/log", hub)
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+// stream opens the log of host
+func stream(t *testing.T, server *httptest.Server, host string) *http.Response {
+	t.Helper()
+	resp, err := http.Get(server.URL + "/hosts/" + host + "/log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+// readLine reads a line of r, failing the test when none arrives in time
+func readLine(t *testing.T, r *bufio.Reader) string {
+	t.Helper()
+	line := make(chan string, 1)
+	go func() {
+		s, _ := r.ReadString('\n')
+		line <- s
+	}()
+	select {
+	case s := <-line:
+		return s
+	case <-time.After(5 * time.Second):
+		t.Fatal("no line streamed")
+		return ""
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	hub := New([]string{"10.0.0.1", "10.0.0.2"})
+	server := serve(t, hub)
+
+	hub.SetHost("10.0.0.1")
+	hub.Write([]byte("installing containerd\n"))
+
+	// A client subscribing late gets the log from its beginning, then what
+	// is written while it streams
+	resp := stream(t, server, "10.0.0.1")
+	if ct := resp.Header.Get("Content-Type"); ct != "text/plain; charset=utf-8" {
+		t.Errorf("content type %q", ct)
+	}
+	r := bufio.NewReader(resp.Body)
+	if line := readLine(t, r); line != "installing containerd\n" {
+		t.Errorf("first line %q", line)
+	}
+	hub.Write([]byte("running kubeadm init\n"))
+	if line := readLine(t, r); line != "running kubeadm init\n" {
+		t.Errorf("second line %q", line)
+	}
+
+	// Moving on to the next host ends the stream, later writes go elsewhere
+	hub.SetHost("10.0.0.2")
+	hub.Write([]byte("joining\n"))
+	rest, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rest) != 0 {
+		t.Errorf("stream of a done host went on with %q", rest)
+	}
+
+	if resp := stream(t, server, "10.0.0.3"); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("unknown host: status %d, want 404", resp.StatusCode)
+	}
+}
+
+func TestBroadcast(t *testing.T) {
+	hub := New([]string{"10.0.0.1"})
+	server := serve(t, hub)
+	hub.SetHost("10.0.0.1")
+
+	var readers []*bufio.Reader
+	for i := 0; i < 3; i++ {
+		readers = append(readers, bufio.NewReader(stream(t, server, "10.0.0.1").Body))
+	}
+	for _, line := range []string{"one\n", "two\n", "three\n"} {
+		hub.Write([]byte(line))
+		for i, r := range readers {
+			if got := readLine(t, r); got != line {
+				t.Errorf("client %d got %q, want %q", i, got, line)
+			}
+		}
+	}
+
+	// Close ends every stream and later writes are dropped
+	hub.Close()
+	for i, r := range readers {
+		if rest, _ := io.ReadAll(r); len(rest) != 0 {
+			t.Errorf("client %d got %q after the hub closed", i, rest)
+		}
+	}
+	hub.Write([]byte("ignored\n"))
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	if strings.Contains(string(hub.logs["10.0.0.1"]), "ignored") {
+		t.Error("a closed hub kept writing")
+	}
+}
+
+func TestSlowConsumer(t *testing.T) {
+	hub := New([]string{"10.0.0.1"})
+	server := serve(t, hub)
+	hub.SetHost("10.0.0.1")
+
+	// A client that does not read fills the connection buffers, which must
+	// not hold up the run writing to the hub
+	resp := stream(t, server, "10.0.0.1")
+	line := bytes.Repeat([]byte("x"), 1023)
+	line = append(line, '\n')
+	written := make(chan struct{})
+	go func() {
+		for i := 0; i < 16*1024; i++ {
+			hub.Write(line)
+		}
+		close(written)
+	}()
+	select {
+	case <-written:
+	case <-time.After(10 * time.Second):
+		t.Fatal("writes blocked on a slow client")
+	}
+
+	// Other clients are served meanwhile
+	fast := bufio.NewReader(stream(t, server, "10.0.0.1").Body)
+	if got := readLine(t, fast); got != string(line) {
+		t.Errorf("second client got %d bytes, want a line", len(got))
+	}
+
+	// The slow client still gets the whole log once it reads
+	hub.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != 16*1024*len(line) {
+		t.Errorf("slow client got %d bytes, want %d", len(data), 16*1024*len(line))
+	}
+}
@@ -0,0 +1,112 @@
+package report
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/maarulav/k8s-setup/pkg/config"
+)
+
+// Check is the outcome of a scheduled check of a cluster
+type Check struct {
+	Cluster string
+	IP      string
+	Time    time.Time
+	// Verified is false when Error is set
+	Verified bool
+	Error    string
+	// CertExpiry is when the first control plane certificate expires, Cert
+	// which one. Both are zero when the certificates could not be read.
+	CertExpiry time.Time
+	Cert       string
+	// Problems are what the check alerts about, empty for a healthy cluster
+	Problems []string
+}
+
+// CheckRound is a round of checks across the fleet
+type CheckRound struct {
+	Start  time.Time
+	End    time.Time
+	Checks []Check
+}
+
+// Unhealthy returns the number of clusters with problems
+func (r *CheckRound) Unhealthy() int {
+	unhealthy := 0
+	for _, c := range r.Checks {
+		if len(c.Problems) > 0 {
+			unhealthy++
+		}
+	}
+	return unhealthy
+}
+
+// Text renders the checks as plain text, the clusters with problems first
+func (r *CheckRound) Text() string {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "Checks started %s and took %s.\n\n", r.Start.Format(time.RFC1123), r.End.Sub(r.Start).Round(time.Second))
+
+	w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "CLUSTER\tIP\tVERIFIED\tCERT EXPIRY")
+	for _, c := range r.Checks {
+		expiry := "-"
+		if !c.CertExpiry.IsZero() {
+			expiry = fmt.Sprintf("%s (%s)", c.CertExpiry.Format("2006-01-02"), c.Cert)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%t\t%s\n", c.Cluster, c.IP, c.Verified, expiry)
+	}
+	w.Flush()
+
+	for _, c := range r.Checks {
+		if len(c.Problems) == 0 {
+			continue
+		}
+		fmt.Fprintf(&b, "\n%s (%s):\n", c.Cluster, c.IP)
+		for _, problem := range c.Problems {
+			fmt.Fprintf(&b, "  %s\n", excerpt(problem))
+		}
+	}
+	return b.String()
+}
+
+// SendAlert mails the problems of the round as configured in cfg. Healthy
+// clusters are listed too, for context.
+func SendAlert(cfg config.EmailReport, r *CheckRound) error {
+	if !cfg.Enabled {
+		return nil
+	}
+	hostname, _ := os.Hostname()
+	subject := fmt.Sprintf("k8s-setup watch on %s: %d of %d clusters need attention", hostname, r.Unhealthy(), len(r.Checks))
+	return mail(cfg, subject, r.End, r.Text())
+}
+
+// PushChecks replaces the metrics of the "watch" operation on the
+// Pushgateway configured in cfg with those of the round r
+func PushChecks(cfg config.Pushgateway, r *CheckRound) error {
+	return push(cfg, "", "watch", r.Metrics())
+}
+
+// Metrics renders the round in the Prometheus text format
+func (r *CheckRound) Metrics() string {
+	var b bytes.Buffer
+	gauge := func(name, help string) {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s gauge\n", name, help, name)
+	}
+
+	gauge("k8s_setup_check_timestamp_seconds", "End of the last round of checks.")
+	fmt.Fprintf(&b, "k8s_setup_check_timestamp_seconds %d\n", r.End.Unix())
+	gauge("k8s_setup_cluster_verified", "Whether the cluster passed its last verification.")
+	for _, c := range r.Checks {
+		fmt.Fprintf(&b, "k8s_setup_cluster_verified{cluster=%q,host=%q} %d\n", c.Cluster, c.IP, boolValue(c.Verified))
+	}
+	gauge("k8s_setup_cluster_cert_expiry_timestamp_seconds", "When the first control plane certificate of the cluster expires.")
+	for _, c := range r.Checks {
+		if !c.CertExpiry.IsZero() {
+			fmt.Fprintf(&b, "k8s_setup_cluster_cert_expiry_timestamp_seconds{cluster=%q,host=%q} %d\n", c.Cluster, c.IP, c.CertExpiry.Unix())
+		}
+	}
+	return b.String()
+}
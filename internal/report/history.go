@@ -0,0 +1,223 @@
+package report
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/maarulav/k8s-setup/internal/status"
+)
+
+// RunsDir holds a record of every run, named after the time it started and
+// a counter when several started within the same second
+var RunsDir = filepath.Join(status.Dir, "runs")
+
+// runIDFormat is the layout of run IDs, the start time of the run
+const runIDFormat = "20060102-150405"
+
+// Run is the saved record of a run of operation, e.g. "apply" or "upgrade"
+type Run struct {
+	ID        string `json:"id"`
+	Operation string `json:"operation"`
+	Summary
+}
+
+// SaveRun records the run s of operation in RunsDir and returns its ID. A
+// run started in the same second as a saved one gets the next free suffix,
+// e.g. 20240101-120000-2.
+func SaveRun(operation string, s *Summary) (string, error) {
+	if err := os.MkdirAll(RunsDir, 0700); err != nil {
+		return "", err
+	}
+	base := s.Start.Format(runIDFormat)
+	for n := 1; ; n++ {
+		id := base
+		if n > 1 {
+			id = fmt.Sprintf("%s-%d", base, n)
+		}
+		data, err := json.MarshalIndent(Run{ID: id, Operation: operation, Summary: *s}, "", "  ")
+		if err != nil {
+			return "", err
+		}
+		path := filepath.Join(RunsDir, id+".json")
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if os.IsExist(err) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		_, err = f.Write(data)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			os.Remove(path)
+			return "", err
+		}
+		return id, nil
+	}
+}
+
+// LoadRun reads the run with id, or the one saved at the path id
+func LoadRun(id string) (*Run, error) {
+	path := id
+	if !strings.HasSuffix(id, ".json") {
+		path = filepath.Join(RunsDir, id+".json")
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read run %s: %v", id, err)
+	}
+	var run Run
+	if err := json.Unmarshal(data, &run); err != nil {
+		return nil, fmt.Errorf("failed to parse run %s: %v", id, err)
+	}
+	return &run, nil
+}
+
+// ListRuns returns the saved runs, oldest first
+func ListRuns() ([]*Run, error) {
+	paths, err := filepath.Glob(filepath.Join(RunsDir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	var runs []*Run
+	for _, path := range paths {
+		run, err := LoadRun(path)
+		if err != nil {
+			return nil, err
+		}
+		runs = append(runs, run)
+	}
+	// Suffixed IDs do not sort by name, 20240101-120000-2 comes first
+	sort.SliceStable(runs, func(i, j int) bool {
+		if !runs[i].Start.Equal(runs[j].Start) {
+			return runs[i].Start.Before(runs[j].Start)
+		}
+		return len(runs[i].ID) < len(runs[j].ID) || len(runs[i].ID) == len(runs[j].ID) && runs[i].ID < runs[j].ID
+	})
+	return runs, nil
+}
+
+// StepChange compares a step of two runs across their hosts. The durations
+// are the medians over the hosts that completed the step, zero when none did.
+type StepChange struct {
+	Step          string
+	Before, After time.Duration
+	HostsBefore   int
+	HostsAfter    int
+	FailedBefore  int
+	FailedAfter   int
+	newlyFailedOn []string
+}
+
+// Slower reports whether the step took more than threshold, a fraction such
+// as 0.2, and at least minDelta longer than before
+func (c StepChange) Slower(threshold float64, minDelta time.Duration) bool {
+	if c.Before == 0 || c.After == 0 {
+		return false
+	}
+	delta := c.After - c.Before
+	return delta >= minDelta && float64(delta) > threshold*float64(c.Before)
+}
+
+// NewlyFailing reports whether the step failed in the second run on a host
+// it had completed on in the first, or failed at all where it never did
+func (c StepChange) NewlyFailing() bool {
+	return len(c.newlyFailedOn) > 0 || (c.FailedBefore == 0 && c.FailedAfter > 0)
+}
+
+// NewlyFailedOn returns the hosts the step failed on in the second run after
+// completing there in the first
+func (c StepChange) NewlyFailedOn() []string {
+	return c.newlyFailedOn
+}
+
+// Compare returns the changes of every step between the runs a and b, in the
+// order the steps ran
+func Compare(a, b *Run) []StepChange {
+	changes := map[string]*StepChange{}
+	var order []string
+	change := func(step string) *StepChange {
+		c, ok := changes[step]
+		if !ok {
+			c = &StepChange{Step: step}
+			changes[step] = c
+			order = append(order, step)
+		}
+		return c
+	}
+
+	durations := func(run *Run, after bool) map[string][]time.Duration {
+		completed := map[string][]time.Duration{}
+		for _, h := range run.Hosts {
+			for _, step := range h.Steps {
+				completed[step.Name] = append(completed[step.Name], step.Duration)
+				c := change(step.Name)
+				if after {
+					c.HostsAfter++
+				} else {
+					c.HostsBefore++
+				}
+			}
+			if h.FailedStep == "" {
+				continue
+			}
+			c := change(h.FailedStep)
+			if after {
+				c.FailedAfter++
+			} else {
+				c.FailedBefore++
+			}
+		}
+		return completed
+	}
+	before := durations(a, false)
+	after := durations(b, true)
+
+	// Which hosts completed each step in a
+	completedBefore := map[string]map[string]bool{}
+	for _, h := range a.Hosts {
+		for _, step := range h.Steps {
+			if completedBefore[step.Name] == nil {
+				completedBefore[step.Name] = map[string]bool{}
+			}
+			completedBefore[step.Name][h.IP] = true
+		}
+	}
+	for _, h := range b.Hosts {
+		if h.FailedStep != "" && completedBefore[h.FailedStep][h.IP] {
+			c := changes[h.FailedStep]
+			c.newlyFailedOn = append(c.newlyFailedOn, h.IP)
+		}
+	}
+
+	result := make([]StepChange, 0, len(order))
+	for _, step := range order {
+		c := changes[step]
+		c.Before = median(before[step])
+		c.After = median(after[step])
+		result = append(result, *c)
+	}
+	return result
+}
+
+// median returns the median of durations, zero for none
+func median(durations []time.Duration) time.Duration {
+	if len(durations) == 0 {
+		return 0
+	}
+	sorted := append([]time.Duration{}, durations...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	middle := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[middle-1] + sorted[middle]) / 2
+	}
+	return sorted[middle]
+}
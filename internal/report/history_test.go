@@ -0,0 +1,190 @@
+package report
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSaveRunSameSecond(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	var ids []string
+	for i := 0; i < 3; i++ {
+		s := &Summary{Start: start.Add(time.Duration(i) * time.Millisecond), End: start.Add(time.Minute)}
+		id, err := SaveRun("apply", s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+	if want := []string{"20240101-120000", "20240101-120000-2", "20240101-120000-3"}; !reflect.DeepEqual(ids, want) {
+		t.Fatalf("saved as %v, want %v", ids, want)
+	}
+	if _, err := SaveRun("upgrade", &Summary{Start: start.Add(-time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+
+	runs, err := ListRuns()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var listed []string
+	for _, run := range runs {
+		listed = append(listed, run.ID)
+	}
+	if want := []string{"20240101-110000", "20240101-120000", "20240101-120000-2", "20240101-120000-3"}; !reflect.DeepEqual(listed, want) {
+		t.Errorf("listed %v, want %v", listed, want)
+	}
+	if run, err := LoadRun("20240101-120000-2"); err != nil || run.Operation != "apply" || !run.Start.Equal(start.Add(time.Millisecond)) {
+		t.Errorf("LoadRun = %+v, %v", run, err)
+	}
+}
+
+func TestListRunsEmpty(t *testing.T) {
+	t.Chdir(t.TempDir())
+	if runs, err := ListRuns(); err != nil || len(runs) != 0 {
+		t.Errorf("ListRuns = %v, %v, want none", runs, err)
+	}
+}
+
+func TestMedian(t *testing.T) {
+	for _, tt := range []struct {
+		durations []time.Duration
+		want      time.Duration
+	}{
+		{nil, 0},
+		{[]time.Duration{7 * time.Second}, 7 * time.Second},
+		{[]time.Duration{9 * time.Second, time.Second, 5 * time.Second}, 5 * time.Second},
+		{[]time.Duration{4 * time.Second, time.Second, 2 * time.Second, 10 * time.Second}, 3 * time.Second},
+	} {
+		if got := median(tt.durations); got != tt.want {
+			t.Errorf("median(%v) = %s, want %s", tt.durations, got, tt.want)
+		}
+	}
+}
+
+// run returns a run whose hosts completed the steps "name=duration" and
+// failed at the step "!name"
+func run(hosts map[string][]string) *Run {
+	r := &Run{}
+	for ip, steps := range hosts {
+		h := Host{IP: ip}
+		for _, step := range steps {
+			if failed, ok := strings.CutPrefix(step, "!"); ok {
+				h.FailedStep = failed
+				continue
+			}
+			name, value, _ := strings.Cut(step, "=")
+			duration, _ := time.ParseDuration(value)
+			h.Steps = append(h.Steps, Step{Name: name, Duration: duration})
+		}
+		r.Hosts = append(r.Hosts, h)
+	}
+	return r
+}
+
+func TestCompare(t *testing.T) {
+	for _, tt := range []struct {
+		name          string
+		a, b          *Run
+		step          string
+		slower        bool
+		newlyFailing  bool
+		newlyFailedOn []string
+	}{
+		{
+			name:   "slower by the median of the hosts",
+			a:      run(map[string][]string{"10.0.0.1": {"docker=60s"}, "10.0.0.2": {"docker=60s"}}),
+			b:      run(map[string][]string{"10.0.0.1": {"docker=90s"}, "10.0.0.2": {"docker=90s"}}),
+			step:   "docker",
+			slower: true,
+		},
+		{
+			name: "below the threshold",
+			a:    run(map[string][]string{"10.0.0.1": {"docker=60s"}}),
+			b:    run(map[string][]string{"10.0.0.1": {"docker=70s"}}),
+			step: "docker",
+		},
+		{
+			name: "below the minimum delta",
+			a:    run(map[string][]string{"10.0.0.1": {"hostname=1s"}}),
+			b:    run(map[string][]string{"10.0.0.1": {"hostname=4s"}}),
+			step: "hostname",
+		},
+		{
+			name: "faster",
+			a:    run(map[string][]string{"10.0.0.1": {"docker=90s"}}),
+			b:    run(map[string][]string{"10.0.0.1": {"docker=60s"}}),
+			step: "docker",
+		},
+		{
+			name:          "failing where it completed",
+			a:             run(map[string][]string{"10.0.0.1": {"docker=60s", "kubernetes=120s"}, "10.0.0.2": {"docker=60s", "kubernetes=120s"}}),
+			b:             run(map[string][]string{"10.0.0.1": {"docker=60s", "kubernetes=120s"}, "10.0.0.2": {"docker=60s", "!kubernetes"}}),
+			step:          "kubernetes",
+			newlyFailing:  true,
+			newlyFailedOn: []string{"10.0.0.2"},
+		},
+		{
+			name:         "failing for the first time on a new host",
+			a:            run(map[string][]string{"10.0.0.1": {"docker=60s"}}),
+			b:            run(map[string][]string{"10.0.0.1": {"docker=60s"}, "10.0.0.3": {"!docker"}}),
+			step:         "docker",
+			newlyFailing: true,
+		},
+		{
+			name: "failing as before",
+			a:    run(map[string][]string{"10.0.0.1": {"!docker"}}),
+			b:    run(map[string][]string{"10.0.0.1": {"!docker"}}),
+			step: "docker",
+		},
+		{
+			name: "missing from the first run",
+			a:    run(map[string][]string{"10.0.0.1": {"docker=60s"}}),
+			b:    run(map[string][]string{"10.0.0.1": {"docker=60s", "velero=90s"}}),
+			step: "velero",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var change *StepChange
+			for _, c := range Compare(tt.a, tt.b) {
+				if c.Step == tt.step {
+					c := c
+					change = &c
+				}
+			}
+			if change == nil {
+				t.Fatalf("step %s missing from the comparison", tt.step)
+			}
+			if got := change.Slower(0.2, 5*time.Second); got != tt.slower {
+				t.Errorf("Slower = %t, want %t (%s -> %s)", got, tt.slower, change.Before, change.After)
+			}
+			if got := change.NewlyFailing(); got != tt.newlyFailing {
+				t.Errorf("NewlyFailing = %t, want %t", got, tt.newlyFailing)
+			}
+			if got := change.NewlyFailedOn(); !reflect.DeepEqual(got, tt.newlyFailedOn) {
+				t.Errorf("NewlyFailedOn = %v, want %v", got, tt.newlyFailedOn)
+			}
+		})
+	}
+}
+
+func TestCompareOrder(t *testing.T) {
+	a := run(map[string][]string{"10.0.0.1": {"hostname=1s", "docker=60s", "kubernetes=120s"}})
+	b := run(map[string][]string{"10.0.0.1": {"hostname=1s", "docker=60s", "!kubernetes"}})
+
+	changes := Compare(a, b)
+	var steps []string
+	for _, c := range changes {
+		steps = append(steps, c.Step)
+	}
+	if want := []string{"hostname", "docker", "kubernetes"}; !reflect.DeepEqual(steps, want) {
+		t.Fatalf("steps %v, want %v", steps, want)
+	}
+	if c := changes[2]; c.HostsBefore != 1 || c.HostsAfter != 0 || c.FailedAfter != 1 || c.Before != 120*time.Second || c.After != 0 {
+		t.Errorf("kubernetes: %+v", c)
+	}
+}
@@ -0,0 +1,107 @@
+package report
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/maarulav/k8s-setup/pkg/config"
+)
+
+// pushTimeout bounds the request to the Pushgateway
+const pushTimeout = 30 * time.Second
+
+// Step is a completed step of a host and how long it took
+type Step struct {
+	Name     string        `json:"name"`
+	Duration time.Duration `json:"duration"`
+
+	// Commands are the commands the step ran, generalized like those of
+	// an allowlist. Only the runbook shows them.
+	Commands []string `json:"-"`
+}
+
+// Push replaces the metrics of operation, e.g. "apply" or "upgrade", of
+// cluster on the Pushgateway configured in cfg with those of the run s
+func Push(cfg config.Pushgateway, cluster, operation string, s *Summary) error {
+	return push(cfg, cluster, operation, s.Metrics())
+}
+
+// push replaces the metrics of operation on the Pushgateway with metrics, in
+// the Prometheus text format. The cluster, when set, is part of the grouping
+// key, so runs against different clusters do not replace each other.
+func push(cfg config.Pushgateway, cluster, operation, metrics string) error {
+	if cfg.URL == "" {
+		return nil
+	}
+	job := cfg.Job
+	if job == "" {
+		job = "k8s-setup"
+	}
+	target := fmt.Sprintf("%s/metrics/job/%s", strings.TrimSuffix(cfg.URL, "/"), url.PathEscape(job))
+	if cluster != "" {
+		target += "/cluster/" + url.PathEscape(cluster)
+	}
+	target += "/operation/" + url.PathEscape(operation)
+
+	req, err := http.NewRequest(http.MethodPut, target, strings.NewReader(metrics))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; version=0.0.4")
+	if cfg.Username != "" {
+		req.SetBasicAuth(cfg.Username, cfg.Password)
+	}
+	resp, err := (&http.Client{Timeout: pushTimeout}).Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to push metrics: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("pushgateway returned %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+// Metrics renders the run in the Prometheus text format
+func (s *Summary) Metrics() string {
+	var b bytes.Buffer
+	gauge := func(name, help string) {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s gauge\n", name, help, name)
+	}
+
+	gauge("k8s_setup_run_timestamp_seconds", "End of the last run.")
+	fmt.Fprintf(&b, "k8s_setup_run_timestamp_seconds %d\n", s.End.Unix())
+	gauge("k8s_setup_run_duration_seconds", "Duration of the last run.")
+	fmt.Fprintf(&b, "k8s_setup_run_duration_seconds %g\n", s.End.Sub(s.Start).Seconds())
+	gauge("k8s_setup_run_success", "Whether every host of the last run completed and nothing else failed.")
+	fmt.Fprintf(&b, "k8s_setup_run_success %d\n", boolValue(s.Succeeded()))
+
+	gauge("k8s_setup_host_success", "Whether the host completed in the last run.")
+	for _, h := range s.Hosts {
+		fmt.Fprintf(&b, "k8s_setup_host_success{host=%q} %d\n", h.IP, boolValue(h.Status == "Completed"))
+	}
+	gauge("k8s_setup_host_duration_seconds", "Time spent on the host in the last run.")
+	for _, h := range s.Hosts {
+		fmt.Fprintf(&b, "k8s_setup_host_duration_seconds{host=%q} %g\n", h.IP, h.Duration.Seconds())
+	}
+	gauge("k8s_setup_step_duration_seconds", "Duration of the steps completed in the last run.")
+	for _, h := range s.Hosts {
+		for _, step := range h.Steps {
+			fmt.Fprintf(&b, "k8s_setup_step_duration_seconds{host=%q,step=%q} %g\n", h.IP, step.Name, step.Duration.Seconds())
+		}
+	}
+	return b.String()
+}
+
+func boolValue(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,106 @@
+package report
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/maarulav/k8s-setup/internal/testutil"
+	"github.com/maarulav/k8s-setup/pkg/config"
+)
+
+// pushed is a request received by the fake Pushgateway
+type pushed struct {
+	method, path, contentType, body string
+	username, password              string
+}
+
+// pushgateway returns a Pushgateway answering with status and the requests
+// it received
+func pushgateway(t *testing.T, status int) (*httptest.Server, *[]pushed) {
+	var requests []pushed
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		username, password, _ := r.BasicAuth()
+		requests = append(requests, pushed{r.Method, r.URL.EscapedPath(), r.Header.Get("Content-Type"), string(body), username, password})
+		w.WriteHeader(status)
+		if status != http.StatusOK {
+			w.Write([]byte("push rejected\n"))
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server, &requests
+}
+
+// testSummary returns a run of a completed control plane and a failed worker
+func testSummary() *Summary {
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	return &Summary{
+		Start: start,
+		End:   start.Add(10 * time.Minute),
+		Hosts: []Host{
+			{IP: "10.0.0.1", Status: "Completed", Duration: 6 * time.Minute, Steps: []Step{
+				{Name: "control-plane", Duration: 6 * time.Minute},
+			}},
+			{IP: "10.0.0.2", Status: "Failed", Duration: 4 * time.Minute, FailedStep: "worker"},
+		},
+	}
+}
+
+func TestPush(t *testing.T) {
+	server, requests := pushgateway(t, http.StatusOK)
+	cfg := config.Pushgateway{URL: server.URL + "/", Job: "nightly", Username: "ci", Password: "secret"}
+
+	if err := Push(cfg, "prod eu", "upgrade", testSummary()); err != nil {
+		t.Fatal(err)
+	}
+	if len(*requests) != 1 {
+		t.Fatalf("%d requests, want one", len(*requests))
+	}
+	r := (*requests)[0]
+	if r.method != http.MethodPut || r.path != "/metrics/job/nightly/cluster/prod%20eu/operation/upgrade" {
+		t.Errorf("pushed with %s %s", r.method, r.path)
+	}
+	if r.contentType != "text/plain; version=0.0.4" || r.username != "ci" || r.password != "secret" {
+		t.Errorf("pushed as %q with %s:%s", r.contentType, r.username, r.password)
+	}
+	testutil.AssertGolden(t, "push-upgrade", r.body)
+}
+
+func TestPushWithoutCluster(t *testing.T) {
+	server, requests := pushgateway(t, http.StatusOK)
+	if err := Push(config.Pushgateway{URL: server.URL}, "", "apply", testSummary()); err != nil {
+		t.Fatal(err)
+	}
+	if path := (*requests)[0].path; path != "/metrics/job/k8s-setup/operation/apply" {
+		t.Errorf("pushed to %s", path)
+	}
+
+	// Nothing is pushed without a URL
+	if err := Push(config.Pushgateway{}, "prod", "apply", testSummary()); err != nil || len(*requests) != 1 {
+		t.Errorf("push without a URL: %v, %d requests", err, len(*requests))
+	}
+}
+
+func TestPushRejected(t *testing.T) {
+	server, _ := pushgateway(t, http.StatusBadRequest)
+	err := Push(config.Pushgateway{URL: server.URL}, "prod", "apply", testSummary())
+	if err == nil || !strings.Contains(err.Error(), "400") || !strings.Contains(err.Error(), "push rejected") {
+		t.Errorf("error %v, want the status and body of the Pushgateway", err)
+	}
+}
+
+func TestMetricsRunError(t *testing.T) {
+	// A run that failed before any host, e.g. at the backup
+	s := &Summary{Start: time.Now(), End: time.Now(), Error: "backup before upgrade failed"}
+	if metrics := s.Metrics(); !strings.Contains(metrics, "k8s_setup_run_success 0\n") {
+		t.Errorf("metrics of a failed run without hosts:\n%s", metrics)
+	}
+	s.Error = ""
+	if metrics := s.Metrics(); !strings.Contains(metrics, "k8s_setup_run_success 1\n") {
+		t.Errorf("metrics of a run without failures:\n%s", metrics)
+	}
+}
@@ -0,0 +1,189 @@
+package report
+
+import (
+	"bytes"
+	"fmt"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+	"unicode/utf8"
+
+	"github.com/maarulav/k8s-setup/internal/status"
+	"github.com/maarulav/k8s-setup/pkg/config"
+)
+
+// excerptLength bounds the error text quoted for a failed host
+const excerptLength = 500
+
+// Host is the outcome of one host in a run
+type Host struct {
+	IP             string        `json:"ip"`
+	Status         string        `json:"status"`
+	Step           string        `json:"step,omitempty"`
+	CompletedSteps []string      `json:"completedSteps"`
+	Steps          []Step        `json:"steps,omitempty"`
+	Duration       time.Duration `json:"duration"`
+	Error          string        `json:"error,omitempty"`
+	Reports        []string      `json:"reports,omitempty"`
+	// Grafana is the URL Grafana is exposed at, if any
+	Grafana string `json:"grafana,omitempty"`
+	// FailedStep is the name of the step a failed host stopped at
+	FailedStep string `json:"failedStep,omitempty"`
+}
+
+// Summary is the outcome of a provisioning run
+type Summary struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+	Hosts []Host    `json:"hosts"`
+	// Error ended the run outside the step of a host, e.g. a failed backup
+	// before an upgrade
+	Error string `json:"error,omitempty"`
+}
+
+// NewHost records the outcome of the host with status s, finished at end
+func NewHost(s *status.SetupStatus, end time.Time) Host {
+	h := Host{
+		IP:             s.VMIP,
+		Status:         s.Status,
+		Step:           s.CurrentStep,
+		CompletedSteps: s.CompletedSteps,
+		Duration:       end.Sub(s.StartTime).Round(time.Second),
+		Error:          s.Error,
+		Reports:        []string{filepath.Join(status.Dir, s.VMIP+".json")},
+		Grafana:        s.GrafanaURL,
+	}
+	if s.SecurityReport != "" {
+		h.Reports = append(h.Reports, s.SecurityReport)
+	}
+	if s.Runbook != "" {
+		h.Reports = append(h.Reports, s.Runbook)
+	}
+	return h
+}
+
+// Failed returns the number of hosts that did not complete
+func (s *Summary) Failed() int {
+	failed := 0
+	for _, h := range s.Hosts {
+		if h.Status != "Completed" {
+			failed++
+		}
+	}
+	return failed
+}
+
+// Succeeded reports whether every host completed and nothing else failed
+func (s *Summary) Succeeded() bool {
+	return s.Error == "" && s.Failed() == 0
+}
+
+// Subject is the subject line of the summary email
+func (s *Summary) Subject() string {
+	hostname, _ := os.Hostname()
+	if failed := s.Failed(); failed > 0 {
+		return fmt.Sprintf("k8s-setup on %s: %d of %d hosts failed", hostname, failed, len(s.Hosts))
+	}
+	return fmt.Sprintf("k8s-setup on %s: %d hosts completed", hostname, len(s.Hosts))
+}
+
+// Text renders the summary as plain text. reportURL, when set, replaces the
+// status directory in the report paths.
+func (s *Summary) Text(reportURL string) string {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "Run started %s and took %s.\n\n", s.Start.Format(time.RFC1123), s.End.Sub(s.Start).Round(time.Second))
+
+	w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "HOST\tSTATUS\tDURATION\tSTEPS")
+	for _, h := range s.Hosts {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\n", h.IP, h.Status, h.Duration, len(h.CompletedSteps))
+	}
+	w.Flush()
+
+	for _, h := range s.Hosts {
+		if h.Status == "Completed" {
+			continue
+		}
+		fmt.Fprintf(&b, "\n%s %s at %q:\n", h.IP, strings.ToLower(h.Status), h.Step)
+		fmt.Fprintf(&b, "  %s\n", strings.ReplaceAll(excerpt(h.Error), "\n", "\n  "))
+	}
+
+	var endpoints []string
+	for _, h := range s.Hosts {
+		if h.Grafana != "" {
+			endpoints = append(endpoints, fmt.Sprintf("  %s: Grafana %s\n", h.IP, h.Grafana))
+		}
+	}
+	if len(endpoints) > 0 {
+		fmt.Fprintf(&b, "\nEndpoints:\n%s", strings.Join(endpoints, ""))
+	}
+
+	fmt.Fprintln(&b, "\nReports:")
+	for _, h := range s.Hosts {
+		for _, path := range h.Reports {
+			fmt.Fprintf(&b, "  %s: %s\n", h.IP, link(path, reportURL))
+		}
+	}
+	return b.String()
+}
+
+// Send mails the summary as configured in cfg
+func Send(cfg config.EmailReport, s *Summary) error {
+	if !cfg.Enabled || (cfg.OnlyOnFailure && s.Failed() == 0) {
+		return nil
+	}
+	return mail(cfg, s.Subject(), s.End, s.Text(cfg.ReportURL))
+}
+
+// mail sends a plain text message over the SMTP server of cfg
+func mail(cfg config.EmailReport, subject string, date time.Time, text string) error {
+	if cfg.Host == "" || cfg.From == "" || len(cfg.To) == 0 {
+		return fmt.Errorf("email report needs host, from and to")
+	}
+
+	port := cfg.Port
+	if port == 0 {
+		port = 587
+	}
+	var auth smtp.Auth
+	if cfg.Username != "" {
+		auth = smtp.PlainAuth("", cfg.Username, cfg.Password, cfg.Host)
+	}
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", cfg.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(cfg.To, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", subject)
+	fmt.Fprintf(&msg, "Date: %s\r\n", date.Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	msg.WriteString(strings.ReplaceAll(text, "\n", "\r\n"))
+
+	return smtp.SendMail(fmt.Sprintf("%s:%d", cfg.Host, port), auth, cfg.From, cfg.To, msg.Bytes())
+}
+
+// excerpt shortens an error, which may carry the output of a command, to
+// its first excerptLength bytes, without splitting a character
+func excerpt(err string) string {
+	err = strings.TrimSpace(err)
+	if len(err) <= excerptLength {
+		return err
+	}
+	n := excerptLength
+	for n > 0 && !utf8.RuneStart(err[n]) {
+		n--
+	}
+	return err[:n] + " [...]"
+}
+
+// link turns a path under the status directory into a URL below reportURL
+func link(path, reportURL string) string {
+	rel, err := filepath.Rel(status.Dir, path)
+	if reportURL == "" || err != nil || strings.HasPrefix(rel, "..") {
+		return path
+	}
+	return strings.TrimSuffix(reportURL, "/") + "/" + filepath.ToSlash(rel)
+}
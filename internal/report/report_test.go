@@ -0,0 +1,69 @@
+package report
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/maarulav/k8s-setup/internal/testutil"
+)
+
+// mailed renders the subject and text of s as mailed, with the hostname of
+// the machine running the test replaced by ops-1
+func mailed(s *Summary, reportURL string) string {
+	hostname, _ := os.Hostname()
+	subject := strings.Replace(s.Subject(), " on "+hostname+":", " on ops-1:", 1)
+	return "Subject: " + subject + "\n\n" + s.Text(reportURL)
+}
+
+// runSummary returns a run of a completed control plane exposing Grafana and
+// a worker that failed with a long error
+func runSummary() *Summary {
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	return &Summary{
+		Start: start,
+		End:   start.Add(12*time.Minute + 30*time.Second),
+		Hosts: []Host{
+			{
+				IP: "10.0.0.1", Status: "Completed", Step: "Completed",
+				CompletedSteps: []string{"kubernetes", "addons", "monitoring"},
+				Duration:       11 * time.Minute,
+				Reports:        []string{"status/10.0.0.1.json", "status/10.0.0.1/runbook.md"},
+				Grafana:        "http://10.0.0.1:30300",
+			},
+			{
+				IP: "10.0.0.2", Status: "Failed", Step: "Setting up Kubernetes",
+				CompletedSteps: []string{"packages"},
+				Duration:       3 * time.Minute,
+				Error:          "Kubernetes setup failed: exit status 1\nOutput: " + strings.Repeat("[preflight] ", 50),
+				Reports:        []string{"status/10.0.0.2.json"},
+			},
+		},
+	}
+}
+
+func TestSummaryText(t *testing.T) {
+	testutil.AssertGolden(t, "summary-failed", mailed(runSummary(), "https://reports.example.com/k8s/"))
+
+	s := runSummary()
+	s.Hosts = s.Hosts[:1]
+	testutil.AssertGolden(t, "summary-completed", mailed(s, ""))
+}
+
+func TestExcerpt(t *testing.T) {
+	if got := excerpt("  exit status 1\n"); got != "exit status 1" {
+		t.Errorf("short error = %q", got)
+	}
+
+	// The cut falls into the two bytes of ü
+	long := strings.Repeat("a", excerptLength-1) + "über"
+	got := excerpt(long)
+	if !utf8.ValidString(got) {
+		t.Errorf("excerpt splits a character: %q", got[len(got)-10:])
+	}
+	if want := strings.Repeat("a", excerptLength-1) + " [...]"; got != want {
+		t.Errorf("excerpt ends in %q, want %q", got[len(got)-10:], want[len(want)-10:])
+	}
+}
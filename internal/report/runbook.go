@@ -0,0 +1,156 @@
+package report
+
+import (
+	"bytes"
+	htmltemplate "html/template"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Entry is a named value of a runbook section
+type Entry struct {
+	Name  string
+	Value string
+}
+
+// Node is a node of the cluster as reported by the API server
+type Node struct {
+	Name             string
+	Roles            string
+	KubeletVersion   string
+	ContainerRuntime string
+	OS               string
+}
+
+// Runbook is the handover document of a provisioned cluster
+type Runbook struct {
+	Cluster     string
+	Host        string
+	Generated   time.Time
+	Components  []Entry
+	Nodes       []Node
+	Endpoints   []Entry
+	Credentials []Entry
+	Backups     []Entry
+	Steps       []Step
+}
+
+var markdownRunbook = template.Must(template.New("runbook").Funcs(template.FuncMap{"cell": cell}).Parse(`# Runbook: {{ .Cluster }}
+
+Control plane {{ .Host }}, generated by k8s-setup on {{ .Generated.Format "2006-01-02 15:04 MST" }}.
+
+## Components
+
+| Component | Version |
+|-----------|---------|
+{{ range .Components }}| {{ cell .Name }} | {{ cell .Value }} |
+{{ end }}
+## Nodes
+
+| Node | Roles | Kubelet | Runtime | OS |
+|------|-------|---------|---------|----|
+{{ range .Nodes }}| {{ cell .Name }} | {{ cell .Roles }} | {{ cell .KubeletVersion }} | {{ cell .ContainerRuntime }} | {{ cell .OS }} |
+{{ end }}
+## Endpoints
+
+{{ range .Endpoints }}- {{ .Name }}: {{ .Value }}
+{{ end }}
+## Credentials
+
+{{ range .Credentials }}- {{ .Name }}: {{ .Value }}
+{{ end }}
+## Backups
+
+{{ range .Backups }}- {{ .Name }}: {{ .Value }}
+{{ end }}
+## Executed Steps
+
+| Step | Duration |
+|------|----------|
+{{ range .Steps }}| {{ cell .Name }} | {{ .Duration }} |
+{{ end }}{{ range .Steps }}{{ if .Commands }}
+### {{ .Name }}
+
+~~~
+{{ range .Commands }}{{ . }}
+{{ end }}~~~
+{{ end }}{{ end }}`))
+
+var htmlRunbook = htmltemplate.Must(htmltemplate.New("runbook").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Runbook: {{ .Cluster }}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; }
+th, td { border: 1px solid #ccc; padding: 0.3em 0.6em; text-align: left; }
+</style>
+</head>
+<body>
+<h1>Runbook: {{ .Cluster }}</h1>
+<p>Control plane {{ .Host }}, generated by k8s-setup on {{ .Generated.Format "2006-01-02 15:04 MST" }}.</p>
+<h2>Components</h2>
+<table>
+<tr><th>Component</th><th>Version</th></tr>
+{{ range .Components }}<tr><td>{{ .Name }}</td><td>{{ .Value }}</td></tr>
+{{ end }}</table>
+<h2>Nodes</h2>
+<table>
+<tr><th>Node</th><th>Roles</th><th>Kubelet</th><th>Runtime</th><th>OS</th></tr>
+{{ range .Nodes }}<tr><td>{{ .Name }}</td><td>{{ .Roles }}</td><td>{{ .KubeletVersion }}</td><td>{{ .ContainerRuntime }}</td><td>{{ .OS }}</td></tr>
+{{ end }}</table>
+<h2>Endpoints</h2>
+<ul>
+{{ range .Endpoints }}<li>{{ .Name }}: {{ .Value }}</li>
+{{ end }}</ul>
+<h2>Credentials</h2>
+<ul>
+{{ range .Credentials }}<li>{{ .Name }}: {{ .Value }}</li>
+{{ end }}</ul>
+<h2>Backups</h2>
+<ul>
+{{ range .Backups }}<li>{{ .Name }}: {{ .Value }}</li>
+{{ end }}</ul>
+<h2>Executed Steps</h2>
+<table>
+<tr><th>Step</th><th>Duration</th></tr>
+{{ range .Steps }}<tr><td>{{ .Name }}</td><td>{{ .Duration }}</td></tr>
+{{ end }}</table>
+{{ range .Steps }}{{ if .Commands }}<h3>{{ .Name }}</h3>
+<pre>{{ range .Commands }}{{ . }}
+{{ end }}</pre>
+{{ end }}{{ end }}</body>
+</html>
+`))
+
+// Markdown renders the runbook as Markdown
+func (r *Runbook) Markdown() (string, error) {
+	var b bytes.Buffer
+	if err := markdownRunbook.Execute(&b, r); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// HTML renders the runbook as a standalone HTML page
+func (r *Runbook) HTML() (string, error) {
+	var b bytes.Buffer
+	if err := htmlRunbook.Execute(&b, r); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// cellReplacer escapes the pipes and HTML tags of a Markdown table cell and
+// joins its lines, a line break would end the row
+var cellReplacer = strings.NewReplacer("|", `\|`, "<", "&lt;", "\r\n", " ", "\n", " ", "\r", " ")
+
+// cell renders s as a Markdown table cell, "-" when it is empty
+func cell(s string) string {
+	if s = strings.TrimSpace(s); s == "" {
+		return "-"
+	}
+	return cellReplacer.Replace(s)
+}
@@ -0,0 +1,75 @@
+package report
+
+import (
+	"testing"
+	"time"
+
+	"github.com/maarulav/k8s-setup/internal/testutil"
+)
+
+// testRunbook returns a runbook with values that need escaping in both
+// formats
+func testRunbook() *Runbook {
+	return &Runbook{
+		Cluster:   "prod",
+		Host:      "10.0.0.1",
+		Generated: time.Date(2024, 5, 1, 12, 30, 0, 0, time.UTC),
+		Components: []Entry{
+			{Name: "Kubernetes", Value: "1.30.4"},
+			{Name: "monitoring/kube-prometheus-stack (kube-prometheus-stack)", Value: "58.2.1"},
+			{Name: "default/app (a|b)", Value: ""},
+		},
+		Nodes: []Node{
+			{Name: "cp-1", Roles: "control-plane", KubeletVersion: "v1.30.4", ContainerRuntime: "containerd://1.7.13", OS: "Ubuntu 22.04.4 LTS"},
+			{Name: "<script>alert(1)</script>", Roles: "", KubeletVersion: "v1.30.4", ContainerRuntime: "containerd://1.7.13", OS: "Ubuntu\n22.04"},
+		},
+		Endpoints: []Entry{
+			{Name: "API server", Value: "https://10.0.0.1:6443"},
+			{Name: "Grafana", Value: "http://10.0.0.1:30300/?orgId=1&refresh=5s"},
+		},
+		Credentials: []Entry{
+			{Name: "Kubeconfig", Value: "status/10.0.0.1/kubeconfig"},
+		},
+		Backups: []Entry{
+			{Name: "Latest", Value: "/var/backups/k8s-setup/20240501-120000.tar.gz"},
+		},
+		Steps: []Step{
+			{Name: "prepare", Duration: 42 * time.Second, Commands: []string{"apt-get update", "echo '<b>' > /etc/motd"}},
+			{Name: "kubeadm init", Duration: 2*time.Minute + 5*time.Second},
+		},
+	}
+}
+
+func TestRunbookMarkdown(t *testing.T) {
+	got, err := testRunbook().Markdown()
+	if err != nil {
+		t.Fatal(err)
+	}
+	testutil.AssertGolden(t, "runbook-markdown", got)
+}
+
+func TestRunbookHTML(t *testing.T) {
+	got, err := testRunbook().HTML()
+	if err != nil {
+		t.Fatal(err)
+	}
+	testutil.AssertGolden(t, "runbook-html", got)
+}
+
+func TestCell(t *testing.T) {
+	for in, want := range map[string]string{
+		"":                   "-",
+		"  ":                 "-",
+		"v1.30.4":            "v1.30.4",
+		"a|b":                `a\|b`,
+		"||":                 `\|\|`,
+		"Ubuntu\n22.04":      "Ubuntu 22.04",
+		"line\r\nbreak\rend": "line break end",
+		" padded\n":          "padded",
+		"<b>bold</b>":        "&lt;b>bold&lt;/b>",
+	} {
+		if got := cell(in); got != want {
+			t.Errorf("cell(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,239 @@
+package selfupdate
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultEndpoint is the GitHub API URL of the latest release
+const DefaultEndpoint = "https://api.github.com/repos/maarulav/k8s-setup/releases/latest"
+
+// Release is the subset of the GitHub release payload used for updates
+type Release struct {
+	Tag    string  `json:"tag_name"`
+	Assets []Asset `json:"assets"`
+}
+
+// Asset is a downloadable file attached to a release
+type Asset struct {
+	Name string `json:"name"`
+	URL  string `json:"browser_download_url"`
+}
+
+var httpClient = &http.Client{Timeout: 5 * time.Minute}
+
+// Latest fetches the latest release description from endpoint
+func Latest(endpoint string) (*Release, error) {
+	data, err := fetch(endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	var release Release
+	if err := json.Unmarshal(data, &release); err != nil {
+		return nil, fmt.Errorf("failed to parse release: %v", err)
+	}
+	return &release, nil
+}
+
+// BinaryName is the asset name of the binary for the running platform
+func BinaryName() string {
+	return fmt.Sprintf("k8s-setup_%s_%s", runtime.GOOS, runtime.GOARCH)
+}
+
+// Asset returns the asset with the given name
+func (r *Release) Asset(name string) (Asset, bool) {
+	for _, a := range r.Assets {
+		if a.Name == name {
+			return a, true
+		}
+	}
+	return Asset{}, false
+}
+
+// Newer reports whether the release is newer than current. Development
+// builds are never considered up to date.
+func (r *Release) Newer(current string) bool {
+	if current == "" || current == "dev" {
+		return true
+	}
+	return compareVersions(r.Tag, current) > 0
+}
+
+// Download fetches the binary for the running platform and verifies it
+// against the release checksums. When publicKey is set, the checksums file
+// must carry a valid ed25519 signature made with it.
+func (r *Release) Download(publicKey string) ([]byte, error) {
+	binary, ok := r.Asset(BinaryName())
+	if !ok {
+		return nil, fmt.Errorf("release %s has no binary for %s/%s", r.Tag, runtime.GOOS, runtime.GOARCH)
+	}
+	sums, ok := r.Asset("checksums.txt")
+	if !ok {
+		return nil, fmt.Errorf("release %s has no checksums.txt", r.Tag)
+	}
+
+	checksums, err := fetch(sums.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	if publicKey != "" {
+		sig, ok := r.Asset("checksums.txt.sig")
+		if !ok {
+			return nil, fmt.Errorf("release %s is not signed", r.Tag)
+		}
+		signature, err := fetch(sig.URL)
+		if err != nil {
+			return nil, err
+		}
+		if err := verifySignature(publicKey, checksums, signature); err != nil {
+			return nil, err
+		}
+	}
+
+	expected, err := checksumFor(checksums, binary.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := fetch(binary.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	sum := sha256.Sum256(data)
+	if hex.EncodeToString(sum[:]) != expected {
+		return nil, fmt.Errorf("checksum mismatch for %s", binary.Name)
+	}
+
+	return data, nil
+}
+
+// Replace atomically swaps the running executable for data
+func Replace(data []byte) (string, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return "", fmt.Errorf("failed to locate executable: %v", err)
+	}
+	exe, err = filepath.EvalSymlinks(exe)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve executable: %v", err)
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(exe), ".k8s-setup-update-")
+	if err != nil {
+		return "", fmt.Errorf("failed to create temporary file: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return "", fmt.Errorf("failed to write update: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+	if err := os.Chmod(tmp.Name(), 0755); err != nil {
+		return "", err
+	}
+
+	if err := os.Rename(tmp.Name(), exe); err != nil {
+		return "", fmt.Errorf("failed to replace %s: %v", exe, err)
+	}
+	return exe, nil
+}
+
+func verifySignature(publicKey string, message, signature []byte) error {
+	key, err := base64.StdEncoding.DecodeString(publicKey)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return fmt.Errorf("invalid update public key")
+	}
+
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(signature)))
+	if err != nil {
+		// Accept raw signatures as well as base64 encoded ones
+		sig = signature
+	}
+
+	if !ed25519.Verify(ed25519.PublicKey(key), message, sig) {
+		return fmt.Errorf("invalid signature on checksums.txt")
+	}
+	return nil
+}
+
+// checksumFor finds the sha256 of name in a sha256sum style file
+func checksumFor(checksums []byte, name string) (string, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(checksums))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && strings.TrimPrefix(fields[1], "*") == name {
+			return strings.ToLower(fields[0]), nil
+		}
+	}
+	return "", fmt.Errorf("no checksum for %s", name)
+}
+
+func fetch(url string) ([]byte, error) {
+	resp, err := httpClient.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch %s: %v", url, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch %s: %s", url, resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// compareVersions compares dotted versions such as v1.2.3, ignoring any
+// pre-release suffix
+func compareVersions(a, b string) int {
+	pa, pb := versionParts(a), versionParts(b)
+	for i := 0; i < len(pa) || i < len(pb); i++ {
+		var x, y int
+		if i < len(pa) {
+			x = pa[i]
+		}
+		if i < len(pb) {
+			y = pb[i]
+		}
+		if x != y {
+			if x > y {
+				return 1
+			}
+			return -1
+		}
+	}
+	return 0
+}
+
+func versionParts(v string) []int {
+	v = strings.TrimPrefix(v, "v")
+	if i := strings.IndexAny(v, "-+"); i >= 0 {
+		v = v[:i]
+	}
+
+	var parts []int
+	for _, p := range strings.Split(v, ".") {
+		n, _ := strconv.Atoi(p)
+		parts = append(parts, n)
+	}
+	return parts
+}
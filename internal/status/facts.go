@@ -0,0 +1,25 @@
+package status
+
+import "time"
+
+// Facts describe a host for audits and upgrade planning
+type Facts struct {
+	Collected time.Time `json:"collected"`
+	// OS is the pretty name of the distribution, OSID and OSVersion its
+	// ID and VERSION_ID in os-release
+	OS        string `json:"os"`
+	OSID      string `json:"osID"`
+	OSVersion string `json:"osVersion"`
+	Kernel    string `json:"kernel"`
+	Arch      string `json:"arch"`
+	CPUs      int    `json:"cpus"`
+	// MemoryBytes is the physical memory, DiskBytes and DiskFreeBytes the
+	// size and free space of the root file system
+	MemoryBytes   int64 `json:"memoryBytes"`
+	DiskBytes     int64 `json:"diskBytes"`
+	DiskFreeBytes int64 `json:"diskFreeBytes"`
+	// ContainerRuntime and Kubelet are the installed versions, empty when
+	// they are not installed
+	ContainerRuntime string `json:"containerRuntime,omitempty"`
+	Kubelet          string `json:"kubelet,omitempty"`
+}
@@ -0,0 +1,195 @@
+package status
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path/filepath"
+	"time"
+)
+
+// Lock keeps other operators from mutating a host while an operation runs.
+// It is a file next to the status file and expires after its TTL, so the
+// lock of a crashed run does not block the host forever.
+type Lock struct {
+	Host      string    `json:"host"`
+	Owner     string    `json:"owner"`
+	Operation string    `json:"operation"`
+	Acquired  time.Time `json:"acquired"`
+	Expires   time.Time `json:"expires"`
+}
+
+// LockPath returns where the lock of the VM at ip is stored
+func LockPath(ip string) string {
+	return filepath.Join(Dir, fmt.Sprintf("%s.lock", ip))
+}
+
+// takeoverTimeout is how long a takeover guard is honoured; a process that
+// crashes while breaking a lock leaves its guard behind
+const takeoverTimeout = time.Minute
+
+// AcquireLock locks the VM at ip for operation. A lock held by someone else
+// is an error until it expires, unless force breaks it.
+func AcquireLock(ip, operation string, ttl time.Duration, force bool) (*Lock, error) {
+	if err := os.MkdirAll(Dir, 0755); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	lock := &Lock{
+		Host:      ip,
+		Owner:     owner(),
+		Operation: operation,
+		Acquired:  now,
+		Expires:   now.Add(ttl),
+	}
+	data, err := json.MarshalIndent(lock, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	// The lock is written aside and linked into place, which fails when the
+	// lock exists, so nobody reads a lock half written
+	tmp, err := writeTemp(ip, data)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp)
+
+	for {
+		err := os.Link(tmp, LockPath(ip))
+		if err == nil {
+			return lock, nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+
+		held, err := readLock(ip)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err == nil && now.Before(held.Expires) && !force {
+			return nil, fmt.Errorf("%s is locked by %s for %s since %s until %s, pass --force-unlock if the lock is stale",
+				ip, held.Owner, held.Operation, held.Acquired.Format(time.RFC1123), held.Expires.Format(time.RFC1123))
+		}
+		taken, err := takeOver(ip, held, tmp)
+		if err != nil {
+			return nil, err
+		}
+		if taken {
+			return lock, nil
+		}
+		// Someone else is breaking or took the lock meanwhile, look again
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// takeOver replaces the lock of ip with the file tmp, provided it still is
+// stale, the lock read as stale before. The replacement is a rename, so the
+// lock file exists throughout and no link can slip in, and the guard keeps a
+// second process from replacing the lock it just took.
+func takeOver(ip string, stale *Lock, tmp string) (bool, error) {
+	release, err := guard(ip)
+	if release == nil || err != nil {
+		return false, err
+	}
+	defer release()
+
+	current, err := readLock(ip)
+	if os.IsNotExist(err) || !sameLock(current, stale) {
+		return false, nil
+	}
+	if err := os.Rename(tmp, LockPath(ip)); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// writeTemp writes data to a file next to the lock of ip that no other
+// process or acquisition uses
+func writeTemp(ip string, data []byte) (string, error) {
+	path := fmt.Sprintf("%s.%d.%d", LockPath(ip), os.Getpid(), time.Now().UnixNano())
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	if _, err := file.Write(data); err != nil {
+		os.Remove(path)
+		return "", err
+	}
+	return path, nil
+}
+
+// guard takes the takeover guard of the lock of ip, exclusively created
+// next to it. It returns the function releasing the guard, or nil when
+// another process holds it.
+func guard(ip string) (func(), error) {
+	path := LockPath(ip) + ".takeover"
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err == nil {
+		file.Close()
+		return func() { os.Remove(path) }, nil
+	}
+	if !os.IsExist(err) {
+		return nil, err
+	}
+	if info, err := os.Stat(path); err == nil && time.Since(info.ModTime()) > takeoverTimeout {
+		os.Remove(path)
+	}
+	return nil, nil
+}
+
+// sameLock reports whether a and b are the same acquisition, both nil for
+// lock files that do not parse
+func sameLock(a, b *Lock) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return a.Owner == b.Owner && a.Acquired.Equal(b.Acquired)
+}
+
+// Release removes the lock, unless it was broken and taken over meanwhile
+func (l *Lock) Release() error {
+	for start := time.Now(); time.Since(start) < takeoverTimeout; time.Sleep(100 * time.Millisecond) {
+		release, err := guard(l.Host)
+		if err != nil {
+			return err
+		}
+		if release == nil {
+			continue
+		}
+		defer release()
+		held, err := readLock(l.Host)
+		if err != nil || !sameLock(held, l) {
+			return nil
+		}
+		return os.Remove(LockPath(l.Host))
+	}
+	return fmt.Errorf("timed out releasing the lock of %s", l.Host)
+}
+
+func readLock(ip string) (*Lock, error) {
+	data, err := ioutil.ReadFile(LockPath(ip))
+	if err != nil {
+		return nil, err
+	}
+	var l Lock
+	if err := json.Unmarshal(data, &l); err != nil {
+		return nil, fmt.Errorf("failed to parse lock: %v", err)
+	}
+	return &l, nil
+}
+
+// owner identifies the operator as user@host and the process holding a lock
+func owner() string {
+	name := "unknown"
+	if u, err := user.Current(); err == nil {
+		name = u.Username
+	}
+	hostname, _ := os.Hostname()
+	return fmt.Sprintf("%s@%s (pid %d)", name, hostname, os.Getpid())
+}
@@ -0,0 +1,108 @@
+package status
+
+import (
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestAcquireLock(t *testing.T) {
+	chdir(t)
+
+	lock, err := AcquireLock("10.0.0.1", "apply", time.Hour, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := AcquireLock("10.0.0.1", "upgrade", time.Hour, false); err == nil {
+		t.Fatal("a held lock was acquired again")
+	}
+
+	// Breaking the lock takes it over, the previous holder then leaves it
+	broken, err := AcquireLock("10.0.0.1", "upgrade", time.Hour, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := lock.Release(); err != nil {
+		t.Fatal(err)
+	}
+	if held, err := readLock("10.0.0.1"); err != nil || !sameLock(held, broken) {
+		t.Fatalf("lock = %+v, %v, want the one taken over", held, err)
+	}
+	if err := broken.Release(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(LockPath("10.0.0.1")); !os.IsNotExist(err) {
+		t.Errorf("released lock still exists: %v", err)
+	}
+	if entries, _ := os.ReadDir(Dir); len(entries) != 0 {
+		t.Errorf("files left behind: %v", entries)
+	}
+}
+
+func TestAcquireLockStale(t *testing.T) {
+	chdir(t)
+
+	if _, err := AcquireLock("10.0.0.1", "apply", -time.Second, false); err != nil {
+		t.Fatal(err)
+	}
+
+	// Of the processes finding the lock expired, exactly one takes it over
+	const contenders = 8
+	var wg sync.WaitGroup
+	locks := make(chan *Lock, contenders)
+	for i := 0; i < contenders; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if l, err := AcquireLock("10.0.0.1", "apply", time.Hour, false); err == nil {
+				locks <- l
+			}
+		}()
+	}
+	wg.Wait()
+	close(locks)
+
+	var winners []*Lock
+	for l := range locks {
+		winners = append(winners, l)
+	}
+	if len(winners) != 1 {
+		t.Fatalf("%d processes hold the lock, want 1", len(winners))
+	}
+	if held, err := readLock("10.0.0.1"); err != nil || !sameLock(held, winners[0]) {
+		t.Errorf("lock = %+v, %v, want the winner's", held, err)
+	}
+}
+
+func TestAcquireLockStaleGuard(t *testing.T) {
+	chdir(t)
+
+	if _, err := AcquireLock("10.0.0.1", "apply", -time.Second, false); err != nil {
+		t.Fatal(err)
+	}
+	// A process that crashed while breaking the lock left its guard
+	guardPath := LockPath("10.0.0.1") + ".takeover"
+	if err := os.WriteFile(guardPath, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-2 * takeoverTimeout)
+	os.Chtimes(guardPath, old, old)
+
+	if _, err := AcquireLock("10.0.0.1", "apply", time.Hour, false); err != nil {
+		t.Fatalf("the stale guard blocked the takeover: %v", err)
+	}
+}
+
+// chdir runs the test in an empty directory, where the status store starts
+// out empty
+func chdir(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+}
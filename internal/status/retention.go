@@ -0,0 +1,112 @@
+package status
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// Prune drops the operations older than maxAge or beyond the newest maxCount
+// from every saved status, and removes the entries of hosts whose last run
+// did not complete and started before maxAge, unless they belong to a live
+// cluster, see live. A zero maxAge or maxCount disables that limit. It
+// returns the removed entries.
+func Prune(maxAge time.Duration, maxCount int, now time.Time) ([]string, error) {
+	statuses, err := List()
+	if err != nil {
+		return nil, err
+	}
+
+	var removed []string
+	for _, s := range statuses {
+		if maxAge > 0 && !s.live() && s.StartTime.Before(now.Add(-maxAge)) {
+			if err := Remove(s.VMIP); err != nil {
+				return removed, err
+			}
+			removed = append(removed, filepath.Join(Dir, s.VMIP+".json"))
+			continue
+		}
+
+		operations := pruneOperations(s.Operations, maxAge, maxCount, now)
+		if len(operations) != len(s.Operations) {
+			s.Operations = operations
+			if err := s.Save(); err != nil {
+				return removed, err
+			}
+		}
+	}
+
+	return removed, nil
+}
+
+// live reports whether the entry may belong to a cluster that is still
+// running: the run completed, or it got as far as the kubeconfig or the join
+// secrets, which are kept until the cluster is torn down, e.g. by local down
+func (s *SetupStatus) live() bool {
+	if s.Status == "Completed" || s.Kubeconfig != "" || s.JoinCommand != nil || s.CertificateKey != nil {
+		return true
+	}
+	_, err := os.Stat(s.KubeconfigPath())
+	return err == nil
+}
+
+// pruneOperations keeps the operations newer than maxAge, at most the last
+// maxCount of them
+func pruneOperations(operations []Operation, maxAge time.Duration, maxCount int, now time.Time) []Operation {
+	var kept []Operation
+	for _, op := range operations {
+		if maxAge == 0 || !op.StartTime.Before(now.Add(-maxAge)) {
+			kept = append(kept, op)
+		}
+	}
+	if maxCount > 0 && len(kept) > maxCount {
+		kept = kept[len(kept)-maxCount:]
+	}
+	return kept
+}
+
+// Remove deletes the status file, kubeconfig and work directory of the VM at ip
+func Remove(ip string) error {
+	for _, path := range []string{filepath.Join(Dir, ip+".json"), KubeconfigPath(ip)} {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return os.RemoveAll(filepath.Join(Dir, ip))
+}
+
+// PruneFiles removes the files matching pattern that were modified before
+// maxAge or are beyond the newest maxCount of them, and returns their paths
+func PruneFiles(pattern string, maxAge time.Duration, maxCount int, now time.Time) ([]string, error) {
+	paths, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	type file struct {
+		path    string
+		modTime time.Time
+	}
+	var files []file
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil || info.IsDir() {
+			continue
+		}
+		files = append(files, file{path, info.ModTime()})
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].modTime.After(files[j].modTime) })
+
+	var removed []string
+	for i, f := range files {
+		if (maxAge == 0 || !f.modTime.Before(now.Add(-maxAge))) && (maxCount == 0 || i < maxCount) {
+			continue
+		}
+		if err := os.Remove(f.path); err != nil {
+			return removed, err
+		}
+		removed = append(removed, f.path)
+	}
+	return removed, nil
+}
@@ -0,0 +1,111 @@
+package status
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestPrune(t *testing.T) {
+	chdir(t)
+	if err := os.MkdirAll(Dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	old := now.Add(-48 * time.Hour)
+
+	save := func(ip, result string, edit func(s *SetupStatus)) {
+		s := New(ip)
+		s.StartTime, s.Status = old, result
+		if edit != nil {
+			edit(s)
+		}
+		if err := s.Save(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	save("10.0.0.1", "Failed", nil)
+	save("10.0.0.2", "Completed", nil)
+	// Failed late, after the cluster came up
+	save("10.0.0.3", "Failed", func(s *SetupStatus) { s.Kubeconfig = s.KubeconfigPath() })
+	save("10.0.0.4", "Failed", func(s *SetupStatus) { s.JoinCommand = &Secret{} })
+	save("10.0.0.5", "Failed", func(s *SetupStatus) { os.WriteFile(s.KubeconfigPath(), nil, 0600) })
+	save("10.0.0.6", "Failed", func(s *SetupStatus) { s.StartTime = now })
+
+	removed, err := Prune(24*time.Hour, 0, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != 1 || removed[0] != "status/10.0.0.1.json" {
+		t.Errorf("removed = %v, want only the failed entry without a cluster", removed)
+	}
+	for _, ip := range []string{"10.0.0.2", "10.0.0.3", "10.0.0.4", "10.0.0.5", "10.0.0.6"} {
+		if _, err := Load(ip); err != nil {
+			t.Errorf("entry of %s was pruned: %v", ip, err)
+		}
+	}
+}
+
+func TestPruneFiles(t *testing.T) {
+	now := time.Now()
+	// Newest first, by the hours since they were modified
+	ages := map[string]int{"run-1.log": 1, "run-2.log": 10, "run-3.log": 30, "run-4.log": 50}
+
+	for _, tc := range []struct {
+		name     string
+		maxAge   time.Duration
+		maxCount int
+		removed  []string
+	}{
+		{"no limits", 0, 0, nil},
+		{"age", 24 * time.Hour, 0, []string{"run-3.log", "run-4.log"}},
+		{"count", 0, 1, []string{"run-2.log", "run-3.log", "run-4.log"}},
+		{"count above the files", 0, 10, nil},
+		// Either limit removes a file
+		{"age and count", 40 * time.Hour, 2, []string{"run-3.log", "run-4.log"}},
+		{"count within age", 24 * time.Hour, 1, []string{"run-2.log", "run-3.log", "run-4.log"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for name, hours := range ages {
+				path := filepath.Join(dir, name)
+				if err := os.WriteFile(path, nil, 0600); err != nil {
+					t.Fatal(err)
+				}
+				modTime := now.Add(-time.Duration(hours) * time.Hour)
+				if err := os.Chtimes(path, modTime, modTime); err != nil {
+					t.Fatal(err)
+				}
+			}
+			// Neither directories nor other files match
+			os.Mkdir(filepath.Join(dir, "old.log"), 0700)
+			os.WriteFile(filepath.Join(dir, "run.json"), nil, 0600)
+
+			removed, err := PruneFiles(filepath.Join(dir, "*.log"), tc.maxAge, tc.maxCount, now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var names []string
+			for _, path := range removed {
+				names = append(names, filepath.Base(path))
+				if _, err := os.Stat(path); !os.IsNotExist(err) {
+					t.Errorf("%s was reported removed but exists", path)
+				}
+			}
+			sort.Strings(names)
+			if !reflect.DeepEqual(names, tc.removed) {
+				t.Errorf("removed %v, want %v", names, tc.removed)
+			}
+			if _, err := os.Stat(filepath.Join(dir, "old.log")); err != nil {
+				t.Errorf("a directory matching the pattern was removed: %v", err)
+			}
+		})
+	}
+
+	if _, err := PruneFiles("[", time.Hour, 0, now); err == nil {
+		t.Error("an invalid pattern was accepted")
+	}
+}
@@ -0,0 +1,106 @@
+package status
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// KeyEnv holds the passphrase secrets in status files are encrypted with
+const KeyEnv = "K8S_SETUP_STATUS_KEY"
+
+// expiryMargin is how long before its expiry a secret is no longer handed out
+const expiryMargin = 10 * time.Minute
+
+// saltSize is the size of the random salt the key of each secret is derived
+// with
+const saltSize = 16
+
+// Secret is a value kept encrypted in the status file together with its
+// expiry and the salt its key was derived with
+type Secret struct {
+	Sealed  string    `json:"sealed"`
+	Salt    string    `json:"salt"`
+	Expires time.Time `json:"expires"`
+}
+
+// Valid reports whether the secret is set and does not expire soon
+func (s *Secret) Valid() bool {
+	return s != nil && s.Sealed != "" && time.Now().Add(expiryMargin).Before(s.Expires)
+}
+
+// Seal encrypts value with the key from KeyEnv
+func Seal(value string, expires time.Time) (*Secret, error) {
+	salt := make([]byte, saltSize)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, err
+	}
+	gcm, err := statusCipher(salt)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	sealed := gcm.Seal(nonce, nonce, []byte(value), nil)
+	return &Secret{
+		Sealed:  base64.StdEncoding.EncodeToString(sealed),
+		Salt:    base64.StdEncoding.EncodeToString(salt),
+		Expires: expires,
+	}, nil
+}
+
+// Open decrypts the secret with the key from KeyEnv
+func (s *Secret) Open() (string, error) {
+	// Secrets stored before keys were salted have no salt and are created
+	// again
+	salt, err := base64.StdEncoding.DecodeString(s.Salt)
+	if err != nil || len(salt) != saltSize {
+		return "", fmt.Errorf("malformed secret in status file")
+	}
+	gcm, err := statusCipher(salt)
+	if err != nil {
+		return "", err
+	}
+
+	data, err := base64.StdEncoding.DecodeString(s.Sealed)
+	if err != nil || len(data) < gcm.NonceSize() {
+		return "", fmt.Errorf("malformed secret in status file")
+	}
+
+	value, err := gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt secret, is %s the key it was stored with? %v", KeyEnv, err)
+	}
+	return string(value), nil
+}
+
+// statusCipher derives the AES-256-GCM cipher from the passphrase in KeyEnv
+// and salt with scrypt
+func statusCipher(salt []byte) (cipher.AEAD, error) {
+	passphrase := os.Getenv(KeyEnv)
+	if passphrase == "" {
+		return nil, fmt.Errorf("%s is not set, secrets are not stored in status files without it", KeyEnv)
+	}
+
+	// The cost parameters recommended for interactive logins
+	key, err := scrypt.Key([]byte(passphrase), salt, 1<<15, 8, 1, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,67 @@
+package status
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSecret(t *testing.T) {
+	t.Setenv(KeyEnv, "correct horse battery staple")
+	expires := time.Now().Add(time.Hour)
+
+	a, err := Seal("kubeadm join 10.0.0.1:6443 --token abcdef.0123456789abcdef", expires)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value, err := a.Open(); err != nil || value != "kubeadm join 10.0.0.1:6443 --token abcdef.0123456789abcdef" {
+		t.Errorf("Open = %q, %v", value, err)
+	}
+	if !a.Valid() {
+		t.Error("a secret expiring in an hour is not valid")
+	}
+
+	// Every secret gets its own salt
+	b, err := Seal("kubeadm join 10.0.0.1:6443 --token abcdef.0123456789abcdef", expires)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Salt == b.Salt || a.Sealed == b.Sealed {
+		t.Error("two secrets share their salt")
+	}
+
+	t.Setenv(KeyEnv, "wrong")
+	if _, err := a.Open(); err == nil || !strings.Contains(err.Error(), KeyEnv) {
+		t.Errorf("got %v, want a failure with the wrong key", err)
+	}
+
+	t.Setenv(KeyEnv, "")
+	if _, err := Seal("value", expires); err == nil {
+		t.Error("expected sealing without a key to fail")
+	}
+}
+
+func TestSecretUnsalted(t *testing.T) {
+	t.Setenv(KeyEnv, "correct horse battery staple")
+	s, err := Seal("value", time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Salt = ""
+	if _, err := s.Open(); err == nil {
+		t.Error("expected a secret without salt to be refused")
+	}
+}
+
+func TestSecretValid(t *testing.T) {
+	var missing *Secret
+	for _, s := range []*Secret{
+		missing,
+		{Expires: time.Now().Add(time.Hour)},
+		{Sealed: "x", Salt: "y", Expires: time.Now().Add(5 * time.Minute)},
+	} {
+		if s.Valid() {
+			t.Errorf("%+v is valid", s)
+		}
+	}
+}
@@ -0,0 +1,199 @@
+package status
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Dir is the directory holding the status files
+const Dir = "status"
+
+// SetupStatus tracks the progress of setup
+type SetupStatus struct {
+	VMIP           string    `json:"vmIP"`
+	StartTime      time.Time `json:"startTime"`
+	EndTime        time.Time `json:"endTime"`
+	CurrentStep    string    `json:"currentStep"`
+	Status         string    `json:"status"`
+	Error          string    `json:"error,omitempty"`
+	CompletedSteps []string  `json:"completedSteps"`
+	Adopted        bool      `json:"adopted,omitempty"`
+	Kubeconfig     string    `json:"kubeconfig,omitempty"`
+	Context        string    `json:"context,omitempty"`
+
+	// ClusterName is the name k8s-setup rename last gave the cluster
+	ClusterName string `json:"clusterName,omitempty"`
+
+	// JoinCommand is the kubeadm join command for workers and
+	// CertificateKey the key control plane nodes need on top of it
+	JoinCommand    *Secret `json:"joinCommand,omitempty"`
+	CertificateKey *Secret `json:"certificateKey,omitempty"`
+
+	// Arch is the architecture detected on the VM, amd64 or arm64
+	Arch string `json:"arch,omitempty"`
+
+	// Facts describe the VM as of the pre-flight checks or, once it ran,
+	// the verification of the last run
+	Facts *Facts `json:"facts,omitempty"`
+
+	// SecurityReport is the local file holding the findings of the security scan
+	SecurityReport string `json:"securityReport,omitempty"`
+
+	// GrafanaURL is where Grafana is exposed, when it has a domain
+	GrafanaURL string `json:"grafanaURL,omitempty"`
+
+	// Credentials is the local file holding the credentials summary
+	Credentials string `json:"credentials,omitempty"`
+
+	// Runbook is the local file holding the handover document of the cluster
+	Runbook string `json:"runbook,omitempty"`
+
+	// SealedSecretsCert is the local copy of the sealed-secrets public certificate
+	SealedSecretsCert string `json:"sealedSecretsCert,omitempty"`
+
+	// ConfigHash is the hash of the configuration the VM was provisioned with
+	ConfigHash string `json:"configHash,omitempty"`
+
+	// StepCache holds the key each cached Kubernetes setup step last
+	// succeeded with, see kubernetes.StepCache
+	StepCache map[string]string `json:"stepCache,omitempty"`
+
+	// Verification is the outcome of the last verification of the cluster
+	Verification *Verification `json:"verification,omitempty"`
+
+	// CertExpiry is when the first control plane certificate expires, as
+	// checked by k8s-setup watch at CertChecked
+	CertExpiry  *time.Time `json:"certExpiry,omitempty"`
+	CertChecked *time.Time `json:"certChecked,omitempty"`
+
+	// LastBackup is when the backup step of a run last succeeded
+	LastBackup *time.Time `json:"lastBackup,omitempty"`
+
+	// Operations records the destructive operations run against the cluster
+	Operations []Operation `json:"operations,omitempty"`
+
+	// Maintenance lists the nodes drained with k8s-setup node drain that
+	// were not uncordoned since
+	Maintenance []Maintenance `json:"maintenance,omitempty"`
+}
+
+// Maintenance is a node taken out of service for maintenance. Error is set
+// when the drain failed, leaving the node cordoned with pods still on it.
+type Maintenance struct {
+	IP     string    `json:"ip"`
+	Node   string    `json:"node"`
+	Reason string    `json:"reason,omitempty"`
+	Since  time.Time `json:"since"`
+	Error  string    `json:"error,omitempty"`
+}
+
+// Verification is the outcome of the verification step of a run
+type Verification struct {
+	Time   time.Time `json:"time"`
+	Passed bool      `json:"passed"`
+	Error  string    `json:"error,omitempty"`
+}
+
+// Operation is a destructive operation and the backup taken before it
+type Operation struct {
+	Name      string    `json:"name"`
+	StartTime time.Time `json:"startTime"`
+	Backup    string    `json:"backup,omitempty"`
+}
+
+// Save saves the status to a JSON file
+func (s *SetupStatus) Save() error {
+	filename := filepath.Join(Dir, fmt.Sprintf("%s.json", s.VMIP))
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal status: %v", err)
+	}
+
+	return ioutil.WriteFile(filename, data, 0644)
+}
+
+// HasCompleted reports whether step is among the completed steps
+func (s *SetupStatus) HasCompleted(step string) bool {
+	for _, completed := range s.CompletedSteps {
+		if completed == step {
+			return true
+		}
+	}
+	return false
+}
+
+// LastRun returns when the last run or operation against the VM ended or
+// started, whichever is later
+func (s *SetupStatus) LastRun() time.Time {
+	last := s.EndTime
+	for _, op := range s.Operations {
+		if op.StartTime.After(last) {
+			last = op.StartTime
+		}
+	}
+	return last
+}
+
+// New creates a new SetupStatus instance
+func New(ip string) *SetupStatus {
+	return &SetupStatus{
+		VMIP:        ip,
+		StartTime:   time.Now(),
+		CurrentStep: "Initializing",
+		Status:      "In Progress",
+	}
+}
+
+// Load reads the saved status of the VM at ip
+func Load(ip string) (*SetupStatus, error) {
+	data, err := ioutil.ReadFile(filepath.Join(Dir, fmt.Sprintf("%s.json", ip)))
+	if err != nil {
+		return nil, err
+	}
+
+	var s SetupStatus
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("failed to parse status: %v", err)
+	}
+
+	return &s, nil
+}
+
+// List loads every saved status, ordered by VM IP
+func List() ([]*SetupStatus, error) {
+	files, err := filepath.Glob(filepath.Join(Dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	var statuses []*SetupStatus
+	for _, file := range files {
+		s, err := Load(strings.TrimSuffix(filepath.Base(file), ".json"))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", file, err)
+		}
+		statuses = append(statuses, s)
+	}
+
+	return statuses, nil
+}
+
+// KubeconfigPath returns where the admin kubeconfig of the VM at ip is stored
+func KubeconfigPath(ip string) string {
+	return filepath.Join(Dir, fmt.Sprintf("%s.kubeconfig", ip))
+}
+
+// KubeconfigPath returns where the admin kubeconfig of this VM is stored
+func (s *SetupStatus) KubeconfigPath() string {
+	return KubeconfigPath(s.VMIP)
+}
+
+// WorkDir returns the local working directory used when the cluster
+// operations of this VM run on the operator machine
+func (s *SetupStatus) WorkDir() string {
+	return filepath.Join(Dir, s.VMIP)
+}
@@ -0,0 +1,148 @@
+// Package testutil provides a fake executor and golden file helpers for
+// testing the setup steps without a host.
+package testutil
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strings"
+)
+
+// ErrNoCluster is returned by the fake for operations that need a real API server
+var ErrNoCluster = errors.New("fake executor has no cluster")
+
+// response is a canned reaction to commands containing match
+type response struct {
+	match  string
+	output string
+	err    error
+	after  int
+}
+
+// answer returns the first response matching command, or empty output
+func answer(responses []*response, command string) (string, error) {
+	for _, r := range responses {
+		if !strings.Contains(command, r.match) {
+			continue
+		}
+		if r.err != nil && r.after > 0 {
+			r.after--
+			continue
+		}
+		return r.output, r.err
+	}
+	return "", nil
+}
+
+// Executor is an ssh.Executor that records every command and file write. By
+// default commands succeed with empty output; Respond and Fail change that
+// for commands containing a substring.
+type Executor struct {
+	IP string
+
+	// Ops is the recorded plan in execution order
+	Ops []string
+
+	// Files holds the content of every written file by path
+	Files map[string][]byte
+
+	responses []*response
+}
+
+// NewExecutor returns a fake executor for the cluster at ip
+func NewExecutor(ip string) *Executor {
+	return &Executor{IP: ip, Files: map[string][]byte{}}
+}
+
+// Respond makes commands containing match return output
+func (e *Executor) Respond(match, output string) *Executor {
+	e.responses = append(e.responses, &response{match: match, output: output})
+	return e
+}
+
+// Fail makes commands containing match fail with output
+func (e *Executor) Fail(match, output string) *Executor {
+	return e.FailAfter(match, output, 0)
+}
+
+// FailAfter lets the first n commands containing match succeed and fails
+// the following ones, to inject failures into repeated steps
+func (e *Executor) FailAfter(match, output string, n int) *Executor {
+	e.responses = append(e.responses, failure(match, output, n))
+	return e
+}
+
+func failure(match, output string, after int) *response {
+	return &response{
+		match:  match,
+		output: output,
+		err:    fmt.Errorf("command failed: injected failure"),
+		after:  after,
+	}
+}
+
+// Host returns the IP of the fake cluster
+func (e *Executor) Host() string {
+	return e.IP
+}
+
+// ExecuteCommand records the command and returns the first matching response
+func (e *Executor) ExecuteCommand(command string) (string, error) {
+	e.Ops = append(e.Ops, "$ "+command)
+	return answer(e.responses, command)
+}
+
+// WriteFile records the file
+func (e *Executor) WriteFile(path string, data []byte, mode os.FileMode) error {
+	e.Ops = append(e.Ops, fmt.Sprintf("> %s (%o)", path, mode.Perm()))
+	e.Files[path] = append([]byte(nil), data...)
+	return nil
+}
+
+// Kubeconfig fails, the fake has no API server
+func (e *Executor) Kubeconfig() ([]byte, error) {
+	return nil, ErrNoCluster
+}
+
+// Dial fails, the fake has no API server
+func (e *Executor) Dial(network, addr string) (net.Conn, error) {
+	return nil, ErrNoCluster
+}
+
+// Commands returns the executed commands without the written files
+func (e *Executor) Commands() []string {
+	var commands []string
+	for _, op := range e.Ops {
+		if strings.HasPrefix(op, "$ ") {
+			commands = append(commands, strings.TrimPrefix(op, "$ "))
+		}
+	}
+	return commands
+}
+
+// Plan renders the recorded operations followed by the written files, the
+// format compared against golden files
+func (e *Executor) Plan() string {
+	var b strings.Builder
+	for _, op := range e.Ops {
+		b.WriteString(op)
+		b.WriteString("\n")
+	}
+
+	paths := make([]string, 0, len(e.Files))
+	for path := range e.Files {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		fmt.Fprintf(&b, "\n--- %s\n%s", path, e.Files[path])
+		if !strings.HasSuffix(string(e.Files[path]), "\n") {
+			b.WriteString("\n")
+		}
+	}
+
+	return b.String()
+}
@@ -0,0 +1,36 @@
+package testutil
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files")
+
+// AssertGolden compares got with testdata/<name>.golden. Run the tests with
+// -update to rewrite the file after an intended change.
+func AssertGolden(t *testing.T, name, got string) {
+	t.Helper()
+
+	path := filepath.Join("testdata", name+".golden")
+	if *update {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(got), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	want, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read golden file, run with -update to create it: %v", err)
+	}
+	if got != string(want) {
+		t.Errorf("%s does not match the golden file, run with -update after checking the change\n--- got\n%s\n--- want\n%s", path, got, want)
+	}
+}
@@ -0,0 +1,210 @@
+package testutil
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/maarulav/k8s-setup/pkg/config"
+	"golang.org/x/crypto/ssh"
+)
+
+// Test credentials accepted by the SSH server
+const (
+	SSHUser     = "test"
+	SSHPassword = "test"
+)
+
+// Exec is a command received by the SSH server together with its stdin
+type Exec struct {
+	Command string
+	Stdin   []byte
+}
+
+// SSHServer is an in-process SSH server for integration tests. It records
+// every exec request and answers with canned output, so the real SSH client
+// can be exercised without a host.
+type SSHServer struct {
+	listener net.Listener
+	config   *ssh.ServerConfig
+
+	mu         sync.Mutex
+	execs      []Exec
+	responses  []*response
+	authorized map[string]bool
+}
+
+// NewSSHServer starts a server on a loopback port that is closed when the
+// test ends
+func NewSSHServer(t *testing.T) *SSHServer {
+	t.Helper()
+
+	_, hostKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(hostKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &SSHServer{authorized: map[string]bool{}}
+	s.config = &ssh.ServerConfig{
+		PasswordCallback: func(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			if conn.User() == SSHUser && string(password) == SSHPassword {
+				return nil, nil
+			}
+			return nil, ssh.ErrNoAuth
+		},
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			if conn.User() == SSHUser && s.authorized[string(key.Marshal())] {
+				return nil, nil
+			}
+			return nil, ssh.ErrNoAuth
+		},
+	}
+	s.config.AddHostKey(signer)
+
+	s.listener, err = net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.listener.Close() })
+
+	go s.serve()
+	return s
+}
+
+// VMConfig returns the connection settings for the server
+func (s *SSHServer) VMConfig() config.VMConfig {
+	host, port, _ := net.SplitHostPort(s.listener.Addr().String())
+	p, _ := strconv.Atoi(port)
+	return config.VMConfig{
+		IP:       host,
+		Port:     p,
+		Username: SSHUser,
+		Password: SSHPassword,
+		Timeout:  5 * time.Second,
+		// Commands reach the server as the steps issue them
+		Shell: config.ShellLogin,
+	}
+}
+
+// Respond makes commands containing match return output
+func (s *SSHServer) Respond(match, output string) *SSHServer {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.responses = append(s.responses, &response{match: match, output: output})
+	return s
+}
+
+// Fail makes commands containing match exit with status 1 and output
+func (s *SSHServer) Fail(match, output string) *SSHServer {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.responses = append(s.responses, failure(match, output, 0))
+	return s
+}
+
+// AuthorizeKey lets the public key in authorized_keys format log in as
+// SSHUser
+func (s *SSHServer) AuthorizeKey(t *testing.T, authorized string) *SSHServer {
+	t.Helper()
+	key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(authorized))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.authorized[string(key.Marshal())] = true
+	return s
+}
+
+// Execs returns the commands received so far
+func (s *SSHServer) Execs() []Exec {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Exec(nil), s.execs...)
+}
+
+// Commands returns the command lines received so far
+func (s *SSHServer) Commands() []string {
+	var commands []string
+	for _, e := range s.Execs() {
+		commands = append(commands, e.Command)
+	}
+	return commands
+}
+
+func (s *SSHServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handleConn(conn)
+	}
+}
+
+func (s *SSHServer) handleConn(conn net.Conn) {
+	_, channels, requests, err := ssh.NewServerConn(conn, s.config)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	go ssh.DiscardRequests(requests)
+
+	for newChannel := range channels {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "only sessions are supported")
+			continue
+		}
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			continue
+		}
+		go s.handleSession(channel, requests)
+	}
+}
+
+func (s *SSHServer) handleSession(channel ssh.Channel, requests <-chan *ssh.Request) {
+	defer channel.Close()
+
+	for req := range requests {
+		if req.Type != "exec" {
+			req.Reply(false, nil)
+			continue
+		}
+
+		var payload struct{ Command string }
+		if err := ssh.Unmarshal(req.Payload, &payload); err != nil {
+			req.Reply(false, nil)
+			return
+		}
+		req.Reply(true, nil)
+
+		stdin, _ := ioutil.ReadAll(channel)
+
+		s.mu.Lock()
+		s.execs = append(s.execs, Exec{Command: payload.Command, Stdin: stdin})
+		output, err := answer(s.responses, payload.Command)
+		s.mu.Unlock()
+
+		channel.Write([]byte(output))
+
+		status := make([]byte, 4)
+		if err != nil {
+			binary.BigEndian.PutUint32(status, 1)
+		}
+		channel.SendRequest("exit-status", false, status)
+		return
+	}
+}
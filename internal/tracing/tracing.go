@@ -0,0 +1,94 @@
+package tracing
+
+import (
+	"context"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/maarulav/k8s-setup/pkg/config"
+)
+
+// ServiceName identifies the tool in the exported traces
+const ServiceName = "k8s-setup"
+
+// recordCommands is set from config.Tracing.Commands
+var recordCommands bool
+
+// Tracer returns the tracer of the tool. Without Setup it is a no-op.
+func Tracer() trace.Tracer {
+	return otel.Tracer("github.com/maarulav/k8s-setup")
+}
+
+// Setup installs an OTLP/HTTP exporter when cfg.Endpoint or the standard
+// OTEL_EXPORTER_OTLP_ENDPOINT variables are set. The returned function
+// flushes the pending spans and must be called before exiting.
+func Setup(cfg config.Tracing) func() {
+	recordCommands = cfg.Commands
+	if cfg.Endpoint == "" && os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") == "" && os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") == "" {
+		return func() {}
+	}
+
+	var options []otlptracehttp.Option
+	if cfg.Endpoint != "" {
+		options = append(options, otlptracehttp.WithEndpointURL(cfg.Endpoint))
+	}
+	exporter, err := otlptracehttp.New(context.Background(), options...)
+	if err != nil {
+		log.Printf("Warning: tracing disabled: %v", err)
+		return func() {}
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", ServiceName))),
+	)
+	otel.SetTracerProvider(provider)
+
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := provider.Shutdown(ctx); err != nil {
+			log.Printf("Warning: failed to flush traces: %v", err)
+		}
+	}
+}
+
+// Command starts the span of a command run on host, as a child of the span
+// in ctx, which may be nil
+func Command(ctx context.Context, host, command string) trace.Span {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	text := command
+	if !recordCommands {
+		if fields := strings.Fields(command); len(fields) > 0 {
+			text = fields[0]
+		}
+	}
+
+	_, span := Tracer().Start(ctx, "command", trace.WithAttributes(
+		attribute.String("host", host),
+		attribute.String("command", text),
+	))
+	return span
+}
+
+// End ends span, marking it failed when err is set
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
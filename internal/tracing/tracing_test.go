@@ -0,0 +1,151 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	collectortrace "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/maarulav/k8s-setup/pkg/config"
+)
+
+// collector returns an OTLP/HTTP endpoint and the spans exported to it
+func collector(t *testing.T) (*httptest.Server, func() []*tracepb.Span) {
+	var mu sync.Mutex
+	var spans []*tracepb.Span
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/traces" || r.Header.Get("Content-Type") != "application/x-protobuf" {
+			t.Errorf("exported to %s as %q", r.URL.Path, r.Header.Get("Content-Type"))
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		var request collectortrace.ExportTraceServiceRequest
+		if err := proto.Unmarshal(body, &request); err != nil {
+			t.Errorf("invalid export request: %v", err)
+		}
+		mu.Lock()
+		for _, rs := range request.ResourceSpans {
+			var service string
+			for _, attribute := range rs.Resource.Attributes {
+				if attribute.Key == "service.name" {
+					service = attribute.Value.GetStringValue()
+				}
+			}
+			if service != ServiceName {
+				t.Errorf("exported by service %q", service)
+			}
+			for _, ss := range rs.ScopeSpans {
+				spans = append(spans, ss.Spans...)
+			}
+		}
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/x-protobuf")
+	}))
+	t.Cleanup(server.Close)
+	return server, func() []*tracepb.Span {
+		mu.Lock()
+		defer mu.Unlock()
+		return spans
+	}
+}
+
+// spanAttribute returns the string attribute key of span
+func spanAttribute(span *tracepb.Span, key string) string {
+	for _, a := range span.Attributes {
+		if a.Key == key {
+			return a.Value.GetStringValue()
+		}
+	}
+	return ""
+}
+
+// export runs a step with a successful and a failing command, exporting the
+// full command text when commands is set, and returns the spans of the step
+// and the commands
+func export(t *testing.T, commands bool) (step *tracepb.Span, ok, failed *tracepb.Span) {
+	provider := otel.GetTracerProvider()
+	t.Cleanup(func() {
+		otel.SetTracerProvider(provider)
+		recordCommands = false
+	})
+	server, spans := collector(t)
+
+	shutdown := Setup(config.Tracing{Endpoint: server.URL, Commands: commands})
+	ctx, span := Tracer().Start(context.Background(), "Installing Kubernetes")
+	End(Command(ctx, "10.0.0.1", "kubeadm join 10.0.0.1:6443 --token abcdef.0123456789abcdef"), nil)
+	End(Command(ctx, "10.0.0.1", "systemctl restart kubelet"), errors.New("command failed: exit status 1"))
+	End(span, nil)
+	shutdown()
+
+	got := spans()
+	if len(got) != 3 {
+		t.Fatalf("%d spans exported, want 3", len(got))
+	}
+	for _, s := range got {
+		switch {
+		case s.Name == "Installing Kubernetes":
+			step = s
+		case strings.HasPrefix(spanAttribute(s, "command"), "kubeadm"):
+			ok = s
+		default:
+			failed = s
+		}
+	}
+	if step == nil {
+		t.Fatal("the step span was not exported")
+	}
+	return step, ok, failed
+}
+
+func TestExport(t *testing.T) {
+	step, ok, failed := export(t, false)
+
+	for _, s := range []*tracepb.Span{ok, failed} {
+		if s.Name != "command" || string(s.ParentSpanId) != string(step.SpanId) || string(s.TraceId) != string(step.TraceId) {
+			t.Errorf("span %q is no command of the step", s.Name)
+		}
+		if host := spanAttribute(s, "host"); host != "10.0.0.1" {
+			t.Errorf("command on host %q", host)
+		}
+	}
+	// Only the program is recorded, the arguments may hold secrets
+	if command := spanAttribute(ok, "command"); command != "kubeadm" {
+		t.Errorf("command %q, want only the program", command)
+	}
+	if ok.Status.GetCode() == tracepb.Status_STATUS_CODE_ERROR {
+		t.Error("successful command marked failed")
+	}
+	if failed.Status.GetCode() != tracepb.Status_STATUS_CODE_ERROR || failed.Status.Message != "command failed: exit status 1" {
+		t.Errorf("failed command has status %v", failed.Status)
+	}
+	if len(failed.Events) != 1 || failed.Events[0].Name != "exception" {
+		t.Errorf("failed command has events %v, want the error recorded", failed.Events)
+	}
+}
+
+func TestExportCommands(t *testing.T) {
+	_, ok, _ := export(t, true)
+	if command := spanAttribute(ok, "command"); command != "kubeadm join 10.0.0.1:6443 --token abcdef.0123456789abcdef" {
+		t.Errorf("command %q, want the full text", command)
+	}
+}
+
+func TestSetupWithoutEndpoint(t *testing.T) {
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "")
+	t.Setenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", "")
+	provider := otel.GetTracerProvider()
+	Setup(config.Tracing{})()
+	if otel.GetTracerProvider() != provider {
+		t.Error("a tracer provider was installed without an endpoint")
+	}
+	// Commands outside of a step have no parent
+	End(Command(nil, "10.0.0.1", "true"), nil)
+}
@@ -264,7 +264,7 @@ func setupKubernetes(client *ssh.Client, config *Config) error {
 
 		// Add Docker repository
 		"curl -fsSL https://download.docker.com/linux/ubuntu/gpg | apt-key add -",
-		"add-apt-repository \"deb [arch=amd64] https://download.docker.com/linux/ubuntu $(lsb_release -cs) stable\"",
+		"add-apt-repository \"deb [arch=$(dpkg --print-architecture)] https://download.docker.com/linux/ubuntu $(lsb_release -cs) stable\"",
 
 		// Install Docker
 		"apt-get update && apt-get install -y docker-ce docker-ce-cli containerd.io",
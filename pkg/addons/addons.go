@@ -0,0 +1,167 @@
+package addons
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/maarulav/k8s-setup/pkg/config"
+	"github.com/maarulav/k8s-setup/pkg/helm"
+	"github.com/maarulav/k8s-setup/pkg/kubernetes"
+	"github.com/maarulav/k8s-setup/pkg/ssh"
+)
+
+// addon is a single optional cluster component. inspect detects an existing
+// installation and records it in the configuration.
+type addon struct {
+	name    string
+	enabled func(config *config.Config) bool
+	setup   func(client ssh.Executor, config *config.Config) error
+	inspect func(client ssh.Executor, config *config.Config, releases []helm.Release) error
+}
+
+// all lists the addons in installation order
+var all = []addon{
+	{
+		name:    "cert-manager",
+		enabled: func(c *config.Config) bool { return c.Addons.CertManager.Enabled },
+		setup:   setupCertManager,
+		inspect: inspectCertManager,
+	},
+	{
+		name:    "external-dns",
+		enabled: func(c *config.Config) bool { return c.Addons.ExternalDNS.Enabled },
+		setup:   setupExternalDNS,
+		inspect: inspectExternalDNS,
+	},
+	{
+		name:    "registry",
+		enabled: func(c *config.Config) bool { return c.Addons.Registry.Enabled },
+		setup:   setupRegistry,
+		inspect: inspectRegistry,
+	},
+	{
+		name:    "falco",
+		enabled: func(c *config.Config) bool { return c.Addons.Falco.Enabled },
+		setup:   setupFalco,
+		inspect: inspectFalco,
+	},
+	{
+		name:    "secrets",
+		enabled: func(c *config.Config) bool { return c.Addons.Secrets.Enabled },
+		setup:   setupSecrets,
+		inspect: inspectSecrets,
+	},
+	{
+		name:    "event-exporter",
+		enabled: func(c *config.Config) bool { return c.Addons.EventExporter.Enabled },
+		setup:   setupEventExporter,
+		inspect: inspectEventExporter,
+	},
+	{
+		name:    "descheduler",
+		enabled: func(c *config.Config) bool { return c.Addons.Descheduler.Enabled },
+		setup:   setupDescheduler,
+		inspect: inspectDescheduler,
+	},
+	{
+		name:    "overprovisioner",
+		enabled: func(c *config.Config) bool { return c.Addons.Overprovisioner.Enabled },
+		setup:   setupOverprovisioner,
+		inspect: inspectOverprovisioner,
+	},
+	{
+		name:    "vpa",
+		enabled: func(c *config.Config) bool { return c.Addons.VPA.Enabled },
+		setup:   setupVPA,
+		inspect: inspectVPA,
+	},
+	{
+		name:    "tenancy",
+		enabled: func(c *config.Config) bool { return c.Addons.Tenancy.Enabled },
+		setup:   setupTenancy,
+		inspect: inspectTenancy,
+	},
+	{
+		// Last, so that the policies do not get in the way of the other addons
+		name:    "policy",
+		enabled: func(c *config.Config) bool { return c.Addons.Policy.Enabled },
+		setup:   setupPolicy,
+		inspect: inspectPolicy,
+	},
+}
+
+// Enabled returns the names of the addons enabled in the configuration
+func Enabled(config *config.Config) []string {
+	var names []string
+	for _, a := range all {
+		if a.enabled(config) {
+			names = append(names, a.name)
+		}
+	}
+	return names
+}
+
+// CheckArch returns an error when an enabled addon publishes no images for
+// the architecture of the nodes
+func CheckArch(config *config.Config, arch string) error {
+	if arch != kubernetes.ArchAMD64 && config.Addons.Registry.Enabled && config.Addons.Registry.Type == "harbor" {
+		return fmt.Errorf("harbor only publishes amd64 images, use the plain registry on %s", arch)
+	}
+	return nil
+}
+
+// Setup installs every enabled addon on the cluster
+func Setup(client ssh.Executor, config *config.Config) error {
+	for _, a := range all {
+		if !a.enabled(config) {
+			continue
+		}
+		if err := a.setup(client, config); err != nil {
+			return fmt.Errorf("addon %s: %v", a.name, err)
+		}
+	}
+
+	return nil
+}
+
+// Inspect detects the addons installed on the cluster and records them in config
+func Inspect(client ssh.Executor, config *config.Config) error {
+	releases, err := helm.List(client)
+	if err != nil {
+		return err
+	}
+
+	for _, a := range all {
+		if err := a.inspect(client, config, releases); err != nil {
+			return fmt.Errorf("addon %s: %v", a.name, err)
+		}
+	}
+
+	return nil
+}
+
+// findRelease returns the release installed from the named chart
+func findRelease(releases []helm.Release, chart string) (helm.Release, bool) {
+	for _, r := range releases {
+		if r.ChartName() == chart {
+			return r, true
+		}
+	}
+	return helm.Release{}, false
+}
+
+// retryDelay is the pause between the checks waiting for a controller
+var retryDelay = 2 * time.Second
+
+// retry runs check until it succeeds, giving up after a minute. Webhooks and
+// CRDs of freshly installed controllers take a moment to become available.
+func retry(check func() error) error {
+	var err error
+	for i := 0; i < 30; i++ {
+		if err = check(); err == nil {
+			return nil
+		}
+		time.Sleep(retryDelay)
+	}
+	return err
+}
@@ -0,0 +1,276 @@
+package addons
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/maarulav/k8s-setup/internal/testutil"
+	"github.com/maarulav/k8s-setup/pkg/config"
+)
+
+func TestFalcoDriver(t *testing.T) {
+	tests := map[string]string{
+		"6.5.0-1017-azure":       "modern_ebpf",
+		"5.15.0-91-generic":      "modern_ebpf",
+		"5.4.0-150-generic":      "ebpf",
+		"4.18.0-513.el8.x86_64":  "ebpf",
+		"3.10.0-1160.el7.x86_64": "kmod",
+		"unknown":                "kmod",
+	}
+	for kernel, want := range tests {
+		if got := falcoDriver(kernel); got != want {
+			t.Errorf("falcoDriver(%q) = %s, want %s", kernel, got, want)
+		}
+	}
+}
+
+func TestPolicyNames(t *testing.T) {
+	names, err := policyNames(config.PolicyAddon{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(names, ","); got != "disallow-privileged,require-requests-limits" {
+		t.Errorf("default policies without registries = %s", got)
+	}
+
+	names, _ = policyNames(config.PolicyAddon{AllowedRegistries: []string{"registry.example.com"}})
+	if len(names) != 3 {
+		t.Errorf("default policies with registries = %v, want all three", names)
+	}
+
+	if _, err := policyNames(config.PolicyAddon{Policies: []string{"restrict-registries"}}); err == nil {
+		t.Error("expected an error for restrict-registries without allowedRegistries")
+	}
+	if _, err := policyNames(config.PolicyAddon{Policies: []string{"disallow-latest"}}); err == nil {
+		t.Error("expected an error for an unknown policy")
+	}
+}
+
+func TestSecretStoreObjects(t *testing.T) {
+	objects := secretStoreObjects(config.SecretStore{
+		Provider: map[string]interface{}{"vault": map[string]interface{}{"server": "https://vault.example.com"}},
+	})
+	if len(objects) != 1 || objects[0]["kind"] != "ClusterSecretStore" {
+		t.Fatalf("expected only the store without credentials, got %v", objects)
+	}
+	if name := objects[0]["metadata"].(map[string]interface{})["name"]; name != "default" {
+		t.Errorf("store name = %v, want default", name)
+	}
+	if apiVersion := objects[0]["apiVersion"]; apiVersion != "external-secrets.io/v1" {
+		t.Errorf("store apiVersion = %v, want external-secrets.io/v1", apiVersion)
+	}
+
+	objects = secretStoreObjects(config.SecretStore{
+		Name:        "vault",
+		Provider:    map[string]interface{}{"vault": map[string]interface{}{}},
+		Credentials: map[string]string{"token": "s.123"},
+	})
+	if len(objects) != 2 || objects[0]["kind"] != "Secret" {
+		t.Fatalf("expected the credentials secret before the store, got %v", objects)
+	}
+	if name := objects[0]["metadata"].(map[string]interface{})["name"]; name != "vault-credentials" {
+		t.Errorf("credentials secret name = %v, want vault-credentials", name)
+	}
+}
+
+func TestEventExporterValues(t *testing.T) {
+	if _, err := eventExporterValues(config.EventExporterAddon{}); err == nil {
+		t.Error("expected an error without sinks")
+	}
+
+	values, err := eventExporterValues(config.EventExporterAddon{
+		Loki:          &config.LokiSink{URL: "http://loki.logging:3100/loki/api/v1/push"},
+		Elasticsearch: &config.ElasticsearchSink{Hosts: []string{"http://es.logging:9200"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	exporter := values["config"].(map[string]interface{})
+	routes := exporter["route"].(map[string]interface{})["routes"].([]map[string]interface{})
+	if len(routes) != 2 {
+		t.Fatalf("expected a route per sink, got %v", routes)
+	}
+	es := exporter["receivers"].([]map[string]interface{})[1]["elasticsearch"].(map[string]interface{})
+	if es["indexFormat"] != "kube-events-{2006-01-02}" {
+		t.Errorf("indexFormat = %v", es["indexFormat"])
+	}
+}
+
+func TestOverprovisionerSize(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Resources.CPU = "4"
+	cfg.Resources.Memory = "8Gi"
+	cfg.Addons.Overprovisioner = config.OverprovisionerAddon{Enabled: true, Replicas: 2, Percent: 25}
+
+	replicas, cpu, memory, err := OverprovisionerSize(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if replicas != 2 || cpu != 500 || memory != 1<<30 {
+		t.Errorf("got %d pods of %dm and %d bytes, want 2 pods of 500m and 1Gi", replicas, cpu, memory)
+	}
+
+	cfg.Addons.Overprovisioner = config.OverprovisionerAddon{Enabled: true}
+	replicas, cpu, _, _ = OverprovisionerSize(cfg)
+	if replicas != 1 || cpu != 400 {
+		t.Errorf("defaults: got %d pods of %dm, want 1 pod of 400m", replicas, cpu)
+	}
+}
+
+func TestVPAObjects(t *testing.T) {
+	objects := vpaObjects("monitoring", []string{"Deployment/grafana", "StatefulSet/prometheus", "garbage"}, false)
+	if len(objects) != 2 {
+		t.Fatalf("expected a VPA per workload, got %d", len(objects))
+	}
+	spec := objects[1]["spec"].(map[string]interface{})
+	if kind := spec["targetRef"].(map[string]interface{})["kind"]; kind != "StatefulSet" {
+		t.Errorf("target kind = %v, want StatefulSet", kind)
+	}
+	if mode := spec["updatePolicy"].(map[string]interface{})["updateMode"]; mode != "Off" {
+		t.Errorf("recommender-only update mode = %v, want Off", mode)
+	}
+
+	if _, err := vpaFull(config.VPAAddon{Mode: "auto"}); err == nil {
+		t.Error("expected an error for an unknown mode")
+	}
+}
+
+func TestCapsuleTenant(t *testing.T) {
+	tenant, err := capsuleTenant(config.Tenant{
+		Name:   "team-a",
+		Owners: []string{"alice", "group:team-a-admins"},
+		CPU:    "4",
+		Memory: "8Gi",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	spec := tenant["spec"].(map[string]interface{})
+	owners := spec["owners"].([]map[string]interface{})
+	if len(owners) != 2 || owners[0]["kind"] != "User" || owners[1]["kind"] != "Group" || owners[1]["name"] != "team-a-admins" {
+		t.Errorf("unexpected owners %v", owners)
+	}
+	quotas := spec["resourceQuotas"].(map[string]interface{})
+	hard := quotas["items"].([]map[string]interface{})[0]["hard"].(map[string]interface{})
+	if quotas["scope"] != "Tenant" || hard["limits.cpu"] != "4" || hard["requests.memory"] != "8Gi" {
+		t.Errorf("unexpected quota %v", quotas)
+	}
+
+	tenant, _ = capsuleTenant(config.Tenant{Name: "team-b", Owners: []string{"bob"}})
+	if _, ok := tenant["spec"].(map[string]interface{})["resourceQuotas"]; ok {
+		t.Error("tenant without limits got a quota")
+	}
+	if _, err := capsuleTenant(config.Tenant{Name: "team-c", Owners: []string{"carol"}, CPU: "lots"}); err == nil {
+		t.Error("expected an error for an invalid cpu")
+	}
+
+	if v := capsuleVersion(config.TenancyAddon{}); v != defaultCapsuleVersion {
+		t.Errorf("default version %q, want the pinned %s", v, defaultCapsuleVersion)
+	}
+	if v := capsuleVersion(config.TenancyAddon{Version: "0.7.4"}); v != "0.7.4" {
+		t.Errorf("configured version %q, want 0.7.4", v)
+	}
+}
+
+func TestVClusterValues(t *testing.T) {
+	values, err := vclusterValues(config.Tenant{Name: "team-a", Namespaces: []string{"dev", "prod"}, Pods: 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+	policies := values["policies"].(map[string]interface{})
+	quota := policies["resourceQuota"].(map[string]interface{})
+	if quota["enabled"] != true || quota["quota"].(map[string]interface{})["pods"] != "20" {
+		t.Errorf("unexpected quota %v", quota)
+	}
+	for _, policy := range []string{"limitRange", "networkPolicy"} {
+		if policies[policy].(map[string]interface{})["enabled"] != true {
+			t.Errorf("policy %s not enabled", policy)
+		}
+	}
+	if _, ok := values["isolation"]; ok {
+		t.Error("values use the isolation section vcluster 0.20 removed")
+	}
+	deploy := values["experimental"].(map[string]interface{})["deploy"].(map[string]interface{})
+	manifests := deploy["vcluster"].(map[string]interface{})["manifests"].(string)
+	if strings.Count(manifests, "kind: Namespace") != 2 || !strings.Contains(manifests, "name: prod") {
+		t.Errorf("unexpected init manifests:\n%s", manifests)
+	}
+
+	if v := vclusterVersion(config.TenancyAddon{}); v != defaultVClusterVersion {
+		t.Errorf("default version %q, want the pinned %s", v, defaultVClusterVersion)
+	}
+	if v := vclusterVersion(config.TenancyAddon{Version: "0.21.1"}); v != "0.21.1" {
+		t.Errorf("configured version %q, want 0.21.1", v)
+	}
+}
+
+func TestValidateTenants(t *testing.T) {
+	valid := config.Tenant{Name: "team-a", Owners: []string{"alice"}, Namespaces: []string{"team-a-dev"}}
+	if err := validateTenants(config.TenancyAddon{Tenants: []config.Tenant{valid}}); err != nil {
+		t.Errorf("valid tenant refused: %v", err)
+	}
+
+	long := strings.Repeat("a", 60)
+	for name, tt := range map[string]struct {
+		kind   string
+		tenant config.Tenant
+	}{
+		"no owner":                {"capsule", config.Tenant{Name: "team-a"}},
+		"upper case name":         {"capsule", config.Tenant{Name: "Team-A", Owners: []string{"alice"}}},
+		"shell in name":           {"vcluster", config.Tenant{Name: "a;reboot", Owners: []string{"alice"}}},
+		"invalid namespace":       {"capsule", config.Tenant{Name: "team-a", Owners: []string{"alice"}, Namespaces: []string{"dev_1"}}},
+		"host namespace too long": {"vcluster", config.Tenant{Name: long, Owners: []string{"alice"}}},
+	} {
+		if err := validateTenants(config.TenancyAddon{Type: tt.kind, Tenants: []config.Tenant{tt.tenant}}); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+	// Only the virtual clusters prefix the name
+	if err := validateTenants(config.TenancyAddon{Tenants: []config.Tenant{{Name: long, Owners: []string{"alice"}}}}); err != nil {
+		t.Errorf("capsule tenant with a 60 character name refused: %v", err)
+	}
+}
+
+func TestVerifyPolicies(t *testing.T) {
+	delay := retryDelay
+	retryDelay = 0
+	t.Cleanup(func() { retryDelay = delay })
+	names := []string{policyDisallowPrivileged}
+
+	denied := `Error from server: error when creating "/root/k8s-setup-manifests/policy-check-disallow-privileged.json": admission webhook "validate.kyverno.svc-fail" denied the request:
+
+resource Pod/default/k8s-setup-policy-check was blocked due to the following policies
+
+disallow-privileged:
+  privileged-containers: 'validation error: Privileged containers are not allowed.'`
+	fake := testutil.NewExecutor("10.0.0.1").Fail("kubectl create --dry-run=server", denied)
+	if err := verifyPolicies(fake, names, config.PolicyAddon{}); err != nil {
+		t.Errorf("denied pod: %v", err)
+	}
+
+	// Gatekeeper names the constraint
+	fake = testutil.NewExecutor("10.0.0.1").Fail("kubectl create --dry-run=server",
+		`Error from server (Forbidden): admission webhook "validation.gatekeeper.sh" denied the request: [disallow-privileged] privileged container is not allowed: check`)
+	if err := verifyPolicies(fake, names, config.PolicyAddon{Engine: "gatekeeper"}); err != nil {
+		t.Errorf("pod denied by gatekeeper: %v", err)
+	}
+
+	fake = testutil.NewExecutor("10.0.0.1")
+	if err := verifyPolicies(fake, names, config.PolicyAddon{}); err == nil || !strings.Contains(err.Error(), "admitted") {
+		t.Errorf("admitted pod: got %v, want it reported", err)
+	}
+
+	// The file name carries the policy name, which must not count as a denial
+	fake = testutil.NewExecutor("10.0.0.1").Fail("kubectl create --dry-run=server",
+		`error: error validating "/root/k8s-setup-manifests/policy-check-disallow-privileged.json": the server could not find the requested resource`)
+	if err := verifyPolicies(fake, names, config.PolicyAddon{}); err == nil || !strings.Contains(err.Error(), "another reason") {
+		t.Errorf("unrelated failure: got %v, want it reported", err)
+	}
+
+	// Denied by another policy
+	fake = testutil.NewExecutor("10.0.0.1").Fail("kubectl create --dry-run=server",
+		`admission webhook "validate.kyverno.svc-fail" denied the request: require-requests-limits: missing limits`)
+	if err := verifyPolicies(fake, names, config.PolicyAddon{}); err == nil {
+		t.Error("pod denied by another policy counted as enforced")
+	}
+}
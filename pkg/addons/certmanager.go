@@ -0,0 +1,149 @@
+package addons
+
+import (
+	"fmt"
+
+	"github.com/maarulav/k8s-setup/pkg/config"
+	"github.com/maarulav/k8s-setup/pkg/helm"
+	"github.com/maarulav/k8s-setup/pkg/kubernetes"
+	"github.com/maarulav/k8s-setup/pkg/ssh"
+)
+
+const (
+	certManagerNamespace = "cert-manager"
+	letsEncryptServer    = "https://acme-v02.api.letsencrypt.org/directory"
+)
+
+func setupCertManager(client ssh.Executor, cfg *config.Config) error {
+	cm := cfg.Addons.CertManager
+	if err := helm.Install(client, helm.Chart{
+		Release:   "cert-manager",
+		RepoURL:   "https://charts.jetstack.io",
+		Chart:     "cert-manager",
+		Version:   cm.Version,
+		Namespace: certManagerNamespace,
+		Values: map[string]interface{}{
+			"crds": map[string]interface{}{"enabled": true},
+		},
+	}); err != nil {
+		return err
+	}
+
+	if len(cm.Issuers) == 0 {
+		return nil
+	}
+
+	var objects []map[string]interface{}
+	for _, issuer := range cm.Issuers {
+		issuerObjects, err := clusterIssuerObjects(issuer)
+		if err != nil {
+			return fmt.Errorf("issuer %s: %v", issuer.Name, err)
+		}
+		objects = append(objects, issuerObjects...)
+	}
+
+	return kubernetes.Apply(client, "cluster-issuers", objects)
+}
+
+// clusterIssuerObjects renders the ClusterIssuer and any credential Secret it needs
+func clusterIssuerObjects(issuer config.ClusterIssuer) ([]map[string]interface{}, error) {
+	clusterIssuer := func(spec map[string]interface{}) map[string]interface{} {
+		return map[string]interface{}{
+			"apiVersion": "cert-manager.io/v1",
+			"kind":       "ClusterIssuer",
+			"metadata":   map[string]interface{}{"name": issuer.Name},
+			"spec":       spec,
+		}
+	}
+
+	if issuer.Type == "selfSigned" {
+		return []map[string]interface{}{
+			clusterIssuer(map[string]interface{}{"selfSigned": map[string]interface{}{}}),
+		}, nil
+	}
+	if issuer.Type != "acme" {
+		return nil, fmt.Errorf("unsupported issuer type %q", issuer.Type)
+	}
+
+	var objects []map[string]interface{}
+	var solver map[string]interface{}
+	secretName := issuer.Name + "-credentials"
+
+	switch issuer.Solver {
+	case "", "http01":
+		ingress := map[string]interface{}{}
+		if issuer.IngressClass != "" {
+			ingress["ingressClassName"] = issuer.IngressClass
+		}
+		solver = map[string]interface{}{
+			"http01": map[string]interface{}{"ingress": ingress},
+		}
+	case "cloudflare":
+		objects = append(objects, credentialSecret(certManagerNamespace, secretName, map[string]string{
+			"api-token": issuer.Cloudflare.APIToken,
+		}))
+		solver = map[string]interface{}{
+			"dns01": map[string]interface{}{
+				"cloudflare": map[string]interface{}{
+					"apiTokenSecretRef": map[string]interface{}{"name": secretName, "key": "api-token"},
+				},
+			},
+		}
+	case "route53":
+		r53 := issuer.Route53
+		route53 := map[string]interface{}{"region": r53.Region}
+		if r53.HostedZoneID != "" {
+			route53["hostedZoneID"] = r53.HostedZoneID
+		}
+		if r53.AccessKeyID != "" {
+			objects = append(objects, credentialSecret(certManagerNamespace, secretName, map[string]string{
+				"secret-access-key": r53.SecretAccessKey,
+			}))
+			route53["accessKeyID"] = r53.AccessKeyID
+			route53["secretAccessKeySecretRef"] = map[string]interface{}{"name": secretName, "key": "secret-access-key"}
+		}
+		solver = map[string]interface{}{
+			"dns01": map[string]interface{}{"route53": route53},
+		}
+	default:
+		return nil, fmt.Errorf("unsupported solver %q", issuer.Solver)
+	}
+
+	server := issuer.Server
+	if server == "" {
+		server = letsEncryptServer
+	}
+
+	objects = append(objects, clusterIssuer(map[string]interface{}{
+		"acme": map[string]interface{}{
+			"email":               issuer.Email,
+			"server":              server,
+			"privateKeySecretRef": map[string]interface{}{"name": issuer.Name + "-account-key"},
+			"solvers":             []map[string]interface{}{solver},
+		},
+	}))
+
+	return objects, nil
+}
+
+// credentialSecret renders an Opaque Secret holding provider credentials
+func credentialSecret(namespace, name string, data map[string]string) map[string]interface{} {
+	return map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Secret",
+		"type":       "Opaque",
+		"metadata":   map[string]interface{}{"name": name, "namespace": namespace},
+		"stringData": data,
+	}
+}
+
+func inspectCertManager(client ssh.Executor, cfg *config.Config, releases []helm.Release) error {
+	release, ok := findRelease(releases, "cert-manager")
+	if !ok {
+		return nil
+	}
+
+	cfg.Addons.CertManager.Enabled = true
+	cfg.Addons.CertManager.Version = release.ChartVersion()
+	return nil
+}
@@ -0,0 +1,59 @@
+package addons
+
+import (
+	"github.com/maarulav/k8s-setup/pkg/config"
+	"github.com/maarulav/k8s-setup/pkg/helm"
+	"github.com/maarulav/k8s-setup/pkg/ssh"
+)
+
+const defaultDeschedulerSchedule = "*/15 * * * *"
+
+func setupDescheduler(client ssh.Executor, cfg *config.Config) error {
+	d := cfg.Addons.Descheduler
+	return helm.Install(client, helm.Chart{
+		Release:   "descheduler",
+		RepoURL:   "https://kubernetes-sigs.github.io/descheduler/",
+		Chart:     "descheduler",
+		Version:   d.Version,
+		Namespace: "kube-system",
+		Values:    deschedulerValues(d),
+	})
+}
+
+func deschedulerValues(d config.DeschedulerAddon) map[string]interface{} {
+	schedule := d.Schedule
+	if schedule == "" {
+		schedule = defaultDeschedulerSchedule
+	}
+	values := map[string]interface{}{
+		"kind":     "CronJob",
+		"schedule": schedule,
+	}
+	if len(d.Profile) > 0 {
+		values["deschedulerPolicy"] = map[string]interface{}{
+			"profiles": []map[string]interface{}{d.Profile},
+		}
+	}
+	return values
+}
+
+func inspectDescheduler(client ssh.Executor, cfg *config.Config, releases []helm.Release) error {
+	release, ok := findRelease(releases, "descheduler")
+	if !ok {
+		return nil
+	}
+
+	cfg.Addons.Descheduler.Enabled = true
+	cfg.Addons.Descheduler.Version = release.ChartVersion()
+	if values, err := helm.Values(client, release.Name, release.Namespace); err == nil {
+		if schedule, _ := values["schedule"].(string); schedule != defaultDeschedulerSchedule {
+			cfg.Addons.Descheduler.Schedule = schedule
+		}
+		if policy, ok := values["deschedulerPolicy"].(map[string]interface{}); ok {
+			if profiles, ok := policy["profiles"].([]interface{}); ok && len(profiles) > 0 {
+				cfg.Addons.Descheduler.Profile, _ = profiles[0].(map[string]interface{})
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package addons
+
+import (
+	"fmt"
+
+	"github.com/maarulav/k8s-setup/pkg/config"
+	"github.com/maarulav/k8s-setup/pkg/helm"
+	"github.com/maarulav/k8s-setup/pkg/ssh"
+)
+
+const eventExporterNamespace = "event-exporter"
+
+func setupEventExporter(client ssh.Executor, cfg *config.Config) error {
+	e := cfg.Addons.EventExporter
+	values, err := eventExporterValues(e)
+	if err != nil {
+		return err
+	}
+
+	return helm.Install(client, helm.Chart{
+		Release:   "event-exporter",
+		RepoURL:   "oci://registry-1.docker.io/bitnamicharts",
+		Chart:     "kubernetes-event-exporter",
+		Version:   e.Version,
+		Namespace: eventExporterNamespace,
+		Values:    values,
+	})
+}
+
+// eventExporterValues routes every event to each configured sink and lets
+// the monitoring stack scrape the exporter's own metrics
+func eventExporterValues(e config.EventExporterAddon) (map[string]interface{}, error) {
+	var receivers, routes []map[string]interface{}
+
+	if e.Loki != nil {
+		if e.Loki.URL == "" {
+			return nil, fmt.Errorf("loki sink needs a url")
+		}
+		labels := map[string]string{"source": "kubernetes-event-exporter"}
+		for k, v := range e.Loki.Labels {
+			labels[k] = v
+		}
+		receivers = append(receivers, map[string]interface{}{
+			"name": "loki",
+			"loki": map[string]interface{}{
+				"url":          e.Loki.URL,
+				"streamLabels": labels,
+			},
+		})
+	}
+
+	if es := e.Elasticsearch; es != nil {
+		if len(es.Hosts) == 0 {
+			return nil, fmt.Errorf("elasticsearch sink needs hosts")
+		}
+		index := es.Index
+		if index == "" {
+			index = "kube-events"
+		}
+		receiver := map[string]interface{}{
+			"hosts":       es.Hosts,
+			"index":       index,
+			"indexFormat": index + "-{2006-01-02}",
+		}
+		if es.Username != "" {
+			receiver["username"] = es.Username
+			receiver["password"] = es.Password
+		}
+		receivers = append(receivers, map[string]interface{}{
+			"name":          "elasticsearch",
+			"elasticsearch": receiver,
+		})
+	}
+
+	if len(receivers) == 0 {
+		return nil, fmt.Errorf("no event sink configured, set loki or elasticsearch")
+	}
+
+	for _, r := range receivers {
+		routes = append(routes, map[string]interface{}{
+			"match": []map[string]interface{}{{"receiver": r["name"]}},
+		})
+	}
+
+	return map[string]interface{}{
+		"config": map[string]interface{}{
+			"logLevel":  "error",
+			"logFormat": "json",
+			"route":     map[string]interface{}{"routes": routes},
+			"receivers": receivers,
+		},
+		"metrics": map[string]interface{}{
+			"enabled": true,
+			"serviceMonitor": map[string]interface{}{
+				"enabled": true,
+				"labels":  map[string]interface{}{"release": "prometheus"},
+			},
+		},
+	}, nil
+}
+
+func inspectEventExporter(client ssh.Executor, cfg *config.Config, releases []helm.Release) error {
+	release, ok := findRelease(releases, "kubernetes-event-exporter")
+	if !ok {
+		return nil
+	}
+
+	cfg.Addons.EventExporter.Enabled = true
+	cfg.Addons.EventExporter.Version = release.ChartVersion()
+	return nil
+}
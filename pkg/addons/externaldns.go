@@ -0,0 +1,162 @@
+package addons
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/maarulav/k8s-setup/pkg/config"
+	"github.com/maarulav/k8s-setup/pkg/helm"
+	"github.com/maarulav/k8s-setup/pkg/kubernetes"
+	"github.com/maarulav/k8s-setup/pkg/ssh"
+)
+
+const (
+	externalDNSNamespace = "external-dns"
+	externalDNSSecret    = "external-dns-credentials"
+)
+
+func setupExternalDNS(client ssh.Executor, cfg *config.Config) error {
+	e := cfg.Addons.ExternalDNS
+
+	var env []map[string]interface{}
+	var extraArgs []string
+	secretData := map[string]string{}
+
+	secretEnv := func(name, key string) map[string]interface{} {
+		return map[string]interface{}{
+			"name": name,
+			"valueFrom": map[string]interface{}{
+				"secretKeyRef": map[string]interface{}{"name": externalDNSSecret, "key": key},
+			},
+		}
+	}
+
+	switch e.Provider {
+	case "cloudflare":
+		secretData["api-token"] = e.Cloudflare.APIToken
+		env = append(env, secretEnv("CF_API_TOKEN", "api-token"))
+	case "route53":
+		if e.Route53.Region != "" {
+			env = append(env, map[string]interface{}{"name": "AWS_DEFAULT_REGION", "value": e.Route53.Region})
+		}
+		if e.Route53.AccessKeyID != "" {
+			secretData["access-key-id"] = e.Route53.AccessKeyID
+			secretData["secret-access-key"] = e.Route53.SecretAccessKey
+			env = append(env,
+				secretEnv("AWS_ACCESS_KEY_ID", "access-key-id"),
+				secretEnv("AWS_SECRET_ACCESS_KEY", "secret-access-key"),
+			)
+		}
+		if e.Route53.HostedZoneID != "" {
+			extraArgs = append(extraArgs, "--zone-id-filter="+e.Route53.HostedZoneID)
+		}
+	case "rfc2136":
+		r := e.RFC2136
+		port := r.Port
+		if port == 0 {
+			port = 53
+		}
+		alg := r.TSIGSecretAlg
+		if alg == "" {
+			alg = "hmac-sha256"
+		}
+		extraArgs = append(extraArgs,
+			"--rfc2136-host="+r.Host,
+			fmt.Sprintf("--rfc2136-port=%d", port),
+			"--rfc2136-zone="+r.Zone,
+			"--rfc2136-tsig-keyname="+r.TSIGKeyName,
+			"--rfc2136-tsig-secret-alg="+alg,
+			"--rfc2136-tsig-axfr",
+		)
+		if r.TSIGSecret != "" {
+			secretData["tsig-secret"] = r.TSIGSecret
+			env = append(env, secretEnv("EXTERNAL_DNS_RFC2136_TSIG_SECRET", "tsig-secret"))
+		}
+	default:
+		return fmt.Errorf("unsupported provider %q", e.Provider)
+	}
+
+	if len(secretData) > 0 {
+		if err := kubernetes.Apply(client, "external-dns-credentials", []map[string]interface{}{
+			{
+				"apiVersion": "v1",
+				"kind":       "Namespace",
+				"metadata":   map[string]interface{}{"name": externalDNSNamespace},
+			},
+			credentialSecret(externalDNSNamespace, externalDNSSecret, secretData),
+		}); err != nil {
+			return err
+		}
+	}
+
+	policy := e.Policy
+	if policy == "" {
+		policy = "upsert-only"
+	}
+
+	values := map[string]interface{}{
+		"provider": map[string]interface{}{"name": e.Provider},
+		"sources":  []string{"ingress", "service"},
+		"policy":   policy,
+	}
+	if len(e.DomainFilters) > 0 {
+		values["domainFilters"] = e.DomainFilters
+	}
+	if e.TXTOwnerID != "" {
+		values["txtOwnerId"] = e.TXTOwnerID
+	}
+	if len(env) > 0 {
+		values["env"] = env
+	}
+	if len(extraArgs) > 0 {
+		values["extraArgs"] = extraArgs
+	}
+
+	return helm.Install(client, helm.Chart{
+		Release:   "external-dns",
+		RepoURL:   "https://kubernetes-sigs.github.io/external-dns/",
+		Chart:     "external-dns",
+		Version:   e.Version,
+		Namespace: externalDNSNamespace,
+		Values:    values,
+	})
+}
+
+func inspectExternalDNS(client ssh.Executor, cfg *config.Config, releases []helm.Release) error {
+	release, ok := findRelease(releases, "external-dns")
+	if !ok {
+		return nil
+	}
+
+	cfg.Addons.ExternalDNS.Enabled = true
+	cfg.Addons.ExternalDNS.Version = release.ChartVersion()
+
+	userValues, err := helm.Values(client, release.Name, release.Namespace)
+	if err != nil {
+		return err
+	}
+
+	// Round-trip through JSON to decode into the typed view
+	output, err := json.Marshal(userValues)
+	if err != nil {
+		return fmt.Errorf("failed to parse release values: %v", err)
+	}
+
+	var values struct {
+		Provider struct {
+			Name string `json:"name"`
+		} `json:"provider"`
+		DomainFilters []string `json:"domainFilters"`
+		Policy        string   `json:"policy"`
+		TXTOwnerID    string   `json:"txtOwnerId"`
+	}
+	if err := json.Unmarshal(output, &values); err != nil {
+		return fmt.Errorf("failed to parse release values: %v", err)
+	}
+
+	cfg.Addons.ExternalDNS.Provider = values.Provider.Name
+	cfg.Addons.ExternalDNS.DomainFilters = values.DomainFilters
+	cfg.Addons.ExternalDNS.Policy = values.Policy
+	cfg.Addons.ExternalDNS.TXTOwnerID = values.TXTOwnerID
+	return nil
+}
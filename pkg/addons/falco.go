@@ -0,0 +1,125 @@
+package addons
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/maarulav/k8s-setup/pkg/config"
+	"github.com/maarulav/k8s-setup/pkg/helm"
+	"github.com/maarulav/k8s-setup/pkg/kube"
+	"github.com/maarulav/k8s-setup/pkg/ssh"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	falcoNamespace = "falco"
+
+	// alertmanagerURL is the Alertmanager of the kube-prometheus-stack release
+	alertmanagerURL = "http://prometheus-kube-prometheus-alertmanager.monitoring:9093"
+)
+
+func setupFalco(client ssh.Executor, cfg *config.Config) error {
+	f := cfg.Addons.Falco
+
+	driver := f.Driver
+	if driver == "" || driver == "auto" {
+		kernel, err := nodeKernel(client)
+		if err != nil {
+			return err
+		}
+		driver = falcoDriver(kernel)
+	}
+
+	return helm.Install(client, helm.Chart{
+		Release:   "falco",
+		RepoURL:   "https://falcosecurity.github.io/charts",
+		Chart:     "falco",
+		Version:   f.Version,
+		Namespace: falcoNamespace,
+		Values:    falcoValues(driver),
+	})
+}
+
+// falcoValues wires Falco into the monitoring stack: its metrics are scraped
+// through a ServiceMonitor and falcosidekick forwards alerts to Alertmanager
+func falcoValues(driver string) map[string]interface{} {
+	return map[string]interface{}{
+		"driver": map[string]interface{}{"kind": driver},
+		"tty":    true,
+		"metrics": map[string]interface{}{
+			"enabled": true,
+		},
+		"serviceMonitor": map[string]interface{}{
+			"create": true,
+			"labels": map[string]interface{}{"release": "prometheus"},
+		},
+		"falcosidekick": map[string]interface{}{
+			"enabled": true,
+			"config": map[string]interface{}{
+				"alertmanager": map[string]interface{}{
+					"hostport":        alertmanagerURL,
+					"minimumpriority": "warning",
+				},
+			},
+			"serviceMonitor": map[string]interface{}{
+				"enabled":          true,
+				"additionalLabels": map[string]interface{}{"release": "prometheus"},
+			},
+		},
+	}
+}
+
+// nodeKernel returns the kernel version the first node reports
+func nodeKernel(client ssh.Executor) (string, error) {
+	kc, err := kube.NewClient(client)
+	if err != nil {
+		return "", err
+	}
+	nodes, err := kc.CoreV1().Nodes().List(context.Background(), metav1.ListOptions{Limit: 1})
+	if err != nil {
+		return "", fmt.Errorf("failed to read node kernel version: %v", err)
+	}
+	if len(nodes.Items) == 0 {
+		return "", fmt.Errorf("no nodes registered")
+	}
+	return nodes.Items[0].Status.NodeInfo.KernelVersion, nil
+}
+
+// falcoDriver picks the Falco driver for a kernel: the CO-RE modern eBPF
+// probe from 5.8, the classic eBPF probe from 4.14 and the kernel module
+// before that
+func falcoDriver(kernel string) string {
+	parts := strings.SplitN(kernel, ".", 3)
+	if len(parts) < 2 {
+		return "kmod"
+	}
+	major, _ := strconv.Atoi(parts[0])
+	minor, _ := strconv.Atoi(strings.TrimRightFunc(parts[1], func(r rune) bool { return r < '0' || r > '9' }))
+
+	switch {
+	case major > 5 || major == 5 && minor >= 8:
+		return "modern_ebpf"
+	case major == 5 || major == 4 && minor >= 14:
+		return "ebpf"
+	default:
+		return "kmod"
+	}
+}
+
+func inspectFalco(client ssh.Executor, cfg *config.Config, releases []helm.Release) error {
+	release, ok := findRelease(releases, "falco")
+	if !ok {
+		return nil
+	}
+
+	cfg.Addons.Falco.Enabled = true
+	cfg.Addons.Falco.Version = release.ChartVersion()
+	if values, err := helm.Values(client, release.Name, release.Namespace); err == nil {
+		if d, ok := values["driver"].(map[string]interface{}); ok {
+			cfg.Addons.Falco.Driver, _ = d["kind"].(string)
+		}
+	}
+	return nil
+}
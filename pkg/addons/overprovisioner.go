@@ -0,0 +1,120 @@
+package addons
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/maarulav/k8s-setup/pkg/config"
+	"github.com/maarulav/k8s-setup/pkg/helm"
+	"github.com/maarulav/k8s-setup/pkg/kubernetes"
+	"github.com/maarulav/k8s-setup/pkg/ssh"
+)
+
+const (
+	overprovisionerNamespace = "overprovisioner"
+	overprovisionerPriority  = "overprovisioning"
+	defaultOverprovisionPct  = 10
+)
+
+// OverprovisionerSize returns the number of pause pods and the CPU in
+// millicores and memory in bytes each of them requests
+func OverprovisionerSize(cfg *config.Config) (int, int64, int64, error) {
+	o := cfg.Addons.Overprovisioner
+	replicas := o.Replicas
+	if replicas <= 0 {
+		replicas = 1
+	}
+	percent := o.Percent
+	if percent <= 0 {
+		percent = defaultOverprovisionPct
+	}
+
+	cpu, err := config.ParseCPU(cfg.Resources.CPU)
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("overprovisioner: %v", err)
+	}
+	memory, err := config.ParseMemory(cfg.Resources.Memory)
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("overprovisioner: %v", err)
+	}
+
+	return replicas, cpu * int64(percent) / 100 / int64(replicas), memory * int64(percent) / 100 / int64(replicas), nil
+}
+
+func setupOverprovisioner(client ssh.Executor, cfg *config.Config) error {
+	objects, err := overprovisionerObjects(cfg)
+	if err != nil {
+		return err
+	}
+	return kubernetes.Apply(client, "overprovisioner", objects)
+}
+
+// overprovisionerObjects renders the pause pods under a priority class below
+// every workload, so the scheduler preempts them first
+func overprovisionerObjects(cfg *config.Config) ([]map[string]interface{}, error) {
+	replicas, cpu, memory, err := OverprovisionerSize(cfg)
+	if err != nil {
+		return nil, err
+	}
+	resources := map[string]interface{}{
+		"cpu":    config.FormatCPU(cpu),
+		"memory": config.FormatMemory(memory),
+	}
+	labels := map[string]interface{}{"app": "overprovisioner"}
+
+	return []map[string]interface{}{
+		{
+			"apiVersion":    "scheduling.k8s.io/v1",
+			"kind":          "PriorityClass",
+			"metadata":      map[string]interface{}{"name": overprovisionerPriority},
+			"value":         -10,
+			"globalDefault": false,
+			"description":   "Pause pods reserving headroom, preempted by every workload",
+		},
+		{
+			"apiVersion": "v1",
+			"kind":       "Namespace",
+			"metadata":   map[string]interface{}{"name": overprovisionerNamespace},
+		},
+		{
+			"apiVersion": "apps/v1",
+			"kind":       "Deployment",
+			"metadata":   map[string]interface{}{"name": "overprovisioner", "namespace": overprovisionerNamespace, "labels": labels},
+			"spec": map[string]interface{}{
+				"replicas": replicas,
+				"selector": map[string]interface{}{"matchLabels": labels},
+				"template": map[string]interface{}{
+					"metadata": map[string]interface{}{"labels": labels},
+					"spec": map[string]interface{}{
+						"priorityClassName":             overprovisionerPriority,
+						"terminationGracePeriodSeconds": 0,
+						"containers": []map[string]interface{}{
+							{
+								"name":  "pause",
+								"image": "registry.k8s.io/pause:3.9",
+								"resources": map[string]interface{}{
+									"requests": resources,
+									"limits":   resources,
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}, nil
+}
+
+func inspectOverprovisioner(client ssh.Executor, cfg *config.Config, releases []helm.Release) error {
+	output, err := client.ExecuteCommand(fmt.Sprintf("kubectl get deployment overprovisioner -n %s -o jsonpath='{.spec.replicas}'", overprovisionerNamespace))
+	if err != nil {
+		return nil
+	}
+
+	cfg.Addons.Overprovisioner.Enabled = true
+	if replicas, err := strconv.Atoi(strings.TrimSpace(output)); err == nil && replicas > 1 {
+		cfg.Addons.Overprovisioner.Replicas = replicas
+	}
+	return nil
+}
@@ -0,0 +1,448 @@
+package addons
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/maarulav/k8s-setup/pkg/config"
+	"github.com/maarulav/k8s-setup/pkg/helm"
+	"github.com/maarulav/k8s-setup/pkg/kubernetes"
+	"github.com/maarulav/k8s-setup/pkg/ssh"
+)
+
+const (
+	kyvernoNamespace    = "kyverno"
+	gatekeeperNamespace = "gatekeeper-system"
+
+	policyDisallowPrivileged   = "disallow-privileged"
+	policyRequireResources     = "require-requests-limits"
+	policyRestrictRegistries   = "restrict-registries"
+	gatekeeperConstraintsGroup = "constraints.gatekeeper.sh"
+
+	// violatingPodName names the pods submitted by verifyPolicies. It must not
+	// contain a policy name, kubectl echoes it with every error.
+	violatingPodName = "k8s-setup-policy-check"
+)
+
+// starterPolicies lists the starter policies in the order they are applied
+var starterPolicies = []string{policyDisallowPrivileged, policyRequireResources, policyRestrictRegistries}
+
+func setupPolicy(client ssh.Executor, cfg *config.Config) error {
+	p := cfg.Addons.Policy
+
+	names, err := policyNames(p)
+	if err != nil {
+		return err
+	}
+	excluded := policyExcludedNamespaces(p)
+
+	switch policyEngine(p) {
+	case "kyverno":
+		err = helm.Install(client, helm.Chart{
+			Release:   "kyverno",
+			RepoURL:   "https://kyverno.github.io/kyverno/",
+			Chart:     "kyverno",
+			Version:   p.Version,
+			Namespace: kyvernoNamespace,
+		})
+		if err != nil {
+			return err
+		}
+		var objects []map[string]interface{}
+		for _, name := range names {
+			objects = append(objects, kyvernoPolicy(name, p, excluded))
+		}
+		// The admission webhook may take a moment to serve after the chart is up
+		if err := retry(func() error { return kubernetes.Apply(client, "kyverno-policies", objects) }); err != nil {
+			return err
+		}
+	case "gatekeeper":
+		err = helm.Install(client, helm.Chart{
+			Release:   "gatekeeper",
+			RepoURL:   "https://open-policy-agent.github.io/gatekeeper/charts",
+			Chart:     "gatekeeper",
+			Version:   p.Version,
+			Namespace: gatekeeperNamespace,
+		})
+		if err != nil {
+			return err
+		}
+		if err := setupGatekeeperPolicies(client, names, p, excluded); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown policy engine %q", p.Engine)
+	}
+
+	if policyAction(p) != "enforce" {
+		fmt.Println("Policies are in audit mode, skipping the enforcement check")
+		return nil
+	}
+	return verifyPolicies(client, names, p)
+}
+
+// setupGatekeeperPolicies applies the ConstraintTemplates, waits for
+// Gatekeeper to create the constraint CRDs and applies the constraints
+func setupGatekeeperPolicies(client ssh.Executor, names []string, p config.PolicyAddon, excluded []string) error {
+	var templates, constraints []map[string]interface{}
+	for _, name := range names {
+		template, constraint := gatekeeperPolicy(name, p, excluded)
+		templates = append(templates, template)
+		constraints = append(constraints, constraint)
+	}
+	if err := retry(func() error { return kubernetes.Apply(client, "gatekeeper-templates", templates) }); err != nil {
+		return err
+	}
+
+	for _, c := range constraints {
+		crd := fmt.Sprintf("crd/%s.%s", strings.ToLower(c["kind"].(string)), gatekeeperConstraintsGroup)
+		err := retry(func() error {
+			_, err := client.ExecuteCommand("kubectl wait --for=condition=established --timeout=10s " + crd)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("constraint CRD %s was not created: %v", crd, err)
+		}
+	}
+
+	return kubernetes.Apply(client, "gatekeeper-constraints", constraints)
+}
+
+// verifyPolicies submits a violating pod for every policy as a server-side
+// dry run and checks that the engine rejects it
+func verifyPolicies(client ssh.Executor, names []string, p config.PolicyAddon) error {
+	for _, name := range names {
+		pod := violatingPod(name, p)
+		err := retry(func() error {
+			output, err := kubernetes.DryRun(client, "policy-check-"+name, []map[string]interface{}{pod})
+			if err == nil {
+				return fmt.Errorf("violating pod was admitted")
+			}
+			if !deniedBy(output, name) {
+				return fmt.Errorf("pod was rejected for another reason: %s", strings.TrimSpace(output))
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("policy %s is not enforced: %v", name, err)
+		}
+		fmt.Printf("Policy %s is enforced\n", name)
+	}
+	return nil
+}
+
+// deniedBy reports whether kubectl output tells that an admission webhook
+// denied the request for the policy name. Kyverno lists the names of the
+// failed policies and Gatekeeper those of the constraints, which are named
+// after the policy.
+func deniedBy(output, name string) bool {
+	i := strings.Index(output, "denied the request")
+	return i >= 0 && strings.Contains(output[i:], name)
+}
+
+func policyEngine(p config.PolicyAddon) string {
+	if p.Engine == "" {
+		return "kyverno"
+	}
+	return p.Engine
+}
+
+func policyAction(p config.PolicyAddon) string {
+	if p.Action == "" {
+		return "enforce"
+	}
+	return p.Action
+}
+
+// policyNames returns the starter policies to apply. Without allowed
+// registries the registry policy is left out of the default set.
+func policyNames(p config.PolicyAddon) ([]string, error) {
+	if len(p.Policies) == 0 {
+		var names []string
+		for _, name := range starterPolicies {
+			if name == policyRestrictRegistries && len(p.AllowedRegistries) == 0 {
+				continue
+			}
+			names = append(names, name)
+		}
+		return names, nil
+	}
+
+	for _, name := range p.Policies {
+		switch name {
+		case policyDisallowPrivileged, policyRequireResources:
+		case policyRestrictRegistries:
+			if len(p.AllowedRegistries) == 0 {
+				return nil, fmt.Errorf("policy %s needs allowedRegistries", name)
+			}
+		default:
+			return nil, fmt.Errorf("unknown policy %q", name)
+		}
+	}
+	return p.Policies, nil
+}
+
+// policyExcludedNamespaces exempts the system namespaces and the ones the
+// tool installs into, whose components need privileges the policies deny
+func policyExcludedNamespaces(p config.PolicyAddon) []string {
+	namespaces := []string{
+		"kube-system", "kube-public", "kube-node-lease", "monitoring",
+		certManagerNamespace, externalDNSNamespace, registryNamespace, falcoNamespace,
+		externalSecretsNamespace, eventExporterNamespace, overprovisionerNamespace, vpaNamespace,
+		capsuleNamespace, kyvernoNamespace, gatekeeperNamespace, "security-scan", "velero",
+	}
+	return append(namespaces, p.ExcludeNamespaces...)
+}
+
+// kyvernoPolicy renders a starter policy as a Kyverno ClusterPolicy. Kyverno
+// generates the matching rules for the pod controllers.
+func kyvernoPolicy(name string, p config.PolicyAddon, excluded []string) map[string]interface{} {
+	var message string
+	var pattern map[string]interface{}
+
+	switch name {
+	case policyDisallowPrivileged:
+		message = "Privileged containers are not allowed."
+		notPrivileged := []map[string]interface{}{
+			{"=(securityContext)": map[string]interface{}{"=(privileged)": "false"}},
+		}
+		pattern = map[string]interface{}{
+			"spec": map[string]interface{}{
+				"=(ephemeralContainers)": notPrivileged,
+				"=(initContainers)":      notPrivileged,
+				"containers":             notPrivileged,
+			},
+		}
+	case policyRequireResources:
+		message = "CPU and memory requests and a memory limit are required."
+		pattern = map[string]interface{}{
+			"spec": map[string]interface{}{
+				"containers": []map[string]interface{}{
+					{
+						"resources": map[string]interface{}{
+							"requests": map[string]interface{}{"cpu": "?*", "memory": "?*"},
+							"limits":   map[string]interface{}{"memory": "?*"},
+						},
+					},
+				},
+			},
+		}
+	case policyRestrictRegistries:
+		message = "Images must come from " + strings.Join(p.AllowedRegistries, ", ") + "."
+		var images []string
+		for _, registry := range p.AllowedRegistries {
+			images = append(images, strings.TrimSuffix(registry, "/")+"/*")
+		}
+		allowed := []map[string]interface{}{{"image": strings.Join(images, " | ")}}
+		pattern = map[string]interface{}{
+			"spec": map[string]interface{}{
+				"=(ephemeralContainers)": allowed,
+				"=(initContainers)":      allowed,
+				"containers":             allowed,
+			},
+		}
+	}
+
+	action := "Enforce"
+	if policyAction(p) == "audit" {
+		action = "Audit"
+	}
+
+	return map[string]interface{}{
+		"apiVersion": "kyverno.io/v1",
+		"kind":       "ClusterPolicy",
+		"metadata":   map[string]interface{}{"name": name},
+		"spec": map[string]interface{}{
+			"validationFailureAction": action,
+			"background":              true,
+			"rules": []map[string]interface{}{
+				{
+					"name": name,
+					"match": map[string]interface{}{
+						"any": []map[string]interface{}{
+							{"resources": map[string]interface{}{"kinds": []string{"Pod"}}},
+						},
+					},
+					"exclude": map[string]interface{}{
+						"any": []map[string]interface{}{
+							{"resources": map[string]interface{}{"namespaces": excluded}},
+						},
+					},
+					"validate": map[string]interface{}{
+						"message": message,
+						"pattern": pattern,
+					},
+				},
+			},
+		},
+	}
+}
+
+// gatekeeperRego holds the Rego of the starter ConstraintTemplates, keyed by
+// the kind of their constraint
+var gatekeeperRego = map[string]string{
+	"K8sDisallowPrivileged": `package k8sdisallowprivileged
+
+violation[{"msg": msg}] {
+  c := input_containers[_]
+  c.securityContext.privileged
+  msg := sprintf("privileged container %v is not allowed", [c.name])
+}
+
+input_containers[c] { c := input.review.object.spec.containers[_] }
+input_containers[c] { c := input.review.object.spec.initContainers[_] }
+input_containers[c] { c := input.review.object.spec.ephemeralContainers[_] }
+`,
+	"K8sRequireRequestsLimits": `package k8srequirerequestslimits
+
+violation[{"msg": msg}] {
+  c := input.review.object.spec.containers[_]
+  not c.resources.requests.cpu
+  msg := sprintf("container %v has no CPU request", [c.name])
+}
+
+violation[{"msg": msg}] {
+  c := input.review.object.spec.containers[_]
+  not c.resources.requests.memory
+  msg := sprintf("container %v has no memory request", [c.name])
+}
+
+violation[{"msg": msg}] {
+  c := input.review.object.spec.containers[_]
+  not c.resources.limits.memory
+  msg := sprintf("container %v has no memory limit", [c.name])
+}
+`,
+	"K8sRestrictRegistries": `package k8srestrictregistries
+
+violation[{"msg": msg}] {
+  c := input_containers[_]
+  not allowed(c.image)
+  msg := sprintf("image %v of container %v is not from %v", [c.image, c.name, input.parameters.registries])
+}
+
+allowed(image) { startswith(image, input.parameters.registries[_]) }
+
+input_containers[c] { c := input.review.object.spec.containers[_] }
+input_containers[c] { c := input.review.object.spec.initContainers[_] }
+input_containers[c] { c := input.review.object.spec.ephemeralContainers[_] }
+`,
+}
+
+// gatekeeperPolicy renders a starter policy as a Gatekeeper ConstraintTemplate
+// and the constraint instantiating it
+func gatekeeperPolicy(name string, p config.PolicyAddon, excluded []string) (map[string]interface{}, map[string]interface{}) {
+	var kind string
+	var parameters, schema map[string]interface{}
+
+	switch name {
+	case policyDisallowPrivileged:
+		kind = "K8sDisallowPrivileged"
+	case policyRequireResources:
+		kind = "K8sRequireRequestsLimits"
+	case policyRestrictRegistries:
+		kind = "K8sRestrictRegistries"
+		var registries []string
+		for _, registry := range p.AllowedRegistries {
+			registries = append(registries, strings.TrimSuffix(registry, "/")+"/")
+		}
+		parameters = map[string]interface{}{"registries": registries}
+		schema = map[string]interface{}{
+			"openAPIV3Schema": map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"registries": map[string]interface{}{
+						"type":  "array",
+						"items": map[string]interface{}{"type": "string"},
+					},
+				},
+			},
+		}
+	}
+
+	crd := map[string]interface{}{"names": map[string]interface{}{"kind": kind}}
+	if schema != nil {
+		crd["validation"] = schema
+	}
+	template := map[string]interface{}{
+		"apiVersion": "templates.gatekeeper.sh/v1",
+		"kind":       "ConstraintTemplate",
+		"metadata":   map[string]interface{}{"name": strings.ToLower(kind)},
+		"spec": map[string]interface{}{
+			"crd": map[string]interface{}{"spec": crd},
+			"targets": []map[string]interface{}{
+				{"target": "admission.k8s.gatekeeper.sh", "rego": gatekeeperRego[kind]},
+			},
+		},
+	}
+
+	action := "deny"
+	if policyAction(p) == "audit" {
+		action = "dryrun"
+	}
+	spec := map[string]interface{}{
+		"enforcementAction": action,
+		"match": map[string]interface{}{
+			"kinds": []map[string]interface{}{
+				{"apiGroups": []string{""}, "kinds": []string{"Pod"}},
+			},
+			"excludedNamespaces": excluded,
+		},
+	}
+	if parameters != nil {
+		spec["parameters"] = parameters
+	}
+	constraint := map[string]interface{}{
+		"apiVersion": gatekeeperConstraintsGroup + "/v1beta1",
+		"kind":       kind,
+		"metadata":   map[string]interface{}{"name": name},
+		"spec":       spec,
+	}
+
+	return template, constraint
+}
+
+// violatingPod returns a pod in the default namespace that breaks only the
+// named policy, so that a rejection can be attributed to it
+func violatingPod(name string, p config.PolicyAddon) map[string]interface{} {
+	image := "nginx:stable-alpine"
+	if len(p.AllowedRegistries) > 0 {
+		image = strings.TrimSuffix(p.AllowedRegistries[0], "/") + "/nginx:stable-alpine"
+	}
+	container := map[string]interface{}{
+		"name":  "check",
+		"image": image,
+		"resources": map[string]interface{}{
+			"requests": map[string]interface{}{"cpu": "10m", "memory": "16Mi"},
+			"limits":   map[string]interface{}{"memory": "16Mi"},
+		},
+	}
+
+	switch name {
+	case policyDisallowPrivileged:
+		container["securityContext"] = map[string]interface{}{"privileged": true}
+	case policyRequireResources:
+		delete(container, "resources")
+	case policyRestrictRegistries:
+		container["image"] = "k8s-setup.invalid/policy-check:latest"
+	}
+
+	return map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Pod",
+		"metadata":   map[string]interface{}{"name": violatingPodName, "namespace": "default"},
+		"spec":       map[string]interface{}{"containers": []map[string]interface{}{container}},
+	}
+}
+
+func inspectPolicy(client ssh.Executor, cfg *config.Config, releases []helm.Release) error {
+	for _, engine := range []string{"kyverno", "gatekeeper"} {
+		if release, ok := findRelease(releases, engine); ok {
+			cfg.Addons.Policy.Enabled = true
+			cfg.Addons.Policy.Engine = engine
+			cfg.Addons.Policy.Version = release.ChartVersion()
+			return nil
+		}
+	}
+	return nil
+}
@@ -0,0 +1,266 @@
+package addons
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/maarulav/k8s-setup/pkg/config"
+	"github.com/maarulav/k8s-setup/pkg/helm"
+	"github.com/maarulav/k8s-setup/pkg/kube"
+	"github.com/maarulav/k8s-setup/pkg/kubernetes"
+	"github.com/maarulav/k8s-setup/pkg/ssh"
+)
+
+const (
+	registryNamespace       = "registry"
+	defaultRegistryNodePort = 30500
+	defaultRegistryStorage  = "20Gi"
+)
+
+func setupRegistry(client ssh.Executor, cfg *config.Config) error {
+	if cfg.Addons.Registry.Type == "harbor" {
+		return setupHarbor(client, cfg.Addons.Registry)
+	}
+	return setupPlainRegistry(client, cfg.Addons.Registry)
+}
+
+// setupHarbor installs Harbor from its Helm chart
+func setupHarbor(client ssh.Executor, r config.RegistryAddon) error {
+	expose := map[string]interface{}{}
+	if r.Host != "" {
+		ingress := map[string]interface{}{
+			"hosts": map[string]interface{}{"core": r.Host},
+		}
+		if r.IngressClass != "" {
+			ingress["className"] = r.IngressClass
+		}
+		if r.ClusterIssuer != "" {
+			ingress["annotations"] = map[string]interface{}{
+				"cert-manager.io/cluster-issuer": r.ClusterIssuer,
+			}
+		}
+		expose["type"] = "ingress"
+		expose["ingress"] = ingress
+		expose["tls"] = map[string]interface{}{
+			"enabled":    true,
+			"certSource": "secret",
+			"secret":     map[string]interface{}{"secretName": "harbor-tls"},
+		}
+	} else {
+		expose["type"] = "nodePort"
+		expose["tls"] = map[string]interface{}{"enabled": false}
+		expose["nodePort"] = map[string]interface{}{
+			"ports": map[string]interface{}{
+				"http": map[string]interface{}{"nodePort": registryNodePort(r)},
+			},
+		}
+	}
+
+	registryPVC := map[string]interface{}{"size": registryStorage(r)}
+	if r.StorageClass != "" {
+		registryPVC["storageClass"] = r.StorageClass
+	}
+
+	values := map[string]interface{}{
+		"expose": expose,
+		"persistence": map[string]interface{}{
+			"enabled": true,
+			"persistentVolumeClaim": map[string]interface{}{
+				"registry": registryPVC,
+			},
+		},
+	}
+	if r.Host != "" {
+		values["externalURL"] = "https://" + r.Host
+	}
+	if r.AdminPassword != "" {
+		values["harborAdminPassword"] = r.AdminPassword
+	}
+
+	return helm.Install(client, helm.Chart{
+		Release:   "harbor",
+		RepoURL:   "https://helm.goharbor.io",
+		Chart:     "harbor",
+		Namespace: registryNamespace,
+		Values:    values,
+	})
+}
+
+// setupPlainRegistry deploys registry:2 backed by a PersistentVolumeClaim
+func setupPlainRegistry(client ssh.Executor, r config.RegistryAddon) error {
+	labels := map[string]interface{}{"app": "registry"}
+	meta := func(name string) map[string]interface{} {
+		return map[string]interface{}{"name": name, "namespace": registryNamespace, "labels": labels}
+	}
+
+	pvcSpec := map[string]interface{}{
+		"accessModes": []string{"ReadWriteOnce"},
+		"resources": map[string]interface{}{
+			"requests": map[string]interface{}{"storage": registryStorage(r)},
+		},
+	}
+	if r.StorageClass != "" {
+		pvcSpec["storageClassName"] = r.StorageClass
+	}
+
+	service := map[string]interface{}{
+		"selector": labels,
+		"ports": []map[string]interface{}{
+			{"name": "http", "port": 5000, "targetPort": 5000},
+		},
+	}
+	if r.Host == "" {
+		service["type"] = "NodePort"
+		service["ports"] = []map[string]interface{}{
+			{"name": "http", "port": 5000, "targetPort": 5000, "nodePort": registryNodePort(r)},
+		}
+	}
+
+	objects := []map[string]interface{}{
+		{
+			"apiVersion": "v1",
+			"kind":       "Namespace",
+			"metadata":   map[string]interface{}{"name": registryNamespace},
+		},
+		{
+			"apiVersion": "v1",
+			"kind":       "PersistentVolumeClaim",
+			"metadata":   meta("registry-data"),
+			"spec":       pvcSpec,
+		},
+		{
+			"apiVersion": "apps/v1",
+			"kind":       "Deployment",
+			"metadata":   meta("registry"),
+			"spec": map[string]interface{}{
+				"replicas": 1,
+				"strategy": map[string]interface{}{"type": "Recreate"},
+				"selector": map[string]interface{}{"matchLabels": labels},
+				"template": map[string]interface{}{
+					"metadata": map[string]interface{}{"labels": labels},
+					"spec": map[string]interface{}{
+						"containers": []map[string]interface{}{
+							{
+								"name":  "registry",
+								"image": "registry:2",
+								"ports": []map[string]interface{}{{"containerPort": 5000}},
+								"env": []map[string]interface{}{
+									{"name": "REGISTRY_STORAGE_DELETE_ENABLED", "value": "true"},
+								},
+								"volumeMounts": []map[string]interface{}{
+									{"name": "data", "mountPath": "/var/lib/registry"},
+								},
+							},
+						},
+						"volumes": []map[string]interface{}{
+							{"name": "data", "persistentVolumeClaim": map[string]interface{}{"claimName": "registry-data"}},
+						},
+					},
+				},
+			},
+		},
+		{
+			"apiVersion": "v1",
+			"kind":       "Service",
+			"metadata":   meta("registry"),
+			"spec":       service,
+		},
+	}
+
+	if r.Host != "" {
+		ingressMeta := meta("registry")
+		annotations := map[string]interface{}{
+			"nginx.ingress.kubernetes.io/proxy-body-size": "0",
+		}
+		if r.ClusterIssuer != "" {
+			annotations["cert-manager.io/cluster-issuer"] = r.ClusterIssuer
+		}
+		ingressMeta["annotations"] = annotations
+
+		spec := map[string]interface{}{
+			"tls": []map[string]interface{}{
+				{"hosts": []string{r.Host}, "secretName": "registry-tls"},
+			},
+			"rules": []map[string]interface{}{
+				{
+					"host": r.Host,
+					"http": map[string]interface{}{
+						"paths": []map[string]interface{}{
+							{
+								"path":     "/",
+								"pathType": "Prefix",
+								"backend": map[string]interface{}{
+									"service": map[string]interface{}{
+										"name": "registry",
+										"port": map[string]interface{}{"number": 5000},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		}
+		if r.IngressClass != "" {
+			spec["ingressClassName"] = r.IngressClass
+		}
+
+		objects = append(objects, map[string]interface{}{
+			"apiVersion": "networking.k8s.io/v1",
+			"kind":       "Ingress",
+			"metadata":   ingressMeta,
+			"spec":       spec,
+		})
+	}
+
+	if err := kubernetes.Apply(client, "registry", objects); err != nil {
+		return err
+	}
+
+	kc, err := kube.NewClient(client)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), kube.DefaultTimeout)
+	defer cancel()
+	if err := kube.WaitForDeploymentAvailable(ctx, kc, registryNamespace, "registry", kube.Print); err != nil {
+		return fmt.Errorf("registry did not become ready: %v", err)
+	}
+
+	return nil
+}
+
+func inspectRegistry(client ssh.Executor, cfg *config.Config, releases []helm.Release) error {
+	if _, ok := findRelease(releases, "harbor"); ok {
+		cfg.Addons.Registry.Enabled = true
+		cfg.Addons.Registry.Type = "harbor"
+		return nil
+	}
+
+	if _, err := client.ExecuteCommand(fmt.Sprintf("kubectl get deployment registry -n %s", registryNamespace)); err != nil {
+		return nil
+	}
+	cfg.Addons.Registry.Enabled = true
+	cfg.Addons.Registry.Type = "registry"
+
+	host, err := client.ExecuteCommand(fmt.Sprintf("kubectl get ingress registry -n %s -o jsonpath='{.spec.rules[0].host}'", registryNamespace))
+	if err == nil {
+		cfg.Addons.Registry.Host = strings.TrimSpace(host)
+	}
+	return nil
+}
+
+func registryNodePort(r config.RegistryAddon) int {
+	if r.NodePort != 0 {
+		return r.NodePort
+	}
+	return defaultRegistryNodePort
+}
+
+func registryStorage(r config.RegistryAddon) string {
+	if r.StorageSize != "" {
+		return r.StorageSize
+	}
+	return defaultRegistryStorage
+}
@@ -0,0 +1,150 @@
+package addons
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"github.com/maarulav/k8s-setup/pkg/config"
+	"github.com/maarulav/k8s-setup/pkg/helm"
+	"github.com/maarulav/k8s-setup/pkg/kubernetes"
+	"github.com/maarulav/k8s-setup/pkg/ssh"
+)
+
+const (
+	externalSecretsNamespace = "external-secrets"
+
+	// sealedSecretsController is the name kubeseal looks for in kube-system
+	sealedSecretsController = "sealed-secrets-controller"
+)
+
+// SealedSecrets reports whether the configuration installs sealed-secrets
+func SealedSecrets(cfg *config.Config) bool {
+	s := cfg.Addons.Secrets
+	return s.Enabled && (s.Type == "" || s.Type == "sealed-secrets")
+}
+
+func setupSecrets(client ssh.Executor, cfg *config.Config) error {
+	s := cfg.Addons.Secrets
+	switch {
+	case SealedSecrets(cfg):
+		return helm.Install(client, helm.Chart{
+			Release:   sealedSecretsController,
+			RepoURL:   "https://bitnami-labs.github.io/sealed-secrets",
+			Chart:     "sealed-secrets",
+			Version:   s.Version,
+			Namespace: "kube-system",
+			Values: map[string]interface{}{
+				"fullnameOverride": sealedSecretsController,
+			},
+		})
+	case s.Type == "external-secrets":
+		return setupExternalSecrets(client, s)
+	default:
+		return fmt.Errorf("unknown secrets type %q", s.Type)
+	}
+}
+
+// setupExternalSecrets installs External Secrets Operator and, when a
+// provider is configured, a ClusterSecretStore that has to become ready
+func setupExternalSecrets(client ssh.Executor, s config.SecretsAddon) error {
+	if err := helm.Install(client, helm.Chart{
+		Release:   "external-secrets",
+		RepoURL:   "https://charts.external-secrets.io",
+		Chart:     "external-secrets",
+		Version:   s.Version,
+		Namespace: externalSecretsNamespace,
+		Values: map[string]interface{}{
+			"installCRDs": true,
+		},
+	}); err != nil {
+		return err
+	}
+
+	if len(s.Store.Provider) == 0 {
+		return nil
+	}
+
+	objects := secretStoreObjects(s.Store)
+	// The webhook validating the store may take a moment to serve
+	if err := retry(func() error { return kubernetes.Apply(client, "cluster-secret-store", objects) }); err != nil {
+		return err
+	}
+
+	cmd := fmt.Sprintf("kubectl wait --for=condition=Ready clustersecretstore/%s --timeout=120s", secretStoreName(s.Store))
+	if output, err := client.ExecuteCommand(cmd); err != nil {
+		return fmt.Errorf("secret store did not become ready: %v\nOutput: %s", err, output)
+	}
+	return nil
+}
+
+func secretStoreName(store config.SecretStore) string {
+	if store.Name == "" {
+		return "default"
+	}
+	return store.Name
+}
+
+// secretStoreObjects renders the ClusterSecretStore and its credential Secret
+func secretStoreObjects(store config.SecretStore) []map[string]interface{} {
+	name := secretStoreName(store)
+
+	var objects []map[string]interface{}
+	if len(store.Credentials) > 0 {
+		objects = append(objects, map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "Secret",
+			"metadata":   map[string]interface{}{"name": name + "-credentials", "namespace": externalSecretsNamespace},
+			"type":       "Opaque",
+			"stringData": store.Credentials,
+		})
+	}
+
+	// v1 is served from chart 0.16 on, releases since 0.17 dropped v1beta1
+	return append(objects, map[string]interface{}{
+		"apiVersion": "external-secrets.io/v1",
+		"kind":       "ClusterSecretStore",
+		"metadata":   map[string]interface{}{"name": name},
+		"spec":       map[string]interface{}{"provider": store.Provider},
+	})
+}
+
+// SealedSecretsCert returns the public certificate of the sealed-secrets
+// controller, which kubeseal needs to seal secrets offline
+func SealedSecretsCert(client ssh.Executor) ([]byte, error) {
+	cmd := "kubectl get secret -n kube-system -l sealedsecrets.bitnami.com/sealed-secrets-key=active -o jsonpath='{.items[0].data.tls\\.crt}'"
+
+	// The controller generates its key pair after it starts
+	var encoded string
+	err := retry(func() error {
+		output, err := client.ExecuteCommand(cmd)
+		if err != nil {
+			return fmt.Errorf("%v\nOutput: %s", err, output)
+		}
+		if encoded = strings.TrimSpace(output); encoded == "" {
+			return fmt.Errorf("no active sealing key")
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read sealed-secrets certificate: %v", err)
+	}
+
+	cert, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode sealed-secrets certificate: %v", err)
+	}
+	return cert, nil
+}
+
+func inspectSecrets(client ssh.Executor, cfg *config.Config, releases []helm.Release) error {
+	for _, chart := range []string{"sealed-secrets", "external-secrets"} {
+		if release, ok := findRelease(releases, chart); ok {
+			cfg.Addons.Secrets.Enabled = true
+			cfg.Addons.Secrets.Type = chart
+			cfg.Addons.Secrets.Version = release.ChartVersion()
+			return nil
+		}
+	}
+	return nil
+}
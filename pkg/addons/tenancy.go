@@ -0,0 +1,332 @@
+package addons
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/maarulav/k8s-setup/pkg/config"
+	"github.com/maarulav/k8s-setup/pkg/helm"
+	"github.com/maarulav/k8s-setup/pkg/kubernetes"
+	"github.com/maarulav/k8s-setup/pkg/ssh"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+const (
+	capsuleNamespace  = "capsule-system"
+	capsuleAPIVersion = "capsule.clastix.io/v1beta2"
+	// defaultCapsuleVersion is the Capsule chart installed unless a version
+	// is configured
+	defaultCapsuleVersion = "0.7.2"
+
+	// vclusterNamespacePrefix prefixes the host namespace of each virtual cluster
+	vclusterNamespacePrefix = "vcluster-"
+	// defaultVClusterVersion is the vcluster chart installed unless a version
+	// is configured. Its values follow the schema introduced with 0.20.
+	defaultVClusterVersion = "0.20.0"
+)
+
+func setupTenancy(client ssh.Executor, cfg *config.Config) error {
+	t := cfg.Addons.Tenancy
+	if err := validateTenants(t); err != nil {
+		return err
+	}
+
+	switch tenancyType(t) {
+	case "capsule":
+		return setupCapsule(client, t)
+	case "vcluster":
+		return setupVClusters(client, t)
+	default:
+		return fmt.Errorf("unknown tenancy type %q", t.Type)
+	}
+}
+
+// validateTenants checks that every tenant has an owner and that its name
+// and namespaces are valid namespace names. The host namespace of a virtual
+// cluster is prefixed, so it must fit as well.
+func validateTenants(t config.TenancyAddon) error {
+	for _, tenant := range t.Tenants {
+		if tenant.Name == "" || len(tenant.Owners) == 0 {
+			return fmt.Errorf("tenants need a name and at least one owner")
+		}
+		names := []string{tenant.Name}
+		if tenancyType(t) == "vcluster" {
+			names = append(names, vclusterNamespacePrefix+tenant.Name)
+		}
+		for _, name := range append(names, tenant.Namespaces...) {
+			if errs := validation.IsDNS1123Label(name); len(errs) > 0 {
+				return fmt.Errorf("tenant %s: invalid name %q: %s", tenant.Name, name, strings.Join(errs, ", "))
+			}
+		}
+	}
+	return nil
+}
+
+// tenancyType returns the configured type, capsule by default
+func tenancyType(t config.TenancyAddon) string {
+	if t.Type == "" {
+		return "capsule"
+	}
+	return t.Type
+}
+
+// setupCapsule installs Capsule, creates a Tenant per configured tenant and
+// assigns the tenant's namespaces to it
+func setupCapsule(client ssh.Executor, t config.TenancyAddon) error {
+	if err := helm.Install(client, helm.Chart{
+		Release:   "capsule",
+		RepoURL:   "https://projectcapsule.github.io/charts",
+		Chart:     "capsule",
+		Version:   capsuleVersion(t),
+		Namespace: capsuleNamespace,
+	}); err != nil {
+		return err
+	}
+
+	var tenants []map[string]interface{}
+	for _, tenant := range t.Tenants {
+		object, err := capsuleTenant(tenant)
+		if err != nil {
+			return fmt.Errorf("tenant %s: %v", tenant.Name, err)
+		}
+		tenants = append(tenants, object)
+	}
+	if len(tenants) == 0 {
+		return nil
+	}
+	// The Tenant CRD and webhook of a fresh install take a moment to be served
+	if err := retry(func() error { return kubernetes.Apply(client, "capsule-tenants", tenants) }); err != nil {
+		return err
+	}
+
+	// Namespaces created by an administrator belong to a tenant through an
+	// owner reference, which needs the UID of the Tenant
+	var namespaces []map[string]interface{}
+	for _, tenant := range t.Tenants {
+		if len(tenant.Namespaces) == 0 {
+			continue
+		}
+		output, err := client.ExecuteCommand(fmt.Sprintf("kubectl get tenants.capsule.clastix.io %s -o jsonpath='{.metadata.uid}'", tenant.Name))
+		if err != nil {
+			return fmt.Errorf("failed to read tenant %s: %v\nOutput: %s", tenant.Name, err, output)
+		}
+		for _, ns := range tenant.Namespaces {
+			namespaces = append(namespaces, tenantNamespace(ns, tenant.Name, strings.TrimSpace(output)))
+		}
+	}
+	if len(namespaces) == 0 {
+		return nil
+	}
+	return kubernetes.Apply(client, "capsule-namespaces", namespaces)
+}
+
+// capsuleTenant renders the Capsule Tenant of a tenant, with a quota over
+// all its namespaces when one is configured
+func capsuleTenant(tenant config.Tenant) (map[string]interface{}, error) {
+	var owners []map[string]interface{}
+	for _, owner := range tenant.Owners {
+		kind, name := tenantOwner(owner)
+		owners = append(owners, map[string]interface{}{"kind": kind, "name": name})
+	}
+
+	spec := map[string]interface{}{"owners": owners}
+	hard, err := tenantQuota(tenant)
+	if err != nil {
+		return nil, err
+	}
+	if len(hard) > 0 {
+		spec["resourceQuotas"] = map[string]interface{}{
+			"scope": "Tenant",
+			"items": []map[string]interface{}{{"hard": hard}},
+		}
+	}
+
+	return map[string]interface{}{
+		"apiVersion": capsuleAPIVersion,
+		"kind":       "Tenant",
+		"metadata":   map[string]interface{}{"name": tenant.Name},
+		"spec":       spec,
+	}, nil
+}
+
+// tenantOwner returns the RBAC subject kind and name of an owner entry
+func tenantOwner(owner string) (string, string) {
+	if name := strings.TrimPrefix(owner, "group:"); name != owner {
+		return "Group", name
+	}
+	return "User", owner
+}
+
+// tenantNamespace renders a namespace owned by the Tenant with uid
+func tenantNamespace(name, tenant, uid string) map[string]interface{} {
+	return map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Namespace",
+		"metadata": map[string]interface{}{
+			"name": name,
+			"ownerReferences": []map[string]interface{}{
+				{
+					"apiVersion":         capsuleAPIVersion,
+					"kind":               "Tenant",
+					"name":               tenant,
+					"uid":                uid,
+					"controller":         true,
+					"blockOwnerDeletion": true,
+				},
+			},
+		},
+	}
+}
+
+// tenantQuota returns the ResourceQuota limits of a tenant, empty when it
+// has none
+func tenantQuota(tenant config.Tenant) (map[string]interface{}, error) {
+	hard := map[string]interface{}{}
+	if tenant.CPU != "" {
+		cpu, err := config.ParseCPU(tenant.CPU)
+		if err != nil {
+			return nil, err
+		}
+		hard["requests.cpu"] = config.FormatCPU(cpu)
+		hard["limits.cpu"] = config.FormatCPU(cpu)
+	}
+	if tenant.Memory != "" {
+		memory, err := config.ParseMemory(tenant.Memory)
+		if err != nil {
+			return nil, err
+		}
+		hard["requests.memory"] = config.FormatMemory(memory)
+		hard["limits.memory"] = config.FormatMemory(memory)
+	}
+	if tenant.Pods > 0 {
+		hard["pods"] = fmt.Sprint(tenant.Pods)
+	}
+	return hard, nil
+}
+
+// setupVClusters installs a virtual cluster per tenant into its own host
+// namespace and lets the owners administer that namespace, which holds the
+// kubeconfig of the virtual cluster
+func setupVClusters(client ssh.Executor, t config.TenancyAddon) error {
+	var bindings []map[string]interface{}
+	for _, tenant := range t.Tenants {
+		values, err := vclusterValues(tenant)
+		if err != nil {
+			return fmt.Errorf("tenant %s: %v", tenant.Name, err)
+		}
+		if err := helm.Install(client, helm.Chart{
+			Release:   tenant.Name,
+			RepoURL:   "https://charts.loft.sh",
+			Chart:     "vcluster",
+			Version:   vclusterVersion(t),
+			Namespace: vclusterNamespacePrefix + tenant.Name,
+			Values:    values,
+		}); err != nil {
+			return err
+		}
+		bindings = append(bindings, tenantAdminBinding(tenant))
+	}
+	if len(bindings) == 0 {
+		return nil
+	}
+	return kubernetes.Apply(client, "vcluster-owners", bindings)
+}
+
+// capsuleVersion returns the configured Capsule chart version, the pinned
+// default otherwise
+func capsuleVersion(t config.TenancyAddon) string {
+	if t.Version == "" {
+		return defaultCapsuleVersion
+	}
+	return t.Version
+}
+
+// vclusterVersion returns the configured vcluster chart version, the pinned
+// default otherwise
+func vclusterVersion(t config.TenancyAddon) string {
+	if t.Version == "" {
+		return defaultVClusterVersion
+	}
+	return t.Version
+}
+
+// vclusterValues isolates the virtual cluster of a tenant, caps it with the
+// tenant's quota and creates the tenant's namespaces inside it
+func vclusterValues(tenant config.Tenant) (map[string]interface{}, error) {
+	hard, err := tenantQuota(tenant)
+	if err != nil {
+		return nil, err
+	}
+
+	// Isolation is a set of policies applied in the host namespace: pod
+	// security, a limit range, network policies and the quota
+	quota := map[string]interface{}{"enabled": true}
+	if len(hard) > 0 {
+		quota["quota"] = hard
+	}
+	values := map[string]interface{}{
+		"policies": map[string]interface{}{
+			"podSecurityStandard": "baseline",
+			"resourceQuota":       quota,
+			"limitRange":          map[string]interface{}{"enabled": true},
+			"networkPolicy":       map[string]interface{}{"enabled": true},
+		},
+	}
+
+	var manifests []string
+	for _, ns := range tenant.Namespaces {
+		manifests = append(manifests, fmt.Sprintf("apiVersion: v1\nkind: Namespace\nmetadata:\n  name: %s\n", ns))
+	}
+	if len(manifests) > 0 {
+		values["experimental"] = map[string]interface{}{
+			"deploy": map[string]interface{}{
+				"vcluster": map[string]interface{}{"manifests": strings.Join(manifests, "---\n")},
+			},
+		}
+	}
+	return values, nil
+}
+
+// tenantAdminBinding grants the owners of a tenant the admin role in the
+// host namespace of its virtual cluster
+func tenantAdminBinding(tenant config.Tenant) map[string]interface{} {
+	var subjects []map[string]interface{}
+	for _, owner := range tenant.Owners {
+		kind, name := tenantOwner(owner)
+		subjects = append(subjects, map[string]interface{}{
+			"apiGroup": "rbac.authorization.k8s.io",
+			"kind":     kind,
+			"name":     name,
+		})
+	}
+
+	return map[string]interface{}{
+		"apiVersion": "rbac.authorization.k8s.io/v1",
+		"kind":       "RoleBinding",
+		"metadata": map[string]interface{}{
+			"name":      "tenant-owners",
+			"namespace": vclusterNamespacePrefix + tenant.Name,
+		},
+		"roleRef": map[string]interface{}{
+			"apiGroup": "rbac.authorization.k8s.io",
+			"kind":     "ClusterRole",
+			"name":     "admin",
+		},
+		"subjects": subjects,
+	}
+}
+
+func inspectTenancy(client ssh.Executor, cfg *config.Config, releases []helm.Release) error {
+	if release, ok := findRelease(releases, "capsule"); ok {
+		cfg.Addons.Tenancy.Enabled = true
+		cfg.Addons.Tenancy.Type = "capsule"
+		cfg.Addons.Tenancy.Version = release.ChartVersion()
+		return nil
+	}
+	if release, ok := findRelease(releases, "vcluster"); ok {
+		cfg.Addons.Tenancy.Enabled = true
+		cfg.Addons.Tenancy.Type = "vcluster"
+		cfg.Addons.Tenancy.Version = release.ChartVersion()
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package addons
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/maarulav/k8s-setup/pkg/config"
+	"github.com/maarulav/k8s-setup/pkg/helm"
+	"github.com/maarulav/k8s-setup/pkg/kubernetes"
+	"github.com/maarulav/k8s-setup/pkg/ssh"
+)
+
+const vpaNamespace = "vpa"
+
+func setupVPA(client ssh.Executor, cfg *config.Config) error {
+	v := cfg.Addons.VPA
+	full, err := vpaFull(v)
+	if err != nil {
+		return err
+	}
+
+	if err := helm.Install(client, helm.Chart{
+		Release:   "vpa",
+		RepoURL:   "https://charts.fairwinds.com/stable",
+		Chart:     "vpa",
+		Version:   v.Version,
+		Namespace: vpaNamespace,
+		Values: map[string]interface{}{
+			"recommender":         map[string]interface{}{"enabled": true},
+			"updater":             map[string]interface{}{"enabled": full},
+			"admissionController": map[string]interface{}{"enabled": full},
+		},
+	}); err != nil {
+		return err
+	}
+
+	var objects []map[string]interface{}
+	for _, ns := range v.Namespaces {
+		output, err := client.ExecuteCommand(fmt.Sprintf("kubectl get deployments,statefulsets,daemonsets -n %s -o jsonpath='{range .items[*]}{.kind}/{.metadata.name}{\"\\n\"}{end}'", ns))
+		if err != nil {
+			return fmt.Errorf("failed to list workloads in %s: %v\nOutput: %s", ns, err, output)
+		}
+		objects = append(objects, vpaObjects(ns, strings.Fields(output), full)...)
+	}
+	if len(objects) == 0 {
+		return nil
+	}
+
+	// The CRDs of a fresh install take a moment to be served
+	return retry(func() error { return kubernetes.Apply(client, "vpa-targets", objects) })
+}
+
+// vpaFull reports whether the VPA applies its recommendations
+func vpaFull(v config.VPAAddon) (bool, error) {
+	switch v.Mode {
+	case "", "recommender":
+		return false, nil
+	case "full":
+		return true, nil
+	default:
+		return false, fmt.Errorf("unknown vpa mode %q", v.Mode)
+	}
+}
+
+// vpaObjects renders a VerticalPodAutoscaler for each kind/name workload.
+// They only record recommendations unless the full VPA is installed.
+func vpaObjects(namespace string, workloads []string, full bool) []map[string]interface{} {
+	mode := "Off"
+	if full {
+		mode = "Auto"
+	}
+
+	var objects []map[string]interface{}
+	for _, workload := range workloads {
+		parts := strings.SplitN(workload, "/", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		objects = append(objects, map[string]interface{}{
+			"apiVersion": "autoscaling.k8s.io/v1",
+			"kind":       "VerticalPodAutoscaler",
+			"metadata":   map[string]interface{}{"name": parts[1], "namespace": namespace},
+			"spec": map[string]interface{}{
+				"targetRef": map[string]interface{}{
+					"apiVersion": "apps/v1",
+					"kind":       parts[0],
+					"name":       parts[1],
+				},
+				"updatePolicy": map[string]interface{}{"updateMode": mode},
+			},
+		})
+	}
+	return objects
+}
+
+func inspectVPA(client ssh.Executor, cfg *config.Config, releases []helm.Release) error {
+	release, ok := findRelease(releases, "vpa")
+	if !ok {
+		return nil
+	}
+
+	cfg.Addons.VPA.Enabled = true
+	cfg.Addons.VPA.Version = release.ChartVersion()
+	if values, err := helm.Values(client, release.Name, release.Namespace); err == nil {
+		if updater, ok := values["updater"].(map[string]interface{}); ok && updater["enabled"] == true {
+			cfg.Addons.VPA.Mode = "full"
+		}
+	}
+	return nil
+}
@@ -1,27 +1,341 @@
 package backup
 
 import (
+	"encoding/json"
 	"fmt"
+	"strings"
+	"time"
 
+	"github.com/maarulav/k8s-setup/pkg/config"
+	"github.com/maarulav/k8s-setup/pkg/kubernetes"
 	"github.com/maarulav/k8s-setup/pkg/ssh"
+	"k8s.io/apimachinery/pkg/util/validation"
 )
 
-// Create creates a backup of the Kubernetes cluster
-func Create(client *ssh.Client) error {
-	backupDir := "/root/k8s-backup"
+const backupDir = "/root/k8s-backup"
+
+// archiveDir keeps the archives taken before destructive operations, outside
+// of backupDir so later backups do not include them
+const archiveDir = "/root/k8s-backups"
+
+// namespacedKinds are the resource kinds dumped per namespace for restores
+var namespacedKinds = []string{"serviceaccounts", "configmaps", "secrets", "persistentvolumeclaims", "services", "deployments", "statefulsets", "daemonsets", "cronjobs", "ingresses"}
+
+// clusterKinds are the cluster-scoped resource kinds dumped into cluster.json,
+// which the namespaced resources depend on
+var clusterKinds = []string{"customresourcedefinitions", "clusterroles", "clusterrolebindings", "storageclasses", "ingressclasses", "priorityclasses"}
+
+// Location is where one kind of backup of a cluster is kept
+type Location struct {
+	Kind string
+	Path string
+}
+
+// Locations lists where the backups of the cluster with control plane ip
+// are kept, for handover documents
+func Locations(cfg *config.Config, ip string) []Location {
+	locations := []Location{
+		{"Resource backups", fmt.Sprintf("%s:%s", ip, backupDir)},
+		{"Archives before destructive operations", fmt.Sprintf("%s:%s", ip, archiveDir)},
+	}
+	if v := cfg.Backup.Volumes; v.Enabled {
+		path := "s3://" + v.Bucket
+		if v.Prefix != "" {
+			path += "/" + v.Prefix
+		}
+		if v.Endpoint != "" {
+			path += " at " + v.Endpoint
+		}
+		locations = append(locations, Location{"Volume backups (Velero)", path})
+	}
+	return locations
+}
+
+// Create backs up the resources selected by the backup scope of config, one
+// file per namespace, and archives them. With volume backups enabled, Velero
+// then copies the PersistentVolume data of the same namespaces.
+func Create(client ssh.Executor, config *config.Config) error {
+	_, err := create(client, config)
+	return err
+}
+
+// create runs Create and returns the location of the archive it wrote
+func create(client ssh.Executor, config *config.Config) (string, error) {
+	kinds, err := scopeKinds(config.Backup)
+	if err != nil {
+		return "", err
+	}
+	for _, ns := range append(append([]string{}, config.Backup.Namespaces...), config.Backup.ExcludeNamespaces...) {
+		if errs := validation.IsDNS1123Label(ns); len(errs) > 0 {
+			return "", fmt.Errorf("invalid backup namespace %q: %s", ns, strings.Join(errs, ", "))
+		}
+	}
+
+	selector := ""
+	if config.Backup.Selector != "" {
+		selector = " -l " + ssh.Quote(config.Backup.Selector)
+	}
+
 	commands := []string{
-		fmt.Sprintf("mkdir -p %s", backupDir),
-		fmt.Sprintf("kubectl get all -A -o yaml > %s/all-resources.yaml", backupDir),
-		fmt.Sprintf("kubectl get configmaps -A -o yaml > %s/configmaps.yaml", backupDir),
-		fmt.Sprintf("kubectl get secrets -A -o yaml > %s/secrets.yaml", backupDir),
-		fmt.Sprintf("tar -czf %s/k8s-backup.tar.gz %s", backupDir, backupDir),
+		// Namespaces that left the scope must not linger from earlier backups
+		fmt.Sprintf("rm -rf %s/namespaces %s/cluster.json && mkdir -p %s/namespaces", backupDir, backupDir, backupDir),
+		fmt.Sprintf(`for ns in %s; do kubectl get %s -n "$ns"%s -o json > "%s/namespaces/$ns.json"; done`, scopeNamespaces(config.Backup), ssh.Quote(strings.Join(kinds, ",")), selector, backupDir),
+		// The cluster version tells backup list which release a backup came from
+		fmt.Sprintf("kubectl version -o json > %s/version.json", backupDir),
+	}
+	if cluster := excludeKinds(clusterKinds, config.Backup); len(cluster) > 0 {
+		commands = append(commands, fmt.Sprintf("kubectl get %s%s -o json > %s/cluster.json", ssh.Quote(strings.Join(cluster, ",")), selector, backupDir))
+	}
+
+	archive := backupDir + "/k8s-backup.tar.gz"
+	if config.Backup.Incremental {
+		archive = fmt.Sprintf("%s/increments/%s.tar.gz", backupDir, now().UTC().Format("20060102-150405"))
+		commands = append(commands, incrementalArchive(archive))
+	} else {
+		// Increments left from incremental backups are not part of the dump
+		commands = append(commands, fmt.Sprintf("tar -czf %s --exclude=increments %s", archive, backupDir))
 	}
 
 	for _, cmd := range commands {
 		if _, err := client.ExecuteCommand(cmd); err != nil {
-			return fmt.Errorf("backup failed: %v", err)
+			return "", fmt.Errorf("backup failed: %v", err)
+		}
+	}
+
+	if config.Backup.Volumes.Enabled {
+		if err := backupVolumes(client, config); err != nil {
+			return "", err
+		}
+	}
+	return archive, nil
+}
+
+// incrementalArchive archives only the namespace dumps whose checksum changed
+// since the last backup, together with the checksums of the full dump. The
+// dumps in backupDir stay complete, so restores do not need to replay the
+// increments.
+func incrementalArchive(archive string) string {
+	return strings.Join([]string{
+		"cd " + backupDir,
+		"sha256sum namespaces/*.json $(ls cluster.json 2>/dev/null) > checksums.new",
+		"touch checksums",
+		"{ grep -vxFf checksums checksums.new || true; } | awk '{print $2}' > changed",
+		"echo version.json >> changed",
+		"mkdir -p increments",
+		fmt.Sprintf("tar -czf %s -T changed checksums.new", archive),
+		"mv checksums.new checksums",
+		"rm -f changed",
+	}, " && ")
+}
+
+// scopeKinds returns the namespaced resource kinds the scope dumps
+func scopeKinds(scope config.Backup) ([]string, error) {
+	kinds := namespacedKinds
+	if len(scope.Resources) > 0 {
+		kinds = scope.Resources
+	}
+	selected := excludeKinds(kinds, scope)
+	if len(selected) == 0 {
+		return nil, fmt.Errorf("backup scope excludes every resource kind")
+	}
+	return selected, nil
+}
+
+// excludeKinds returns kinds without the ones the scope excludes
+func excludeKinds(kinds []string, scope config.Backup) []string {
+	excluded := map[string]bool{}
+	for _, kind := range scope.ExcludeResources {
+		excluded[kind] = true
+	}
+	if scope.ExcludeSecrets {
+		excluded["secrets"] = true
+	}
+
+	var selected []string
+	for _, kind := range kinds {
+		if !excluded[kind] {
+			selected = append(selected, kind)
+		}
+	}
+	return selected
+}
+
+// scopeNamespaces returns the shell word list of the namespaces the scope
+// dumps, evaluated on the control plane when no namespaces are listed
+func scopeNamespaces(scope config.Backup) string {
+	list := "kubectl get namespaces -o jsonpath='{.items[*].metadata.name}'"
+	if len(scope.Namespaces) > 0 {
+		list = "echo" + quoteAll(scope.Namespaces)
+	}
+	if len(scope.ExcludeNamespaces) == 0 {
+		return "$(" + list + ")"
+	}
+	var patterns []string
+	for _, ns := range scope.ExcludeNamespaces {
+		patterns = append(patterns, "-e "+ssh.Quote(ns))
+	}
+	return fmt.Sprintf("$(%s | tr ' ' '\\n' | grep -vxF %s)", list, strings.Join(patterns, " "))
+}
+
+// quoteAll returns words as shell words, each preceded by a space
+func quoteAll(words []string) string {
+	var b strings.Builder
+	for _, word := range words {
+		b.WriteString(" " + ssh.Quote(word))
+	}
+	return b.String()
+}
+
+// etcdSnapshot saves an etcd snapshot through the etcd static pod into its
+// hostPath data directory and moves it into the backup directory
+var etcdSnapshot = strings.Join([]string{
+	"pod=$(kubectl -n kube-system get pods -l component=etcd -o jsonpath='{.items[0].metadata.name}')",
+	"kubectl -n kube-system exec $pod -- etcdctl --endpoints=https://127.0.0.1:2379" +
+		" --cacert=/etc/kubernetes/pki/etcd/ca.crt --cert=/etc/kubernetes/pki/etcd/server.crt --key=/etc/kubernetes/pki/etcd/server.key" +
+		" snapshot save /var/lib/etcd/k8s-setup-snapshot.db",
+	fmt.Sprintf("mv /var/lib/etcd/k8s-setup-snapshot.db %s/etcd-snapshot.db", backupDir),
+}, " && ")
+
+// dedupSnapshot stores the etcd snapshot once per content hash in
+// archiveDir/etcd and leaves a symlink to the stored copy, so archives of
+// an unchanged etcd share one snapshot
+var dedupSnapshot = strings.Join([]string{
+	"cd " + backupDir,
+	"hash=$(sha256sum etcd-snapshot.db | cut -d' ' -f1)",
+	fmt.Sprintf("mkdir -p %s/etcd", archiveDir),
+	fmt.Sprintf("{ [ -f %s/etcd/$hash.db ] || mv etcd-snapshot.db %s/etcd/$hash.db; }", archiveDir, archiveDir),
+	fmt.Sprintf("ln -sfn %s/etcd/$hash.db etcd-snapshot.db", archiveDir),
+}, " && ")
+
+// now is replaced in tests to get stable archive names
+var now = time.Now
+
+// BeforeOperation takes an etcd snapshot and a resource backup before a
+// destructive operation and keeps the archive under a name of its own. The
+// archive is always a full one, a rollback must not depend on the chain of
+// increments. With incremental backups the etcd snapshot is deduplicated by
+// its hash. It returns the location of the archive on the control plane.
+func BeforeOperation(client ssh.Executor, config *config.Config, operation string) (string, error) {
+	if _, err := client.ExecuteCommand(fmt.Sprintf("mkdir -p %s %s", backupDir, archiveDir)); err != nil {
+		return "", fmt.Errorf("backup failed: %v", err)
+	}
+	if output, err := client.ExecuteCommand(etcdSnapshot); err != nil {
+		return "", fmt.Errorf("etcd snapshot failed: %v\nOutput: %s", err, output)
+	}
+	if config.Backup.Incremental {
+		if output, err := client.ExecuteCommand(dedupSnapshot); err != nil {
+			return "", fmt.Errorf("etcd snapshot failed: %v\nOutput: %s", err, output)
+		}
+	}
+
+	full := *config
+	full.Backup.Incremental = false
+	archive, err := create(client, &full)
+	if err != nil {
+		return "", err
+	}
+
+	// The snapshot only belongs to this archive, not to later regular
+	// backups. An incremental setup has no full archive of its own to keep.
+	location := fmt.Sprintf("%s/%s-%s.tar.gz", archiveDir, operation, now().UTC().Format("20060102-150405"))
+	keep := "cp"
+	if config.Backup.Incremental {
+		keep = "mv"
+	}
+	if _, err := client.ExecuteCommand(fmt.Sprintf("%s %s %s && rm -f %s/etcd-snapshot.db", keep, archive, ssh.Quote(location), backupDir)); err != nil {
+		return "", fmt.Errorf("backup failed: %v", err)
+	}
+	return location, nil
+}
+
+// RestoreNamespace recreates a namespace and its resources from the latest backup.
+// It returns the number of restored objects.
+func RestoreNamespace(client ssh.Executor, namespace string) (int, error) {
+	if errs := validation.IsDNS1123Label(namespace); len(errs) > 0 {
+		return 0, fmt.Errorf("invalid namespace %q: %s", namespace, strings.Join(errs, ", "))
+	}
+	output, err := ssh.Complete(client.ExecuteCommand(fmt.Sprintf("cat %s/namespaces/%s.json", backupDir, namespace)))
+	if err != nil {
+		return 0, fmt.Errorf("no backup found for namespace %s: %v", namespace, err)
+	}
+
+	var list struct {
+		Items []map[string]interface{} `json:"items"`
+	}
+	if err := json.Unmarshal([]byte(output), &list); err != nil {
+		return 0, fmt.Errorf("failed to parse backup of namespace %s: %v", namespace, err)
+	}
+
+	objects := []map[string]interface{}{
+		{
+			"apiVersion": "v1",
+			"kind":       "Namespace",
+			"metadata":   map[string]interface{}{"name": namespace},
+		},
+	}
+	for _, item := range list.Items {
+		if restorable(item) {
+			objects = append(objects, sanitize(item))
+		}
+	}
+
+	if err := kubernetes.Apply(client, "restore-"+namespace, objects); err != nil {
+		return 0, fmt.Errorf("restore failed: %v", err)
+	}
+
+	return len(objects) - 1, nil
+}
+
+// restorable filters out objects that the cluster recreates on its own
+func restorable(item map[string]interface{}) bool {
+	kind, _ := item["kind"].(string)
+	metadata, _ := item["metadata"].(map[string]interface{})
+	name, _ := metadata["name"].(string)
+
+	switch kind {
+	case "ServiceAccount":
+		return name != "default"
+	case "ConfigMap":
+		return name != "kube-root-ca.crt"
+	case "Secret":
+		secretType, _ := item["type"].(string)
+		return secretType != "kubernetes.io/service-account-token"
+	}
+
+	// Skip objects owned by a controller, such as Jobs created by CronJobs
+	owners, _ := metadata["ownerReferences"].([]interface{})
+	return len(owners) == 0
+}
+
+// sanitize strips server-populated fields so the object can be created again
+func sanitize(item map[string]interface{}) map[string]interface{} {
+	delete(item, "status")
+
+	if metadata, ok := item["metadata"].(map[string]interface{}); ok {
+		for _, field := range []string{"resourceVersion", "uid", "creationTimestamp", "generation", "managedFields", "selfLink"} {
+			delete(metadata, field)
+		}
+		if annotations, ok := metadata["annotations"].(map[string]interface{}); ok {
+			for key := range annotations {
+				if strings.HasPrefix(key, "deployment.kubernetes.io/") || key == "kubectl.kubernetes.io/last-applied-configuration" {
+					delete(annotations, key)
+				}
+			}
+		}
+	}
+
+	if item["kind"] == "Service" {
+		if spec, ok := item["spec"].(map[string]interface{}); ok {
+			delete(spec, "clusterIP")
+			delete(spec, "clusterIPs")
+		}
+	}
+
+	if item["kind"] == "PersistentVolumeClaim" {
+		if spec, ok := item["spec"].(map[string]interface{}); ok {
+			delete(spec, "volumeName")
 		}
 	}
 
-	return nil
+	return item
 }
@@ -0,0 +1,323 @@
+package backup
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/maarulav/k8s-setup/internal/testutil"
+	"github.com/maarulav/k8s-setup/pkg/config"
+	"github.com/maarulav/k8s-setup/pkg/ssh"
+)
+
+const namespaceBackup = `{
+  "items": [
+    {"apiVersion": "v1", "kind": "ServiceAccount", "metadata": {"name": "default", "namespace": "shop"}},
+    {"apiVersion": "v1", "kind": "ConfigMap", "metadata": {"name": "kube-root-ca.crt", "namespace": "shop"}},
+    {"apiVersion": "v1", "kind": "ConfigMap", "metadata": {"name": "settings", "namespace": "shop", "uid": "1", "resourceVersion": "42"}, "data": {"mode": "prod"}},
+    {"apiVersion": "v1", "kind": "Service", "metadata": {"name": "web", "namespace": "shop"}, "spec": {"clusterIP": "10.96.0.10", "clusterIPs": ["10.96.0.10"], "ports": [{"port": 80}]}},
+    {"apiVersion": "batch/v1", "kind": "Job", "metadata": {"name": "report-123", "namespace": "shop", "ownerReferences": [{"kind": "CronJob", "name": "report"}]}},
+    {"apiVersion": "apps/v1", "kind": "Deployment", "metadata": {"name": "web", "namespace": "shop", "annotations": {"deployment.kubernetes.io/revision": "3", "team": "shop"}}, "status": {"replicas": 2}}
+  ]
+}`
+
+func TestCreatePlan(t *testing.T) {
+	fake := testutil.NewExecutor("10.0.0.1")
+	if err := Create(fake, &config.Config{}); err != nil {
+		t.Fatal(err)
+	}
+	testutil.AssertGolden(t, "create", fake.Plan())
+}
+
+func TestCreateScope(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Backup = config.Backup{
+		Namespaces:        []string{"shop", "billing", "legacy"},
+		ExcludeNamespaces: []string{"legacy"},
+		ExcludeResources:  []string{"cronjobs"},
+		Selector:          "backup!=skip",
+		ExcludeSecrets:    true,
+	}
+
+	fake := testutil.NewExecutor("10.0.0.1")
+	if err := Create(fake, cfg); err != nil {
+		t.Fatal(err)
+	}
+	testutil.AssertGolden(t, "create-scope", fake.Plan())
+}
+
+func TestCreateQuoting(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Backup = config.Backup{Selector: "team=o'brien"}
+
+	fake := testutil.NewExecutor("10.0.0.1")
+	if err := Create(fake, cfg); err != nil {
+		t.Fatal(err)
+	}
+	if commands := strings.Join(fake.Commands(), "\n"); !strings.Contains(commands, `-l 'team=o'"'"'brien'`) {
+		t.Errorf("selector not quoted:\n%s", commands)
+	}
+
+	for _, ns := range []string{"shop; rm -rf /", "$(reboot)", "Shop"} {
+		cfg.Backup = config.Backup{Namespaces: []string{"billing", ns}}
+		fake := testutil.NewExecutor("10.0.0.1")
+		if err := Create(fake, cfg); err == nil {
+			t.Errorf("expected the namespace %q to be refused", ns)
+		}
+		if len(fake.Commands()) != 0 {
+			t.Errorf("ran commands for the namespace %q: %v", ns, fake.Commands())
+		}
+	}
+}
+
+func TestCreateWithoutClusterKinds(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Backup.ExcludeResources = []string{"customresourcedefinitions", "clusterroles", "clusterrolebindings", "storageclasses", "ingressclasses", "priorityclasses"}
+
+	fake := testutil.NewExecutor("10.0.0.1")
+	if err := Create(fake, cfg); err != nil {
+		t.Fatal(err)
+	}
+	for _, cmd := range fake.Commands() {
+		if strings.Contains(cmd, "> /root/k8s-backup/cluster.json") {
+			t.Errorf("dumped excluded cluster-scoped kinds: %s", cmd)
+		}
+	}
+}
+
+func TestCreateScopeWithoutKinds(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Backup = config.Backup{Resources: []string{"secrets"}, ExcludeSecrets: true}
+
+	fake := testutil.NewExecutor("10.0.0.1")
+	if err := Create(fake, cfg); err == nil {
+		t.Fatal("expected an empty scope to be refused")
+	}
+	if len(fake.Commands()) != 0 {
+		t.Errorf("ran commands for an empty scope: %v", fake.Commands())
+	}
+}
+
+func TestCreateVolumes(t *testing.T) {
+	now = func() time.Time { return time.Date(2024, 1, 6, 22, 0, 0, 0, time.UTC) }
+	defer func() { now = time.Now }()
+
+	cfg := &config.Config{}
+	cfg.Backup = config.Backup{
+		ExcludeNamespaces: []string{"scratch"},
+		Volumes:           config.VolumeBackup{Enabled: true, Bucket: "backups"},
+	}
+
+	fake := testutil.NewExecutor("10.0.0.1")
+	if err := Create(fake, cfg); err != nil {
+		t.Fatal(err)
+	}
+	testutil.AssertGolden(t, "create-volumes", fake.Plan())
+}
+
+func TestBeforeOperationIncremental(t *testing.T) {
+	now = func() time.Time { return time.Date(2024, 1, 6, 22, 0, 0, 0, time.UTC) }
+	defer func() { now = time.Now }()
+
+	cfg := &config.Config{}
+	cfg.Backup.Incremental = true
+
+	fake := testutil.NewExecutor("10.0.0.1")
+	if _, err := BeforeOperation(fake, cfg, "upgrade"); err != nil {
+		t.Fatal(err)
+	}
+	testutil.AssertGolden(t, "before-operation-incremental", fake.Plan())
+}
+
+func TestDedupSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	backups, store := filepath.Join(dir, "backup"), filepath.Join(dir, "backups")
+	// archiveDir starts with backupDir
+	script := strings.ReplaceAll(strings.ReplaceAll(dedupSnapshot, archiveDir, store), backupDir, backups)
+	if err := os.MkdirAll(backups, 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	snapshot := func(data string) string {
+		t.Helper()
+		// etcdSnapshot moves the new snapshot over the link
+		file := filepath.Join(backups, "etcd-snapshot.new")
+		if err := ioutil.WriteFile(file, []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Rename(file, filepath.Join(backups, "etcd-snapshot.db")); err != nil {
+			t.Fatal(err)
+		}
+		if output, err := exec.Command("sh", "-c", script).CombinedOutput(); err != nil {
+			t.Fatalf("%v: %s", err, output)
+		}
+		target, err := filepath.EvalSymlinks(filepath.Join(backups, "etcd-snapshot.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, err := ioutil.ReadFile(target); err != nil || string(got) != data {
+			t.Errorf("snapshot link reads %q, %v, want %q", got, err, data)
+		}
+		return target
+	}
+
+	first := snapshot("revision 1")
+	if again := snapshot("revision 1"); again != first {
+		t.Errorf("unchanged snapshot stored as %s and %s", first, again)
+	}
+	if changed := snapshot("revision 2"); changed == first {
+		t.Errorf("changed snapshot replaced %s", first)
+	}
+	stored, err := filepath.Glob(filepath.Join(store, "etcd", "*.db"))
+	if err != nil || len(stored) != 2 {
+		t.Errorf("stored snapshots %v, want one per content", stored)
+	}
+}
+
+func TestCreateFailure(t *testing.T) {
+	fake := testutil.NewExecutor("10.0.0.1").Fail("tar -czf", "no space left on device")
+	if err := Create(fake, &config.Config{}); err == nil {
+		t.Fatal("expected the backup to fail")
+	}
+}
+
+func TestBeforeOperationPlan(t *testing.T) {
+	now = func() time.Time { return time.Date(2024, 1, 6, 22, 0, 0, 0, time.UTC) }
+	defer func() { now = time.Now }()
+
+	fake := testutil.NewExecutor("10.0.0.1")
+	location, err := BeforeOperation(fake, &config.Config{}, "upgrade")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if location != "/root/k8s-backups/upgrade-20240106-220000.tar.gz" {
+		t.Errorf("unexpected backup location %s", location)
+	}
+	testutil.AssertGolden(t, "before-operation", fake.Plan())
+}
+
+func TestRestoreNamespace(t *testing.T) {
+	fake := testutil.NewExecutor("10.0.0.1").Respond("namespaces/shop.json", namespaceBackup)
+
+	restored, err := RestoreNamespace(fake, "shop")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored != 3 {
+		t.Errorf("restored %d objects, want 3", restored)
+	}
+	testutil.AssertGolden(t, "restore", fake.Plan())
+}
+
+func TestRestoreNamespaceInvalid(t *testing.T) {
+	fake := testutil.NewExecutor("10.0.0.1")
+	if _, err := RestoreNamespace(fake, "../../etc/passwd"); err == nil {
+		t.Fatal("expected an invalid namespace to be refused")
+	}
+	if len(fake.Commands()) != 0 {
+		t.Errorf("ran commands for an invalid namespace: %v", fake.Commands())
+	}
+}
+
+func TestRestoreNamespaceWithoutBackup(t *testing.T) {
+	fake := testutil.NewExecutor("10.0.0.1").Fail("namespaces/shop.json", "No such file or directory")
+
+	_, err := RestoreNamespace(fake, "shop")
+	if err == nil || !strings.Contains(err.Error(), "no backup found") {
+		t.Fatalf("expected a missing backup error, got %v", err)
+	}
+	for _, cmd := range fake.Commands() {
+		if strings.Contains(cmd, "kubectl apply") {
+			t.Errorf("applied objects without a backup: %s", cmd)
+		}
+	}
+}
+
+func TestRestoreNamespaceOverSSH(t *testing.T) {
+	server := testutil.NewSSHServer(t).Respond("namespaces/shop.json", namespaceBackup)
+	client, err := ssh.Connect(server.VMConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	if _, err := RestoreNamespace(client, "shop"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The manifest uploaded over SSH matches the plan of the fake executor
+	fake := testutil.NewExecutor("10.0.0.1").Respond("namespaces/shop.json", namespaceBackup)
+	if _, err := RestoreNamespace(fake, "shop"); err != nil {
+		t.Fatal(err)
+	}
+	want := fake.Files["/root/k8s-manifests/restore-shop.json"]
+
+	var uploaded []byte
+	for _, e := range server.Execs() {
+		if strings.HasPrefix(e.Command, "umask 077 && cat > '/root/k8s-manifests/restore-shop.json'") {
+			uploaded = e.Stdin
+		}
+	}
+	if string(uploaded) != string(want) {
+		t.Errorf("uploaded manifest differs from the planned one:\n%s", uploaded)
+	}
+
+	commands := server.Commands()
+	if last := commands[len(commands)-1]; last != "kubectl apply -f /root/k8s-manifests/restore-shop.json" {
+		t.Errorf("last command %q", last)
+	}
+}
+
+const storedArchives = `/root/k8s-backups/upgrade-20240106-220000.tar.gz 52311 1704578400.5
+/root/k8s-backup/k8s-backup.tar.gz 48210 1704664800.0
+/root/k8s-backup/increments/20240107-220000.tar.gz 1203 1704664800.2
+`
+
+func TestList(t *testing.T) {
+	fake := testutil.NewExecutor("10.0.0.1").
+		Respond("find /root/k8s-backup", storedArchives).
+		Respond("upgrade-20240106-220000.tar.gz --wildcards '*version.json'", `{"serverVersion": {"gitVersion": "v1.28.2"}}`)
+
+	backups, err := List(fake, &config.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 3 {
+		t.Fatalf("listed %d backups, want 3: %+v", len(backups), backups)
+	}
+
+	first := backups[0]
+	if first.ID != "upgrade-20240106-220000" || first.Kind != KindOperation || first.Size != 52311 || first.KubernetesVersion != "v1.28.2" {
+		t.Errorf("unexpected operation backup %+v", first)
+	}
+	if backups[1].Kind != KindLatest || backups[2].ID != "increments/20240107-220000" || backups[2].Kind != KindIncrement {
+		t.Errorf("unexpected order or kinds: %+v", backups)
+	}
+}
+
+func TestShow(t *testing.T) {
+	fake := testutil.NewExecutor("10.0.0.1").
+		Respond("find /root/k8s-backup", storedArchives).
+		Respond("tar -tzf", "root/k8s-backup/etcd-snapshot.db\nroot/k8s-backup/namespaces/shop.json\n").
+		Respond("--wildcards '*namespaces/*.json'", namespaceBackup+`{"items": []}`)
+
+	details, err := Show(fake, &config.Config{}, "upgrade-20240106-220000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if details.EtcdSnapshot != "root/k8s-backup/etcd-snapshot.db" {
+		t.Errorf("etcd snapshot %q", details.EtcdSnapshot)
+	}
+	shop := details.Objects["shop"]
+	if shop["ConfigMap"] != 2 || shop["Deployment"] != 1 || len(shop) != 5 {
+		t.Errorf("unexpected object counts %v", shop)
+	}
+
+	if _, err := Show(fake, &config.Config{}, "missing"); err == nil {
+		t.Error("expected an unknown backup to fail")
+	}
+}
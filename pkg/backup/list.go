@@ -0,0 +1,257 @@
+package backup
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/maarulav/k8s-setup/pkg/config"
+	"github.com/maarulav/k8s-setup/pkg/ssh"
+)
+
+// Kinds of stored backups
+const (
+	KindLatest    = "latest"
+	KindIncrement = "increment"
+	KindOperation = "operation"
+	KindVolumes   = "volumes"
+)
+
+// Info describes a stored backup. ID is what backup show takes: the archive
+// name relative to its directory without .tar.gz, or the Velero backup name.
+type Info struct {
+	ID                string    `json:"id"`
+	Kind              string    `json:"kind"`
+	Location          string    `json:"location"`
+	Size              int64     `json:"size,omitempty"`
+	Time              time.Time `json:"time"`
+	KubernetesVersion string    `json:"kubernetesVersion,omitempty"`
+	Phase             string    `json:"phase,omitempty"`
+}
+
+// Details is the contents summary of a stored backup
+type Details struct {
+	Info
+	// Objects counts the dumped objects per namespace and kind
+	Objects map[string]map[string]int `json:"objects,omitempty"`
+	// EtcdSnapshot is the hash or file of the etcd snapshot in the backup
+	EtcdSnapshot string `json:"etcdSnapshot,omitempty"`
+	// Namespaces and Items are the scope and size of a Velero backup
+	Namespaces []string `json:"namespaces,omitempty"`
+	Items      int      `json:"items,omitempty"`
+}
+
+// List returns the archives stored on the control plane, oldest first,
+// followed by the Velero volume backups when they are enabled
+func List(client ssh.Executor, config *config.Config) ([]Info, error) {
+	cmd := fmt.Sprintf("find %s %s -maxdepth 2 -name '*.tar.gz' -printf '%%p %%s %%T@\\n' 2>/dev/null || true", backupDir, archiveDir)
+	output, err := ssh.Complete(client.ExecuteCommand(cmd))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list backups: %v", err)
+	}
+
+	backups, err := parseArchives(output)
+	if err != nil {
+		return nil, err
+	}
+	for i := range backups {
+		backups[i].KubernetesVersion = archiveVersion(client, backups[i].Location)
+	}
+
+	if config.Backup.Volumes.Enabled {
+		volumes, err := listVolumeBackups(client)
+		if err != nil {
+			return nil, err
+		}
+		backups = append(backups, volumes...)
+	}
+	return backups, nil
+}
+
+// parseArchives parses the find output of List
+func parseArchives(output string) ([]Info, error) {
+	var backups []Info
+	for _, line := range strings.Split(strings.TrimSpace(output), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			continue
+		}
+		size, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("unexpected size in %q", line)
+		}
+		seconds, err := strconv.ParseFloat(fields[2], 64)
+		if err != nil {
+			return nil, fmt.Errorf("unexpected time in %q", line)
+		}
+
+		location := fields[0]
+		info := Info{Location: location, Size: size, Time: time.Unix(int64(seconds), 0).UTC()}
+		switch {
+		case path.Dir(location) == archiveDir:
+			info.Kind = KindOperation
+			info.ID = strings.TrimSuffix(path.Base(location), ".tar.gz")
+		case path.Dir(location) == backupDir+"/increments":
+			info.Kind = KindIncrement
+			info.ID = "increments/" + strings.TrimSuffix(path.Base(location), ".tar.gz")
+		default:
+			info.Kind = KindLatest
+			info.ID = strings.TrimSuffix(path.Base(location), ".tar.gz")
+		}
+		backups = append(backups, info)
+	}
+
+	sort.Slice(backups, func(i, j int) bool { return backups[i].Time.Before(backups[j].Time) })
+	return backups, nil
+}
+
+// archiveVersion returns the server version recorded in an archive, empty
+// for archives taken before the version was recorded
+func archiveVersion(client ssh.Executor, archive string) string {
+	output, err := ssh.Complete(client.ExecuteCommand(fmt.Sprintf("tar -xzOf %s --wildcards '*version.json' 2>/dev/null", archive)))
+	if err != nil {
+		return ""
+	}
+	var version struct {
+		ServerVersion struct {
+			GitVersion string `json:"gitVersion"`
+		} `json:"serverVersion"`
+	}
+	if err := json.Unmarshal([]byte(output), &version); err != nil {
+		return ""
+	}
+	return version.ServerVersion.GitVersion
+}
+
+// veleroBackup is the part of a Velero Backup that list and show report
+type veleroBackup struct {
+	Metadata struct {
+		Name              string    `json:"name"`
+		CreationTimestamp time.Time `json:"creationTimestamp"`
+	} `json:"metadata"`
+	Spec struct {
+		IncludedNamespaces []string `json:"includedNamespaces"`
+	} `json:"spec"`
+	Status struct {
+		Phase    string `json:"phase"`
+		Progress struct {
+			ItemsBackedUp int `json:"itemsBackedUp"`
+		} `json:"progress"`
+	} `json:"status"`
+}
+
+func (b veleroBackup) info() Info {
+	return Info{
+		ID:       b.Metadata.Name,
+		Kind:     KindVolumes,
+		Location: "velero/" + b.Metadata.Name,
+		Time:     b.Metadata.CreationTimestamp,
+		Phase:    b.Status.Phase,
+	}
+}
+
+func listVolumeBackups(client ssh.Executor) ([]Info, error) {
+	output, err := ssh.Complete(client.ExecuteCommand(fmt.Sprintf("kubectl -n %s get backups.velero.io -o json", veleroNamespace)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list volume backups: %v\nOutput: %s", err, output)
+	}
+	var list struct {
+		Items []veleroBackup `json:"items"`
+	}
+	if err := json.Unmarshal([]byte(output), &list); err != nil {
+		return nil, fmt.Errorf("failed to parse volume backups: %v", err)
+	}
+
+	var backups []Info
+	for _, b := range list.Items {
+		backups = append(backups, b.info())
+	}
+	sort.Slice(backups, func(i, j int) bool { return backups[i].Time.Before(backups[j].Time) })
+	return backups, nil
+}
+
+// Show returns the backup with the given ID and a summary of its contents
+func Show(client ssh.Executor, config *config.Config, id string) (*Details, error) {
+	backups, err := List(client, config)
+	if err != nil {
+		return nil, err
+	}
+	for _, info := range backups {
+		if info.ID != id {
+			continue
+		}
+		if info.Kind == KindVolumes {
+			return showVolumeBackup(client, info)
+		}
+		return showArchive(client, info)
+	}
+	return nil, fmt.Errorf("no backup %s found", id)
+}
+
+// showArchive counts the objects of every namespace dump in the archive
+func showArchive(client ssh.Executor, info Info) (*Details, error) {
+	details := &Details{Info: info, Objects: map[string]map[string]int{}}
+
+	files, err := ssh.Complete(client.ExecuteCommand(fmt.Sprintf("tar -tzf %s", info.Location)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read backup %s: %v", info.ID, err)
+	}
+	for _, file := range strings.Fields(files) {
+		if path.Base(file) == "etcd-snapshot.db" {
+			details.EtcdSnapshot = file
+		}
+	}
+
+	dumps, err := ssh.Complete(client.ExecuteCommand(fmt.Sprintf("tar -xzOf %s --wildcards '*namespaces/*.json' 2>/dev/null || true", info.Location)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read backup %s: %v", info.ID, err)
+	}
+	if err := countObjects(dumps, details.Objects); err != nil {
+		return nil, fmt.Errorf("failed to parse backup %s: %v", info.ID, err)
+	}
+	return details, nil
+}
+
+// countObjects adds the items of the concatenated namespace dumps to objects
+func countObjects(dumps string, objects map[string]map[string]int) error {
+	decoder := json.NewDecoder(strings.NewReader(dumps))
+	for {
+		var list struct {
+			Items []struct {
+				Kind     string `json:"kind"`
+				Metadata struct {
+					Namespace string `json:"namespace"`
+				} `json:"metadata"`
+			} `json:"items"`
+		}
+		if err := decoder.Decode(&list); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		for _, item := range list.Items {
+			if objects[item.Metadata.Namespace] == nil {
+				objects[item.Metadata.Namespace] = map[string]int{}
+			}
+			objects[item.Metadata.Namespace][item.Kind]++
+		}
+	}
+}
+
+func showVolumeBackup(client ssh.Executor, info Info) (*Details, error) {
+	output, err := ssh.Complete(client.ExecuteCommand(fmt.Sprintf("kubectl -n %s get backups.velero.io %s -o json", veleroNamespace, info.ID)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read volume backup %s: %v\nOutput: %s", info.ID, err, output)
+	}
+	var b veleroBackup
+	if err := json.Unmarshal([]byte(output), &b); err != nil {
+		return nil, fmt.Errorf("failed to parse volume backup %s: %v", info.ID, err)
+	}
+	return &Details{Info: info, Namespaces: b.Spec.IncludedNamespaces, Items: b.Status.Progress.ItemsBackedUp}, nil
+}
@@ -0,0 +1,141 @@
+package backup
+
+import (
+	"fmt"
+
+	"github.com/maarulav/k8s-setup/pkg/config"
+	"github.com/maarulav/k8s-setup/pkg/helm"
+	"github.com/maarulav/k8s-setup/pkg/kubernetes"
+	"github.com/maarulav/k8s-setup/pkg/ssh"
+)
+
+const (
+	// veleroNamespace holds Velero, its node agent and the Backup objects
+	veleroNamespace = "velero"
+
+	veleroAWSPlugin = "velero/velero-plugin-for-aws:v1.10.0"
+)
+
+// SetupVolumes installs Velero with its node agent on every node, writing
+// into the bucket of the volume backup configuration
+func SetupVolumes(client ssh.Executor, config *config.Config) error {
+	v := config.Backup.Volumes
+	if v.Bucket == "" {
+		return fmt.Errorf("volume backup needs a bucket")
+	}
+
+	return helm.Install(client, helm.Chart{
+		Release:   "velero",
+		RepoURL:   "https://vmware-tanzu.github.io/helm-charts",
+		Chart:     "velero",
+		Version:   v.Version,
+		Namespace: veleroNamespace,
+		Values:    veleroValues(v),
+	})
+}
+
+// veleroValues configures a single S3 backup location and file system
+// backups only, volume snapshots need a cloud provider
+func veleroValues(v config.VolumeBackup) map[string]interface{} {
+	uploader := v.Uploader
+	if uploader == "" {
+		uploader = "kopia"
+	}
+
+	location := map[string]interface{}{}
+	if v.Region != "" {
+		location["region"] = v.Region
+	}
+	if v.Endpoint != "" {
+		location["s3Url"] = v.Endpoint
+		location["s3ForcePathStyle"] = "true"
+		if v.Region == "" {
+			location["region"] = "minio"
+		}
+	}
+
+	return map[string]interface{}{
+		"initContainers": []map[string]interface{}{
+			{
+				"name":         "velero-plugin-for-aws",
+				"image":        veleroAWSPlugin,
+				"volumeMounts": []map[string]interface{}{{"mountPath": "/target", "name": "plugins"}},
+			},
+		},
+		"configuration": map[string]interface{}{
+			"backupStorageLocation": []map[string]interface{}{
+				{
+					"name":     "default",
+					"provider": "aws",
+					"bucket":   v.Bucket,
+					"prefix":   v.Prefix,
+					"config":   location,
+				},
+			},
+			"volumeSnapshotLocation": []map[string]interface{}{},
+			"uploaderType":           uploader,
+		},
+		"credentials": map[string]interface{}{
+			"secretContents": map[string]interface{}{
+				"cloud": fmt.Sprintf("[default]\naws_access_key_id=%s\naws_secret_access_key=%s\n", v.AccessKey, v.SecretKey),
+			},
+		},
+		"deployNodeAgent":  true,
+		"snapshotsEnabled": false,
+		// Picked up by the Prometheus of the monitoring step for the backup alerts
+		"metrics": map[string]interface{}{
+			"serviceMonitor": map[string]interface{}{
+				"enabled":          true,
+				"additionalLabels": map[string]interface{}{"release": "prometheus"},
+			},
+		},
+	}
+}
+
+// backupVolumes has Velero copy the volumes of the namespaces in the backup
+// scope and waits for the upload to finish
+func backupVolumes(client ssh.Executor, config *config.Config) error {
+	name := "k8s-setup-" + now().UTC().Format("20060102-150405")
+	if err := kubernetes.Apply(client, "volume-backup", []map[string]interface{}{volumeBackup(name, config.Backup)}); err != nil {
+		return fmt.Errorf("volume backup failed: %v", err)
+	}
+
+	cmd := fmt.Sprintf("kubectl -n %s wait --for=jsonpath='{.status.phase}'=Completed backup/%s --timeout=30m", veleroNamespace, name)
+	if output, err := client.ExecuteCommand(cmd); err != nil {
+		phase, _ := client.ExecuteCommand(fmt.Sprintf("kubectl -n %s get backup/%s -o jsonpath='{.status.phase}'", veleroNamespace, name))
+		return fmt.Errorf("volume backup %s did not complete (phase %s): %v\nOutput: %s", name, phase, err, output)
+	}
+	return nil
+}
+
+// volumeBackup renders the Velero Backup of scope. Resources are left to the
+// resource backup, Velero only picks up the pods and their volumes.
+func volumeBackup(name string, scope config.Backup) map[string]interface{} {
+	namespaces := scope.Namespaces
+	if len(namespaces) == 0 {
+		namespaces = []string{"*"}
+	}
+
+	spec := map[string]interface{}{
+		"includedNamespaces":       namespaces,
+		"includedResources":        []string{"pods", "persistentvolumeclaims", "persistentvolumes"},
+		"defaultVolumesToFsBackup": true,
+		"snapshotVolumes":          false,
+		"storageLocation":          "default",
+	}
+	if len(scope.ExcludeNamespaces) > 0 {
+		spec["excludedNamespaces"] = scope.ExcludeNamespaces
+	}
+	ttl := scope.Volumes.TTL
+	if ttl == "" {
+		ttl = "720h"
+	}
+	spec["ttl"] = ttl
+
+	return map[string]interface{}{
+		"apiVersion": "velero.io/v1",
+		"kind":       "Backup",
+		"metadata":   map[string]interface{}{"name": name, "namespace": veleroNamespace},
+		"spec":       spec,
+	}
+}
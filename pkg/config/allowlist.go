@@ -0,0 +1,17 @@
+package config
+
+// Allowlist restricts the commands run over SSH to a reviewed list. With
+// Record set, a rehearsal run (e.g. against a lab) writes every command,
+// file write and download it makes to File instead of checking them. For
+// enforcement SHA256 pins the checksum of the reviewed file, printed by
+// `k8s-setup allowlist checksum`, and anything not on it is refused.
+type Allowlist struct {
+	File   string `json:"file,omitempty"`
+	SHA256 string `json:"sha256,omitempty"`
+	Record bool   `json:"record,omitempty"`
+}
+
+// Enabled reports whether commands are recorded or checked
+func (a Allowlist) Enabled() bool {
+	return a.File != ""
+}
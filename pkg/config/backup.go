@@ -0,0 +1,37 @@
+package config
+
+// Backup selects what the resource backup dumps. Namespaces limits it to the
+// listed namespaces (default all of them) and ExcludeNamespaces drops some of
+// those. Resources replaces the default resource kinds, ExcludeResources
+// drops kinds from them and Selector is a label selector every dumped object
+// has to match. ExcludeSecrets keeps Secrets out of the archive. Volumes
+// adds the data of the PersistentVolumes in the selected namespaces.
+// Incremental archives only the namespaces that changed since the last
+// backup and stores identical etcd snapshots once.
+type Backup struct {
+	Namespaces        []string     `json:"namespaces,omitempty"`
+	ExcludeNamespaces []string     `json:"excludeNamespaces,omitempty"`
+	Resources         []string     `json:"resources,omitempty"`
+	ExcludeResources  []string     `json:"excludeResources,omitempty"`
+	Selector          string       `json:"selector,omitempty"`
+	ExcludeSecrets    bool         `json:"excludeSecrets,omitempty"`
+	Volumes           VolumeBackup `json:"volumes,omitempty"`
+	Incremental       bool         `json:"incremental,omitempty"`
+}
+
+// VolumeBackup copies the data of PersistentVolumes with the file system
+// backup of Velero's node agent into an S3 compatible bucket. Uploader is
+// "kopia" (default) or "restic", Endpoint the URL of a non-AWS store such as
+// MinIO and TTL how long Velero keeps each backup (default 720h).
+type VolumeBackup struct {
+	Enabled   bool   `json:"enabled"`
+	Version   string `json:"version,omitempty"`
+	Uploader  string `json:"uploader,omitempty"`
+	Bucket    string `json:"bucket"`
+	Prefix    string `json:"prefix,omitempty"`
+	Region    string `json:"region,omitempty"`
+	Endpoint  string `json:"endpoint,omitempty"`
+	AccessKey string `json:"accessKey"`
+	SecretKey string `json:"secretKey"`
+	TTL       string `json:"ttl,omitempty"`
+}
@@ -0,0 +1,21 @@
+package config
+
+// Cluster identifies the clusters of a configuration in the metrics they
+// send elsewhere. Name defaults to the IP of the control plane; inventory
+// hosts override it with clusterName, which a configuration provisioning
+// several clusters needs to keep them apart. Environment is e.g. staging.
+type Cluster struct {
+	Name        string `json:"name,omitempty"`
+	Environment string `json:"environment,omitempty"`
+}
+
+// ClusterName returns the name of the cluster whose control plane is ip
+func (c *Config) ClusterName(ip string) string {
+	if host, ok := c.Host(ip); ok && host.ClusterName != "" {
+		return host.ClusterName
+	}
+	if c.Cluster.Name != "" {
+		return c.Cluster.Name
+	}
+	return ip
+}
@@ -1,39 +1,572 @@
 package config
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"os"
 	"time"
 )
 
 // Config represents the application configuration
 type Config struct {
+	// SchemaVersion is the version of the file format, see Migrate
+	SchemaVersion int `json:"schemaVersion,omitempty"`
+
 	SSHConfig struct {
 		Username string `json:"username"`
 		Password string `json:"password"`
 		KeyFile  string `json:"keyFile"`
-		Timeout  int    `json:"timeout"`
+		CertFile string `json:"certFile,omitempty"`
+		Port     int    `json:"port,omitempty"`
+		// Timeout bounds the connection, e.g. "30s" or 30 seconds
+		Timeout Duration `json:"timeout,omitempty"`
+		// Sudo runs every remote command through sudo for non-root users
+		Sudo         bool   `json:"sudo,omitempty"`
+		SudoPassword string `json:"sudoPassword,omitempty"`
+		// KeyboardInteractive enables PAM/OTP challenges; OTPEnv names the
+		// environment variable holding the one-time password
+		KeyboardInteractive bool   `json:"keyboardInteractive,omitempty"`
+		OTPEnv              string `json:"otpEnv,omitempty"`
+		// Limits keep provisioning from starving small VMs or a shared network
+		Limits SSHLimits `json:"limits,omitempty"`
+		// Probe drops targets that do not accept connections on the SSH port
+		Probe bool `json:"probe,omitempty"`
+		// ConfigFile is the OpenSSH client configuration aliases on the
+		// command line are looked up in, default ~/.ssh/config, "none" for none
+		ConfigFile string `json:"configFile,omitempty"`
+		// Allowlist limits remote execution to reviewed commands
+		Allowlist Allowlist `json:"allowlist,omitempty"`
+		// Shell runs the remote commands: "auto" (default) uses bash where
+		// installed and sh elsewhere, "bash" and "sh" force one, "login"
+		// leaves them to the login shell
+		Shell string `json:"shell,omitempty"`
 	} `json:"ssh"`
+	Hosts []Host `json:"hosts,omitempty"`
+
+	// Roles are the extra step sets inventory hosts opt into by name
+	Roles map[string]Role `json:"roles,omitempty"`
+
+	// Cluster names the provisioned clusters and their environment
+	Cluster Cluster `json:"cluster,omitempty"`
+
 	Kubernetes struct {
 		Version     string `json:"version"`
 		PodCIDR     string `json:"podCIDR"`
 		ServiceCIDR string `json:"serviceCIDR"`
+		CNI         string `json:"cni,omitempty"`
+
+		// IPv6 ranges, set both to create a dual-stack cluster
+		PodCIDRv6     string `json:"podCIDRv6,omitempty"`
+		ServiceCIDRv6 string `json:"serviceCIDRv6,omitempty"`
+
+		// ClusterDomain replaces cluster.local as the cluster DNS domain
+		ClusterDomain string `json:"clusterDomain,omitempty"`
+
+		// ServiceNodePortRange is the API server service-node-port-range, e.g. 20000-40000
+		ServiceNodePortRange string `json:"serviceNodePortRange,omitempty"`
+
+		// KubeProxyReplacement runs Cilium without kube-proxy
+		KubeProxyReplacement bool `json:"kubeProxyReplacement,omitempty"`
+
+		// AdvertiseAddress is the address the API server advertises and the
+		// kubelet registers with, for hosts whose default route is not on the
+		// cluster network
+		AdvertiseAddress string `json:"advertiseAddress,omitempty"`
+
+		// CertSANs are extra names and addresses of the API server
+		// certificate, e.g. a public IP or DNS name, see certs add-san
+		CertSANs []string `json:"certSANs,omitempty"`
+
+		// Prebaked skips the node preparation on hosts booted from an image
+		// built with render packer, leaving only kubeadm init and join
+		Prebaked bool `json:"prebaked,omitempty"`
+
+		// CgroupDriver is the cgroup driver of the kubelet and the container
+		// runtime, systemd (default) or cgroupfs. cgroupfs is refused on
+		// cgroup v2 hosts.
+		CgroupDriver string `json:"cgroupDriver,omitempty"`
+
+		// Reserved sets aside node resources the pods cannot request
+		Reserved KubeletReservations `json:"reserved,omitempty"`
+
+		// EOLFeed is the URL of end-of-life dates in the format of
+		// https://endoflife.date/api/kubernetes.json, checked on top of the
+		// dates built into the tool
+		EOLFeed string `json:"eolFeed,omitempty"`
 	} `json:"kubernetes"`
 	Monitoring struct {
 		Prometheus struct {
 			RetentionTime string `json:"retentionTime"`
 			StorageClass  string `json:"storageClass"`
+			ChartVersion  string `json:"chartVersion,omitempty"`
+
+			// RetentionSize caps the TSDB on disk, e.g. 8GB, so the volume
+			// does not fill up before RetentionTime expires
+			RetentionSize string `json:"retentionSize,omitempty"`
+			// WALCompression compresses the write-ahead log, on by default
+			WALCompression *bool `json:"walCompression,omitempty"`
+			// ScrapeInterval replaces the default of 30s, e.g. 60s
+			ScrapeInterval string `json:"scrapeInterval,omitempty"`
+			// ExternalLabels are added to every series leaving the cluster,
+			// on top of the cluster, environment and cluster_id labels
+			ExternalLabels map[string]string `json:"externalLabels,omitempty"`
 		} `json:"prometheus"`
 		Grafana struct {
 			AdminPassword string `json:"adminPassword"`
-			Domain        string `json:"domain"`
+			// Domain exposes Grafana at https://<domain> through an Ingress
+			Domain string `json:"domain"`
+			// IngressClass is the class of the Ingress and ClusterIssuer the
+			// cert-manager issuer of its certificate, see GrafanaIssuer
+			IngressClass  string `json:"ingressClass,omitempty"`
+			ClusterIssuer string `json:"clusterIssuer,omitempty"`
+
+			// OAuth enables single sign-on, see GrafanaOAuth
+			OAuth *GrafanaOAuth `json:"oauth,omitempty"`
 		} `json:"grafana"`
+		// Cardinality limits the series of the kubelet, cAdvisor and
+		// kube-state-metrics, see MetricFilter
+		Cardinality MetricFilter `json:"cardinality,omitempty"`
+		// Alerts switches the built-in alert rule groups ("node",
+		// "certificates", "etcd", "backup") on or off, all are on by default
+		Alerts map[string]bool `json:"alerts,omitempty"`
+		// Scope limits the stack to namespaces, see MonitoringScope
+		Scope      MonitoringScope `json:"scope,omitempty"`
+		Federation struct {
+			Enabled  bool   `json:"enabled"`
+			Hub      string `json:"hub"`
+			Mode     string `json:"mode"`
+			NodePort int    `json:"nodePort"`
+		} `json:"federation"`
 	} `json:"monitoring"`
 	Resources struct {
 		CPU    string `json:"cpu"`
 		Memory string `json:"memory"`
 	} `json:"resources"`
+	Namespaces []Namespace `json:"namespaces"`
+	Components struct {
+		CoreDNS            ResourceRequirements `json:"coredns"`
+		CNI                ResourceRequirements `json:"cni"`
+		Prometheus         ResourceRequirements `json:"prometheus"`
+		Grafana            ResourceRequirements `json:"grafana"`
+		Alertmanager       ResourceRequirements `json:"alertmanager"`
+		PrometheusOperator ResourceRequirements `json:"prometheusOperator"`
+		KubeStateMetrics   ResourceRequirements `json:"kubeStateMetrics"`
+		NodeExporter       ResourceRequirements `json:"nodeExporter"`
+	} `json:"components"`
+	PriorityClasses bool       `json:"priorityClasses"`
+	Registries      []Registry `json:"registries"`
+
+	// RegistryMirrors are rendered as containerd certs.d hosts.toml entries
+	RegistryMirrors []RegistryMirror `json:"registryMirrors,omitempty"`
+
+	// TrustedCAs are local PEM files added to the system trust store of every node
+	TrustedCAs []string `json:"trustedCAs,omitempty"`
+
+	// ClusterAccess selects where kubectl and helm run after bootstrap:
+	// ClusterAccessSSH (default) or ClusterAccessLocal
+	ClusterAccess string `json:"clusterAccess,omitempty"`
+
+	// Manifests are local files or directories applied once the cluster is Ready
+	Manifests []string `json:"manifests,omitempty"`
+
+	// Vars are the template variables of every host, see HostVars
+	Vars map[string]string `json:"vars,omitempty"`
+
+	// Security runs security scanners after the addons are installed
+	Security SecurityScan `json:"security,omitempty"`
+
+	// EventSinks are HTTP endpoints receiving run and step CloudEvents
+	EventSinks []string `json:"eventSinks,omitempty"`
+
+	// Tracing exports spans of the run over OTLP/HTTP
+	Tracing Tracing `json:"tracing,omitempty"`
+
+	// Hooks are local commands run before or after steps
+	Hooks []Hook `json:"hooks,omitempty"`
+
+	// Progress streams the log of each host over HTTP during the run
+	Progress Progress `json:"progress,omitempty"`
+
+	// MaintenanceWindow, when set, limits provisioning to the window
+	MaintenanceWindow *MaintenanceWindow `json:"maintenanceWindow,omitempty"`
+
+	// Backup selects the namespaces and resources of the resource backup
+	Backup Backup `json:"backup,omitempty"`
+
+	// LockTTL is how long the lock an operation takes on its hosts holds,
+	// e.g. 6h, default 4h. Runs expected to take longer need a longer TTL.
+	LockTTL string `json:"lockTTL,omitempty"`
+
+	// ConfigDrift decides what an apply does with hosts last provisioned
+	// with a different configuration: "warn" (default) or "refuse", which
+	// requires --force
+	ConfigDrift string `json:"configDrift,omitempty"`
+
+	// Email sends a summary of each run over SMTP
+	Email EmailReport `json:"email,omitempty"`
+
+	// Pushgateway receives the step durations and results of each run
+	Pushgateway Pushgateway `json:"pushgateway,omitempty"`
+
+	// Runbook documents each provisioned cluster for handover
+	Runbook Runbook `json:"runbook,omitempty"`
+
+	// Credentials summarizes the credentials of each provisioned cluster
+	Credentials CredentialsSummary `json:"credentials,omitempty"`
+
+	// Retention prunes old status entries, logs and reports after each run
+	Retention Retention `json:"retention,omitempty"`
+
+	// Windows configures the experimental Windows Server workers
+	Windows Windows `json:"windows,omitempty"`
+
+	// Lab describes the local VMs booted by lab up
+	Lab Lab `json:"lab,omitempty"`
+
+	// Profile selects the kustomize overlay of manifest directories, e.g. staging
+	Profile string `json:"profile,omitempty"`
+
+	// Charts are user Helm releases installed after monitoring and addons
+	Charts []Chart `json:"charts,omitempty"`
+
+	Addons struct {
+		CertManager     CertManagerAddon     `json:"certManager"`
+		ExternalDNS     ExternalDNSAddon     `json:"externalDNS"`
+		Registry        RegistryAddon        `json:"registry"`
+		Falco           FalcoAddon           `json:"falco,omitempty"`
+		Policy          PolicyAddon          `json:"policy,omitempty"`
+		Secrets         SecretsAddon         `json:"secrets,omitempty"`
+		EventExporter   EventExporterAddon   `json:"eventExporter,omitempty"`
+		Descheduler     DeschedulerAddon     `json:"descheduler,omitempty"`
+		Overprovisioner OverprovisionerAddon `json:"overprovisioner,omitempty"`
+		VPA             VPAAddon             `json:"vpa,omitempty"`
+		Tenancy         TenancyAddon         `json:"tenancy,omitempty"`
+	} `json:"addons"`
+
+	// hash is the hash of the file the configuration was parsed from
+	hash string
+}
+
+// Chart is a Helm release installed or upgraded during provisioning
+type Chart struct {
+	Name      string                 `json:"name"`
+	Repo      string                 `json:"repo"`
+	Chart     string                 `json:"chart"`
+	Version   string                 `json:"version,omitempty"`
+	Namespace string                 `json:"namespace"`
+	Values    map[string]interface{} `json:"values,omitempty"`
+
+	// ValuesFiles are local YAML or JSON values files merged in order below
+	// Values. Files ending in .tmpl are rendered with the host vars first.
+	ValuesFiles []string `json:"valuesFiles,omitempty"`
+}
+
+// CertManagerAddon configures cert-manager and the ClusterIssuers it serves
+type CertManagerAddon struct {
+	Enabled bool            `json:"enabled"`
+	Version string          `json:"version"`
+	Issuers []ClusterIssuer `json:"issuers"`
+}
+
+// ClusterIssuer describes a cert-manager ClusterIssuer. Type is "selfSigned"
+// or "acme"; ACME issuers solve challenges with Solver, one of "http01",
+// "cloudflare" or "route53".
+type ClusterIssuer struct {
+	Name         string                `json:"name"`
+	Type         string                `json:"type"`
+	Email        string                `json:"email"`
+	Server       string                `json:"server"`
+	Solver       string                `json:"solver"`
+	IngressClass string                `json:"ingressClass"`
+	Cloudflare   CloudflareCredentials `json:"cloudflare"`
+	Route53      Route53Credentials    `json:"route53"`
+}
+
+// CloudflareCredentials holds a Cloudflare API token with DNS edit rights
+type CloudflareCredentials struct {
+	APIToken string `json:"apiToken"`
+}
+
+// Route53Credentials holds AWS credentials for Route53. Static keys may be
+// omitted when the nodes have an instance profile.
+type Route53Credentials struct {
+	Region          string `json:"region"`
+	HostedZoneID    string `json:"hostedZoneID"`
+	AccessKeyID     string `json:"accessKeyID"`
+	SecretAccessKey string `json:"secretAccessKey"`
+}
+
+// ExternalDNSAddon configures external-dns. Provider is "cloudflare",
+// "route53" or "rfc2136".
+type ExternalDNSAddon struct {
+	Enabled       bool                  `json:"enabled"`
+	Version       string                `json:"version"`
+	Provider      string                `json:"provider"`
+	DomainFilters []string              `json:"domainFilters"`
+	Policy        string                `json:"policy"`
+	TXTOwnerID    string                `json:"txtOwnerID"`
+	Cloudflare    CloudflareCredentials `json:"cloudflare"`
+	Route53       Route53Credentials    `json:"route53"`
+	RFC2136       struct {
+		Host          string `json:"host"`
+		Port          int    `json:"port"`
+		Zone          string `json:"zone"`
+		TSIGKeyName   string `json:"tsigKeyName"`
+		TSIGSecret    string `json:"tsigSecret"`
+		TSIGSecretAlg string `json:"tsigSecretAlg"`
+	} `json:"rfc2136"`
+}
+
+// RegistryAddon configures an in-cluster image registry. Type is either
+// "registry" (plain registry:2) or "harbor".
+type RegistryAddon struct {
+	Enabled       bool   `json:"enabled"`
+	Type          string `json:"type"`
+	Host          string `json:"host"`
+	StorageClass  string `json:"storageClass"`
+	StorageSize   string `json:"storageSize"`
+	IngressClass  string `json:"ingressClass"`
+	ClusterIssuer string `json:"clusterIssuer"`
+	NodePort      int    `json:"nodePort"`
+	AdminPassword string `json:"adminPassword"`
+}
+
+// FalcoAddon configures Falco runtime threat detection. Driver is "auto"
+// (default, picked from the node's kernel), "modern_ebpf", "ebpf" or "kmod".
+type FalcoAddon struct {
+	Enabled bool   `json:"enabled"`
+	Version string `json:"version,omitempty"`
+	Driver  string `json:"driver,omitempty"`
+}
+
+// PolicyAddon configures an admission policy engine, Engine "kyverno"
+// (default) or "gatekeeper", with a starter policy set. Policies picks from
+// "disallow-privileged", "require-requests-limits" and "restrict-registries"
+// and defaults to all of them. Action is "enforce" (default) or "audit".
+type PolicyAddon struct {
+	Enabled           bool     `json:"enabled"`
+	Engine            string   `json:"engine,omitempty"`
+	Version           string   `json:"version,omitempty"`
+	Policies          []string `json:"policies,omitempty"`
+	AllowedRegistries []string `json:"allowedRegistries,omitempty"`
+	Action            string   `json:"action,omitempty"`
+
+	// ExcludeNamespaces are exempt from the policies in addition to the
+	// system and addon namespaces
+	ExcludeNamespaces []string `json:"excludeNamespaces,omitempty"`
+}
+
+// SecretsAddon configures the secret delivery mechanism of the cluster. Type
+// is "sealed-secrets" (default) or "external-secrets", which also creates the
+// ClusterSecretStore described by Store.
+type SecretsAddon struct {
+	Enabled bool        `json:"enabled"`
+	Type    string      `json:"type,omitempty"`
+	Version string      `json:"version,omitempty"`
+	Store   SecretStore `json:"store,omitempty"`
+}
+
+// SecretStore is an External Secrets ClusterSecretStore. Provider is its
+// spec.provider, e.g. {"vault": {...}}. Credentials are stored in the Secret
+// <name>-credentials in the external-secrets namespace for the provider to
+// reference.
+type SecretStore struct {
+	Name        string                 `json:"name,omitempty"`
+	Provider    map[string]interface{} `json:"provider,omitempty"`
+	Credentials map[string]string      `json:"credentials,omitempty"`
+}
+
+// EventExporterAddon ships Kubernetes events to Loki, Elasticsearch or both,
+// keeping them beyond the hour the API server retains them
+type EventExporterAddon struct {
+	Enabled       bool               `json:"enabled"`
+	Version       string             `json:"version,omitempty"`
+	Loki          *LokiSink          `json:"loki,omitempty"`
+	Elasticsearch *ElasticsearchSink `json:"elasticsearch,omitempty"`
+}
+
+// LokiSink is the push endpoint of a Loki instance, e.g.
+// http://loki.logging:3100/loki/api/v1/push
+type LokiSink struct {
+	URL    string            `json:"url"`
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// ElasticsearchSink writes events into daily indices named <index>-YYYY-MM-DD
+type ElasticsearchSink struct {
+	Hosts    []string `json:"hosts"`
+	Index    string   `json:"index,omitempty"`
+	Username string   `json:"username,omitempty"`
+	Password string   `json:"password,omitempty"`
+}
+
+// DeschedulerAddon runs the descheduler as a CronJob on Schedule (default
+// every 15 minutes). Profile is a DeschedulerPolicy profile passed as is,
+// without one the chart's default profile applies.
+type DeschedulerAddon struct {
+	Enabled  bool                   `json:"enabled"`
+	Version  string                 `json:"version,omitempty"`
+	Schedule string                 `json:"schedule,omitempty"`
+	Profile  map[string]interface{} `json:"profile,omitempty"`
+}
+
+// OverprovisionerAddon keeps spare capacity with low-priority pause pods that
+// are preempted as soon as real workloads need the room. Together the
+// Replicas (default 1) request Percent (default 10) of the resources section.
+type OverprovisionerAddon struct {
+	Enabled  bool `json:"enabled"`
+	Replicas int  `json:"replicas,omitempty"`
+	Percent  int  `json:"percent,omitempty"`
+}
+
+// VPAAddon installs the vertical pod autoscaler. Mode is "recommender"
+// (default), which only collects recommendations, or "full", which also
+// installs the updater and admission controller. Namespaces get a
+// VerticalPodAutoscaler for every workload they hold at setup time.
+type VPAAddon struct {
+	Enabled    bool     `json:"enabled"`
+	Version    string   `json:"version,omitempty"`
+	Mode       string   `json:"mode,omitempty"`
+	Namespaces []string `json:"namespaces,omitempty"`
+}
+
+// TenancyAddon shares the cluster between teams. Type is "capsule"
+// (default), which groups namespaces into Capsule tenants, or "vcluster",
+// which gives every tenant a virtual cluster in a host namespace of its own.
+type TenancyAddon struct {
+	Enabled bool     `json:"enabled"`
+	Type    string   `json:"type,omitempty"`
+	Version string   `json:"version,omitempty"`
+	Tenants []Tenant `json:"tenants,omitempty"`
+}
+
+// Tenant is a team sharing the cluster. Owners are user names, entries
+// prefixed with "group:" name groups. Namespaces are created for the tenant
+// up front; CPU, Memory and Pods cap what the tenant uses in total.
+type Tenant struct {
+	Name       string   `json:"name"`
+	Owners     []string `json:"owners"`
+	Namespaces []string `json:"namespaces,omitempty"`
+	CPU        string   `json:"cpu,omitempty"`
+	Memory     string   `json:"memory,omitempty"`
+	Pods       int      `json:"pods,omitempty"`
+}
+
+// Windows holds the versions of the components installed on Windows Server
+// workers, defaulting to containerd 1.7.13 and flannel v0.21.5
+type Windows struct {
+	ContainerdVersion string `json:"containerdVersion,omitempty"`
+	FlannelVersion    string `json:"flannelVersion,omitempty"`
+}
+
+// Registry holds credentials for a private image registry. NodeAuth installs
+// them for the kubelet on every node; Namespaces lists the namespaces whose
+// default ServiceAccount gets an imagePullSecret.
+type Registry struct {
+	Server     string   `json:"server"`
+	Username   string   `json:"username"`
+	Password   string   `json:"password"`
+	NodeAuth   bool     `json:"nodeAuth"`
+	Namespaces []string `json:"namespaces"`
+}
+
+// SecurityScan selects the security scanners. KubeBench runs the CIS
+// benchmark as a Job; Trivy installs trivy-operator and collects the
+// vulnerabilities of running workloads. FailOnCritical fails the run when a
+// critical vulnerability is found. Timeout bounds each scanner (default 10m).
+type SecurityScan struct {
+	KubeBench      bool   `json:"kubeBench,omitempty"`
+	Trivy          bool   `json:"trivy,omitempty"`
+	TrivyVersion   string `json:"trivyVersion,omitempty"`
+	IgnoreUnfixed  bool   `json:"ignoreUnfixed,omitempty"`
+	FailOnCritical bool   `json:"failOnCritical,omitempty"`
+	Timeout        string `json:"timeout,omitempty"`
+}
+
+// Enabled reports whether any scanner is selected
+func (s SecurityScan) Enabled() bool {
+	return s.KubeBench || s.Trivy
+}
+
+// Tracing configures the OTLP/HTTP trace exporter. Endpoint is a URL such as
+// http://tempo:4318/v1/traces; the OTEL_EXPORTER_OTLP_* variables apply too.
+type Tracing struct {
+	Endpoint string `json:"endpoint,omitempty"`
+
+	// Commands records the full command text on command spans instead of
+	// only the program, which may expose secrets passed on command lines
+	Commands bool `json:"commands,omitempty"`
+}
+
+// Progress serves the provisioning log of each host while it runs, for CI
+// systems attaching it to a job. Listen is the address of the HTTP endpoint,
+// e.g. :8088.
+type Progress struct {
+	Listen string `json:"listen,omitempty"`
+}
+
+// RegistryMirror configures how containerd on every node reaches Registry,
+// e.g. docker.io or registry.lab:5000. Endpoints are mirrors tried before the
+// registry itself; Insecure allows plain HTTP and unverified certificates;
+// CAFile is a local PEM file uploaded as the registry's CA.
+type RegistryMirror struct {
+	Registry  string   `json:"registry"`
+	Endpoints []string `json:"endpoints,omitempty"`
+	Insecure  bool     `json:"insecure,omitempty"`
+	CAFile    string   `json:"caFile,omitempty"`
+}
+
+// KubeletReservations holds what the kubelet keeps from the pods of a node,
+// keyed by resource name, e.g. cpu: 500m and memory: 1Gi. System is for the
+// operating system daemons, Kube for the kubelet and the container runtime.
+// Eviction holds the hard eviction thresholds keyed by signal, e.g.
+// memory.available: 200Mi or nodefs.available: 10%.
+type KubeletReservations struct {
+	System   map[string]string `json:"system,omitempty"`
+	Kube     map[string]string `json:"kube,omitempty"`
+	Eviction map[string]string `json:"eviction,omitempty"`
+}
+
+// IsEmpty reports whether nothing is reserved
+func (r KubeletReservations) IsEmpty() bool {
+	return len(r.System) == 0 && len(r.Kube) == 0 && len(r.Eviction) == 0
+}
+
+// ResourceRequirements holds container requests and limits keyed by resource name
+type ResourceRequirements struct {
+	Requests map[string]string `json:"requests,omitempty"`
+	Limits   map[string]string `json:"limits,omitempty"`
+}
+
+// IsEmpty reports whether no requests or limits are set
+func (r ResourceRequirements) IsEmpty() bool {
+	return len(r.Requests) == 0 && len(r.Limits) == 0
+}
+
+// Namespace describes an application namespace created after provisioning.
+// CPU and Memory override the quota derived from the Resources block.
+type Namespace struct {
+	Name   string `json:"name"`
+	CPU    string `json:"cpu,omitempty"`
+	Memory string `json:"memory,omitempty"`
+	Pods   int    `json:"pods,omitempty"`
+}
+
+// SSHLimits throttles the work done on one host
+type SSHLimits struct {
+	// MaxSessions caps the concurrent SSH sessions per host, 0 means unlimited
+	MaxSessions int `json:"maxSessions,omitempty"`
+	// CommandDelay is the pause in milliseconds between two commands
+	CommandDelay int `json:"commandDelay,omitempty"`
+	// BandwidthKBps caps file uploads and downloads in KiB per second
+	BandwidthKBps int `json:"bandwidthKBps,omitempty"`
+	// MaxOutputKB caps the captured output of a command in KiB, keeping its
+	// head and tail; OutputDir receives the full output of truncated commands
+	MaxOutputKB int    `json:"maxOutputKB,omitempty"`
+	OutputDir   string `json:"outputDir,omitempty"`
 }
 
 // VMConfig represents configuration for a single VM
@@ -42,20 +575,284 @@ type VMConfig struct {
 	Username string
 	Password string
 	KeyFile  string
+	CertFile string
+	Port     int
 	Timeout  time.Duration
+
+	Sudo         bool
+	SudoPassword string
+
+	KeyboardInteractive bool
+	OTPEnv              string
+
+	Limits SSHLimits
+
+	// ProxyJump hosts are resolved through the OpenSSH client configuration
+	// in ClientConfig
+	ProxyJump    string
+	ClientConfig string
+
+	Allowlist Allowlist
+
+	// Shell is ssh.shell or the host's override
+	Shell string
+}
+
+// Host is an inventory entry overriding the global SSH settings for one VM.
+// Empty fields fall back to the ssh section.
+type Host struct {
+	IP           string `json:"ip"`
+	Username     string `json:"username,omitempty"`
+	Password     string `json:"password,omitempty"`
+	KeyFile      string `json:"keyFile,omitempty"`
+	CertFile     string `json:"certFile,omitempty"`
+	Port         int    `json:"port,omitempty"`
+	Sudo         *bool  `json:"sudo,omitempty"`
+	SudoPassword string `json:"sudoPassword,omitempty"`
+
+	// ProxyJump lists the [user@]host[:port] jump hosts the host is reached
+	// through, separated by commas, as in OpenSSH
+	ProxyJump string `json:"proxyJump,omitempty"`
+
+	// Shell overrides ssh.shell for the host, e.g. sh on a minimal image
+	Shell string `json:"shell,omitempty"`
+
+	// Roles names the roles whose steps run on the host, e.g. ["gpu"]
+	Roles []string `json:"roles,omitempty"`
+
+	// ClusterName names the cluster the host is the control plane of
+	ClusterName string `json:"clusterName,omitempty"`
+
+	// Vars are template variables of the host, e.g. internalIP or zone,
+	// overriding the global vars, see HostVars
+	Vars map[string]string `json:"vars,omitempty"`
+}
+
+// HostIPs returns the IPs of the inventory hosts
+func (c *Config) HostIPs() []string {
+	ips := make([]string, 0, len(c.Hosts))
+	for _, h := range c.Hosts {
+		ips = append(ips, h.IP)
+	}
+	return ips
+}
+
+// Host returns the inventory entry for ip, if there is one
+func (c *Config) Host(ip string) (Host, bool) {
+	for _, h := range c.Hosts {
+		if h.IP == ip {
+			return h, true
+		}
+	}
+	return Host{}, false
+}
+
+// VMConfig returns the SSH connection settings for the VM at ip
+func (c *Config) VMConfig(ip string) VMConfig {
+	vm := VMConfig{
+		IP:       ip,
+		Username: c.SSHConfig.Username,
+		Password: c.SSHConfig.Password,
+		KeyFile:  c.SSHConfig.KeyFile,
+		CertFile: c.SSHConfig.CertFile,
+		Port:     c.SSHConfig.Port,
+		Timeout:  c.SSHTimeout(),
+
+		Sudo:         c.SSHConfig.Sudo,
+		SudoPassword: c.SSHConfig.SudoPassword,
+
+		KeyboardInteractive: c.SSHConfig.KeyboardInteractive,
+		OTPEnv:              c.SSHConfig.OTPEnv,
+
+		Limits: c.SSHConfig.Limits,
+
+		ClientConfig: c.SSHConfig.ConfigFile,
+
+		Allowlist: c.SSHConfig.Allowlist,
+
+		Shell: c.SSHConfig.Shell,
+	}
+
+	if host, ok := c.Host(ip); ok {
+		if host.Username != "" {
+			vm.Username = host.Username
+		}
+		if host.Password != "" {
+			vm.Password = host.Password
+		}
+		if host.KeyFile != "" {
+			vm.KeyFile = host.KeyFile
+			// The global certificate does not belong to a host specific key
+			vm.CertFile = ""
+		}
+		if host.CertFile != "" {
+			vm.CertFile = host.CertFile
+		}
+		if host.Port != 0 {
+			vm.Port = host.Port
+		}
+		if host.Sudo != nil {
+			vm.Sudo = *host.Sudo
+		}
+		if host.SudoPassword != "" {
+			vm.SudoPassword = host.SudoPassword
+		}
+		vm.ProxyJump = host.ProxyJump
+		if host.Shell != "" {
+			vm.Shell = host.Shell
+		}
+	}
+
+	if vm.Port == 0 {
+		vm.Port = 22
+	}
+
+	return vm
 }
 
-// LoadConfig loads configuration from a JSON file
+// Parse parses a configuration of any schema version, see Migrate, and
+// returns it with the deprecation notices of its settings
+func Parse(data []byte) (*Config, []string, error) {
+	raw, notices, _, err := Migrate(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	// Maps marshal with sorted keys, the version is the same for every file
+	version := raw["schemaVersion"]
+	delete(raw, "schemaVersion")
+	canonical, err := json.Marshal(raw)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse config file: %v", err)
+	}
+	raw["schemaVersion"] = version
+	if data, err = json.Marshal(raw); err != nil {
+		return nil, nil, fmt.Errorf("failed to parse config file: %v", err)
+	}
+
+	var config Config
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, nil, fmt.Errorf("failed to parse config file: %v", err)
+	}
+	config.hash = hashOf(canonical)
+	return &config, notices, nil
+}
+
+// Duration is a duration written like "30s", or as a number of seconds as
+// in files of schema version 1
+type Duration time.Duration
+
+// UnmarshalJSON accepts both forms of a duration
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var seconds float64
+	if err := json.Unmarshal(data, &seconds); err == nil {
+		*d = Duration(seconds * float64(time.Second))
+		return nil
+	}
+	var text string
+	if err := json.Unmarshal(data, &text); err != nil {
+		return fmt.Errorf("invalid duration %s", data)
+	}
+	parsed, err := time.ParseDuration(text)
+	if err != nil {
+		return fmt.Errorf("invalid duration %q: %v", text, err)
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// MarshalJSON writes the duration in the current form, e.g. "30s"
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// SSHTimeout returns ssh.timeout, 0 for none
+func (c *Config) SSHTimeout() time.Duration {
+	return time.Duration(c.SSHConfig.Timeout)
+}
+
+// defaultLockTTL is how long a lock holds when LockTTL is not set
+const defaultLockTTL = 4 * time.Hour
+
+// LockTTLDuration returns the parsed LockTTL or the default TTL
+func (c *Config) LockTTLDuration() (time.Duration, error) {
+	if c.LockTTL == "" {
+		return defaultLockTTL, nil
+	}
+	ttl, err := time.ParseDuration(c.LockTTL)
+	if err != nil || ttl <= 0 {
+		return 0, fmt.Errorf("invalid lockTTL %q", c.LockTTL)
+	}
+	return ttl, nil
+}
+
+// Hash returns the hex encoded SHA-256 of the configuration as the file
+// sets it, upgraded to SchemaVersion. Neither the formatting of the file nor
+// the fields it leaves out count, so a release adding fields or resolving the
+// targets keeps the hash. A Config not parsed from a file hashes its values.
+func (c *Config) Hash() string {
+	if c.hash != "" {
+		return c.hash
+	}
+	data, _ := json.Marshal(c)
+	return hashOf(data)
+}
+
+// hashOf returns the hex encoded SHA-256 of data
+func hashOf(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// Config drift policies
+const (
+	ConfigDriftWarn   = "warn"
+	ConfigDriftRefuse = "refuse"
+)
+
+// Cgroup drivers
+const (
+	CgroupDriverSystemd  = "systemd"
+	CgroupDriverCgroupfs = "cgroupfs"
+)
+
+// Remote shells, see ssh.shell
+const (
+	ShellAuto  = "auto"
+	ShellBash  = "bash"
+	ShellSh    = "sh"
+	ShellLogin = "login"
+)
+
+// Cluster access modes
+const (
+	ClusterAccessSSH   = "ssh"
+	ClusterAccessLocal = "local"
+)
+
+// ProfileEnv overrides the profile of the configuration file
+const ProfileEnv = "K8S_SETUP_PROFILE"
+
+// LoadConfig loads configuration from a JSON file. Files of an older
+// schema version are upgraded in memory with a warning for every deprecated
+// setting, config migrate writes the upgraded file.
 func LoadConfig(path string) (*Config, error) {
 	data, err := ioutil.ReadFile(path)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read config file: %v", err)
 	}
 
-	var config Config
-	if err := json.Unmarshal(data, &config); err != nil {
-		return nil, fmt.Errorf("failed to parse config file: %v", err)
+	config, notices, err := Parse(data)
+	if err != nil {
+		return nil, err
+	}
+	for _, notice := range notices {
+		// Warnings go to stderr, so commands printing JSON or YAML stay parseable
+		fmt.Fprintf(os.Stderr, "Warning: %s: %s, run k8s-setup config migrate %s\n", path, notice, path)
+	}
+
+	if profile := os.Getenv(ProfileEnv); profile != "" {
+		config.Profile = profile
 	}
 
-	return &config, nil
+	return config, nil
 }
@@ -0,0 +1,262 @@
+package config
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMaintenanceWindow(t *testing.T) {
+	utc := func(value string) time.Time {
+		ts, err := time.Parse("2006-01-02 15:04", value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ts
+	}
+
+	// 2024-01-06 is a Saturday
+	window := &MaintenanceWindow{Start: "22:00", Duration: "4h", Days: []string{"Sat"}, Timezone: "UTC"}
+
+	tests := []struct {
+		now     string
+		end     string
+		outside bool
+	}{
+		{now: "2024-01-06 22:30", end: "2024-01-07 02:00"},
+		{now: "2024-01-07 01:59", end: "2024-01-07 02:00"},
+		{now: "2024-01-07 02:00", outside: true},
+		{now: "2024-01-06 21:59", outside: true},
+		{now: "2024-01-08 23:00", outside: true},
+	}
+
+	for _, tt := range tests {
+		end, err := window.End(utc(tt.now))
+		if tt.outside {
+			if err == nil || !strings.Contains(err.Error(), "opens next at") {
+				t.Errorf("%s: expected to be outside the window, got end %v, err %v", tt.now, end, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.now, err)
+			continue
+		}
+		if !end.Equal(utc(tt.end)) {
+			t.Errorf("%s: window ends at %v, expected %s", tt.now, end, tt.end)
+		}
+	}
+}
+
+func TestLabNodes(t *testing.T) {
+	nodes, err := Lab{}.LabNodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nodes) != 2 || nodes[0].Role != LabControlPlane || nodes[1].Role != LabWorker {
+		t.Fatalf("default nodes = %+v, want a control plane and a worker", nodes)
+	}
+	if nodes[1].CPUs != 2 || nodes[1].Memory != "4Gi" || nodes[1].Disk != "20Gi" {
+		t.Errorf("default size = %+v", nodes[1])
+	}
+
+	nodes, err = Lab{Nodes: []LabNode{{Name: "a"}, {Name: "b", CPUs: 4, Memory: "8Gi"}}}.LabNodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nodes[0].Role != LabControlPlane || nodes[1].Role != LabWorker || nodes[1].CPUs != 4 {
+		t.Errorf("nodes = %+v, want the first one as control plane", nodes)
+	}
+
+	if _, err := (Lab{Nodes: []LabNode{{Name: "a", Role: LabWorker}}}).LabNodes(); err == nil {
+		t.Error("expected an error without a control plane")
+	}
+	if _, err := (Lab{Nodes: []LabNode{{Name: "a", Memory: "lots"}}}).LabNodes(); err == nil {
+		t.Error("expected an error for an invalid memory size")
+	}
+}
+
+func TestHash(t *testing.T) {
+	dir := t.TempDir()
+	load := func(name, data string) *Config {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		cfg, err := LoadConfig(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cfg
+	}
+
+	compact := load("compact.json", `{"kubernetes":{"version":"1.28.2-00","podCIDR":"10.244.0.0/16"}}`)
+	indented := load("indented.json", "{\n  \"kubernetes\": {\n    \"podCIDR\": \"10.244.0.0/16\",\n    \"version\": \"1.28.2-00\"\n  }\n}\n")
+	if compact.Hash() != indented.Hash() {
+		t.Error("formatting changed the hash")
+	}
+
+	changed := load("changed.json", `{"kubernetes":{"version":"1.29.0-00","podCIDR":"10.244.0.0/16"}}`)
+	if compact.Hash() == changed.Hash() {
+		t.Error("a changed version kept the hash")
+	}
+
+	// Fields the file leaves out and later changes to the loaded values,
+	// such as resolved targets, do not count
+	versioned := load("versioned.json", `{"schemaVersion":2,"kubernetes":{"version":"1.28.2-00","podCIDR":"10.244.0.0/16"}}`)
+	versioned.Hosts = append(versioned.Hosts, Host{IP: "10.0.0.2"})
+	if compact.Hash() != versioned.Hash() {
+		t.Error("the schema version or a resolved host changed the hash")
+	}
+
+	// A file upgraded with config migrate keeps its hash
+	legacy := load("legacy.json", `{"ssh":{"timeout":30}}`)
+	migrated := load("migrated.json", `{"schemaVersion":2,"ssh":{"timeout":"30s"}}`)
+	if legacy.Hash() != migrated.Hash() {
+		t.Error("migrating the file changed the hash")
+	}
+}
+
+func TestHostVars(t *testing.T) {
+	cfg := &Config{
+		Vars:  map[string]string{"zone": "a", "tier": "default"},
+		Hosts: []Host{{IP: "10.0.0.2", Vars: map[string]string{"zone": "b", "internalIP": "192.168.0.2"}}},
+	}
+
+	got, err := Render("test", "{{ .ip }} {{ .internalIP }} {{ .zone }} {{ .tier }}", cfg.HostVars("10.0.0.2"))
+	if err != nil || got != "10.0.0.2 192.168.0.2 b default" {
+		t.Errorf("Render = %q, %v", got, err)
+	}
+	if _, err := Render("test", "{{ .internalIP }}", cfg.HostVars("10.0.0.1")); err == nil {
+		t.Error("expected an error for a variable the host does not define")
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	raw, notices, version, err := Migrate([]byte(`{"ssh":{"username":"root","timeout":30}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != 1 || raw["schemaVersion"] != SchemaVersion {
+		t.Errorf("version = %d, schemaVersion = %v, want 1 upgraded to %d", version, raw["schemaVersion"], SchemaVersion)
+	}
+	if timeout := raw["ssh"].(map[string]interface{})["timeout"]; timeout != "30s" {
+		t.Errorf("ssh.timeout = %v, want 30s", timeout)
+	}
+	if len(notices) != 1 || !strings.Contains(notices[0], "ssh.timeout") {
+		t.Errorf("notices = %q, want one for ssh.timeout", notices)
+	}
+
+	_, notices, version, err = Migrate([]byte(`{"schemaVersion":2,"ssh":{"timeout":"1m"}}`))
+	if err != nil || version != 2 || len(notices) != 0 {
+		t.Errorf("current file: version %d, notices %q, err %v", version, notices, err)
+	}
+	if _, _, _, err := Migrate([]byte(`{"schemaVersion":99}`)); err == nil || !strings.Contains(err.Error(), "newer") {
+		t.Errorf("expected a newer schema version to be refused, got %v", err)
+	}
+
+	cfg, notices, err := Parse([]byte(`{"ssh":{"timeout":15}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.SSHTimeout() != 15*time.Second || len(notices) != 1 {
+		t.Errorf("old file loaded with timeout %v, notices %q", cfg.SSHTimeout(), notices)
+	}
+	if _, _, err := Parse([]byte(`{"schemaVersion":2,"ssh":{"timeout":"soon"}}`)); err == nil {
+		t.Error("expected an invalid ssh.timeout to be refused")
+	}
+
+	// Both forms load whatever the schema version
+	for _, data := range []string{`{"schemaVersion":2,"ssh":{"timeout":45}}`, `{"ssh":{"timeout":"45s"}}`} {
+		if cfg, _, err := Parse([]byte(data)); err != nil || cfg.SSHTimeout() != 45*time.Second {
+			t.Errorf("%s: timeout %v, err %v", data, cfg.SSHTimeout(), err)
+		}
+	}
+}
+
+// capture returns what f writes to stdout and stderr
+func capture(t *testing.T, f func()) (string, string) {
+	t.Helper()
+	read := func(file **os.File) func() string {
+		r, w, err := os.Pipe()
+		if err != nil {
+			t.Fatal(err)
+		}
+		orig := *file
+		*file = w
+		done := make(chan string)
+		go func() {
+			data, _ := ioutil.ReadAll(r)
+			done <- string(data)
+		}()
+		return func() string {
+			*file = orig
+			w.Close()
+			return <-done
+		}
+	}
+	stdout, stderr := read(&os.Stdout), read(&os.Stderr)
+	f()
+	return stdout(), stderr()
+}
+
+func TestLoadConfigWarnings(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "legacy.json")
+	if err := ioutil.WriteFile(path, []byte(`{"ssh":{"timeout":30}}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	stdout, stderr := capture(t, func() {
+		if _, err := LoadConfig(path); err != nil {
+			t.Error(err)
+		}
+	})
+	if stdout != "" {
+		t.Errorf("deprecation warnings on stdout: %q", stdout)
+	}
+	if !strings.Contains(stderr, "Warning: "+path) || !strings.Contains(stderr, "config migrate") {
+		t.Errorf("stderr %q, want the deprecation warning", stderr)
+	}
+}
+
+func TestMigrateFlatFile(t *testing.T) {
+	// The example configuration of the repository is the flat, unversioned format
+	data, err := ioutil.ReadFile("../../config.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, notices, version, err := Migrate(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != 1 || len(notices) != 1 {
+		t.Errorf("version %d, notices %q, want 1 with the ssh.timeout notice", version, notices)
+	}
+
+	migrated, err := json.Marshal(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, notices, err := Parse(migrated)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(notices) != 0 {
+		t.Errorf("migrated file still has notices %q", notices)
+	}
+	flat, _, err := Parse(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(cfg, flat) {
+		t.Errorf("the migrated file loads as\n%+v\nthe flat file as\n%+v", cfg, flat)
+	}
+	if cfg.SSHTimeout() != 30*time.Second || cfg.Kubernetes.PodCIDR != "10.244.0.0/16" || cfg.Monitoring.Grafana.Domain != "grafana.example.com" {
+		t.Errorf("settings lost in the migration: %+v", cfg)
+	}
+}
@@ -0,0 +1,19 @@
+package config
+
+// EmailReport mails a summary of every provisioning run to To. Host and Port
+// (default 587) locate the SMTP server, which is asked for STARTTLS;
+// Username and Password, when set, authenticate with PLAIN auth.
+// OnlyOnFailure skips runs in which every host completed. ReportURL, the
+// address the status directory is published under, turns the local report
+// paths in the summary into links.
+type EmailReport struct {
+	Enabled       bool     `json:"enabled"`
+	Host          string   `json:"host"`
+	Port          int      `json:"port,omitempty"`
+	Username      string   `json:"username,omitempty"`
+	Password      string   `json:"password,omitempty"`
+	From          string   `json:"from"`
+	To            []string `json:"to"`
+	OnlyOnFailure bool     `json:"onlyOnFailure,omitempty"`
+	ReportURL     string   `json:"reportURL,omitempty"`
+}
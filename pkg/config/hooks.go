@@ -0,0 +1,32 @@
+package config
+
+// When a hook runs relative to its step
+const (
+	HookBefore = "before"
+	HookAfter  = "after"
+)
+
+// Hook is a command run with sh -c on the machine running the tool before or
+// after a step, e.g. to update a CMDB, DNS or refresh Terraform outputs. Step
+// is a completed step name such as "kubernetes" or "monitoring", or "*" for
+// every step. When is HookBefore or HookAfter (default). A failing before
+// hook with Required set fails the host; every other failure is a warning.
+// Timeout bounds the command, default 5m; the shell is killed after it.
+// With Template set, Command is rendered with the host vars first.
+type Hook struct {
+	Step     string `json:"step"`
+	When     string `json:"when,omitempty"`
+	Command  string `json:"command"`
+	Required bool   `json:"required,omitempty"`
+	Timeout  string `json:"timeout,omitempty"`
+	Template bool   `json:"template,omitempty"`
+}
+
+// Matches reports whether the hook runs at when around step
+func (h Hook) Matches(when, step string) bool {
+	hookWhen := h.When
+	if hookWhen == "" {
+		hookWhen = HookAfter
+	}
+	return hookWhen == when && (h.Step == "*" || h.Step == step)
+}
@@ -0,0 +1,82 @@
+package config
+
+import "fmt"
+
+// Roles of the lab nodes
+const (
+	LabControlPlane = "control-plane"
+	LabWorker       = "worker"
+)
+
+// Lab describes the VMs lab up boots on this machine. Provider is
+// "multipass" (default) or "vagrant", Image the Multipass image (default
+// 22.04) or Vagrant box (default ubuntu/jammy64). Vagrant VMs get static
+// addresses on the Network /24, 192.168.56 by default.
+type Lab struct {
+	Name     string    `json:"name,omitempty"`
+	Provider string    `json:"provider,omitempty"`
+	Image    string    `json:"image,omitempty"`
+	Network  string    `json:"network,omitempty"`
+	Nodes    []LabNode `json:"nodes,omitempty"`
+}
+
+// LabNode is one lab VM. Memory and Disk use Kubernetes quantities.
+type LabNode struct {
+	Name   string `json:"name"`
+	Role   string `json:"role,omitempty"`
+	CPUs   int    `json:"cpus,omitempty"`
+	Memory string `json:"memory,omitempty"`
+	Disk   string `json:"disk,omitempty"`
+}
+
+// LabNodes returns the lab VMs with the defaults filled in: a control plane
+// and a worker when none are configured, each with 2 CPUs, 4Gi of memory and
+// a 20Gi disk. Exactly one node must be the control plane.
+func (l Lab) LabNodes() ([]LabNode, error) {
+	nodes := l.Nodes
+	if len(nodes) == 0 {
+		nodes = []LabNode{{Name: "cp", Role: LabControlPlane}, {Name: "worker-1", Role: LabWorker}}
+	}
+
+	controlPlanes := 0
+	result := make([]LabNode, 0, len(nodes))
+	for i, n := range nodes {
+		if n.Name == "" {
+			n.Name = fmt.Sprintf("node-%d", i+1)
+		}
+		if n.Role == "" {
+			n.Role = LabWorker
+			if i == 0 {
+				n.Role = LabControlPlane
+			}
+		}
+		switch n.Role {
+		case LabControlPlane:
+			controlPlanes++
+		case LabWorker:
+		default:
+			return nil, fmt.Errorf("lab node %s: unknown role %q", n.Name, n.Role)
+		}
+		if n.CPUs == 0 {
+			n.CPUs = 2
+		}
+		if n.Memory == "" {
+			n.Memory = "4Gi"
+		}
+		if _, err := ParseMemory(n.Memory); err != nil {
+			return nil, fmt.Errorf("lab node %s: %v", n.Name, err)
+		}
+		if n.Disk == "" {
+			n.Disk = "20Gi"
+		}
+		if _, err := ParseMemory(n.Disk); err != nil {
+			return nil, fmt.Errorf("lab node %s: %v", n.Name, err)
+		}
+		result = append(result, n)
+	}
+
+	if controlPlanes != 1 {
+		return nil, fmt.Errorf("lab needs exactly one control plane node, found %d", controlPlanes)
+	}
+	return result, nil
+}
@@ -0,0 +1,13 @@
+package config
+
+// MetricFilter keeps busy clusters from filling the Prometheus volume.
+// DropMetrics are regular expressions of metric names dropped at scrape
+// time from the kubelet, cAdvisor and kube-state-metrics. PodLabels are the
+// pod labels kube-state-metrics exports in kube_pod_labels, none by default.
+// KubeStateMetricsDenylist names metric families kube-state-metrics does not
+// generate at all, e.g. kube_replicaset_.*.
+type MetricFilter struct {
+	DropMetrics              []string `json:"dropMetrics,omitempty"`
+	PodLabels                []string `json:"podLabels,omitempty"`
+	KubeStateMetricsDenylist []string `json:"kubeStateMetricsDenylist,omitempty"`
+}
@@ -0,0 +1,64 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// SchemaVersion is the version of the configuration format this build
+// reads and writes. Files without schemaVersion are version 1, the format
+// before it was versioned.
+const SchemaVersion = 2
+
+// migration upgrades a configuration by one schema version, editing raw in
+// place, and returns a notice for every deprecated setting it converted
+type migration func(raw map[string]interface{}) []string
+
+// migrations upgrade one schema version each, migrations[0] from 1 to 2
+var migrations = []migration{
+	migrateSSHTimeout,
+}
+
+// Migrate upgrades the configuration data to SchemaVersion. It returns the
+// upgraded configuration, the deprecation notices of the settings it
+// converted and the version data had.
+func Migrate(data []byte) (map[string]interface{}, []string, int, error) {
+	var raw map[string]interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, nil, 0, fmt.Errorf("failed to parse config file: %v", err)
+	}
+	if raw == nil {
+		raw = map[string]interface{}{}
+	}
+
+	version := 1
+	if v, ok := raw["schemaVersion"]; ok {
+		n, ok := v.(float64)
+		if !ok || n < 1 || n != float64(int(n)) {
+			return nil, nil, 0, fmt.Errorf("invalid schemaVersion %v", v)
+		}
+		version = int(n)
+	}
+	if version > SchemaVersion {
+		return nil, nil, 0, fmt.Errorf("schemaVersion %d is newer than %d, the newest this build reads, update k8s-setup", version, SchemaVersion)
+	}
+
+	var notices []string
+	for v := version; v < SchemaVersion; v++ {
+		notices = append(notices, migrations[v-1](raw)...)
+	}
+	raw["schemaVersion"] = SchemaVersion
+	return raw, notices, version, nil
+}
+
+// migrateSSHTimeout turns ssh.timeout from a number of seconds into a
+// duration like the other timeouts of the file
+func migrateSSHTimeout(raw map[string]interface{}) []string {
+	ssh, _ := raw["ssh"].(map[string]interface{})
+	seconds, ok := ssh["timeout"].(float64)
+	if !ok {
+		return nil
+	}
+	ssh["timeout"] = fmt.Sprintf("%ds", int(seconds))
+	return []string{fmt.Sprintf("ssh.timeout as a number of seconds is deprecated, use a duration: %q", ssh["timeout"])}
+}
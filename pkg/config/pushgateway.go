@@ -0,0 +1,12 @@
+package config
+
+// Pushgateway pushes the metrics of every provisioning and upgrade run to
+// the Prometheus Pushgateway at URL, e.g. http://pushgateway:9091, under Job
+// (default "k8s-setup"). Username and Password, when set, authenticate with
+// basic auth.
+type Pushgateway struct {
+	URL      string `json:"url"`
+	Job      string `json:"job,omitempty"`
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+}
@@ -0,0 +1,75 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var memorySuffixes = []struct {
+	suffix     string
+	multiplier int64
+}{
+	{"Ki", 1 << 10},
+	{"Mi", 1 << 20},
+	{"Gi", 1 << 30},
+	{"Ti", 1 << 40},
+	{"k", 1000},
+	{"M", 1000 * 1000},
+	{"G", 1000 * 1000 * 1000},
+	{"T", 1000 * 1000 * 1000 * 1000},
+}
+
+// ParseCPU converts a Kubernetes CPU quantity such as "2" or "500m" to millicores
+func ParseCPU(value string) (int64, error) {
+	value = strings.TrimSpace(value)
+	if strings.HasSuffix(value, "m") {
+		milli, err := strconv.ParseInt(strings.TrimSuffix(value, "m"), 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid CPU quantity %q: %v", value, err)
+		}
+		return milli, nil
+	}
+
+	cores, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid CPU quantity %q: %v", value, err)
+	}
+	return int64(cores * 1000), nil
+}
+
+// ParseMemory converts a Kubernetes memory quantity such as "4Gi" to bytes
+func ParseMemory(value string) (int64, error) {
+	value = strings.TrimSpace(value)
+	for _, s := range memorySuffixes {
+		if strings.HasSuffix(value, s.suffix) {
+			n, err := strconv.ParseFloat(strings.TrimSuffix(value, s.suffix), 64)
+			if err != nil {
+				return 0, fmt.Errorf("invalid memory quantity %q: %v", value, err)
+			}
+			return int64(n * float64(s.multiplier)), nil
+		}
+	}
+
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid memory quantity %q: %v", value, err)
+	}
+	return n, nil
+}
+
+// FormatCPU renders millicores as a Kubernetes CPU quantity
+func FormatCPU(milli int64) string {
+	if milli%1000 == 0 {
+		return strconv.FormatInt(milli/1000, 10)
+	}
+	return fmt.Sprintf("%dm", milli)
+}
+
+// FormatMemory renders bytes as a Kubernetes memory quantity in Mi
+func FormatMemory(bytes int64) string {
+	if bytes%(1<<30) == 0 {
+		return fmt.Sprintf("%dGi", bytes/(1<<30))
+	}
+	return fmt.Sprintf("%dMi", bytes/(1<<20))
+}
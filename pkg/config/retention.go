@@ -0,0 +1,32 @@
+package config
+
+import (
+	"fmt"
+	"time"
+)
+
+// Retention bounds what accumulates on a management host running the tool
+// repeatedly. MaxAge (e.g. 720h) drops the operation history, failed status
+// entries, truncated command output and support bundles older than it;
+// MaxCount keeps at most that many of the operations and files.
+type Retention struct {
+	MaxAge   string `json:"maxAge,omitempty"`
+	MaxCount int    `json:"maxCount,omitempty"`
+}
+
+// Enabled reports whether any limit is set
+func (r Retention) Enabled() bool {
+	return r.MaxAge != "" || r.MaxCount > 0
+}
+
+// MaxAgeDuration returns the parsed MaxAge, 0 when it is not set
+func (r Retention) MaxAgeDuration() (time.Duration, error) {
+	if r.MaxAge == "" {
+		return 0, nil
+	}
+	age, err := time.ParseDuration(r.MaxAge)
+	if err != nil || age <= 0 {
+		return 0, fmt.Errorf("invalid retention maxAge %q", r.MaxAge)
+	}
+	return age, nil
+}
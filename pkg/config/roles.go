@@ -0,0 +1,40 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Role is a set of extra steps run on the inventory hosts carrying it, on top
+// of the control plane or worker setup, e.g. a "gpu" role installing drivers.
+// Packages are installed with apt-get before Commands run in order, then the
+// node is labelled node-role.kubernetes.io/<role> plus Labels and tainted
+// with Taints such as "nvidia.com/gpu=present:NoSchedule". With Template set,
+// the Commands are rendered with the host vars of the node first.
+type Role struct {
+	Packages []string          `json:"packages,omitempty"`
+	Commands []string          `json:"commands,omitempty"`
+	Labels   map[string]string `json:"labels,omitempty"`
+	Taints   []string          `json:"taints,omitempty"`
+	Template bool              `json:"template,omitempty"`
+}
+
+// HostRoles returns the names of the roles of the inventory host at ip, in
+// the order given, or an error naming a role the configuration does not define
+func (c *Config) HostRoles(ip string) ([]string, error) {
+	host, ok := c.Host(ip)
+	if !ok {
+		return nil, nil
+	}
+	for _, name := range host.Roles {
+		if _, ok := c.Roles[name]; !ok {
+			defined := make([]string, 0, len(c.Roles))
+			for role := range c.Roles {
+				defined = append(defined, role)
+			}
+			sort.Strings(defined)
+			return nil, fmt.Errorf("host %s has undefined role %q, defined roles: %v", ip, name, defined)
+		}
+	}
+	return host.Roles, nil
+}
@@ -0,0 +1,25 @@
+package config
+
+// Runbook formats
+const (
+	RunbookMarkdown = "markdown"
+	RunbookHTML     = "html"
+)
+
+// Runbook writes a handover document for every host that completed a run:
+// component versions, endpoints, where the credentials and backups are, and
+// the steps that ran. Format is RunbookMarkdown (default) or RunbookHTML.
+type Runbook struct {
+	Enabled bool   `json:"enabled"`
+	Format  string `json:"format,omitempty"`
+}
+
+// CredentialsSummary collects the credentials of every host that completed a
+// run into one file readable only by the operator, instead of leaving them
+// in the configuration, the logs and the secrets of the cluster. With
+// AgeRecipients, age public keys or SSH public keys, it is encrypted for
+// them with the age tool instead.
+type CredentialsSummary struct {
+	Enabled       bool     `json:"enabled"`
+	AgeRecipients []string `json:"ageRecipients,omitempty"`
+}
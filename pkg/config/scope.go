@@ -0,0 +1,15 @@
+package config
+
+// MonitoringScope installs the monitoring stack on shared clusters where the
+// tool user is not cluster-admin. Prometheus only watches the monitoring
+// namespace and Namespaces, its permissions are namespaced Roles, and the
+// cluster-wide exporters, default rules and CRDs are left out. The
+// monitoring namespace and the Prometheus operator CRDs have to exist.
+type MonitoringScope struct {
+	Namespaces []string `json:"namespaces,omitempty"`
+}
+
+// Enabled reports whether the monitoring stack is scoped to namespaces
+func (s MonitoringScope) Enabled() bool {
+	return len(s.Namespaces) > 0
+}
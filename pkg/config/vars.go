@@ -0,0 +1,41 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"text/template"
+)
+
+// HostVars returns the template variables of the host at ip: the built-in
+// ip, clusterName and profile, then the global vars and the vars of its
+// inventory entry, each overriding the ones before
+func (c *Config) HostVars(ip string) map[string]string {
+	vars := map[string]string{
+		"ip":          ip,
+		"clusterName": c.ClusterName(ip),
+		"profile":     c.Profile,
+	}
+	for k, v := range c.Vars {
+		vars[k] = v
+	}
+	if host, ok := c.Host(ip); ok {
+		for k, v := range host.Vars {
+			vars[k] = v
+		}
+	}
+	return vars
+}
+
+// Render executes text as a Go template over vars, e.g. {{ .zone }}. A
+// variable that is not defined is an error rather than an empty string.
+func Render(name, text string, vars map[string]string) (string, error) {
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse template %s: %v", name, err)
+	}
+	var b bytes.Buffer
+	if err := tmpl.Execute(&b, vars); err != nil {
+		return "", fmt.Errorf("failed to render template %s: %v", name, err)
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,90 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// defaultWindowReserve is how much of the maintenance window is kept free by
+// not starting new steps
+const defaultWindowReserve = 15 * time.Minute
+
+// MaintenanceWindow restricts when provisioning may run. Start is the local
+// time the window opens (HH:MM in Timezone, default the local zone), Duration
+// how long it stays open and Days the weekdays it opens on (default every
+// day). No step is started once less than Reserve is left.
+type MaintenanceWindow struct {
+	Start    string   `json:"start"`
+	Duration string   `json:"duration"`
+	Days     []string `json:"days,omitempty"`
+	Timezone string   `json:"timezone,omitempty"`
+	Reserve  string   `json:"reserve,omitempty"`
+}
+
+// End returns when the window that now falls into closes, or an error naming
+// the next opening when now is outside the window
+func (w *MaintenanceWindow) End(now time.Time) (time.Time, error) {
+	start, duration, err := w.parse()
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	loc := time.Local
+	if w.Timezone != "" {
+		if loc, err = time.LoadLocation(w.Timezone); err != nil {
+			return time.Time{}, fmt.Errorf("invalid maintenance window timezone: %v", err)
+		}
+	}
+	now = now.In(loc)
+
+	// The window may have opened on one of the previous days and still be open
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+	for days := -int(duration/(24*time.Hour)) - 1; days <= 7; days++ {
+		opens := midnight.AddDate(0, 0, days).Add(start)
+		if !w.opensOn(opens.Weekday()) {
+			continue
+		}
+		closes := opens.Add(duration)
+		if !now.Before(opens) && now.Before(closes) {
+			return closes, nil
+		}
+		if now.Before(opens) {
+			return time.Time{}, fmt.Errorf("outside the maintenance window, it opens next at %s", opens.Format(time.RFC1123))
+		}
+	}
+	return time.Time{}, fmt.Errorf("maintenance window has no matching days")
+}
+
+// ReserveDuration returns how long before the end of the window no new step is started
+func (w *MaintenanceWindow) ReserveDuration() time.Duration {
+	if reserve, err := time.ParseDuration(w.Reserve); err == nil {
+		return reserve
+	}
+	return defaultWindowReserve
+}
+
+func (w *MaintenanceWindow) parse() (time.Duration, time.Duration, error) {
+	clock, err := time.Parse("15:04", w.Start)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid maintenance window start %q, expected HH:MM", w.Start)
+	}
+	duration, err := time.ParseDuration(w.Duration)
+	if err != nil || duration <= 0 {
+		return 0, 0, fmt.Errorf("invalid maintenance window duration %q", w.Duration)
+	}
+	start := time.Duration(clock.Hour())*time.Hour + time.Duration(clock.Minute())*time.Minute
+	return start, duration, nil
+}
+
+func (w *MaintenanceWindow) opensOn(day time.Weekday) bool {
+	if len(w.Days) == 0 {
+		return true
+	}
+	for _, d := range w.Days {
+		if strings.EqualFold(d, day.String()) || strings.EqualFold(d, day.String()[:3]) {
+			return true
+		}
+	}
+	return false
+}
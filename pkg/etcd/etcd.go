@@ -0,0 +1,276 @@
+// Package etcd inspects and maintains the stacked etcd members of a kubeadm
+// cluster through etcdctl in the etcd static pods.
+package etcd
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/maarulav/k8s-setup/pkg/ssh"
+)
+
+// DefaultQuota is the backend quota of etcd when --quota-backend-bytes is
+// not set
+const DefaultQuota = 2 << 30
+
+// etcdctl runs etcdctl in the first etcd static pod with the client
+// certificates kubeadm generates
+const etcdctl = "kubectl -n kube-system exec $(kubectl -n kube-system get pods -l component=etcd -o jsonpath='{.items[0].metadata.name}') -- etcdctl" +
+	" --cacert=/etc/kubernetes/pki/etcd/ca.crt --cert=/etc/kubernetes/pki/etcd/server.crt --key=/etc/kubernetes/pki/etcd/server.key"
+
+// Member is an etcd member and the state of its endpoint
+type Member struct {
+	ID       uint64
+	Name     string
+	Endpoint string
+
+	Healthy bool
+	Error   string
+
+	Leader      bool
+	Version     string
+	DBSize      int64
+	DBSizeInUse int64
+}
+
+// Fragmentation is the share of the database file not in use, which a
+// defragmentation returns to the file system
+func (m Member) Fragmentation() float64 {
+	if m.DBSize == 0 {
+		return 0
+	}
+	return float64(m.DBSize-m.DBSizeInUse) / float64(m.DBSize)
+}
+
+// Alarm is a raised etcd alarm, e.g. NOSPACE
+type Alarm struct {
+	MemberID uint64
+	Alarm    string
+}
+
+// Members lists the members of the cluster with their health and database
+// size. Members whose endpoint does not answer are returned unhealthy.
+func Members(client ssh.Executor) ([]Member, error) {
+	output, err := client.ExecuteCommand(etcdctl + " --endpoints=https://127.0.0.1:2379 member list -w json")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list etcd members: %v\nOutput: %s", err, output)
+	}
+	members, err := parseMembers([]byte(output))
+	if err != nil {
+		return nil, err
+	}
+	endpoints := make([]string, len(members))
+	for i, m := range members {
+		endpoints[i] = m.Endpoint
+	}
+	flag := " --endpoints=" + strings.Join(endpoints, ",")
+
+	// Both commands exit non-zero when an endpoint is down but still print
+	// the state of every endpoint
+	output, _ = client.ExecuteCommand(etcdctl + flag + " endpoint health -w json")
+	health, err := parseHealth([]byte(output))
+	if err != nil {
+		return nil, err
+	}
+	output, _ = client.ExecuteCommand(etcdctl + flag + " endpoint status -w json")
+	status, err := parseStatus([]byte(output))
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range members {
+		m := &members[i]
+		if h, ok := health[m.Endpoint]; ok {
+			m.Healthy, m.Error = h.Health, h.Error
+		} else {
+			m.Error = "no health reported"
+		}
+		if s, ok := status[m.Endpoint]; ok {
+			m.Leader = s.Status.Leader == m.ID
+			m.Version = s.Status.Version
+			m.DBSize = s.Status.DBSize
+			m.DBSizeInUse = s.Status.DBSizeInUse
+		}
+	}
+	return members, nil
+}
+
+// Alarms lists the raised alarms
+func Alarms(client ssh.Executor) ([]Alarm, error) {
+	output, err := client.ExecuteCommand(etcdctl + " --endpoints=https://127.0.0.1:2379 alarm list")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list etcd alarms: %v\nOutput: %s", err, output)
+	}
+	return parseAlarms(output)
+}
+
+// Defragment defragments the member at endpoint. The member blocks reads and
+// writes while it runs, so members are defragmented one at a time.
+func Defragment(client ssh.Executor, endpoint string) error {
+	command := fmt.Sprintf("%s --endpoints=%s --command-timeout=120s defrag", etcdctl, endpoint)
+	if output, err := client.ExecuteCommand(command); err != nil {
+		return fmt.Errorf("defragmentation of %s failed: %v\nOutput: %s", endpoint, err, output)
+	}
+	return nil
+}
+
+// DisarmAlarms clears a NOSPACE alarm once the database of every member is
+// back under the quota. Any other alarm, e.g. CORRUPT, needs a closer look
+// and is refused, as etcd would accept writes again after the disarm.
+func DisarmAlarms(client ssh.Executor) error {
+	alarms, err := Alarms(client)
+	if err != nil {
+		return err
+	}
+	if len(alarms) == 0 {
+		return nil
+	}
+	for _, a := range alarms {
+		if a.Alarm != "NOSPACE" {
+			return fmt.Errorf("not disarming etcd alarm %s of member %x, only NOSPACE alarms are disarmed", a.Alarm, a.MemberID)
+		}
+	}
+
+	quota, err := Quota(client)
+	if err != nil {
+		return err
+	}
+	members, err := Members(client)
+	if err != nil {
+		return err
+	}
+	for _, m := range members {
+		if m.DBSize == 0 {
+			return fmt.Errorf("not disarming the NOSPACE alarm, %s reported no database size", m.Name)
+		}
+		if m.DBSize >= quota {
+			return fmt.Errorf("not disarming the NOSPACE alarm, the database of %s (%d bytes) is not under its quota of %d bytes", m.Name, m.DBSize, quota)
+		}
+	}
+
+	if output, err := client.ExecuteCommand(etcdctl + " --endpoints=https://127.0.0.1:2379 alarm disarm"); err != nil {
+		return fmt.Errorf("failed to disarm etcd alarms: %v\nOutput: %s", err, output)
+	}
+	return nil
+}
+
+// Quota returns the backend quota the etcd static pod runs with
+func Quota(client ssh.Executor) (int64, error) {
+	output, err := client.ExecuteCommand("kubectl -n kube-system get pods -l component=etcd -o jsonpath='{.items[0].spec.containers[0].command}'")
+	if err != nil {
+		return 0, fmt.Errorf("failed to read the etcd command line: %v\nOutput: %s", err, output)
+	}
+	return parseQuota(output), nil
+}
+
+var quotaFlag = regexp.MustCompile(`--quota-backend-bytes=(\d+)`)
+
+// parseQuota returns the quota set on an etcd command line
+func parseQuota(command string) int64 {
+	if m := quotaFlag.FindStringSubmatch(command); m != nil {
+		if quota, err := strconv.ParseInt(m[1], 10, 64); err == nil && quota > 0 {
+			return quota
+		}
+	}
+	return DefaultQuota
+}
+
+// parseMembers parses the output of etcdctl member list -w json
+func parseMembers(data []byte) ([]Member, error) {
+	var list struct {
+		Members []struct {
+			ID         uint64   `json:"ID"`
+			Name       string   `json:"name"`
+			ClientURLs []string `json:"clientURLs"`
+		} `json:"members"`
+	}
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("failed to parse etcd members: %v", err)
+	}
+	var members []Member
+	for _, m := range list.Members {
+		if len(m.ClientURLs) == 0 {
+			// Learners that have not started yet have no client URL
+			continue
+		}
+		members = append(members, Member{ID: m.ID, Name: m.Name, Endpoint: m.ClientURLs[0]})
+	}
+	if len(members) == 0 {
+		return nil, fmt.Errorf("etcd reported no members")
+	}
+	return members, nil
+}
+
+type endpointHealth struct {
+	Endpoint string `json:"endpoint"`
+	Health   bool   `json:"health"`
+	Error    string `json:"error"`
+}
+
+// parseHealth parses the output of etcdctl endpoint health -w json by
+// endpoint
+func parseHealth(data []byte) (map[string]endpointHealth, error) {
+	var list []endpointHealth
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("failed to parse etcd endpoint health: %v", err)
+	}
+	health := map[string]endpointHealth{}
+	for _, h := range list {
+		health[h.Endpoint] = h
+	}
+	return health, nil
+}
+
+type endpointStatus struct {
+	Endpoint string `json:"Endpoint"`
+	Status   struct {
+		Version     string `json:"version"`
+		DBSize      int64  `json:"dbSize"`
+		DBSizeInUse int64  `json:"dbSizeInUse"`
+		Leader      uint64 `json:"leader"`
+	} `json:"Status"`
+}
+
+// parseStatus parses the output of etcdctl endpoint status -w json by
+// endpoint
+func parseStatus(data []byte) (map[string]endpointStatus, error) {
+	var list []endpointStatus
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("failed to parse etcd endpoint status: %v", err)
+	}
+	status := map[string]endpointStatus{}
+	for _, s := range list {
+		status[s.Endpoint] = s
+	}
+	return status, nil
+}
+
+// parseAlarms parses the "memberID:<id> alarm:<name>" lines of etcdctl
+// alarm list
+func parseAlarms(output string) ([]Alarm, error) {
+	var alarms []Alarm
+	for _, line := range strings.Split(strings.TrimSpace(output), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		var id, name string
+		for _, field := range strings.Fields(line) {
+			switch {
+			case strings.HasPrefix(field, "memberID:"):
+				id = strings.TrimPrefix(field, "memberID:")
+			case strings.HasPrefix(field, "alarm:"):
+				name = strings.TrimPrefix(field, "alarm:")
+			}
+		}
+		memberID, err := strconv.ParseUint(id, 10, 64)
+		if err != nil || name == "" {
+			return nil, fmt.Errorf("unexpected etcd alarm %q", line)
+		}
+		alarms = append(alarms, Alarm{MemberID: memberID, Alarm: name})
+	}
+	return alarms, nil
+}
@@ -0,0 +1,126 @@
+package etcd
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/maarulav/k8s-setup/internal/testutil"
+)
+
+func TestMembers(t *testing.T) {
+	client := testutil.NewExecutor("10.0.0.1").
+		Respond("member list", `{"header":{"cluster_id":1},"members":[
+			{"ID":12345678901234567890,"name":"cp-1","clientURLs":["https://10.0.0.1:2379"]},
+			{"ID":2,"name":"cp-2","clientURLs":["https://10.0.0.2:2379"]},
+			{"ID":3,"name":"cp-3","peerURLs":["https://10.0.0.3:2380"],"isLearner":true}
+		]}`).
+		Respond("endpoint health", `[
+			{"endpoint":"https://10.0.0.1:2379","health":true,"took":"9ms"},
+			{"endpoint":"https://10.0.0.2:2379","health":false,"error":"context deadline exceeded"}
+		]`).
+		Respond("endpoint status", `[
+			{"Endpoint":"https://10.0.0.1:2379","Status":{"version":"3.5.9","dbSize":100,"dbSizeInUse":60,"leader":12345678901234567890}}
+		]`)
+
+	members, err := Members(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(members) != 2 {
+		t.Fatalf("got %d members, want the two with client URLs", len(members))
+	}
+	cp1, cp2 := members[0], members[1]
+	if !cp1.Healthy || !cp1.Leader || cp1.Version != "3.5.9" || cp1.Fragmentation() != 0.4 {
+		t.Errorf("unexpected cp-1 %+v", cp1)
+	}
+	if cp2.Healthy || cp2.Leader || cp2.Error != "context deadline exceeded" || cp2.Fragmentation() != 0 {
+		t.Errorf("unexpected cp-2 %+v", cp2)
+	}
+	if want := "--endpoints=https://10.0.0.1:2379,https://10.0.0.2:2379 endpoint health"; !strings.Contains(strings.Join(client.Commands(), "\n"), want) {
+		t.Errorf("health was not checked on every member: %v", client.Commands())
+	}
+}
+
+func TestParseAlarms(t *testing.T) {
+	alarms, err := parseAlarms("memberID:2 alarm:NOSPACE\nmemberID:3 alarm:CORRUPT\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(alarms) != 2 || alarms[0] != (Alarm{MemberID: 2, Alarm: "NOSPACE"}) || alarms[1].Alarm != "CORRUPT" {
+		t.Errorf("unexpected alarms %v", alarms)
+	}
+	if alarms, err := parseAlarms(""); err != nil || len(alarms) != 0 {
+		t.Errorf("no alarms: got %v, %v", alarms, err)
+	}
+	if _, err := parseAlarms("alarm:NOSPACE"); err == nil {
+		t.Error("expected an error for an alarm without member")
+	}
+}
+
+func TestParseQuota(t *testing.T) {
+	if quota := parseQuota(`["etcd","--data-dir=/var/lib/etcd","--quota-backend-bytes=8589934592"]`); quota != 8<<30 {
+		t.Errorf("quota = %d, want 8Gi", quota)
+	}
+	if quota := parseQuota(`["etcd","--data-dir=/var/lib/etcd"]`); quota != DefaultQuota {
+		t.Errorf("quota = %d, want the default", quota)
+	}
+}
+
+// alarmed returns an executor of a single member cluster with alarms raised,
+// whose database has dbSize bytes under a quota of 1000
+func alarmed(alarms string, dbSize int) *testutil.Executor {
+	return testutil.NewExecutor("10.0.0.1").
+		Respond("alarm list", alarms).
+		Respond("member list", `{"members":[{"ID":2,"name":"cp-1","clientURLs":["https://10.0.0.1:2379"]}]}`).
+		Respond("endpoint health", `[{"endpoint":"https://10.0.0.1:2379","health":true}]`).
+		Respond("endpoint status", fmt.Sprintf(`[{"Endpoint":"https://10.0.0.1:2379","Status":{"dbSize":%d,"dbSizeInUse":%d,"leader":2}}]`, dbSize, dbSize)).
+		Respond("spec.containers[0].command", `["etcd","--quota-backend-bytes=1000"]`)
+}
+
+// disarmed reports whether client disarmed the alarms
+func disarmed(client *testutil.Executor) bool {
+	for _, command := range client.Commands() {
+		if strings.Contains(command, "alarm disarm") {
+			return true
+		}
+	}
+	return false
+}
+
+func TestDisarmAlarms(t *testing.T) {
+	client := alarmed("memberID:2 alarm:NOSPACE\n", 600)
+	if err := DisarmAlarms(client); err != nil {
+		t.Fatal(err)
+	}
+	if !disarmed(client) {
+		t.Error("NOSPACE alarm under the quota was not disarmed")
+	}
+
+	client = alarmed("", 600)
+	if err := DisarmAlarms(client); err != nil || disarmed(client) {
+		t.Errorf("no alarms: got %v, disarmed %t", err, disarmed(client))
+	}
+}
+
+func TestDisarmAlarmsRefused(t *testing.T) {
+	for name, tc := range map[string]struct {
+		alarms string
+		dbSize int
+		want   string
+	}{
+		"corrupt":      {"memberID:2 alarm:CORRUPT\n", 600, "alarm CORRUPT"},
+		"corrupt too":  {"memberID:2 alarm:NOSPACE\nmemberID:3 alarm:CORRUPT\n", 600, "alarm CORRUPT"},
+		"over quota":   {"memberID:2 alarm:NOSPACE\n", 1000, "not under its quota"},
+		"size unknown": {"memberID:2 alarm:NOSPACE\n", 0, "no database size"},
+	} {
+		client := alarmed(tc.alarms, tc.dbSize)
+		err := DisarmAlarms(client)
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: error %v, want %q", name, err, tc.want)
+		}
+		if disarmed(client) {
+			t.Errorf("%s: alarms were disarmed", name)
+		}
+	}
+}
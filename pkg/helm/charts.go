@@ -0,0 +1,85 @@
+package helm
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/maarulav/k8s-setup/pkg/config"
+	"github.com/maarulav/k8s-setup/pkg/ssh"
+	"gopkg.in/yaml.v3"
+)
+
+// InstallCharts installs or upgrades the user charts in configuration order.
+// vars are the template variables of the host, used by templated values
+// files.
+func InstallCharts(client ssh.Executor, charts []config.Chart, vars map[string]string) error {
+	for _, c := range charts {
+		if c.Name == "" || c.Repo == "" || c.Chart == "" {
+			return fmt.Errorf("chart entries need a name, repo and chart")
+		}
+
+		namespace := c.Namespace
+		if namespace == "" {
+			namespace = "default"
+		}
+
+		values, err := chartValues(c, vars)
+		if err != nil {
+			return fmt.Errorf("chart %s: %v", c.Name, err)
+		}
+
+		err = Install(client, Chart{
+			Release:   c.Name,
+			RepoURL:   c.Repo,
+			Chart:     c.Chart,
+			Version:   c.Version,
+			Namespace: namespace,
+			Values:    values,
+		})
+		if err != nil {
+			return fmt.Errorf("chart %s: %v", c.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// chartValues merges the values files of c in order, then its inline values
+func chartValues(c config.Chart, vars map[string]string) (map[string]interface{}, error) {
+	values := map[string]interface{}{}
+	for _, file := range c.ValuesFiles {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read values file: %v", err)
+		}
+		if strings.HasSuffix(file, ".tmpl") {
+			text, err := config.Render(file, string(data), vars)
+			if err != nil {
+				return nil, err
+			}
+			data = []byte(text)
+		}
+		var fileValues map[string]interface{}
+		if err := yaml.Unmarshal(data, &fileValues); err != nil {
+			return nil, fmt.Errorf("failed to parse values file %s: %v", file, err)
+		}
+		mergeValues(values, fileValues)
+	}
+	mergeValues(values, c.Values)
+	return values, nil
+}
+
+// mergeValues merges src into dst, descending into maps present in both
+// like helm does with several values files
+func mergeValues(dst, src map[string]interface{}) {
+	for k, v := range src {
+		if srcMap, ok := v.(map[string]interface{}); ok {
+			if dstMap, ok := dst[k].(map[string]interface{}); ok {
+				mergeValues(dstMap, srcMap)
+				continue
+			}
+		}
+		dst[k] = v
+	}
+}
@@ -0,0 +1,84 @@
+package helm
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/maarulav/k8s-setup/pkg/config"
+)
+
+func TestMergeValues(t *testing.T) {
+	dst := map[string]interface{}{
+		"replicas": 1,
+		"image":    map[string]interface{}{"repository": "nginx", "tag": "1.25"},
+		"ports":    []interface{}{80},
+		"service":  "ClusterIP",
+	}
+	mergeValues(dst, map[string]interface{}{
+		"image":   map[string]interface{}{"tag": "1.27"},
+		"ports":   []interface{}{8080},
+		"service": map[string]interface{}{"type": "NodePort"},
+		"extra":   true,
+	})
+
+	want := map[string]interface{}{
+		"replicas": 1,
+		// Maps present in both are merged key by key
+		"image": map[string]interface{}{"repository": "nginx", "tag": "1.27"},
+		// Lists and other values are replaced, as by helm
+		"ports":   []interface{}{8080},
+		"service": map[string]interface{}{"type": "NodePort"},
+		"extra":   true,
+	}
+	if !reflect.DeepEqual(dst, want) {
+		t.Errorf("merged values %v, want %v", dst, want)
+	}
+}
+
+func TestChartValues(t *testing.T) {
+	dir := t.TempDir()
+	base := filepath.Join(dir, "base.yaml")
+	zone := filepath.Join(dir, "zone.yaml.tmpl")
+	if err := ioutil.WriteFile(base, []byte("replicas: 2\nnodeSelector:\n  zone: '{{ .zone }}'\n  disk: ssd\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(zone, []byte("nodeSelector:\n  zone: {{ .zone }}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	c := config.Chart{
+		Name:        "app",
+		ValuesFiles: []string{base, zone},
+		Values:      map[string]interface{}{"replicas": 3},
+	}
+	values, err := chartValues(c, map[string]string{"zone": "eu-1a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		// Inline values go last
+		"replicas": 3,
+		// Only the .tmpl file is rendered, the later file wins
+		"nodeSelector": map[string]interface{}{"zone": "eu-1a", "disk": "ssd"},
+	}
+	if !reflect.DeepEqual(values, want) {
+		t.Errorf("values %v, want %v", values, want)
+	}
+
+	c.ValuesFiles = []string{base}
+	if values, err := chartValues(c, nil); err != nil || values["nodeSelector"].(map[string]interface{})["zone"] != "{{ .zone }}" {
+		t.Errorf("plain values file rendered: %v, %v", values, err)
+	}
+
+	c.ValuesFiles = []string{zone}
+	if _, err := chartValues(c, map[string]string{}); err == nil || !strings.Contains(err.Error(), "zone") {
+		t.Errorf("error %v, want the undefined variable", err)
+	}
+	c.ValuesFiles = []string{filepath.Join(dir, "missing.yaml")}
+	if _, err := chartValues(c, nil); err == nil {
+		t.Error("expected an error for a missing values file")
+	}
+}
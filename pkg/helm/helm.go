@@ -0,0 +1,208 @@
+package helm
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/maarulav/k8s-setup/pkg/kube"
+	"github.com/maarulav/k8s-setup/pkg/ssh"
+	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/cli"
+	"helm.sh/helm/v3/pkg/registry"
+	"helm.sh/helm/v3/pkg/storage/driver"
+)
+
+// installTimeout bounds how long an install or upgrade waits for its resources
+const installTimeout = 10 * time.Minute
+
+// Chart describes a Helm release to install on the cluster
+type Chart struct {
+	Release   string
+	RepoURL   string
+	Chart     string
+	Version   string
+	Namespace string
+	Values    map[string]interface{}
+
+	// Namespaced installs without cluster-scoped changes: the namespace has
+	// to exist and the CRDs of the chart are skipped
+	Namespaced bool
+}
+
+// Error is returned when a Helm operation on a release fails
+type Error struct {
+	Op      string
+	Release string
+	Err     error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("failed to %s %s: %v", e.Op, e.Release, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// actionConfig returns the Helm SDK configuration for namespace on the
+// cluster behind client. Release state is kept in Secrets, like the CLI does.
+func actionConfig(client ssh.Executor, namespace string) (*action.Configuration, error) {
+	getter, err := kube.NewRESTClientGetter(client, namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := new(action.Configuration)
+	if err := cfg.Init(getter, namespace, "secret", func(string, ...interface{}) {}); err != nil {
+		return nil, fmt.Errorf("failed to initialise Helm: %v", err)
+	}
+
+	registryClient, err := registry.NewClient()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OCI registry client: %v", err)
+	}
+	cfg.RegistryClient = registryClient
+
+	return cfg, nil
+}
+
+// Install installs or upgrades the chart. Both are atomic: a failed release is
+// rolled back, or uninstalled when it was a first install.
+func Install(client ssh.Executor, chart Chart) error {
+	cfg, err := actionConfig(client, chart.Namespace)
+	if err != nil {
+		return &Error{Op: "install", Release: chart.Release, Err: err}
+	}
+
+	install := action.NewInstall(cfg)
+	install.ReleaseName = chart.Release
+	install.Namespace = chart.Namespace
+	install.CreateNamespace = !chart.Namespaced
+	install.SkipCRDs = chart.Namespaced
+	install.Atomic = true
+	install.Timeout = installTimeout
+	install.Version = chart.Version
+
+	// OCI registries are referenced directly, other charts through their repo URL
+	ref := chart.Chart
+	if registry.IsOCI(chart.RepoURL) {
+		ref = strings.TrimSuffix(chart.RepoURL, "/") + "/" + chart.Chart
+	} else {
+		install.RepoURL = chart.RepoURL
+	}
+
+	path, err := install.LocateChart(ref, cli.New())
+	if err != nil {
+		return &Error{Op: "download", Release: chart.Release, Err: err}
+	}
+	loaded, err := loader.Load(path)
+	if err != nil {
+		return &Error{Op: "load chart for", Release: chart.Release, Err: err}
+	}
+
+	history := action.NewHistory(cfg)
+	history.Max = 1
+	if _, err := history.Run(chart.Release); errors.Is(err, driver.ErrReleaseNotFound) {
+		if _, err := install.Run(loaded, chart.Values); err != nil {
+			return &Error{Op: "install", Release: chart.Release, Err: err}
+		}
+		return nil
+	} else if err != nil {
+		return &Error{Op: "read history of", Release: chart.Release, Err: err}
+	}
+
+	upgrade := action.NewUpgrade(cfg)
+	upgrade.Namespace = chart.Namespace
+	upgrade.Atomic = true
+	upgrade.Timeout = installTimeout
+	upgrade.Version = chart.Version
+	upgrade.MaxHistory = 10
+	if _, err := upgrade.Run(chart.Release, loaded, chart.Values); err != nil {
+		return &Error{Op: "upgrade", Release: chart.Release, Err: err}
+	}
+
+	return nil
+}
+
+// Values returns the user supplied values of an installed release
+func Values(client ssh.Executor, release, namespace string) (map[string]interface{}, error) {
+	cfg, err := actionConfig(client, namespace)
+	if err != nil {
+		return nil, &Error{Op: "read values of", Release: release, Err: err}
+	}
+
+	values, err := action.NewGetValues(cfg).Run(release)
+	if err != nil {
+		return nil, &Error{Op: "read values of", Release: release, Err: err}
+	}
+	return values, nil
+}
+
+// Release is an installed Helm release as reported by helm list
+type Release struct {
+	Name       string `json:"name"`
+	Namespace  string `json:"namespace"`
+	Chart      string `json:"chart"`
+	AppVersion string `json:"app_version"`
+	Status     string `json:"status"`
+}
+
+// ChartName returns the chart name without its version suffix
+func (r Release) ChartName() string {
+	name, _ := splitChart(r.Chart)
+	return name
+}
+
+// ChartVersion returns the version of the installed chart
+func (r Release) ChartVersion() string {
+	_, version := splitChart(r.Chart)
+	return version
+}
+
+// splitChart splits "kube-prometheus-stack-45.7.1" into name and version
+func splitChart(chart string) (string, string) {
+	for i := len(chart) - 1; i > 0; i-- {
+		if chart[i-1] != '-' {
+			continue
+		}
+		c := chart[i]
+		if c >= '0' && c <= '9' || c == 'v' && i+1 < len(chart) && chart[i+1] >= '0' && chart[i+1] <= '9' {
+			return chart[:i-1], chart[i:]
+		}
+	}
+	return chart, ""
+}
+
+// List returns every release installed on the cluster
+func List(client ssh.Executor) ([]Release, error) {
+	cfg, err := actionConfig(client, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list Helm releases: %v", err)
+	}
+
+	list := action.NewList(cfg)
+	list.AllNamespaces = true
+	list.SetStateMask()
+	found, err := list.Run()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list Helm releases: %v", err)
+	}
+
+	releases := make([]Release, 0, len(found))
+	for _, r := range found {
+		release := Release{Name: r.Name, Namespace: r.Namespace}
+		if r.Chart != nil && r.Chart.Metadata != nil {
+			release.Chart = r.Chart.Metadata.Name + "-" + r.Chart.Metadata.Version
+			release.AppVersion = r.Chart.Metadata.AppVersion
+		}
+		if r.Info != nil {
+			release.Status = r.Info.Status.String()
+		}
+		releases = append(releases, release)
+	}
+
+	return releases, nil
+}
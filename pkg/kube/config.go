@@ -0,0 +1,115 @@
+package kube
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/maarulav/k8s-setup/pkg/ssh"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/discovery/cached/memory"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/restmapper"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// RESTClientGetter gives client-go and the Helm SDK access to the cluster
+// behind an executor. API connections are dialled through the executor, so
+// over SSH they are tunnelled to the host and the API server does not have to
+// be reachable from this machine.
+type RESTClientGetter struct {
+	client       ssh.Executor
+	clientConfig clientcmd.ClientConfig
+}
+
+// NewRESTClientGetter reads the executor's kubeconfig, defaulting to namespace
+func NewRESTClientGetter(client ssh.Executor, namespace string) (*RESTClientGetter, error) {
+	data, err := client.Kubeconfig()
+	if err != nil {
+		return nil, err
+	}
+
+	kubeconfig, err := clientcmd.Load(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse kubeconfig: %v", err)
+	}
+
+	overrides := &clientcmd.ConfigOverrides{}
+	overrides.Context.Namespace = namespace
+
+	return &RESTClientGetter{
+		client:       client,
+		clientConfig: clientcmd.NewDefaultClientConfig(*kubeconfig, overrides),
+	}, nil
+}
+
+func (g *RESTClientGetter) ToRESTConfig() (*rest.Config, error) {
+	config, err := g.clientConfig.ClientConfig()
+	if err != nil {
+		return nil, err
+	}
+	config.Dial = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return g.client.Dial(network, addr)
+	}
+	return config, nil
+}
+
+func (g *RESTClientGetter) ToDiscoveryClient() (discovery.CachedDiscoveryInterface, error) {
+	config, err := g.ToRESTConfig()
+	if err != nil {
+		return nil, err
+	}
+	client, err := discovery.NewDiscoveryClientForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	return memory.NewMemCacheClient(client), nil
+}
+
+func (g *RESTClientGetter) ToRESTMapper() (meta.RESTMapper, error) {
+	client, err := g.ToDiscoveryClient()
+	if err != nil {
+		return nil, err
+	}
+	mapper := restmapper.NewDeferredDiscoveryRESTMapper(client)
+	return restmapper.NewShortcutExpander(mapper, client, nil), nil
+}
+
+func (g *RESTClientGetter) ToRawKubeConfigLoader() clientcmd.ClientConfig {
+	return g.clientConfig
+}
+
+// NewClientForKubeconfig returns a clientset for the cluster of a kubeconfig
+// file, reached directly from this machine, and its REST configuration
+func NewClientForKubeconfig(path string, timeout time.Duration) (kubernetes.Interface, *rest.Config, error) {
+	config, err := clientcmd.BuildConfigFromFlags("", path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load kubeconfig %s: %v", path, err)
+	}
+	config.Timeout = timeout
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create Kubernetes client: %v", err)
+	}
+	return clientset, config, nil
+}
+
+// NewClient returns a clientset for the cluster behind an executor
+func NewClient(client ssh.Executor) (kubernetes.Interface, error) {
+	getter, err := NewRESTClientGetter(client, "")
+	if err != nil {
+		return nil, err
+	}
+	config, err := getter.ToRESTConfig()
+	if err != nil {
+		return nil, err
+	}
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Kubernetes client: %v", err)
+	}
+	return clientset, nil
+}
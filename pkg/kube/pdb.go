@@ -0,0 +1,50 @@
+package kube
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+)
+
+// BlockingPDBs returns the pod disruption budgets, as namespace/name, that
+// currently allow no disruption of a pod running on node. Draining the node
+// waits for them until it times out.
+func BlockingPDBs(ctx context.Context, client kubernetes.Interface, node string) ([]string, error) {
+	pods, err := client.CoreV1().Pods("").List(ctx, metav1.ListOptions{FieldSelector: "spec.nodeName=" + node})
+	if err != nil {
+		return nil, err
+	}
+	pdbs, err := client.PolicyV1().PodDisruptionBudgets("").List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	var blocking []string
+	for _, pdb := range pdbs.Items {
+		if pdb.Status.DisruptionsAllowed > 0 {
+			continue
+		}
+		selector, err := metav1.LabelSelectorAsSelector(pdb.Spec.Selector)
+		if err != nil {
+			return nil, fmt.Errorf("invalid selector of pod disruption budget %s/%s: %v", pdb.Namespace, pdb.Name, err)
+		}
+		if matchesPod(pods.Items, pdb.Namespace, selector) {
+			blocking = append(blocking, pdb.Namespace+"/"+pdb.Name)
+		}
+	}
+	return blocking, nil
+}
+
+// matchesPod reports whether selector selects a running pod of namespace
+func matchesPod(pods []corev1.Pod, namespace string, selector labels.Selector) bool {
+	for _, pod := range pods {
+		if pod.Namespace == namespace && pod.Status.Phase == corev1.PodRunning && selector.Matches(labels.Set(pod.Labels)) {
+			return true
+		}
+	}
+	return false
+}
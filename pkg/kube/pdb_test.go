@@ -0,0 +1,107 @@
+package kube
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// appPod returns a pod of namespace on node with the label app
+func appPod(namespace, name, node, app string, phase corev1.PodPhase) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name, Labels: map[string]string{"app": app}},
+		Spec:       corev1.PodSpec{NodeName: node},
+		Status:     corev1.PodStatus{Phase: phase},
+	}
+}
+
+// pdb returns a budget of namespace selecting the pods labelled app, which
+// allows allowed disruptions
+func pdb(namespace, name, app string, allowed int32) *policyv1.PodDisruptionBudget {
+	return &policyv1.PodDisruptionBudget{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name},
+		Spec:       policyv1.PodDisruptionBudgetSpec{Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": app}}},
+		Status:     policyv1.PodDisruptionBudgetStatus{DisruptionsAllowed: allowed},
+	}
+}
+
+// nodeClientset returns a clientset of objects whose pod lists honour the
+// spec.nodeName field selector, which the fake tracker ignores
+func nodeClientset(objects ...runtime.Object) *fake.Clientset {
+	client := fake.NewClientset(objects...)
+	client.PrependReactor("list", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		selector := action.(k8stesting.ListAction).GetListRestrictions().Fields
+		list := &corev1.PodList{}
+		for _, object := range objects {
+			if p, ok := object.(*corev1.Pod); ok && selector.Matches(fields.Set{"spec.nodeName": p.Spec.NodeName}) {
+				list.Items = append(list.Items, *p)
+			}
+		}
+		return true, list, nil
+	})
+	return client
+}
+
+func TestBlockingPDBs(t *testing.T) {
+	client := nodeClientset(
+		appPod("shop", "db-0", "worker-1", "db", corev1.PodRunning),
+		appPod("shop", "web-1", "worker-1", "web", corev1.PodRunning),
+		appPod("shop", "queue-0", "worker-2", "queue", corev1.PodRunning),
+		appPod("shop", "batch-1", "worker-1", "batch", corev1.PodSucceeded),
+		appPod("other", "db-0", "worker-2", "db", corev1.PodRunning),
+		// A single replica with minAvailable 1 is exhausted
+		pdb("shop", "db", "db", 0),
+		pdb("shop", "web", "web", 1),
+		// Exhausted, but its pods run elsewhere or have finished
+		pdb("shop", "queue", "queue", 0),
+		pdb("shop", "batch", "batch", 0),
+		// Selects pods labelled db, but only of its own namespace
+		pdb("other", "db", "db", 0),
+	)
+
+	blocking, err := BlockingPDBs(context.Background(), client, "worker-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"shop/db"}; !reflect.DeepEqual(blocking, want) {
+		t.Errorf("BlockingPDBs = %v, want %v", blocking, want)
+	}
+
+	blocking, err = BlockingPDBs(context.Background(), client, "worker-2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"other/db", "shop/queue"}; !reflect.DeepEqual(blocking, want) {
+		t.Errorf("BlockingPDBs = %v, want %v", blocking, want)
+	}
+
+	if blocking, err := BlockingPDBs(context.Background(), client, "worker-3"); err != nil || len(blocking) != 0 {
+		t.Errorf("BlockingPDBs of an empty node = %v, %v, want none", blocking, err)
+	}
+}
+
+func TestBlockingPDBsErrors(t *testing.T) {
+	invalid := pdb("shop", "db", "db", 0)
+	invalid.Spec.Selector.MatchExpressions = []metav1.LabelSelectorRequirement{{Key: "tier", Operator: "Near"}}
+	client := nodeClientset(appPod("shop", "db-0", "worker-1", "db", corev1.PodRunning), invalid)
+	if _, err := BlockingPDBs(context.Background(), client, "worker-1"); err == nil {
+		t.Error("expected an error for an invalid selector")
+	}
+
+	failing := fake.NewClientset()
+	failing.PrependReactor("list", "poddisruptionbudgets", func(k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.New("forbidden")
+	})
+	if _, err := BlockingPDBs(context.Background(), failing, "worker-1"); err == nil {
+		t.Error("expected the API error to be returned")
+	}
+}
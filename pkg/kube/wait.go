@@ -0,0 +1,225 @@
+package kube
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+)
+
+// DefaultTimeout is how long the setup steps wait for the cluster
+const DefaultTimeout = 5 * time.Minute
+
+// pollInterval is how often the wait functions check the cluster, tests
+// shorten it
+var pollInterval = 2 * time.Second
+
+// Progress receives a short description of what a wait is still blocked on.
+// It is only called when the description changes.
+type Progress func(message string)
+
+// Print is a Progress that writes every update to stdout
+func Print(message string) {
+	fmt.Printf("Waiting: %s\n", message)
+}
+
+// report returns a deduplicating wrapper around progress, which may be nil
+func report(progress Progress) Progress {
+	var last string
+	return func(message string) {
+		if progress != nil && message != last {
+			last = message
+			progress(message)
+		}
+	}
+}
+
+// poll runs condition until it reports done, fails, or ctx ends. A transient
+// API error is reported as progress and retried.
+func poll(ctx context.Context, progress Progress, condition func(ctx context.Context) (bool, string, error)) error {
+	progress = report(progress)
+	var pending string
+	err := wait.PollUntilContextCancel(ctx, pollInterval, true, func(ctx context.Context) (bool, error) {
+		done, message, err := condition(ctx)
+		if err != nil {
+			pending = err.Error()
+			progress(pending)
+			return false, nil
+		}
+		if !done {
+			pending = message
+			progress(message)
+		}
+		return done, nil
+	})
+	if err != nil && pending != "" {
+		return fmt.Errorf("%v: %s", err, pending)
+	}
+	return err
+}
+
+// WaitForNodeReady waits until the named node is Ready, or every node when
+// name is empty
+func WaitForNodeReady(ctx context.Context, client kubernetes.Interface, name string, progress Progress) error {
+	return poll(ctx, progress, func(ctx context.Context) (bool, string, error) {
+		var nodes []corev1.Node
+		if name != "" {
+			node, err := client.CoreV1().Nodes().Get(ctx, name, metav1.GetOptions{})
+			if err != nil {
+				return false, "", err
+			}
+			nodes = append(nodes, *node)
+		} else {
+			list, err := client.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+			if err != nil {
+				return false, "", err
+			}
+			nodes = list.Items
+		}
+
+		if len(nodes) == 0 {
+			return false, "no nodes registered yet", nil
+		}
+		for _, node := range nodes {
+			if !nodeReady(node) {
+				return false, fmt.Sprintf("node %s is not Ready", node.Name), nil
+			}
+		}
+		return true, "", nil
+	})
+}
+
+// WaitForDeploymentAvailable waits until the deployment has rolled out its
+// current revision and all of its replicas are available
+func WaitForDeploymentAvailable(ctx context.Context, client kubernetes.Interface, namespace, name string, progress Progress) error {
+	return poll(ctx, progress, func(ctx context.Context) (bool, string, error) {
+		deployment, err := client.AppsV1().Deployments(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return false, "", err
+		}
+		if done, message := deploymentAvailable(deployment); !done {
+			return false, fmt.Sprintf("deployment %s/%s: %s", namespace, name, message), nil
+		}
+		return true, "", nil
+	})
+}
+
+// WaitForPodsBySelector waits until at least one pod matches the label
+// selector and every matching pod is Ready
+func WaitForPodsBySelector(ctx context.Context, client kubernetes.Interface, namespace, selector string, progress Progress) error {
+	return poll(ctx, progress, func(ctx context.Context) (bool, string, error) {
+		pods, err := client.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{LabelSelector: selector})
+		if err != nil {
+			return false, "", err
+		}
+		if len(pods.Items) == 0 {
+			return false, fmt.Sprintf("no pods match %s in %s", selector, namespace), nil
+		}
+
+		ready := 0
+		for _, pod := range pods.Items {
+			if podReady(pod) {
+				ready++
+			}
+		}
+		if ready < len(pods.Items) {
+			return false, fmt.Sprintf("%d/%d pods matching %s in %s are Ready", ready, len(pods.Items), selector, namespace), nil
+		}
+		return true, "", nil
+	})
+}
+
+// WaitForNodePods waits until every pod scheduled on the node is Ready
+func WaitForNodePods(ctx context.Context, client kubernetes.Interface, node string, progress Progress) error {
+	return poll(ctx, progress, func(ctx context.Context) (bool, string, error) {
+		pods, err := client.CoreV1().Pods("").List(ctx, metav1.ListOptions{FieldSelector: "spec.nodeName=" + node})
+		if err != nil {
+			return false, "", err
+		}
+		for _, pod := range pods.Items {
+			if !podReady(pod) {
+				return false, fmt.Sprintf("pod %s/%s on node %s is not Ready", pod.Namespace, pod.Name, node), nil
+			}
+		}
+		return true, "", nil
+	})
+}
+
+// NotReadyNodes returns the names of the nodes that are not Ready
+func NotReadyNodes(ctx context.Context, client kubernetes.Interface) ([]string, error) {
+	nodes, err := client.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, node := range nodes.Items {
+		if !nodeReady(node) {
+			names = append(names, node.Name)
+		}
+	}
+	return names, nil
+}
+
+// NodeByAddress returns the name of the node with the given internal or
+// external IP
+func NodeByAddress(ctx context.Context, client kubernetes.Interface, ip string) (string, error) {
+	nodes, err := client.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return "", err
+	}
+	for _, node := range nodes.Items {
+		for _, addr := range node.Status.Addresses {
+			if addr.Address == ip {
+				return node.Name, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("no node with address %s", ip)
+}
+
+func nodeReady(node corev1.Node) bool {
+	for _, c := range node.Status.Conditions {
+		if c.Type == corev1.NodeReady {
+			return c.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+func podReady(pod corev1.Pod) bool {
+	if pod.Status.Phase == corev1.PodSucceeded {
+		return true
+	}
+	for _, c := range pod.Status.Conditions {
+		if c.Type == corev1.PodReady {
+			return c.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// deploymentAvailable mirrors the checks of kubectl rollout status
+func deploymentAvailable(d *appsv1.Deployment) (bool, string) {
+	if d.Generation > d.Status.ObservedGeneration {
+		return false, "waiting for the spec update to be observed"
+	}
+
+	replicas := int32(1)
+	if d.Spec.Replicas != nil {
+		replicas = *d.Spec.Replicas
+	}
+	switch {
+	case d.Status.UpdatedReplicas < replicas:
+		return false, fmt.Sprintf("%d of %d replicas updated", d.Status.UpdatedReplicas, replicas)
+	case d.Status.Replicas > d.Status.UpdatedReplicas:
+		return false, fmt.Sprintf("%d old replicas pending termination", d.Status.Replicas-d.Status.UpdatedReplicas)
+	case d.Status.AvailableReplicas < d.Status.UpdatedReplicas:
+		return false, fmt.Sprintf("%d of %d updated replicas available", d.Status.AvailableReplicas, d.Status.UpdatedReplicas)
+	}
+	return true, ""
+}
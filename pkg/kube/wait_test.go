@@ -0,0 +1,253 @@
+package kube
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// node returns a node with the Ready condition set to ready, or without one
+// when ready is empty
+func node(name string, ready corev1.ConditionStatus) *corev1.Node {
+	n := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: name}}
+	if ready != "" {
+		n.Status.Conditions = []corev1.NodeCondition{
+			{Type: corev1.NodeMemoryPressure, Status: corev1.ConditionFalse},
+			{Type: corev1.NodeReady, Status: ready},
+		}
+	}
+	return n
+}
+
+func TestNotReadyNodes(t *testing.T) {
+	client := fake.NewClientset(
+		node("cp-1", corev1.ConditionTrue),
+		node("worker-1", corev1.ConditionFalse),
+		node("worker-2", corev1.ConditionUnknown),
+		node("worker-3", ""),
+		node("worker-4", corev1.ConditionTrue),
+	)
+	names, err := NotReadyNodes(context.Background(), client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"worker-1", "worker-2", "worker-3"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("NotReadyNodes = %v, want %v", names, want)
+	}
+
+	if names, err := NotReadyNodes(context.Background(), fake.NewClientset(node("cp-1", corev1.ConditionTrue))); err != nil || len(names) != 0 {
+		t.Errorf("NotReadyNodes = %v, %v, want none", names, err)
+	}
+
+	failing := fake.NewClientset()
+	failing.PrependReactor("list", "nodes", func(k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.New("connection refused")
+	})
+	if _, err := NotReadyNodes(context.Background(), failing); err == nil {
+		t.Error("expected the API error to be returned")
+	}
+}
+
+// fastPoll shortens the poll interval for the duration of the test
+func fastPoll(t *testing.T) {
+	interval := pollInterval
+	pollInterval = 10 * time.Millisecond
+	t.Cleanup(func() { pollInterval = interval })
+}
+
+// progress records the messages of a wait
+type progress []string
+
+func (p *progress) report(message string) {
+	*p = append(*p, message)
+}
+
+func TestWaitForNodeReady(t *testing.T) {
+	fastPoll(t)
+	client := fake.NewClientset(node("cp-1", corev1.ConditionTrue), node("worker-1", corev1.ConditionFalse))
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := WaitForNodeReady(ctx, client, "cp-1", nil); err != nil {
+		t.Errorf("Ready node: %v", err)
+	}
+
+	// worker-1 turns Ready after a while
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		client.CoreV1().Nodes().Update(context.Background(), node("worker-1", corev1.ConditionTrue), metav1.UpdateOptions{})
+	}()
+	var p progress
+	if err := WaitForNodeReady(ctx, client, "", p.report); err != nil {
+		t.Fatal(err)
+	}
+	if len(p) != 1 || p[0] != "node worker-1 is not Ready" {
+		t.Errorf("progress %q, want one deduplicated message", p)
+	}
+}
+
+func TestWaitForNodeReadyTimeout(t *testing.T) {
+	fastPoll(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	err := WaitForNodeReady(ctx, fake.NewClientset(node("worker-1", corev1.ConditionFalse)), "worker-1", nil)
+	if err == nil || !strings.Contains(err.Error(), "node worker-1 is not Ready") {
+		t.Errorf("got %v, want a timeout naming the node", err)
+	}
+	err = WaitForNodeReady(ctx, fake.NewClientset(), "", nil)
+	if err == nil || !strings.Contains(err.Error(), "no nodes registered yet") {
+		t.Errorf("got %v, want a timeout without nodes", err)
+	}
+}
+
+func TestWaitRetriesAPIErrors(t *testing.T) {
+	fastPoll(t)
+	client := fake.NewClientset(node("cp-1", corev1.ConditionTrue))
+	failures := 2
+	client.PrependReactor("get", "nodes", func(k8stesting.Action) (bool, runtime.Object, error) {
+		if failures > 0 {
+			failures--
+			return true, nil, errors.New("etcdserver: leader changed")
+		}
+		return false, nil, nil
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var p progress
+	if err := WaitForNodeReady(ctx, client, "cp-1", p.report); err != nil {
+		t.Fatalf("transient errors were not retried: %v", err)
+	}
+	if len(p) != 1 || p[0] != "etcdserver: leader changed" {
+		t.Errorf("progress %q, want the API error", p)
+	}
+
+	// An error lasting until the timeout is returned
+	failures = 1000
+	short, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := WaitForNodeReady(short, client, "cp-1", nil); err == nil || !strings.Contains(err.Error(), "leader changed") {
+		t.Errorf("got %v, want the API error", err)
+	}
+}
+
+func TestWaitForDeploymentAvailable(t *testing.T) {
+	fastPoll(t)
+	replicas := int32(2)
+	deployment := func(updated, available int32) *appsv1.Deployment {
+		return &appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: "grafana", Namespace: "monitoring", Generation: 2},
+			Spec:       appsv1.DeploymentSpec{Replicas: &replicas},
+			Status:     appsv1.DeploymentStatus{ObservedGeneration: 2, Replicas: 2, UpdatedReplicas: updated, AvailableReplicas: available},
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := WaitForDeploymentAvailable(ctx, fake.NewClientset(deployment(2, 2)), "monitoring", "grafana", nil); err != nil {
+		t.Errorf("available deployment: %v", err)
+	}
+
+	short, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	err := WaitForDeploymentAvailable(short, fake.NewClientset(deployment(2, 1)), "monitoring", "grafana", nil)
+	if err == nil || !strings.Contains(err.Error(), "deployment monitoring/grafana: 1 of 2 updated replicas available") {
+		t.Errorf("got %v, want a timeout with the rollout state", err)
+	}
+	if err := WaitForDeploymentAvailable(short, fake.NewClientset(), "monitoring", "grafana", nil); err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Errorf("got %v, want the missing deployment", err)
+	}
+}
+
+func TestDeploymentAvailable(t *testing.T) {
+	replicas := int32(3)
+	for _, tt := range []struct {
+		status  appsv1.DeploymentStatus
+		gen     int64
+		done    bool
+		message string
+	}{
+		{appsv1.DeploymentStatus{ObservedGeneration: 1, Replicas: 3, UpdatedReplicas: 3, AvailableReplicas: 3}, 1, true, ""},
+		{appsv1.DeploymentStatus{ObservedGeneration: 1, Replicas: 3, UpdatedReplicas: 3, AvailableReplicas: 3}, 2, false, "waiting for the spec update to be observed"},
+		{appsv1.DeploymentStatus{ObservedGeneration: 2, Replicas: 3, UpdatedReplicas: 1}, 2, false, "1 of 3 replicas updated"},
+		{appsv1.DeploymentStatus{ObservedGeneration: 2, Replicas: 4, UpdatedReplicas: 3}, 2, false, "1 old replicas pending termination"},
+		{appsv1.DeploymentStatus{ObservedGeneration: 2, Replicas: 3, UpdatedReplicas: 3, AvailableReplicas: 2}, 2, false, "2 of 3 updated replicas available"},
+	} {
+		d := &appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Generation: tt.gen},
+			Spec:       appsv1.DeploymentSpec{Replicas: &replicas},
+			Status:     tt.status,
+		}
+		if done, message := deploymentAvailable(d); done != tt.done || message != tt.message {
+			t.Errorf("deploymentAvailable(%+v) = %t, %q, want %t, %q", tt.status, done, message, tt.done, tt.message)
+		}
+	}
+}
+
+// pod returns a pod of the app grafana on node, Ready or not
+func pod(name, node string, ready bool) *corev1.Pod {
+	status := corev1.ConditionFalse
+	if ready {
+		status = corev1.ConditionTrue
+	}
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "monitoring", Labels: map[string]string{"app": "grafana"}},
+		Spec:       corev1.PodSpec{NodeName: node},
+		Status:     corev1.PodStatus{Phase: corev1.PodRunning, Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: status}}},
+	}
+}
+
+func TestWaitForPodsBySelector(t *testing.T) {
+	fastPoll(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Pods of other apps are ignored
+	other := pod("other", "worker-1", false)
+	other.Labels = map[string]string{"app": "other"}
+	client := fake.NewClientset(pod("grafana-1", "worker-1", true), other)
+	if err := WaitForPodsBySelector(ctx, client, "monitoring", "app=grafana", nil); err != nil {
+		t.Errorf("Ready pods: %v", err)
+	}
+
+	short, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	err := WaitForPodsBySelector(short, fake.NewClientset(pod("grafana-1", "worker-1", true), pod("grafana-2", "worker-2", false)), "monitoring", "app=grafana", nil)
+	if err == nil || !strings.Contains(err.Error(), "1/2 pods matching app=grafana in monitoring are Ready") {
+		t.Errorf("got %v, want a timeout counting the Ready pods", err)
+	}
+	err = WaitForPodsBySelector(short, fake.NewClientset(), "monitoring", "app=grafana", nil)
+	if err == nil || !strings.Contains(err.Error(), "no pods match app=grafana in monitoring") {
+		t.Errorf("got %v, want a timeout without pods", err)
+	}
+}
+
+func TestWaitForNodePods(t *testing.T) {
+	fastPoll(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	completed := pod("backup-job", "worker-1", false)
+	completed.Status.Phase = corev1.PodSucceeded
+	if err := WaitForNodePods(ctx, fake.NewClientset(pod("grafana-1", "worker-1", true), completed), "worker-1", nil); err != nil {
+		t.Errorf("Ready and completed pods: %v", err)
+	}
+
+	short, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	err := WaitForNodePods(short, fake.NewClientset(pod("grafana-1", "worker-1", false)), "worker-1", nil)
+	if err == nil || !strings.Contains(err.Error(), "pod monitoring/grafana-1 on node worker-1 is not Ready") {
+		t.Errorf("got %v, want a timeout naming the pod", err)
+	}
+}
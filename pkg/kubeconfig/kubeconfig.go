@@ -0,0 +1,330 @@
+package kubeconfig
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config is a kubeconfig file. Cluster and user bodies are kept as generic maps
+// so that fields this tool does not know about survive a merge.
+type Config struct {
+	APIVersion     string                 `yaml:"apiVersion"`
+	Kind           string                 `yaml:"kind"`
+	Preferences    map[string]interface{} `yaml:"preferences"`
+	CurrentContext string                 `yaml:"current-context"`
+	Clusters       []NamedCluster         `yaml:"clusters"`
+	Contexts       []NamedContext         `yaml:"contexts"`
+	Users          []NamedUser            `yaml:"users"`
+}
+
+// NamedCluster is an entry of the clusters list
+type NamedCluster struct {
+	Name    string                 `yaml:"name"`
+	Cluster map[string]interface{} `yaml:"cluster"`
+}
+
+// NamedContext is an entry of the contexts list
+type NamedContext struct {
+	Name    string  `yaml:"name"`
+	Context Context `yaml:"context"`
+}
+
+// Context binds a cluster to a user
+type Context struct {
+	Cluster   string `yaml:"cluster"`
+	User      string `yaml:"user"`
+	Namespace string `yaml:"namespace,omitempty"`
+}
+
+// NamedUser is an entry of the users list
+type NamedUser struct {
+	Name string                 `yaml:"name"`
+	User map[string]interface{} `yaml:"user"`
+}
+
+// DefaultPath returns ~/.kube/config
+func DefaultPath() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return filepath.Join(".kube", "config")
+	}
+	return filepath.Join(home, ".kube", "config")
+}
+
+// Load reads a kubeconfig file. A missing file yields an empty config.
+func Load(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return &Config{APIVersion: "v1", Kind: "Config", Preferences: map[string]interface{}{}}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read kubeconfig: %v", err)
+	}
+
+	return Parse(data)
+}
+
+// Parse decodes a kubeconfig document
+func Parse(data []byte) (*Config, error) {
+	var cfg Config
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse kubeconfig: %v", err)
+	}
+	if cfg.Preferences == nil {
+		cfg.Preferences = map[string]interface{}{}
+	}
+	return &cfg, nil
+}
+
+// Save writes the kubeconfig with owner-only permissions
+func (c *Config) Save(path string) error {
+	data, err := yaml.Marshal(c)
+	if err != nil {
+		return fmt.Errorf("failed to marshal kubeconfig: %v", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("failed to create kubeconfig directory: %v", err)
+	}
+
+	return ioutil.WriteFile(path, data, 0600)
+}
+
+// Server returns the API server of the cluster used by the named context
+func (c *Config) Server(context string) string {
+	for _, ctx := range c.Contexts {
+		if ctx.Name != context {
+			continue
+		}
+		for _, cluster := range c.Clusters {
+			if cluster.Name == ctx.Context.Cluster {
+				server, _ := cluster.Cluster["server"].(string)
+				return server
+			}
+		}
+	}
+	return ""
+}
+
+// SetServer points the cluster used by the named context at server and
+// reports whether the context exists
+func (c *Config) SetServer(context, server string) bool {
+	for _, ctx := range c.Contexts {
+		if ctx.Name != context {
+			continue
+		}
+		for _, cluster := range c.Clusters {
+			if cluster.Name == ctx.Context.Cluster {
+				cluster.Cluster["server"] = server
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// HasContext reports whether the named context exists
+func (c *Config) HasContext(name string) bool {
+	for _, ctx := range c.Contexts {
+		if ctx.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Merge adds the current context of src to c under the given name, with a
+// cluster of that name and a user of that name with -admin appended, and
+// returns the context name used. An earlier merge of the same API server is
+// replaced; when any of the names is taken otherwise, the existing entries
+// are kept and a numeric suffix is added to the new ones.
+func (c *Config) Merge(src *Config, name string) (string, error) {
+	context := src.CurrentContext
+	if context == "" && len(src.Contexts) > 0 {
+		context = src.Contexts[0].Name
+	}
+
+	var ctx *NamedContext
+	for i := range src.Contexts {
+		if src.Contexts[i].Name == context {
+			ctx = &src.Contexts[i]
+		}
+	}
+	if ctx == nil {
+		return "", fmt.Errorf("kubeconfig has no context %q", context)
+	}
+
+	var cluster *NamedCluster
+	for i := range src.Clusters {
+		if src.Clusters[i].Name == ctx.Context.Cluster {
+			cluster = &src.Clusters[i]
+		}
+	}
+	var user *NamedUser
+	for i := range src.Users {
+		if src.Users[i].Name == ctx.Context.User {
+			user = &src.Users[i]
+		}
+	}
+	if cluster == nil || user == nil {
+		return "", fmt.Errorf("context %q references a missing cluster or user", context)
+	}
+
+	server, _ := cluster.Cluster["server"].(string)
+	final := name
+	for i := 2; !c.free(final, server); i++ {
+		final = fmt.Sprintf("%s-%d", name, i)
+	}
+
+	c.setCluster(NamedCluster{Name: final, Cluster: cluster.Cluster})
+	c.setUser(NamedUser{Name: final + "-admin", User: user.User})
+	c.setContext(NamedContext{
+		Name: final,
+		Context: Context{
+			Cluster:   final,
+			User:      final + "-admin",
+			Namespace: ctx.Context.Namespace,
+		},
+	})
+
+	return final, nil
+}
+
+// Rename renames the named context, and the cluster and user Merge created
+// for it, and returns the new name. Like Merge, a numeric suffix is added when
+// the name is taken by anything but an earlier merge of the same API server.
+func (c *Config) Rename(context, name string) (string, error) {
+	if !c.HasContext(context) {
+		return "", fmt.Errorf("context %q not found", context)
+	}
+	if context == name {
+		return name, nil
+	}
+
+	server := c.Server(context)
+	final := name
+	for i := 2; !c.free(final, server); i++ {
+		final = fmt.Sprintf("%s-%d", name, i)
+	}
+	// A context with the name for the same API server is a stale copy
+	c.remove(final)
+
+	for i := range c.Contexts {
+		ctx := &c.Contexts[i]
+		if ctx.Name != context {
+			continue
+		}
+		ctx.Name = final
+		if ctx.Context.Cluster == context {
+			for j := range c.Clusters {
+				if c.Clusters[j].Name == context {
+					c.Clusters[j].Name = final
+				}
+			}
+			ctx.Context.Cluster = final
+		}
+		if ctx.Context.User == context+"-admin" {
+			for j := range c.Users {
+				if c.Users[j].Name == context+"-admin" {
+					c.Users[j].Name = final + "-admin"
+				}
+			}
+			ctx.Context.User = final + "-admin"
+		}
+	}
+	if c.CurrentContext == context {
+		c.CurrentContext = final
+	}
+	return final, nil
+}
+
+// free reports whether name can hold the context, cluster and user Merge
+// creates for server: none of them exists, or they are an earlier merge of
+// the same API server
+func (c *Config) free(name, server string) bool {
+	for _, ctx := range c.Contexts {
+		if ctx.Name == name {
+			return ctx.Context.Cluster == name && ctx.Context.User == name+"-admin" && c.Server(name) == server
+		}
+	}
+	for _, cluster := range c.Clusters {
+		if cluster.Name == name {
+			return false
+		}
+	}
+	for _, user := range c.Users {
+		if user.Name == name+"-admin" {
+			return false
+		}
+	}
+	return true
+}
+
+// remove deletes the named context with the cluster and user Merge created
+// for it
+func (c *Config) remove(name string) {
+	contexts := c.Contexts[:0]
+	for _, ctx := range c.Contexts {
+		if ctx.Name != name {
+			contexts = append(contexts, ctx)
+		}
+	}
+	c.Contexts = contexts
+	clusters := c.Clusters[:0]
+	for _, cluster := range c.Clusters {
+		if cluster.Name != name {
+			clusters = append(clusters, cluster)
+		}
+	}
+	c.Clusters = clusters
+	users := c.Users[:0]
+	for _, user := range c.Users {
+		if user.Name != name+"-admin" {
+			users = append(users, user)
+		}
+	}
+	c.Users = users
+}
+
+// Use switches the current context
+func (c *Config) Use(context string) error {
+	if !c.HasContext(context) {
+		return fmt.Errorf("context %q not found", context)
+	}
+	c.CurrentContext = context
+	return nil
+}
+
+func (c *Config) setCluster(entry NamedCluster) {
+	for i := range c.Clusters {
+		if c.Clusters[i].Name == entry.Name {
+			c.Clusters[i] = entry
+			return
+		}
+	}
+	c.Clusters = append(c.Clusters, entry)
+}
+
+func (c *Config) setUser(entry NamedUser) {
+	for i := range c.Users {
+		if c.Users[i].Name == entry.Name {
+			c.Users[i] = entry
+			return
+		}
+	}
+	c.Users = append(c.Users, entry)
+}
+
+func (c *Config) setContext(entry NamedContext) {
+	for i := range c.Contexts {
+		if c.Contexts[i].Name == entry.Name {
+			c.Contexts[i] = entry
+			return
+		}
+	}
+	c.Contexts = append(c.Contexts, entry)
+}
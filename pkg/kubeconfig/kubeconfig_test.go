@@ -0,0 +1,160 @@
+package kubeconfig
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testConfig returns a kubeconfig of the context prod using its own cluster
+func testConfig() *Config {
+	return &Config{
+		APIVersion:     "v1",
+		Kind:           "Config",
+		CurrentContext: "prod",
+		Clusters: []NamedCluster{
+			{Name: "prod", Cluster: map[string]interface{}{"server": "https://10.0.0.1:6443", "certificate-authority-data": "Q0E="}},
+		},
+		Contexts: []NamedContext{{Name: "prod", Context: Context{Cluster: "prod", User: "prod-admin"}}},
+		Users:    []NamedUser{{Name: "prod-admin", User: map[string]interface{}{"token": "secret"}}},
+	}
+}
+
+func TestSetServer(t *testing.T) {
+	cfg := testConfig()
+	if !cfg.SetServer("prod", "https://k8s.example.com:6443") {
+		t.Fatal("SetServer did not find the context")
+	}
+	if server := cfg.Server("prod"); server != "https://k8s.example.com:6443" {
+		t.Errorf("server %s", server)
+	}
+	if ca := cfg.Clusters[0].Cluster["certificate-authority-data"]; ca != "Q0E=" {
+		t.Errorf("certificate authority %v was not kept", ca)
+	}
+
+	if cfg.SetServer("staging", "https://10.0.0.9:6443") {
+		t.Error("SetServer reported a missing context")
+	}
+	if server := cfg.Server("prod"); server != "https://k8s.example.com:6443" {
+		t.Errorf("a missing context changed the server to %s", server)
+	}
+}
+
+func TestSetServerSaved(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config")
+	cfg := testConfig()
+	cfg.SetServer("prod", "https://k8s.example.com:6443")
+	if err := cfg.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if server := loaded.Server("prod"); server != "https://k8s.example.com:6443" {
+		t.Errorf("saved server %s", server)
+	}
+}
+
+func TestRename(t *testing.T) {
+	cfg := testConfig()
+	name, err := cfg.Rename("prod", "edge-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "edge-1" || cfg.CurrentContext != "edge-1" || cfg.HasContext("prod") {
+		t.Errorf("renamed to %s, current context %s", name, cfg.CurrentContext)
+	}
+	ctx := cfg.Contexts[0].Context
+	if ctx.Cluster != "edge-1" || ctx.User != "edge-1-admin" || cfg.Clusters[0].Name != "edge-1" || cfg.Users[0].Name != "edge-1-admin" {
+		t.Errorf("cluster and user were not renamed with the context: %+v", cfg)
+	}
+	if server := cfg.Server("edge-1"); server != "https://10.0.0.1:6443" {
+		t.Errorf("renamed context points at %s", server)
+	}
+
+	if _, err := cfg.Rename("staging", "edge-2"); err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Errorf("error %v, want the missing context", err)
+	}
+}
+
+func TestRenameTaken(t *testing.T) {
+	// The name belongs to another cluster, so a suffix is added
+	cfg := testConfig()
+	other := testConfig()
+	other.Clusters[0].Cluster["server"] = "https://10.0.1.1:6443"
+	if _, err := cfg.Merge(other, "edge-1"); err != nil {
+		t.Fatal(err)
+	}
+	name, err := cfg.Rename("prod", "edge-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "edge-1-2" || cfg.Server("edge-1") != "https://10.0.1.1:6443" || cfg.Server("edge-1-2") != "https://10.0.0.1:6443" {
+		t.Errorf("renamed to %s, edge-1 at %s", name, cfg.Server("edge-1"))
+	}
+
+	// A stale copy of the same cluster is replaced
+	cfg = testConfig()
+	if _, err := cfg.Merge(testConfig(), "edge-1"); err != nil {
+		t.Fatal(err)
+	}
+	name, err = cfg.Rename("prod", "edge-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "edge-1" || len(cfg.Contexts) != 1 || len(cfg.Clusters) != 1 || len(cfg.Users) != 1 {
+		t.Errorf("renamed to %s, kept %d contexts, %d clusters and %d users", name, len(cfg.Contexts), len(cfg.Clusters), len(cfg.Users))
+	}
+}
+
+func TestMergeConflicts(t *testing.T) {
+	cluster := func(server string) *Config {
+		src := testConfig()
+		src.Clusters[0].Cluster["server"] = server
+		return src
+	}
+
+	// Entries of the name that Merge did not create are kept
+	for _, tc := range []struct {
+		what string
+		dst  *Config
+		kept func(*Config) bool
+	}{
+		{"context", &Config{Contexts: []NamedContext{{Name: "edge", Context: Context{Cluster: "lab", User: "me"}}}},
+			func(c *Config) bool { return c.Contexts[0].Context.Cluster == "lab" }},
+		{"cluster", &Config{Clusters: []NamedCluster{{Name: "edge", Cluster: map[string]interface{}{"server": "https://10.9.0.1:6443"}}}},
+			func(c *Config) bool { return c.Clusters[0].Cluster["server"] == "https://10.9.0.1:6443" }},
+		{"user", &Config{Users: []NamedUser{{Name: "edge-admin", User: map[string]interface{}{"token": "mine"}}}},
+			func(c *Config) bool { return c.Users[0].User["token"] == "mine" }},
+	} {
+		name, err := tc.dst.Merge(cluster("https://10.0.0.1:6443"), "edge")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if name != "edge-2" {
+			t.Errorf("%s taken: merged as %s, want edge-2", tc.what, name)
+		}
+		if !tc.kept(tc.dst) {
+			t.Errorf("%s taken: the existing entry was changed: %+v", tc.what, tc.dst)
+		}
+		if server := tc.dst.Server("edge-2"); server != "https://10.0.0.1:6443" {
+			t.Errorf("%s taken: edge-2 points at %s", tc.what, server)
+		}
+	}
+
+	// Another API server gets a suffix, the same one is merged again in place
+	dst := &Config{}
+	dst.Merge(cluster("https://10.0.0.1:6443"), "edge")
+	if name, _ := dst.Merge(cluster("https://10.0.1.1:6443"), "edge"); name != "edge-2" {
+		t.Errorf("another API server merged as %s, want edge-2", name)
+	}
+	again := cluster("https://10.0.0.1:6443")
+	again.Users[0].User["token"] = "rotated"
+	if name, _ := dst.Merge(again, "edge"); name != "edge" {
+		t.Errorf("the same API server merged as %s, want edge", name)
+	}
+	if len(dst.Contexts) != 2 || len(dst.Clusters) != 2 || len(dst.Users) != 2 || dst.Users[0].User["token"] != "rotated" {
+		t.Errorf("merging again did not replace the earlier merge: %+v", dst)
+	}
+}
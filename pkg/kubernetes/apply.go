@@ -0,0 +1,59 @@
+package kubernetes
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+
+	"github.com/maarulav/k8s-setup/pkg/ssh"
+)
+
+const manifestDir = "/root/k8s-manifests"
+
+// Apply uploads the given objects as a single List manifest and applies it with kubectl
+func Apply(client ssh.Executor, name string, objects []map[string]interface{}) error {
+	file, err := writeManifest(client, name, objects)
+	if err != nil {
+		return err
+	}
+
+	cmd := fmt.Sprintf("kubectl apply -f %s", file)
+	if output, err := client.ExecuteCommand(cmd); err != nil {
+		return fmt.Errorf("failed to execute command '%s': %v\nOutput: %s", cmd, err, output)
+	}
+
+	return nil
+}
+
+// DryRun submits the objects to the API server without persisting them, so
+// that admission webhooks see them. It returns the output of kubectl, which
+// explains a rejection.
+func DryRun(client ssh.Executor, name string, objects []map[string]interface{}) (string, error) {
+	file, err := writeManifest(client, name, objects)
+	if err != nil {
+		return "", err
+	}
+	return client.ExecuteCommand(fmt.Sprintf("kubectl create --dry-run=server -f %s", file))
+}
+
+// writeManifest uploads the objects as a List manifest and returns its path
+func writeManifest(client ssh.Executor, name string, objects []map[string]interface{}) (string, error) {
+	manifest, err := json.MarshalIndent(map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "List",
+		"items":      objects,
+	}, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to render %s manifest: %v", name, err)
+	}
+
+	if _, err := client.ExecuteCommand(fmt.Sprintf("mkdir -p %s", manifestDir)); err != nil {
+		return "", fmt.Errorf("failed to create manifest directory: %v", err)
+	}
+
+	file := path.Join(manifestDir, name+".json")
+	if err := client.WriteFile(file, manifest, 0600); err != nil {
+		return "", err
+	}
+	return file, nil
+}
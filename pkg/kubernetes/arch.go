@@ -0,0 +1,41 @@
+package kubernetes
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/maarulav/k8s-setup/pkg/ssh"
+)
+
+// Supported host architectures, named as Debian packages and images name them
+const (
+	ArchAMD64 = "amd64"
+	ArchARM64 = "arm64"
+)
+
+// DetectArch returns the architecture of the host, failing for the ones no
+// packages are published for
+func DetectArch(client ssh.Executor) (string, error) {
+	output, err := client.ExecuteCommand("uname -m")
+	if err != nil {
+		return "", fmt.Errorf("failed to detect architecture: %v", err)
+	}
+
+	machine := strings.TrimSpace(output)
+	arch, ok := archName(machine)
+	if !ok {
+		return "", fmt.Errorf("unsupported architecture %q, expected x86_64 or aarch64", machine)
+	}
+	return arch, nil
+}
+
+// archName returns the supported architecture uname -m reports as machine
+func archName(machine string) (string, bool) {
+	switch machine {
+	case "x86_64", "amd64":
+		return ArchAMD64, true
+	case "aarch64", "arm64":
+		return ArchARM64, true
+	}
+	return "", false
+}
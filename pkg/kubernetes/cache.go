@@ -0,0 +1,66 @@
+package kubernetes
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/maarulav/k8s-setup/pkg/ssh"
+)
+
+// Steps of Setup that are skipped while their cache key is unchanged
+const (
+	StepPackages = "packages"
+	StepImages   = "images"
+	StepInit     = "init"
+)
+
+// StepCache maps the expensive idempotent steps run on a host to the key
+// they last succeeded with. The key hashes the host fingerprint and the
+// inputs of the step, so the step runs again when either changes.
+type StepCache map[string]string
+
+// Fingerprint identifies the host behind client by its machine ID, which
+// changes when the VM behind an address is replaced or reinstalled
+func Fingerprint(client ssh.Executor) (string, error) {
+	output, err := client.ExecuteCommand("cat /etc/machine-id")
+	if err != nil {
+		return "", fmt.Errorf("failed to read machine ID: %v", err)
+	}
+	id := strings.TrimSpace(output)
+	if id == "" {
+		return "", fmt.Errorf("host has an empty machine ID")
+	}
+	return id, nil
+}
+
+// cacheKey hashes the host fingerprint together with the inputs of a step
+func cacheKey(fingerprint string, inputs ...interface{}) string {
+	h := sha256.New()
+	h.Write([]byte(fingerprint))
+	for _, input := range inputs {
+		data, _ := json.Marshal(input)
+		h.Write(data)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// run runs fn unless step last succeeded with key. A nil cache runs every
+// step.
+func (c StepCache) run(step, key string, fn func() error) error {
+	if c == nil {
+		return fn()
+	}
+	if c[step] == key {
+		fmt.Printf("Skipping %s, unchanged since the last run\n", step)
+		return nil
+	}
+	delete(c, step)
+	if err := fn(); err != nil {
+		return err
+	}
+	c[step] = key
+	return nil
+}
@@ -0,0 +1,105 @@
+package kubernetes
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/maarulav/k8s-setup/pkg/config"
+	"github.com/maarulav/k8s-setup/pkg/ssh"
+)
+
+// cgroupDriver returns the configured cgroup driver. Unset it is systemd,
+// which runs on both cgroup versions and is the only one cgroup v2 hosts
+// support.
+func cgroupDriver(cfg *config.Config) (string, error) {
+	switch driver := cfg.Kubernetes.CgroupDriver; driver {
+	case "":
+		return config.CgroupDriverSystemd, nil
+	case config.CgroupDriverSystemd, config.CgroupDriverCgroupfs:
+		return driver, nil
+	default:
+		return "", fmt.Errorf("unknown cgroupDriver %q, expected systemd or cgroupfs", driver)
+	}
+}
+
+// DetectCgroupVersion returns the cgroup version the host mounts, 1 or 2
+func DetectCgroupVersion(client ssh.Executor) (int, error) {
+	output, err := client.ExecuteCommand("stat -fc %T /sys/fs/cgroup")
+	if err != nil {
+		return 0, fmt.Errorf("failed to detect the cgroup version: %v", err)
+	}
+	if strings.TrimSpace(output) == "cgroup2fs" {
+		return 2, nil
+	}
+	return 1, nil
+}
+
+// checkCgroups fails when the host cannot run the configured cgroup driver,
+// that is when cgroupfs is set explicitly on a cgroup v2 host
+func checkCgroups(client ssh.Executor, cfg *config.Config) error {
+	driver, err := cgroupDriver(cfg)
+	if err != nil {
+		return err
+	}
+	if _, isScript := client.(scripted); isScript {
+		// An image build does not run on the hosts it is for
+		return nil
+	}
+
+	version, err := DetectCgroupVersion(client)
+	if err != nil {
+		return err
+	}
+	if version == 2 && driver == config.CgroupDriverCgroupfs {
+		return fmt.Errorf("%s uses cgroup v2, which systemd manages, remove cgroupDriver %q or set it to systemd", client.Host(), driver)
+	}
+	return nil
+}
+
+// runtimeCommands returns the commands pointing the container runtime at the
+// configured cgroup driver
+func runtimeCommands(cfg *config.Config) []string {
+	driver, _ := cgroupDriver(cfg)
+	return []string{
+		// The containerd.io package ships a config with the CRI plugin
+		// disabled, replace it with the defaults in that case
+		fmt.Sprintf("test -s %[1]s && ! grep -q 'disabled_plugins = \\[\"cri\"\\]' %[1]s || containerd config default > %[1]s", ContainerdConfig),
+		fmt.Sprintf("sed -i 's/SystemdCgroup = .*/SystemdCgroup = %t/' %s", driver == config.CgroupDriverSystemd, ContainerdConfig),
+		"systemctl restart containerd",
+	}
+}
+
+// kubeletConfiguration returns the KubeletConfiguration kubeadm passes to
+// every kubelet of the cluster, or nil when the kubelet defaults of the
+// version fit the configuration. It carries the cgroup driver and the
+// reservations, see kubeletReservations.
+func kubeletConfiguration(cfg *config.Config) (map[string]interface{}, error) {
+	driver, err := cgroupDriver(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	kubelet := map[string]interface{}{}
+	// kubeadm defaults the kubelet to systemd from 1.22, to cgroupfs before
+	minor := minorVersion(cfg.Kubernetes.Version)
+	defaultDriver := config.CgroupDriverSystemd
+	if minor < 22 {
+		defaultDriver = config.CgroupDriverCgroupfs
+	}
+	if driver != defaultDriver {
+		kubelet["cgroupDriver"] = driver
+	}
+	reserved, err := kubeletReservations(cfg)
+	if err != nil {
+		return nil, err
+	}
+	for key, value := range reserved {
+		kubelet[key] = value
+	}
+	if len(kubelet) == 0 {
+		return nil, nil
+	}
+	kubelet["apiVersion"] = "kubelet.config.k8s.io/v1beta1"
+	kubelet["kind"] = "KubeletConfiguration"
+	return kubelet, nil
+}
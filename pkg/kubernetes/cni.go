@@ -0,0 +1,136 @@
+package kubernetes
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/maarulav/k8s-setup/pkg/config"
+	"github.com/maarulav/k8s-setup/pkg/helm"
+	"github.com/maarulav/k8s-setup/pkg/ssh"
+)
+
+// Supported network plugins
+const (
+	CNICalico  = "calico"
+	CNIFlannel = "flannel"
+	CNICilium  = "cilium"
+)
+
+const (
+	flannelManifest = "https://github.com/flannel-io/flannel/releases/latest/download/kube-flannel.yml"
+	// flannelNetwork is the pod network in the net-conf.json of the manifest
+	flannelNetwork = "10.244.0.0/16"
+)
+
+// cni describes where a network plugin runs once installed
+type cni struct {
+	name      string
+	namespace string
+	daemonSet string
+	container string
+}
+
+var cnis = []cni{
+	{CNICalico, "kube-system", "calico-node", "calico-node"},
+	{CNIFlannel, "kube-flannel", "kube-flannel-ds", "kube-flannel"},
+	{CNICilium, "kube-system", "cilium", "cilium-agent"},
+}
+
+// cniFor returns the configured network plugin, defaulting to Calico
+func cniFor(cfg *config.Config) cni {
+	for _, c := range cnis {
+		if c.name == cfg.Kubernetes.CNI {
+			return c
+		}
+	}
+	return cnis[0]
+}
+
+// installCNI installs the configured network plugin
+func installCNI(client ssh.Executor, cfg *config.Config) error {
+	switch cniFor(cfg).name {
+	case CNIFlannel:
+		return installFlannel(client, cfg)
+	case CNICilium:
+		return helm.Install(client, helm.Chart{
+			Release:   "cilium",
+			RepoURL:   "https://helm.cilium.io/",
+			Chart:     "cilium",
+			Namespace: "kube-system",
+			Values:    ciliumValues(client, cfg),
+		})
+	default:
+		return applyURL(client, "https://docs.projectcalico.org/manifests/calico.yaml")
+	}
+}
+
+// installFlannel applies the flannel manifest with the pod network of its
+// net-conf.json replaced by the configured one
+func installFlannel(client ssh.Executor, cfg *config.Config) error {
+	network := cfg.Kubernetes.PodCIDR
+	if network == "" || network == flannelNetwork {
+		return applyURL(client, flannelManifest)
+	}
+	if _, _, err := net.ParseCIDR(network); err != nil {
+		return fmt.Errorf("invalid pod network %q: %v", network, err)
+	}
+
+	edit := fmt.Sprintf("s#%s#%s#", strings.ReplaceAll(flannelNetwork, ".", `\.`), network)
+	cmd := fmt.Sprintf("curl -fsSL %s | sed %s | kubectl apply -f -", flannelManifest, ssh.Quote(edit))
+	if output, err := client.ExecuteCommand(cmd); err != nil {
+		return fmt.Errorf("failed to execute command '%s': %v\nOutput: %s", cmd, err, output)
+	}
+	return nil
+}
+
+// ciliumValues returns the Cilium chart values. Without kube-proxy Cilium
+// cannot reach the API server through its service IP, so it is pointed at the
+// control plane directly.
+func ciliumValues(client ssh.Executor, cfg *config.Config) map[string]interface{} {
+	values := map[string]interface{}{
+		"ipam": map[string]interface{}{"mode": "kubernetes"},
+	}
+	if dualStack(cfg) {
+		values["ipv6"] = map[string]interface{}{"enabled": true}
+	}
+	if kubeProxyReplacement(cfg) {
+		values["kubeProxyReplacement"] = true
+		values["k8sServiceHost"] = client.Host()
+		values["k8sServicePort"] = 6443
+	}
+	return values
+}
+
+// kubeProxyReplacement reports whether the cluster runs without kube-proxy,
+// which is only supported with Cilium
+func kubeProxyReplacement(cfg *config.Config) bool {
+	return cfg.Kubernetes.KubeProxyReplacement && cniFor(cfg).name == CNICilium
+}
+
+// verifyServiceRouting checks that Cilium replaced kube-proxy and that a pod
+// can reach the API server through its ClusterIP service
+func verifyServiceRouting(client ssh.Executor) error {
+	commands := []string{
+		"kubectl -n kube-system rollout status daemonset/cilium --timeout=300s",
+		"kubectl -n kube-system exec ds/cilium -c cilium-agent -- cilium-dbg status | grep -E 'KubeProxyReplacement:\\s+True'",
+		"kubectl run service-routing-check --rm -i --restart=Never --image=curlimages/curl --timeout=120s -- " +
+			"curl -sk -o /dev/null -w '%{http_code}' https://kubernetes.default.svc/healthz",
+	}
+
+	for _, cmd := range commands {
+		if output, err := client.ExecuteCommand(cmd); err != nil {
+			return fmt.Errorf("failed to execute command '%s': %v\nOutput: %s", cmd, err, output)
+		}
+	}
+
+	return nil
+}
+
+func applyURL(client ssh.Executor, url string) error {
+	cmd := fmt.Sprintf("kubectl apply -f %s", url)
+	if output, err := client.ExecuteCommand(cmd); err != nil {
+		return fmt.Errorf("failed to execute command '%s': %v\nOutput: %s", cmd, err, output)
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+package kubernetes
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strings"
+
+	"github.com/maarulav/k8s-setup/pkg/config"
+	"github.com/maarulav/k8s-setup/pkg/ssh"
+)
+
+const (
+	// ContainerdConfig is the containerd configuration file on every node
+	ContainerdConfig = "/etc/containerd/config.toml"
+
+	// ContainerdCertsDir holds one hosts.toml directory per registry
+	ContainerdCertsDir = "/etc/containerd/certs.d"
+)
+
+// ConfigureRegistryMirrors writes the certs.d hosts.toml of every configured
+// registry and points containerd at the directory
+func ConfigureRegistryMirrors(client ssh.Executor, cfg *config.Config) error {
+	if len(cfg.RegistryMirrors) == 0 {
+		return nil
+	}
+
+	for _, mirror := range cfg.RegistryMirrors {
+		dir := path.Join(ContainerdCertsDir, mirror.Registry)
+		if _, err := client.ExecuteCommand("mkdir -p " + dir); err != nil {
+			return fmt.Errorf("failed to create %s: %v", dir, err)
+		}
+
+		caPath := ""
+		if mirror.CAFile != "" {
+			ca, err := ioutil.ReadFile(mirror.CAFile)
+			if err != nil {
+				return fmt.Errorf("failed to read CA of registry %s: %v", mirror.Registry, err)
+			}
+			caPath = path.Join(dir, "ca.crt")
+			if err := client.WriteFile(caPath, ca, 0644); err != nil {
+				return err
+			}
+		}
+
+		if err := client.WriteFile(path.Join(dir, "hosts.toml"), []byte(renderHostsTOML(mirror, caPath)), 0644); err != nil {
+			return err
+		}
+	}
+
+	// PrepareNode already replaced a config without the CRI plugin
+	commands := []string{
+		fmt.Sprintf(`sed -i 's#config_path = ""#config_path = "%s"#' %s`, ContainerdCertsDir, ContainerdConfig),
+		"systemctl restart containerd",
+	}
+	for _, cmd := range commands {
+		if output, err := client.ExecuteCommand(cmd); err != nil {
+			return fmt.Errorf("failed to execute command '%s': %v\nOutput: %s", cmd, err, output)
+		}
+	}
+
+	return nil
+}
+
+// renderHostsTOML renders the hosts.toml of a registry. Mirrors come first
+// with pull and resolve only; the registry itself is the server.
+func renderHostsTOML(mirror config.RegistryMirror, caPath string) string {
+	scheme := "https://"
+	if mirror.Insecure {
+		scheme = "http://"
+	}
+
+	server := scheme + mirror.Registry
+	if mirror.Registry == "docker.io" {
+		server = "https://registry-1.docker.io"
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "server = %q\n", server)
+
+	endpoints := mirror.Endpoints
+	if len(endpoints) == 0 && (mirror.Insecure || caPath != "") {
+		endpoints = []string{server}
+	}
+	for _, endpoint := range endpoints {
+		if !strings.Contains(endpoint, "://") {
+			endpoint = scheme + endpoint
+		}
+
+		fmt.Fprintf(&b, "\n[host.%q]\n", endpoint)
+		if endpoint == server {
+			b.WriteString("  capabilities = [\"pull\", \"resolve\", \"push\"]\n")
+		} else {
+			b.WriteString("  capabilities = [\"pull\", \"resolve\"]\n")
+		}
+		if mirror.Insecure {
+			b.WriteString("  skip_verify = true\n")
+		}
+		if caPath != "" {
+			fmt.Fprintf(&b, "  ca = %q\n", caPath)
+		}
+	}
+
+	return b.String()
+}
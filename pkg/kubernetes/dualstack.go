@@ -0,0 +1,171 @@
+package kubernetes
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/maarulav/k8s-setup/pkg/config"
+	"github.com/maarulav/k8s-setup/pkg/ssh"
+)
+
+// dualStack reports whether the cluster gets IPv6 ranges next to the IPv4 ones
+func dualStack(cfg *config.Config) bool {
+	return cfg.Kubernetes.PodCIDRv6 != "" && cfg.Kubernetes.ServiceCIDRv6 != ""
+}
+
+// podCIDRs returns the pod ranges in the comma separated form kubeadm expects
+func podCIDRs(cfg *config.Config) string {
+	if dualStack(cfg) {
+		return cfg.Kubernetes.PodCIDR + "," + cfg.Kubernetes.PodCIDRv6
+	}
+	return cfg.Kubernetes.PodCIDR
+}
+
+// serviceCIDRs returns the service ranges in the comma separated form kubeadm expects
+func serviceCIDRs(cfg *config.Config) string {
+	if dualStack(cfg) {
+		return cfg.Kubernetes.ServiceCIDR + "," + cfg.Kubernetes.ServiceCIDRv6
+	}
+	return cfg.Kubernetes.ServiceCIDR
+}
+
+// minorVersion returns the minor version of a package version such as
+// 1.24.0-00, or 0 when it cannot be parsed
+func minorVersion(version string) int {
+	parts := strings.Split(strings.TrimPrefix(version, "v"), ".")
+	if len(parts) < 2 {
+		return 0
+	}
+	minor, _ := strconv.Atoi(parts[1])
+	return minor
+}
+
+// dualStackFeatureGates returns the kubeadm feature gates dual-stack needs.
+// IPv6DualStack is enabled by default from 1.21 and GA from 1.23.
+func dualStackFeatureGates(cfg *config.Config) string {
+	if dualStack(cfg) && minorVersion(cfg.Kubernetes.Version) < 21 {
+		return "IPv6DualStack=true"
+	}
+	return ""
+}
+
+// enableDualStackCNI switches the manifest based network plugins to dual-stack.
+// Cilium gets its IPv6 settings through the chart values instead.
+func enableDualStackCNI(client ssh.Executor, cfg *config.Config) error {
+	switch cniFor(cfg).name {
+	case CNIFlannel:
+		netConf, err := json.Marshal(map[string]interface{}{
+			"Network":     cfg.Kubernetes.PodCIDR,
+			"IPv6Network": cfg.Kubernetes.PodCIDRv6,
+			"EnableIPv6":  true,
+			"Backend":     map[string]interface{}{"Type": "vxlan"},
+		})
+		if err != nil {
+			return err
+		}
+		if err := patchConfigMap(client, "kube-flannel", "kube-flannel-cfg", "net-conf.json", func(string) string {
+			return string(netConf)
+		}); err != nil {
+			return err
+		}
+		return restartDaemonSet(client, "kube-flannel", "kube-flannel-ds")
+	case CNICalico:
+		if err := patchConfigMap(client, "kube-system", "calico-config", "cni_network_config", func(conf string) string {
+			return strings.Replace(conf, `"type": "calico-ipam"`, `"type": "calico-ipam", "assign_ipv4": "true", "assign_ipv6": "true"`, 1)
+		}); err != nil {
+			return err
+		}
+		cmd := fmt.Sprintf("kubectl -n kube-system set env daemonset/calico-node IP6=autodetect FELIX_IPV6SUPPORT=true CALICO_IPV6POOL_CIDR=%s CALICO_IPV6POOL_NAT_OUTGOING=true",
+			cfg.Kubernetes.PodCIDRv6)
+		if output, err := client.ExecuteCommand(cmd); err != nil {
+			return fmt.Errorf("failed to execute command '%s': %v\nOutput: %s", cmd, err, output)
+		}
+	}
+	return nil
+}
+
+// patchConfigMap rewrites one key of a config map
+func patchConfigMap(client ssh.Executor, namespace, name, key string, edit func(string) string) error {
+	output, err := ssh.Complete(client.ExecuteCommand(fmt.Sprintf("kubectl -n %s get configmap %s -o json", namespace, name)))
+	if err != nil {
+		return fmt.Errorf("failed to read config map %s/%s: %v", namespace, name, err)
+	}
+
+	var configMap map[string]interface{}
+	if err := json.Unmarshal([]byte(output), &configMap); err != nil {
+		return fmt.Errorf("failed to parse config map %s/%s: %v", namespace, name, err)
+	}
+
+	// Keep the other keys, apply would prune them otherwise
+	data, _ := configMap["data"].(map[string]interface{})
+	if data == nil {
+		data = map[string]interface{}{}
+	}
+	value, _ := data[key].(string)
+	data[key] = edit(value)
+
+	return Apply(client, name, []map[string]interface{}{
+		{
+			"apiVersion": "v1",
+			"kind":       "ConfigMap",
+			"metadata":   map[string]interface{}{"name": name, "namespace": namespace},
+			"data":       data,
+		},
+	})
+}
+
+// restartDaemonSet restarts the pods of a daemon set and waits for them
+func restartDaemonSet(client ssh.Executor, namespace, name string) error {
+	commands := []string{
+		fmt.Sprintf("kubectl -n %s rollout restart daemonset/%s", namespace, name),
+		fmt.Sprintf("kubectl -n %s rollout status daemonset/%s --timeout=300s", namespace, name),
+	}
+
+	for _, cmd := range commands {
+		if output, err := client.ExecuteCommand(cmd); err != nil {
+			return fmt.Errorf("failed to execute command '%s': %v\nOutput: %s", cmd, err, output)
+		}
+	}
+	return nil
+}
+
+// verifyDualStack checks that the node got pod ranges of both families and
+// that a dual-stack service is assigned an IPv4 and an IPv6 cluster IP
+func verifyDualStack(client ssh.Executor) error {
+	output, err := client.ExecuteCommand("kubectl get nodes -o jsonpath='{.items[0].spec.podCIDRs[*]}'")
+	if err != nil {
+		return fmt.Errorf("failed to read node pod CIDRs: %v", err)
+	}
+	if ranges := strings.Fields(output); len(ranges) != 2 {
+		return fmt.Errorf("expected an IPv4 and an IPv6 pod CIDR on the node, found %q", strings.TrimSpace(output))
+	}
+
+	err = Apply(client, "dual-stack-check", []map[string]interface{}{
+		{
+			"apiVersion": "v1",
+			"kind":       "Service",
+			"metadata":   map[string]interface{}{"name": "dual-stack-check", "namespace": "default"},
+			"spec": map[string]interface{}{
+				"ipFamilyPolicy": "RequireDualStack",
+				"selector":       map[string]interface{}{"app": "dual-stack-check"},
+				"ports":          []map[string]interface{}{{"port": 80}},
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	defer client.ExecuteCommand("kubectl -n default delete service dual-stack-check")
+
+	output, err = client.ExecuteCommand("kubectl -n default get service dual-stack-check -o jsonpath='{.spec.clusterIPs[*]}'")
+	if err != nil {
+		return fmt.Errorf("failed to read service cluster IPs: %v", err)
+	}
+	if ips := strings.Fields(output); len(ips) != 2 {
+		return fmt.Errorf("expected an IPv4 and an IPv6 cluster IP, found %q", strings.TrimSpace(output))
+	}
+
+	return nil
+}
@@ -0,0 +1,135 @@
+package kubernetes
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// eolWarning is how long before its end of life a minor version is reported
+const eolWarning = 60 * 24 * time.Hour
+
+// endOfLife holds the dates upstream stops patching each minor version of
+// Kubernetes 1.x. Minor versions older than the first entry are past theirs.
+var endOfLife = map[int]string{
+	19: "2021-10-28",
+	20: "2022-02-28",
+	21: "2022-06-28",
+	22: "2022-10-28",
+	23: "2023-02-28",
+	24: "2023-07-28",
+	25: "2023-10-28",
+	26: "2024-02-28",
+	27: "2024-06-28",
+	28: "2024-10-28",
+	29: "2025-02-28",
+	30: "2025-06-28",
+	31: "2025-10-28",
+	32: "2026-02-28",
+	33: "2026-06-28",
+	34: "2026-10-27",
+	35: "2027-02-28",
+}
+
+// oldestKnown is the oldest minor version in endOfLife
+const oldestKnown = 19
+
+// Support is the support status of a Kubernetes version
+type Support struct {
+	Version string
+	// EndOfLife is zero when the date is not known
+	EndOfLife time.Time
+	Expired   bool
+}
+
+// CheckSupport returns the support status of version at now from the
+// embedded end-of-life dates, or from dates when given, e.g. from FetchEOL
+func CheckSupport(version string, now time.Time, dates map[int]time.Time) Support {
+	minor := minorVersion(version)
+	s := Support{Version: KubeadmVersion(version)}
+	if dates == nil {
+		dates = embeddedEOL()
+	}
+	if eol, ok := dates[minor]; ok {
+		s.EndOfLife = eol
+		s.Expired = !now.Before(eol)
+	} else if minor > 0 && minor < oldestKnown {
+		s.Expired = true
+	}
+	return s
+}
+
+// Warning returns the message to show for a version that is past or near
+// its end of life, or "" for a supported one
+func (s Support) Warning(now time.Time) string {
+	switch {
+	case s.Expired && s.EndOfLife.IsZero():
+		return fmt.Sprintf("Kubernetes %s is past its end of life and no longer receives security fixes", s.Version)
+	case s.Expired:
+		return fmt.Sprintf("Kubernetes %s reached its end of life on %s and no longer receives security fixes", s.Version, s.EndOfLife.Format("2006-01-02"))
+	case !s.EndOfLife.IsZero() && s.EndOfLife.Sub(now) < eolWarning:
+		return fmt.Sprintf("Kubernetes %s reaches its end of life on %s", s.Version, s.EndOfLife.Format("2006-01-02"))
+	}
+	return ""
+}
+
+// embeddedEOL parses endOfLife
+func embeddedEOL() map[int]time.Time {
+	dates := map[int]time.Time{}
+	for minor, date := range endOfLife {
+		if t, err := time.Parse("2006-01-02", date); err == nil {
+			dates[minor] = t
+		}
+	}
+	return dates
+}
+
+// FetchEOL reads the end-of-life dates from url, a feed in the format of
+// https://endoflife.date/api/kubernetes.json. The embedded dates fill in
+// the minor versions the feed lacks.
+func FetchEOL(url string) (map[int]time.Time, error) {
+	resp, err := (&http.Client{Timeout: 10 * time.Second}).Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch end-of-life data: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch end-of-life data: %s", resp.Status)
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch end-of-life data: %v", err)
+	}
+	return parseEOLFeed(data)
+}
+
+// parseEOLFeed parses the cycles of an endoflife.date feed over the
+// embedded dates. A cycle's eol is a date, or false while none is set.
+func parseEOLFeed(data []byte) (map[int]time.Time, error) {
+	var cycles []struct {
+		Cycle string          `json:"cycle"`
+		EOL   json.RawMessage `json:"eol"`
+	}
+	if err := json.Unmarshal(data, &cycles); err != nil {
+		return nil, fmt.Errorf("failed to parse end-of-life data: %v", err)
+	}
+
+	dates := embeddedEOL()
+	for _, c := range cycles {
+		var date string
+		if json.Unmarshal(c.EOL, &date) != nil {
+			continue
+		}
+		eol, err := time.Parse("2006-01-02", date)
+		if err != nil {
+			return nil, fmt.Errorf("invalid end of life %q of %s", date, c.Cycle)
+		}
+		if minor := minorVersion(strings.TrimSpace(c.Cycle)); minor > 0 {
+			dates[minor] = eol
+		}
+	}
+	return dates, nil
+}
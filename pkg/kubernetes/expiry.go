@@ -0,0 +1,54 @@
+package kubernetes
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/maarulav/k8s-setup/pkg/ssh"
+)
+
+// pkiDir holds the certificates kubeadm issues for the control plane
+const pkiDir = "/etc/kubernetes/pki"
+
+// Certificate is a certificate of the control plane and when it expires
+type Certificate struct {
+	Path     string
+	NotAfter time.Time
+}
+
+// CertificateExpiry returns the certificates of the control plane behind
+// client, the one to expire first first
+func CertificateExpiry(client ssh.Executor) ([]Certificate, error) {
+	output, err := ssh.Complete(client.ExecuteCommand(fmt.Sprintf("for f in %[1]s/*.crt %[1]s/etcd/*.crt; do [ -f \"$f\" ] && echo \"$f $(openssl x509 -enddate -noout -in \"$f\")\"; done; true", pkiDir)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the certificates: %v\nOutput: %s", err, output)
+	}
+	certs, err := parseEndDates(output)
+	if err != nil {
+		return nil, err
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("no certificates in %s", pkiDir)
+	}
+	return certs, nil
+}
+
+// parseEndDates parses lines of a path and the notAfter= output of openssl
+func parseEndDates(output string) ([]Certificate, error) {
+	var certs []Certificate
+	for _, line := range strings.Split(strings.TrimSpace(output), "\n") {
+		path, date, ok := strings.Cut(strings.TrimSpace(line), " notAfter=")
+		if !ok {
+			continue
+		}
+		notAfter, err := time.Parse("Jan _2 15:04:05 2006 MST", strings.TrimSpace(date))
+		if err != nil {
+			return nil, fmt.Errorf("invalid expiry of %s: %v", path, err)
+		}
+		certs = append(certs, Certificate{Path: path, NotAfter: notAfter})
+	}
+	sort.Slice(certs, func(i, j int) bool { return certs[i].NotAfter.Before(certs[j].NotAfter) })
+	return certs, nil
+}
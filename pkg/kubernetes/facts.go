@@ -0,0 +1,95 @@
+package kubernetes
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/maarulav/k8s-setup/pkg/ssh"
+)
+
+// Facts describe a host for audits and upgrade planning
+type Facts struct {
+	Collected time.Time
+	// OS is the pretty name of the distribution, OSID and OSVersion its ID
+	// and VERSION_ID in os-release
+	OS        string
+	OSID      string
+	OSVersion string
+	Kernel    string
+	Arch      string
+	CPUs      int
+	// MemoryBytes is the physical memory, DiskBytes and DiskFreeBytes the
+	// size and free space of the root file system
+	MemoryBytes   int64
+	DiskBytes     int64
+	DiskFreeBytes int64
+	// ContainerRuntime and Kubelet are the installed versions, empty when
+	// they are not installed
+	ContainerRuntime string
+	Kubelet          string
+}
+
+// factsCommand prints the facts of a host as key=value lines. The runtime
+// and the kubelet are missing before the Kubernetes step installs them.
+const factsCommand = `. /etc/os-release; echo "os=$PRETTY_NAME"; echo "osID=$ID"; echo "osVersion=$VERSION_ID"
+echo "kernel=$(uname -r)"; echo "machine=$(uname -m)"; echo "cpus=$(nproc)"
+awk '/^MemTotal:/ {print "memory=" $2}' /proc/meminfo
+df -Pk / | awk 'NR == 2 {print "disk=" $2; print "diskFree=" $4}'
+echo "containerd=$(containerd --version 2>/dev/null)"; echo "kubelet=$(kubelet --version 2>/dev/null)"`
+
+// CollectFacts returns the facts of the host behind client
+func CollectFacts(client ssh.Executor) (*Facts, error) {
+	output, err := ssh.Complete(client.ExecuteCommand(factsCommand))
+	if err != nil {
+		return nil, fmt.Errorf("failed to collect host facts: %v\nOutput: %s", err, output)
+	}
+	return parseFacts(output)
+}
+
+// parseFacts parses the output of factsCommand
+func parseFacts(output string) (*Facts, error) {
+	values := map[string]string{}
+	for _, line := range strings.Split(output, "\n") {
+		if key, value, ok := strings.Cut(strings.TrimSpace(line), "="); ok {
+			values[key] = strings.TrimSpace(value)
+		}
+	}
+
+	f := &Facts{
+		Collected: time.Now().UTC(),
+		OS:        values["os"],
+		OSID:      values["osID"],
+		OSVersion: values["osVersion"],
+		Kernel:    values["kernel"],
+		Arch:      values["machine"],
+	}
+	// Architectures without packages keep the name uname gave them
+	if arch, ok := archName(f.Arch); ok {
+		f.Arch = arch
+	}
+
+	var err error
+	if f.CPUs, err = strconv.Atoi(values["cpus"]); err != nil {
+		return nil, fmt.Errorf("unexpected CPU count %q", values["cpus"])
+	}
+	sizes := map[string]*int64{"memory": &f.MemoryBytes, "disk": &f.DiskBytes, "diskFree": &f.DiskFreeBytes}
+	for key, size := range sizes {
+		kb, err := strconv.ParseInt(values[key], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("unexpected %s size %q", key, values[key])
+		}
+		*size = kb << 10
+	}
+
+	// containerd containerd.io 1.7.22 7f7fdf5fed64eb6a7caf99b3e12efcf9d60e311c
+	if fields := strings.Fields(values["containerd"]); len(fields) >= 3 {
+		f.ContainerRuntime = "containerd " + strings.TrimPrefix(fields[2], "v")
+	}
+	// Kubernetes v1.30.4
+	if fields := strings.Fields(values["kubelet"]); len(fields) == 2 {
+		f.Kubelet = fields[1]
+	}
+	return f, nil
+}
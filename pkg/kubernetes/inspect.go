@@ -0,0 +1,105 @@
+package kubernetes
+
+import (
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/maarulav/k8s-setup/pkg/config"
+	"github.com/maarulav/k8s-setup/pkg/ssh"
+)
+
+// Inspect fills the Kubernetes section of cfg from the running cluster
+func Inspect(client *ssh.Client, cfg *config.Config) error {
+	version, err := ssh.Complete(client.ExecuteCommand("dpkg-query -W -f='${Version}' kubelet"))
+	if err != nil {
+		return fmt.Errorf("failed to detect kubelet version: %v", err)
+	}
+	cfg.Kubernetes.Version = strings.TrimSpace(version)
+
+	clusterConfig, err := ssh.Complete(client.ExecuteCommand("kubectl -n kube-system get configmap kubeadm-config -o jsonpath='{.data.ClusterConfiguration}'"))
+	if err != nil {
+		return fmt.Errorf("failed to read kubeadm configuration: %v", err)
+	}
+	cfg.Kubernetes.PodCIDR, cfg.Kubernetes.PodCIDRv6 = splitCIDRs(yamlValue(clusterConfig, "podSubnet"))
+	cfg.Kubernetes.ServiceCIDR, cfg.Kubernetes.ServiceCIDRv6 = splitCIDRs(yamlValue(clusterConfig, "serviceSubnet"))
+	if domain := yamlValue(clusterConfig, "dnsDomain"); domain != "cluster.local" {
+		cfg.Kubernetes.ClusterDomain = domain
+	}
+	cfg.Kubernetes.ServiceNodePortRange = apiServerArg(clusterConfig, "service-node-port-range")
+
+	daemonSets, err := ssh.Complete(client.ExecuteCommand("kubectl get daemonsets -A -o jsonpath='{range .items[*]}{.metadata.name}{\"\\n\"}{end}'"))
+	if err != nil {
+		return fmt.Errorf("failed to list daemonsets: %v", err)
+	}
+	for _, name := range strings.Fields(daemonSets) {
+		for _, c := range cnis {
+			if name == c.daemonSet {
+				cfg.Kubernetes.CNI = c.name
+			}
+		}
+	}
+
+	return nil
+}
+
+// splitCIDRs splits a dual-stack "v4,v6" range list into its families
+func splitCIDRs(ranges string) (string, string) {
+	var v4, v6 string
+	for _, cidr := range strings.Split(ranges, ",") {
+		cidr = strings.TrimSpace(cidr)
+		if strings.Contains(cidr, ":") {
+			v6 = cidr
+		} else {
+			v4 = cidr
+		}
+	}
+	return v4, v6
+}
+
+// yamlValue returns the scalar value of the first "key: value" line in a YAML document
+func yamlValue(doc, key string) string {
+	for _, line := range strings.Split(doc, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, key+":") {
+			return strings.Trim(strings.TrimSpace(strings.TrimPrefix(line, key+":")), `"'`)
+		}
+	}
+	return ""
+}
+
+// apiServerArg returns the API server flag name of a kubeadm
+// ClusterConfiguration, whose extraArgs are a map up to v1beta3 and a list
+// of name/value pairs since v1beta4
+func apiServerArg(clusterConfig, name string) string {
+	var doc struct {
+		APIServer struct {
+			ExtraArgs yaml.Node `yaml:"extraArgs"`
+		} `yaml:"apiServer"`
+	}
+	if err := yaml.Unmarshal([]byte(clusterConfig), &doc); err != nil {
+		return ""
+	}
+
+	switch args := doc.APIServer.ExtraArgs; args.Kind {
+	case yaml.MappingNode:
+		var m map[string]string
+		if err := args.Decode(&m); err == nil {
+			return m[name]
+		}
+	case yaml.SequenceNode:
+		var list []struct {
+			Name  string `yaml:"name"`
+			Value string `yaml:"value"`
+		}
+		if err := args.Decode(&list); err == nil {
+			for _, arg := range list {
+				if arg.Name == name {
+					return arg.Value
+				}
+			}
+		}
+	}
+	return ""
+}
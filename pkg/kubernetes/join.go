@@ -0,0 +1,54 @@
+package kubernetes
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/maarulav/k8s-setup/pkg/ssh"
+)
+
+const (
+	// JoinTokenTTL is the lifetime of the bootstrap tokens created for joins
+	JoinTokenTTL = 24 * time.Hour
+
+	// CertificateKeyTTL is how long kubeadm keeps the uploaded control plane
+	// certificates, fixed by kubeadm
+	CertificateKeyTTL = 2 * time.Hour
+)
+
+// CreateJoinCommand creates a bootstrap token and returns the worker join command
+func CreateJoinCommand(client ssh.Executor) (string, time.Time, error) {
+	expires := time.Now().Add(JoinTokenTTL)
+	output, err := client.ExecuteCommand(fmt.Sprintf("kubeadm token create --ttl %s --print-join-command", JoinTokenTTL))
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to create join token: %v\nOutput: %s", err, output)
+	}
+
+	command := lastLine(output)
+	if !strings.HasPrefix(command, "kubeadm join ") {
+		return "", time.Time{}, fmt.Errorf("unexpected kubeadm token output: %s", output)
+	}
+	return command, expires, nil
+}
+
+// UploadCertificates re-uploads the control plane certificates and returns
+// the key that decrypts them
+func UploadCertificates(client ssh.Executor) (string, time.Time, error) {
+	expires := time.Now().Add(CertificateKeyTTL)
+	output, err := client.ExecuteCommand("kubeadm init phase upload-certs --upload-certs")
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to upload certificates: %v\nOutput: %s", err, output)
+	}
+
+	key := lastLine(output)
+	if len(key) != 64 {
+		return "", time.Time{}, fmt.Errorf("unexpected kubeadm upload-certs output: %s", output)
+	}
+	return key, expires, nil
+}
+
+func lastLine(output string) string {
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	return strings.TrimSpace(lines[len(lines)-1])
+}
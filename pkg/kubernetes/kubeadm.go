@@ -0,0 +1,131 @@
+package kubernetes
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/maarulav/k8s-setup/pkg/config"
+)
+
+// KubeadmConfig is where the rendered kubeadm configuration is uploaded
+const KubeadmConfig = "/root/kubeadm-config.yaml"
+
+// etcdMetricsURL is where etcd serves its metrics. kubeadm binds them to
+// 127.0.0.1:2381, out of reach of the Prometheus of the monitoring step.
+const etcdMetricsURL = "http://0.0.0.0:2381"
+
+// kubeadmAPIVersion returns the kubeadm configuration API supported by the
+// configured Kubernetes version
+func kubeadmAPIVersion(version string) string {
+	switch minor := minorVersion(version); {
+	case minor > 0 && minor < 22:
+		return "kubeadm.k8s.io/v1beta2"
+	case minor >= 31:
+		return "kubeadm.k8s.io/v1beta4"
+	default:
+		return "kubeadm.k8s.io/v1beta3"
+	}
+}
+
+// renderKubeadmConfig renders the ClusterConfiguration passed to kubeadm init
+// on the control plane at ip. JSON is valid YAML, so it is written as JSON
+// like the other manifests.
+func renderKubeadmConfig(cfg *config.Config, ip string) ([]byte, error) {
+	apiVersion := kubeadmAPIVersion(cfg.Kubernetes.Version)
+
+	networking := map[string]interface{}{
+		"podSubnet":     podCIDRs(cfg),
+		"serviceSubnet": serviceCIDRs(cfg),
+	}
+	if cfg.Kubernetes.ClusterDomain != "" {
+		networking["dnsDomain"] = cfg.Kubernetes.ClusterDomain
+	}
+
+	clusterConfig := map[string]interface{}{
+		"apiVersion": apiVersion,
+		"kind":       "ClusterConfiguration",
+		"networking": networking,
+	}
+	if name := cfg.ClusterName(ip); name != ip {
+		clusterConfig["clusterName"] = name
+	}
+
+	clusterConfig["etcd"] = map[string]interface{}{
+		"local": map[string]interface{}{
+			"extraArgs": extraArgs(apiVersion, map[string]string{"listen-metrics-urls": etcdMetricsURL}),
+		},
+	}
+
+	if gates := dualStackFeatureGates(cfg); gates != "" {
+		clusterConfig["featureGates"] = map[string]bool{"IPv6DualStack": true}
+	}
+
+	apiServerArgs := map[string]string{}
+	if cfg.Kubernetes.ServiceNodePortRange != "" {
+		apiServerArgs["service-node-port-range"] = cfg.Kubernetes.ServiceNodePortRange
+	}
+	apiServer := map[string]interface{}{}
+	if len(apiServerArgs) > 0 {
+		apiServer["extraArgs"] = extraArgs(apiVersion, apiServerArgs)
+	}
+	if len(cfg.Kubernetes.CertSANs) > 0 {
+		apiServer["certSANs"] = cfg.Kubernetes.CertSANs
+	}
+	if len(apiServer) > 0 {
+		clusterConfig["apiServer"] = apiServer
+	}
+
+	data, err := json.MarshalIndent(clusterConfig, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to render kubeadm configuration: %v", err)
+	}
+
+	kubelet, err := kubeletConfiguration(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if kubelet != nil {
+		kubeletConfig, err := json.MarshalIndent(kubelet, "", "  ")
+		if err != nil {
+			return nil, fmt.Errorf("failed to render kubeadm configuration: %v", err)
+		}
+		data = append(append(data, "\n---\n"...), kubeletConfig...)
+	}
+
+	if address := cfg.Kubernetes.AdvertiseAddress; address != "" {
+		initConfig, err := json.MarshalIndent(map[string]interface{}{
+			"apiVersion":       apiVersion,
+			"kind":             "InitConfiguration",
+			"localAPIEndpoint": map[string]string{"advertiseAddress": address},
+			"nodeRegistration": map[string]interface{}{
+				"kubeletExtraArgs": extraArgs(apiVersion, map[string]string{"node-ip": address}),
+			},
+		}, "", "  ")
+		if err != nil {
+			return nil, fmt.Errorf("failed to render kubeadm configuration: %v", err)
+		}
+		data = append(append(initConfig, "\n---\n"...), data...)
+	}
+	return data, nil
+}
+
+// extraArgs renders component flags, which v1beta4 turned from a map into a
+// list of name/value pairs
+func extraArgs(apiVersion string, args map[string]string) interface{} {
+	if apiVersion != "kubeadm.k8s.io/v1beta4" {
+		return args
+	}
+
+	names := make([]string, 0, len(args))
+	for name := range args {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var list []map[string]string
+	for _, name := range names {
+		list = append(list, map[string]string{"name": name, "value": args[name]})
+	}
+	return list
+}
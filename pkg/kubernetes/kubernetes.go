@@ -8,8 +8,114 @@ import (
 	"github.com/maarulav/k8s-setup/pkg/ssh"
 )
 
-// Setup sets up Kubernetes on the remote server
-func Setup(client *ssh.Client, config *config.Config) error {
+// KubeadmLog is where the output of kubeadm init is kept on the control plane
+const KubeadmLog = "/root/kubeadm-init.log"
+
+// commandDelay is the pause between the bootstrap commands
+var commandDelay = 2 * time.Second
+
+// Setup sets up Kubernetes on the remote server. A non-nil cache skips the
+// node preparation, the image pre-pull and kubeadm init where they already
+// succeeded on this host with the same inputs, and is updated with the steps
+// that ran.
+func Setup(client ssh.Executor, config *config.Config, cache StepCache) error {
+	kubeadmConfig, err := renderKubeadmConfig(config, client.Host())
+	if err != nil {
+		return err
+	}
+	if err := client.WriteFile(KubeadmConfig, kubeadmConfig, 0600); err != nil {
+		return err
+	}
+
+	initFlags := "--config=" + KubeadmConfig
+	if kubeProxyReplacement(config) {
+		initFlags += " --skip-phases=addon/kube-proxy"
+	}
+
+	var fingerprint string
+	if cache != nil {
+		if fingerprint, err = Fingerprint(client); err != nil {
+			fmt.Printf("Warning: not caching steps: %v\n", err)
+			cache = nil
+		}
+	}
+	version := config.Kubernetes.Version
+
+	if !config.Kubernetes.Prebaked {
+		key := cacheKey(fingerprint, version, config.TrustedCAs, config.RegistryMirrors, config.Kubernetes.CgroupDriver)
+		if err := cache.run(StepPackages, key, func() error { return PrepareNode(client, config) }); err != nil {
+			return err
+		}
+	}
+
+	// Pull the control plane images up front, so a registry problem fails
+	// here rather than in the middle of kubeadm init
+	key := cacheKey(fingerprint, version, string(kubeadmConfig), config.RegistryMirrors)
+	if err := cache.run(StepImages, key, func() error {
+		return runCommands(client, []string{"kubeadm config images pull --config=" + KubeadmConfig})
+	}); err != nil {
+		return err
+	}
+
+	commands := []string{
+		// Initialize Kubernetes cluster, keeping the output for diagnostics
+		fmt.Sprintf("kubeadm init %s > %s 2>&1; rc=$?; cat %s; exit $rc",
+			initFlags, KubeadmLog, KubeadmLog),
+
+		// Setup kubectl for root user
+		"mkdir -p $HOME/.kube && cp -i /etc/kubernetes/admin.conf $HOME/.kube/config && chown $(id -u):$(id -g) $HOME/.kube/config",
+	}
+
+	key = cacheKey(fingerprint, version, string(kubeadmConfig), initFlags)
+	if err := cache.run(StepInit, key, func() error { return runCommands(client, commands) }); err != nil {
+		return err
+	}
+
+	if err := installCNI(client, config); err != nil {
+		return fmt.Errorf("failed to install CNI: %v", err)
+	}
+
+	if dualStack(config) {
+		if err := enableDualStackCNI(client, config); err != nil {
+			return fmt.Errorf("failed to enable dual-stack networking: %v", err)
+		}
+	}
+
+	if kubeProxyReplacement(config) {
+		if err := verifyServiceRouting(client); err != nil {
+			return fmt.Errorf("service routing check failed: %v", err)
+		}
+	}
+
+	if err := tuneSystemComponents(client, config); err != nil {
+		return fmt.Errorf("failed to tune system components: %v", err)
+	}
+
+	if config.PriorityClasses {
+		if err := setupPriorityClasses(client); err != nil {
+			return fmt.Errorf("failed to create priority classes: %v", err)
+		}
+	}
+
+	// Clusters without a configured name are known by the IP, which is no
+	// label value for IPv6
+	if name := config.ClusterName(client.Host()); name != client.Host() {
+		if err := LabelCluster(client, client, name); err != nil {
+			return fmt.Errorf("failed to label the node with the cluster name: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// PrepareNode installs the container runtime and the Kubernetes packages,
+// everything a node needs before kubeadm init or join
+func PrepareNode(client ssh.Executor, config *config.Config) error {
+	if err := checkCgroups(client, config); err != nil {
+		return err
+	}
+	driver, _ := cgroupDriver(config)
+
 	commands := []string{
 		// Update system
 		"apt-get update && apt-get upgrade -y",
@@ -17,28 +123,42 @@ func Setup(client *ssh.Client, config *config.Config) error {
 		// Install required packages
 		"apt-get install -y apt-transport-https ca-certificates curl software-properties-common",
 
-		// Add Docker repository
+		// Add Docker repository for the architecture of the host
 		"curl -fsSL https://download.docker.com/linux/ubuntu/gpg | apt-key add -",
-		"add-apt-repository \"deb [arch=amd64] https://download.docker.com/linux/ubuntu $(lsb_release -cs) stable\"",
+		"add-apt-repository \"deb [arch=$(dpkg --print-architecture)] https://download.docker.com/linux/ubuntu $(lsb_release -cs) stable\"",
 
 		// Install Docker
 		"apt-get update && apt-get install -y docker-ce docker-ce-cli containerd.io",
 
 		// Configure Docker
 		"mkdir -p /etc/docker",
-		`cat > /etc/docker/daemon.json << EOF
+		fmt.Sprintf(`cat > /etc/docker/daemon.json << EOF
 {
-  "exec-opts": ["native.cgroupdriver=systemd"],
+  "exec-opts": ["native.cgroupdriver=%s"],
   "log-driver": "json-file",
   "log-opts": {
     "max-size": "100m"
   },
   "storage-driver": "overlay2"
 }
-EOF`,
+EOF`, driver),
 		"systemctl daemon-reload",
 		"systemctl restart docker",
+	}
+	commands = append(commands, runtimeCommands(config)...)
+	if err := runCommands(client, commands); err != nil {
+		return err
+	}
+
+	if err := InstallTrustedCAs(client, config); err != nil {
+		return fmt.Errorf("failed to install trusted CAs: %v", err)
+	}
+
+	if err := ConfigureRegistryMirrors(client, config); err != nil {
+		return fmt.Errorf("failed to configure registry mirrors: %v", err)
+	}
 
+	commands = []string{
 		// Add Kubernetes repository
 		"curl -s https://packages.cloud.google.com/apt/doc/apt-key.gpg | apt-key add -",
 		"echo \"deb https://apt.kubernetes.io/ kubernetes-xenial main\" > /etc/apt/sources.list.d/kubernetes.list",
@@ -48,32 +168,43 @@ EOF`,
 			config.Kubernetes.Version,
 			config.Kubernetes.Version,
 			config.Kubernetes.Version),
+	}
 
-		// Initialize Kubernetes cluster
-		fmt.Sprintf("kubeadm init --pod-network-cidr=%s --service-cidr=%s",
-			config.Kubernetes.PodCIDR,
-			config.Kubernetes.ServiceCIDR),
-
-		// Setup kubectl for root user
-		"mkdir -p $HOME/.kube && cp -i /etc/kubernetes/admin.conf $HOME/.kube/config && chown $(id -u):$(id -g) $HOME/.kube/config",
+	return runCommands(client, commands)
+}
 
-		// Install Calico network plugin
-		"kubectl apply -f https://docs.projectcalico.org/manifests/calico.yaml",
-	}
+// scripted executors record the commands into a script instead of running them
+type scripted interface {
+	Scripted() bool
+}
 
+// runCommands runs the bootstrap commands in order, pausing between them
+// unless they are only scripted
+func runCommands(client ssh.Executor, commands []string) error {
+	_, isScript := client.(scripted)
 	for _, cmd := range commands {
 		output, err := client.ExecuteCommand(cmd)
 		if err != nil {
 			return fmt.Errorf("failed to execute command '%s': %v\nOutput: %s", cmd, err, output)
 		}
-		time.Sleep(2 * time.Second)
+		if !isScript {
+			time.Sleep(commandDelay)
+		}
 	}
-
 	return nil
 }
 
+// FetchKubeconfig returns the admin kubeconfig generated by kubeadm
+func FetchKubeconfig(client ssh.Executor) ([]byte, error) {
+	output, err := ssh.Complete(client.ExecuteCommand("cat /etc/kubernetes/admin.conf"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read admin kubeconfig: %v", err)
+	}
+	return []byte(output), nil
+}
+
 // Verify verifies the Kubernetes setup
-func Verify(client *ssh.Client) error {
+func Verify(client ssh.Executor, cfg *config.Config) error {
 	commands := []string{
 		"kubectl get nodes",
 		"kubectl get pods -A",
@@ -89,5 +220,11 @@ func Verify(client *ssh.Client) error {
 		fmt.Printf("Verification output for %s:\n%s", cmd, output)
 	}
 
+	if dualStack(cfg) {
+		if err := verifyDualStack(client); err != nil {
+			return fmt.Errorf("dual-stack verification failed: %v", err)
+		}
+	}
+
 	return nil
 }
@@ -0,0 +1,813 @@
+package kubernetes
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/maarulav/k8s-setup/internal/testutil"
+	"github.com/maarulav/k8s-setup/pkg/config"
+	"github.com/maarulav/k8s-setup/pkg/ssh"
+)
+
+func testConfig() *config.Config {
+	cfg := &config.Config{}
+	cfg.Kubernetes.Version = "1.28.2-00"
+	cfg.Kubernetes.PodCIDR = "10.244.0.0/16"
+	cfg.Kubernetes.ServiceCIDR = "10.96.0.0/12"
+	return cfg
+}
+
+func init() {
+	commandDelay = 0
+}
+
+func TestSetupPlan(t *testing.T) {
+	cfg := testConfig()
+	cfg.PriorityClasses = true
+	cfg.Components.CoreDNS = config.ResourceRequirements{
+		Requests: map[string]string{"cpu": "100m", "memory": "70Mi"},
+	}
+
+	fake := testutil.NewExecutor("10.0.0.1")
+	if err := Setup(fake, cfg, nil); err != nil {
+		t.Fatal(err)
+	}
+	testutil.AssertGolden(t, "setup", fake.Plan())
+}
+
+func TestSetupPlanDualStackFlannel(t *testing.T) {
+	cfg := testConfig()
+	cfg.Kubernetes.Version = "1.20.5-00"
+	cfg.Kubernetes.CNI = CNIFlannel
+	cfg.Kubernetes.PodCIDRv6 = "fd00:10:244::/56"
+	cfg.Kubernetes.ServiceCIDRv6 = "fd00:10:96::/112"
+	cfg.Kubernetes.ClusterDomain = "edge.local"
+	cfg.Kubernetes.ServiceNodePortRange = "20000-40000"
+
+	fake := testutil.NewExecutor("10.0.0.1").
+		Respond("get configmap kube-flannel-cfg", `{"data":{"cni-conf.json":"{}","net-conf.json":"{}"}}`)
+	if err := Setup(fake, cfg, nil); err != nil {
+		t.Fatal(err)
+	}
+	testutil.AssertGolden(t, "setup-dual-stack-flannel", fake.Plan())
+}
+
+func TestSetupStopsAtFirstFailure(t *testing.T) {
+	fake := testutil.NewExecutor("10.0.0.1").Fail("kubeadm init", "port 6443 is in use")
+
+	err := Setup(fake, testConfig(), nil)
+	if err == nil {
+		t.Fatal("expected kubeadm init to fail")
+	}
+	if !strings.Contains(err.Error(), "port 6443 is in use") {
+		t.Errorf("error does not carry the command output: %v", err)
+	}
+
+	commands := fake.Commands()
+	if last := commands[len(commands)-1]; !strings.Contains(last, "kubeadm init") {
+		t.Errorf("commands kept running after the failure, last was %q", last)
+	}
+}
+
+func TestSetupCache(t *testing.T) {
+	cfg := testConfig()
+	cache := StepCache{}
+	run := func(machineID string) string {
+		fake := testutil.NewExecutor("10.0.0.1").Respond("cat /etc/machine-id", machineID)
+		if err := Setup(fake, cfg, cache); err != nil {
+			t.Fatal(err)
+		}
+		return strings.Join(fake.Commands(), "\n")
+	}
+
+	if commands := run("m1"); !strings.Contains(commands, "kubeadm init") {
+		t.Fatal("first run skipped kubeadm init")
+	}
+	commands := run("m1")
+	for _, skipped := range []string{"apt-get install", "images pull", "kubeadm init"} {
+		if strings.Contains(commands, skipped) {
+			t.Errorf("unchanged re-run ran %q", skipped)
+		}
+	}
+
+	cfg.Kubernetes.ServiceNodePortRange = "20000-40000"
+	commands = run("m1")
+	if strings.Contains(commands, "apt-get install") || !strings.Contains(commands, "kubeadm init") {
+		t.Errorf("kubeadm change re-ran the wrong steps:\n%s", commands)
+	}
+
+	if commands := run("m2"); !strings.Contains(commands, "apt-get install") {
+		t.Error("new host skipped the node preparation")
+	}
+}
+
+func TestDetectArch(t *testing.T) {
+	for output, want := range map[string]string{"x86_64\n": ArchAMD64, "aarch64\n": ArchARM64} {
+		arch, err := DetectArch(testutil.NewExecutor("10.0.0.1").Respond("uname -m", output))
+		if err != nil || arch != want {
+			t.Errorf("DetectArch(%q) = %q, %v, want %q", output, arch, err, want)
+		}
+	}
+
+	if _, err := DetectArch(testutil.NewExecutor("10.0.0.1").Respond("uname -m", "riscv64\n")); err == nil {
+		t.Error("expected riscv64 to be refused")
+	}
+}
+
+func TestKubeadmConfigVersions(t *testing.T) {
+	for _, version := range []string{"1.20.5-00", "1.28.2-00", "1.31.1-1.1"} {
+		cfg := testConfig()
+		cfg.Kubernetes.Version = version
+		cfg.Kubernetes.ServiceNodePortRange = "20000-40000"
+
+		data, err := renderKubeadmConfig(cfg, "10.0.0.1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		testutil.AssertGolden(t, "kubeadm-config-"+version, string(data)+"\n")
+	}
+}
+
+func TestKubeadmConfigAdvertiseAddress(t *testing.T) {
+	cfg := testConfig()
+	cfg.Kubernetes.Version = "1.31.1-1.1"
+	cfg.Kubernetes.AdvertiseAddress = "192.168.56.10"
+
+	data, err := renderKubeadmConfig(cfg, "10.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	testutil.AssertGolden(t, "kubeadm-config-advertise", string(data)+"\n")
+}
+
+func TestSetupNamespacesPlan(t *testing.T) {
+	cfg := testConfig()
+	cfg.Resources.CPU = "8"
+	cfg.Resources.Memory = "16Gi"
+	cfg.Namespaces = []config.Namespace{
+		{Name: "team-a"},
+		{Name: "team-b", CPU: "2", Memory: "4Gi", Pods: 20},
+	}
+
+	fake := testutil.NewExecutor("10.0.0.1")
+	if err := SetupNamespaces(fake, cfg); err != nil {
+		t.Fatal(err)
+	}
+	testutil.AssertGolden(t, "namespaces", fake.Plan())
+}
+
+func TestRegistryMirrorsPlan(t *testing.T) {
+	caFile := filepath.Join(t.TempDir(), "lab-ca.pem")
+	if err := ioutil.WriteFile(caFile, []byte("-----BEGIN CERTIFICATE-----\nlab\n-----END CERTIFICATE-----\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := testConfig()
+	cfg.RegistryMirrors = []config.RegistryMirror{
+		{Registry: "docker.io", Endpoints: []string{"mirror.lab:5000"}, Insecure: true},
+		{Registry: "registry.lab:5000", Insecure: true},
+		{Registry: "harbor.lab", CAFile: caFile},
+	}
+
+	fake := testutil.NewExecutor("10.0.0.1")
+	if err := ConfigureRegistryMirrors(fake, cfg); err != nil {
+		t.Fatal(err)
+	}
+	testutil.AssertGolden(t, "registry-mirrors", fake.Plan())
+}
+
+func TestTrustedCAsPlan(t *testing.T) {
+	caFile := filepath.Join(t.TempDir(), "corp-root.pem")
+	if err := ioutil.WriteFile(caFile, []byte("-----BEGIN CERTIFICATE-----\ncorp\n-----END CERTIFICATE-----\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := testConfig()
+	cfg.TrustedCAs = []string{caFile}
+
+	fake := testutil.NewExecutor("10.0.0.1").Fail("command -v update-ca-certificates", "")
+	if err := InstallTrustedCAs(fake, cfg); err != nil {
+		t.Fatal(err)
+	}
+	testutil.AssertGolden(t, "trusted-cas-rhel", fake.Plan())
+}
+
+func TestUpgradeWorkerPlan(t *testing.T) {
+	controlPlane := testutil.NewExecutor("10.0.0.1")
+	worker := testutil.NewExecutor("10.0.0.2")
+	if err := UpgradeWorker(controlPlane, worker, "worker-1", testConfig()); err != nil {
+		t.Fatal(err)
+	}
+	testutil.AssertGolden(t, "upgrade-worker", "# control plane\n"+controlPlane.Plan()+"# worker\n"+worker.Plan())
+}
+
+func TestUpgradeWorkerKeepsFailedNodeCordoned(t *testing.T) {
+	controlPlane := testutil.NewExecutor("10.0.0.1")
+	worker := testutil.NewExecutor("10.0.0.2").Fail("kubeadm upgrade node", "unable to fetch kubeadm-config")
+
+	err := UpgradeWorker(controlPlane, worker, "worker-1", testConfig())
+	if err == nil || !strings.Contains(err.Error(), "stays cordoned") {
+		t.Fatalf("expected the upgrade to fail, got %v", err)
+	}
+	for _, cmd := range controlPlane.Commands() {
+		if strings.Contains(cmd, "uncordon") {
+			t.Errorf("failed node was uncordoned")
+		}
+	}
+}
+
+func TestSplitCIDRs(t *testing.T) {
+	v4, v6 := splitCIDRs("10.244.0.0/16,fd00:10:244::/56")
+	if v4 != "10.244.0.0/16" || v6 != "fd00:10:244::/56" {
+		t.Errorf("got %q, %q", v4, v6)
+	}
+}
+
+func TestSetupNamespacesOverSSH(t *testing.T) {
+	server := testutil.NewSSHServer(t).Fail("kubectl apply", "namespaces is forbidden")
+	client, err := ssh.Connect(server.VMConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	cfg := testConfig()
+	cfg.Namespaces = []config.Namespace{{Name: "team-a", CPU: "1", Memory: "1Gi"}}
+
+	err = SetupNamespaces(client, cfg)
+	if err == nil || !strings.Contains(err.Error(), "namespaces is forbidden") {
+		t.Fatalf("expected the apply failure with its output, got %v", err)
+	}
+	if commands := server.Commands(); len(commands) != 3 {
+		t.Errorf("expected mkdir, upload and apply, got %v", commands)
+	}
+}
+
+func TestWindowsWorkerPlan(t *testing.T) {
+	cfg := testConfig()
+	cfg.Kubernetes.CNI = CNIFlannel
+
+	controlPlane := testutil.NewExecutor("10.0.0.1")
+	if err := SetupWindowsNetworking(controlPlane, cfg); err != nil {
+		t.Fatal(err)
+	}
+	node := testutil.NewExecutor("10.0.0.3").Respond("Install-WindowsFeature", "No")
+	if err := PrepareWindowsNode(node, cfg); err != nil {
+		t.Fatal(err)
+	}
+	testutil.AssertGolden(t, "windows-worker", "# control plane\n"+controlPlane.Plan()+"# node\n"+node.Plan())
+
+	cfg.Kubernetes.CNI = CNICalico
+	if err := SetupWindowsNetworking(controlPlane, cfg); err == nil {
+		t.Error("expected Calico to be refused for Windows workers")
+	}
+
+	restart := testutil.NewExecutor("10.0.0.3").Respond("Install-WindowsFeature", "Yes\r\n")
+	if err := PrepareWindowsNode(restart, cfg); err == nil || !strings.Contains(err.Error(), "restart") {
+		t.Errorf("expected a restart request, got %v", err)
+	}
+}
+
+func TestSetupRolesPlan(t *testing.T) {
+	cfg := testConfig()
+	cfg.Roles = map[string]config.Role{
+		"gpu": {
+			Packages: []string{"nvidia-driver-535", "nvidia-container-toolkit"},
+			Commands: []string{"nvidia-ctk runtime configure --runtime=containerd", "systemctl restart containerd"},
+			Labels:   map[string]string{"accelerator": "nvidia"},
+			Taints:   []string{"nvidia.com/gpu=present:NoSchedule"},
+		},
+		"storage": {
+			Packages: []string{"open-iscsi"},
+		},
+	}
+
+	controlPlane := testutil.NewExecutor("10.0.0.1")
+	node := testutil.NewExecutor("10.0.0.2").Respond("hostname", "GPU-1\n")
+	if err := SetupRoles(controlPlane, node, cfg, []string{"gpu", "storage"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := TaintRoles(controlPlane, node, cfg, []string{"gpu", "storage"}); err != nil {
+		t.Fatal(err)
+	}
+	testutil.AssertGolden(t, "roles", "# control plane\n"+controlPlane.Plan()+"# node\n"+node.Plan())
+}
+
+func TestSetupRolesTemplate(t *testing.T) {
+	cfg := testConfig()
+	cfg.Vars = map[string]string{"zone": "eu-1a"}
+	cfg.Hosts = []config.Host{{IP: "10.0.0.2", Vars: map[string]string{"zone": "eu-1b"}}}
+	cfg.Roles = map[string]config.Role{
+		"storage": {Commands: []string{"echo {{ .zone }} > /etc/zone"}, Template: true},
+		"plain":   {Commands: []string{"echo '{{ .zone }}'"}},
+	}
+
+	node := testutil.NewExecutor("10.0.0.2").Respond("hostname", "store-1\n")
+	if err := SetupRoles(testutil.NewExecutor("10.0.0.1"), node, cfg, []string{"storage", "plain"}); err != nil {
+		t.Fatal(err)
+	}
+	commands := node.Commands()
+	if commands[0] != "echo eu-1b > /etc/zone" || commands[1] != "echo '{{ .zone }}'" {
+		t.Errorf("role commands %q, want only the templated role rendered", commands[:2])
+	}
+
+	cfg.Roles["storage"] = config.Role{Commands: []string{"echo {{ .rack }}"}, Template: true}
+	if err := SetupRoles(testutil.NewExecutor("10.0.0.1"), testutil.NewExecutor("10.0.0.2"), cfg, []string{"storage"}); err == nil || !strings.Contains(err.Error(), "rack") {
+		t.Errorf("error %v, want the undefined variable", err)
+	}
+}
+
+func TestLoadManifestsTemplate(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"10-storage.yaml.tmpl": "zone: {{ .zone }}\ncluster: {{ .clusterName }}\n",
+		"20-plain.yaml":        "literal: '{{ .zone }}'\n",
+		"30-notes.tmpl":        "skipped, not a manifest\n",
+	}
+	for name, data := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	manifests, err := loadManifests([]string{dir}, "", map[string]string{"zone": "eu-1a", "clusterName": "prod"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(manifests) != 2 {
+		t.Fatalf("loaded %d manifests, want the templated and the plain one", len(manifests))
+	}
+	if m := manifests[0]; m.name != "10-storage.yaml" || string(m.data) != "zone: eu-1a\ncluster: prod\n" {
+		t.Errorf("templated manifest %s: %q", m.name, m.data)
+	}
+	if m := manifests[1]; m.name != "20-plain.yaml" || string(m.data) != files["20-plain.yaml"] {
+		t.Errorf("plain manifest %s: %q", m.name, m.data)
+	}
+
+	if _, err := loadManifests([]string{filepath.Join(dir, "10-storage.yaml.tmpl")}, "", map[string]string{"zone": "eu-1a"}); err == nil || !strings.Contains(err.Error(), "clusterName") {
+		t.Errorf("error %v, want the undefined variable", err)
+	}
+}
+
+func TestPatchHostPlan(t *testing.T) {
+	host := testutil.NewExecutor("10.0.0.2").Respond("reboot-required", "yes\n")
+	needsReboot, err := PatchHost(host, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !needsReboot {
+		t.Error("pending reboot was not detected")
+	}
+	testutil.AssertGolden(t, "patch-host", host.Plan())
+
+	all := testutil.NewExecutor("10.0.0.2").Respond("reboot-required", "no\n")
+	if needsReboot, err := PatchHost(all, true); err != nil || needsReboot {
+		t.Fatalf("got %v, %v", needsReboot, err)
+	}
+	if commands := strings.Join(all.Commands(), "\n"); !strings.Contains(commands, "apt-get -y -o Dpkg::Options::=--force-confold dist-upgrade") {
+		t.Errorf("--all did not upgrade every package:\n%s", commands)
+	}
+}
+
+func TestDrainOptions(t *testing.T) {
+	controlPlane := testutil.NewExecutor("10.0.0.1")
+	if err := Drain(controlPlane, "worker-1", DrainOptions{Timeout: 90 * time.Second, Force: true, DisableEviction: true}); err != nil {
+		t.Fatal(err)
+	}
+	want := "kubectl drain worker-1 --ignore-daemonsets --delete-emptydir-data --timeout=90s --force --disable-eviction"
+	if commands := controlPlane.Commands(); len(commands) != 1 || commands[0] != want {
+		t.Errorf("got %v, want %q", commands, want)
+	}
+}
+
+func TestAddCertSANs(t *testing.T) {
+	clusterConfig := "apiVersion: kubeadm.k8s.io/v1beta3\nkind: ClusterConfiguration\napiServer:\n  certSANs:\n  - 10.0.0.1\n"
+	cert := "            X509v3 Subject Alternative Name:\n                DNS:cp-1, DNS:kubernetes, IP Address:10.96.0.1, IP Address:10.0.0.1\n"
+	client := testutil.NewExecutor("10.0.0.1").
+		Respond("configmap kubeadm-config", clusterConfig).
+		Respond("openssl x509", cert)
+
+	added, err := AddCertSANs(client, nil, testConfig(), []string{"10.0.0.1", "k8s.example.com", "203.0.113.7"}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(added, ",") != "k8s.example.com,203.0.113.7" {
+		t.Errorf("added = %v", added)
+	}
+	written := string(client.Files[sansConfig])
+	for _, san := range []string{"- 10.0.0.1", "- k8s.example.com", "- 203.0.113.7"} {
+		if !strings.Contains(written, san) {
+			t.Errorf("uploaded configuration lacks %s:\n%s", san, written)
+		}
+	}
+	if commands := strings.Join(client.Commands(), "\n"); !strings.Contains(commands, "kubeadm init phase certs apiserver") ||
+		!strings.Contains(commands, "kubeadm init phase upload-config kubeadm") {
+		t.Errorf("certificate was not reissued:\n%s", commands)
+	}
+
+	unchanged := testutil.NewExecutor("10.0.0.1").
+		Respond("configmap kubeadm-config", clusterConfig).
+		Respond("openssl x509", cert)
+	if added, err := AddCertSANs(unchanged, nil, testConfig(), []string{"cp-1", "10.0.0.1"}, ""); err != nil || len(added) != 0 {
+		t.Fatalf("got %v, %v", added, err)
+	}
+	for _, cmd := range unchanged.Commands() {
+		if strings.Contains(cmd, "kubeadm init") {
+			t.Errorf("covered names reissued the certificate: %s", cmd)
+		}
+	}
+}
+
+func TestAddCertSANsControlPlanes(t *testing.T) {
+	clusterConfig := "apiVersion: kubeadm.k8s.io/v1beta3\nkind: ClusterConfiguration\ncontrolPlaneEndpoint: 10.0.0.1:6443\n"
+	clusterInfo := "apiVersion: v1\nkind: Config\nclusters:\n- cluster:\n    certificate-authority-data: Q0E=\n    server: https://10.0.0.1:6443\n  name: \"\"\n"
+	covered := "X509v3 Subject Alternative Name:\n    DNS:k8s.example.com, IP Address:10.0.0.1\n"
+	first := testutil.NewExecutor("10.0.0.1").
+		Respond("configmap kubeadm-config", clusterConfig).
+		Respond("configmap cluster-info", clusterInfo).
+		Respond("openssl x509", covered)
+	second := testutil.NewExecutor("10.0.0.2").
+		Respond("openssl x509", "X509v3 Subject Alternative Name:\n    IP Address:10.0.0.2\n")
+
+	others := []ControlPlane{{Address: "10.0.0.2", Client: second}}
+	added, err := AddCertSANs(first, others, testConfig(), []string{"k8s.example.com"}, "k8s.example.com:6443")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(added, ",") != "k8s.example.com" {
+		t.Errorf("added = %v", added)
+	}
+
+	// The control plane missing the name is reissued with its own address
+	if written := string(second.Files[sansConfig]); !strings.Contains(written, "advertiseAddress: 10.0.0.2") ||
+		!strings.Contains(written, "- k8s.example.com") {
+		t.Errorf("configuration of the second control plane:\n%s", written)
+	}
+	if commands := strings.Join(second.Commands(), "\n"); !strings.Contains(commands, "kubeadm init phase certs apiserver") ||
+		!strings.Contains(commands, "crictl stop") {
+		t.Errorf("second control plane was not reissued:\n%s", commands)
+	}
+
+	// The endpoint moves in the kubeadm configuration and in cluster-info
+	commands := strings.Join(first.Commands(), "\n")
+	if strings.Contains(commands, "kubeadm init phase certs") {
+		t.Errorf("covered control plane was reissued:\n%s", commands)
+	}
+	if written := string(first.Files[sansConfig]); !strings.Contains(written, "controlPlaneEndpoint: k8s.example.com:6443") {
+		t.Errorf("uploaded configuration keeps the old endpoint:\n%s", written)
+	}
+	if !strings.Contains(commands, "kubeadm init phase upload-config kubeadm") {
+		t.Errorf("configuration was not uploaded:\n%s", commands)
+	}
+	if !strings.Contains(commands, "kubectl -n kube-public patch configmap cluster-info") ||
+		!strings.Contains(commands, `server: https://k8s.example.com:6443`) ||
+		!strings.Contains(commands, "certificate-authority-data: Q0E=") {
+		t.Errorf("cluster-info was not moved to the endpoint:\n%s", commands)
+	}
+}
+
+func TestOtherControlPlanes(t *testing.T) {
+	client := testutil.NewExecutor("10.0.0.1").
+		Respond("kubectl get nodes", "cp-1 10.0.0.1\ncp-2 10.0.0.2\ncp-3 10.0.0.3\n").
+		Respond("hostname", "CP-1\n")
+	addresses, err := OtherControlPlanes(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(addresses, ",") != "10.0.0.2,10.0.0.3" {
+		t.Errorf("addresses = %v", addresses)
+	}
+}
+
+func TestRenameCluster(t *testing.T) {
+	clusterConfig := "apiVersion: kubeadm.k8s.io/v1beta3\nkind: ClusterConfiguration\nclusterName: kubernetes\n"
+	client := testutil.NewExecutor("10.0.0.1").Respond("configmap kubeadm-config", clusterConfig)
+
+	changed, err := RenameCluster(client, "edge-1")
+	if err != nil || !changed {
+		t.Fatalf("got %v, %v", changed, err)
+	}
+	if written := string(client.Files[renameConfig]); !strings.Contains(written, "clusterName: edge-1") {
+		t.Errorf("uploaded configuration keeps the old name:\n%s", written)
+	}
+	commands := strings.Join(client.Commands(), "\n")
+	if !strings.Contains(commands, "kubeadm init phase upload-config kubeadm") || !strings.Contains(commands, ClusterLabel+"=edge-1 --overwrite") {
+		t.Errorf("cluster was not renamed:\n%s", commands)
+	}
+
+	unchanged := testutil.NewExecutor("10.0.0.1").Respond("configmap kubeadm-config", "clusterName: edge-1\n")
+	if changed, err := RenameCluster(unchanged, "edge-1"); err != nil || changed {
+		t.Fatalf("got %v, %v", changed, err)
+	}
+	for _, cmd := range unchanged.Commands() {
+		if strings.Contains(cmd, "kubeadm init") {
+			t.Errorf("unchanged name uploaded the configuration: %s", cmd)
+		}
+	}
+
+	if _, err := RenameCluster(testutil.NewExecutor("10.0.0.1"), "edge 1"); err == nil {
+		t.Error("expected an invalid label value to be refused")
+	}
+}
+
+func TestLabelCluster(t *testing.T) {
+	controlPlane := testutil.NewExecutor("10.0.0.1")
+	node := testutil.NewExecutor("10.0.0.2").Respond("hostname", "Worker-1\n")
+	if err := LabelCluster(controlPlane, node, "edge-1"); err != nil {
+		t.Fatal(err)
+	}
+	want := "kubectl label node worker-1 " + ClusterLabel + "=edge-1 --overwrite"
+	if commands := controlPlane.Commands(); len(commands) != 1 || commands[0] != want {
+		t.Errorf("got %v, want %q", commands, want)
+	}
+
+	if err := LabelCluster(controlPlane, node, "edge 1"); err == nil {
+		t.Error("expected an invalid label value to be refused")
+	}
+}
+
+func TestSetupLabelsCluster(t *testing.T) {
+	cfg := testConfig()
+	cfg.Cluster.Name = "edge-1"
+	fake := testutil.NewExecutor("10.0.0.1").Respond("hostname", "cp-1\n")
+	if err := Setup(fake, cfg, nil); err != nil {
+		t.Fatal(err)
+	}
+	if commands := strings.Join(fake.Commands(), "\n"); !strings.Contains(commands, "kubectl label node cp-1 "+ClusterLabel+"=edge-1 --overwrite") {
+		t.Errorf("control plane was not labeled with the cluster name:\n%s", commands)
+	}
+
+	// Without a configured name the cluster is known by the IP
+	unnamed := testutil.NewExecutor("10.0.0.1")
+	if err := Setup(unnamed, testConfig(), nil); err != nil {
+		t.Fatal(err)
+	}
+	for _, cmd := range unnamed.Commands() {
+		if strings.Contains(cmd, ClusterLabel) {
+			t.Errorf("unnamed cluster labeled: %s", cmd)
+		}
+	}
+}
+
+func TestKubeadmConfigClusterName(t *testing.T) {
+	cfg := testConfig()
+	cfg.Cluster.Name = "edge-1"
+	data, err := renderKubeadmConfig(cfg, "10.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"clusterName": "edge-1"`) {
+		t.Errorf("clusterName not rendered:\n%s", data)
+	}
+}
+
+func TestCheckSupport(t *testing.T) {
+	now := time.Date(2026, 10, 15, 0, 0, 0, 0, time.UTC)
+	for version, want := range map[string]bool{
+		"1.18.20-00": true,
+		"1.28.2-00":  true,
+		"1.34.1-1.1": false,
+		"1.35.0-1.1": false,
+		"1.40.0-1.1": false,
+	} {
+		if s := CheckSupport(version, now, nil); s.Expired != want {
+			t.Errorf("CheckSupport(%s).Expired = %v, want %v", version, s.Expired, want)
+		}
+	}
+
+	if w := CheckSupport("1.34.1-1.1", now, nil).Warning(now); !strings.Contains(w, "reaches its end of life on 2026-10-27") {
+		t.Errorf("no warning for a version close to its end of life: %q", w)
+	}
+	if w := CheckSupport("1.35.0-1.1", now, nil).Warning(now); w != "" {
+		t.Errorf("unexpected warning %q", w)
+	}
+}
+
+func TestParseEOLFeed(t *testing.T) {
+	feed := `[{"cycle":"1.36","eol":"2027-06-28"},{"cycle":"1.37","eol":false},{"cycle":"1.28","eol":"2024-10-28"}]`
+	dates, err := parseEOLFeed([]byte(feed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := dates[36].Format("2006-01-02"); got != "2027-06-28" {
+		t.Errorf("1.36 ends on %s", got)
+	}
+	if _, ok := dates[37]; ok {
+		t.Error("a cycle without end of life got a date")
+	}
+	if _, ok := dates[20]; !ok {
+		t.Error("embedded dates missing from the feed were dropped")
+	}
+	if _, err := parseEOLFeed([]byte(`[{"cycle":"1.36","eol":"soon"}]`)); err == nil {
+		t.Error("expected an invalid date to be refused")
+	}
+}
+
+func TestKubeadmConfigReserved(t *testing.T) {
+	cfg := testConfig()
+	cfg.Kubernetes.Reserved = config.KubeletReservations{
+		System:   map[string]string{"cpu": "500m", "memory": "1Gi"},
+		Kube:     map[string]string{"cpu": "250m", "memory": "512Mi", "ephemeral-storage": "1Gi"},
+		Eviction: map[string]string{"memory.available": "200Mi"},
+	}
+
+	data, err := renderKubeadmConfig(cfg, "10.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	testutil.AssertGolden(t, "kubeadm-config-reserved", string(data)+"\n")
+
+	for _, reserved := range []config.KubeletReservations{
+		{System: map[string]string{"gpu": "1"}},
+		{Kube: map[string]string{"memory": "lots"}},
+		{Eviction: map[string]string{"memory.free": "1Gi"}},
+		{Eviction: map[string]string{"nodefs.available": "110%"}},
+	} {
+		cfg.Kubernetes.Reserved = reserved
+		if _, err := renderKubeadmConfig(cfg, "10.0.0.1"); err == nil {
+			t.Errorf("expected %+v to be refused", reserved)
+		}
+	}
+}
+
+func TestCheckCgroups(t *testing.T) {
+	v2 := func() *testutil.Executor {
+		return testutil.NewExecutor("10.0.0.1").Respond("stat -fc %T /sys/fs/cgroup", "cgroup2fs\n")
+	}
+	v1 := testutil.NewExecutor("10.0.0.1").Respond("stat -fc %T /sys/fs/cgroup", "tmpfs\n")
+
+	if version, err := DetectCgroupVersion(v2()); err != nil || version != 2 {
+		t.Errorf("DetectCgroupVersion = %d, %v, want 2", version, err)
+	}
+
+	// An unset driver is systemd, also before kubeadm defaults to it
+	cfg := testConfig()
+	cfg.Kubernetes.Version = "1.21.14-00"
+	if err := checkCgroups(v2(), cfg); err != nil {
+		t.Errorf("the default driver refused on cgroup v2: %v", err)
+	}
+	if cmds := runtimeCommands(cfg); !strings.Contains(cmds[1], "SystemdCgroup = true") {
+		t.Errorf("containerd not set to systemd: %s", cmds[1])
+	}
+	kubelet, err := kubeletConfiguration(cfg)
+	if err != nil || kubelet["cgroupDriver"] != config.CgroupDriverSystemd {
+		t.Errorf("kubelet configuration %v, %v, want the systemd driver", kubelet, err)
+	}
+
+	cfg.Kubernetes.CgroupDriver = config.CgroupDriverSystemd
+	if err := checkCgroups(v2(), cfg); err != nil {
+		t.Errorf("systemd refused on cgroup v2: %v", err)
+	}
+	cfg.Kubernetes.CgroupDriver = config.CgroupDriverCgroupfs
+	if err := checkCgroups(v2(), cfg); err == nil {
+		t.Error("expected cgroupfs to be refused on cgroup v2")
+	}
+	if err := checkCgroups(v1, cfg); err != nil {
+		t.Errorf("cgroupfs refused on cgroup v1: %v", err)
+	}
+	cfg.Kubernetes.CgroupDriver = "cgroupv2"
+	if err := checkCgroups(v1, cfg); err == nil {
+		t.Error("expected an unknown driver to be refused")
+	}
+}
+
+func TestCertificateExpiry(t *testing.T) {
+	client := testutil.NewExecutor("10.0.0.1").Respond("openssl x509 -enddate", strings.Join([]string{
+		"/etc/kubernetes/pki/ca.crt notAfter=Oct 12 09:30:00 2034 GMT",
+		"/etc/kubernetes/pki/apiserver.crt notAfter=Oct 14 09:30:05 2025 GMT",
+		"/etc/kubernetes/pki/etcd/server.crt notAfter=Oct  3 09:30:05 2025 GMT",
+	}, "\n")+"\n")
+
+	certs, err := CertificateExpiry(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(certs) != 3 || certs[0].Path != "/etc/kubernetes/pki/etcd/server.crt" {
+		t.Fatalf("certs = %+v, want etcd/server.crt first", certs)
+	}
+	if want := time.Date(2025, 10, 3, 9, 30, 5, 0, time.UTC); !certs[0].NotAfter.Equal(want) {
+		t.Errorf("NotAfter = %v, want %v", certs[0].NotAfter, want)
+	}
+
+	if _, err := CertificateExpiry(testutil.NewExecutor("10.0.0.1")); err == nil {
+		t.Error("expected an error without certificates")
+	}
+}
+
+func TestCollectFacts(t *testing.T) {
+	client := testutil.NewExecutor("10.0.0.1").Respond("os-release", strings.Join([]string{
+		"os=Ubuntu 22.04.4 LTS",
+		"osID=ubuntu",
+		"osVersion=22.04",
+		"kernel=5.15.0-119-generic",
+		"machine=aarch64",
+		"cpus=4",
+		"memory=8131520",
+		"disk=40581564",
+		"diskFree=31014428",
+		"containerd=containerd containerd.io 1.7.22 7f7fdf5fed64eb6a7caf99b3e12efcf9d60e311c",
+		"kubelet=Kubernetes v1.30.4",
+	}, "\n")+"\n")
+
+	facts, err := CollectFacts(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	facts.Collected = time.Time{}
+	want := Facts{
+		OS:               "Ubuntu 22.04.4 LTS",
+		OSID:             "ubuntu",
+		OSVersion:        "22.04",
+		Kernel:           "5.15.0-119-generic",
+		Arch:             ArchARM64,
+		CPUs:             4,
+		MemoryBytes:      8131520 << 10,
+		DiskBytes:        40581564 << 10,
+		DiskFreeBytes:    31014428 << 10,
+		ContainerRuntime: "containerd 1.7.22",
+		Kubelet:          "v1.30.4",
+	}
+	if *facts != want {
+		t.Errorf("facts = %+v, want %+v", *facts, want)
+	}
+
+	// Before the Kubernetes step neither is installed
+	facts, err = CollectFacts(testutil.NewExecutor("10.0.0.1").Respond("os-release", "os=Debian GNU/Linux 12 (bookworm)\ncpus=2\nmemory=2000000\ndisk=1000\ndiskFree=500\ncontainerd=\nkubelet=\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if facts.ContainerRuntime != "" || facts.Kubelet != "" {
+		t.Errorf("facts = %+v, want no runtime and kubelet", *facts)
+	}
+
+	if _, err := CollectFacts(testutil.NewExecutor("10.0.0.1").Respond("os-release", "os=Debian\n")); err == nil {
+		t.Error("expected an error without the CPU count")
+	}
+}
+
+func TestAPIServerArg(t *testing.T) {
+	for version, clusterConfig := range map[string]string{
+		"v1beta3": `apiVersion: kubeadm.k8s.io/v1beta3
+kind: ClusterConfiguration
+apiServer:
+  extraArgs:
+    authorization-mode: Node,RBAC
+    service-node-port-range: 30000-40000
+etcd:
+  local:
+    extraArgs:
+      service-node-port-range: 1-2
+`,
+		"v1beta4": `apiVersion: kubeadm.k8s.io/v1beta4
+kind: ClusterConfiguration
+apiServer:
+  extraArgs:
+  - name: authorization-mode
+    value: Node,RBAC
+  - value: "30000-40000"
+    name: service-node-port-range
+`,
+	} {
+		if got := apiServerArg(clusterConfig, "service-node-port-range"); got != "30000-40000" {
+			t.Errorf("%s: service-node-port-range = %q, want 30000-40000", version, got)
+		}
+		if got := apiServerArg(clusterConfig, "audit-log-path"); got != "" {
+			t.Errorf("%s: unset flag = %q", version, got)
+		}
+	}
+
+	if got := apiServerArg("apiVersion: kubeadm.k8s.io/v1beta4\nkind: ClusterConfiguration\n", "service-node-port-range"); got != "" {
+		t.Errorf("flag of a configuration without extraArgs = %q", got)
+	}
+}
+
+func TestInstallFlannelNetwork(t *testing.T) {
+	cfg := testConfig()
+	cfg.Kubernetes.CNI = CNIFlannel
+
+	fake := testutil.NewExecutor("10.0.0.1")
+	if err := installCNI(fake, cfg); err != nil {
+		t.Fatal(err)
+	}
+	if commands := fake.Commands(); len(commands) != 1 || commands[0] != "kubectl apply -f "+flannelManifest {
+		t.Errorf("default pod network installed with %q", commands)
+	}
+
+	cfg.Kubernetes.PodCIDR = "10.32.0.0/12"
+	fake = testutil.NewExecutor("10.0.0.1")
+	if err := installCNI(fake, cfg); err != nil {
+		t.Fatal(err)
+	}
+	want := "curl -fsSL " + flannelManifest + ` | sed 's#10\.244\.0\.0/16#10.32.0.0/12#' | kubectl apply -f -`
+	if commands := fake.Commands(); len(commands) != 1 || commands[0] != want {
+		t.Errorf("pod network 10.32.0.0/12 installed with %q, want %q", commands, want)
+	}
+
+	cfg.Kubernetes.PodCIDR = "10.32.0.0/12#x"
+	if err := installCNI(testutil.NewExecutor("10.0.0.1"), cfg); err == nil {
+		t.Error("an invalid pod network was installed")
+	}
+}
@@ -0,0 +1,188 @@
+package kubernetes
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/maarulav/k8s-setup/pkg/config"
+	"github.com/maarulav/k8s-setup/pkg/kube"
+	"github.com/maarulav/k8s-setup/pkg/ssh"
+)
+
+// bootstrapDir holds the uploaded bootstrap manifests on the control plane
+const bootstrapDir = manifestDir + "/bootstrap"
+
+// kustomizationFiles are the file names that mark a kustomize directory
+var kustomizationFiles = []string{"kustomization.yaml", "kustomization.yml", "Kustomization"}
+
+// manifest is one rendered document set to upload and apply
+type manifest struct {
+	source string
+	name   string
+	data   []byte
+}
+
+// ApplyManifests uploads the configured local manifest files and directories
+// and applies them in order with server-side apply once the node is Ready
+func ApplyManifests(client ssh.Executor, cfg *config.Config) error {
+	manifests, err := loadManifests(cfg.Manifests, cfg.Profile, cfg.HostVars(client.Host()))
+	if err != nil {
+		return err
+	}
+
+	kc, err := kube.NewClient(client)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), kube.DefaultTimeout)
+	defer cancel()
+	if err := kube.WaitForNodeReady(ctx, kc, "", kube.Print); err != nil {
+		return fmt.Errorf("cluster did not become Ready: %v", err)
+	}
+
+	cmd := fmt.Sprintf("rm -rf %s && mkdir -p %s", bootstrapDir, bootstrapDir)
+	if output, err := client.ExecuteCommand(cmd); err != nil {
+		return fmt.Errorf("failed to execute command '%s': %v\nOutput: %s", cmd, err, output)
+	}
+
+	for i, m := range manifests {
+		// Prefix with the position so the remote copies keep the order
+		remote := path.Join(bootstrapDir, fmt.Sprintf("%03d-%s", i, m.name))
+		if err := client.WriteFile(remote, m.data, 0600); err != nil {
+			return err
+		}
+
+		cmd := fmt.Sprintf("kubectl apply --server-side --force-conflicts --field-manager=k8s-setup -f %s", remote)
+		if output, err := client.ExecuteCommand(cmd); err != nil {
+			return fmt.Errorf("failed to apply manifest %s: %v\nOutput: %s", m.source, err, output)
+		}
+	}
+
+	return nil
+}
+
+// loadManifests expands the configured paths into manifests. Kustomize
+// directories are rendered locally, using overlays/<profile> when a profile is
+// set and the overlay exists. Other directories contribute their .yaml, .yml
+// and .json files in lexical order. Files with a further .tmpl extension are
+// rendered with vars, the template variables of the host.
+func loadManifests(paths []string, profile string, vars map[string]string) ([]manifest, error) {
+	var manifests []manifest
+	for _, p := range paths {
+		info, err := os.Stat(p)
+		if err != nil {
+			return nil, fmt.Errorf("manifest path %s: %v", p, err)
+		}
+
+		if !info.IsDir() {
+			m, err := readManifest(p, vars)
+			if err != nil {
+				return nil, err
+			}
+			manifests = append(manifests, m)
+			continue
+		}
+
+		if dir := kustomizeDir(p, profile); dir != "" {
+			data, err := kustomizeBuild(dir)
+			if err != nil {
+				return nil, err
+			}
+			name := strings.ReplaceAll(strings.Trim(filepath.ToSlash(dir), "/."), "/", "-") + ".yaml"
+			manifests = append(manifests, manifest{source: dir, name: name, data: data})
+			continue
+		}
+
+		entries, err := ioutil.ReadDir(p)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read manifest directory %s: %v", p, err)
+		}
+		var files []string
+		for _, entry := range entries {
+			switch strings.ToLower(filepath.Ext(strings.TrimSuffix(entry.Name(), ".tmpl"))) {
+			case ".yaml", ".yml", ".json":
+				if !entry.IsDir() {
+					files = append(files, filepath.Join(p, entry.Name()))
+				}
+			}
+		}
+		sort.Strings(files)
+		for _, file := range files {
+			m, err := readManifest(file, vars)
+			if err != nil {
+				return nil, err
+			}
+			manifests = append(manifests, m)
+		}
+	}
+
+	return manifests, nil
+}
+
+func readManifest(file string, vars map[string]string) (manifest, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return manifest{}, fmt.Errorf("failed to read manifest %s: %v", file, err)
+	}
+	name := filepath.Base(file)
+	if strings.HasSuffix(name, ".tmpl") {
+		text, err := config.Render(file, string(data), vars)
+		if err != nil {
+			return manifest{}, err
+		}
+		data, name = []byte(text), strings.TrimSuffix(name, ".tmpl")
+	}
+	return manifest{source: file, name: name, data: data}, nil
+}
+
+// kustomizeDir returns the kustomization to build for dir, or "" when dir is
+// a plain manifest directory
+func kustomizeDir(dir, profile string) string {
+	if profile != "" {
+		if overlay := filepath.Join(dir, "overlays", profile); isKustomization(overlay) {
+			return overlay
+		}
+	}
+	if isKustomization(dir) {
+		return dir
+	}
+	return ""
+}
+
+func isKustomization(dir string) bool {
+	for _, name := range kustomizationFiles {
+		if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// kustomizeBuild renders a kustomization with the local kustomize binary,
+// falling back to kubectl's built-in kustomize
+func kustomizeBuild(dir string) ([]byte, error) {
+	var cmd *exec.Cmd
+	if _, err := exec.LookPath("kustomize"); err == nil {
+		cmd = exec.Command("kustomize", "build", dir)
+	} else if _, err := exec.LookPath("kubectl"); err == nil {
+		cmd = exec.Command("kubectl", "kustomize", dir)
+	} else {
+		return nil, fmt.Errorf("rendering %s needs kustomize or kubectl on this machine", dir)
+	}
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build kustomization %s: %v\nOutput: %s", dir, err, stderr.String())
+	}
+	return output, nil
+}
@@ -0,0 +1,92 @@
+package kubernetes
+
+import (
+	"fmt"
+
+	"github.com/maarulav/k8s-setup/pkg/config"
+	"github.com/maarulav/k8s-setup/pkg/ssh"
+)
+
+// SetupNamespaces creates the configured application namespaces together with a
+// ResourceQuota and LimitRange derived from the Resources block
+func SetupNamespaces(client ssh.Executor, config *config.Config) error {
+	if len(config.Namespaces) == 0 {
+		return nil
+	}
+
+	var objects []map[string]interface{}
+	for _, ns := range config.Namespaces {
+		nsObjects, err := namespaceObjects(config, ns)
+		if err != nil {
+			return fmt.Errorf("namespace %s: %v", ns.Name, err)
+		}
+		objects = append(objects, nsObjects...)
+	}
+
+	return Apply(client, "namespaces", objects)
+}
+
+func namespaceObjects(cfg *config.Config, ns config.Namespace) ([]map[string]interface{}, error) {
+	cpuValue, memoryValue := cfg.Resources.CPU, cfg.Resources.Memory
+	if ns.CPU != "" {
+		cpuValue = ns.CPU
+	}
+	if ns.Memory != "" {
+		memoryValue = ns.Memory
+	}
+
+	cpu, err := config.ParseCPU(cpuValue)
+	if err != nil {
+		return nil, err
+	}
+	memory, err := config.ParseMemory(memoryValue)
+	if err != nil {
+		return nil, err
+	}
+
+	hard := map[string]interface{}{
+		"requests.cpu":    config.FormatCPU(cpu),
+		"requests.memory": config.FormatMemory(memory),
+		"limits.cpu":      config.FormatCPU(cpu),
+		"limits.memory":   config.FormatMemory(memory),
+	}
+	if ns.Pods > 0 {
+		hard["pods"] = fmt.Sprint(ns.Pods)
+	}
+
+	// Containers without explicit resources get an eighth of the quota as
+	// request and a quarter as limit
+	return []map[string]interface{}{
+		{
+			"apiVersion": "v1",
+			"kind":       "Namespace",
+			"metadata":   map[string]interface{}{"name": ns.Name},
+		},
+		{
+			"apiVersion": "v1",
+			"kind":       "ResourceQuota",
+			"metadata":   map[string]interface{}{"name": "default-quota", "namespace": ns.Name},
+			"spec":       map[string]interface{}{"hard": hard},
+		},
+		{
+			"apiVersion": "v1",
+			"kind":       "LimitRange",
+			"metadata":   map[string]interface{}{"name": "default-limits", "namespace": ns.Name},
+			"spec": map[string]interface{}{
+				"limits": []map[string]interface{}{
+					{
+						"type": "Container",
+						"defaultRequest": map[string]interface{}{
+							"cpu":    config.FormatCPU(cpu / 8),
+							"memory": config.FormatMemory(memory / 8),
+						},
+						"default": map[string]interface{}{
+							"cpu":    config.FormatCPU(cpu / 4),
+							"memory": config.FormatMemory(memory / 4),
+						},
+					},
+				},
+			},
+		},
+	}, nil
+}
@@ -0,0 +1,64 @@
+package kubernetes
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/maarulav/k8s-setup/pkg/ssh"
+)
+
+// Reboot policies of a patch run
+const (
+	// RebootAuto reboots the hosts whose updates ask for it
+	RebootAuto = "auto"
+	// RebootAlways reboots every patched host
+	RebootAlways = "always"
+	// RebootNever leaves the reboot to the operator
+	RebootNever = "never"
+)
+
+// kubernetesPackages only change with an upgrade, never with OS patches
+var kubernetesPackages = []string{"kubeadm", "kubelet", "kubectl"}
+
+// PatchHost installs the pending security updates on host, or every pending
+// update with all, and reports whether the host asks for a reboot. The
+// Kubernetes packages are held while the updates run.
+func PatchHost(host ssh.Executor, all bool) (bool, error) {
+	update := "unattended-upgrade -v"
+	if all {
+		update = "apt-get -y -o Dpkg::Options::=--force-confold dist-upgrade"
+	}
+	packages := strings.Join(kubernetesPackages, " ")
+	err := runCommands(host, []string{
+		"apt-get update",
+		"DEBIAN_FRONTEND=noninteractive apt-get install -y unattended-upgrades",
+		fmt.Sprintf("apt-mark hold %s && (DEBIAN_FRONTEND=noninteractive %s; rc=$?; apt-mark unhold %s; exit $rc)", packages, update, packages),
+	})
+	if err != nil {
+		return false, err
+	}
+
+	output, err := host.ExecuteCommand("test -f /var/run/reboot-required && echo yes || echo no")
+	if err != nil {
+		return false, fmt.Errorf("failed to check for a pending reboot: %v", err)
+	}
+	return strings.TrimSpace(output) == "yes", nil
+}
+
+// BootID identifies the current boot of host, it changes with every reboot
+func BootID(host ssh.Executor) (string, error) {
+	output, err := host.ExecuteCommand("cat /proc/sys/kernel/random/boot_id")
+	if err != nil {
+		return "", fmt.Errorf("failed to read boot ID: %v", err)
+	}
+	return strings.TrimSpace(output), nil
+}
+
+// Reboot schedules a reboot of host a few seconds out, so the command
+// returns before the SSH connection drops
+func Reboot(host ssh.Executor) error {
+	if output, err := host.ExecuteCommand("nohup sh -c 'sleep 2; systemctl reboot' >/dev/null 2>&1 &"); err != nil {
+		return fmt.Errorf("failed to reboot: %v\nOutput: %s", err, output)
+	}
+	return nil
+}
@@ -0,0 +1,161 @@
+package kubernetes
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/maarulav/k8s-setup/pkg/config"
+	"github.com/maarulav/k8s-setup/pkg/ssh"
+)
+
+// RegistrySecretName is the docker-registry Secret attached to default ServiceAccounts
+const RegistrySecretName = "registry-credentials"
+
+type dockerConfig struct {
+	Auths map[string]dockerAuth `json:"auths"`
+}
+
+type dockerAuth struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Auth     string `json:"auth"`
+}
+
+// SetupRegistryCredentials distributes private registry credentials to the
+// kubelet and to the default ServiceAccounts of the configured namespaces
+func SetupRegistryCredentials(client *ssh.Client, config *config.Config) error {
+	if len(config.Registries) == 0 {
+		return nil
+	}
+
+	if err := setupNodeRegistryAuth(client, config); err != nil {
+		return err
+	}
+
+	return setupPullSecrets(client, config)
+}
+
+// setupNodeRegistryAuth writes the kubelet credential file, which the kubelet
+// passes to the container runtime on every pull
+func setupNodeRegistryAuth(client *ssh.Client, cfg *config.Config) error {
+	var registries []config.Registry
+	for _, r := range cfg.Registries {
+		if r.NodeAuth {
+			registries = append(registries, r)
+		}
+	}
+	if len(registries) == 0 {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(newDockerConfig(registries), "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to render registry auth: %v", err)
+	}
+
+	for _, path := range []string{"/var/lib/kubelet/config.json", "/root/.docker/config.json"} {
+		if _, err := client.ExecuteCommand(fmt.Sprintf("mkdir -p $(dirname %s)", path)); err != nil {
+			return fmt.Errorf("failed to create directory for %s: %v", path, err)
+		}
+		if err := client.WriteFile(path, data, 0600); err != nil {
+			return err
+		}
+	}
+
+	if _, err := client.ExecuteCommand("systemctl restart kubelet"); err != nil {
+		return fmt.Errorf("failed to restart kubelet: %v", err)
+	}
+
+	return nil
+}
+
+// setupPullSecrets creates a docker-registry Secret per namespace and attaches it
+// to the namespace's default ServiceAccount
+func setupPullSecrets(client *ssh.Client, cfg *config.Config) error {
+	byNamespace := map[string][]config.Registry{}
+	for _, r := range cfg.Registries {
+		for _, ns := range r.Namespaces {
+			byNamespace[ns] = append(byNamespace[ns], r)
+		}
+	}
+	if len(byNamespace) == 0 {
+		return nil
+	}
+
+	namespaces := make([]string, 0, len(byNamespace))
+	for ns := range byNamespace {
+		namespaces = append(namespaces, ns)
+	}
+	sort.Strings(namespaces)
+
+	var objects []map[string]interface{}
+	for _, ns := range namespaces {
+		data, err := json.Marshal(newDockerConfig(byNamespace[ns]))
+		if err != nil {
+			return fmt.Errorf("failed to render pull secret for %s: %v", ns, err)
+		}
+
+		objects = append(objects,
+			map[string]interface{}{
+				"apiVersion": "v1",
+				"kind":       "Namespace",
+				"metadata":   map[string]interface{}{"name": ns},
+			},
+			map[string]interface{}{
+				"apiVersion": "v1",
+				"kind":       "Secret",
+				"type":       "kubernetes.io/dockerconfigjson",
+				"metadata":   map[string]interface{}{"name": RegistrySecretName, "namespace": ns},
+				"data": map[string]interface{}{
+					".dockerconfigjson": base64.StdEncoding.EncodeToString(data),
+				},
+			},
+		)
+	}
+
+	if err := Apply(client, "registry-credentials", objects); err != nil {
+		return err
+	}
+
+	patch := fmt.Sprintf(`{"imagePullSecrets":[{"name":"%s"}]}`, RegistrySecretName)
+	for _, ns := range namespaces {
+		if err := waitForDefaultServiceAccount(client, ns); err != nil {
+			return err
+		}
+
+		cmd := fmt.Sprintf("kubectl patch serviceaccount default -n %s -p '%s'", ns, patch)
+		if output, err := client.ExecuteCommand(cmd); err != nil {
+			return fmt.Errorf("failed to execute command '%s': %v\nOutput: %s", cmd, err, output)
+		}
+	}
+
+	return nil
+}
+
+// waitForDefaultServiceAccount waits for the ServiceAccount controller to
+// populate a freshly created namespace
+func waitForDefaultServiceAccount(client *ssh.Client, namespace string) error {
+	cmd := fmt.Sprintf("kubectl get serviceaccount default -n %s", namespace)
+	for i := 0; i < 30; i++ {
+		if _, err := client.ExecuteCommand(cmd); err == nil {
+			return nil
+		}
+		time.Sleep(2 * time.Second)
+	}
+	return fmt.Errorf("default service account in namespace %s was not created", namespace)
+}
+
+func newDockerConfig(registries []config.Registry) dockerConfig {
+	cfg := dockerConfig{Auths: map[string]dockerAuth{}}
+	for _, r := range registries {
+		cfg.Auths[r.Server] = dockerAuth{
+			Username: r.Username,
+			Password: r.Password,
+			Auth:     base64.StdEncoding.EncodeToString([]byte(r.Username + ":" + r.Password)),
+		}
+	}
+	return cfg
+}
@@ -0,0 +1,70 @@
+package kubernetes
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/maarulav/k8s-setup/pkg/ssh"
+	"gopkg.in/yaml.v3"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// ClusterLabel is the node label holding the name of the cluster
+const ClusterLabel = "k8s-setup.io/cluster"
+
+// renameConfig is where RenameCluster uploads the updated kubeadm
+// configuration
+const renameConfig = "/root/kubeadm-rename.yaml"
+
+// LabelCluster labels the node behind node with name, the name of its
+// cluster, through controlPlane
+func LabelCluster(controlPlane, node ssh.Executor, name string) error {
+	if errs := validation.IsValidLabelValue(name); len(errs) > 0 {
+		return fmt.Errorf("invalid cluster name %q: %s", name, strings.Join(errs, "; "))
+	}
+	nodeName, err := nodeName(node)
+	if err != nil {
+		return err
+	}
+	return runCommands(controlPlane, []string{fmt.Sprintf("kubectl label node %s %s=%s --overwrite", nodeName, ClusterLabel, name)})
+}
+
+// RenameCluster sets the clusterName of the kubeadm configuration stored in
+// the cluster at client to name, so upgrades and joins keep it, and labels
+// every node with it. It reports whether the kubeadm configuration changed.
+func RenameCluster(client ssh.Executor, name string) (bool, error) {
+	if errs := validation.IsValidLabelValue(name); len(errs) > 0 {
+		return false, fmt.Errorf("invalid cluster name %q: %s", name, strings.Join(errs, "; "))
+	}
+
+	text, err := ssh.Complete(client.ExecuteCommand("kubectl -n kube-system get configmap kubeadm-config -o jsonpath='{.data.ClusterConfiguration}'"))
+	if err != nil {
+		return false, fmt.Errorf("failed to read the kubeadm configuration: %v", err)
+	}
+	var clusterConfig map[string]interface{}
+	if err := yaml.Unmarshal([]byte(text), &clusterConfig); err != nil || clusterConfig == nil {
+		return false, fmt.Errorf("failed to parse the kubeadm configuration: %v", err)
+	}
+
+	changed := clusterConfig["clusterName"] != name
+	if changed {
+		clusterConfig["clusterName"] = name
+		data, err := yaml.Marshal(clusterConfig)
+		if err != nil {
+			return false, fmt.Errorf("failed to render kubeadm configuration: %v", err)
+		}
+		if err := client.WriteFile(renameConfig, data, 0600); err != nil {
+			return false, err
+		}
+		upload := fmt.Sprintf("kubeadm init phase upload-config kubeadm --config %s", renameConfig)
+		if output, err := client.ExecuteCommand(upload); err != nil {
+			return false, fmt.Errorf("failed to upload the kubeadm configuration: %v\nOutput: %s", err, output)
+		}
+	}
+
+	label := fmt.Sprintf("kubectl label nodes --all %s=%s --overwrite", ClusterLabel, name)
+	if output, err := client.ExecuteCommand(label); err != nil {
+		return changed, fmt.Errorf("failed to label the nodes: %v\nOutput: %s", err, output)
+	}
+	return changed, nil
+}
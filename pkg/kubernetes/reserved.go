@@ -0,0 +1,119 @@
+package kubernetes
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/maarulav/k8s-setup/pkg/config"
+)
+
+// evictionDefaults are the hard eviction thresholds of the kubelet. Setting
+// evictionHard replaces all of them, so the ones not configured are kept.
+var evictionDefaults = map[string]string{
+	"memory.available":  "100Mi",
+	"nodefs.available":  "10%",
+	"nodefs.inodesFree": "5%",
+	"imagefs.available": "15%",
+}
+
+// evictionSignals are the signals evictionHard accepts
+var evictionSignals = map[string]bool{
+	"memory.available":   true,
+	"nodefs.available":   true,
+	"nodefs.inodesFree":  true,
+	"imagefs.available":  true,
+	"imagefs.inodesFree": true,
+	"pid.available":      true,
+}
+
+// kubeletReservations returns the systemReserved, kubeReserved and
+// evictionHard settings of the KubeletConfiguration for the configured
+// reservations, nil when there are none
+func kubeletReservations(cfg *config.Config) (map[string]interface{}, error) {
+	reserved := cfg.Kubernetes.Reserved
+	if reserved.IsEmpty() {
+		return nil, nil
+	}
+
+	settings := map[string]interface{}{}
+	for _, r := range []struct {
+		name      string
+		resources map[string]string
+	}{
+		{"systemReserved", reserved.System},
+		{"kubeReserved", reserved.Kube},
+	} {
+		if len(r.resources) == 0 {
+			continue
+		}
+		for _, resource := range sortedKeys(r.resources) {
+			if err := checkReservation(resource, r.resources[resource]); err != nil {
+				return nil, fmt.Errorf("kubernetes.reserved: %v", err)
+			}
+		}
+		settings[r.name] = r.resources
+	}
+
+	if len(reserved.Eviction) > 0 {
+		eviction := map[string]string{}
+		for signal, threshold := range evictionDefaults {
+			eviction[signal] = threshold
+		}
+		for _, signal := range sortedKeys(reserved.Eviction) {
+			threshold := reserved.Eviction[signal]
+			if err := checkEviction(signal, threshold); err != nil {
+				return nil, fmt.Errorf("kubernetes.reserved: %v", err)
+			}
+			eviction[signal] = threshold
+		}
+		settings["evictionHard"] = eviction
+	}
+	return settings, nil
+}
+
+// checkReservation validates the quantity reserved of resource
+func checkReservation(resource, quantity string) error {
+	var err error
+	switch resource {
+	case "cpu":
+		_, err = config.ParseCPU(quantity)
+	case "memory", "ephemeral-storage", "pid":
+		_, err = config.ParseMemory(quantity)
+	default:
+		return fmt.Errorf("unknown resource %q, expected cpu, memory, ephemeral-storage or pid", resource)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %v", resource, err)
+	}
+	return nil
+}
+
+// checkEviction validates the threshold of an eviction signal, a quantity
+// or a percentage
+func checkEviction(signal, threshold string) error {
+	if !evictionSignals[signal] {
+		return fmt.Errorf("unknown eviction signal %q", signal)
+	}
+	if percent := strings.TrimSuffix(threshold, "%"); percent != threshold {
+		if n, err := strconv.ParseFloat(percent, 64); err != nil || n < 0 || n > 100 {
+			return fmt.Errorf("%s: invalid percentage %q", signal, threshold)
+		}
+		return nil
+	}
+	if _, err := config.ParseMemory(threshold); err != nil {
+		return fmt.Errorf("%s: %v", signal, err)
+	}
+	return nil
+}
+
+// sortedKeys returns the keys of m in order, for stable error messages
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,98 @@
+package kubernetes
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/maarulav/k8s-setup/pkg/config"
+	"github.com/maarulav/k8s-setup/pkg/ssh"
+)
+
+// SetupRoles runs the steps of roles on node: installs the role packages and
+// runs the role commands, rendered with the host vars of node for templated
+// roles, then labels the node through controlPlane. On a
+// single node cluster both are the same host. The taints are left to
+// TaintRoles, once the addons and the monitoring stack run.
+func SetupRoles(controlPlane, node ssh.Executor, cfg *config.Config, roles []string) error {
+	if len(roles) == 0 {
+		return nil
+	}
+
+	var commands []string
+	var packages []string
+	for _, name := range roles {
+		packages = append(packages, cfg.Roles[name].Packages...)
+	}
+	if len(packages) > 0 {
+		commands = append(commands, "apt-get update && apt-get install -y "+strings.Join(packages, " "))
+	}
+	for _, name := range roles {
+		role := cfg.Roles[name]
+		for i, command := range role.Commands {
+			if role.Template {
+				rendered, err := config.Render(fmt.Sprintf("role %s command %d", name, i+1), command, cfg.HostVars(node.Host()))
+				if err != nil {
+					return err
+				}
+				command = rendered
+			}
+			commands = append(commands, command)
+		}
+	}
+	if err := runCommands(node, commands); err != nil {
+		return err
+	}
+
+	name, err := nodeName(node)
+	if err != nil {
+		return err
+	}
+
+	labels := map[string]string{}
+	for _, role := range roles {
+		labels["node-role.kubernetes.io/"+role] = ""
+		for key, value := range cfg.Roles[role].Labels {
+			labels[key] = value
+		}
+	}
+
+	keys := make([]string, 0, len(labels))
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	label := fmt.Sprintf("kubectl label node %s --overwrite", name)
+	for _, key := range keys {
+		label += fmt.Sprintf(" %s=%s", key, labels[key])
+	}
+	return runCommands(controlPlane, []string{label})
+}
+
+// TaintRoles taints node with the taints of roles through controlPlane.
+// Pods that do not tolerate them, such as the addons, stay off the node, so
+// on a single node cluster it runs after they are installed.
+func TaintRoles(controlPlane, node ssh.Executor, cfg *config.Config, roles []string) error {
+	var taints []string
+	for _, role := range roles {
+		taints = append(taints, cfg.Roles[role].Taints...)
+	}
+	if len(taints) == 0 {
+		return nil
+	}
+
+	name, err := nodeName(node)
+	if err != nil {
+		return err
+	}
+	return runCommands(controlPlane, []string{fmt.Sprintf("kubectl taint node %s --overwrite %s", name, strings.Join(taints, " "))})
+}
+
+// nodeName returns the name the host behind node registered with
+func nodeName(node ssh.Executor) (string, error) {
+	output, err := node.ExecuteCommand("hostname")
+	if err != nil {
+		return "", fmt.Errorf("failed to read the node name: %v", err)
+	}
+	return strings.ToLower(strings.TrimSpace(output)), nil
+}
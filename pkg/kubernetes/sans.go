@@ -0,0 +1,271 @@
+package kubernetes
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/maarulav/k8s-setup/pkg/config"
+	"github.com/maarulav/k8s-setup/pkg/ssh"
+	"gopkg.in/yaml.v3"
+)
+
+// sansConfig is where AddCertSANs uploads the updated kubeadm configuration
+const sansConfig = "/root/kubeadm-sans.yaml"
+
+// apiServerCert is the serving certificate and key kubeadm issues the API
+// server, without extension
+const apiServerCert = "/etc/kubernetes/pki/apiserver"
+
+// waitAPIServer polls the API server until it is ready again, for up to
+// two minutes
+const waitAPIServer = "for i in $(seq 60); do kubectl get --raw=/readyz >/dev/null 2>&1 && exit 0; sleep 2; done; exit 1"
+
+// ControlPlane is a further control plane node and the address its API
+// server advertises
+type ControlPlane struct {
+	Address string
+	Client  ssh.Executor
+}
+
+// OtherControlPlanes returns the InternalIPs of the control plane nodes other
+// than the one behind client
+func OtherControlPlanes(client ssh.Executor) ([]string, error) {
+	output, err := ssh.Complete(client.ExecuteCommand(`kubectl get nodes -l node-role.kubernetes.io/control-plane -o jsonpath='{range .items[*]}{.metadata.name}{" "}{.status.addresses[?(@.type=="InternalIP")].address}{"\n"}{end}'`))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the control plane nodes: %v", err)
+	}
+	self, err := nodeName(client)
+	if err != nil {
+		return nil, err
+	}
+	var addresses []string
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || fields[0] == self {
+			continue
+		}
+		addresses = append(addresses, fields[1])
+	}
+	return addresses, nil
+}
+
+// AddCertSANs adds sans to the API server certificates of the control plane
+// at client and of the others. The certSANs stored in the cluster's kubeadm
+// configuration are extended, each certificate missing a name is issued
+// again from them and its API server restarted, and the configuration is
+// uploaded so upgrades keep the names. A new endpoint, host:port, becomes the
+// controlPlaneEndpoint and the server of the cluster-info joining nodes
+// discover the cluster from. It returns the names that were missing from a
+// certificate.
+func AddCertSANs(client ssh.Executor, others []ControlPlane, cfg *config.Config, sans []string, endpoint string) ([]string, error) {
+	text, err := ssh.Complete(client.ExecuteCommand("kubectl -n kube-system get configmap kubeadm-config -o jsonpath='{.data.ClusterConfiguration}'"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the kubeadm configuration: %v", err)
+	}
+	var clusterConfig map[string]interface{}
+	if err := yaml.Unmarshal([]byte(text), &clusterConfig); err != nil || clusterConfig == nil {
+		return nil, fmt.Errorf("failed to parse the kubeadm configuration: %v", err)
+	}
+	mergeCertSANs(clusterConfig, sans)
+	moved := endpoint != "" && clusterConfig["controlPlaneEndpoint"] != endpoint
+	if moved {
+		clusterConfig["controlPlaneEndpoint"] = endpoint
+	}
+
+	missing, err := reissueCert(client, clusterConfig, cfg.Kubernetes.AdvertiseAddress, sans)
+	if err != nil {
+		return nil, err
+	}
+	for _, cp := range others {
+		names, err := reissueCert(cp.Client, clusterConfig, cp.Address, sans)
+		if err != nil {
+			return nil, fmt.Errorf("control plane %s: %v", cp.Client.Host(), err)
+		}
+	names:
+		for _, name := range names {
+			for _, m := range missing {
+				if m == name {
+					continue names
+				}
+			}
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) == 0 && !moved {
+		return nil, nil
+	}
+
+	data, err := kubeadmDocuments(clusterConfig, "")
+	if err != nil {
+		return nil, err
+	}
+	if err := client.WriteFile(sansConfig, data, 0600); err != nil {
+		return nil, err
+	}
+	if err := runCommands(client, []string{fmt.Sprintf("kubeadm init phase upload-config kubeadm --config %s", sansConfig)}); err != nil {
+		return nil, err
+	}
+	if moved {
+		if err := updateClusterInfo(client, "https://"+endpoint); err != nil {
+			return nil, err
+		}
+	}
+	return missing, nil
+}
+
+// reissueCert issues the API server certificate of the control plane at
+// client again from clusterConfig when it lacks one of sans, and restarts the
+// API server. advertise is the address of the API server on the node, which
+// kubeadm would otherwise take from the default route. It returns the names
+// that were missing.
+func reissueCert(client ssh.Executor, clusterConfig map[string]interface{}, advertise string, sans []string) ([]string, error) {
+	cert, err := ssh.Complete(client.ExecuteCommand(fmt.Sprintf("openssl x509 -in %s.crt -noout -text", apiServerCert)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the API server certificate: %v", err)
+	}
+	missing := missingSANs(cert, sans)
+	if len(missing) == 0 {
+		return nil, nil
+	}
+
+	data, err := kubeadmDocuments(clusterConfig, advertise)
+	if err != nil {
+		return nil, err
+	}
+	if err := client.WriteFile(sansConfig, data, 0600); err != nil {
+		return nil, err
+	}
+
+	// kubeadm keeps an existing certificate, so it is moved aside first and
+	// restored when the new one cannot be issued
+	backup := fmt.Sprintf("mv %[1]s.crt %[1]s.crt.bak && mv %[1]s.key %[1]s.key.bak", apiServerCert)
+	if output, err := client.ExecuteCommand(backup); err != nil {
+		return nil, fmt.Errorf("failed to move the API server certificate aside: %v\nOutput: %s", err, output)
+	}
+	issue := fmt.Sprintf("kubeadm init phase certs apiserver --config %s", sansConfig)
+	if output, err := client.ExecuteCommand(issue); err != nil {
+		client.ExecuteCommand(fmt.Sprintf("mv %[1]s.crt.bak %[1]s.crt && mv %[1]s.key.bak %[1]s.key", apiServerCert))
+		return nil, fmt.Errorf("failed to issue the API server certificate, the old one was restored: %v\nOutput: %s", err, output)
+	}
+
+	err = runCommands(client, []string{
+		"crictl ps --name kube-apiserver -q | xargs -r crictl stop",
+		waitAPIServer,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return missing, nil
+}
+
+// kubeadmDocuments renders clusterConfig for kubeadm, preceded by an
+// InitConfiguration advertising advertise when set
+func kubeadmDocuments(clusterConfig map[string]interface{}, advertise string) ([]byte, error) {
+	data, err := yaml.Marshal(clusterConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to render kubeadm configuration: %v", err)
+	}
+	if advertise == "" {
+		return data, nil
+	}
+	apiVersion, _ := clusterConfig["apiVersion"].(string)
+	initConfig, err := yaml.Marshal(map[string]interface{}{
+		"apiVersion":       apiVersion,
+		"kind":             "InitConfiguration",
+		"localAPIEndpoint": map[string]string{"advertiseAddress": advertise},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to render kubeadm configuration: %v", err)
+	}
+	return append(append(initConfig, "---\n"...), data...), nil
+}
+
+// updateClusterInfo points the kubeconfig of the kube-public cluster-info
+// ConfigMap, which joining nodes discover the API server from, at server.
+// The bootstrap signer of the controller manager signs it again.
+func updateClusterInfo(client ssh.Executor, server string) error {
+	text, err := ssh.Complete(client.ExecuteCommand("kubectl -n kube-public get configmap cluster-info -o jsonpath='{.data.kubeconfig}'"))
+	if err != nil {
+		return fmt.Errorf("failed to read cluster-info: %v", err)
+	}
+	var info map[string]interface{}
+	if err := yaml.Unmarshal([]byte(text), &info); err != nil || info == nil {
+		return fmt.Errorf("failed to parse cluster-info: %v", err)
+	}
+	clusters, _ := info["clusters"].([]interface{})
+	for _, c := range clusters {
+		if entry, ok := c.(map[string]interface{}); ok {
+			if cluster, ok := entry["cluster"].(map[string]interface{}); ok {
+				cluster["server"] = server
+			}
+		}
+	}
+
+	data, err := yaml.Marshal(info)
+	if err != nil {
+		return fmt.Errorf("failed to render cluster-info: %v", err)
+	}
+	patch, err := json.Marshal(map[string]interface{}{"data": map[string]string{"kubeconfig": string(data)}})
+	if err != nil {
+		return fmt.Errorf("failed to render cluster-info: %v", err)
+	}
+	return runCommands(client, []string{"kubectl -n kube-public patch configmap cluster-info --type merge -p " + ssh.Quote(string(patch))})
+}
+
+// mergeCertSANs adds the names of sans missing from apiServer.certSANs of
+// a kubeadm ClusterConfiguration
+// mergeCertSANs adds the names of sans missing from apiServer.certSANs of
+// a kubeadm ClusterConfiguration
+func mergeCertSANs(clusterConfig map[string]interface{}, sans []string) {
+	apiServer, _ := clusterConfig["apiServer"].(map[string]interface{})
+	if apiServer == nil {
+		apiServer = map[string]interface{}{}
+		clusterConfig["apiServer"] = apiServer
+	}
+	existing, _ := apiServer["certSANs"].([]interface{})
+	known := map[string]bool{}
+	for _, san := range existing {
+		if s, ok := san.(string); ok {
+			known[s] = true
+		}
+	}
+	for _, san := range sans {
+		if !known[san] {
+			existing = append(existing, san)
+			known[san] = true
+		}
+	}
+	apiServer["certSANs"] = existing
+}
+
+// missingSANs returns the sans the subject alternative names of the
+// certificate in text, as printed by openssl x509 -text, do not contain
+func missingSANs(text string, sans []string) []string {
+	present := map[string]bool{}
+	for _, line := range strings.Split(text, "\n") {
+		for _, entry := range strings.Split(line, ",") {
+			entry = strings.TrimSpace(entry)
+			if name := strings.TrimPrefix(entry, "DNS:"); name != entry {
+				present[name] = true
+			} else if address := strings.TrimPrefix(entry, "IP Address:"); address != entry {
+				if ip := net.ParseIP(address); ip != nil {
+					present[ip.String()] = true
+				}
+			}
+		}
+	}
+
+	var missing []string
+	for _, san := range sans {
+		name := san
+		if ip := net.ParseIP(san); ip != nil {
+			name = ip.String()
+		}
+		if !present[name] {
+			missing = append(missing, san)
+		}
+	}
+	return missing
+}
@@ -0,0 +1,49 @@
+package kubernetes
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/maarulav/k8s-setup/pkg/config"
+	"github.com/maarulav/k8s-setup/pkg/ssh"
+)
+
+// InstallTrustedCAs adds the configured CA certificates to the system trust
+// store of the node and restarts containerd so image pulls use them
+func InstallTrustedCAs(client ssh.Executor, cfg *config.Config) error {
+	if len(cfg.TrustedCAs) == 0 {
+		return nil
+	}
+
+	// Debian and Ubuntu use update-ca-certificates, RHEL and friends update-ca-trust
+	dir, update := "/usr/local/share/ca-certificates", "update-ca-certificates"
+	if _, err := client.ExecuteCommand("command -v update-ca-certificates"); err != nil {
+		dir, update = "/etc/pki/ca-trust/source/anchors", "update-ca-trust extract"
+	}
+
+	for _, file := range cfg.TrustedCAs {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return fmt.Errorf("failed to read trusted CA: %v", err)
+		}
+		if !strings.Contains(string(data), "-----BEGIN CERTIFICATE-----") {
+			return fmt.Errorf("trusted CA %s is not a PEM certificate", file)
+		}
+
+		// update-ca-certificates only picks up files ending in .crt
+		name := "k8s-setup-" + strings.TrimSuffix(filepath.Base(file), filepath.Ext(file)) + ".crt"
+		if err := client.WriteFile(dir+"/"+name, data, 0644); err != nil {
+			return err
+		}
+	}
+
+	for _, cmd := range []string{update, "systemctl restart containerd"} {
+		if output, err := client.ExecuteCommand(cmd); err != nil {
+			return fmt.Errorf("failed to execute command '%s': %v\nOutput: %s", cmd, err, output)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,87 @@
+package kubernetes
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/maarulav/k8s-setup/pkg/config"
+	"github.com/maarulav/k8s-setup/pkg/ssh"
+)
+
+const (
+	// PlatformPriorityClass is assigned to cluster add-ons installed by this tool
+	PlatformPriorityClass = "platform"
+	// UserPriorityClass is the default priority class for user workloads
+	UserPriorityClass = "user"
+)
+
+// tuneSystemComponents applies configured requests and limits to CoreDNS and the CNI
+func tuneSystemComponents(client ssh.Executor, cfg *config.Config) error {
+	cni := cniFor(cfg)
+	targets := []struct {
+		namespace string
+		resource  string
+		container string
+		spec      config.ResourceRequirements
+	}{
+		{"kube-system", "deployment/coredns", "coredns", cfg.Components.CoreDNS},
+		{cni.namespace, "daemonset/" + cni.daemonSet, cni.container, cfg.Components.CNI},
+	}
+
+	for _, t := range targets {
+		if t.spec.IsEmpty() {
+			continue
+		}
+
+		cmd := fmt.Sprintf("kubectl -n %s set resources %s -c %s", t.namespace, t.resource, t.container)
+		if len(t.spec.Requests) > 0 {
+			cmd += " --requests=" + joinResources(t.spec.Requests)
+		}
+		if len(t.spec.Limits) > 0 {
+			cmd += " --limits=" + joinResources(t.spec.Limits)
+		}
+
+		if output, err := client.ExecuteCommand(cmd); err != nil {
+			return fmt.Errorf("failed to execute command '%s': %v\nOutput: %s", cmd, err, output)
+		}
+	}
+
+	return nil
+}
+
+// setupPriorityClasses creates the platform and user workload priority classes
+func setupPriorityClasses(client ssh.Executor) error {
+	return Apply(client, "priority-classes", []map[string]interface{}{
+		{
+			"apiVersion":    "scheduling.k8s.io/v1",
+			"kind":          "PriorityClass",
+			"metadata":      map[string]interface{}{"name": PlatformPriorityClass},
+			"value":         1000000,
+			"globalDefault": false,
+			"description":   "Cluster add-ons such as monitoring and ingress",
+		},
+		{
+			"apiVersion":    "scheduling.k8s.io/v1",
+			"kind":          "PriorityClass",
+			"metadata":      map[string]interface{}{"name": UserPriorityClass},
+			"value":         1000,
+			"globalDefault": true,
+			"description":   "Default priority for user workloads",
+		},
+	})
+}
+
+func joinResources(resources map[string]string) string {
+	keys := make([]string, 0, len(resources))
+	for k := range resources {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		pairs = append(pairs, k+"="+resources[k])
+	}
+	return strings.Join(pairs, ",")
+}
@@ -0,0 +1,165 @@
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/maarulav/k8s-setup/pkg/config"
+	"github.com/maarulav/k8s-setup/pkg/kube"
+	"github.com/maarulav/k8s-setup/pkg/ssh"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// SmokeNamespace holds the probe workload of the upgrade smoke test
+const SmokeNamespace = "k8s-setup-smoke"
+
+// KubeadmVersion turns a package version such as 1.28.2-00 into v1.28.2
+func KubeadmVersion(version string) string {
+	return "v" + strings.SplitN(version, "-", 2)[0]
+}
+
+// UpgradeControlPlane upgrades kubeadm, the control plane components and the
+// kubelet of the control plane node to the configured version
+func UpgradeControlPlane(client ssh.Executor, cfg *config.Config) error {
+	version := cfg.Kubernetes.Version
+	return runCommands(client, []string{
+		fmt.Sprintf("apt-get update && apt-get install -y kubeadm=%s", version),
+		fmt.Sprintf("kubeadm upgrade apply -y %s", KubeadmVersion(version)),
+		fmt.Sprintf("apt-get install -y kubelet=%s kubectl=%s", version, version),
+		"systemctl daemon-reload && systemctl restart kubelet",
+	})
+}
+
+// UpgradeWorker drains node through the control plane, upgrades its packages
+// and kubelet configuration, and uncordons it again. A node whose upgrade
+// failed is left cordoned.
+func UpgradeWorker(controlPlane, worker ssh.Executor, node string, cfg *config.Config) error {
+	version := cfg.Kubernetes.Version
+
+	if err := Drain(controlPlane, node, DrainOptions{}); err != nil {
+		return err
+	}
+
+	err := runCommands(worker, []string{
+		fmt.Sprintf("apt-get update && apt-get install -y kubeadm=%s", version),
+		"kubeadm upgrade node",
+		fmt.Sprintf("apt-get install -y kubelet=%s kubectl=%s", version, version),
+		"systemctl daemon-reload && systemctl restart kubelet",
+	})
+	if err != nil {
+		return fmt.Errorf("upgrade of node %s failed, it stays cordoned: %v", node, err)
+	}
+
+	return Uncordon(controlPlane, node)
+}
+
+// DrainOptions change how Drain empties a node
+type DrainOptions struct {
+	// Timeout bounds the drain, 5 minutes when zero
+	Timeout time.Duration
+
+	// Force also deletes pods no controller manages
+	Force bool
+
+	// DisableEviction deletes the pods instead of evicting them, bypassing
+	// their pod disruption budgets
+	DisableEviction bool
+}
+
+// Drain cordons node and evicts its pods through the control plane
+func Drain(controlPlane ssh.Executor, node string, opts DrainOptions) error {
+	timeout := opts.Timeout
+	if timeout == 0 {
+		timeout = 5 * time.Minute
+	}
+	drain := fmt.Sprintf("kubectl drain %s --ignore-daemonsets --delete-emptydir-data --timeout=%ds", node, int(timeout.Seconds()))
+	if opts.Force {
+		drain += " --force"
+	}
+	if opts.DisableEviction {
+		drain += " --disable-eviction"
+	}
+	if output, err := controlPlane.ExecuteCommand(drain); err != nil {
+		return fmt.Errorf("failed to drain node %s: %v\nOutput: %s", node, err, output)
+	}
+	return nil
+}
+
+// Uncordon makes node schedulable again
+func Uncordon(controlPlane ssh.Executor, node string) error {
+	uncordon := fmt.Sprintf("kubectl uncordon %s", node)
+	if output, err := controlPlane.ExecuteCommand(uncordon); err != nil {
+		return fmt.Errorf("failed to uncordon node %s: %v\nOutput: %s", node, err, output)
+	}
+	return nil
+}
+
+// SmokeTest checks an upgraded node: it must be Ready on the configured
+// version, the pods on it must be Ready again and a probe deployment pinned
+// to it must become available
+func SmokeTest(controlPlane ssh.Executor, node string, cfg *config.Config) error {
+	kc, err := kube.NewClient(controlPlane)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), kube.DefaultTimeout)
+	defer cancel()
+
+	if err := kube.WaitForNodeReady(ctx, kc, node, kube.Print); err != nil {
+		return fmt.Errorf("node %s is not Ready: %v", node, err)
+	}
+
+	n, err := kc.CoreV1().Nodes().Get(ctx, node, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	if want := KubeadmVersion(cfg.Kubernetes.Version); n.Status.NodeInfo.KubeletVersion != want {
+		return fmt.Errorf("node %s runs kubelet %s, expected %s", node, n.Status.NodeInfo.KubeletVersion, want)
+	}
+
+	if err := kube.WaitForNodePods(ctx, kc, node, kube.Print); err != nil {
+		return fmt.Errorf("workloads on node %s did not recover: %v", node, err)
+	}
+
+	labels := map[string]interface{}{"app": "smoke"}
+	err = Apply(controlPlane, "smoke-"+node, []map[string]interface{}{
+		{
+			"apiVersion": "v1",
+			"kind":       "Namespace",
+			"metadata":   map[string]interface{}{"name": SmokeNamespace},
+		},
+		{
+			"apiVersion": "apps/v1",
+			"kind":       "Deployment",
+			"metadata":   map[string]interface{}{"name": node, "namespace": SmokeNamespace, "labels": labels},
+			"spec": map[string]interface{}{
+				"replicas": 1,
+				"selector": map[string]interface{}{"matchLabels": labels},
+				"template": map[string]interface{}{
+					"metadata": map[string]interface{}{"labels": labels},
+					"spec": map[string]interface{}{
+						"nodeSelector": map[string]interface{}{"kubernetes.io/hostname": node},
+						"containers": []map[string]interface{}{
+							{
+								"name":           "nginx",
+								"image":          "nginx:stable-alpine",
+								"readinessProbe": map[string]interface{}{"httpGet": map[string]interface{}{"path": "/", "port": 80}},
+							},
+						},
+					},
+				},
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	defer controlPlane.ExecuteCommand(fmt.Sprintf("kubectl delete deployment %s -n %s --ignore-not-found", node, SmokeNamespace))
+
+	if err := kube.WaitForDeploymentAvailable(ctx, kc, SmokeNamespace, node, kube.Print); err != nil {
+		return fmt.Errorf("probe workload on node %s did not start: %v", node, err)
+	}
+	return nil
+}
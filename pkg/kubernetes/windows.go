@@ -0,0 +1,104 @@
+package kubernetes
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/maarulav/k8s-setup/pkg/config"
+	"github.com/maarulav/k8s-setup/pkg/ssh"
+)
+
+const (
+	// sigWindowsTools hosts the HostProcess scripts and manifests for Windows nodes
+	sigWindowsTools = "https://raw.githubusercontent.com/kubernetes-sigs/sig-windows-tools/master/hostprocess"
+
+	windowsDir = `C:\k8s-setup`
+
+	// WindowsCRISocket is the containerd endpoint kubeadm join needs on Windows
+	WindowsCRISocket = "npipe:////./pipe/containerd-containerd"
+
+	defaultWindowsContainerd = "1.7.13"
+	defaultWindowsFlannel    = "v0.21.5"
+
+	// Windows only supports the VXLAN network and port that flannel uses there
+	windowsFlannelVNI  = 4096
+	windowsFlannelPort = 4789
+)
+
+// powershell runs script through PowerShell, since the default shell of the
+// Windows OpenSSH server is cmd.exe. The script must not contain double quotes.
+func powershell(script string) string {
+	return `powershell -NoProfile -NonInteractive -ExecutionPolicy Bypass -Command "$ProgressPreference='SilentlyContinue'; ` + script + `"`
+}
+
+// SetupWindowsNetworking prepares the cluster for Windows workers: flannel is
+// switched to the VXLAN settings Windows supports and the Windows flannel
+// and kube-proxy DaemonSets are installed. Only flannel is supported.
+func SetupWindowsNetworking(client ssh.Executor, cfg *config.Config) error {
+	if cniFor(cfg).name != CNIFlannel {
+		return fmt.Errorf("windows workers need the flannel CNI, the cluster uses %s", cniFor(cfg).name)
+	}
+
+	podCIDR, _ := splitCIDRs(podCIDRs(cfg))
+	netConf, err := json.Marshal(map[string]interface{}{
+		"Network": podCIDR,
+		"Backend": map[string]interface{}{"Type": "vxlan", "VNI": windowsFlannelVNI, "Port": windowsFlannelPort},
+	})
+	if err != nil {
+		return err
+	}
+	patch, err := json.Marshal(map[string]interface{}{
+		"data": map[string]string{"net-conf.json": string(netConf)},
+	})
+	if err != nil {
+		return err
+	}
+
+	flannel := cfg.Windows.FlannelVersion
+	if flannel == "" {
+		flannel = defaultWindowsFlannel
+	}
+	return runCommands(client, []string{
+		fmt.Sprintf("kubectl -n kube-flannel patch configmap kube-flannel-cfg --type merge -p '%s'", patch),
+		"kubectl -n kube-flannel rollout restart daemonset kube-flannel-ds && kubectl -n kube-flannel rollout status daemonset kube-flannel-ds --timeout=300s",
+		fmt.Sprintf("curl -fsSL %s/flannel/flanneld/flannel-overlay.yml | sed 's/FLANNEL_VERSION/%s/g' | kubectl apply -f -", sigWindowsTools, flannel),
+		fmt.Sprintf("curl -fsSL %s/flannel/kube-proxy/kube-proxy.yml | sed 's/KUBE_PROXY_VERSION/%s/g' | kubectl apply -f -", sigWindowsTools, KubeadmVersion(cfg.Kubernetes.Version)),
+	})
+}
+
+// PrepareWindowsNode installs containerd and the kubelet and kubeadm on a
+// Windows Server worker reached through its OpenSSH server. The Containers
+// feature needs a reboot when it is first enabled; the node has to be
+// restarted and the join run again then.
+func PrepareWindowsNode(client ssh.Executor, cfg *config.Config) error {
+	feature := powershell("(Install-WindowsFeature -Name Containers).RestartNeeded")
+	output, err := client.ExecuteCommand(feature)
+	if err != nil {
+		return fmt.Errorf("failed to enable the Containers feature: %v\nOutput: %s", err, output)
+	}
+	if strings.TrimSpace(output) == "Yes" {
+		return fmt.Errorf("the Containers feature was enabled, restart %s and join it again", client.Host())
+	}
+
+	containerd := cfg.Windows.ContainerdVersion
+	if containerd == "" {
+		containerd = defaultWindowsContainerd
+	}
+	download := func(script string) string {
+		return powershell(fmt.Sprintf(`New-Item -ItemType Directory -Force -Path %s | Out-Null; Invoke-WebRequest -UseBasicParsing %s/%s -OutFile %s\%s`,
+			windowsDir, sigWindowsTools, script, windowsDir, script))
+	}
+	return runCommands(client, []string{
+		download("Install-Containerd.ps1"),
+		powershell(fmt.Sprintf(`%s\Install-Containerd.ps1 -ContainerDVersion %s`, windowsDir, containerd)),
+		download("PrepareNode.ps1"),
+		powershell(fmt.Sprintf(`%s\PrepareNode.ps1 -KubernetesVersion %s`, windowsDir, KubeadmVersion(cfg.Kubernetes.Version))),
+	})
+}
+
+// WindowsJoinCommand turns a join command into its PowerShell form for a
+// Windows worker running containerd
+func WindowsJoinCommand(join string) string {
+	return powershell(join + " --cri-socket " + WindowsCRISocket)
+}
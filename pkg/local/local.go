@@ -0,0 +1,126 @@
+package local
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/maarulav/k8s-setup/internal/tracing"
+)
+
+// remoteHome is the remote working directory the setup steps write to. Locally
+// it is mapped into the executor's directory.
+const remoteHome = "/root/"
+
+// remotePath matches remoteHome where a shell word or the value of an
+// option starts
+var remotePath = regexp.MustCompile(`(^|[\s"'=<>;|&(` + "`" + `])` + regexp.QuoteMeta(remoteHome))
+
+// Executor runs the kubectl steps on the operator machine against the
+// cluster's kubeconfig instead of over SSH
+type Executor struct {
+	IP             string
+	KubeconfigPath string
+
+	// Dir stands in for /root on the host: manifests and backups are kept there
+	Dir string
+
+	traceCtx   context.Context
+	commandLog func(command string)
+}
+
+// New returns an executor for the cluster at ip. kubectl must be installed
+// locally.
+func New(ip, kubeconfig, dir string) (*Executor, error) {
+	if _, err := exec.LookPath("kubectl"); err != nil {
+		return nil, fmt.Errorf("local cluster access needs kubectl on this machine: %v", err)
+	}
+
+	kubeconfig, err := filepath.Abs(kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(kubeconfig); err != nil {
+		return nil, fmt.Errorf("kubeconfig for %s not available: %v", ip, err)
+	}
+
+	dir, err = filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create %s: %v", dir, err)
+	}
+
+	return &Executor{IP: ip, KubeconfigPath: kubeconfig, Dir: dir}, nil
+}
+
+// Host returns the IP of the cluster
+func (e *Executor) Host() string {
+	return e.IP
+}
+
+// Kubeconfig returns the cluster's kubeconfig
+func (e *Executor) Kubeconfig() ([]byte, error) {
+	return ioutil.ReadFile(e.KubeconfigPath)
+}
+
+// Dial connects to addr directly from this machine
+func (e *Executor) Dial(network, addr string) (net.Conn, error) {
+	return net.Dial(network, addr)
+}
+
+// SetTraceContext makes the span in ctx the parent of the command spans
+func (e *Executor) SetTraceContext(ctx context.Context) {
+	e.traceCtx = ctx
+}
+
+// SetCommandLog passes every following command to log before it runs
+func (e *Executor) SetCommandLog(log func(command string)) {
+	e.commandLog = log
+}
+
+// ExecuteCommand runs a command in a local shell with KUBECONFIG pointing at the cluster
+func (e *Executor) ExecuteCommand(command string) (string, error) {
+	if e.commandLog != nil {
+		e.commandLog(command)
+	}
+	span := tracing.Command(e.traceCtx, e.IP, command)
+	cmd := exec.Command("bash", "-c", e.localize(command))
+	cmd.Env = append(os.Environ(), "KUBECONFIG="+e.KubeconfigPath)
+
+	output, err := cmd.CombinedOutput()
+	tracing.End(span, err)
+	if err != nil {
+		return string(output), fmt.Errorf("command failed: %v", err)
+	}
+
+	return string(output), nil
+}
+
+// WriteFile writes data to the local counterpart of a remote path
+func (e *Executor) WriteFile(path string, data []byte, mode os.FileMode) error {
+	path = e.localize(path)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("failed to write %s: %v", path, err)
+	}
+	if err := ioutil.WriteFile(path, data, mode.Perm()); err != nil {
+		return fmt.Errorf("failed to write %s: %v", path, err)
+	}
+	return nil
+}
+
+// localize maps remote working paths into Dir. Only paths starting with
+// remoteHome at the start of a word are mapped, e.g. "/root/x.yaml" or
+// "-f=/root/x.yaml", not "/home/root/x" or "https://host/root/x".
+func (e *Executor) localize(s string) string {
+	return remotePath.ReplaceAllStringFunc(s, func(match string) string {
+		return strings.TrimSuffix(match, remoteHome) + e.Dir + "/"
+	})
+}
@@ -0,0 +1,25 @@
+package local
+
+import "testing"
+
+func TestLocalize(t *testing.T) {
+	e := &Executor{Dir: "/work/$HOME/10.0.0.1"}
+	for _, tt := range []struct {
+		command, want string
+	}{
+		{"/root/manifests/app.yaml", "/work/$HOME/10.0.0.1/manifests/app.yaml"},
+		{"kubectl apply -f /root/app.yaml", "kubectl apply -f /work/$HOME/10.0.0.1/app.yaml"},
+		{"helm upgrade --values=/root/values.yaml x", "helm upgrade --values=/work/$HOME/10.0.0.1/values.yaml x"},
+		{`cat > "/root/a b.yaml" && tar -C '/root/backup' -xf x`, `cat > "/work/$HOME/10.0.0.1/a b.yaml" && tar -C '/work/$HOME/10.0.0.1/backup' -xf x`},
+		{"kubectl apply -f /root/a.yaml;kubectl apply -f /root/b.yaml", "kubectl apply -f /work/$HOME/10.0.0.1/a.yaml;kubectl apply -f /work/$HOME/10.0.0.1/b.yaml"},
+		{"(cd /root/charts)", "(cd /work/$HOME/10.0.0.1/charts)"},
+		// Not a path of the remote working directory
+		{"ls /home/root/x /var/root/", "ls /home/root/x /var/root/"},
+		{"curl -fsSL https://example.com/root/install.sh", "curl -fsSL https://example.com/root/install.sh"},
+		{"kubectl get pods -n root", "kubectl get pods -n root"},
+	} {
+		if got := e.localize(tt.command); got != tt.want {
+			t.Errorf("localize(%q) = %q, want %q", tt.command, got, tt.want)
+		}
+	}
+}
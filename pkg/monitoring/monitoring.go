@@ -1,81 +1,565 @@
 package monitoring
 
 import (
+	"context"
 	"fmt"
-	"io/ioutil"
+	"net"
+	"strings"
 
 	"github.com/maarulav/k8s-setup/pkg/config"
+	"github.com/maarulav/k8s-setup/pkg/helm"
+	"github.com/maarulav/k8s-setup/pkg/kube"
+	"github.com/maarulav/k8s-setup/pkg/kubernetes"
 	"github.com/maarulav/k8s-setup/pkg/ssh"
 )
 
-// Setup sets up monitoring stack on the remote server
-func Setup(client *ssh.Client, config *config.Config) error {
-	// Create monitoring namespace
-	if _, err := client.ExecuteCommand("kubectl create namespace monitoring"); err != nil {
-		return fmt.Errorf("failed to create monitoring namespace: %v", err)
-	}
+const (
+	// ModeFederate makes the hub Prometheus scrape each spoke's /federate endpoint
+	ModeFederate = "federate"
+	// ModeRemoteWrite makes each spoke Prometheus push samples to the hub
+	ModeRemoteWrite = "remoteWrite"
 
-	// Install Helm
-	helmCommands := []string{
-		"curl https://raw.githubusercontent.com/helm/helm/master/scripts/get-helm-3 | bash",
-		"helm repo add prometheus-community https://prometheus-community.github.io/helm-charts",
-		"helm repo update",
-	}
+	defaultNodePort = 30090
 
-	for _, cmd := range helmCommands {
-		if _, err := client.ExecuteCommand(cmd); err != nil {
-			return fmt.Errorf("failed to setup Helm: %v", err)
+	// grafanaTLSSecret holds the certificate of the Grafana Ingress
+	grafanaTLSSecret = "grafana-tls"
+)
+
+// Setup sets up monitoring stack on the remote server. clusters lists every
+// cluster provisioned in this run and is used to wire up federation.
+func Setup(client ssh.Executor, config *config.Config, clusters []string) error {
+	if err := validateFederation(config); err != nil {
+		return err
+	}
+	if oauth := config.Monitoring.Grafana.OAuth; oauth != nil {
+		if _, err := oauth.Section(); err != nil {
+			return err
 		}
 	}
+	if config.Monitoring.Grafana.Domain != "" && !isSpoke(config, client.Host()) && grafanaIssuer(config) == "" {
+		fmt.Printf("Warning: no clusterIssuer for Grafana, the Ingress of %s serves the default certificate until Secret monitoring/%s exists\n", config.Monitoring.Grafana.Domain, grafanaTLSSecret)
+	}
+	rules, err := alertRules(config.Monitoring.Alerts)
+	if err != nil {
+		return err
+	}
+	scoped := config.Monitoring.Scope.Enabled()
+	if scoped {
+		// The rules are about nodes and control plane components, whose
+		// metrics a scoped stack does not collect
+		rules = nil
 
-	// Create Prometheus values file
-	prometheusValues := fmt.Sprintf(`
-prometheus:
-  prometheusSpec:
-    retention: %s
-    storageSpec:
-      volumeClaimTemplate:
-        spec:
-          storageClassName: %s
-          accessModes: ["ReadWriteOnce"]
-          resources:
-            requests:
-              storage: 10Gi
-`, config.Monitoring.Prometheus.RetentionTime, config.Monitoring.Prometheus.StorageClass)
+		// Without cluster-wide RBAC from the chart, the operator and
+		// Prometheus get Roles in the namespaces they watch
+		if err := kubernetes.Apply(client, "monitoring-roles", scopeRoles(config.Monitoring.Scope)); err != nil {
+			return fmt.Errorf("failed to create monitoring roles: %v", err)
+		}
+	}
 
-	if err := ioutil.WriteFile("prometheus-values.yaml", []byte(prometheusValues), 0644); err != nil {
-		return fmt.Errorf("failed to create Prometheus values file: %v", err)
+	// The UID of kube-system identifies the cluster for as long as it exists
+	clusterID, err := client.ExecuteCommand("kubectl get namespace kube-system -o jsonpath='{.metadata.uid}'")
+	if err != nil {
+		clusterID = ""
 	}
 
-	// Install Prometheus stack
-	installCmd := fmt.Sprintf("helm install prometheus prometheus-community/kube-prometheus-stack -f prometheus-values.yaml --namespace monitoring")
-	if _, err := client.ExecuteCommand(installCmd); err != nil {
+	// Install Prometheus stack, creating the monitoring namespace unless scoped
+	err = helm.Install(client, helm.Chart{
+		Release:    "prometheus",
+		RepoURL:    "https://prometheus-community.github.io/helm-charts",
+		Chart:      "kube-prometheus-stack",
+		Version:    config.Monitoring.Prometheus.ChartVersion,
+		Namespace:  "monitoring",
+		Values:     renderValues(config, client.Host(), clusters, strings.TrimSpace(clusterID)),
+		Namespaced: scoped,
+	})
+	if err != nil {
 		return fmt.Errorf("failed to install Prometheus stack: %v", err)
 	}
 
-	// Configure Grafana
-	grafanaCommands := []string{
-		fmt.Sprintf("kubectl create secret generic grafana-admin --from-literal=admin-password=%s -n monitoring", config.Monitoring.Grafana.AdminPassword),
-		"kubectl patch deployment prometheus-grafana -n monitoring --type=json -p='[{\"op\": \"add\", \"path\": \"/spec/template/spec/containers/0/env/0\", \"value\": {\"name\": \"GF_SECURITY_ADMIN_PASSWORD\", \"valueFrom\": {\"secretKeyRef\": {\"name\": \"grafana-admin\", \"key\": \"admin-password\"}}}}]'",
+	// The rules need the PrometheusRule CRD installed by the chart
+	if rules != nil {
+		err = kubernetes.Apply(client, rulesName, []map[string]interface{}{rules})
+	} else {
+		_, err = client.ExecuteCommand(fmt.Sprintf("kubectl delete prometheusrule %s -n monitoring --ignore-not-found", rulesName))
 	}
+	if err != nil {
+		return fmt.Errorf("failed to install alert rules: %v", err)
+	}
+
+	// Spokes in a federation do not run Grafana
+	withGrafana := !isSpoke(config, client.Host())
+
+	// Configure Grafana
+	if withGrafana {
+		grafanaCommands := []string{
+			fmt.Sprintf("kubectl create secret generic grafana-admin --from-literal=admin-password=%s -n monitoring", config.Monitoring.Grafana.AdminPassword),
+			"kubectl patch deployment prometheus-grafana -n monitoring --type=json -p='[{\"op\": \"add\", \"path\": \"/spec/template/spec/containers/0/env/0\", \"value\": {\"name\": \"GF_SECURITY_ADMIN_PASSWORD\", \"valueFrom\": {\"secretKeyRef\": {\"name\": \"grafana-admin\", \"key\": \"admin-password\"}}}}]'",
+		}
 
-	for _, cmd := range grafanaCommands {
-		if _, err := client.ExecuteCommand(cmd); err != nil {
-			return fmt.Errorf("failed to configure Grafana: %v", err)
+		for _, cmd := range grafanaCommands {
+			if _, err := client.ExecuteCommand(cmd); err != nil {
+				return fmt.Errorf("failed to configure Grafana: %v", err)
+			}
 		}
 	}
 
 	// Wait for pods to be ready
-	waitCommands := []string{
-		"kubectl wait --for=condition=ready pod -l app.kubernetes.io/name=prometheus -n monitoring --timeout=300s",
-		"kubectl wait --for=condition=ready pod -l app.kubernetes.io/name=grafana -n monitoring --timeout=300s",
+	selectors := []string{"app.kubernetes.io/name=prometheus"}
+	if withGrafana {
+		selectors = append(selectors, "app.kubernetes.io/name=grafana")
 	}
 
-	for _, cmd := range waitCommands {
-		if _, err := client.ExecuteCommand(cmd); err != nil {
+	kc, err := kube.NewClient(client)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), kube.DefaultTimeout)
+	defer cancel()
+	for _, selector := range selectors {
+		if err := kube.WaitForPodsBySelector(ctx, kc, "monitoring", selector, kube.Print); err != nil {
 			return fmt.Errorf("failed to wait for pods: %v", err)
 		}
 	}
 
 	return nil
 }
+
+// GrafanaURL returns the external address of Grafana on the cluster at ip,
+// "" when it has no domain. ok is false when Grafana does not run there.
+func GrafanaURL(config *config.Config, ip string) (url string, ok bool) {
+	if isSpoke(config, ip) {
+		return "", false
+	}
+	if config.Monitoring.Grafana.Domain == "" {
+		return "", true
+	}
+	return "https://" + config.Monitoring.Grafana.Domain, true
+}
+
+// PrometheusURL returns the address Prometheus on the cluster at ip is
+// exposed on, "" when it is only reachable inside the cluster
+func PrometheusURL(config *config.Config, ip string) string {
+	if !config.Monitoring.Federation.Enabled {
+		return ""
+	}
+	return fmt.Sprintf("http://%s:%d", ip, nodePort(config))
+}
+
+// renderValues builds the kube-prometheus-stack values for the given cluster.
+// clusterID is added to the external labels when it is known.
+func renderValues(config *config.Config, ip string, clusters []string, clusterID string) map[string]interface{} {
+	prometheusSpec := map[string]interface{}{
+		"retention": config.Monitoring.Prometheus.RetentionTime,
+		"storageSpec": map[string]interface{}{
+			"volumeClaimTemplate": map[string]interface{}{
+				"spec": map[string]interface{}{
+					"storageClassName": config.Monitoring.Prometheus.StorageClass,
+					"accessModes":      []string{"ReadWriteOnce"},
+					"resources": map[string]interface{}{
+						"requests": map[string]interface{}{
+							"storage": "10Gi",
+						},
+					},
+				},
+			},
+		},
+	}
+	if size := config.Monitoring.Prometheus.RetentionSize; size != "" {
+		prometheusSpec["retentionSize"] = size
+	}
+	if compression := config.Monitoring.Prometheus.WALCompression; compression != nil {
+		prometheusSpec["walCompression"] = *compression
+	}
+	if interval := config.Monitoring.Prometheus.ScrapeInterval; interval != "" {
+		prometheusSpec["scrapeInterval"] = interval
+	}
+	prometheus := map[string]interface{}{
+		"prometheusSpec": prometheusSpec,
+	}
+	values := map[string]interface{}{
+		"prometheus": prometheus,
+	}
+	if labels := externalLabels(config, ip, clusterID); len(labels) > 0 {
+		prometheusSpec["externalLabels"] = labels
+	}
+	applyComponentSettings(config, values)
+	applyMetricFilter(values, config.Monitoring.Cardinality)
+	if config.Monitoring.Scope.Enabled() {
+		applyScope(values, config.Monitoring.Scope)
+	}
+	if !isSpoke(config, ip) {
+		if config.Monitoring.Grafana.Domain != "" {
+			applyGrafanaDomain(values, config)
+		}
+		if oauth := config.Monitoring.Grafana.OAuth; oauth != nil {
+			applyGrafanaOAuth(values, *oauth)
+		}
+	}
+
+	federation := config.Monitoring.Federation
+	if !federation.Enabled {
+		return values
+	}
+
+	port := nodePort(config)
+	prometheus["service"] = map[string]interface{}{
+		"type":     "NodePort",
+		"nodePort": port,
+	}
+	if isSpoke(config, ip) {
+		section(values, "grafana")["enabled"] = false
+		if federation.Mode == ModeRemoteWrite {
+			prometheusSpec["remoteWrite"] = []map[string]interface{}{
+				{"url": fmt.Sprintf("http://%s:%d/api/v1/write", federation.Hub, port)},
+			}
+		}
+		return values
+	}
+
+	var scrapeConfigs []map[string]interface{}
+	var dataSources []map[string]interface{}
+	for _, spoke := range spokes(config, clusters) {
+		target := fmt.Sprintf("%s:%d", spoke, port)
+		if federation.Mode != ModeRemoteWrite {
+			scrapeConfigs = append(scrapeConfigs, map[string]interface{}{
+				"job_name":     "federate-" + spoke,
+				"honor_labels": true,
+				"metrics_path": "/federate",
+				"params": map[string]interface{}{
+					"match[]": []string{`{job=~".+"}`},
+				},
+				"static_configs": []map[string]interface{}{
+					{"targets": []string{target}},
+				},
+			})
+		}
+		dataSources = append(dataSources, map[string]interface{}{
+			"name":   "Prometheus " + config.ClusterName(spoke),
+			"type":   "prometheus",
+			"access": "proxy",
+			"url":    "http://" + target,
+		})
+	}
+
+	if federation.Mode == ModeRemoteWrite {
+		prometheusSpec["enableRemoteWriteReceiver"] = true
+	} else if len(scrapeConfigs) > 0 {
+		prometheusSpec["additionalScrapeConfigs"] = scrapeConfigs
+	}
+	if len(dataSources) > 0 {
+		section(values, "grafana")["additionalDataSources"] = dataSources
+	}
+
+	return values
+}
+
+// applyComponentSettings sets resources and priority classes for each chart component
+func applyComponentSettings(cfg *config.Config, values map[string]interface{}) {
+	components := []struct {
+		path      []string
+		resources config.ResourceRequirements
+	}{
+		{[]string{"prometheus", "prometheusSpec"}, cfg.Components.Prometheus},
+		{[]string{"alertmanager", "alertmanagerSpec"}, cfg.Components.Alertmanager},
+		{[]string{"grafana"}, cfg.Components.Grafana},
+		{[]string{"prometheusOperator"}, cfg.Components.PrometheusOperator},
+		{[]string{"kube-state-metrics"}, cfg.Components.KubeStateMetrics},
+		{[]string{"prometheus-node-exporter"}, cfg.Components.NodeExporter},
+	}
+
+	for _, c := range components {
+		target := section(values, c.path...)
+		if !c.resources.IsEmpty() {
+			target["resources"] = c.resources
+		}
+		if cfg.PriorityClasses {
+			target["priorityClassName"] = kubernetes.PlatformPriorityClass
+		}
+	}
+}
+
+// cAdvisorDrops are the chart's default cAdvisor relabelings, which setting
+// the relabelings replaces
+var cAdvisorDrops = []map[string]interface{}{
+	dropMetrics(`container_cpu_(cfs_throttled_seconds_total|load_average_10s|system_seconds_total|user_seconds_total)`),
+	dropMetrics(`container_fs_(io_current|io_time_seconds_total|io_time_weighted_seconds_total|reads_merged_total|sector_reads_total|sector_writes_total|writes_merged_total)`),
+	dropMetrics(`container_memory_(mapped_file|swap)`),
+	dropMetrics(`container_(file_descriptors|tasks_state|threads_max)`),
+	dropMetrics(`container_spec.*`),
+	{"sourceLabels": []string{"id", "pod"}, "action": "drop", "regex": ".+;"},
+}
+
+// dropMetrics is a relabeling dropping the series whose name matches regex
+func dropMetrics(regex string) map[string]interface{} {
+	return map[string]interface{}{"sourceLabels": []string{"__name__"}, "action": "drop", "regex": regex}
+}
+
+// applyMetricFilter drops the configured metrics from the kubelet, cAdvisor
+// and kube-state-metrics scrapes and limits what kube-state-metrics exports
+func applyMetricFilter(values map[string]interface{}, filter config.MetricFilter) {
+	if len(filter.DropMetrics) > 0 {
+		drop := dropMetrics(strings.Join(filter.DropMetrics, "|"))
+		kubelet := section(values, "kubelet", "serviceMonitor")
+		kubelet["cAdvisorMetricRelabelings"] = append(append([]map[string]interface{}{}, cAdvisorDrops...), drop)
+		kubelet["metricRelabelings"] = []map[string]interface{}{drop}
+		section(values, "kube-state-metrics", "prometheus", "monitor")["metricRelabelings"] = []map[string]interface{}{drop}
+	}
+	if len(filter.PodLabels) > 0 {
+		section(values, "kube-state-metrics")["metricLabelsAllowlist"] = []string{
+			fmt.Sprintf("pods=[%s]", strings.Join(filter.PodLabels, ",")),
+		}
+	}
+	if len(filter.KubeStateMetricsDenylist) > 0 {
+		section(values, "kube-state-metrics")["metricDenylist"] = filter.KubeStateMetricsDenylist
+	}
+}
+
+// externalLabels returns the labels that tell the series of the cluster at
+// ip apart from those of other clusters in a federation or central store.
+// The cluster label is set once the cluster is named or federated.
+func externalLabels(config *config.Config, ip, clusterID string) map[string]interface{} {
+	labels := map[string]interface{}{}
+	for name, value := range config.Monitoring.Prometheus.ExternalLabels {
+		labels[name] = value
+	}
+	if name := config.ClusterName(ip); name != ip || config.Monitoring.Federation.Enabled {
+		labels["cluster"] = name
+	}
+	if env := config.Cluster.Environment; env != "" {
+		labels["environment"] = env
+	}
+	if clusterID != "" {
+		labels["cluster_id"] = clusterID
+	}
+	return labels
+}
+
+// applyGrafanaDomain exposes Grafana at its domain through an Ingress with a
+// certificate from cert-manager, and makes it build its links, such as the
+// OAuth redirect URL, from the domain
+func applyGrafanaDomain(values map[string]interface{}, cfg *config.Config) {
+	grafana := cfg.Monitoring.Grafana
+	ingress := map[string]interface{}{
+		"enabled": true,
+		"hosts":   []string{grafana.Domain},
+		"path":    "/",
+		"tls": []map[string]interface{}{
+			{"secretName": grafanaTLSSecret, "hosts": []string{grafana.Domain}},
+		},
+	}
+	if grafana.IngressClass != "" {
+		ingress["ingressClassName"] = grafana.IngressClass
+	}
+	if issuer := grafanaIssuer(cfg); issuer != "" {
+		ingress["annotations"] = map[string]interface{}{
+			"cert-manager.io/cluster-issuer": issuer,
+		}
+	}
+	section(values, "grafana")["ingress"] = ingress
+
+	server := section(values, "grafana", "grafana.ini", "server")
+	server["domain"] = grafana.Domain
+	server["root_url"] = "https://" + grafana.Domain
+}
+
+// grafanaIssuer returns the ClusterIssuer of the Grafana certificate: the
+// configured one, or the only issuer of the cert-manager addon
+func grafanaIssuer(cfg *config.Config) string {
+	if issuer := cfg.Monitoring.Grafana.ClusterIssuer; issuer != "" {
+		return issuer
+	}
+	cm := cfg.Addons.CertManager
+	if cm.Enabled && len(cm.Issuers) == 1 {
+		return cm.Issuers[0].Name
+	}
+	return ""
+}
+
+// applyGrafanaOAuth renders the single sign-on settings into grafana.ini.
+// The redirect URL the provider calls back is built from the domain, see
+// applyGrafanaDomain.
+func applyGrafanaOAuth(values map[string]interface{}, oauth config.GrafanaOAuth) {
+	name, err := oauth.Section()
+	if err != nil {
+		return
+	}
+
+	scopes := oauth.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{"openid", "email", "profile"}
+		if oauth.Provider == "github" {
+			scopes = []string{"user:email", "read:org"}
+		}
+	}
+
+	settings := section(values, "grafana", "grafana.ini", name)
+	settings["enabled"] = true
+	settings["allow_sign_up"] = true
+	settings["client_id"] = oauth.ClientID
+	settings["client_secret"] = oauth.ClientSecret
+	settings["scopes"] = strings.Join(scopes, " ")
+	if oauth.Provider == "generic" {
+		settings["name"] = oauth.Name
+		if oauth.Name == "" {
+			settings["name"] = "SSO"
+		}
+		settings["auth_url"] = oauth.AuthURL
+		settings["token_url"] = oauth.TokenURL
+		settings["api_url"] = oauth.APIURL
+	}
+	if len(oauth.AllowedOrganizations) > 0 {
+		settings["allowed_organizations"] = strings.Join(oauth.AllowedOrganizations, " ")
+	}
+	if len(oauth.AllowedDomains) > 0 {
+		settings["allowed_domains"] = strings.Join(oauth.AllowedDomains, " ")
+	}
+	if path := roleAttributePath(oauth); path != "" {
+		settings["role_attribute_path"] = path
+	}
+
+	if oauth.DisableLoginForm {
+		section(values, "grafana", "grafana.ini", "auth")["disable_login_form"] = true
+	}
+}
+
+// roleAttributePath maps the groups of a user to a Grafana role, Admin
+// before Editor, and everyone else to Viewer
+func roleAttributePath(oauth config.GrafanaOAuth) string {
+	if oauth.RoleAttributePath != "" || (len(oauth.AdminGroups) == 0 && len(oauth.EditorGroups) == 0) {
+		return oauth.RoleAttributePath
+	}
+
+	var rules []string
+	for _, mapping := range []struct {
+		role   string
+		groups []string
+	}{{"Admin", oauth.AdminGroups}, {"Editor", oauth.EditorGroups}} {
+		for _, group := range mapping.groups {
+			rules = append(rules, fmt.Sprintf("contains(groups[*], '%s') && '%s'", group, mapping.role))
+		}
+	}
+	return strings.Join(append(rules, "'Viewer'"), " || ")
+}
+
+// section returns the nested map at path, creating it if needed
+func section(values map[string]interface{}, path ...string) map[string]interface{} {
+	current := values
+	for _, key := range path {
+		next, ok := current[key].(map[string]interface{})
+		if !ok {
+			next = map[string]interface{}{}
+			current[key] = next
+		}
+		current = next
+	}
+	return current
+}
+
+// validateFederation checks that the hub of an enabled federation is an IP,
+// which the clusters are told apart by
+func validateFederation(config *config.Config) error {
+	federation := config.Monitoring.Federation
+	if federation.Enabled && net.ParseIP(federation.Hub) == nil {
+		return fmt.Errorf("invalid federation hub %q, expected the IP of one of the clusters", federation.Hub)
+	}
+	return nil
+}
+
+// isSpoke reports whether ip is a federated cluster other than the hub
+func isSpoke(config *config.Config, ip string) bool {
+	federation := config.Monitoring.Federation
+	return federation.Enabled && federation.Hub != ip
+}
+
+// spokes returns every cluster except the hub
+func spokes(config *config.Config, clusters []string) []string {
+	var result []string
+	for _, ip := range clusters {
+		if ip != config.Monitoring.Federation.Hub {
+			result = append(result, ip)
+		}
+	}
+	return result
+}
+
+func nodePort(config *config.Config) int {
+	if config.Monitoring.Federation.NodePort != 0 {
+		return config.Monitoring.Federation.NodePort
+	}
+	return defaultNodePort
+}
+
+// Inspect fills the monitoring section of cfg from the installed stack
+func Inspect(client ssh.Executor, cfg *config.Config) error {
+	releases, err := helm.List(client)
+	if err != nil {
+		return err
+	}
+
+	for _, r := range releases {
+		if r.ChartName() == "kube-prometheus-stack" {
+			cfg.Monitoring.Prometheus.ChartVersion = r.ChartVersion()
+		}
+	}
+
+	retention, err := client.ExecuteCommand("kubectl get prometheus -n monitoring -o jsonpath='{.items[0].spec.retention}'")
+	if err != nil {
+		// No Prometheus installed by the operator
+		return nil
+	}
+	cfg.Monitoring.Prometheus.RetentionTime = strings.TrimSpace(retention)
+
+	storageClass, err := client.ExecuteCommand("kubectl get prometheus -n monitoring -o jsonpath='{.items[0].spec.storage.volumeClaimTemplate.spec.storageClassName}'")
+	if err == nil {
+		cfg.Monitoring.Prometheus.StorageClass = strings.TrimSpace(storageClass)
+	}
+
+	retentionSize, err := client.ExecuteCommand("kubectl get prometheus -n monitoring -o jsonpath='{.items[0].spec.retentionSize}'")
+	if err == nil {
+		cfg.Monitoring.Prometheus.RetentionSize = strings.TrimSpace(retentionSize)
+	}
+
+	return nil
+}
+
+// Relabel sets the cluster external label of the installed Prometheus stack
+// to the name of the cluster at ip, keeping the other values of the release.
+// It reports whether the stack is installed.
+func Relabel(client ssh.Executor, cfg *config.Config, ip string) (bool, error) {
+	releases, err := helm.List(client)
+	if err != nil {
+		return false, err
+	}
+	var release *helm.Release
+	for i, r := range releases {
+		if r.Name == "prometheus" && r.ChartName() == "kube-prometheus-stack" {
+			release = &releases[i]
+		}
+	}
+	if release == nil {
+		return false, nil
+	}
+
+	values, err := helm.Values(client, release.Name, release.Namespace)
+	if err != nil {
+		return true, err
+	}
+	if values == nil {
+		values = map[string]interface{}{}
+	}
+	section(values, "prometheus", "prometheusSpec", "externalLabels")["cluster"] = cfg.ClusterName(ip)
+
+	err = helm.Install(client, helm.Chart{
+		Release:    release.Name,
+		RepoURL:    "https://prometheus-community.github.io/helm-charts",
+		Chart:      "kube-prometheus-stack",
+		Version:    release.ChartVersion(),
+		Namespace:  release.Namespace,
+		Values:     values,
+		Namespaced: cfg.Monitoring.Scope.Enabled(),
+	})
+	if err != nil {
+		return true, fmt.Errorf("failed to relabel Prometheus stack: %v", err)
+	}
+	return true, nil
+}
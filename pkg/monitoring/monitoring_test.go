@@ -0,0 +1,199 @@
+package monitoring
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/maarulav/k8s-setup/internal/testutil"
+	"github.com/maarulav/k8s-setup/pkg/config"
+)
+
+func renderTestValues(t *testing.T, cfg *config.Config, ip string, clusters []string) string {
+	t.Helper()
+	data, err := json.MarshalIndent(renderValues(cfg, ip, clusters, ""), "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data) + "\n"
+}
+
+func testConfig() *config.Config {
+	cfg := &config.Config{}
+	cfg.Monitoring.Prometheus.RetentionTime = "15d"
+	cfg.Monitoring.Prometheus.StorageClass = "standard"
+	return cfg
+}
+
+func TestRenderValues(t *testing.T) {
+	testutil.AssertGolden(t, "values", renderTestValues(t, testConfig(), "10.0.0.1", []string{"10.0.0.1"}))
+}
+
+func TestRenderValuesFederation(t *testing.T) {
+	clusters := []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"}
+	for _, mode := range []string{ModeFederate, ModeRemoteWrite} {
+		cfg := testConfig()
+		cfg.Monitoring.Federation.Enabled = true
+		cfg.Monitoring.Federation.Hub = "10.0.0.1"
+		cfg.Monitoring.Federation.Mode = mode
+
+		testutil.AssertGolden(t, "values-"+mode+"-hub", renderTestValues(t, cfg, "10.0.0.1", clusters))
+		testutil.AssertGolden(t, "values-"+mode+"-spoke", renderTestValues(t, cfg, "10.0.0.2", clusters))
+	}
+}
+
+func TestValidateFederation(t *testing.T) {
+	cfg := testConfig()
+	cfg.Monitoring.Federation.Hub = "k8s-cp1"
+	if err := validateFederation(cfg); err != nil {
+		t.Errorf("disabled federation: %v", err)
+	}
+
+	cfg.Monitoring.Federation.Enabled = true
+	for _, hub := range []string{"", "k8s-cp1", "10.0.0.256", "10.0.0.0/24"} {
+		cfg.Monitoring.Federation.Hub = hub
+		if err := validateFederation(cfg); err == nil {
+			t.Errorf("hub %q accepted", hub)
+		}
+	}
+	for _, hub := range []string{"10.0.0.1", "fd00::1"} {
+		cfg.Monitoring.Federation.Hub = hub
+		if err := validateFederation(cfg); err != nil {
+			t.Errorf("hub %q: %v", hub, err)
+		}
+	}
+}
+
+func TestRenderValuesStorage(t *testing.T) {
+	cfg := testConfig()
+	compression := false
+	cfg.Monitoring.Prometheus.RetentionSize = "8GB"
+	cfg.Monitoring.Prometheus.WALCompression = &compression
+	cfg.Monitoring.Prometheus.ScrapeInterval = "60s"
+
+	testutil.AssertGolden(t, "values-storage", renderTestValues(t, cfg, "10.0.0.1", []string{"10.0.0.1"}))
+}
+
+func TestRenderValuesGrafanaOAuth(t *testing.T) {
+	cfg := testConfig()
+	cfg.Monitoring.Grafana.Domain = "grafana.example.com"
+	cfg.Monitoring.Grafana.OAuth = &config.GrafanaOAuth{
+		Provider:             "github",
+		ClientID:             "id",
+		ClientSecret:         "secret",
+		AllowedOrganizations: []string{"example"},
+		AdminGroups:          []string{"@example/platform"},
+		EditorGroups:         []string{"@example/dev"},
+		DisableLoginForm:     true,
+	}
+
+	testutil.AssertGolden(t, "values-grafana-oauth", renderTestValues(t, cfg, "10.0.0.1", []string{"10.0.0.1"}))
+}
+
+func TestRenderValuesGrafanaDomain(t *testing.T) {
+	cfg := testConfig()
+	cfg.Monitoring.Grafana.Domain = "grafana.example.com"
+	cfg.Monitoring.Grafana.IngressClass = "nginx"
+	cfg.Addons.CertManager.Enabled = true
+	cfg.Addons.CertManager.Issuers = []config.ClusterIssuer{{Name: "letsencrypt", Type: "acme"}}
+
+	testutil.AssertGolden(t, "values-grafana-domain", renderTestValues(t, cfg, "10.0.0.1", []string{"10.0.0.1"}))
+
+	cfg.Addons.CertManager.Issuers = append(cfg.Addons.CertManager.Issuers, config.ClusterIssuer{Name: "internal", Type: "selfSigned"})
+	if issuer := grafanaIssuer(cfg); issuer != "" {
+		t.Errorf("issuer = %q, want none to pick from two", issuer)
+	}
+	cfg.Monitoring.Grafana.ClusterIssuer = "internal"
+	if issuer := grafanaIssuer(cfg); issuer != "internal" {
+		t.Errorf("issuer = %q, want the configured one", issuer)
+	}
+	if url, ok := GrafanaURL(cfg, "10.0.0.1"); !ok || url != "https://grafana.example.com" {
+		t.Errorf("GrafanaURL = %q, %v", url, ok)
+	}
+}
+
+func TestExternalLabels(t *testing.T) {
+	cfg := testConfig()
+	cfg.Cluster = config.Cluster{Name: "default", Environment: "staging"}
+	cfg.Hosts = []config.Host{{IP: "10.0.0.2", ClusterName: "edge-2"}}
+	cfg.Monitoring.Prometheus.ExternalLabels = map[string]string{"region": "eu-west"}
+
+	labels := renderValues(cfg, "10.0.0.2", []string{"10.0.0.2"}, "5b0c")["prometheus"].(map[string]interface{})["prometheusSpec"].(map[string]interface{})["externalLabels"]
+	want := map[string]interface{}{"cluster": "edge-2", "environment": "staging", "cluster_id": "5b0c", "region": "eu-west"}
+	if !reflect.DeepEqual(labels, want) {
+		t.Errorf("external labels = %v, want %v", labels, want)
+	}
+
+	if labels := externalLabels(testConfig(), "10.0.0.1", ""); len(labels) != 0 {
+		t.Errorf("unnamed standalone cluster got labels %v", labels)
+	}
+}
+
+func TestRenderValuesCardinality(t *testing.T) {
+	cfg := testConfig()
+	cfg.Monitoring.Cardinality = config.MetricFilter{
+		DropMetrics:              []string{"container_network_.*", "kubelet_runtime_operations_duration_seconds_bucket"},
+		PodLabels:                []string{"app", "team"},
+		KubeStateMetricsDenylist: []string{"kube_replicaset_.*"},
+	}
+
+	testutil.AssertGolden(t, "values-cardinality", renderTestValues(t, cfg, "10.0.0.1", []string{"10.0.0.1"}))
+}
+
+func TestRenderValuesScope(t *testing.T) {
+	cfg := testConfig()
+	cfg.Monitoring.Scope = config.MonitoringScope{Namespaces: []string{"team-a", "monitoring", "team-b"}}
+
+	testutil.AssertGolden(t, "values-scope", renderTestValues(t, cfg, "10.0.0.1", []string{"10.0.0.1"}))
+
+	roles := scopeRoles(cfg.Monitoring.Scope)
+	if len(roles) != 12 {
+		t.Fatalf("got %d objects, want a Role and RoleBinding per service account and namespace", len(roles))
+	}
+	for _, obj := range roles {
+		if kind := obj["kind"]; kind != "Role" && kind != "RoleBinding" {
+			t.Errorf("unexpected kind %v", kind)
+		}
+	}
+}
+
+func TestParseVector(t *testing.T) {
+	samples, err := parseVector([]byte(`{"status":"success","data":{"resultType":"vector","result":[
+		{"metric":{"__name__":"node_uname_info","nodename":"cp-1"},"value":[1700000000,"1"]},
+		{"metric":{"__name__":"node_uname_info","nodename":"worker-1"},"value":[1700000000,"1"]}
+	]}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(samples) != 2 || samples[0].value() != 1 {
+		t.Fatalf("unexpected samples %v", samples)
+	}
+
+	missing := missingNodes([]string{"cp-1", "worker-1", "worker-2"}, samples)
+	if len(missing) != 1 || missing[0] != "worker-2" {
+		t.Errorf("missing nodes = %v, want [worker-2]", missing)
+	}
+
+	if _, err := parseVector([]byte(`{"status":"error","error":"parse error"}`)); err == nil {
+		t.Error("expected an error for a failed query")
+	}
+}
+
+func TestAlertRules(t *testing.T) {
+	rules, err := alertRules(map[string]bool{"backup": false, "etcd": true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.MarshalIndent(rules, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	testutil.AssertGolden(t, "rules", string(data)+"\n")
+
+	if rules, err := alertRules(map[string]bool{"node": false, "certificates": false, "etcd": false, "backup": false}); err != nil || rules != nil {
+		t.Errorf("all groups off: got %v, %v", rules, err)
+	}
+	if _, err := alertRules(map[string]bool{"disk": true}); err == nil {
+		t.Error("unknown group: expected an error")
+	}
+}
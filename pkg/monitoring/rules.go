@@ -0,0 +1,172 @@
+package monitoring
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// rulesName names the PrometheusRule holding the built-in alert groups
+const rulesName = "k8s-setup-rules"
+
+// alertRule is one alerting rule of a built-in group
+type alertRule struct {
+	name     string
+	expr     string
+	duration string
+	severity string
+	summary  string
+}
+
+// alertGroups are the built-in rule groups, each of which can be switched
+// off in monitoring.alerts. Rules over metrics that are not scraped, such as
+// cert-manager or Velero when those addons are not installed, never fire.
+var alertGroups = []struct {
+	name  string
+	rules []alertRule
+}{
+	{"node", []alertRule{
+		{
+			name:     "NodeDiskPressure",
+			expr:     `kube_node_status_condition{condition="DiskPressure",status="true"} == 1`,
+			duration: "5m",
+			severity: "warning",
+			summary:  "Node {{ $labels.node }} is under disk pressure",
+		},
+		{
+			name:     "NodeFilesystemAlmostFull",
+			expr:     `node_filesystem_avail_bytes{fstype!~"tmpfs|overlay|squashfs"} / node_filesystem_size_bytes < 0.10`,
+			duration: "15m",
+			severity: "warning",
+			summary:  "Filesystem {{ $labels.mountpoint }} on {{ $labels.instance }} has less than 10% space left",
+		},
+	}},
+	{"certificates", []alertRule{
+		{
+			name:     "APIServerClientCertificateExpiring",
+			expr:     `histogram_quantile(0.01, sum by (job, le) (rate(apiserver_client_certificate_expiration_seconds_bucket{job="apiserver"}[5m]))) < 604800`,
+			duration: "5m",
+			severity: "warning",
+			summary:  "A client certificate used against the API server expires within 7 days",
+		},
+		{
+			name:     "KubeletServerCertificateExpiring",
+			expr:     `kubelet_certificate_manager_server_ttl_seconds < 604800`,
+			duration: "0m",
+			severity: "warning",
+			summary:  "The kubelet server certificate of {{ $labels.node }} expires within 7 days",
+		},
+		{
+			name:     "CertManagerCertificateExpiring",
+			expr:     `certmanager_certificate_expiration_timestamp_seconds - time() < 1209600`,
+			duration: "1h",
+			severity: "warning",
+			summary:  "Certificate {{ $labels.namespace }}/{{ $labels.name }} expires within 14 days",
+		},
+	}},
+	{"etcd", []alertRule{
+		{
+			// kubeadm serves the etcd metrics on every interface, see
+			// kubernetes.etcdMetricsURL, so a missing job means broken scrapes
+			name:     "EtcdMetricsAbsent",
+			expr:     `absent(up{job="kube-etcd"} == 1)`,
+			duration: "15m",
+			severity: "warning",
+			summary:  "No etcd member is scraped, etcd health is not monitored",
+		},
+		{
+			name:     "EtcdNoLeader",
+			expr:     `etcd_server_has_leader == 0`,
+			duration: "1m",
+			severity: "critical",
+			summary:  "etcd member {{ $labels.instance }} has no leader",
+		},
+		{
+			name:     "EtcdFrequentLeaderChanges",
+			expr:     `increase(etcd_server_leader_changes_seen_total[1h]) > 3`,
+			duration: "0m",
+			severity: "warning",
+			summary:  "etcd member {{ $labels.instance }} saw more than 3 leader changes within an hour",
+		},
+		{
+			name:     "EtcdDatabaseQuotaLow",
+			expr:     `etcd_mvcc_db_total_size_in_bytes / etcd_server_quota_backend_bytes > 0.80`,
+			duration: "10m",
+			severity: "warning",
+			summary:  "etcd database of {{ $labels.instance }} uses more than 80% of its quota",
+		},
+	}},
+	{"backup", []alertRule{
+		{
+			// The volume backups of the tool are not scheduled, so their
+			// failures count under an empty schedule label
+			name:     "VeleroBackupFailed",
+			expr:     `sum(increase(velero_backup_failure_total[1h])) > 0`,
+			duration: "0m",
+			severity: "warning",
+			summary:  "A Velero volume backup failed within the last hour",
+		},
+		{
+			name:     "BackupJobFailed",
+			expr:     `kube_job_status_failed{job_name=~".*backup.*"} > 0`,
+			duration: "0m",
+			severity: "warning",
+			summary:  "Backup job {{ $labels.namespace }}/{{ $labels.job_name }} failed",
+		},
+	}},
+}
+
+// alertRules renders the PrometheusRule with the enabled groups, nil when
+// all of them are switched off
+func alertRules(switches map[string]bool) (map[string]interface{}, error) {
+	known := map[string]bool{}
+	for _, group := range alertGroups {
+		known[group.name] = true
+	}
+	var unknown []string
+	for name := range switches {
+		if !known[name] {
+			unknown = append(unknown, name)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return nil, fmt.Errorf("unknown alert groups %s", strings.Join(unknown, ", "))
+	}
+
+	var groups []map[string]interface{}
+	for _, group := range alertGroups {
+		if enabled, ok := switches[group.name]; ok && !enabled {
+			continue
+		}
+		var rules []map[string]interface{}
+		for _, rule := range group.rules {
+			rules = append(rules, map[string]interface{}{
+				"alert":       rule.name,
+				"expr":        rule.expr,
+				"for":         rule.duration,
+				"labels":      map[string]interface{}{"severity": rule.severity},
+				"annotations": map[string]interface{}{"summary": rule.summary},
+			})
+		}
+		groups = append(groups, map[string]interface{}{
+			"name":  "k8s-setup." + group.name,
+			"rules": rules,
+		})
+	}
+	if len(groups) == 0 {
+		return nil, nil
+	}
+
+	return map[string]interface{}{
+		"apiVersion": "monitoring.coreos.com/v1",
+		"kind":       "PrometheusRule",
+		"metadata": map[string]interface{}{
+			"name":      rulesName,
+			"namespace": "monitoring",
+			// The release label makes the operator of the stack load the rules
+			"labels": map[string]interface{}{"release": "prometheus"},
+		},
+		"spec": map[string]interface{}{"groups": groups},
+	}, nil
+}
@@ -0,0 +1,118 @@
+package monitoring
+
+import (
+	"github.com/maarulav/k8s-setup/pkg/config"
+)
+
+// Service accounts of the kube-prometheus-stack release installed by Setup
+const (
+	operatorServiceAccount   = "prometheus-kube-prometheus-operator"
+	prometheusServiceAccount = "prometheus-kube-prometheus-prometheus"
+)
+
+// clusterComponents are the chart components that need cluster-wide access
+// or only scrape cluster components
+var clusterComponents = []string{
+	"kubeApiServer", "kubelet", "kubeControllerManager", "coreDns", "kubeEtcd",
+	"kubeScheduler", "kubeProxy", "kubeStateMetrics", "nodeExporter",
+}
+
+// scopedNamespaces returns the namespaces a scoped stack watches, its own
+// first
+func scopedNamespaces(scope config.MonitoringScope) []string {
+	namespaces := []string{"monitoring"}
+	for _, ns := range scope.Namespaces {
+		if ns != "monitoring" {
+			namespaces = append(namespaces, ns)
+		}
+	}
+	return namespaces
+}
+
+// applyScope limits the stack to the namespaces of scope. The chart creates
+// no RBAC, scopeRoles grants the namespaced permissions instead.
+func applyScope(values map[string]interface{}, scope config.MonitoringScope) {
+	namespaces := scopedNamespaces(scope)
+
+	section(values, "crds")["enabled"] = false
+	section(values, "global", "rbac")["create"] = false
+	section(values, "defaultRules")["create"] = false
+	for _, component := range clusterComponents {
+		section(values, component)["enabled"] = false
+	}
+
+	operator := section(values, "prometheusOperator")
+	operator["namespaces"] = map[string]interface{}{
+		"releaseNamespace": true,
+		"additional":       namespaces[1:],
+	}
+	section(operator, "kubeletService")["enabled"] = false
+	section(operator, "admissionWebhooks")["enabled"] = false
+	section(operator, "tls")["enabled"] = false
+
+	selector := map[string]interface{}{
+		"matchExpressions": []map[string]interface{}{
+			{"key": "kubernetes.io/metadata.name", "operator": "In", "values": namespaces},
+		},
+	}
+	prometheusSpec := section(values, "prometheus", "prometheusSpec")
+	for _, key := range []string{"serviceMonitorNamespaceSelector", "podMonitorNamespaceSelector", "probeNamespaceSelector", "ruleNamespaceSelector"} {
+		prometheusSpec[key] = selector
+	}
+
+	grafana := section(values, "grafana")
+	grafana["defaultDashboardsEnabled"] = false
+	section(grafana, "rbac")["namespaced"] = true
+}
+
+// scopeRoles grants the operator and Prometheus what they need in each
+// namespace of scope through Roles rather than ClusterRoles
+func scopeRoles(scope config.MonitoringScope) []map[string]interface{} {
+	operatorRules := []map[string]interface{}{
+		{"apiGroups": []string{"monitoring.coreos.com"}, "resources": []string{"*"}, "verbs": []string{"*"}},
+		{"apiGroups": []string{"apps"}, "resources": []string{"statefulsets"}, "verbs": []string{"*"}},
+		{"apiGroups": []string{""}, "resources": []string{"configmaps", "secrets"}, "verbs": []string{"*"}},
+		{"apiGroups": []string{""}, "resources": []string{"pods"}, "verbs": []string{"list", "delete"}},
+		{"apiGroups": []string{""}, "resources": []string{"services", "services/finalizers", "endpoints"}, "verbs": []string{"get", "create", "update", "delete"}},
+		{"apiGroups": []string{""}, "resources": []string{"events"}, "verbs": []string{"create", "patch"}},
+	}
+	prometheusRules := []map[string]interface{}{
+		{"apiGroups": []string{""}, "resources": []string{"services", "endpoints", "pods"}, "verbs": []string{"get", "list", "watch"}},
+		{"apiGroups": []string{"discovery.k8s.io"}, "resources": []string{"endpointslices"}, "verbs": []string{"get", "list", "watch"}},
+		{"apiGroups": []string{""}, "resources": []string{"configmaps"}, "verbs": []string{"get"}},
+	}
+
+	var objects []map[string]interface{}
+	for _, ns := range scopedNamespaces(scope) {
+		objects = append(objects, role(ns, operatorServiceAccount, operatorRules)...)
+		objects = append(objects, role(ns, prometheusServiceAccount, prometheusRules)...)
+	}
+	return objects
+}
+
+// role renders a Role with rules in namespace and binds it to the service
+// account of the monitoring namespace of the same name
+func role(namespace, serviceAccount string, rules []map[string]interface{}) []map[string]interface{} {
+	metadata := map[string]interface{}{"name": serviceAccount, "namespace": namespace}
+	return []map[string]interface{}{
+		{
+			"apiVersion": "rbac.authorization.k8s.io/v1",
+			"kind":       "Role",
+			"metadata":   metadata,
+			"rules":      rules,
+		},
+		{
+			"apiVersion": "rbac.authorization.k8s.io/v1",
+			"kind":       "RoleBinding",
+			"metadata":   metadata,
+			"roleRef": map[string]interface{}{
+				"apiGroup": "rbac.authorization.k8s.io",
+				"kind":     "Role",
+				"name":     serviceAccount,
+			},
+			"subjects": []map[string]interface{}{
+				{"kind": "ServiceAccount", "name": serviceAccount, "namespace": "monitoring"},
+			},
+		},
+	}
+}
@@ -0,0 +1,272 @@
+package monitoring
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/maarulav/k8s-setup/pkg/config"
+	"github.com/maarulav/k8s-setup/pkg/kube"
+	"github.com/maarulav/k8s-setup/pkg/ssh"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// Services of the kube-prometheus-stack release installed by Setup
+	prometheusService = "prometheus-operated"
+	prometheusPort    = "9090"
+	grafanaService    = "prometheus-grafana"
+	grafanaPort       = "80"
+
+	// requestTimeout bounds each request to the API server and Grafana
+	requestTimeout = 30 * time.Second
+)
+
+// verifyInterval is the pause between queries while the first scrapes have
+// not come in yet
+var verifyInterval = 10 * time.Second
+
+// sample is one series of an instant query result
+type sample struct {
+	Metric map[string]string `json:"metric"`
+	Value  []interface{}     `json:"value"`
+}
+
+// value returns the sample value, 0 when it cannot be parsed
+func (s sample) value() float64 {
+	if len(s.Value) != 2 {
+		return 0
+	}
+	text, _ := s.Value[1].(string)
+	v, _ := strconv.ParseFloat(text, 64)
+	return v
+}
+
+// Verify checks that the monitoring stack actually works: Prometheus has
+// targets up and node_exporter metrics from every node, and, where Grafana
+// runs, Grafana is healthy and reaches its Prometheus datasources. Targets
+// that are down are only reported, kubeadm binds some control plane metrics
+// endpoints to localhost. A stack scoped to namespaces runs no node_exporter.
+func Verify(client ssh.Executor, cfg *config.Config) error {
+	kc, err := kube.NewClient(client)
+	if err != nil {
+		return err
+	}
+
+	var nodes []string
+	if !cfg.Monitoring.Scope.Enabled() {
+		ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+		nodeList, err := kc.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+		cancel()
+		if err != nil {
+			return fmt.Errorf("failed to list nodes: %v", err)
+		}
+		for _, node := range nodeList.Items {
+			nodes = append(nodes, node.Name)
+		}
+	}
+
+	down, err := waitForTargets(kc, nodes, kube.DefaultTimeout)
+	if err != nil {
+		return fmt.Errorf("prometheus: %v", err)
+	}
+	for _, target := range down {
+		fmt.Printf("Warning: Prometheus target %s is down\n", target)
+	}
+
+	if isSpoke(cfg, client.Host()) {
+		return nil
+	}
+	return checkGrafana(kc, client, cfg.Monitoring.Grafana.AdminPassword)
+}
+
+// waitForTargets runs checkTargets until it passes, as the first scrapes of
+// a fresh install take a moment to arrive, or timeout has passed
+func waitForTargets(kc kubernetes.Interface, nodes []string, timeout time.Duration) ([]string, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		down, err := checkTargets(kc, nodes)
+		if err == nil {
+			return down, nil
+		}
+		if time.Now().Add(verifyInterval).After(deadline) {
+			return nil, err
+		}
+		time.Sleep(verifyInterval)
+	}
+}
+
+// query runs an instant PromQL query through the API server's service proxy
+func query(kc kubernetes.Interface, promql string) ([]sample, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	data, err := kc.CoreV1().Services("monitoring").
+		ProxyGet("http", prometheusService, prometheusPort, "/api/v1/query", map[string]string{"query": promql}).
+		DoRaw(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("query %s failed: %v", promql, err)
+	}
+	return parseVector(data)
+}
+
+// parseVector returns the series of an instant query response
+func parseVector(data []byte) ([]sample, error) {
+	var response struct {
+		Status string `json:"status"`
+		Error  string `json:"error"`
+		Data   struct {
+			ResultType string   `json:"resultType"`
+			Result     []sample `json:"result"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(data, &response); err != nil {
+		return nil, fmt.Errorf("failed to parse query response: %v", err)
+	}
+	if response.Status != "success" {
+		return nil, fmt.Errorf("query failed: %s", response.Error)
+	}
+	if response.Data.ResultType != "vector" {
+		return nil, fmt.Errorf("unexpected %s result", response.Data.ResultType)
+	}
+	return response.Data.Result, nil
+}
+
+// checkTargets requires at least one target up and node_exporter metrics
+// from every node. It returns the targets that are down.
+func checkTargets(kc kubernetes.Interface, nodes []string) ([]string, error) {
+	up, err := query(kc, "up")
+	if err != nil {
+		return nil, err
+	}
+	var running int
+	var down []string
+	for _, s := range up {
+		if s.value() == 1 {
+			running++
+		} else {
+			down = append(down, fmt.Sprintf("%s/%s", s.Metric["job"], s.Metric["instance"]))
+		}
+	}
+	if running == 0 {
+		return nil, fmt.Errorf("no scrape targets are up")
+	}
+
+	if len(nodes) > 0 {
+		uname, err := query(kc, "node_uname_info")
+		if err != nil {
+			return nil, err
+		}
+		if missing := missingNodes(nodes, uname); len(missing) > 0 {
+			return nil, fmt.Errorf("no node_exporter metrics from %s", strings.Join(missing, ", "))
+		}
+	}
+
+	fmt.Printf("Prometheus: %d targets up, %d down, node_exporter on %d nodes\n", running, len(down), len(nodes))
+	sort.Strings(down)
+	return down, nil
+}
+
+// missingNodes returns the nodes without a node_uname_info series
+func missingNodes(nodes []string, uname []sample) []string {
+	reporting := map[string]bool{}
+	for _, s := range uname {
+		reporting[s.Metric["nodename"]] = true
+	}
+	var missing []string
+	for _, node := range nodes {
+		if !reporting[node] {
+			missing = append(missing, node)
+		}
+	}
+	return missing
+}
+
+// checkGrafana checks the health endpoint of Grafana and the health of each
+// Prometheus datasource. The datasource API needs the admin login, which
+// the API server proxy does not pass on, so those requests go to the
+// service IP from where the cluster operations run.
+func checkGrafana(kc kubernetes.Interface, client ssh.Executor, password string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	data, err := kc.CoreV1().Services("monitoring").ProxyGet("http", grafanaService, grafanaPort, "/api/health", nil).DoRaw(ctx)
+	cancel()
+	if err != nil {
+		return fmt.Errorf("grafana health check failed: %v", err)
+	}
+	var health struct {
+		Database string `json:"database"`
+	}
+	if err := json.Unmarshal(data, &health); err != nil || health.Database != "ok" {
+		return fmt.Errorf("grafana is unhealthy: %s", strings.TrimSpace(string(data)))
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), requestTimeout)
+	service, err := kc.CoreV1().Services("monitoring").Get(ctx, grafanaService, metav1.GetOptions{})
+	cancel()
+	if err != nil {
+		return fmt.Errorf("failed to read the grafana service: %v", err)
+	}
+	base := "http://" + net.JoinHostPort(service.Spec.ClusterIP, grafanaPort)
+	httpClient := &http.Client{
+		Timeout: requestTimeout,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				return client.Dial(network, addr)
+			},
+		},
+	}
+	get := func(path string, v interface{}) error {
+		req, err := http.NewRequest(http.MethodGet, base+path, nil)
+		if err != nil {
+			return err
+		}
+		req.SetBasicAuth("admin", password)
+		resp, err := httpClient.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("%s returned %s", path, resp.Status)
+		}
+		return json.NewDecoder(resp.Body).Decode(v)
+	}
+
+	var datasources []struct {
+		UID  string `json:"uid"`
+		Name string `json:"name"`
+		Type string `json:"type"`
+	}
+	if err := get("/api/datasources", &datasources); err != nil {
+		return fmt.Errorf("failed to list grafana datasources: %v", err)
+	}
+	checked := 0
+	for _, ds := range datasources {
+		if ds.Type != "prometheus" {
+			continue
+		}
+		var result struct {
+			Status  string `json:"status"`
+			Message string `json:"message"`
+		}
+		if err := get("/api/datasources/uid/"+ds.UID+"/health", &result); err != nil {
+			return fmt.Errorf("datasource %s: %v", ds.Name, err)
+		}
+		if result.Status != "OK" {
+			return fmt.Errorf("datasource %s: %s", ds.Name, result.Message)
+		}
+		checked++
+	}
+	if checked == 0 {
+		return fmt.Errorf("grafana has no prometheus datasource")
+	}
+
+	fmt.Printf("Grafana: healthy, %d Prometheus datasources reachable\n", checked)
+	return nil
+}
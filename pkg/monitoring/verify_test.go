@@ -0,0 +1,140 @@
+package monitoring
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"k8s.io/client-go/kubernetes/fake"
+	restclient "k8s.io/client-go/rest"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// proxyResponse answers a service proxy request with data and records the
+// time left on the context of the request
+type proxyResponse struct {
+	data      string
+	remaining func(time.Duration)
+}
+
+func (r proxyResponse) DoRaw(ctx context.Context) ([]byte, error) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		r.remaining(0)
+	} else {
+		r.remaining(time.Until(deadline))
+	}
+	return []byte(r.data), nil
+}
+
+func (r proxyResponse) Stream(ctx context.Context) (io.ReadCloser, error) {
+	data, err := r.DoRaw(ctx)
+	return ioutil.NopCloser(bytes.NewReader(data)), err
+}
+
+// vector renders an instant query response with the given series
+func vector(series ...string) string {
+	return `{"status":"success","data":{"resultType":"vector","result":[` + strings.Join(series, ",") + `]}}`
+}
+
+// prometheus returns a clientset whose Prometheus answers up with the
+// responses in turn, repeating the last, node_uname_info for cp-1 and
+// every other request with an unhealthy Grafana. It records the time left
+// for every request.
+func prometheus(up ...string) (*fake.Clientset, *[]time.Duration) {
+	var mu sync.Mutex
+	var remaining []time.Duration
+	record := func(d time.Duration) {
+		mu.Lock()
+		defer mu.Unlock()
+		remaining = append(remaining, d)
+	}
+
+	kc := fake.NewClientset()
+	kc.PrependProxyReactor("services", func(action k8stesting.Action) (bool, restclient.ResponseWrapper, error) {
+		proxy := action.(k8stesting.ProxyGetAction)
+		switch proxy.GetParams()["query"] {
+		case "up":
+			mu.Lock()
+			data := up[0]
+			if len(up) > 1 {
+				up = up[1:]
+			}
+			mu.Unlock()
+			return true, proxyResponse{data, record}, nil
+		case "node_uname_info":
+			return true, proxyResponse{vector(`{"metric":{"nodename":"cp-1"},"value":[1700000000,"1"]}`), record}, nil
+		}
+		return true, proxyResponse{`{"database":"failing"}`, record}, nil
+	})
+	return kc, &remaining
+}
+
+func TestWaitForTargets(t *testing.T) {
+	interval := verifyInterval
+	verifyInterval = 10 * time.Millisecond
+	t.Cleanup(func() { verifyInterval = interval })
+
+	kc, remaining := prometheus(
+		// No scrapes yet, then one target up and one down
+		vector(),
+		vector(`{"metric":{"job":"kube-scheduler","instance":"127.0.0.1:10259"},"value":[1700000000,"0"]}`),
+		vector(`{"metric":{"job":"kube-scheduler","instance":"127.0.0.1:10259"},"value":[1700000000,"0"]}`,
+			`{"metric":{"job":"node-exporter","instance":"10.0.0.1:9100"},"value":[1700000000,"1"]}`),
+	)
+	down, err := waitForTargets(kc, []string{"cp-1"}, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(down) != 1 || down[0] != "kube-scheduler/127.0.0.1:10259" {
+		t.Errorf("down targets %v", down)
+	}
+
+	// Three queries of up and one of node_uname_info, each with a full
+	// timeout of its own
+	if len(*remaining) != 4 {
+		t.Fatalf("%d queries, want 4", len(*remaining))
+	}
+	for i, d := range *remaining {
+		if d <= requestTimeout-time.Second || d > requestTimeout {
+			t.Errorf("query %d had %s left, want its own %s", i, d, requestTimeout)
+		}
+	}
+}
+
+func TestWaitForTargetsTimeout(t *testing.T) {
+	interval := verifyInterval
+	verifyInterval = 10 * time.Millisecond
+	t.Cleanup(func() { verifyInterval = interval })
+
+	kc, remaining := prometheus(vector())
+	_, err := waitForTargets(kc, nil, 50*time.Millisecond)
+	if err == nil || err.Error() != "no scrape targets are up" {
+		t.Errorf("error %v, want no scrape targets are up", err)
+	}
+	if len(*remaining) < 2 {
+		t.Errorf("%d queries, want a retry before the timeout", len(*remaining))
+	}
+
+	// node_exporter must report from every node
+	kc, _ = prometheus(vector(`{"metric":{"job":"apiserver","instance":"10.0.0.1:6443"},"value":[1700000000,"1"]}`))
+	if _, err := waitForTargets(kc, []string{"cp-1", "worker-1"}, 0); err == nil || !strings.Contains(err.Error(), "worker-1") {
+		t.Errorf("error %v, want worker-1 missing", err)
+	}
+}
+
+func TestCheckGrafanaUnhealthy(t *testing.T) {
+	kc, remaining := prometheus(vector())
+	err := checkGrafana(kc, nil, "admin")
+	if err == nil || !strings.Contains(err.Error(), "grafana is unhealthy") {
+		t.Errorf("error %v, want grafana unhealthy", err)
+	}
+	if len(*remaining) != 1 || (*remaining)[0] <= requestTimeout-time.Second {
+		t.Errorf("health check had %v left, want its own %s", *remaining, requestTimeout)
+	}
+}
@@ -0,0 +1,228 @@
+package plan
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/maarulav/k8s-setup/pkg/addons"
+	"github.com/maarulav/k8s-setup/pkg/config"
+	"github.com/maarulav/k8s-setup/pkg/kubernetes"
+	"github.com/maarulav/k8s-setup/pkg/ssh"
+)
+
+// Minimum host size kubeadm's preflight checks accept for a control plane
+const (
+	minCPU    = 2000
+	minMemory = 1700 << 20
+)
+
+// Footprint is the expected CPU (millicores) and memory (bytes) of a component
+type Footprint struct {
+	Component string
+	CPU       int64
+	Memory    int64
+}
+
+// Capacity is the CPU and memory a host has
+type Capacity struct {
+	CPU    int64
+	Memory int64
+}
+
+// defaults are the footprints assumed for components without configured
+// requests. They reflect a small single-node cluster, not chart defaults,
+// which often leave requests empty.
+var defaults = map[string]Footprint{
+	"system":              {CPU: 500, Memory: 1 << 30},
+	"control-plane":       {CPU: 650, Memory: 900 << 20},
+	"coredns":             {CPU: 200, Memory: 140 << 20},
+	kubernetes.CNICalico:  {CPU: 250, Memory: 200 << 20},
+	kubernetes.CNIFlannel: {CPU: 100, Memory: 50 << 20},
+	kubernetes.CNICilium:  {CPU: 100, Memory: 300 << 20},
+	"prometheus":          {CPU: 500, Memory: 2 << 30},
+	"grafana":             {CPU: 100, Memory: 256 << 20},
+	"alertmanager":        {CPU: 50, Memory: 128 << 20},
+	"prometheus-operator": {CPU: 100, Memory: 128 << 20},
+	"kube-state-metrics":  {CPU: 50, Memory: 128 << 20},
+	"node-exporter":       {CPU: 50, Memory: 64 << 20},
+	"cert-manager":        {CPU: 100, Memory: 256 << 20},
+	"external-dns":        {CPU: 50, Memory: 64 << 20},
+	"registry":            {CPU: 100, Memory: 256 << 20},
+	"trivy-operator":      {CPU: 200, Memory: 512 << 20},
+	"falco":               {CPU: 150, Memory: 640 << 20},
+	"kyverno":             {CPU: 300, Memory: 512 << 20},
+	"gatekeeper":          {CPU: 200, Memory: 512 << 20},
+	"sealed-secrets":      {CPU: 50, Memory: 64 << 20},
+	"external-secrets":    {CPU: 100, Memory: 192 << 20},
+	"event-exporter":      {CPU: 50, Memory: 64 << 20},
+	"descheduler":         {CPU: 100, Memory: 128 << 20},
+	"vpa":                 {CPU: 50, Memory: 500 << 20},
+	"velero":              {CPU: 200, Memory: 256 << 20},
+	"capsule":             {CPU: 200, Memory: 128 << 20},
+	"vcluster":            {CPU: 200, Memory: 256 << 20},
+}
+
+// component is an installed component with the requests configured for it
+type component struct {
+	name      string
+	requested config.ResourceRequirements
+}
+
+// Estimate returns the footprint of every component the configuration
+// installs on a cluster. Configured requests replace the defaults.
+func Estimate(cfg *config.Config) ([]Footprint, error) {
+	cni := cfg.Kubernetes.CNI
+	if cni == "" {
+		cni = kubernetes.CNICalico
+	}
+
+	components := []component{
+		{name: "system"},
+		{name: "control-plane"},
+		{"coredns", cfg.Components.CoreDNS},
+		{cni, cfg.Components.CNI},
+		{"prometheus", cfg.Components.Prometheus},
+		{"grafana", cfg.Components.Grafana},
+		{"alertmanager", cfg.Components.Alertmanager},
+		{"prometheus-operator", cfg.Components.PrometheusOperator},
+		{"kube-state-metrics", cfg.Components.KubeStateMetrics},
+		{"node-exporter", cfg.Components.NodeExporter},
+	}
+	if cfg.Addons.CertManager.Enabled {
+		components = append(components, component{name: "cert-manager"})
+	}
+	if cfg.Addons.ExternalDNS.Enabled {
+		components = append(components, component{name: "external-dns"})
+	}
+	if cfg.Addons.Registry.Enabled {
+		components = append(components, component{name: "registry"})
+	}
+	if cfg.Addons.Falco.Enabled {
+		components = append(components, component{name: "falco"})
+	}
+	if p := cfg.Addons.Policy; p.Enabled {
+		engine := p.Engine
+		if engine == "" {
+			engine = "kyverno"
+		}
+		components = append(components, component{name: engine})
+	}
+	if s := cfg.Addons.Secrets; s.Enabled {
+		kind := s.Type
+		if kind == "" {
+			kind = "sealed-secrets"
+		}
+		components = append(components, component{name: kind})
+	}
+	if cfg.Addons.EventExporter.Enabled {
+		components = append(components, component{name: "event-exporter"})
+	}
+	if cfg.Addons.Descheduler.Enabled {
+		components = append(components, component{name: "descheduler"})
+	}
+	if cfg.Addons.Overprovisioner.Enabled {
+		replicas, cpu, memory, err := addons.OverprovisionerSize(cfg)
+		if err != nil {
+			return nil, err
+		}
+		components = append(components, component{"overprovisioner", config.ResourceRequirements{
+			Requests: map[string]string{
+				"cpu":    config.FormatCPU(cpu * int64(replicas)),
+				"memory": config.FormatMemory(memory * int64(replicas)),
+			},
+		}})
+	}
+	if cfg.Addons.VPA.Enabled {
+		components = append(components, component{name: "vpa"})
+	}
+	if t := cfg.Addons.Tenancy; t.Enabled {
+		if t.Type == "vcluster" {
+			// Every tenant runs a virtual control plane of its own
+			for range t.Tenants {
+				components = append(components, component{name: "vcluster"})
+			}
+		} else {
+			components = append(components, component{name: "capsule"})
+		}
+	}
+	if cfg.Backup.Volumes.Enabled {
+		components = append(components, component{name: "velero"})
+	}
+	if cfg.Security.Trivy {
+		components = append(components, component{name: "trivy-operator"})
+	}
+
+	var footprints []Footprint
+	for _, c := range components {
+		f := defaults[c.name]
+		f.Component = c.name
+		if cpu, ok := c.requested.Requests["cpu"]; ok {
+			milli, err := config.ParseCPU(cpu)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", c.name, err)
+			}
+			f.CPU = milli
+		}
+		if memory, ok := c.requested.Requests["memory"]; ok {
+			bytes, err := config.ParseMemory(memory)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", c.name, err)
+			}
+			f.Memory = bytes
+		}
+		footprints = append(footprints, f)
+	}
+	return footprints, nil
+}
+
+// Total sums the footprints
+func Total(footprints []Footprint) Footprint {
+	total := Footprint{Component: "total"}
+	for _, f := range footprints {
+		total.CPU += f.CPU
+		total.Memory += f.Memory
+	}
+	return total
+}
+
+// Check returns warnings for a host that is smaller than the footprint or
+// than kubeadm's minimum
+func Check(total Footprint, capacity Capacity) []string {
+	var warnings []string
+	if capacity.CPU < minCPU {
+		warnings = append(warnings, fmt.Sprintf("%s CPU is below the kubeadm minimum of %s", config.FormatCPU(capacity.CPU), config.FormatCPU(minCPU)))
+	}
+	if capacity.Memory < minMemory {
+		warnings = append(warnings, fmt.Sprintf("%s memory is below the kubeadm minimum of %s", config.FormatMemory(capacity.Memory), config.FormatMemory(minMemory)))
+	}
+	if capacity.CPU < total.CPU {
+		warnings = append(warnings, fmt.Sprintf("%s CPU is less than the estimated %s", config.FormatCPU(capacity.CPU), config.FormatCPU(total.CPU)))
+	}
+	if capacity.Memory < total.Memory {
+		warnings = append(warnings, fmt.Sprintf("%s memory is less than the estimated %s", config.FormatMemory(capacity.Memory), config.FormatMemory(total.Memory)))
+	}
+	return warnings
+}
+
+// Detect reads the CPU count and memory of a host
+func Detect(client ssh.Executor) (Capacity, error) {
+	output, err := client.ExecuteCommand("nproc && awk '/^MemTotal:/ {print $2}' /proc/meminfo")
+	if err != nil {
+		return Capacity{}, fmt.Errorf("failed to detect host size: %v", err)
+	}
+
+	fields := strings.Fields(output)
+	if len(fields) != 2 {
+		return Capacity{}, fmt.Errorf("unexpected host size output: %q", output)
+	}
+	cpus, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return Capacity{}, fmt.Errorf("unexpected CPU count %q", fields[0])
+	}
+	kb, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return Capacity{}, fmt.Errorf("unexpected memory size %q", fields[1])
+	}
+	return Capacity{CPU: cpus * 1000, Memory: kb << 10}, nil
+}
@@ -0,0 +1,62 @@
+package plan
+
+import (
+	"testing"
+
+	"github.com/maarulav/k8s-setup/internal/testutil"
+	"github.com/maarulav/k8s-setup/pkg/config"
+)
+
+func TestEstimate(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Kubernetes.CNI = "cilium"
+	cfg.Addons.CertManager.Enabled = true
+	cfg.Components.Prometheus = config.ResourceRequirements{
+		Requests: map[string]string{"cpu": "1", "memory": "4Gi"},
+	}
+
+	footprints, err := Estimate(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	byName := map[string]Footprint{}
+	for _, f := range footprints {
+		byName[f.Component] = f
+	}
+	if p := byName["prometheus"]; p.CPU != 1000 || p.Memory != 4<<30 {
+		t.Errorf("configured requests not used for prometheus: %+v", p)
+	}
+	if _, ok := byName["cilium"]; !ok {
+		t.Errorf("CNI footprint missing: %v", footprints)
+	}
+	if _, ok := byName["cert-manager"]; !ok {
+		t.Errorf("enabled addon missing: %v", footprints)
+	}
+	if _, ok := byName["registry"]; ok {
+		t.Errorf("disabled addon estimated: %v", footprints)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	total := Footprint{CPU: 3000, Memory: 6 << 30}
+
+	if warnings := Check(total, Capacity{CPU: 4000, Memory: 8 << 30}); len(warnings) != 0 {
+		t.Errorf("expected no warnings, got %v", warnings)
+	}
+	if warnings := Check(total, Capacity{CPU: 1000, Memory: 1 << 30}); len(warnings) != 4 {
+		t.Errorf("expected minimum and estimate warnings for CPU and memory, got %v", warnings)
+	}
+}
+
+func TestDetect(t *testing.T) {
+	fake := testutil.NewExecutor("10.0.0.1").Respond("nproc", "4\n8141256\n")
+
+	capacity, err := Detect(fake)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if capacity.CPU != 4000 || capacity.Memory != 8141256<<10 {
+		t.Errorf("unexpected capacity %+v", capacity)
+	}
+}
@@ -0,0 +1,189 @@
+package render
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/maarulav/k8s-setup/pkg/config"
+	"github.com/maarulav/k8s-setup/pkg/kubernetes"
+	"gopkg.in/yaml.v3"
+)
+
+// API versions of the Cluster API and bring-your-own-host provider objects
+const (
+	capiAPIVersion      = "cluster.x-k8s.io/v1beta1"
+	capiControlPlaneAPI = "controlplane.cluster.x-k8s.io/v1beta1"
+	capiBootstrapAPI    = "bootstrap.cluster.x-k8s.io/v1beta1"
+	byohAPIVersion      = "infrastructure.cluster.x-k8s.io/v1beta1"
+
+	// poolLabel selects the ByoHosts of the control plane and worker pools
+	poolLabel = "k8s-setup.io/pool"
+)
+
+// Topology is the shape of a cluster provisioned by the tool: the control
+// plane node and the workers joined to it
+type Topology struct {
+	Name         string
+	Namespace    string
+	ControlPlane string
+	Workers      []string
+}
+
+// CAPI renders Cluster API manifests for the bring-your-own-host provider
+// describing the cluster of cfg with topology t. The hosts already run the
+// Kubernetes packages, so the machine templates carry no installer and
+// select the registered ByoHosts by their pool label.
+func CAPI(cfg *config.Config, t Topology) (string, error) {
+	if t.Name == "" || t.ControlPlane == "" {
+		return "", fmt.Errorf("the cluster needs a name and a control plane")
+	}
+	namespace := t.Namespace
+	if namespace == "" {
+		namespace = "default"
+	}
+	version := kubernetes.KubeadmVersion(cfg.Kubernetes.Version)
+	endpoint := t.ControlPlane
+	if cfg.Kubernetes.AdvertiseAddress != "" {
+		endpoint = cfg.Kubernetes.AdvertiseAddress
+	}
+
+	meta := func(name string) map[string]interface{} {
+		return map[string]interface{}{"name": name, "namespace": namespace}
+	}
+	ref := func(apiVersion, kind, name string) map[string]interface{} {
+		return map[string]interface{}{"apiVersion": apiVersion, "kind": kind, "name": name}
+	}
+	machineTemplate := func(name, pool string) map[string]interface{} {
+		return map[string]interface{}{
+			"apiVersion": byohAPIVersion,
+			"kind":       "ByoMachineTemplate",
+			"metadata":   meta(name),
+			"spec": map[string]interface{}{
+				"template": map[string]interface{}{
+					"spec": map[string]interface{}{
+						"selector": map[string]interface{}{
+							"matchLabels": map[string]interface{}{poolLabel: pool},
+						},
+					},
+				},
+			},
+		}
+	}
+
+	pods, services := []string{cfg.Kubernetes.PodCIDR}, []string{cfg.Kubernetes.ServiceCIDR}
+	if k := cfg.Kubernetes; k.PodCIDRv6 != "" && k.ServiceCIDRv6 != "" {
+		pods, services = append(pods, k.PodCIDRv6), append(services, k.ServiceCIDRv6)
+	}
+	clusterNetwork := map[string]interface{}{
+		"pods":     map[string]interface{}{"cidrBlocks": pods},
+		"services": map[string]interface{}{"cidrBlocks": services},
+	}
+	if cfg.Kubernetes.ClusterDomain != "" {
+		clusterNetwork["serviceDomain"] = cfg.Kubernetes.ClusterDomain
+	}
+
+	clusterConfiguration := map[string]interface{}{}
+	if cfg.Kubernetes.ServiceNodePortRange != "" {
+		clusterConfiguration["apiServer"] = map[string]interface{}{
+			"extraArgs": map[string]interface{}{"service-node-port-range": cfg.Kubernetes.ServiceNodePortRange},
+		}
+	}
+
+	controlPlaneName := t.Name + "-control-plane"
+	objects := []map[string]interface{}{
+		{
+			"apiVersion": capiAPIVersion,
+			"kind":       "Cluster",
+			"metadata":   meta(t.Name),
+			"spec": map[string]interface{}{
+				"clusterNetwork":    clusterNetwork,
+				"controlPlaneRef":   ref(capiControlPlaneAPI, "KubeadmControlPlane", controlPlaneName),
+				"infrastructureRef": ref(byohAPIVersion, "ByoCluster", t.Name),
+			},
+		},
+		{
+			"apiVersion": byohAPIVersion,
+			"kind":       "ByoCluster",
+			"metadata":   meta(t.Name),
+			"spec": map[string]interface{}{
+				"controlPlaneEndpoint": map[string]interface{}{"host": endpoint, "port": 6443},
+			},
+		},
+		{
+			"apiVersion": capiControlPlaneAPI,
+			"kind":       "KubeadmControlPlane",
+			"metadata":   meta(controlPlaneName),
+			"spec": map[string]interface{}{
+				"replicas": 1,
+				"version":  version,
+				"machineTemplate": map[string]interface{}{
+					"infrastructureRef": ref(byohAPIVersion, "ByoMachineTemplate", controlPlaneName),
+				},
+				"kubeadmConfigSpec": map[string]interface{}{
+					"clusterConfiguration": clusterConfiguration,
+				},
+			},
+		},
+		machineTemplate(controlPlaneName, "control-plane"),
+	}
+
+	if len(t.Workers) > 0 {
+		workersName := t.Name + "-md-0"
+		selector := map[string]interface{}{
+			"matchLabels": map[string]interface{}{"cluster.x-k8s.io/cluster-name": t.Name},
+		}
+		objects = append(objects,
+			map[string]interface{}{
+				"apiVersion": capiAPIVersion,
+				"kind":       "MachineDeployment",
+				"metadata":   meta(workersName),
+				"spec": map[string]interface{}{
+					"clusterName": t.Name,
+					"replicas":    len(t.Workers),
+					"selector":    selector,
+					"template": map[string]interface{}{
+						"spec": map[string]interface{}{
+							"clusterName": t.Name,
+							"version":     version,
+							"bootstrap": map[string]interface{}{
+								"configRef": ref(capiBootstrapAPI, "KubeadmConfigTemplate", workersName),
+							},
+							"infrastructureRef": ref(byohAPIVersion, "ByoMachineTemplate", workersName),
+						},
+					},
+				},
+			},
+			machineTemplate(workersName, "worker"),
+			map[string]interface{}{
+				"apiVersion": capiBootstrapAPI,
+				"kind":       "KubeadmConfigTemplate",
+				"metadata":   meta(workersName),
+				"spec": map[string]interface{}{
+					"template": map[string]interface{}{
+						"spec": map[string]interface{}{},
+					},
+				},
+			},
+		)
+	}
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "# Generated by k8s-setup, do not edit. Register every host with the\n")
+	fmt.Fprintf(&b, "# BYOH agent, then label its ByoHost with %s=<pool>:\n", poolLabel)
+	fmt.Fprintf(&b, "#   control-plane: %s\n", t.ControlPlane)
+	if len(t.Workers) > 0 {
+		fmt.Fprintf(&b, "#   worker: %s\n", strings.Join(t.Workers, ", "))
+	}
+	encoder := yaml.NewEncoder(&b)
+	encoder.SetIndent(2)
+	for _, object := range objects {
+		if err := encoder.Encode(object); err != nil {
+			return "", fmt.Errorf("failed to render %s: %v", object["kind"], err)
+		}
+	}
+	if err := encoder.Close(); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,218 @@
+package render
+
+import (
+	"bytes"
+	"fmt"
+
+	"gopkg.in/yaml.v3"
+)
+
+// API group, version and resource of the ClusterSetup objects the operator
+// reconciles
+const (
+	ClusterSetupGroup    = "k8s-setup.io"
+	ClusterSetupVersion  = "v1alpha1"
+	ClusterSetupResource = "clustersetups"
+)
+
+// OperatorDeployment is where and from which image the operator runs. The
+// configuration is read from the config.json key of ConfigMap. The status
+// store lives on a volume of StorageSize (default 1Gi) from StorageClass,
+// the cluster's default class when empty.
+type OperatorDeployment struct {
+	Namespace    string
+	Image        string
+	ConfigMap    string
+	StorageClass string
+	StorageSize  string
+}
+
+// Operator renders the ClusterSetup CRD and the service account, RBAC,
+// volume and Deployment running the operator in a management cluster. The
+// operator only reconciles the ClusterSetups of its namespace and only reads
+// the Secrets there.
+func Operator(d OperatorDeployment) (string, error) {
+	if d.Image == "" {
+		return "", fmt.Errorf("the operator needs an image")
+	}
+	namespace := d.Namespace
+	if namespace == "" {
+		namespace = "k8s-setup"
+	}
+	configMap := d.ConfigMap
+	if configMap == "" {
+		configMap = "k8s-setup-config"
+	}
+	size := d.StorageSize
+	if size == "" {
+		size = "1Gi"
+	}
+	const name = "k8s-setup-operator"
+
+	claim := map[string]interface{}{
+		"accessModes": []string{"ReadWriteOnce"},
+		"resources":   map[string]interface{}{"requests": map[string]interface{}{"storage": size}},
+	}
+	if d.StorageClass != "" {
+		claim["storageClassName"] = d.StorageClass
+	}
+
+	str := map[string]interface{}{"type": "string"}
+	node := map[string]interface{}{
+		"type":     "object",
+		"required": []string{"ip"},
+		"properties": map[string]interface{}{
+			"ip":                str,
+			"credentialsSecret": str,
+			"controlPlane":      map[string]interface{}{"type": "boolean"},
+		},
+	}
+	nodeStatus := map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"ip":                 str,
+			"phase":              map[string]interface{}{"type": "string", "enum": []string{"Joining", "Joined", "Failed"}},
+			"message":            str,
+			"lastTransitionTime": map[string]interface{}{"type": "string", "format": "date-time"},
+		},
+	}
+	schema := map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"spec": map[string]interface{}{
+				"type":     "object",
+				"required": []string{"controlPlane"},
+				"properties": map[string]interface{}{
+					"controlPlane":      str,
+					"credentialsSecret": str,
+					"nodes":             map[string]interface{}{"type": "array", "items": node},
+				},
+			},
+			"status": map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"nodes": map[string]interface{}{"type": "array", "items": nodeStatus},
+				},
+			},
+		},
+	}
+	column := func(name, path string) map[string]interface{} {
+		return map[string]interface{}{"name": name, "type": "string", "jsonPath": path}
+	}
+
+	meta := map[string]interface{}{"name": name, "namespace": namespace}
+	objects := []map[string]interface{}{
+		{
+			"apiVersion": "apiextensions.k8s.io/v1",
+			"kind":       "CustomResourceDefinition",
+			"metadata":   map[string]interface{}{"name": ClusterSetupResource + "." + ClusterSetupGroup},
+			"spec": map[string]interface{}{
+				"group": ClusterSetupGroup,
+				"scope": "Namespaced",
+				"names": map[string]interface{}{
+					"kind":     "ClusterSetup",
+					"listKind": "ClusterSetupList",
+					"plural":   ClusterSetupResource,
+					"singular": "clustersetup",
+				},
+				"versions": []map[string]interface{}{
+					{
+						"name":                     ClusterSetupVersion,
+						"served":                   true,
+						"storage":                  true,
+						"schema":                   map[string]interface{}{"openAPIV3Schema": schema},
+						"subresources":             map[string]interface{}{"status": map[string]interface{}{}},
+						"additionalPrinterColumns": []map[string]interface{}{column("Control Plane", ".spec.controlPlane")},
+					},
+				},
+			},
+		},
+		{
+			"apiVersion": "v1",
+			"kind":       "Namespace",
+			"metadata":   map[string]interface{}{"name": namespace},
+		},
+		{
+			"apiVersion": "v1",
+			"kind":       "ServiceAccount",
+			"metadata":   meta,
+		},
+		{
+			"apiVersion": "rbac.authorization.k8s.io/v1",
+			"kind":       "Role",
+			"metadata":   meta,
+			"rules": []map[string]interface{}{
+				{"apiGroups": []string{ClusterSetupGroup}, "resources": []string{ClusterSetupResource}, "verbs": []string{"get", "list", "watch"}},
+				{"apiGroups": []string{ClusterSetupGroup}, "resources": []string{ClusterSetupResource + "/status"}, "verbs": []string{"get", "update", "patch"}},
+				{"apiGroups": []string{""}, "resources": []string{"secrets"}, "verbs": []string{"get"}},
+			},
+		},
+		{
+			"apiVersion": "rbac.authorization.k8s.io/v1",
+			"kind":       "RoleBinding",
+			"metadata":   meta,
+			"roleRef": map[string]interface{}{
+				"apiGroup": "rbac.authorization.k8s.io",
+				"kind":     "Role",
+				"name":     name,
+			},
+			"subjects": []map[string]interface{}{
+				{"kind": "ServiceAccount", "name": name, "namespace": namespace},
+			},
+		},
+		{
+			"apiVersion": "v1",
+			"kind":       "PersistentVolumeClaim",
+			"metadata":   meta,
+			"spec":       claim,
+		},
+		{
+			"apiVersion": "apps/v1",
+			"kind":       "Deployment",
+			"metadata":   meta,
+			"spec": map[string]interface{}{
+				"replicas": 1,
+				"strategy": map[string]interface{}{"type": "Recreate"},
+				"selector": map[string]interface{}{"matchLabels": map[string]interface{}{"app": name}},
+				"template": map[string]interface{}{
+					"metadata": map[string]interface{}{"labels": map[string]interface{}{"app": name}},
+					"spec": map[string]interface{}{
+						"serviceAccountName": name,
+						"containers": []map[string]interface{}{
+							{
+								"name":       "operator",
+								"image":      d.Image,
+								"args":       []string{"operator", "--namespace", namespace, "/etc/k8s-setup/config.json"},
+								"workingDir": "/var/lib/k8s-setup",
+								"volumeMounts": []map[string]interface{}{
+									{"name": "config", "mountPath": "/etc/k8s-setup", "readOnly": true},
+									{"name": "work", "mountPath": "/var/lib/k8s-setup"},
+								},
+							},
+						},
+						"volumes": []map[string]interface{}{
+							{"name": "config", "configMap": map[string]interface{}{"name": configMap}},
+							{"name": "work", "persistentVolumeClaim": map[string]interface{}{"claimName": name}},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "# Generated by k8s-setup, do not edit. The operator starts once its\n")
+	fmt.Fprintf(&b, "# configuration exists:\n")
+	fmt.Fprintf(&b, "#   kubectl -n %s create configmap %s --from-file=config.json\n", namespace, configMap)
+	encoder := yaml.NewEncoder(&b)
+	encoder.SetIndent(2)
+	for _, object := range objects {
+		if err := encoder.Encode(object); err != nil {
+			return "", fmt.Errorf("failed to render %s: %v", object["kind"], err)
+		}
+	}
+	if err := encoder.Close(); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,114 @@
+// Package render turns setup steps into artifacts that run without the tool,
+// such as the node preparation script baked into a golden image.
+package render
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/maarulav/k8s-setup/pkg/config"
+	"github.com/maarulav/k8s-setup/pkg/kubernetes"
+)
+
+// errNoHost is returned for operations that need a live host or cluster
+var errNoHost = errors.New("rendered scripts have no host")
+
+// Script is an ssh.Executor that appends every command and file write to a
+// bash script instead of running it. Commands succeed with empty output, so
+// steps probing the host take their default branch, the one for Ubuntu.
+type Script struct {
+	b bytes.Buffer
+}
+
+// NewScript starts a script that stops at the first failing command
+func NewScript() *Script {
+	s := &Script{}
+	s.b.WriteString("#!/bin/bash\n# Generated by k8s-setup, do not edit\nset -euxo pipefail\nexport DEBIAN_FRONTEND=noninteractive\n")
+	return s
+}
+
+// ExecuteCommand appends command to the script
+func (s *Script) ExecuteCommand(command string) (string, error) {
+	fmt.Fprintf(&s.b, "\n%s\n", command)
+	return "", nil
+}
+
+// WriteFile appends the commands creating path with data to the script
+func (s *Script) WriteFile(filePath string, data []byte, mode os.FileMode) error {
+	fmt.Fprintf(&s.b, "\nmkdir -p %s\nbase64 -d > %s <<'EOF'\n", path.Dir(filePath), filePath)
+	encoded := base64.StdEncoding.EncodeToString(data)
+	for len(encoded) > 76 {
+		fmt.Fprintln(&s.b, encoded[:76])
+		encoded = encoded[76:]
+	}
+	fmt.Fprintf(&s.b, "%s\nEOF\nchmod %o %s\n", encoded, mode.Perm(), filePath)
+	return nil
+}
+
+// Host returns a placeholder, the script runs on whatever host builds the image
+func (s *Script) Host() string {
+	return "image"
+}
+
+// Kubeconfig fails, there is no cluster while the image is built
+func (s *Script) Kubeconfig() ([]byte, error) {
+	return nil, errNoHost
+}
+
+// Dial fails, there is no cluster while the image is built
+func (s *Script) Dial(network, addr string) (net.Conn, error) {
+	return nil, errNoHost
+}
+
+// Scripted tells the setup steps that the commands do not run yet
+func (s *Script) Scripted() bool {
+	return true
+}
+
+// String returns the script
+func (s *Script) String() string {
+	return s.b.String()
+}
+
+// NodeScript renders the node preparation of cfg, the container runtime, the
+// Kubernetes packages, trusted CAs and registry mirrors, as a script for an
+// image build. It pre-pulls the control plane images and resets the machine
+// identity so every instance of the image registers as a distinct node.
+func NodeScript(cfg *config.Config) (string, error) {
+	s := NewScript()
+	if err := kubernetes.PrepareNode(s, cfg); err != nil {
+		return "", err
+	}
+	for _, cmd := range []string{
+		"kubeadm config images pull",
+		"apt-get clean",
+		"truncate -s 0 /etc/machine-id",
+	} {
+		s.ExecuteCommand(cmd)
+	}
+	return s.String(), nil
+}
+
+// Packer renders a Packer HCL build running script on source, the name of a
+// source block defined next to it such as "source.amazon-ebs.ubuntu"
+func Packer(source, script string) string {
+	return strings.TrimLeft(fmt.Sprintf(`
+# Generated by k8s-setup, do not edit. Define %[1]s in another
+# .pkr.hcl file of this directory, then run: packer build .
+build {
+  name    = "k8s-node"
+  sources = [%[1]q]
+
+  provisioner "shell" {
+    script          = %[2]q
+    execute_command = "chmod +x {{ .Path }}; sudo -E bash '{{ .Path }}'"
+  }
+}
+`, source, script), "\n")
+}
@@ -0,0 +1,52 @@
+package render
+
+import (
+	"testing"
+
+	"github.com/maarulav/k8s-setup/internal/testutil"
+	"github.com/maarulav/k8s-setup/pkg/config"
+)
+
+func TestNodeScript(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Kubernetes.Version = "1.28.2-00"
+	cfg.RegistryMirrors = []config.RegistryMirror{
+		{Registry: "docker.io", Endpoints: []string{"https://mirror.lab:5000"}},
+	}
+
+	script, err := NodeScript(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testutil.AssertGolden(t, "node-script", script)
+}
+
+func TestCAPI(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Kubernetes.Version = "1.28.2-00"
+	cfg.Kubernetes.PodCIDR = "10.244.0.0/16"
+	cfg.Kubernetes.ServiceCIDR = "10.96.0.0/12"
+	cfg.Kubernetes.ServiceNodePortRange = "20000-40000"
+
+	manifests, err := CAPI(cfg, Topology{
+		Name:         "lab",
+		ControlPlane: "10.0.0.10",
+		Workers:      []string{"10.0.0.11", "10.0.0.12"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	testutil.AssertGolden(t, "capi", manifests)
+}
+
+func TestOperator(t *testing.T) {
+	manifests, err := Operator(OperatorDeployment{Image: "registry.lab/k8s-setup:v1.4.0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	testutil.AssertGolden(t, "operator", manifests)
+
+	if _, err := Operator(OperatorDeployment{}); err == nil {
+		t.Error("expected an error without an image")
+	}
+}
@@ -0,0 +1,331 @@
+package security
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/maarulav/k8s-setup/pkg/config"
+	"github.com/maarulav/k8s-setup/pkg/helm"
+	"github.com/maarulav/k8s-setup/pkg/kubernetes"
+	"github.com/maarulav/k8s-setup/pkg/ssh"
+)
+
+const (
+	// Namespace holds the kube-bench Job and trivy-operator
+	Namespace = "security-scan"
+
+	kubeBenchImage      = "docker.io/aquasec/kube-bench:v0.10.1"
+	defaultScanTimeout  = 10 * time.Minute
+	maxReportedFindings = 20
+)
+
+// pollInterval is how often scan results are checked
+var pollInterval = 15 * time.Second
+
+// Report aggregates the findings of the scanners. A scanner that failed
+// has its error instead of its findings.
+type Report struct {
+	Time            time.Time             `json:"time"`
+	KubeBench       *BenchSummary         `json:"kubeBench,omitempty"`
+	KubeBenchError  string                `json:"kubeBenchError,omitempty"`
+	Vulnerabilities *VulnerabilitySummary `json:"vulnerabilities,omitempty"`
+	TrivyError      string                `json:"trivyError,omitempty"`
+}
+
+// BenchSummary counts the CIS benchmark results of kube-bench and lists the
+// failed checks
+type BenchSummary struct {
+	Pass   int      `json:"pass"`
+	Fail   int      `json:"fail"`
+	Warn   int      `json:"warn"`
+	Info   int      `json:"info"`
+	Failed []string `json:"failed,omitempty"`
+}
+
+// VulnerabilitySummary counts the vulnerabilities trivy-operator found in the
+// images of running workloads and lists the worst workloads
+type VulnerabilitySummary struct {
+	Reports  int                       `json:"reports"`
+	Critical int                       `json:"critical"`
+	High     int                       `json:"high"`
+	Medium   int                       `json:"medium"`
+	Low      int                       `json:"low"`
+	Worst    []WorkloadVulnerabilities `json:"worst,omitempty"`
+}
+
+// WorkloadVulnerabilities is the vulnerability count of one container image
+type WorkloadVulnerabilities struct {
+	Workload string `json:"workload"`
+	Image    string `json:"image"`
+	Critical int    `json:"critical"`
+	High     int    `json:"high"`
+}
+
+// Scan runs the configured scanners and returns their findings. A failing
+// scanner does not keep the others from running: its error is recorded in
+// the report and returned alongside it. With failOnCritical an error is
+// returned alongside the report when critical vulnerabilities were found.
+func Scan(client ssh.Executor, cfg *config.Config) (*Report, error) {
+	scan := cfg.Security
+	report := &Report{Time: time.Now()}
+
+	timeout := defaultScanTimeout
+	if scan.Timeout != "" {
+		d, err := time.ParseDuration(scan.Timeout)
+		if err != nil {
+			return nil, fmt.Errorf("invalid security scan timeout: %v", err)
+		}
+		timeout = d
+	}
+
+	var failed []string
+	if scan.KubeBench {
+		bench, err := runKubeBench(client, timeout)
+		if err != nil {
+			report.KubeBenchError = err.Error()
+			failed = append(failed, "kube-bench: "+report.KubeBenchError)
+		}
+		report.KubeBench = bench
+	}
+
+	if scan.Trivy {
+		vulnerabilities, err := runTrivy(client, scan, timeout)
+		if err != nil {
+			report.TrivyError = err.Error()
+			failed = append(failed, "trivy: "+report.TrivyError)
+		}
+		report.Vulnerabilities = vulnerabilities
+	}
+	if len(failed) > 0 {
+		return report, errors.New(strings.Join(failed, "; "))
+	}
+
+	if scan.FailOnCritical && report.Vulnerabilities != nil && report.Vulnerabilities.Critical > 0 {
+		return report, fmt.Errorf("%d critical vulnerabilities found in running workloads", report.Vulnerabilities.Critical)
+	}
+	return report, nil
+}
+
+// runKubeBench runs kube-bench as a Job on the node and parses its JSON output
+func runKubeBench(client ssh.Executor, timeout time.Duration) (*BenchSummary, error) {
+	var volumes, mounts []map[string]interface{}
+	for _, dir := range []struct{ name, path string }{
+		{"etc-cni-netd", "/etc/cni/net.d"},
+		{"etc-kubernetes", "/etc/kubernetes"},
+		{"etc-systemd", "/etc/systemd"},
+		{"lib-systemd", "/lib/systemd"},
+		{"opt-cni-bin", "/opt/cni/bin"},
+		{"var-lib-etcd", "/var/lib/etcd"},
+		{"var-lib-kubelet", "/var/lib/kubelet"},
+	} {
+		volumes = append(volumes, map[string]interface{}{"name": dir.name, "hostPath": map[string]interface{}{"path": dir.path}})
+		mounts = append(mounts, map[string]interface{}{"name": dir.name, "mountPath": dir.path, "readOnly": true})
+	}
+
+	// Remove the Job of an earlier run, Jobs cannot be updated in place
+	client.ExecuteCommand(fmt.Sprintf("kubectl delete job kube-bench -n %s --ignore-not-found", Namespace))
+
+	err := kubernetes.Apply(client, "kube-bench", []map[string]interface{}{
+		{
+			"apiVersion": "v1",
+			"kind":       "Namespace",
+			"metadata":   map[string]interface{}{"name": Namespace},
+		},
+		{
+			"apiVersion": "batch/v1",
+			"kind":       "Job",
+			"metadata":   map[string]interface{}{"name": "kube-bench", "namespace": Namespace},
+			"spec": map[string]interface{}{
+				"backoffLimit": 0,
+				"template": map[string]interface{}{
+					"spec": map[string]interface{}{
+						"hostPID":       true,
+						"restartPolicy": "Never",
+						"tolerations":   []map[string]interface{}{{"operator": "Exists"}},
+						"containers": []map[string]interface{}{
+							{
+								"name":         "kube-bench",
+								"image":        kubeBenchImage,
+								"command":      []string{"kube-bench", "--json"},
+								"volumeMounts": mounts,
+							},
+						},
+						"volumes": volumes,
+					},
+				},
+			},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	wait := fmt.Sprintf("kubectl wait --for=condition=complete job/kube-bench -n %s --timeout=%ds", Namespace, int(timeout.Seconds()))
+	if output, err := client.ExecuteCommand(wait); err != nil {
+		return nil, fmt.Errorf("kube-bench did not complete: %v\nOutput: %s", err, output)
+	}
+
+	output, err := ssh.Complete(client.ExecuteCommand(fmt.Sprintf("kubectl logs job/kube-bench -n %s", Namespace)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read kube-bench results: %v", err)
+	}
+	return parseKubeBench([]byte(output))
+}
+
+// parseKubeBench summarises the --json output of kube-bench
+func parseKubeBench(data []byte) (*BenchSummary, error) {
+	var result struct {
+		Controls []struct {
+			Tests []struct {
+				Results []struct {
+					Number string `json:"test_number"`
+					Desc   string `json:"test_desc"`
+					Status string `json:"status"`
+				} `json:"results"`
+			} `json:"tests"`
+		} `json:"Controls"`
+		Totals struct {
+			Pass int `json:"total_pass"`
+			Fail int `json:"total_fail"`
+			Warn int `json:"total_warn"`
+			Info int `json:"total_info"`
+		} `json:"Totals"`
+	}
+	if err := json.Unmarshal(data, &result); err != nil {
+		return nil, fmt.Errorf("failed to parse kube-bench results: %v", err)
+	}
+
+	summary := &BenchSummary{
+		Pass: result.Totals.Pass,
+		Fail: result.Totals.Fail,
+		Warn: result.Totals.Warn,
+		Info: result.Totals.Info,
+	}
+	for _, control := range result.Controls {
+		for _, test := range control.Tests {
+			for _, r := range test.Results {
+				if r.Status == "FAIL" && len(summary.Failed) < maxReportedFindings {
+					summary.Failed = append(summary.Failed, r.Number+" "+r.Desc)
+				}
+			}
+		}
+	}
+	return summary, nil
+}
+
+// runTrivy installs trivy-operator and waits for its vulnerability reports
+// to settle before summarising them
+func runTrivy(client ssh.Executor, scan config.SecurityScan, timeout time.Duration) (*VulnerabilitySummary, error) {
+	if err := helm.Install(client, helm.Chart{
+		Release:   "trivy-operator",
+		RepoURL:   "https://aquasecurity.github.io/helm-charts/",
+		Chart:     "trivy-operator",
+		Version:   scan.TrivyVersion,
+		Namespace: Namespace,
+		Values: map[string]interface{}{
+			"operator": map[string]interface{}{
+				"configAuditScannerEnabled":     false,
+				"rbacAssessmentScannerEnabled":  false,
+				"infraAssessmentScannerEnabled": false,
+				"exposedSecretScannerEnabled":   false,
+			},
+			"trivy": map[string]interface{}{"ignoreUnfixed": scan.IgnoreUnfixed},
+		},
+	}); err != nil {
+		return nil, err
+	}
+
+	// Reports appear one workload at a time; stop once the count is stable
+	deadline := time.Now().Add(timeout)
+	last := -1
+	for {
+		output, err := ssh.Complete(client.ExecuteCommand("kubectl get vulnerabilityreports -A -o json"))
+		if err == nil {
+			summary, err := summarizeVulnerabilities([]byte(output))
+			if err != nil {
+				return nil, err
+			}
+			if summary.Reports > 0 && summary.Reports == last {
+				return summary, nil
+			}
+			last = summary.Reports
+			if time.Now().After(deadline) {
+				if summary.Reports == 0 {
+					return nil, fmt.Errorf("trivy-operator produced no vulnerability reports within %s", timeout)
+				}
+				return summary, nil
+			}
+		} else if time.Now().After(deadline) {
+			return nil, fmt.Errorf("failed to read vulnerability reports: %v", err)
+		}
+		time.Sleep(pollInterval)
+	}
+}
+
+// summarizeVulnerabilities totals trivy-operator VulnerabilityReports
+func summarizeVulnerabilities(data []byte) (*VulnerabilitySummary, error) {
+	var list struct {
+		Items []struct {
+			Metadata struct {
+				Namespace string            `json:"namespace"`
+				Labels    map[string]string `json:"labels"`
+			} `json:"metadata"`
+			Report struct {
+				Artifact struct {
+					Repository string `json:"repository"`
+					Tag        string `json:"tag"`
+				} `json:"artifact"`
+				Summary struct {
+					Critical int `json:"criticalCount"`
+					High     int `json:"highCount"`
+					Medium   int `json:"mediumCount"`
+					Low      int `json:"lowCount"`
+				} `json:"summary"`
+			} `json:"report"`
+		} `json:"items"`
+	}
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("failed to parse vulnerability reports: %v", err)
+	}
+
+	summary := &VulnerabilitySummary{Reports: len(list.Items)}
+	for _, item := range list.Items {
+		s := item.Report.Summary
+		summary.Critical += s.Critical
+		summary.High += s.High
+		summary.Medium += s.Medium
+		summary.Low += s.Low
+		if s.Critical+s.High == 0 {
+			continue
+		}
+
+		labels := item.Metadata.Labels
+		image := item.Report.Artifact.Repository
+		if item.Report.Artifact.Tag != "" {
+			image += ":" + item.Report.Artifact.Tag
+		}
+		summary.Worst = append(summary.Worst, WorkloadVulnerabilities{
+			Workload: fmt.Sprintf("%s/%s/%s", item.Metadata.Namespace,
+				labels["trivy-operator.resource.kind"], labels["trivy-operator.resource.name"]),
+			Image:    image,
+			Critical: s.Critical,
+			High:     s.High,
+		})
+	}
+
+	sort.SliceStable(summary.Worst, func(i, j int) bool {
+		a, b := summary.Worst[i], summary.Worst[j]
+		if a.Critical != b.Critical {
+			return a.Critical > b.Critical
+		}
+		return a.High > b.High
+	})
+	if len(summary.Worst) > maxReportedFindings {
+		summary.Worst = summary.Worst[:maxReportedFindings]
+	}
+	return summary, nil
+}
@@ -0,0 +1,92 @@
+package security
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/maarulav/k8s-setup/internal/testutil"
+	"github.com/maarulav/k8s-setup/pkg/config"
+)
+
+const kubeBenchOutput = `{
+  "Controls": [
+    {
+      "id": "1",
+      "tests": [
+        {
+          "section": "1.1",
+          "results": [
+            {"test_number": "1.1.1", "test_desc": "Ensure that the API server pod specification file permissions are set to 600", "status": "PASS"},
+            {"test_number": "1.1.12", "test_desc": "Ensure that the etcd data directory ownership is set to etcd:etcd", "status": "FAIL"}
+          ]
+        }
+      ]
+    }
+  ],
+  "Totals": {"total_pass": 1, "total_fail": 1, "total_warn": 0, "total_info": 0}
+}`
+
+const vulnerabilityReports = `{
+  "items": [
+    {
+      "metadata": {"namespace": "shop", "labels": {"trivy-operator.resource.kind": "ReplicaSet", "trivy-operator.resource.name": "web-5d9c"}},
+      "report": {"artifact": {"repository": "library/nginx", "tag": "1.19"}, "summary": {"criticalCount": 2, "highCount": 5, "mediumCount": 7, "lowCount": 1}}
+    },
+    {
+      "metadata": {"namespace": "monitoring", "labels": {"trivy-operator.resource.kind": "StatefulSet", "trivy-operator.resource.name": "prometheus"}},
+      "report": {"artifact": {"repository": "prometheus/prometheus", "tag": "v2.48.0"}, "summary": {"criticalCount": 0, "highCount": 1, "mediumCount": 2, "lowCount": 0}}
+    },
+    {
+      "metadata": {"namespace": "kube-system", "labels": {"trivy-operator.resource.kind": "DaemonSet", "trivy-operator.resource.name": "calico-node"}},
+      "report": {"artifact": {"repository": "calico/node", "tag": "v3.26.1"}, "summary": {"criticalCount": 0, "highCount": 0, "mediumCount": 0, "lowCount": 3}}
+    }
+  ]
+}`
+
+func TestParseKubeBench(t *testing.T) {
+	summary, err := parseKubeBench([]byte(kubeBenchOutput))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Pass != 1 || summary.Fail != 1 {
+		t.Errorf("unexpected totals %+v", summary)
+	}
+	if len(summary.Failed) != 1 || summary.Failed[0] != "1.1.12 Ensure that the etcd data directory ownership is set to etcd:etcd" {
+		t.Errorf("unexpected failed checks %v", summary.Failed)
+	}
+}
+
+func TestSummarizeVulnerabilities(t *testing.T) {
+	summary, err := summarizeVulnerabilities([]byte(vulnerabilityReports))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Reports != 3 || summary.Critical != 2 || summary.High != 6 || summary.Medium != 9 || summary.Low != 4 {
+		t.Errorf("unexpected totals %+v", summary)
+	}
+	if len(summary.Worst) != 2 {
+		t.Fatalf("expected the two workloads with critical or high findings, got %+v", summary.Worst)
+	}
+	if w := summary.Worst[0]; w.Workload != "shop/ReplicaSet/web-5d9c" || w.Image != "library/nginx:1.19" {
+		t.Errorf("unexpected worst workload %+v", w)
+	}
+}
+
+func TestScanTrivyFailure(t *testing.T) {
+	client := testutil.NewExecutor("10.0.0.1").Respond("kubectl logs job/kube-bench", kubeBenchOutput)
+	cfg := &config.Config{}
+	cfg.Security.KubeBench = true
+	cfg.Security.Trivy = true
+
+	// trivy-operator cannot be installed without an API server
+	report, err := Scan(client, cfg)
+	if err == nil || !strings.HasPrefix(err.Error(), "trivy: ") {
+		t.Errorf("error %v, want the trivy failure", err)
+	}
+	if report == nil || report.KubeBench == nil || report.KubeBench.Fail != 1 {
+		t.Fatalf("report %+v, want the kube-bench findings kept", report)
+	}
+	if report.TrivyError == "" || report.Vulnerabilities != nil || report.KubeBenchError != "" {
+		t.Errorf("report %+v, want only the trivy error", report)
+	}
+}
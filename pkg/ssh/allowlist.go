@@ -0,0 +1,231 @@
+package ssh
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Allowlist holds the reviewed commands, written files and downloaded files.
+// Entries match the whole subject literally except for the placeholders, see
+// placeholders, which match a single value without shell metacharacters.
+type Allowlist struct {
+	Commands []string `json:"commands"`
+	Writes   []string `json:"writes"`
+	Reads    []string `json:"reads"`
+}
+
+// Kinds of remote operations an allowlist covers
+const (
+	opCommand = "command"
+	opWrite   = "write"
+	opRead    = "read"
+)
+
+// placeholders are what an entry may use for the parts of a subject that
+// vary, and the values they match. None of them matches whitespace, quotes
+// or shell metacharacters, so an entry cannot admit a command with another
+// one appended.
+var placeholders = map[string]string{
+	"{ip}":        `(\d{1,3}(\.\d{1,3}){3}|[0-9a-fA-F]{0,4}(:[0-9a-fA-F]{0,4}){2,7})`,
+	"{version}":   `v?\d+(\.\d+){0,3}([-+][0-9A-Za-z.]+)?`,
+	"{path}":      `[A-Za-z0-9._/-]+`,
+	"{token}":     `[A-Za-z0-9._-]+`,
+	"{timestamp}": `\d{8}-\d{6}`,
+}
+
+// placeholder matches the placeholders in an entry
+var placeholder = regexp.MustCompile(`\{(ip|version|path|token|timestamp)\}`)
+
+// variables replace the values that change between runs and hosts with
+// their placeholder when recording: IPv4 addresses, kubeadm tokens, hashes
+// and certificate keys, and timestamps
+var variables = []struct {
+	pattern     *regexp.Regexp
+	placeholder string
+}{
+	{regexp.MustCompile(`\b\d{1,3}(\.\d{1,3}){3}\b`), "{ip}"},
+	{regexp.MustCompile(`\b([a-z0-9]{6}\.[a-z0-9]{16}|[0-9a-f]{32,})\b`), "{token}"},
+	{regexp.MustCompile(`\b\d{8}-\d{6}\b`), "{timestamp}"},
+}
+
+// compiled caches the expressions of the entries, by entry
+var compiled sync.Map
+
+// recordMu serializes the clients recording into the same file
+var recordMu sync.Mutex
+
+// Checksum returns the hex encoded SHA-256 of an allowlist file
+func Checksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// LoadAllowlist reads file after checking it against the pinned checksum, so
+// the list cannot change without the configuration changing too
+func LoadAllowlist(file, checksum string) (*Allowlist, error) {
+	if checksum == "" {
+		return nil, fmt.Errorf("allowlist %s has no sha256 pinned in the configuration", file)
+	}
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read allowlist: %v", err)
+	}
+	if sum := Checksum(data); !strings.EqualFold(sum, checksum) {
+		return nil, fmt.Errorf("allowlist %s has checksum %s, the configuration pins %s", file, sum, checksum)
+	}
+
+	var a Allowlist
+	if err := json.Unmarshal(data, &a); err != nil {
+		return nil, fmt.Errorf("failed to parse allowlist: %v", err)
+	}
+	return &a, nil
+}
+
+// Allows reports whether the operation of kind on subject is on the list
+func (a *Allowlist) Allows(kind, subject string) bool {
+	for _, entry := range a.entries(kind) {
+		if matchEntry(entry, subject) {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *Allowlist) entries(kind string) []string {
+	switch kind {
+	case opWrite:
+		return a.Writes
+	case opRead:
+		return a.Reads
+	}
+	return a.Commands
+}
+
+// add appends entry to the entries of kind unless it is listed already
+func (a *Allowlist) add(kind, entry string) bool {
+	for _, listed := range a.entries(kind) {
+		if listed == entry {
+			return false
+		}
+	}
+	switch kind {
+	case opWrite:
+		a.Writes = append(a.Writes, entry)
+		sort.Strings(a.Writes)
+	case opRead:
+		a.Reads = append(a.Reads, entry)
+		sort.Strings(a.Reads)
+	default:
+		a.Commands = append(a.Commands, entry)
+		sort.Strings(a.Commands)
+	}
+	return true
+}
+
+// matchEntry reports whether entry matches all of subject
+func matchEntry(entry, subject string) bool {
+	if !placeholder.MatchString(entry) {
+		return entry == subject
+	}
+	re, ok := compiled.Load(entry)
+	if !ok {
+		re, _ = compiled.LoadOrStore(entry, compileEntry(entry))
+	}
+	return re.(*regexp.Regexp).MatchString(subject)
+}
+
+// compileEntry returns the anchored expression of an entry: its text
+// literally, its placeholders as the values they stand for
+func compileEntry(entry string) *regexp.Regexp {
+	var b strings.Builder
+	b.WriteString(`\A`)
+	last := 0
+	for _, loc := range placeholder.FindAllStringIndex(entry, -1) {
+		b.WriteString(regexp.QuoteMeta(entry[last:loc[0]]))
+		b.WriteString("(?:" + placeholders[entry[loc[0]:loc[1]]] + ")")
+		last = loc[1]
+	}
+	b.WriteString(regexp.QuoteMeta(entry[last:]))
+	b.WriteString(`\z`)
+	return regexp.MustCompile(b.String())
+}
+
+// Generalize replaces the values that differ between runs with their
+// placeholders
+func Generalize(subject string) string {
+	for _, v := range variables {
+		subject = v.pattern.ReplaceAllString(subject, v.placeholder)
+	}
+	return subject
+}
+
+// ReadAllowlist reads an allowlist being recorded, without checking its
+// checksum. A missing file is an empty allowlist.
+func ReadAllowlist(file string) (*Allowlist, error) {
+	var a Allowlist
+	data, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return &a, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &a); err != nil {
+		return nil, fmt.Errorf("failed to parse allowlist: %v", err)
+	}
+	return &a, nil
+}
+
+// Save writes the allowlist to file
+func (a *Allowlist) Save(file string) error {
+	data, err := json.MarshalIndent(a, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(file, append(data, '\n'), 0600)
+}
+
+// recordOp adds the generalized operation of kind on subject and reports
+// whether the allowlist changed. Entries reviewed by hand may cover more than
+// the generalized subject, nothing is added for operations they allow.
+func (a *Allowlist) recordOp(kind, subject string) bool {
+	return !a.Allows(kind, subject) && a.add(kind, Generalize(subject))
+}
+
+// record adds the generalized operation to the allowlist in file
+func record(file, kind, subject string) error {
+	recordMu.Lock()
+	defer recordMu.Unlock()
+
+	a, err := ReadAllowlist(file)
+	if err != nil {
+		return err
+	}
+	if !a.recordOp(kind, subject) {
+		return nil
+	}
+	return a.Save(file)
+}
+
+// permit records the operation, or refuses it when an allowlist is
+// enforced and does not list it
+func (c *Client) permit(kind, subject string) error {
+	if c.recordFile != "" {
+		if err := record(c.recordFile, kind, subject); err != nil {
+			return fmt.Errorf("failed to record allowlist: %v", err)
+		}
+		return nil
+	}
+	if c.allowlist != nil && !c.allowlist.Allows(kind, subject) {
+		return fmt.Errorf("%s refused, not in the allowlist: %s", kind, subject)
+	}
+	return nil
+}
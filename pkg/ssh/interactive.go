@@ -0,0 +1,68 @@
+package ssh
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// DefaultOTPEnv is the environment variable consulted for one-time passwords
+const DefaultOTPEnv = "K8S_SETUP_OTP"
+
+// stdinMu serialises prompts when several hosts are connecting at once
+var stdinMu sync.Mutex
+
+var stdin = bufio.NewReader(os.Stdin)
+
+// keyboardInteractive answers PAM style challenges. Password prompts are
+// answered with the configured password; any other prompt, such as an OTP,
+// is answered from the otpEnv environment variable or read from STDIN.
+func keyboardInteractive(host, password, otpEnv string, used *string) ssh.AuthMethod {
+	if otpEnv == "" {
+		otpEnv = DefaultOTPEnv
+	}
+
+	return ssh.KeyboardInteractive(func(name, instruction string, questions []string, echos []bool) ([]string, error) {
+		*used = "keyboard-interactive"
+		answers := make([]string, len(questions))
+		for i, question := range questions {
+			if password != "" && strings.Contains(strings.ToLower(question), "password") {
+				answers[i] = password
+				continue
+			}
+			if otp := os.Getenv(otpEnv); otp != "" {
+				answers[i] = otp
+				continue
+			}
+
+			answer, err := prompt(host, instruction, question)
+			if err != nil {
+				return nil, err
+			}
+			answers[i] = answer
+		}
+		return answers, nil
+	})
+}
+
+// prompt asks the operator a question on STDERR and reads the answer from STDIN
+func prompt(host, instruction, question string) (string, error) {
+	stdinMu.Lock()
+	defer stdinMu.Unlock()
+
+	if instruction != "" {
+		fmt.Fprintf(os.Stderr, "[%s] %s\n", host, instruction)
+	}
+	fmt.Fprintf(os.Stderr, "[%s] %s", host, question)
+
+	answer, err := stdin.ReadString('\n')
+	if err != nil && answer == "" {
+		return "", fmt.Errorf("failed to read answer for %q: %v", strings.TrimSpace(question), err)
+	}
+
+	return strings.TrimRight(answer, "\r\n"), nil
+}
@@ -0,0 +1,114 @@
+package ssh
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// sshdDropIn overrides the sshd settings of the distribution and cloud-init,
+// sshd keeps the first value it reads and the drop-ins are read in order
+const sshdDropIn = "/etc/ssh/sshd_config.d/01-k8s-setup.conf"
+
+// GenerateKey writes a new ed25519 key pair to keyFile and keyFile.pub and
+// returns the public key in authorized_keys format
+func GenerateKey(keyFile, comment string) (string, error) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate key: %v", err)
+	}
+	block, err := ssh.MarshalPrivateKey(private, comment)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode key: %v", err)
+	}
+	sshPublic, err := ssh.NewPublicKey(public)
+	if err != nil {
+		return "", err
+	}
+	authorized := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(sshPublic)))
+	if comment != "" {
+		authorized += " " + comment
+	}
+
+	if err := ioutil.WriteFile(keyFile, pem.EncodeToMemory(block), 0600); err != nil {
+		return "", err
+	}
+	if err := ioutil.WriteFile(keyFile+".pub", []byte(authorized+"\n"), 0644); err != nil {
+		return "", err
+	}
+	return authorized, nil
+}
+
+// PublicKey returns the public key of the private key in keyFile in
+// authorized_keys format
+func PublicKey(keyFile string) (string, error) {
+	data, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return "", fmt.Errorf("failed to read key file: %v", err)
+	}
+	signer, err := ssh.ParsePrivateKey(data)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse private key: %v", err)
+	}
+	return strings.TrimSpace(string(ssh.MarshalAuthorizedKey(signer.PublicKey()))), nil
+}
+
+// keyBody returns the type and base64 fields of an authorized_keys line,
+// which identify the key whatever its comment
+func keyBody(authorized string) string {
+	fields := strings.Fields(authorized)
+	if len(fields) < 2 {
+		return authorized
+	}
+	return fields[0] + " " + fields[1]
+}
+
+// authorizedKeys returns the shell expression of the authorized_keys file of
+// user, who may differ from the user sudo runs the commands as
+func authorizedKeys(user string) string {
+	return fmt.Sprintf(`"$(getent passwd %s | cut -d: -f6)/.ssh/authorized_keys"`, user)
+}
+
+// InstallAuthorizedKey adds key to the authorized_keys of user unless it is
+// already there
+func (c *Client) InstallAuthorizedKey(user, key string) error {
+	file := authorizedKeys(user)
+	cmd := fmt.Sprintf(`f=%s && mkdir -p "$(dirname "$f")" && touch "$f" && (grep -qF '%s' "$f" || echo '%s' >> "$f") && chmod 700 "$(dirname "$f")" && chmod 600 "$f" && chown -R %s: "$(dirname "$f")"`,
+		file, keyBody(key), key, user)
+	if output, err := c.ExecuteCommand(cmd); err != nil {
+		return fmt.Errorf("failed to install key: %v\nOutput: %s", err, output)
+	}
+	return nil
+}
+
+// RemoveAuthorizedKey drops key from the authorized_keys of user
+func (c *Client) RemoveAuthorizedKey(user, key string) error {
+	cmd := fmt.Sprintf(`f=%s && grep -vF '%s' "$f" > "$f.k8s-setup" ; cat "$f.k8s-setup" > "$f" && rm -f "$f.k8s-setup"`, authorizedKeys(user), keyBody(key))
+	if output, err := c.ExecuteCommand(cmd); err != nil {
+		return fmt.Errorf("failed to remove key: %v\nOutput: %s", err, output)
+	}
+	return nil
+}
+
+// DisablePasswordAuth turns off password logins in sshd, through a drop-in
+// where the main configuration includes sshd_config.d and in sshd_config
+// otherwise. The configuration is validated with sshd -t before sshd is
+// reloaded, a broken one is rolled back.
+func (c *Client) DisablePasswordAuth() error {
+	cmd := fmt.Sprintf(`cp /etc/ssh/sshd_config /etc/ssh/sshd_config.k8s-setup && `+
+		`if grep -qiE '^\s*Include\s+/etc/ssh/sshd_config\.d/' /etc/ssh/sshd_config; then `+
+		`printf 'PasswordAuthentication no\n' > %[1]s; `+
+		`else sed -i -E 's/^\s*#?\s*PasswordAuthentication\s.*/PasswordAuthentication no/I' /etc/ssh/sshd_config && `+
+		`(grep -qiE '^PasswordAuthentication no' /etc/ssh/sshd_config || echo 'PasswordAuthentication no' >> /etc/ssh/sshd_config); fi && `+
+		`if sshd -t; then rm -f /etc/ssh/sshd_config.k8s-setup; else mv /etc/ssh/sshd_config.k8s-setup /etc/ssh/sshd_config; rm -f %[1]s; exit 1; fi && `+
+		`(systemctl reload ssh 2>/dev/null || systemctl reload sshd)`, sshdDropIn)
+	if output, err := c.ExecuteCommand(cmd); err != nil {
+		return fmt.Errorf("failed to disable password authentication: %v\nOutput: %s", err, output)
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+package ssh
+
+import (
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/maarulav/k8s-setup/pkg/config"
+)
+
+// limiter enforces the per host session, pacing and bandwidth limits
+type limiter struct {
+	sessions  chan struct{}
+	delay     time.Duration
+	bandwidth int
+	maxOutput int
+	outputDir string
+
+	mu   sync.Mutex
+	last time.Time
+}
+
+func newLimiter(limits config.SSHLimits) *limiter {
+	l := &limiter{
+		delay:     time.Duration(limits.CommandDelay) * time.Millisecond,
+		bandwidth: limits.BandwidthKBps * 1024,
+		maxOutput: limits.MaxOutputKB * 1024,
+		outputDir: limits.OutputDir,
+	}
+	if limits.MaxSessions > 0 {
+		l.sessions = make(chan struct{}, limits.MaxSessions)
+	}
+	return l
+}
+
+// acquire blocks until a session slot is free and the command delay has
+// passed since the previous command started
+func (l *limiter) acquire() {
+	if l.sessions != nil {
+		l.sessions <- struct{}{}
+	}
+
+	if l.delay > 0 {
+		l.mu.Lock()
+		if wait := time.Until(l.last.Add(l.delay)); wait > 0 {
+			time.Sleep(wait)
+		}
+		l.last = time.Now()
+		l.mu.Unlock()
+	}
+}
+
+// release frees the session slot taken by acquire
+func (l *limiter) release() {
+	if l.sessions != nil {
+		<-l.sessions
+	}
+}
+
+// capture returns an output buffer honouring the output limit. The spill
+// directory is created on first use.
+func (l *limiter) capture(host string) *capture {
+	if l.outputDir != "" {
+		os.MkdirAll(l.outputDir, 0700)
+	}
+	return &capture{limit: l.maxOutput, spillDir: l.outputDir, prefix: host}
+}
+
+// reader caps the rate data is read from r
+func (l *limiter) reader(r io.Reader) io.Reader {
+	if l.bandwidth == 0 {
+		return r
+	}
+	return &throttledReader{r: r, throttle: newThrottle(l.bandwidth)}
+}
+
+// writer caps the rate data is written to w
+func (l *limiter) writer(w io.Writer) io.Writer {
+	if l.bandwidth == 0 {
+		return w
+	}
+	return &throttledWriter{w: w, throttle: newThrottle(l.bandwidth)}
+}
+
+// throttle sleeps as needed to keep a transfer at rate bytes per second
+type throttle struct {
+	rate  int
+	chunk int
+	start time.Time
+	bytes int64
+}
+
+func newThrottle(rate int) *throttle {
+	// Move data in slices of a tenth of a second for an even rate
+	chunk := rate / 10
+	if chunk < 1024 {
+		chunk = 1024
+	}
+	return &throttle{rate: rate, chunk: chunk, start: time.Now()}
+}
+
+func (t *throttle) wait(n int) {
+	t.bytes += int64(n)
+	due := time.Duration(float64(t.bytes) / float64(t.rate) * float64(time.Second))
+	if ahead := due - time.Since(t.start); ahead > 0 {
+		time.Sleep(ahead)
+	}
+}
+
+type throttledReader struct {
+	r io.Reader
+	*throttle
+}
+
+func (t *throttledReader) Read(p []byte) (int, error) {
+	if len(p) > t.chunk {
+		p = p[:t.chunk]
+	}
+	n, err := t.r.Read(p)
+	t.wait(n)
+	return n, err
+}
+
+type throttledWriter struct {
+	w io.Writer
+	*throttle
+}
+
+func (t *throttledWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		chunk := p
+		if len(chunk) > t.chunk {
+			chunk = chunk[:t.chunk]
+		}
+		n, err := t.w.Write(chunk)
+		written += n
+		t.wait(n)
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
@@ -0,0 +1,101 @@
+package ssh
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// truncated matches the marker capture leaves in truncated output
+var truncated = regexp.MustCompile(`\n\.\.\. \[\d+ bytes truncated, (full output in \S+|not kept)\] \.\.\.\n`)
+
+// Complete passes on the result of a command whose output is parsed, and
+// fails when the output was truncated at limits.maxOutputKB: a parser would
+// otherwise consume a cut-off kubeconfig or JSON listing. Use it as
+// ssh.Complete(client.ExecuteCommand(cmd)).
+func Complete(output string, err error) (string, error) {
+	if err != nil {
+		return output, err
+	}
+	if marker := truncated.FindString(output); marker != "" {
+		return output, fmt.Errorf("output truncated at ssh.limits.maxOutputKB, raise it to parse the output (%s)", strings.TrimSpace(marker))
+	}
+	return output, nil
+}
+
+// capture collects command output. Beyond limit bytes only the head and the
+// tail are kept in memory; with a spill directory the full output goes to a
+// file there instead of being lost.
+type capture struct {
+	limit    int
+	spillDir string
+	prefix   string
+
+	mu    sync.Mutex
+	data  []byte
+	tail  []byte
+	total int
+	spill *os.File
+}
+
+func (c *capture) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.total += len(p)
+	if c.tail == nil {
+		c.data = append(c.data, p...)
+		if c.limit <= 0 || len(c.data) <= c.limit {
+			return len(p), nil
+		}
+
+		// First overflow: the buffered output is still complete
+		if c.spillDir != "" {
+			if file, err := ioutil.TempFile(c.spillDir, c.prefix+"-*.log"); err == nil {
+				file.Write(c.data)
+				c.spill = file
+			}
+		}
+		c.tail = append([]byte{}, c.data[c.limit/2:]...)
+		c.data = c.data[:c.limit/2]
+	} else {
+		if c.spill != nil {
+			c.spill.Write(p)
+		}
+		c.tail = append(c.tail, p...)
+	}
+
+	if keep := c.limit - c.limit/2; len(c.tail) > keep {
+		c.tail = append(c.tail[:0], c.tail[len(c.tail)-keep:]...)
+	}
+	return len(p), nil
+}
+
+// String returns the captured output, with a marker where it was truncated
+func (c *capture) String() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.tail == nil {
+		return string(c.data)
+	}
+
+	where := "not kept"
+	if c.spill != nil {
+		where = "full output in " + c.spill.Name()
+	}
+	dropped := c.total - len(c.data) - len(c.tail)
+	return fmt.Sprintf("%s\n... [%d bytes truncated, %s] ...\n%s", c.data, dropped, where, c.tail)
+}
+
+// Close closes the spill file
+func (c *capture) Close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.spill != nil {
+		c.spill.Close()
+	}
+}
@@ -0,0 +1,71 @@
+package ssh
+
+import (
+	"errors"
+	"net"
+	"os"
+
+	"github.com/maarulav/k8s-setup/pkg/config"
+)
+
+// errDryRun is returned for operations that need a live host or cluster
+var errDryRun = errors.New("a dry run has no host")
+
+// Recorder is an Executor that adds the operations of the setup steps to an
+// allowlist instead of running them, so the allowlist is recorded without
+// touching a host. Commands succeed with empty output, so steps probing the
+// host take their default branch.
+type Recorder struct {
+	ip        string
+	allowlist *Allowlist
+}
+
+// NewRecorder returns a recorder of the host at ip adding to a. Connecting
+// with shell auto probes for bash, so the probe is added too.
+func NewRecorder(ip, shell string, a *Allowlist) *Recorder {
+	r := &Recorder{ip: ip, allowlist: a}
+	if shell == "" || shell == config.ShellAuto {
+		a.recordOp(opCommand, bashProbe)
+	}
+	return r
+}
+
+// ExecuteCommand adds command to the allowlist
+func (r *Recorder) ExecuteCommand(command string) (string, error) {
+	r.allowlist.recordOp(opCommand, command)
+	return "", nil
+}
+
+// WriteFile adds the write of path to the allowlist
+func (r *Recorder) WriteFile(path string, data []byte, mode os.FileMode) error {
+	r.allowlist.recordOp(opWrite, path)
+	return nil
+}
+
+// CheckSystemRequirements adds the commands of the system requirements check
+func (r *Recorder) CheckSystemRequirements() error {
+	for _, cmd := range systemChecks {
+		r.allowlist.recordOp(opCommand, cmd)
+	}
+	return nil
+}
+
+// Host returns the IP of the recorded host
+func (r *Recorder) Host() string {
+	return r.ip
+}
+
+// Kubeconfig fails, a dry run has no cluster
+func (r *Recorder) Kubeconfig() ([]byte, error) {
+	return nil, errDryRun
+}
+
+// Dial fails, a dry run has no cluster
+func (r *Recorder) Dial(network, addr string) (net.Conn, error) {
+	return nil, errDryRun
+}
+
+// Scripted tells the setup steps that the commands do not run
+func (r *Recorder) Scripted() bool {
+	return true
+}
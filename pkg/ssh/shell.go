@@ -0,0 +1,70 @@
+package ssh
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/maarulav/k8s-setup/pkg/config"
+)
+
+// bashProbe finds out whether the host has bash
+const bashProbe = "command -v bash"
+
+// resolveShell returns the shell the commands of the client run with. In
+// auto mode it is bash where the host has it and sh elsewhere, so the
+// commands do not depend on the login shell, e.g. busybox ash or dash on
+// minimal images.
+func (c *Client) resolveShell(shell string) (string, error) {
+	switch shell {
+	case "", config.ShellAuto:
+	case config.ShellBash, config.ShellSh, config.ShellLogin:
+		return shell, nil
+	default:
+		return "", fmt.Errorf("unknown shell %q, expected auto, bash, sh or login", shell)
+	}
+
+	// command -v is POSIX, unlike which. A refused probe is not a missing
+	// bash.
+	if c.allowlist != nil && !c.allowlist.Allows(opCommand, bashProbe) {
+		return "", fmt.Errorf("%s refused, not in the allowlist: %s, list it or set ssh.shell", opCommand, bashProbe)
+	}
+	output, err := c.run(bashProbe)
+	if err == nil && strings.TrimSpace(output) != "" {
+		return config.ShellBash, nil
+	}
+	fmt.Printf("Warning: %s has no bash, running commands with sh\n", c.IP)
+	return config.ShellSh, nil
+}
+
+// Shell returns the shell the commands run with: bash, sh or login
+func (c *Client) Shell() string {
+	return c.shell
+}
+
+// Quote returns s as a single shell word
+func Quote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'"'"'`) + "'"
+}
+
+// wrap returns command as run by the shell of the client, through sudo
+// when configured
+func (c *Client) wrap(command string) string {
+	shell := c.shell
+	if shell == config.ShellLogin {
+		if !c.sudo {
+			return command
+		}
+		// sudo runs no shell of its own
+		shell = config.ShellBash
+	}
+
+	quoted := Quote(command)
+	switch {
+	case !c.sudo:
+		return shell + " -c " + quoted
+	case c.sudoPassword != "":
+		return "sudo -S -p '' " + shell + " -c " + quoted
+	default:
+		return "sudo -n " + shell + " -c " + quoted
+	}
+}
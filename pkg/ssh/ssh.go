@@ -1,24 +1,130 @@
 package ssh
 
 import (
+	"bytes"
+	"context"
 	"fmt"
 	"io/ioutil"
+	"net"
+	"os"
+	"strconv"
+	"strings"
 
+	"github.com/maarulav/k8s-setup/internal/tracing"
 	"github.com/maarulav/k8s-setup/pkg/config"
 	"golang.org/x/crypto/ssh"
 )
 
+// Executor runs commands and writes files on behalf of the setup steps. The
+// SSH client runs them on the host; other implementations may run cluster
+// operations elsewhere.
+type Executor interface {
+	ExecuteCommand(command string) (string, error)
+	WriteFile(path string, data []byte, mode os.FileMode) error
+
+	// Host returns the IP of the cluster the executor operates on
+	Host() string
+
+	// Kubeconfig returns the admin kubeconfig of the cluster
+	Kubeconfig() ([]byte, error)
+
+	// Dial connects to addr as seen from where the cluster operations run
+	Dial(network, addr string) (net.Conn, error)
+}
+
 // Client represents an SSH client
 type Client struct {
 	*ssh.Client
+	IP string
+
+	// AuthMethod is the authentication method the server accepted last
+	AuthMethod string
+
+	sudo         bool
+	sudoPassword string
+
+	// shell runs the commands, see wrap
+	shell string
+
+	limits *limiter
+
+	// traceCtx parents the spans of the commands, see SetTraceContext
+	traceCtx context.Context
+
+	// commandLog receives the commands, see SetCommandLog
+	commandLog func(command string)
+
+	// jumps are the ProxyJump connections the client runs through
+	jumps []*ssh.Client
+
+	// allowlist refuses operations it does not list; recordFile collects
+	// them instead, see config.Allowlist
+	allowlist  *Allowlist
+	recordFile string
 }
 
-// Connect establishes an SSH connection
+// Connect establishes an SSH connection, through the jump hosts of
+// config.ProxyJump when it names any
 func Connect(config config.VMConfig) (*Client, error) {
+	var authMethod string
+	sshConfig, err := clientConfig(config, &authMethod)
+	if err != nil {
+		return nil, err
+	}
+
+	var allowlist *Allowlist
+	var recordFile string
+	if a := config.Allowlist; a.Record {
+		recordFile = a.File
+	} else if a.Enabled() {
+		if allowlist, err = LoadAllowlist(a.File, a.SHA256); err != nil {
+			return nil, err
+		}
+	}
+
+	addr := net.JoinHostPort(config.IP, strconv.Itoa(config.Port))
+	var jumps []*ssh.Client
+	if config.ProxyJump != "" {
+		if jumps, err = dialJumpHosts(config); err != nil {
+			return nil, err
+		}
+	}
+
+	client, err := dial(jumps, addr, sshConfig)
+	if err != nil {
+		closeAll(jumps)
+		return nil, fmt.Errorf("failed to dial: %v", err)
+	}
+
+	c := &Client{
+		Client:       client,
+		IP:           config.IP,
+		AuthMethod:   authMethod,
+		sudo:         config.Sudo,
+		sudoPassword: config.SudoPassword,
+		limits:       newLimiter(config.Limits),
+		jumps:        jumps,
+		allowlist:    allowlist,
+		recordFile:   recordFile,
+	}
+	if c.shell, err = c.resolveShell(config.Shell); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// clientConfig returns the SSH client configuration authenticating with
+// the password, key or certificate of config. The accepted method is stored
+// in authMethod.
+func clientConfig(config config.VMConfig, authMethod *string) (*ssh.ClientConfig, error) {
 	sshConfig := &ssh.ClientConfig{
 		User: config.Username,
 		Auth: []ssh.AuthMethod{
-			ssh.Password(config.Password),
+			ssh.PasswordCallback(func() (string, error) {
+				*authMethod = "password"
+				return config.Password, nil
+			}),
 		},
 		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
 		Timeout:         config.Timeout,
@@ -35,21 +141,221 @@ func Connect(config config.VMConfig) (*Client, error) {
 			return nil, fmt.Errorf("failed to parse private key: %v", err)
 		}
 
+		// Like OpenSSH, pick up <key>-cert.pub when no certificate is configured
+		certFile := config.CertFile
+		if certFile == "" {
+			if _, err := os.Stat(config.KeyFile + "-cert.pub"); err == nil {
+				certFile = config.KeyFile + "-cert.pub"
+			}
+		}
+
+		keyMethod := "publickey"
+		if certFile != "" {
+			signer, err = certSigner(certFile, signer)
+			if err != nil {
+				return nil, err
+			}
+			keyMethod = "certificate"
+		}
+
 		sshConfig.Auth = []ssh.AuthMethod{
-			ssh.PublicKeys(signer),
+			ssh.PublicKeysCallback(func() ([]ssh.Signer, error) {
+				*authMethod = keyMethod
+				return []ssh.Signer{signer}, nil
+			}),
 		}
 	}
 
-	client, err := ssh.Dial("tcp", config.IP+":22", sshConfig)
+	// Keyboard-interactive runs after the key when the server asks for a second factor
+	if config.KeyboardInteractive {
+		sshConfig.Auth = append(sshConfig.Auth, keyboardInteractive(config.IP, config.Password, config.OTPEnv, authMethod))
+	}
+
+	return sshConfig, nil
+}
+
+// dial connects to addr directly or through the last of the jump hosts
+func dial(jumps []*ssh.Client, addr string, sshConfig *ssh.ClientConfig) (*ssh.Client, error) {
+	if len(jumps) == 0 {
+		return ssh.Dial("tcp", addr, sshConfig)
+	}
+
+	conn, err := jumps[len(jumps)-1].Dial("tcp", addr)
 	if err != nil {
-		return nil, fmt.Errorf("failed to dial: %v", err)
+		return nil, err
+	}
+	c, chans, reqs, err := ssh.NewClientConn(conn, addr, sshConfig)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return ssh.NewClient(c, chans, reqs), nil
+}
+
+// dialJumpHosts connects to the [user@]host[:port] jump hosts of
+// config.ProxyJump in order, each through the previous one. Jump hosts that
+// are aliases take their settings from the OpenSSH client configuration and
+// otherwise use the credentials of the target.
+func dialJumpHosts(config config.VMConfig) ([]*ssh.Client, error) {
+	clientConfigFile, err := LoadClientConfig(config.ClientConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read SSH client configuration: %v", err)
 	}
 
-	return &Client{client}, nil
+	var jumps []*ssh.Client
+	for _, hop := range strings.Split(config.ProxyJump, ",") {
+		jump := config
+		jump.Port = 22
+		host := strings.TrimSpace(hop)
+		if i := strings.LastIndex(host, "@"); i >= 0 {
+			jump.Username, host = host[:i], host[i+1:]
+		}
+		if h, port, err := net.SplitHostPort(host); err == nil {
+			host = h
+			jump.Port, _ = strconv.Atoi(port)
+		}
+
+		settings := clientConfigFile.Lookup(host)
+		jump.IP = host
+		if settings.HostName != "" {
+			jump.IP = settings.HostName
+		}
+		if settings.User != "" && !strings.Contains(hop, "@") {
+			jump.Username = settings.User
+		}
+		if settings.Port != 0 && !strings.Contains(hop, ":") {
+			jump.Port = settings.Port
+		}
+		if settings.IdentityFile != "" {
+			jump.KeyFile, jump.CertFile = settings.IdentityFile, ""
+		}
+
+		var ignored string
+		sshConfig, err := clientConfig(jump, &ignored)
+		if err == nil {
+			var client *ssh.Client
+			if client, err = dial(jumps, net.JoinHostPort(jump.IP, strconv.Itoa(jump.Port)), sshConfig); err == nil {
+				jumps = append(jumps, client)
+				continue
+			}
+		}
+		closeAll(jumps)
+		return nil, fmt.Errorf("failed to connect to jump host %s: %v", hop, err)
+	}
+	return jumps, nil
+}
+
+// closeAll closes the clients in reverse order
+func closeAll(clients []*ssh.Client) {
+	for i := len(clients) - 1; i >= 0; i-- {
+		clients[i].Close()
+	}
+}
+
+// Close closes the connection and the jump host connections it runs through
+func (c *Client) Close() error {
+	err := c.Client.Close()
+	closeAll(c.jumps)
+	return err
+}
+
+// certSigner wraps signer so that it authenticates with the OpenSSH
+// certificate stored in certFile
+func certSigner(certFile string, signer ssh.Signer) (ssh.Signer, error) {
+	data, err := ioutil.ReadFile(certFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read certificate file: %v", err)
+	}
+
+	pub, _, _, _, err := ssh.ParseAuthorizedKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse certificate: %v", err)
+	}
+
+	cert, ok := pub.(*ssh.Certificate)
+	if !ok {
+		return nil, fmt.Errorf("%s is not an SSH certificate", certFile)
+	}
+
+	certSigner, err := ssh.NewCertSigner(cert, signer)
+	if err != nil {
+		return nil, fmt.Errorf("certificate does not match private key: %v", err)
+	}
+
+	return certSigner, nil
+}
+
+// Host returns the IP of the remote server
+func (c *Client) Host() string {
+	return c.IP
+}
+
+// Kubeconfig returns the kubeconfig kubectl uses on the remote server
+func (c *Client) Kubeconfig() ([]byte, error) {
+	output, err := Complete(c.ExecuteCommand("cat $HOME/.kube/config"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read kubeconfig: %v", err)
+	}
+	return []byte(output), nil
+}
+
+// SetTraceContext makes the span in ctx, usually the current step, the
+// parent of the spans of the following commands
+func (c *Client) SetTraceContext(ctx context.Context) {
+	c.traceCtx = ctx
+}
+
+// SetCommandLog passes every following command to log before it runs
+func (c *Client) SetCommandLog(log func(command string)) {
+	c.commandLog = log
 }
 
 // ExecuteCommand executes a command on the remote server
 func (c *Client) ExecuteCommand(command string) (string, error) {
+	if err := c.permit(opCommand, command); err != nil {
+		return "", err
+	}
+	if c.commandLog != nil {
+		c.commandLog(command)
+	}
+	span := tracing.Command(c.traceCtx, c.IP, command)
+	output, err := c.execute(command)
+	tracing.End(span, err)
+	return output, err
+}
+
+func (c *Client) execute(command string) (string, error) {
+	c.limits.acquire()
+	defer c.limits.release()
+
+	session, err := c.NewSession()
+	if err != nil {
+		return "", err
+	}
+	defer session.Close()
+
+	command = c.wrap(command)
+	if c.sudo && c.sudoPassword != "" {
+		session.Stdin = strings.NewReader(c.sudoPassword + "\n")
+	}
+
+	output := c.limits.capture(c.IP)
+	defer output.Close()
+	session.Stdout = output
+	session.Stderr = output
+	if err := session.Run(command); err != nil {
+		return output.String(), fmt.Errorf("command failed: %v", err)
+	}
+
+	return output.String(), nil
+}
+
+// run runs command in the login shell, as the login user. Like every
+// command it has to be on the allowlist.
+func (c *Client) run(command string) (string, error) {
+	if err := c.permit(opCommand, command); err != nil {
+		return "", err
+	}
 	session, err := c.NewSession()
 	if err != nil {
 		return "", err
@@ -57,24 +363,109 @@ func (c *Client) ExecuteCommand(command string) (string, error) {
 	defer session.Close()
 
 	output, err := session.CombinedOutput(command)
+	return string(output), err
+}
+
+// WriteFile writes data to a file on the remote server. With sudo the data is
+// first uploaded to a private temporary file of the login user, created by
+// mktemp so its name cannot be predicted.
+func (c *Client) WriteFile(path string, data []byte, mode os.FileMode) error {
+	if err := c.permit(opWrite, path); err != nil {
+		return err
+	}
+
+	if !c.sudo {
+		// The umask keeps the file private until chmod sets its mode
+		cmd := fmt.Sprintf("umask 077 && cat > %s && chmod %o %s", Quote(path), mode.Perm(), Quote(path))
+		if _, err := c.upload(cmd, data); err != nil {
+			return fmt.Errorf("failed to write %s: %v", path, err)
+		}
+		return nil
+	}
+
+	output, err := c.upload(`umask 077 && tmp=$(mktemp /tmp/k8s-setup-upload-XXXXXXXXXX) && cat > "$tmp" && echo "$tmp"`, data)
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %v", path, err)
+	}
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	tmp := strings.TrimSpace(lines[len(lines)-1])
+	if !strings.HasPrefix(tmp, "/tmp/k8s-setup-upload-") {
+		return fmt.Errorf("failed to write %s: unexpected temporary file %q", path, tmp)
+	}
+
+	cmd := fmt.Sprintf("install -m %o %s %s; rc=$?; rm -f %s; exit $rc", mode.Perm(), tmp, Quote(path), tmp)
+	if output, err := c.execute(cmd); err != nil {
+		return fmt.Errorf("failed to write %s: %v\nOutput: %s", path, err, output)
+	}
+	return nil
+}
+
+// upload runs cmd over a single session with data streamed to its input
+func (c *Client) upload(cmd string, data []byte) (string, error) {
+	c.limits.acquire()
+	defer c.limits.release()
+
+	session, err := c.NewSession()
 	if err != nil {
-		return string(output), fmt.Errorf("command failed: %v", err)
+		return "", err
 	}
+	defer session.Close()
 
+	session.Stdin = c.limits.reader(bytes.NewReader(data))
+	output, err := session.CombinedOutput(cmd)
+	if err != nil {
+		return string(output), fmt.Errorf("%v\nOutput: %s", err, output)
+	}
 	return string(output), nil
 }
 
-// CheckSystemRequirements checks if the system meets the requirements
-func (c *Client) CheckSystemRequirements() error {
-	commands := []string{
-		"uname -a",
-		"free -h",
-		"df -h",
-		"nproc",
-		"cat /etc/os-release",
+// Download copies a remote file to localPath
+func (c *Client) Download(remotePath, localPath string) error {
+	if err := c.permit(opRead, remotePath); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(localPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %v", localPath, err)
+	}
+	defer file.Close()
+
+	c.limits.acquire()
+	defer c.limits.release()
+
+	session, err := c.NewSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	command := c.wrap("cat " + remotePath)
+	if c.sudo && c.sudoPassword != "" {
+		session.Stdin = strings.NewReader(c.sudoPassword + "\n")
 	}
 
-	for _, cmd := range commands {
+	var stderr bytes.Buffer
+	session.Stdout = c.limits.writer(file)
+	session.Stderr = &stderr
+	if err := session.Run(command); err != nil {
+		return fmt.Errorf("failed to download %s: %v\nOutput: %s", remotePath, err, stderr.String())
+	}
+
+	return nil
+}
+
+// systemChecks are the commands CheckSystemRequirements runs
+var systemChecks = []string{
+	"uname -a",
+	"free -h",
+	"df -h",
+	"nproc",
+	"cat /etc/os-release",
+}
+
+// CheckSystemRequirements checks if the system meets the requirements
+func (c *Client) CheckSystemRequirements() error {
+	for _, cmd := range systemChecks {
 		output, err := c.ExecuteCommand(cmd)
 		if err != nil {
 			return fmt.Errorf("system check failed: %v", err)
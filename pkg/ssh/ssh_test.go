@@ -0,0 +1,411 @@
+package ssh
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/maarulav/k8s-setup/internal/testutil"
+	"github.com/maarulav/k8s-setup/pkg/config"
+)
+
+func connect(t *testing.T, server *testutil.SSHServer, sudo bool, sudoPassword string) *Client {
+	t.Helper()
+	cfg := server.VMConfig()
+	cfg.Sudo = sudo
+	cfg.SudoPassword = sudoPassword
+
+	client, err := Connect(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func TestExecuteCommand(t *testing.T) {
+	server := testutil.NewSSHServer(t).
+		Respond("hostname", "node-1\n").
+		Fail("false", "boom")
+	client := connect(t, server, false, "")
+
+	if client.AuthMethod != "password" {
+		t.Errorf("auth method %q, want password", client.AuthMethod)
+	}
+
+	output, err := client.ExecuteCommand("hostname")
+	if err != nil || output != "node-1\n" {
+		t.Errorf("got %q, %v", output, err)
+	}
+
+	output, err = client.ExecuteCommand("false")
+	if err == nil || output != "boom" {
+		t.Errorf("expected a failure with output, got %q, %v", output, err)
+	}
+}
+
+func TestSudoCommands(t *testing.T) {
+	server := testutil.NewSSHServer(t)
+
+	connect(t, server, true, "").ExecuteCommand("echo 'it works'")
+	connect(t, server, true, "secret").ExecuteCommand("id -u")
+
+	execs := server.Execs()
+	if want := `sudo -n bash -c 'echo '"'"'it works'"'"''`; execs[0].Command != want {
+		t.Errorf("got %s, want %s", execs[0].Command, want)
+	}
+	if want := "sudo -S -p '' bash -c 'id -u'"; execs[1].Command != want {
+		t.Errorf("got %s, want %s", execs[1].Command, want)
+	}
+	if string(execs[1].Stdin) != "secret\n" {
+		t.Errorf("sudo password not sent on stdin, got %q", execs[1].Stdin)
+	}
+}
+
+func TestShell(t *testing.T) {
+	for _, tc := range []struct {
+		name, shell, bash string
+		sudo              bool
+		want              string
+	}{
+		{"bash detected", config.ShellAuto, "/usr/bin/bash\n", false, `bash -c 'echo $(id -u)'`},
+		{"no bash", "", "", false, `sh -c 'echo $(id -u)'`},
+		{"no bash with sudo", "", "", true, `sudo -n sh -c 'echo $(id -u)'`},
+		{"forced sh", config.ShellSh, "/usr/bin/bash\n", false, `sh -c 'echo $(id -u)'`},
+	} {
+		server := testutil.NewSSHServer(t).Respond("command -v bash", tc.bash)
+		cfg := server.VMConfig()
+		cfg.Shell = tc.shell
+		cfg.Sudo = tc.sudo
+		client, err := Connect(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer client.Close()
+
+		client.ExecuteCommand("echo $(id -u)")
+		commands := server.Commands()
+		if last := commands[len(commands)-1]; last != tc.want {
+			t.Errorf("%s: got %s, want %s", tc.name, last, tc.want)
+		}
+		if detected := len(commands) == 2; detected != (tc.shell != config.ShellSh) {
+			t.Errorf("%s: unexpected commands %v", tc.name, commands)
+		}
+	}
+
+	cfg := testutil.NewSSHServer(t).VMConfig()
+	cfg.Shell = "fish"
+	if _, err := Connect(cfg); err == nil {
+		t.Error("expected an unknown shell to be refused")
+	}
+}
+
+func TestWriteFile(t *testing.T) {
+	server := testutil.NewSSHServer(t)
+	if err := connect(t, server, false, "").WriteFile("/root/file.json", []byte("{}"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	execs := server.Execs()
+	if len(execs) != 1 || execs[0].Command != "umask 077 && cat > '/root/file.json' && chmod 600 '/root/file.json'" {
+		t.Fatalf("unexpected commands %v", server.Commands())
+	}
+	if string(execs[0].Stdin) != "{}" {
+		t.Errorf("uploaded %q", execs[0].Stdin)
+	}
+}
+
+func TestWriteFileWithSudo(t *testing.T) {
+	server := testutil.NewSSHServer(t).Respond("mktemp", "/tmp/k8s-setup-upload-Xa9fK2mQ7z\n")
+	if err := connect(t, server, true, "").WriteFile("/etc/app.conf", []byte("x=1"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	execs := server.Execs()
+	if len(execs) != 2 {
+		t.Fatalf("expected an upload and an install, got %v", server.Commands())
+	}
+	if !strings.HasPrefix(execs[0].Command, "umask 077 && tmp=$(mktemp /tmp/k8s-setup-upload-XXXXXXXXXX) && cat > ") {
+		t.Errorf("upload does not go through a private temporary file: %s", execs[0].Command)
+	}
+	if string(execs[0].Stdin) != "x=1" {
+		t.Errorf("uploaded %q", execs[0].Stdin)
+	}
+	// The quotes of the path are escaped for bash -c
+	if !strings.Contains(execs[1].Command, `install -m 644 /tmp/k8s-setup-upload-Xa9fK2mQ7z '"'"'/etc/app.conf'"'"'; rc=$?; rm -f /tmp/k8s-setup-upload-Xa9fK2mQ7z`) {
+		t.Errorf("unexpected install command: %s", execs[1].Command)
+	}
+}
+
+func TestWriteFileWithSudoUnexpectedTemp(t *testing.T) {
+	server := testutil.NewSSHServer(t).Respond("mktemp", "/etc/shadow\n")
+	if err := connect(t, server, true, "").WriteFile("/etc/app.conf", []byte("x=1"), 0644); err == nil {
+		t.Fatal("expected an unexpected temporary file to be refused")
+	}
+	if commands := server.Commands(); len(commands) != 1 {
+		t.Errorf("expected no install, got %v", commands)
+	}
+}
+
+func TestLimits(t *testing.T) {
+	server := testutil.NewSSHServer(t)
+	cfg := server.VMConfig()
+	cfg.Limits.CommandDelay = 50
+	cfg.Limits.BandwidthKBps = 10
+
+	client, err := Connect(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		client.ExecuteCommand("true")
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("three commands took %v, expected the delay between them", elapsed)
+	}
+
+	// 5 KiB at 10 KiB/s takes about half a second
+	start = time.Now()
+	if err := client.WriteFile("/tmp/data", make([]byte, 5*1024), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond {
+		t.Errorf("upload took %v, expected the bandwidth cap to slow it down", elapsed)
+	}
+}
+
+func TestOutputLimit(t *testing.T) {
+	big := strings.Repeat("a", 1024) + strings.Repeat("b", 2048) + strings.Repeat("c", 1024)
+	server := testutil.NewSSHServer(t).Respond("get all", big)
+	cfg := server.VMConfig()
+	cfg.Limits.MaxOutputKB = 1
+	cfg.Limits.OutputDir = t.TempDir()
+
+	client, err := Connect(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	output, err := client.ExecuteCommand("kubectl get all -A -o yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(output, strings.Repeat("a", 512)+"\n... [3072 bytes truncated, full output in ") {
+		t.Errorf("head not kept: %.600s", output)
+	}
+	if !strings.HasSuffix(output, "] ...\n"+strings.Repeat("c", 512)) {
+		t.Errorf("tail not kept")
+	}
+
+	// Output that is parsed must be complete
+	if _, err := Complete(output, nil); err == nil || !strings.Contains(err.Error(), "maxOutputKB") {
+		t.Errorf("expected truncated output to fail, got %v", err)
+	}
+	if _, err := Complete(client.ExecuteCommand("true")); err != nil {
+		t.Errorf("complete output failed: %v", err)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(cfg.Limits.OutputDir, "*.log"))
+	if len(files) != 1 {
+		t.Fatalf("expected one spill file, found %v", files)
+	}
+	if data, _ := ioutil.ReadFile(files[0]); string(data) != big {
+		t.Errorf("spill file holds %d bytes, want the full %d", len(data), len(big))
+	}
+}
+
+func TestClientConfigLookup(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "config")
+	err := ioutil.WriteFile(file, []byte(`
+# Defaults go last in OpenSSH configurations
+Host bastion
+    HostName bastion.example.com
+    User jump
+
+Host k8s-* !k8s-legacy
+    User ubuntu
+    Port 2222
+    ProxyJump bastion
+
+Host k8s-cp1
+    HostName 10.0.0.1
+    Port 22
+
+Host k8s-legacy
+    HostName=10.0.0.9
+
+Match host k8s-cp1
+    User ignored
+
+Host *
+    User root
+    IdentityFile ~/.ssh/id_ed25519
+`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := LoadClientConfig(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := c.Lookup("k8s-cp1")
+	if got.HostName != "10.0.0.1" || got.User != "ubuntu" || got.Port != 2222 || got.ProxyJump != "bastion" {
+		t.Errorf("k8s-cp1: %+v", got)
+	}
+	if !strings.HasSuffix(got.IdentityFile, "/.ssh/id_ed25519") || strings.HasPrefix(got.IdentityFile, "~") {
+		t.Errorf("identity file %q not expanded", got.IdentityFile)
+	}
+
+	got = c.Lookup("k8s-legacy")
+	if got.HostName != "10.0.0.9" || got.User != "root" || got.ProxyJump != "" {
+		t.Errorf("k8s-legacy: %+v", got)
+	}
+
+	if !c.HasAlias("k8s-cp1") || c.HasAlias("k8s-worker") || c.HasAlias("10.0.0.1") {
+		t.Errorf("wildcards and host names must not count as aliases")
+	}
+}
+
+func TestAllowlist(t *testing.T) {
+	server := testutil.NewSSHServer(t)
+	file := filepath.Join(t.TempDir(), "allowlist.json")
+
+	cfg := server.VMConfig()
+	cfg.Allowlist = config.Allowlist{File: file, Record: true}
+	recorder, err := Connect(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorder.ExecuteCommand("kubeadm join 10.0.0.1:6443 --token abcdef.0123456789abcdef")
+	recorder.WriteFile("/etc/app.conf", []byte("x=1"), 0644)
+	recorder.Close()
+
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"kubeadm join {ip}:6443 --token {token}"`) {
+		t.Fatalf("variable values not generalized:\n%s", data)
+	}
+
+	cfg.Allowlist = config.Allowlist{File: file, SHA256: "0000"}
+	if _, err := Connect(cfg); err == nil || !strings.Contains(err.Error(), "checksum") {
+		t.Fatalf("expected a checksum mismatch, got %v", err)
+	}
+
+	cfg.Allowlist.SHA256 = Checksum(data)
+	client, err := Connect(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	if _, err := client.ExecuteCommand("kubeadm join 10.0.0.2:6443 --token zyxwvu.9876543210fedcba"); err != nil {
+		t.Errorf("listed command refused: %v", err)
+	}
+	if err := client.WriteFile("/etc/app.conf", []byte("x=2"), 0644); err != nil {
+		t.Errorf("listed write refused: %v", err)
+	}
+	if _, err := client.ExecuteCommand("rm -rf /"); err == nil {
+		t.Error("unlisted command was run")
+	}
+	for _, command := range []string{
+		"kubeadm join 10.0.0.2:6443 --token zyxwvu.9876543210fedcba; rm -rf /",
+		"kubeadm join 10.0.0.2;rm -rf /:6443 --token zyxwvu.9876543210fedcba",
+		"kubeadm join 10.0.0.2:6443 --token $(rm -rf /)",
+		"kubeadm join 10.0.0.2:6443 --token a\nrm -rf /",
+	} {
+		if _, err := client.ExecuteCommand(command); err == nil {
+			t.Errorf("command with another appended was run: %q", command)
+		}
+	}
+	if err := client.WriteFile("/etc/shadow", nil, 0600); err == nil {
+		t.Error("unlisted write was made")
+	}
+	for _, command := range server.Commands() {
+		if strings.Contains(command, "rm -rf") || strings.Contains(command, "shadow") {
+			t.Errorf("refused operation reached the host: %s", command)
+		}
+	}
+}
+
+func TestAllowlistPlaceholders(t *testing.T) {
+	a := &Allowlist{Commands: []string{
+		"apt-get install -y kubelet={version} kubeadm={version}",
+		"cat {path}",
+		"for f in /etc/kubernetes/pki/*.crt; do echo $f; done",
+	}}
+	for subject, want := range map[string]bool{
+		"apt-get install -y kubelet=1.30.4-1.1 kubeadm=1.30.4-1.1":     true,
+		"apt-get install -y kubelet=1.30.4 kubeadm=1.30.4 && rm -rf /": false,
+		"apt-get install -y kubelet=1.30.4|sh kubeadm=1.30.4":          false,
+		"cat /etc/kubernetes/admin.conf":                               true,
+		"cat /etc/kubernetes/admin.conf /etc/shadow":                   false,
+		"cat /etc/x`id`": false,
+		"for f in /etc/kubernetes/pki/*.crt; do echo $f; done":            true,
+		"for f in /etc/kubernetes/pki/x.crt; do echo $f; done":            false,
+		"apt-get install -y kubelet={version} kubeadm={version}":          false,
+		"apt-get install -y kubelet=1.30.4 kubeadm=1.30.4\nreboot":        false,
+		"prefix apt-get install -y kubelet=1.30.4 kubeadm=1.30.4":         false,
+		"apt-get install -y kubelet=1.30.4 kubeadm=1.30.4 --allow-change": false,
+	} {
+		if got := a.Allows(opCommand, subject); got != want {
+			t.Errorf("Allows(%q) = %t, want %t", subject, got, want)
+		}
+	}
+
+	if got, want := Generalize("kubeadm join 10.0.0.1:6443 --token abcdef.0123456789abcdef --discovery-token-ca-cert-hash sha256:"+strings.Repeat("ab", 32)),
+		"kubeadm join {ip}:6443 --token {token} --discovery-token-ca-cert-hash sha256:{token}"; got != want {
+		t.Errorf("Generalize() = %q, want %q", got, want)
+	}
+}
+
+func TestRecorder(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "allowlist.json")
+	a, err := ReadAllowlist(file)
+	if err != nil {
+		t.Fatalf("a missing allowlist: %v", err)
+	}
+
+	r := NewRecorder("10.0.0.1", config.ShellAuto, a)
+	if out, err := r.ExecuteCommand("kubeadm join 10.0.0.1:6443 --token abcdef.0123456789abcdef"); out != "" || err != nil {
+		t.Errorf("recorded command returned %q, %v", out, err)
+	}
+	r.WriteFile("/etc/app.conf", []byte("x=1"), 0644)
+	r.CheckSystemRequirements()
+	if _, err := r.Kubeconfig(); err == nil {
+		t.Error("a dry run returned a kubeconfig")
+	}
+	if err := a.Save(file); err != nil {
+		t.Fatal(err)
+	}
+
+	saved, err := ReadAllowlist(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, command := range append([]string{bashProbe, "kubeadm join 10.0.0.3:6443 --token aaaaaa.0123456789abcdef"}, systemChecks...) {
+		if !saved.Allows(opCommand, command) {
+			t.Errorf("command %q not recorded", command)
+		}
+	}
+	if !saved.Allows(opWrite, "/etc/app.conf") {
+		t.Error("write not recorded")
+	}
+
+	// Commands already covered are not added again, with a set shell the
+	// probe is not run
+	n := len(saved.Commands)
+	NewRecorder("10.0.0.2", "/bin/sh", saved).ExecuteCommand("kubeadm join 10.0.0.2:6443 --token zyxwvu.9876543210fedcba")
+	if len(saved.Commands) != n {
+		t.Errorf("recorded %v again", saved.Commands[n:])
+	}
+}
@@ -0,0 +1,162 @@
+package ssh
+
+import (
+	"bufio"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// DefaultClientConfig is the OpenSSH client configuration read unless the
+// ssh section names another file, or "none" to read none
+const DefaultClientConfig = "~/.ssh/config"
+
+// HostConfig holds the settings of the OpenSSH client configuration that
+// apply to one host. Empty fields are not set by the configuration.
+type HostConfig struct {
+	HostName     string
+	User         string
+	Port         int
+	IdentityFile string
+	ProxyJump    string
+}
+
+// ClientConfig is a parsed OpenSSH client configuration file. Host blocks
+// are supported; Match blocks and Include are ignored.
+type ClientConfig struct {
+	blocks []hostBlock
+}
+
+type hostBlock struct {
+	patterns []string
+	settings [][2]string
+}
+
+// LoadClientConfig parses the OpenSSH client configuration at file, with ~
+// standing for the home directory. A missing file or "none" yields an empty
+// configuration.
+func LoadClientConfig(file string) (*ClientConfig, error) {
+	c := &ClientConfig{}
+	if file == "" {
+		file = DefaultClientConfig
+	}
+	if file == "none" {
+		return c, nil
+	}
+
+	f, err := os.Open(expandHome(file))
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	// Settings before the first Host line apply to every host
+	c.blocks = []hostBlock{{patterns: []string{"*"}}}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		keyword, value := splitDirective(line)
+		switch strings.ToLower(keyword) {
+		case "host":
+			c.blocks = append(c.blocks, hostBlock{patterns: strings.Fields(value)})
+		case "match":
+			// Match criteria are not evaluated, their settings never apply
+			c.blocks = append(c.blocks, hostBlock{})
+		default:
+			block := &c.blocks[len(c.blocks)-1]
+			block.settings = append(block.settings, [2]string{strings.ToLower(keyword), strings.Trim(value, `"`)})
+		}
+	}
+	return c, scanner.Err()
+}
+
+// HasAlias reports whether host is named literally, without wildcards, in a
+// Host line
+func (c *ClientConfig) HasAlias(host string) bool {
+	for _, block := range c.blocks {
+		for _, pattern := range block.patterns {
+			if pattern == host {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Lookup returns the settings for host. As in OpenSSH, the first value
+// obtained for a setting wins.
+func (c *ClientConfig) Lookup(host string) HostConfig {
+	var h HostConfig
+	seen := map[string]bool{}
+	for _, block := range c.blocks {
+		if !matchHost(block.patterns, host) {
+			continue
+		}
+		for _, setting := range block.settings {
+			keyword, value := setting[0], setting[1]
+			if seen[keyword] {
+				continue
+			}
+			seen[keyword] = true
+			switch keyword {
+			case "hostname":
+				h.HostName = strings.ReplaceAll(value, "%h", host)
+			case "user":
+				h.User = value
+			case "port":
+				h.Port, _ = strconv.Atoi(value)
+			case "identityfile":
+				h.IdentityFile = expandHome(strings.ReplaceAll(value, "%d", "~"))
+			case "proxyjump":
+				if value != "none" {
+					h.ProxyJump = value
+				}
+			}
+		}
+	}
+	return h
+}
+
+// matchHost evaluates the patterns of a Host line against host. A negated
+// pattern that matches rules the block out.
+func matchHost(patterns []string, host string) bool {
+	matched := false
+	for _, pattern := range patterns {
+		negated := strings.HasPrefix(pattern, "!")
+		if ok, _ := path.Match(strings.TrimPrefix(pattern, "!"), host); ok {
+			if negated {
+				return false
+			}
+			matched = true
+		}
+	}
+	return matched
+}
+
+// splitDirective splits a "Keyword value" or "Keyword=value" line
+func splitDirective(line string) (string, string) {
+	i := strings.IndexAny(line, " \t=")
+	if i < 0 {
+		return line, ""
+	}
+	return line[:i], strings.TrimSpace(strings.TrimLeft(line[i:], " \t="))
+}
+
+// expandHome replaces a leading ~ with the home directory
+func expandHome(file string) string {
+	if file == "~" || strings.HasPrefix(file, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, strings.TrimPrefix(file, "~"))
+		}
+	}
+	return file
+}
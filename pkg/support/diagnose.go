@@ -0,0 +1,52 @@
+package support
+
+import (
+	"fmt"
+)
+
+// failingPods prints "namespace name" for every pod that is not Running and
+// ready, or Completed
+const failingPods = `kubectl get pods -A --no-headers | awk '{split($3, r, "/"); if (($4 != "Running" && $4 != "Completed") || ($4 == "Running" && r[1] != r[2])) print $1" "$2}'`
+
+// CollectDiagnostics adds system information, runtime and kubelet status,
+// kubeadm output and the state of the cluster to the bundle
+func (b *Bundle) CollectDiagnostics(kubeadmLog string) {
+	system := map[string]string{
+		"system/uname.txt":      "uname -a",
+		"system/os-release.txt": "cat /etc/os-release",
+		"system/uptime.txt":     "uptime",
+		"system/memory.txt":     "free -h",
+		"system/disk.txt":       "df -h",
+		"system/cpu.txt":        "nproc && cat /proc/cpuinfo | grep 'model name' | sort -u",
+		"system/network.txt":    "ip addr && ip route",
+		"system/swap.txt":       "swapon --show",
+		"system/dmesg.txt":      "dmesg | tail -n 500",
+		"system/sysctl.txt":     "sysctl net.ipv4.ip_forward net.bridge.bridge-nf-call-iptables",
+		"system/processes.txt":  "ps aux --sort=-%mem | head -n 50",
+	}
+	for file, cmd := range system {
+		b.Run(file, cmd)
+	}
+
+	for _, unit := range []string{"kubelet", "containerd", "docker"} {
+		b.Run(fmt.Sprintf("services/%s.txt", unit), fmt.Sprintf("systemctl status %s --no-pager -l", unit))
+	}
+	b.Run("services/containers.txt", "crictl ps -a")
+
+	b.Run("kubeadm/kubeadm-init.log", "cat "+kubeadmLog)
+	b.Run("kubeadm/kubeadm-config.yaml", "kubectl -n kube-system get configmap kubeadm-config -o yaml")
+
+	cluster := map[string]string{
+		"kubernetes/nodes.txt":        "kubectl get nodes -o wide",
+		"kubernetes/describe-nodes":   "kubectl describe nodes",
+		"kubernetes/pods.txt":         "kubectl get pods -A -o wide",
+		"kubernetes/events.txt":       "kubectl get events -A --sort-by=.lastTimestamp",
+		"kubernetes/helm.txt":         "kubectl get secrets -A -l owner=helm",
+		"kubernetes/failing-pods.txt": failingPods,
+		"kubernetes/describe-failing-pods.txt": fmt.Sprintf("%s | while read ns name; do echo \"=== $ns/$name\"; kubectl describe pod $name -n $ns; kubectl logs $name -n $ns --all-containers --tail=200; done",
+			failingPods),
+	}
+	for file, cmd := range cluster {
+		b.Run(file, cmd)
+	}
+}
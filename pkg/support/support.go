@@ -0,0 +1,119 @@
+package support
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/maarulav/k8s-setup/pkg/ssh"
+)
+
+// Component describes where the logs of a node component live
+type Component struct {
+	// Unit is the systemd unit read with journalctl
+	Unit string
+	// Pods is a glob below /var/log/pods for static pod logs
+	Pods string
+}
+
+// Components lists the log sources known to the collector
+var Components = map[string]Component{
+	"kubelet":            {Unit: "kubelet"},
+	"containerd":         {Unit: "containerd"},
+	"docker":             {Unit: "docker"},
+	"apiserver":          {Pods: "kube-system_kube-apiserver-*"},
+	"etcd":               {Pods: "kube-system_etcd-*"},
+	"scheduler":          {Pods: "kube-system_kube-scheduler-*"},
+	"controller-manager": {Pods: "kube-system_kube-controller-manager-*"},
+}
+
+// ComponentNames returns the known component names in sorted order
+func ComponentNames() []string {
+	names := make([]string, 0, len(Components))
+	for name := range Components {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Bundle is a working directory on the remote host that files are collected
+// into before being archived and downloaded
+type Bundle struct {
+	client *ssh.Client
+	Dir    string
+}
+
+// NewBundle creates an empty bundle directory on the remote host
+func NewBundle(client *ssh.Client, name string) (*Bundle, error) {
+	b := &Bundle{
+		client: client,
+		Dir:    fmt.Sprintf("/tmp/%s-%s", name, time.Now().Format("20060102-150405")),
+	}
+
+	if _, err := client.ExecuteCommand(fmt.Sprintf("mkdir -p %s", b.Dir)); err != nil {
+		return nil, fmt.Errorf("failed to create bundle directory: %v", err)
+	}
+
+	return b, nil
+}
+
+// Run stores the output of command in file inside the bundle. Failures are
+// recorded in the file rather than aborting the collection.
+func (b *Bundle) Run(file, command string) {
+	b.client.ExecuteCommand(fmt.Sprintf("mkdir -p $(dirname %s/%s) && (%s) > %s/%s 2>&1", b.Dir, file, command, b.Dir, file))
+}
+
+// Write stores data in file inside the bundle
+func (b *Bundle) Write(file string, data []byte) error {
+	if _, err := b.client.ExecuteCommand(fmt.Sprintf("mkdir -p $(dirname %s/%s)", b.Dir, file)); err != nil {
+		return err
+	}
+	return b.client.WriteFile(b.Dir+"/"+file, data, 0600)
+}
+
+// CollectLogs adds the logs of the given components, optionally limited to
+// entries newer than since (any format accepted by journalctl and date)
+func (b *Bundle) CollectLogs(components []string, since string) error {
+	for _, name := range components {
+		component, ok := Components[name]
+		if !ok {
+			return fmt.Errorf("unknown component %q, expected one of %s", name, strings.Join(ComponentNames(), ", "))
+		}
+
+		if component.Unit != "" {
+			cmd := fmt.Sprintf("journalctl -u %s --no-pager", component.Unit)
+			if since != "" {
+				cmd += fmt.Sprintf(" --since '%s'", since)
+			}
+			b.Run(fmt.Sprintf("logs/%s.log", name), cmd)
+		}
+
+		if component.Pods != "" {
+			find := fmt.Sprintf("find /var/log/pods/%s -name '*.log' -type f", component.Pods)
+			if since != "" {
+				find += fmt.Sprintf(" -newermt '%s'", since)
+			}
+			b.Run(fmt.Sprintf("logs/%s.list", name), find)
+			b.client.ExecuteCommand(fmt.Sprintf("mkdir -p %s/logs/%s && for f in $(%s); do cp --parents $f %s/logs/%s/; done",
+				b.Dir, name, find, b.Dir, name))
+		}
+	}
+
+	return nil
+}
+
+// Download archives the bundle on the remote host, copies the archive to
+// localPath and removes the remote files
+func (b *Bundle) Download(localPath string) error {
+	archive := b.Dir + ".tar.gz"
+	cmd := fmt.Sprintf("tar -czf %s -C $(dirname %s) $(basename %s)", archive, b.Dir, b.Dir)
+	if output, err := b.client.ExecuteCommand(cmd); err != nil {
+		return fmt.Errorf("failed to archive bundle: %v\nOutput: %s", err, output)
+	}
+
+	err := b.client.Download(archive, localPath)
+	b.client.ExecuteCommand(fmt.Sprintf("rm -rf %s %s", b.Dir, archive))
+	return err
+}
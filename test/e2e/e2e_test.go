@@ -0,0 +1,185 @@
+//go:build e2e
+
+// Package e2e runs the full provisioning pipeline against a throwaway VM.
+//
+// By default a fresh Ubuntu VM is launched with Multipass and deleted
+// afterwards. Set E2E_HOST (and E2E_SSH_USER, E2E_SSH_KEY) to use an existing
+// disposable host instead. Run with:
+//
+//	go test -tags e2e -timeout 90m ./test/e2e
+package e2e
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/maarulav/k8s-setup/internal/status"
+	"golang.org/x/crypto/ssh"
+)
+
+// defaultVersion is the Kubernetes package version installed unless
+// E2E_K8S_VERSION is set
+const defaultVersion = "1.28.2-00"
+
+func TestPipeline(t *testing.T) {
+	dir := t.TempDir()
+	host, user, key := os.Getenv("E2E_HOST"), os.Getenv("E2E_SSH_USER"), os.Getenv("E2E_SSH_KEY")
+	if host == "" {
+		host, user, key = launchVM(t, dir)
+	}
+	if user == "" {
+		user = "ubuntu"
+	}
+
+	version := os.Getenv("E2E_K8S_VERSION")
+	if version == "" {
+		version = defaultVersion
+	}
+
+	binary := filepath.Join(dir, "k8s-setup")
+	run(t, "", "go", "build", "-o", binary, "../../cmd/k8s-setup")
+
+	configPath := filepath.Join(dir, "config.json")
+	writeJSON(t, configPath, map[string]interface{}{
+		"ssh": map[string]interface{}{
+			"username": user,
+			"keyFile":  key,
+			"timeout":  30,
+			"sudo":     user != "root",
+		},
+		"kubernetes": map[string]interface{}{
+			"version":     version,
+			"podCIDR":     "10.244.0.0/16",
+			"serviceCIDR": "10.96.0.0/12",
+			"cni":         os.Getenv("E2E_CNI"),
+		},
+		"monitoring": map[string]interface{}{
+			"prometheus": map[string]interface{}{"retentionTime": "1d"},
+			"grafana":    map[string]interface{}{"adminPassword": "e2e-admin"},
+		},
+		"namespaces": []map[string]interface{}{{"name": "e2e"}},
+		"resources":  map[string]interface{}{"cpu": "2", "memory": "4Gi"},
+	})
+
+	run(t, dir, binary, "ping", configPath, host)
+	run(t, dir, binary, configPath, host)
+
+	s, err := readStatus(filepath.Join(dir, status.Dir, host+".json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Status != "Completed" {
+		t.Fatalf("pipeline ended with status %q at step %q: %s", s.Status, s.CurrentStep, s.Error)
+	}
+	for _, step := range []string{"kubernetes", "namespaces", "monitoring", "verification"} {
+		if !s.HasCompleted(step) {
+			t.Errorf("step %s did not complete, completed: %v", step, s.CompletedSteps)
+		}
+	}
+
+	run(t, dir, binary, "versions", configPath, host)
+}
+
+// launchVM starts a Multipass VM authorised for a generated key and returns
+// its address, login user and private key path
+func launchVM(t *testing.T, dir string) (string, string, string) {
+	if _, err := exec.LookPath("multipass"); err != nil {
+		t.Skip("set E2E_HOST or install multipass to run the end-to-end tests")
+	}
+
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := ssh.MarshalPrivateKey(priv, "k8s-setup-e2e")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPath := filepath.Join(dir, "id_ed25519")
+	if err := ioutil.WriteFile(keyPath, pem.EncodeToMemory(block), 0600); err != nil {
+		t.Fatal(err)
+	}
+	sshPub, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cloudInit := filepath.Join(dir, "cloud-init.yaml")
+	data := fmt.Sprintf("#cloud-config\nssh_authorized_keys:\n  - %s", ssh.MarshalAuthorizedKey(sshPub))
+	if err := ioutil.WriteFile(cloudInit, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	name := fmt.Sprintf("k8s-setup-e2e-%d", time.Now().Unix())
+	image := os.Getenv("E2E_IMAGE")
+	if image == "" {
+		image = "22.04"
+	}
+	run(t, "", "multipass", "launch", image, "--name", name, "--cpus", "2", "--memory", "4G", "--disk", "20G", "--cloud-init", cloudInit)
+	t.Cleanup(func() {
+		if os.Getenv("E2E_KEEP") == "" {
+			exec.Command("multipass", "delete", "--purge", name).Run()
+		}
+	})
+
+	output := run(t, "", "multipass", "info", name, "--format", "json")
+	var info struct {
+		Info map[string]struct {
+			IPv4 []string `json:"ipv4"`
+		} `json:"info"`
+	}
+	if err := json.Unmarshal([]byte(output), &info); err != nil {
+		t.Fatalf("failed to parse multipass info: %v", err)
+	}
+	if ips := info.Info[name].IPv4; len(ips) > 0 {
+		return ips[0], "ubuntu", keyPath
+	}
+	t.Fatalf("VM %s has no IPv4 address", name)
+	return "", "", ""
+}
+
+// run executes a command in dir, failing the test with its output on error
+func run(t *testing.T, dir, name string, args ...string) string {
+	t.Helper()
+	cmd := exec.Command(name, args...)
+	cmd.Dir = dir
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("%s %s failed: %v\n%s", name, strings.Join(args, " "), err, output)
+	}
+	t.Logf("%s %s\n%s", name, strings.Join(args, " "), output)
+	return string(output)
+}
+
+func writeJSON(t *testing.T, path string, v interface{}) {
+	t.Helper()
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readStatus(path string) (*status.SetupStatus, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("no status file written: %v", err)
+	}
+	var s status.SetupStatus
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}